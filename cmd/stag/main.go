@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -13,17 +13,20 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
+	"github.com/tabular/stag-v2/internal/audit"
 	"github.com/tabular/stag-v2/internal/config"
 	"github.com/tabular/stag-v2/internal/database"
 	"github.com/tabular/stag-v2/internal/metrics"
 	"github.com/tabular/stag-v2/internal/server"
+	"github.com/tabular/stag-v2/internal/server/websocket"
 	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/internal/tracing"
 	"github.com/tabular/stag-v2/pkg/logger"
 )
 
 func main() {
-	// Initialize logger
-	log := logger.New()
+	// Initialize logger; it is configured once the configuration is loaded
+	log := logger.New(logger.Config{})
 	log.Info("Starting STAG v2...")
 
 	// Load configuration
@@ -31,8 +34,12 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Set log level
+	// Set log format and level
+	log = logger.New(cfg.Logging)
 	level, err := logrus.ParseLevel(cfg.LogLevel)
 	if err != nil {
 		log.Warnf("Invalid log level %s, using info", cfg.LogLevel)
@@ -41,22 +48,54 @@ func main() {
 	log.SetLevel(level)
 
 	// Initialize metrics
-	metricsCollector := metrics.New()
+	metricsCollector := metrics.New(cfg.Metrics, log)
+
+	// Export trace spans, or only propagate trace context when disabled
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, server.Version)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
 
-	// Connect to ArangoDB
-	db, err := database.Connect(cfg.Database)
+	// Connect to ArangoDB, retrying while it comes up
+	connectCtx, cancelConnect := context.WithCancel(context.Background())
+	if cfg.Database.ConnectTimeout > 0 {
+		connectCtx, cancelConnect = context.WithTimeout(context.Background(), cfg.Database.ConnectTimeout)
+	}
+	db, err := database.Connect(connectCtx, cfg.Database, log)
+	cancelConnect()
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer db.Close()
 
 	// Run migrations
-	if err := database.Migrate(db); err != nil {
+	if err := database.Migrate(db, cfg.Database.MetadataIndexes, cfg.Database.MigrationTimeout); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
 	// Initialize spatial repository
-	repository := spatial.NewRepository(db, log, metricsCollector)
+	repository := spatial.NewRepository(cfg, db, log, metricsCollector)
+	defer repository.Close()
+
+	// Audit trail of writes, kept apart from the application log
+	auditLog, err := audit.New(cfg.Audit)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer auditLog.Close()
+	repository.SetAuditLog(auditLog)
+
+	// Warm the mesh dedup cache from stored hashes
+	if cfg.Dedup.WarmCache {
+		warmCtx, warmCancel := context.WithTimeout(context.Background(), 60*time.Second)
+		loaded, err := repository.WarmMeshHashCache(warmCtx)
+		warmCancel()
+		if err != nil {
+			log.Warnf("Failed to warm mesh dedup cache: %v", err)
+		} else {
+			log.Infof("Warmed mesh dedup cache with %d hashes", loaded)
+		}
+	}
 
 	// Set Gin mode
 	if cfg.LogLevel == "debug" {
@@ -66,40 +105,68 @@ func main() {
 	}
 
 	// Create server
-	srv := server.New(cfg, repository, log, metricsCollector)
+	// Initialize WebSocket hub
+	wsHub := websocket.NewHub(cfg.WebSocket, repository, log, metricsCollector)
+	go wsHub.Run()
+
+	// Expire idle sessions, keeping those with WebSocket clients connected
+	repository.StartRetention(cfg.Retention, func(sessionID string) bool {
+		return wsHub.GetSessionConnections(sessionID) > 0
+	})
+
+	// Readiness probes fail until startup completes and once shutdown begins,
+	// so load balancers only route traffic to a fully initialized server
+	var ready atomic.Bool
+
+	srv, err := server.New(cfg, db, repository, wsHub, &ready, log, metricsCollector)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
 
 	// Start server
-	httpServer := &http.Server{
-		Addr:         fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port),
-		Handler:      srv,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	httpServer, err := server.NewHTTPServer(cfg.Server, srv)
+	if err != nil {
+		log.Fatalf("Failed to create HTTP server: %v", err)
 	}
 
 	// Start server in goroutine
 	go func() {
-		log.Infof("Server starting on %s", httpServer.Addr)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Infof("Server starting on %s (TLS: %t, HTTP/2: %t)", httpServer.Addr, cfg.Server.TLSEnabled(), cfg.Server.HTTP2)
+		if err := server.ListenAndServe(httpServer, cfg.Server); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
 
+	// Migrations, cache warm-up and the hub are done
+	ready.Store(true)
+	log.Info("Server ready")
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Info("Shutting down server...")
+	ready.Store(false)
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// Tell WebSocket clients we are going away before closing listeners
+	if err := wsHub.Shutdown(ctx); err != nil {
+		log.Warnf("WebSocket hub did not drain cleanly: %v", err)
+	}
+
 	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Flush spans of the last requests
+	if err := shutdownTracing(ctx); err != nil {
+		log.Warnf("Failed to flush trace spans: %v", err)
+	}
+
 	log.Info("Server stopped")
 }
 
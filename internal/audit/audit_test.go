@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+func TestLogger(t *testing.T) {
+	// Disabled auditing records nothing
+	disabled, err := New(config.AuditConfig{Sink: "file"})
+	if err != nil || disabled != nil {
+		t.Fatalf("Expected no logger while disabled, got %v, %v", disabled, err)
+	}
+	if err := disabled.Record(Record{Action: ActionIngest}); err != nil {
+		t.Errorf("Expected a nil logger to ignore records, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	log := NewWriter(&buf)
+	log.Record(Record{Action: ActionIngest, Principal: "alice", SessionID: "s1", AnchorIDs: []string{"a1"}})
+	log.Record(Record{Action: ActionDeleteSession, Principal: "alice", SessionID: "s1", Anchors: 3})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("Expected a line per record, got %q", buf.String())
+	}
+	var record Record
+	if err := json.Unmarshal(lines[1], &record); err != nil || record.Action != ActionDeleteSession || record.Anchors != 3 || record.Time.IsZero() {
+		t.Errorf("Expected the delete recorded with a time, got %+v, %v", record, err)
+	}
+
+	// The file sink appends across restarts
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for i := 0; i < 2; i++ {
+		file, err := New(config.AuditConfig{Enabled: true, Sink: "file", Path: path})
+		if err != nil {
+			t.Fatalf("Failed to open audit file: %v", err)
+		}
+		file.Record(Record{Action: ActionIngest, SessionID: "s1"})
+		file.Close()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.Count(data, []byte("\n")) != 2 {
+		t.Errorf("Expected 2 records in the audit file, got %q, %v", data, err)
+	}
+}
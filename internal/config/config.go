@@ -3,22 +3,75 @@ package config
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/tabular/stag-v2/pkg/logger"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	LogLevel string         `mapstructure:"log_level"`
-	Metrics  MetricsConfig  `mapstructure:"metrics"`
+	Server      ServerConfig      `mapstructure:"server"`
+	Database    DatabaseConfig    `mapstructure:"database"`
+	LogLevel    string            `mapstructure:"log_level"`
+	Logging     logger.Config     `mapstructure:"logging"`
+	Metrics     MetricsConfig     `mapstructure:"metrics"`
+	Dedup       DedupConfig       `mapstructure:"dedup"`
+	Compression CompressionConfig `mapstructure:"compression"`
+	MeshStorage MeshStorageConfig `mapstructure:"mesh_storage"`
+	Auth        AuthConfig        `mapstructure:"auth"`
+	RateLimit   RateLimitConfig   `mapstructure:"rate_limit"`
+	IngestQueue IngestQueueConfig `mapstructure:"ingest_queue"`
+	Topology    TopologyConfig    `mapstructure:"topology"`
+	Validation  ValidationConfig  `mapstructure:"validation"`
+	WebSocket   WebSocketConfig   `mapstructure:"websocket"`
+	Import      ImportConfig      `mapstructure:"import"`
+	Upload      UploadConfig      `mapstructure:"upload"`
+	Query       QueryConfig       `mapstructure:"query"`
+	Idempotency IdempotencyConfig `mapstructure:"idempotency"`
+	Tracing     TracingConfig     `mapstructure:"tracing"`
+	Retention   RetentionConfig   `mapstructure:"retention"`
+	PoseHistory PoseHistoryConfig `mapstructure:"pose_history"`
+	Audit       AuditConfig       `mapstructure:"audit"`
 }
 
 // ServerConfig holds server configuration
 type ServerConfig struct {
-	Host string `mapstructure:"host"`
-	Port string `mapstructure:"port"`
+	Host                      string        `mapstructure:"host"`
+	Port                      string        `mapstructure:"port"`
+	MaxBodyBytes              int64         `mapstructure:"max_body_bytes"`      // Largest request body accepted by write endpoints
+	ReadTimeout               time.Duration `mapstructure:"read_timeout"`        // Time allowed to read a whole request
+	ReadHeaderTimeout         time.Duration `mapstructure:"read_header_timeout"` // Time allowed to read request headers
+	WriteTimeout              time.Duration `mapstructure:"write_timeout"`       // Time allowed to write a response
+	IdleTimeout               time.Duration `mapstructure:"idle_timeout"`        // How long a keep-alive connection waits for its next request
+	MaxHeaderBytes            int           `mapstructure:"max_header_bytes"`    // Largest request header block accepted
+	KeepAlives                bool          `mapstructure:"keep_alives"`         // Reuse HTTP/1.1 connections across requests
+	HTTP2                     bool          `mapstructure:"http2"`               // Serve HTTP/2: via ALPN under TLS, as h2c in cleartext
+	HTTP2MaxConcurrentStreams uint32        `mapstructure:"http2_max_concurrent_streams"`
+	TLSCertFile               string        `mapstructure:"tls_cert_file"` // Serve TLS with this certificate when set
+	TLSKeyFile                string        `mapstructure:"tls_key_file"`
+
+	ResponseCompression ResponseCompressionConfig `mapstructure:"response_compression"`
+	ExportCompression   ExportCompressionConfig   `mapstructure:"export_compression"`
+}
+
+// ResponseCompressionConfig holds gzip/deflate encoding of JSON responses
+type ResponseCompressionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	MinSize int  `mapstructure:"min_size"` // Smaller responses are sent unencoded
+	Level   int  `mapstructure:"level"`    // 1 (fastest) to 9 (smallest)
+}
+
+// ExportCompressionConfig holds gzip/zstd encoding of glTF exports and
+// snapshots, chosen per request
+type ExportCompressionConfig struct {
+	Level int `mapstructure:"level"` // 1 (fastest) to 9 (smallest)
+}
+
+// TLSEnabled reports whether the server terminates TLS itself
+func (c ServerConfig) TLSEnabled() bool {
+	return c.TLSCertFile != ""
 }
 
 // DatabaseConfig holds database configuration
@@ -27,12 +80,342 @@ type DatabaseConfig struct {
 	Database string `mapstructure:"database"`
 	Username string `mapstructure:"username"`
 	Password string `mapstructure:"password"`
+
+	// Coordinators the driver fails over between, replacing URL when set.
+	// Each is probed at the check interval to report those that are down.
+	Endpoints             []string      `mapstructure:"endpoints"`
+	EndpointCheckInterval time.Duration `mapstructure:"endpoint_check_interval"` // 0 disables the probes
+
+	// Startup connection retries with exponential backoff
+	MaxAttempts    int           `mapstructure:"max_attempts"`
+	RetryDelay     time.Duration `mapstructure:"retry_delay"`     // Delay before the first retry, doubled per attempt
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout"` // Overall deadline for connecting
+
+	// Retries of ingest writes that fail transiently, such as on a write conflict
+	WriteRetries    int           `mapstructure:"write_retries"`
+	WriteRetryDelay time.Duration `mapstructure:"write_retry_delay"` // Delay before the first retry, doubled per attempt
+
+	// Events with at least this many anchors upsert them in one query rather
+	// than one per anchor, 0 disables
+	BulkAnchorThreshold int `mapstructure:"bulk_anchor_threshold"`
+
+	// Anchor metadata keys given a persistent index by migrations, for
+	// queries filtering on them. Keys may hold letters, digits, _ and -.
+	MetadataIndexes []string `mapstructure:"metadata_indexes"`
+
+	// Deadline of each data migration, such as a backfill, at startup or
+	// before a snapshot restore, 0 is none
+	MigrationTimeout time.Duration `mapstructure:"migration_timeout"`
+
+	// Limits on a single AQL query, enforced by both the client and ArangoDB
+	QueryTimeout  time.Duration            `mapstructure:"query_timeout"`  // Default for every query, 0 disables
+	QueryTimeouts map[string]time.Duration `mapstructure:"query_timeouts"` // Overrides by endpoint name
+
+	// Limits on all the database work of one HTTP request, answered with 504
+	OperationTimeout  time.Duration            `mapstructure:"operation_timeout"`  // Default for every endpoint, 0 disables
+	OperationTimeouts map[string]time.Duration `mapstructure:"operation_timeouts"` // Overrides by endpoint name, as for QueryTimeouts
+}
+
+// EndpointList returns the ArangoDB endpoints to connect to: Endpoints if
+// set, otherwise the comma-separated endpoints of URL
+func (c DatabaseConfig) EndpointList() []string {
+	endpoints := c.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = strings.Split(c.URL, ",")
+	}
+
+	list := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			list = append(list, endpoint)
+		}
+	}
+	return list
 }
 
 // MetricsConfig holds metrics configuration
 type MetricsConfig struct {
 	Enabled bool   `mapstructure:"enabled"`
 	Path    string `mapstructure:"path"`
+
+	// Optional credential for the metrics endpoints: a bearer token or basic auth
+	BearerToken string `mapstructure:"bearer_token"`
+	Username    string `mapstructure:"username"`
+	Password    string `mapstructure:"password"`
+
+	SessionLabel string        `mapstructure:"session_label"` // "keep", "hash" or "drop" the session_id label
+	SessionTTL   time.Duration `mapstructure:"session_ttl"`   // Idle time after which a session's series are deleted, 0 keeps them
+}
+
+// DedupConfig holds mesh deduplication configuration
+type DedupConfig struct {
+	WarmCache   bool              `mapstructure:"warm_cache"`
+	CacheExpiry time.Duration     `mapstructure:"cache_expiry"`
+	Anchors     AnchorDedupConfig `mapstructure:"anchors"`
+}
+
+// AnchorDedupConfig holds anchor pose deduplication configuration. An
+// ingested anchor whose position is within Epsilon meters, and whose rotation
+// components are each within RotationEpsilon, of the last pose stored for it
+// is neither stored nor broadcast.
+type AnchorDedupConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Epsilon         float64       `mapstructure:"epsilon"`
+	RotationEpsilon float64       `mapstructure:"rotation_epsilon"`
+	TTL             time.Duration `mapstructure:"ttl"`         // Poses of anchors not stored for this long are forgotten, 0 keeps them
+	MaxAnchors      int           `mapstructure:"max_anchors"` // Most poses remembered, least recently stored evicted first, 0 is unlimited
+}
+
+// CompressionConfig holds mesh storage compression configuration
+type CompressionConfig struct {
+	Codec        string `mapstructure:"codec"`         // raw, gzip or zstd
+	DefaultLevel int    `mapstructure:"default_level"` // 0 (raw) to 9 (smallest), for meshes and sessions that set none
+
+	MaxDeltaDepth int `mapstructure:"max_delta_depth"` // Most deltas applied to resolve one mesh, 0 is unlimited
+}
+
+// MeshStorageConfig holds where mesh geometry is stored. Buffers of at least
+// Threshold bytes, as stored after compression, are offloaded to a filesystem
+// or S3 object store, leaving a reference in the mesh document.
+type MeshStorageConfig struct {
+	Backend      string        `mapstructure:"backend"`       // database, filesystem or s3
+	Threshold    int64         `mapstructure:"threshold"`     // Smallest offloaded geometry in bytes
+	FetchTimeout time.Duration `mapstructure:"fetch_timeout"` // Limit on one object read or write, 0 disables
+	Path         string        `mapstructure:"path"`          // Root directory of the filesystem backend
+	Prefix       string        `mapstructure:"prefix"`        // Prepended to object keys
+	S3           S3Config      `mapstructure:"s3"`
+}
+
+// S3Config holds the bucket of the s3 mesh storage backend
+type S3Config struct {
+	Endpoint        string `mapstructure:"endpoint"` // Service URL, such as https://s3.us-east-1.amazonaws.com
+	Bucket          string `mapstructure:"bucket"`
+	Region          string `mapstructure:"region"`
+	AccessKeyID     string `mapstructure:"access_key_id"` // Requests are unsigned when empty
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	PathStyle       bool   `mapstructure:"path_style"` // Address the bucket in the path rather than the host name
+}
+
+// AuthConfig holds API key authentication configuration. Authentication is
+// enforced once any key is configured.
+type AuthConfig struct {
+	ReadKeys  []string  `mapstructure:"read_keys"`  // Keys limited to queries and streaming
+	WriteKeys []string  `mapstructure:"write_keys"` // Keys allowed to ingest as well as read
+	JWT       JWTConfig `mapstructure:"jwt"`
+}
+
+// JWTConfig holds JWT session authorization configuration. Tokens are
+// verified with HS256 when a secret is set, or RS256 with a public key.
+type JWTConfig struct {
+	Secret        string        `mapstructure:"secret"`
+	PublicKeyFile string        `mapstructure:"public_key_file"` // PEM-encoded RSA public key
+	ClockSkew     time.Duration `mapstructure:"clock_skew"`      // Tolerance for exp and nbf checks
+}
+
+// RateLimitConfig holds per-session ingest rate limiting configuration
+type RateLimitConfig struct {
+	RequestsPerSecond float64       `mapstructure:"requests_per_second"` // 0 disables rate limiting
+	Burst             int           `mapstructure:"burst"`
+	IdleTimeout       time.Duration `mapstructure:"idle_timeout"` // Idle session buckets are dropped after this
+}
+
+// IngestQueueConfig bounds how many ingest requests are processed at once,
+// so a burst of large batches cannot open more concurrent ArangoDB
+// operations than it handles
+type IngestQueueConfig struct {
+	Workers  int `mapstructure:"workers"`   // Ingest requests processed at once, 0 is unlimited
+	MaxQueue int `mapstructure:"max_queue"` // Requests waiting for a worker before more are refused with a 503
+}
+
+// TopologyConfig holds anchor topology graph configuration
+type TopologyConfig struct {
+	NeighborDistance float64 `mapstructure:"neighbor_distance"` // Meters; anchors closer than this are linked, 0 disables
+	MaxHops          int     `mapstructure:"max_hops"`          // Upper bound on neighbor traversal depth
+	MaxPathDepth     int     `mapstructure:"max_path_depth"`    // Most edges a shortest path may cross, 0 is unlimited
+}
+
+// ValidationConfig holds ingest validation and normalization configuration
+type ValidationConfig struct {
+	NormalizeRotations bool `mapstructure:"normalize_rotations"` // Normalize non-unit quaternions instead of rejecting them
+	GenerateNormals    bool `mapstructure:"generate_normals"`    // Compute smooth normals for full meshes sent without them
+
+	// Limit mesh compression levels outside 0 to 9 to that range instead of
+	// rejecting them
+	ClampCompressionLevels bool `mapstructure:"clamp_compression_levels"`
+
+	// Largest number of anchors and meshes one ingested event may carry, 0 disables
+	MaxAnchorsPerEvent int `mapstructure:"max_anchors_per_event"`
+	MaxMeshesPerEvent  int `mapstructure:"max_meshes_per_event"`
+
+	// Event timestamps from misconfigured device clocks are rejected: those
+	// further ahead of server time than MaxFutureSkew, and with
+	// MonotonicTimestamps those more than MonotonicTolerance older than their
+	// session's latest accepted event. Requests marked as backfill skip both.
+	MaxFutureSkew       time.Duration `mapstructure:"max_future_skew"` // 0 disables
+	MonotonicTimestamps bool          `mapstructure:"monotonic_timestamps"`
+	MonotonicTolerance  time.Duration `mapstructure:"monotonic_tolerance"`
+	TimestampSessionTTL time.Duration `mapstructure:"timestamp_session_ttl"` // Idle sessions' latest timestamps are forgotten after this, 0 keeps them
+
+	// Allowed anchor metadata keys as "key:type" entries; metadata with
+	// other keys or types is rejected. Empty accepts any metadata.
+	MetadataSchema []string `mapstructure:"metadata_schema"`
+}
+
+// MetadataTypes are the types a metadata_schema entry may name
+var MetadataTypes = map[string]bool{
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"array":   true,
+	"object":  true,
+	"any":     true,
+}
+
+// MetadataFields parses MetadataSchema into a map of key to type, returning
+// nil when no schema is configured
+func (c ValidationConfig) MetadataFields() (map[string]string, error) {
+	if len(c.MetadataSchema) == 0 {
+		return nil, nil
+	}
+
+	fields := make(map[string]string, len(c.MetadataSchema))
+	for _, entry := range c.MetadataSchema {
+		key, typ, ok := strings.Cut(entry, ":")
+		key, typ = strings.TrimSpace(key), strings.TrimSpace(typ)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("metadata schema entry %q is not key:type", entry)
+		}
+		if !MetadataTypes[typ] {
+			return nil, fmt.Errorf("metadata schema entry %q has unknown type %q", entry, typ)
+		}
+		if _, dup := fields[key]; dup {
+			return nil, fmt.Errorf("metadata schema lists %q more than once", key)
+		}
+		fields[key] = typ
+	}
+	return fields, nil
+}
+
+// WebSocketConfig holds WebSocket connection limits
+type WebSocketConfig struct {
+	ReadBufferSize  int   `mapstructure:"read_buffer_size"`
+	WriteBufferSize int   `mapstructure:"write_buffer_size"`
+	MaxMessageSize  int64 `mapstructure:"max_message_size"` // Largest inbound frame in bytes; larger frames close the connection
+	MaxMeshSize     int64 `mapstructure:"max_mesh_size"`    // Largest decoded mesh update geometry in bytes, 0 disables
+
+	// permessage-deflate, used with clients that offer it
+	EnableCompression    bool `mapstructure:"enable_compression"`
+	CompressionLevel     int  `mapstructure:"compression_level"`     // flate level, 1 (fastest) to 9 (smallest)
+	CompressionThreshold int  `mapstructure:"compression_threshold"` // Messages shorter than this many bytes are sent uncompressed
+
+	// Updates per session above which an HTTP ingest is announced with a
+	// single summary message instead
+	IngestBroadcastLimit int `mapstructure:"ingest_broadcast_limit"`
+
+	// anchor_update messages are broadcast as an anchor_diff of the fields
+	// that changed by more than this, and not at all if none did
+	AnchorDiffTolerance float64 `mapstructure:"anchor_diff_tolerance"`
+
+	// Clients that send no message for this long are disconnected, 0
+	// disables. Pongs keep a connection alive but do not count as activity.
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+
+	// Liveness of connections: a ping is sent every PingPeriod, and a
+	// connection whose pong does not arrive within PongWait of the last is
+	// closed. Writes, pings included, time out after WriteWait.
+	PongWait   time.Duration `mapstructure:"pong_wait"`
+	PingPeriod time.Duration `mapstructure:"ping_period"`
+	WriteWait  time.Duration `mapstructure:"write_wait"`
+
+	// Updates that fail processing for server-side reasons are kept for
+	// inspection, dropping the oldest beyond either cap; a size of 0
+	// disables the dead-letter buffer
+	DeadLetterSize     int   `mapstructure:"dead_letter_size"`
+	DeadLetterMaxBytes int64 `mapstructure:"dead_letter_max_bytes"`
+
+	// Dead letters that failed transiently are retried this many times,
+	// doubling the delay between attempts
+	DeadLetterRetries    int           `mapstructure:"dead_letter_retries"`
+	DeadLetterRetryDelay time.Duration `mapstructure:"dead_letter_retry_delay"`
+
+	// Each client queues up to SendBuffer outbound messages, then up to
+	// SendOverflow more while it is warned that it is falling behind.
+	// Clients that stay over SendBuffer for SlowConsumerTimeout are
+	// disconnected; messages that find the queue full are dropped.
+	SendBuffer          int           `mapstructure:"send_buffer"`
+	SendOverflow        int           `mapstructure:"send_overflow"`
+	SlowConsumerTimeout time.Duration `mapstructure:"slow_consumer_timeout"`
+
+	// A snapshot request is answered with up to SnapshotMaxAnchors of the
+	// session's anchors, SnapshotPageSize to a message
+	SnapshotPageSize   int `mapstructure:"snapshot_page_size"`
+	SnapshotMaxAnchors int `mapstructure:"snapshot_max_anchors"`
+
+	// Clients negotiating this Sec-WebSocket-Protocol exchange mesh updates
+	// as binary frames with raw buffers; others, and every client when it
+	// is empty, use JSON text frames
+	BinarySubprotocol string `mapstructure:"binary_subprotocol"`
+}
+
+// ImportConfig holds mesh file import configuration
+type ImportConfig struct {
+	MaxFileSize int64 `mapstructure:"max_file_size"` // Largest accepted upload in bytes
+}
+
+// UploadConfig holds chunked mesh upload configuration. Chunks are buffered
+// in memory until their upload completes or expires.
+type UploadConfig struct {
+	TTL      time.Duration `mapstructure:"ttl"`       // Unfinished uploads are discarded after this long without a chunk
+	MaxSize  int64         `mapstructure:"max_size"`  // Largest assembled mesh in bytes
+	MaxBytes int64         `mapstructure:"max_bytes"` // Chunk bytes buffered across all uploads
+}
+
+// QueryConfig holds the page sizes of spatial queries and anchor history
+type QueryConfig struct {
+	DefaultLimit int `mapstructure:"default_limit"` // Results returned when a request sets no limit
+	MaxLimit     int `mapstructure:"max_limit"`     // Larger requested limits are clamped to this
+}
+
+// IdempotencyConfig holds how long ingest responses are kept to answer
+// retried requests
+type IdempotencyConfig struct {
+	TTL     time.Duration `mapstructure:"ttl"`      // 0 disables idempotent replays
+	MaxKeys int           `mapstructure:"max_keys"` // The oldest responses are forgotten beyond this many
+}
+
+// TracingConfig holds OpenTelemetry trace export configuration
+type TracingConfig struct {
+	Enabled     bool    `mapstructure:"enabled"`      // Spans are only recorded and exported when enabled
+	Endpoint    string  `mapstructure:"endpoint"`     // OTLP/HTTP collector as host:port
+	Insecure    bool    `mapstructure:"insecure"`     // Export over plain HTTP instead of HTTPS
+	ServiceName string  `mapstructure:"service_name"` // Reported as service.name
+	SampleRatio float64 `mapstructure:"sample_ratio"` // Share of new traces recorded; traced callers decide for their own
+}
+
+// RetentionConfig holds the expiry of idle sessions. A sweep every Interval
+// deletes sessions whose anchors were all last updated more than MaxAge ago.
+type RetentionConfig struct {
+	MaxAge      time.Duration `mapstructure:"max_age"`      // 0 keeps sessions until deleted
+	Interval    time.Duration `mapstructure:"interval"`     // Time between sweeps
+	MaxSessions int           `mapstructure:"max_sessions"` // Most sessions deleted by one sweep
+}
+
+// AuditConfig holds where records of ingests, anchor updates and deletes are
+// written, apart from the application log
+type AuditConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Sink    string `mapstructure:"sink"` // stdout, stderr or file
+	Path    string `mapstructure:"path"` // File appended to by the file sink
+}
+
+// PoseHistoryConfig holds how much of each anchor's pose history is kept.
+// Samples more than FullResolution older than an anchor's latest are thinned
+// to one per Interval, then all but the newest MaxSamples are dropped.
+type PoseHistoryConfig struct {
+	MaxSamples     int           `mapstructure:"max_samples"`     // 0 keeps any number
+	FullResolution time.Duration `mapstructure:"full_resolution"` // Recent span kept at full resolution when thinning
+	Interval       time.Duration `mapstructure:"interval"`        // Spacing of thinned samples, 0 disables thinning
 }
 
 // Load loads configuration from environment and config files
@@ -40,12 +423,135 @@ func Load() (*Config, error) {
 	// Set defaults
 	viper.SetDefault("server.host", "0.0.0.0")
 	viper.SetDefault("server.port", "8080")
+	viper.SetDefault("server.max_body_bytes", 32<<20)
+	viper.SetDefault("server.read_timeout", 30*time.Second)
+	viper.SetDefault("server.read_header_timeout", 10*time.Second)
+	viper.SetDefault("server.write_timeout", 30*time.Second)
+	viper.SetDefault("server.idle_timeout", 120*time.Second)
+	viper.SetDefault("server.max_header_bytes", 1<<20)
+	viper.SetDefault("server.keep_alives", true)
+	viper.SetDefault("server.http2", false)
+	viper.SetDefault("server.http2_max_concurrent_streams", 250)
+	viper.SetDefault("server.tls_cert_file", "")
+	viper.SetDefault("server.tls_key_file", "")
+	viper.SetDefault("server.response_compression.enabled", true)
+	viper.SetDefault("server.response_compression.min_size", 1024)
+	viper.SetDefault("server.response_compression.level", 5)
+	viper.SetDefault("server.export_compression.level", 5)
 	viper.SetDefault("database.url", "http://localhost:8529")
 	viper.SetDefault("database.database", "stag")
 	viper.SetDefault("database.username", "root")
+	viper.SetDefault("database.max_attempts", 10)
+	viper.SetDefault("database.retry_delay", time.Second)
+	viper.SetDefault("database.endpoint_check_interval", 10*time.Second)
+	viper.SetDefault("database.connect_timeout", 2*time.Minute)
+	viper.SetDefault("database.write_retries", 3)
+	viper.SetDefault("database.write_retry_delay", 25*time.Millisecond)
+	viper.SetDefault("database.bulk_anchor_threshold", 16)
+	viper.SetDefault("database.metadata_indexes", []string{})
+	viper.SetDefault("database.migration_timeout", 0)
+	viper.SetDefault("database.query_timeout", 10*time.Second)
+	viper.SetDefault("database.query_timeouts", map[string]time.Duration{"export": time.Minute, "replay": time.Minute, "admin_snapshot": 0})
+	viper.SetDefault("database.operation_timeout", 25*time.Second)
+	viper.SetDefault("database.operation_timeouts", map[string]time.Duration{"export": 0, "replay": 0, "admin_snapshot": 0, "admin_snapshot_restore": 0})
 	viper.SetDefault("log_level", "info")
+	viper.SetDefault("logging.format", logger.FormatJSON)
+	viper.SetDefault("logging.caller", false)
+	viper.SetDefault("logging.timestamp_format", logger.DefaultTimestampFormat)
+	viper.SetDefault("logging.trace_id", true)
 	viper.SetDefault("metrics.enabled", true)
 	viper.SetDefault("metrics.path", "/metrics")
+	viper.SetDefault("metrics.session_label", "drop")
+	viper.SetDefault("metrics.session_ttl", 15*time.Minute)
+	viper.SetDefault("dedup.warm_cache", false)
+	viper.SetDefault("dedup.cache_expiry", 5*time.Minute)
+	viper.SetDefault("dedup.anchors.enabled", false)
+	viper.SetDefault("dedup.anchors.epsilon", 0.001)
+	viper.SetDefault("dedup.anchors.rotation_epsilon", 0.001)
+	viper.SetDefault("dedup.anchors.ttl", 10*time.Minute)
+	viper.SetDefault("dedup.anchors.max_anchors", 100000)
+	viper.SetDefault("compression.codec", "zstd")
+	viper.SetDefault("compression.default_level", 0)
+	viper.SetDefault("compression.max_delta_depth", 100)
+	viper.SetDefault("mesh_storage.backend", "database")
+	viper.SetDefault("mesh_storage.threshold", 1<<20)
+	viper.SetDefault("mesh_storage.fetch_timeout", 30*time.Second)
+	viper.SetDefault("mesh_storage.path", "data/meshes")
+	viper.SetDefault("mesh_storage.prefix", "meshes/")
+	viper.SetDefault("mesh_storage.s3.endpoint", "")
+	viper.SetDefault("mesh_storage.s3.bucket", "")
+	viper.SetDefault("mesh_storage.s3.region", "us-east-1")
+	viper.SetDefault("mesh_storage.s3.access_key_id", "")
+	viper.SetDefault("mesh_storage.s3.secret_access_key", "")
+	viper.SetDefault("mesh_storage.s3.path_style", false)
+	viper.SetDefault("auth.read_keys", []string{})
+	viper.SetDefault("auth.write_keys", []string{})
+	viper.SetDefault("auth.jwt.secret", "")
+	viper.SetDefault("auth.jwt.public_key_file", "")
+	viper.SetDefault("auth.jwt.clock_skew", 30*time.Second)
+	viper.SetDefault("topology.neighbor_distance", 2.0)
+	viper.SetDefault("topology.max_hops", 5)
+	viper.SetDefault("topology.max_path_depth", 50)
+	viper.SetDefault("validation.normalize_rotations", false)
+	viper.SetDefault("validation.clamp_compression_levels", false)
+	viper.SetDefault("validation.generate_normals", false)
+	viper.SetDefault("validation.max_anchors_per_event", 10000)
+	viper.SetDefault("validation.max_meshes_per_event", 1000)
+	viper.SetDefault("validation.max_future_skew", 0)
+	viper.SetDefault("validation.monotonic_timestamps", false)
+	viper.SetDefault("validation.monotonic_tolerance", 0)
+	viper.SetDefault("validation.timestamp_session_ttl", time.Hour)
+	viper.SetDefault("validation.metadata_schema", []string{})
+	viper.SetDefault("websocket.read_buffer_size", 16*1024)
+	viper.SetDefault("websocket.write_buffer_size", 16*1024)
+	viper.SetDefault("websocket.max_message_size", 16<<20)
+	viper.SetDefault("websocket.max_mesh_size", 8<<20)
+	viper.SetDefault("websocket.ingest_broadcast_limit", 100)
+	viper.SetDefault("websocket.enable_compression", false)
+	viper.SetDefault("websocket.compression_level", 1)
+	viper.SetDefault("websocket.compression_threshold", 512)
+	viper.SetDefault("websocket.anchor_diff_tolerance", 1e-6)
+	viper.SetDefault("websocket.idle_timeout", 5*time.Minute)
+	viper.SetDefault("websocket.pong_wait", 60*time.Second)
+	viper.SetDefault("websocket.ping_period", 54*time.Second)
+	viper.SetDefault("websocket.write_wait", 10*time.Second)
+	viper.SetDefault("websocket.dead_letter_size", 1000)
+	viper.SetDefault("websocket.dead_letter_max_bytes", 64<<20)
+	viper.SetDefault("websocket.dead_letter_retries", 3)
+	viper.SetDefault("websocket.dead_letter_retry_delay", 5*time.Second)
+	viper.SetDefault("websocket.send_buffer", 256)
+	viper.SetDefault("websocket.send_overflow", 256)
+	viper.SetDefault("websocket.slow_consumer_timeout", 10*time.Second)
+	viper.SetDefault("websocket.snapshot_page_size", 100)
+	viper.SetDefault("websocket.snapshot_max_anchors", 10000)
+	viper.SetDefault("websocket.binary_subprotocol", "stag.binary.v1")
+	viper.SetDefault("import.max_file_size", 64<<20)
+	viper.SetDefault("upload.ttl", time.Hour)
+	viper.SetDefault("upload.max_size", 256<<20)
+	viper.SetDefault("upload.max_bytes", 1<<30)
+	viper.SetDefault("query.default_limit", 100)
+	viper.SetDefault("query.max_limit", 1000)
+	viper.SetDefault("idempotency.ttl", 10*time.Minute)
+	viper.SetDefault("idempotency.max_keys", 100000)
+	viper.SetDefault("tracing.enabled", false)
+	viper.SetDefault("tracing.endpoint", "localhost:4318")
+	viper.SetDefault("tracing.insecure", false)
+	viper.SetDefault("tracing.service_name", "stag")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+	viper.SetDefault("retention.max_age", 0)
+	viper.SetDefault("retention.interval", time.Hour)
+	viper.SetDefault("retention.max_sessions", 100)
+	viper.SetDefault("pose_history.max_samples", 0)
+	viper.SetDefault("pose_history.full_resolution", 10*time.Minute)
+	viper.SetDefault("pose_history.interval", 0)
+	viper.SetDefault("audit.enabled", false)
+	viper.SetDefault("audit.sink", "stdout")
+	viper.SetDefault("audit.path", "audit.log")
+	viper.SetDefault("rate_limit.requests_per_second", 50.0)
+	viper.SetDefault("rate_limit.burst", 100)
+	viper.SetDefault("rate_limit.idle_timeout", 10*time.Minute)
+	viper.SetDefault("ingest_queue.workers", 32)
+	viper.SetDefault("ingest_queue.max_queue", 256)
 
 	// Environment variables
 	viper.SetEnvPrefix("STAG")
@@ -90,8 +596,20 @@ func (c *Config) Validate() error {
 	if c.Server.Port == "" {
 		return fmt.Errorf("server port is required")
 	}
-	if c.Database.URL == "" {
-		return fmt.Errorf("database URL is required")
+	endpoints := c.Database.EndpointList()
+	if len(endpoints) == 0 {
+		return fmt.Errorf("at least one database endpoint is required")
+	}
+	for _, endpoint := range endpoints {
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			return fmt.Errorf("database endpoint %q must be an http:// or https:// URL", endpoint)
+		}
+	}
+	if c.Database.EndpointCheckInterval < 0 {
+		return fmt.Errorf("database endpoint check interval must not be negative")
+	}
+	if c.Logging.Format != logger.FormatJSON && c.Logging.Format != logger.FormatText {
+		return fmt.Errorf("logging format must be %s or %s, got %q", logger.FormatJSON, logger.FormatText, c.Logging.Format)
 	}
 	if c.Database.Database == "" {
 		return fmt.Errorf("database name is required")
@@ -102,5 +620,206 @@ func (c *Config) Validate() error {
 	if c.Database.Password == "" {
 		return fmt.Errorf("database password is required")
 	}
+	if c.Database.QueryTimeout < 0 {
+		return fmt.Errorf("database query timeout must not be negative")
+	}
+	for endpoint, timeout := range c.Database.QueryTimeouts {
+		if timeout < 0 {
+			return fmt.Errorf("database query timeout for %s must not be negative", endpoint)
+		}
+	}
+	if c.Database.OperationTimeout < 0 {
+		return fmt.Errorf("database operation timeout must not be negative")
+	}
+	for endpoint, timeout := range c.Database.OperationTimeouts {
+		if timeout < 0 {
+			return fmt.Errorf("database operation timeout for %s must not be negative", endpoint)
+		}
+	}
+	if c.Database.WriteRetries < 0 {
+		return fmt.Errorf("database write retries must not be negative")
+	}
+	if c.Database.BulkAnchorThreshold < 0 {
+		return fmt.Errorf("database bulk anchor threshold must not be negative")
+	}
+	if c.Database.MigrationTimeout < 0 {
+		return fmt.Errorf("database migration timeout must not be negative")
+	}
+	if c.Validation.MaxAnchorsPerEvent < 0 || c.Validation.MaxMeshesPerEvent < 0 {
+		return fmt.Errorf("validation per-event limits must not be negative")
+	}
+	if _, err := c.Validation.MetadataFields(); err != nil {
+		return err
+	}
+	if c.WebSocket.AnchorDiffTolerance < 0 {
+		return fmt.Errorf("websocket anchor diff tolerance must not be negative")
+	}
+	if c.WebSocket.IdleTimeout < 0 {
+		return fmt.Errorf("websocket idle timeout must not be negative")
+	}
+	if c.WebSocket.PongWait <= 0 || c.WebSocket.PingPeriod <= 0 || c.WebSocket.WriteWait <= 0 {
+		return fmt.Errorf("websocket pong wait, ping period and write wait must be positive")
+	}
+	if c.WebSocket.PingPeriod >= c.WebSocket.PongWait {
+		return fmt.Errorf("websocket ping period must be shorter than pong wait")
+	}
+	if c.WebSocket.DeadLetterSize < 0 || c.WebSocket.DeadLetterMaxBytes < 0 || c.WebSocket.DeadLetterRetries < 0 {
+		return fmt.Errorf("websocket dead-letter limits must not be negative")
+	}
+	if c.WebSocket.DeadLetterRetries > 0 && c.WebSocket.DeadLetterRetryDelay <= 0 {
+		return fmt.Errorf("websocket dead-letter retry delay must be positive")
+	}
+	if c.WebSocket.SendBuffer <= 0 {
+		return fmt.Errorf("websocket send buffer must be positive")
+	}
+	if c.WebSocket.SendOverflow < 0 || c.WebSocket.SlowConsumerTimeout < 0 {
+		return fmt.Errorf("websocket send overflow and slow consumer timeout must not be negative")
+	}
+	if c.WebSocket.SnapshotPageSize <= 0 || c.WebSocket.SnapshotMaxAnchors <= 0 {
+		return fmt.Errorf("websocket snapshot page size and max anchors must be positive")
+	}
+	if strings.ContainsAny(c.WebSocket.BinarySubprotocol, " \t,;\"") {
+		return fmt.Errorf("websocket binary subprotocol must be a single token")
+	}
+	if c.Query.DefaultLimit <= 0 {
+		return fmt.Errorf("query default limit must be positive")
+	}
+	if c.Query.MaxLimit < c.Query.DefaultLimit {
+		return fmt.Errorf("query max limit %d must not be below the default limit %d", c.Query.MaxLimit, c.Query.DefaultLimit)
+	}
+	if c.Topology.MaxPathDepth < 0 {
+		return fmt.Errorf("topology max path depth must not be negative")
+	}
+	if c.Idempotency.TTL < 0 || c.Idempotency.MaxKeys < 0 {
+		return fmt.Errorf("idempotency ttl and max keys must not be negative")
+	}
+	if c.Tracing.Enabled && c.Tracing.Endpoint == "" {
+		return fmt.Errorf("tracing endpoint is required when tracing is enabled")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample ratio must be between 0 and 1")
+	}
+	if c.Compression.DefaultLevel < 0 || c.Compression.DefaultLevel > 9 {
+		return fmt.Errorf("compression default level must be between 0 and 9")
+	}
+	if c.Compression.MaxDeltaDepth < 0 {
+		return fmt.Errorf("compression max delta depth must not be negative")
+	}
+	switch c.MeshStorage.Backend {
+	case "", "database":
+	case "filesystem":
+		if c.MeshStorage.Path == "" {
+			return fmt.Errorf("mesh storage path is required for the filesystem backend")
+		}
+	case "s3":
+		if c.MeshStorage.S3.Endpoint == "" || c.MeshStorage.S3.Bucket == "" {
+			return fmt.Errorf("mesh storage S3 endpoint and bucket are required for the s3 backend")
+		}
+		if (c.MeshStorage.S3.AccessKeyID == "") != (c.MeshStorage.S3.SecretAccessKey == "") {
+			return fmt.Errorf("mesh storage S3 access key ID and secret access key must be set together")
+		}
+	default:
+		return fmt.Errorf("mesh storage backend must be database, filesystem or s3")
+	}
+	for _, key := range c.Database.MetadataIndexes {
+		if !validMetadataIndexKey(key) {
+			return fmt.Errorf("database metadata index key %q may only hold letters, digits, _ and -", key)
+		}
+	}
+	if c.Validation.MaxFutureSkew < 0 || c.Validation.MonotonicTolerance < 0 || c.Validation.TimestampSessionTTL < 0 {
+		return fmt.Errorf("validation timestamp skew, tolerance and session TTL must not be negative")
+	}
+	if c.Retention.MaxAge < 0 {
+		return fmt.Errorf("retention max age must not be negative")
+	}
+	if c.Retention.MaxAge > 0 && (c.Retention.Interval <= 0 || c.Retention.MaxSessions <= 0) {
+		return fmt.Errorf("retention interval and max sessions must be positive when max age is set")
+	}
+	if c.PoseHistory.MaxSamples < 0 || c.PoseHistory.FullResolution < 0 || c.PoseHistory.Interval < 0 {
+		return fmt.Errorf("pose history max samples, full resolution and interval must not be negative")
+	}
+	if c.Audit.Enabled {
+		switch c.Audit.Sink {
+		case "stdout", "stderr":
+		case "file":
+			if c.Audit.Path == "" {
+				return fmt.Errorf("audit path is required for the file sink")
+			}
+		default:
+			return fmt.Errorf("audit sink must be stdout, stderr or file, got %q", c.Audit.Sink)
+		}
+	}
+	if c.IngestQueue.Workers < 0 || c.IngestQueue.MaxQueue < 0 {
+		return fmt.Errorf("ingest queue workers and max queue must not be negative")
+	}
+	if anchors := c.Dedup.Anchors; anchors.Epsilon < 0 || anchors.RotationEpsilon < 0 || anchors.TTL < 0 || anchors.MaxAnchors < 0 {
+		return fmt.Errorf("anchor dedup epsilons, TTL and max anchors must not be negative")
+	}
+	if c.MeshStorage.Threshold < 0 || c.MeshStorage.FetchTimeout < 0 {
+		return fmt.Errorf("mesh storage threshold and fetch timeout must not be negative")
+	}
+	if c.WebSocket.EnableCompression && (c.WebSocket.CompressionLevel < 1 || c.WebSocket.CompressionLevel > 9) {
+		return fmt.Errorf("websocket compression level must be between 1 and 9")
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		return fmt.Errorf("server max body bytes must be positive")
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.ReadHeaderTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		return fmt.Errorf("server timeouts must not be negative")
+	}
+	if c.Server.ResponseCompression.MinSize < 0 {
+		return fmt.Errorf("server response compression min size must not be negative")
+	}
+	if c.Server.ResponseCompression.Enabled && (c.Server.ResponseCompression.Level < 1 || c.Server.ResponseCompression.Level > 9) {
+		return fmt.Errorf("server response compression level must be between 1 and 9")
+	}
+	if c.Server.ExportCompression.Level < 1 || c.Server.ExportCompression.Level > 9 {
+		return fmt.Errorf("server export compression level must be between 1 and 9")
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("server max header bytes must not be negative")
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		return fmt.Errorf("server TLS certificate and key files must be set together")
+	}
+	if c.Import.MaxFileSize <= 0 {
+		return fmt.Errorf("import max file size must be positive")
+	}
+	if c.Upload.TTL <= 0 || c.Upload.MaxSize <= 0 || c.Upload.MaxBytes <= 0 {
+		return fmt.Errorf("upload TTL, max size and max bytes must be positive")
+	}
+	if c.Metrics.BearerToken != "" && c.Metrics.Username != "" {
+		return fmt.Errorf("metrics bearer token and basic auth are mutually exclusive")
+	}
+	if c.Metrics.Username != "" && c.Metrics.Password == "" {
+		return fmt.Errorf("metrics basic auth requires a password")
+	}
+	switch c.Metrics.SessionLabel {
+	case "", "keep", "hash", "drop":
+	default:
+		return fmt.Errorf("metrics session label must be keep, hash or drop")
+	}
+	if c.Auth.JWT.Secret != "" && c.Auth.JWT.PublicKeyFile != "" {
+		return fmt.Errorf("JWT secret and public key file are mutually exclusive")
+	}
+	// Both schemes use the bearer token, so only one can be active
+	jwtEnabled := c.Auth.JWT.Secret != "" || c.Auth.JWT.PublicKeyFile != ""
+	if jwtEnabled && (len(c.Auth.ReadKeys) > 0 || len(c.Auth.WriteKeys) > 0) {
+		return fmt.Errorf("API keys and JWT authorization cannot both be configured")
+	}
 	return nil
+}
+
+// validMetadataIndexKey reports whether key is non-empty and holds only
+// letters, digits, _ and -, so it names one top-level metadata attribute
+func validMetadataIndexKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
 }
\ No newline at end of file
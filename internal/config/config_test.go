@@ -0,0 +1,49 @@
+package config
+
+import "testing"
+
+func TestMetadataFields(t *testing.T) {
+	fields, err := ValidationConfig{MetadataSchema: []string{"label:string", " floor : integer "}}.MetadataFields()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(fields) != 2 || fields["label"] != "string" || fields["floor"] != "integer" {
+		t.Errorf("Unexpected fields: %v", fields)
+	}
+
+	if fields, err := (ValidationConfig{}).MetadataFields(); fields != nil || err != nil {
+		t.Errorf("Expected no schema by default, got %v (%v)", fields, err)
+	}
+
+	for _, schema := range [][]string{{"label"}, {":string"}, {"label:text"}, {"label:string", "label:number"}} {
+		if _, err := (ValidationConfig{MetadataSchema: schema}).MetadataFields(); err == nil {
+			t.Errorf("Expected %q to be rejected", schema)
+		}
+	}
+}
+
+func TestEndpointList(t *testing.T) {
+	tests := []struct {
+		cfg  DatabaseConfig
+		want []string
+	}{
+		{DatabaseConfig{URL: "http://a:8529"}, []string{"http://a:8529"}},
+		{DatabaseConfig{URL: "http://a:8529, http://b:8529,"}, []string{"http://a:8529", "http://b:8529"}},
+		{DatabaseConfig{URL: "http://a:8529", Endpoints: []string{"http://b:8529", " http://c:8529 "}}, []string{"http://b:8529", "http://c:8529"}},
+		{DatabaseConfig{URL: " , "}, []string{}},
+	}
+
+	for _, tt := range tests {
+		got := tt.cfg.EndpointList()
+		if len(got) != len(tt.want) {
+			t.Errorf("%+v: expected %v, got %v", tt.cfg, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%+v: expected %v, got %v", tt.cfg, tt.want, got)
+				break
+			}
+		}
+	}
+}
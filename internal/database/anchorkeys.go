@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/arangodb/go-driver"
+)
+
+// anchorKeyIndex makes (session_id, id) the key of an anchor
+const anchorKeyIndex = "idx_anchor_session_id"
+
+// rekeyAnchors moves documents written while anchors were upserted by ID
+// alone to keys of session and ID. Duplicates of an anchor within a session,
+// left by racing writes, are reduced to the latest along with their edges;
+// meshes, which named only their anchor, are given its session. Both run
+// once, before the unique index that records the migration is created.
+func rekeyAnchors(ctx context.Context, conn *Connection) error {
+	anchorsCol, err := conn.Database().Collection(ctx, AnchorsCollection)
+	if err != nil {
+		return fmt.Errorf("failed to get anchors collection: %w", err)
+	}
+
+	migrated, err := anchorsCol.IndexExists(ctx, anchorKeyIndex)
+	if err != nil {
+		return fmt.Errorf("failed to check anchor key index: %w", err)
+	}
+	if migrated {
+		return nil
+	}
+
+	dedupe := `
+		LET removed = (
+			FOR a IN @@anchors
+			COLLECT session_id = a.session_id, id = a.id INTO group = a
+			FILTER LENGTH(group) > 1
+			LET latest = FIRST(FOR g IN group SORT g.timestamp DESC RETURN g._id)
+			FOR g IN group
+			FILTER g._id != latest
+			REMOVE g IN @@anchors
+			RETURN OLD._id
+		)
+		FOR e IN @@edges
+		FILTER e._from IN removed OR e._to IN removed
+		REMOVE e IN @@edges
+	`
+	if err := runMigrationQuery(ctx, conn, dedupe, map[string]interface{}{
+		"@anchors": AnchorsCollection,
+		"@edges":   TopologyEdges,
+	}); err != nil {
+		return fmt.Errorf("failed to remove duplicate anchors: %w", err)
+	}
+
+	// Anchor IDs were unique until now, so each mesh's anchor is unambiguous
+	backfill := `
+		FOR m IN @@meshes
+		FILTER m.session_id == null
+		LET session_id = FIRST(FOR a IN @@anchors FILTER a.id == m.anchor_id RETURN a.session_id)
+		FILTER session_id != null
+		UPDATE m WITH { session_id: session_id } IN @@meshes
+	`
+	if err := runMigrationQuery(ctx, conn, backfill, map[string]interface{}{
+		"@anchors": AnchorsCollection,
+		"@meshes":  MeshesCollection,
+	}); err != nil {
+		return fmt.Errorf("failed to backfill mesh sessions: %w", err)
+	}
+
+	_, _, err = anchorsCol.EnsurePersistentIndex(ctx, []string{"session_id", "id"}, &driver.EnsurePersistentIndexOptions{
+		Name:   anchorKeyIndex,
+		Unique: true,
+		Sparse: false,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create anchor key index: %w", err)
+	}
+
+	return nil
+}
+
+// runMigrationQuery runs a data migration query that returns nothing
+func runMigrationQuery(ctx context.Context, conn *Connection, query string, bindVars map[string]interface{}) error {
+	cursor, err := conn.Database().Query(ctx, query, bindVars)
+	if err != nil {
+		return err
+	}
+	return cursor.Close()
+}
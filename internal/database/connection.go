@@ -9,67 +9,135 @@ import (
 	"github.com/arangodb/go-driver/http"
 	
 	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/logger"
 )
 
 const (
 	// Collection names
-	AnchorsCollection  = "anchors"
-	MeshesCollection   = "meshes"
-	TopologyEdges      = "topology_edges"
-	TopologyGraph      = "topology"
+	AnchorsCollection     = "anchors"
+	AnchorPosesCollection = "anchor_poses" // Every pose an anchor has had, for trajectory queries
+	MeshesCollection      = "meshes"
+	TopologyEdges         = "topology_edges"
+	TopologyGraph         = "topology"
+	MigrationsCollection  = "migrations" // Records the applied schema version, see Migrate
 )
 
 // Connection wraps the ArangoDB connection
 type Connection struct {
-	client   driver.Client
-	database driver.Database
+	client    driver.Client
+	database  driver.Database
+	endpoints *endpointMonitor // nil unless several endpoints are probed
 }
 
-// Connect establishes connection to ArangoDB
-func Connect(cfg config.DatabaseConfig) (*Connection, error) {
-	// Create HTTP connection
+// maxRetryDelay caps the exponential backoff between connection attempts
+const maxRetryDelay = 30 * time.Second
+
+// Connect establishes connection to ArangoDB, retrying with exponential
+// backoff until cfg.MaxAttempts is exhausted or ctx expires
+func Connect(ctx context.Context, cfg config.DatabaseConfig, log logger.Logger) (*Connection, error) {
+	// Create HTTP connection; with several endpoints the driver spreads
+	// requests over them and fails over from those that are unreachable
+	endpoints := cfg.EndpointList()
 	conn, err := http.NewConnection(http.ConnectionConfig{
-		Endpoints: []string{cfg.URL},
+		Endpoints: endpoints,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP connection: %w", err)
 	}
 
 	// Create client
+	auth := driver.BasicAuthentication(cfg.Username, cfg.Password)
 	client, err := driver.NewClient(driver.ClientConfig{
 		Connection:     conn,
-		Authentication: driver.BasicAuthentication(cfg.Username, cfg.Password),
+		Authentication: auth,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
-	// Get or create database
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var monitor *endpointMonitor
+	if len(endpoints) > 1 && cfg.EndpointCheckInterval > 0 {
+		if monitor, err = newEndpointMonitor(endpoints, auth, log); err != nil {
+			return nil, err
+		}
+	}
+
+	attempts := cfg.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		db, err := openDatabase(ctx, client, cfg.Database)
+		if err == nil {
+			if attempt > 1 {
+				log.Infof("Connected to ArangoDB after %d attempts", attempt)
+			}
+			if monitor != nil {
+				log.Infof("Probing %d ArangoDB endpoints every %s", len(endpoints), cfg.EndpointCheckInterval)
+				go monitor.run(cfg.EndpointCheckInterval)
+			}
+			return &Connection{
+				client:    client,
+				database:  db,
+				endpoints: monitor,
+			}, nil
+		}
+		lastErr = err
+
+		if attempt == attempts {
+			break
+		}
+
+		delay := retryDelay(cfg.RetryDelay, attempt)
+		log.Warnf("Database connection attempt %d/%d failed: %v (retrying in %s)", attempt, attempts, err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up connecting after %d attempts: %w", attempt, lastErr)
+		}
+	}
+
+	return nil, fmt.Errorf("failed to connect after %d attempts: %w", attempts, lastErr)
+}
+
+// openDatabase gets or creates the configured database
+func openDatabase(ctx context.Context, client driver.Client, name string) (driver.Database, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	var db driver.Database
-	exists, err := client.DatabaseExists(ctx, cfg.Database)
+	exists, err := client.DatabaseExists(ctx, name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check database existence: %w", err)
 	}
 
 	if !exists {
-		db, err = client.CreateDatabase(ctx, cfg.Database, nil)
+		db, err := client.CreateDatabase(ctx, name, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create database: %w", err)
 		}
-	} else {
-		db, err = client.Database(ctx, cfg.Database)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open database: %w", err)
-		}
+		return db, nil
 	}
 
-	return &Connection{
-		client:   client,
-		database: db,
-	}, nil
+	db, err := client.Database(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+// retryDelay returns the backoff before retrying after the given attempt
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
 }
 
 // Database returns the database handle
@@ -82,9 +150,21 @@ func (c *Connection) Client() driver.Client {
 	return c.client
 }
 
+// Ping checks that ArangoDB is reachable and accepting requests
+func (c *Connection) Ping(ctx context.Context) error {
+	if _, err := c.client.Version(ctx); err != nil {
+		return fmt.Errorf("failed to reach ArangoDB: %w", err)
+	}
+	return nil
+}
+
 // Close closes the database connection
 func (c *Connection) Close() error {
-	// ArangoDB Go driver doesn't require explicit connection closing
+	// ArangoDB Go driver doesn't require explicit connection closing, but
+	// the endpoint probes must stop
+	if c.endpoints != nil {
+		c.endpoints.stop()
+	}
 	return nil
 }
 
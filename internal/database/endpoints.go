@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/arangodb/go-driver/http"
+
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// endpointProbeTimeout bounds how long a probe waits on one endpoint
+const endpointProbeTimeout = 2 * time.Second
+
+// endpointMonitor probes each configured coordinator on its own. The driver
+// fails over between them silently, so this is how a dead coordinator, and
+// the failover away from it, shows up in the logs and health checks.
+type endpointMonitor struct {
+	mu        sync.Mutex
+	endpoints []string
+	down      map[string]error // Endpoints failing their last probe, with why
+
+	probe func(ctx context.Context, endpoint string) error
+	log   logger.Logger
+	done  chan struct{}
+	once  sync.Once
+}
+
+// newEndpointMonitor creates a monitor probing endpoints with their own
+// clients, so the probes are not failed over themselves
+func newEndpointMonitor(endpoints []string, auth driver.Authentication, log logger.Logger) (*endpointMonitor, error) {
+	clients := make(map[string]driver.Client, len(endpoints))
+	for _, endpoint := range endpoints {
+		conn, err := http.NewConnection(http.ConnectionConfig{Endpoints: []string{endpoint}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP connection to %s: %w", endpoint, err)
+		}
+		client, err := driver.NewClient(driver.ClientConfig{Connection: conn, Authentication: auth})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for %s: %w", endpoint, err)
+		}
+		clients[endpoint] = client
+	}
+
+	return &endpointMonitor{
+		endpoints: endpoints,
+		down:      make(map[string]error),
+		probe: func(ctx context.Context, endpoint string) error {
+			_, err := clients[endpoint].Version(ctx)
+			return err
+		},
+		log:  log,
+		done: make(chan struct{}),
+	}, nil
+}
+
+// check probes every endpoint, logging those that went down or recovered
+// since the last check
+func (m *endpointMonitor) check(ctx context.Context) {
+	results := make([]error, len(m.endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range m.endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, endpointProbeTimeout)
+			defer cancel()
+			results[i] = m.probe(probeCtx, endpoint)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, endpoint := range m.endpoints {
+		_, wasDown := m.down[endpoint]
+		if err := results[i]; err != nil {
+			m.down[endpoint] = err
+			if !wasDown {
+				m.log.Warnf("ArangoDB endpoint %s is down, failing over to %d remaining endpoints: %v",
+					endpoint, len(m.endpoints)-len(m.down), err)
+			}
+		} else if wasDown {
+			delete(m.down, endpoint)
+			m.log.Infof("ArangoDB endpoint %s recovered", endpoint)
+		}
+	}
+	if len(m.down) == len(m.endpoints) {
+		m.log.Errorf("All %d ArangoDB endpoints are down", len(m.endpoints))
+	}
+}
+
+// run checks the endpoints every interval until stopped
+func (m *endpointMonitor) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.check(context.Background())
+		}
+	}
+}
+
+// stop ends run
+func (m *endpointMonitor) stop() {
+	m.once.Do(func() { close(m.done) })
+}
+
+// status reports whether each endpoint passed its last probe
+func (m *endpointMonitor) status() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := make(map[string]bool, len(m.endpoints))
+	for _, endpoint := range m.endpoints {
+		_, down := m.down[endpoint]
+		status[endpoint] = !down
+	}
+	return status
+}
+
+// EndpointStatus reports whether each ArangoDB endpoint passed its last
+// probe, or nil when a single endpoint is configured or probes are disabled
+func (c *Connection) EndpointStatus() map[string]bool {
+	if c.endpoints == nil {
+		return nil
+	}
+	return c.endpoints.status()
+}
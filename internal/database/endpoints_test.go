@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestEndpointMonitorCheck(t *testing.T) {
+	var mu sync.Mutex
+	down := map[string]bool{}
+	monitor := &endpointMonitor{
+		endpoints: []string{"http://a:8529", "http://b:8529"},
+		down:      make(map[string]error),
+		probe: func(ctx context.Context, endpoint string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if down[endpoint] {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+		log:  logger.New(logger.Config{}),
+		done: make(chan struct{}),
+	}
+
+	expect := func(a, b bool) {
+		t.Helper()
+		status := monitor.status()
+		if status["http://a:8529"] != a || status["http://b:8529"] != b {
+			t.Errorf("Expected a up %t and b up %t, got %v", a, b, status)
+		}
+	}
+
+	monitor.check(context.Background())
+	expect(true, true)
+
+	mu.Lock()
+	down["http://a:8529"] = true
+	mu.Unlock()
+	monitor.check(context.Background())
+	expect(false, true)
+
+	mu.Lock()
+	down["http://a:8529"] = false
+	mu.Unlock()
+	monitor.check(context.Background())
+	expect(true, true)
+
+	monitor.stop()
+	monitor.stop() // Stopping twice is harmless
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	stderrors "errors"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/arangodb/go-driver"
+)
+
+// errQueryKilled is reported for queries stopped by their maxRuntime
+const errQueryKilled = 1500
+
+// ErrorClass groups database failures by whether and why they may succeed on retry
+type ErrorClass string
+
+const (
+	ErrorClassPermanent     ErrorClass = "permanent"      // Retrying cannot help
+	ErrorClassConflict      ErrorClass = "conflict"       // A concurrent write touched the same document
+	ErrorClassTimeout       ErrorClass = "timeout"        // ArangoDB did not answer in time
+	ErrorClassUnavailable   ErrorClass = "unavailable"    // ArangoDB could not be reached or refused the request
+	ErrorClassLeaderChanged ErrorClass = "leader_changed" // A cluster shard is electing a new leader
+	ErrorClassCanceled      ErrorClass = "canceled"       // The caller gave up, as when a client disconnects
+)
+
+// Retryable reports whether an operation that failed with this class may succeed if repeated
+func (c ErrorClass) Retryable() bool {
+	return c != ErrorClassPermanent && c != ErrorClassCanceled
+}
+
+// ClassifyError sorts a go-driver error into an ErrorClass
+func ClassifyError(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ErrorClassPermanent
+	// Racing UPSERTs or inserts of one key surface as unique constraint
+	// violations, which a retry resolves like any write-write conflict
+	case driver.IsArangoErrorWithErrorNum(err, driver.ErrArangoConflict, driver.ErrArangoUniqueConstraintViolated):
+		return ErrorClassConflict
+	case driver.IsNoLeaderOrOngoing(err):
+		return ErrorClassLeaderChanged
+	case driver.IsArangoErrorWithErrorNum(err, driver.ErrClusterReplicationWriteConcernNotFulfilled),
+		driver.IsArangoErrorWithCode(err, http.StatusServiceUnavailable):
+		return ErrorClassUnavailable
+	case driver.IsTimeout(err), driver.IsArangoErrorWithCode(err, http.StatusGatewayTimeout),
+		driver.IsArangoErrorWithErrorNum(err, errQueryKilled):
+		return ErrorClassTimeout
+	case driver.IsCanceled(err), stderrors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case driver.IsArangoError(err):
+		return ErrorClassPermanent
+	}
+
+	cause := driver.Cause(err)
+	var netErr net.Error
+	if stderrors.As(cause, &netErr) && netErr.Timeout() {
+		return ErrorClassTimeout
+	}
+	if stderrors.Is(cause, syscall.ECONNREFUSED) || stderrors.Is(cause, syscall.ECONNRESET) || driver.IsResponse(err) {
+		return ErrorClassUnavailable
+	}
+	return ErrorClassPermanent
+}
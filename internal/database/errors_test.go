@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/arangodb/go-driver"
+)
+
+func TestClassifyError(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://localhost:8529", Err: &net.OpError{
+		Op:  "dial",
+		Err: fmt.Errorf("connect: %w", syscall.ECONNREFUSED),
+	}}
+
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"WriteConflict", driver.ArangoError{HasError: true, Code: 409, ErrorNum: driver.ErrArangoConflict}, ErrorClassConflict},
+		{"UniqueViolation", driver.ArangoError{HasError: true, Code: 409, ErrorNum: driver.ErrArangoUniqueConstraintViolated}, ErrorClassConflict},
+		{"NotLeader", driver.ArangoError{HasError: true, Code: 503, ErrorNum: driver.ErrClusterNotLeader}, ErrorClassLeaderChanged},
+		{"ServiceUnavailable", driver.ArangoError{HasError: true, Code: 503, ErrorNum: 503}, ErrorClassUnavailable},
+		{"ConnectionRefused", refused, ErrorClassUnavailable},
+		{"Deadline", context.DeadlineExceeded, ErrorClassTimeout},
+		{"QueryKilled", driver.ArangoError{HasError: true, Code: 410, ErrorNum: 1500}, ErrorClassTimeout},
+		{"Canceled", context.Canceled, ErrorClassCanceled},
+		{"NotFound", driver.ArangoError{HasError: true, Code: 404, ErrorNum: driver.ErrArangoDocumentNotFound}, ErrorClassPermanent},
+		{"BadQuery", driver.ArangoError{HasError: true, Code: 400, ErrorNum: 1501}, ErrorClassPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClassifyError(tt.err)
+			if got != tt.want {
+				t.Errorf("Expected class %s, got %s", tt.want, got)
+			}
+			if got.Retryable() != (tt.want != ErrorClassPermanent && tt.want != ErrorClassCanceled) {
+				t.Errorf("Unexpected retryability for class %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// MetersPerDegree converts pose meters to GeoJSON degrees. Poses are in a
+// local Cartesian frame, so an anchor's floor position (pose.x, pose.y) is
+// stored as the GeoJSON point [x / MetersPerDegree, y / MetersPerDegree]:
+// pose.x runs east as longitude and pose.y north as latitude, one degree of arc
+// along the equator apart. Near (0, 0) the sphere is flat enough that polygon
+// edges stay within a millimetre of straight lines across ten kilometres.
+const MetersPerDegree = 111319.49079327357
+
+// backfillLocations stores the GeoJSON location of anchors written before
+// locations were indexed
+func backfillLocations(ctx context.Context, conn *Connection) error {
+	query := `
+		FOR a IN @@anchors
+		FILTER a.location == null AND a.pose != null
+		UPDATE a WITH { location: GEO_POINT(a.pose.x / @scale, a.pose.y / @scale) } IN @@anchors
+	`
+	bindVars := map[string]interface{}{
+		"@anchors": AnchorsCollection,
+		"scale":    MetersPerDegree,
+	}
+
+	cursor, err := conn.Database().Query(ctx, query, bindVars)
+	if err != nil {
+		return fmt.Errorf("failed to backfill anchor locations: %w", err)
+	}
+	return cursor.Close()
+}
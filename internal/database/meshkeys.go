@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/arangodb/go-driver"
+)
+
+// meshKeyIndex makes id the key of a mesh
+const meshKeyIndex = "idx_mesh_key"
+
+// dedupeMeshes reduces meshes stored more than once under one ID, as racing
+// ingests could, to the first stored. The references of the copies are
+// merged into it. Both run once, before the unique index that records the
+// migration is created.
+func dedupeMeshes(ctx context.Context, conn *Connection) error {
+	meshesCol, err := conn.Database().Collection(ctx, MeshesCollection)
+	if err != nil {
+		return fmt.Errorf("failed to get meshes collection: %w", err)
+	}
+
+	migrated, err := meshesCol.IndexExists(ctx, meshKeyIndex)
+	if err != nil {
+		return fmt.Errorf("failed to check mesh key index: %w", err)
+	}
+	if migrated {
+		return nil
+	}
+
+	// Meshes stored before references were recorded reference their own anchor
+	merge := `
+		FOR m IN @@meshes
+		COLLECT id = m.id INTO group = m
+		FILTER LENGTH(group) > 1
+		LET first = FIRST(FOR g IN group SORT g.timestamp, g._key RETURN g)
+		LET refs = UNIQUE(FLATTEN(
+			FOR g IN group
+			RETURN NOT_NULL(g.referenced_by, [{ session_id: g.session_id, anchor_id: g.anchor_id }])
+		))
+		UPDATE first WITH { ref_count: LENGTH(refs), referenced_by: refs } IN @@meshes
+	`
+	if err := runMigrationQuery(ctx, conn, merge, map[string]interface{}{
+		"@meshes": MeshesCollection,
+	}); err != nil {
+		return fmt.Errorf("failed to merge duplicate mesh references: %w", err)
+	}
+
+	remove := `
+		FOR m IN @@meshes
+		COLLECT id = m.id INTO group = m
+		FILTER LENGTH(group) > 1
+		LET first = FIRST(FOR g IN group SORT g.timestamp, g._key RETURN g._key)
+		FOR g IN group
+		FILTER g._key != first
+		REMOVE g IN @@meshes
+	`
+	if err := runMigrationQuery(ctx, conn, remove, map[string]interface{}{
+		"@meshes": MeshesCollection,
+	}); err != nil {
+		return fmt.Errorf("failed to remove duplicate meshes: %w", err)
+	}
+
+	_, _, err = meshesCol.EnsurePersistentIndex(ctx, []string{"id"}, &driver.EnsurePersistentIndexOptions{
+		Name:   meshKeyIndex,
+		Unique: true,
+		Sparse: false,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create mesh key index: %w", err)
+	}
+
+	return nil
+}
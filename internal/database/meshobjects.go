@@ -0,0 +1,28 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/arangodb/go-driver"
+)
+
+// indexMeshObjects indexes the object storage keys of offloaded meshes, so
+// deleting meshes can find whether another mesh still shares their object
+func indexMeshObjects(ctx context.Context, conn *Connection) error {
+	meshesCol, err := conn.Database().Collection(ctx, MeshesCollection)
+	if err != nil {
+		return fmt.Errorf("failed to get meshes collection: %w", err)
+	}
+
+	_, _, err = meshesCol.EnsurePersistentIndex(ctx, []string{"buffer_ref.key"}, &driver.EnsurePersistentIndexOptions{
+		Name:   "idx_mesh_buffer_key",
+		Unique: false,
+		Sparse: true,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create mesh buffer key index: %w", err)
+	}
+
+	return nil
+}
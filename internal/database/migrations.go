@@ -8,26 +8,135 @@ import (
 	"github.com/arangodb/go-driver"
 )
 
-// Migrate runs database migrations
-func Migrate(conn *Connection) error {
+// migration is one numbered step of the schema, applied once per database
+type migration struct {
+	version int
+	name    string
+	up      func(ctx context.Context, conn *Connection) error
+
+	// Collections a data migration writes. When set, up runs in a stream
+	// transaction over them that also records the migration, so the step
+	// applies whole or not at all. Steps creating collections or indexes
+	// cannot run in a transaction and leave this empty.
+	writes []string
+
+	// Data migrations scan and rewrite collections, taking time in
+	// proportion to what is stored, so they run under their own deadline
+	// rather than that of schema setup
+	data bool
+}
+
+// migrations is the registry of the schema's steps, in the order they are
+// applied. Steps are appended with the next version, never reordered or
+// changed. They stay safe to rerun, as databases migrated before
+// migrations were recorded report version 0 and run them all again.
+var migrations = []migration{
+	{version: 1, name: "create collections, indexes and topology graph", up: createSchema},
+	{version: 2, name: "index anchor locations", up: backfillLocations, writes: []string{AnchorsCollection}, data: true},
+	{version: 3, name: "key anchors by session and ID", up: rekeyAnchors, data: true},
+	{version: 4, name: "key meshes by ID", up: dedupeMeshes, data: true},
+	{version: 5, name: "index mesh buffer objects", up: indexMeshObjects},
+}
+
+// SchemaVersion is the version migrations bring a database to
+var SchemaVersion = migrations[len(migrations)-1].version
+
+// Migrate applies the pending migrations, those newer than the database's
+// schema version, in order, recording each as it completes, then indexes
+// the given anchor metadata keys. Each data migration runs within
+// dataTimeout, or without a deadline when it is 0. A database already at a
+// newer version, migrated by a newer build during a rolling upgrade, is
+// left as it is.
+func Migrate(conn *Connection, metadataIndexes []string, dataTimeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Create collections
+	// The collection recording migrations precedes every migration
+	if _, err := conn.CreateCollection(ctx, MigrationsCollection, &driver.CreateCollectionOptions{
+		Type: driver.CollectionTypeDocument,
+	}); err != nil {
+		return fmt.Errorf("failed to create migrations collection: %w", err)
+	}
+
+	if err := applyMigrations(ctx, conn, conn, migrations, dataTimeout); err != nil {
+		return err
+	}
+
+	// Index commonly queried metadata keys; these follow configuration
+	// rather than the schema version
+	if err := createMetadataIndexes(ctx, conn, metadataIndexes); err != nil {
+		return fmt.Errorf("failed to create metadata indexes: %w", err)
+	}
+
+	return nil
+}
+
+// migrationStore records the migrations applied to a database
+type migrationStore interface {
+	SchemaVersion(ctx context.Context) (int, error)
+	recordMigration(ctx context.Context, step migration) error
+	withTransaction(ctx context.Context, collections []string, fn func(ctx context.Context) error) error
+}
+
+// applyMigrations applies the steps newer than the schema version of store,
+// in order, recording each as it completes so that a failed migration
+// resumes from the step that failed. Data migrations run within
+// dataTimeout instead of the deadline of ctx, or without one when it is 0.
+func applyMigrations(ctx context.Context, store migrationStore, conn *Connection, steps []migration, dataTimeout time.Duration) error {
+	current, err := store.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if step.version <= current {
+			continue
+		}
+		if err := applyMigration(ctx, store, conn, step, dataTimeout); err != nil {
+			return fmt.Errorf("failed to migrate to schema version %d (%s): %w", step.version, step.name, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration runs step and records it, within a transaction when the
+// step names the collections it writes
+func applyMigration(ctx context.Context, store migrationStore, conn *Connection, step migration, dataTimeout time.Duration) error {
+	if step.data {
+		ctx = context.WithoutCancel(ctx)
+		if dataTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, dataTimeout)
+			defer cancel()
+		}
+	}
+
+	run := func(ctx context.Context) error {
+		if err := step.up(ctx, conn); err != nil {
+			return err
+		}
+		return store.recordMigration(ctx, step)
+	}
+
+	if len(step.writes) == 0 {
+		return run(ctx)
+	}
+	collections := append([]string{MigrationsCollection}, step.writes...)
+	return store.withTransaction(ctx, collections, run)
+}
+
+// createSchema creates the collections, their indexes and the topology graph
+func createSchema(ctx context.Context, conn *Connection) error {
 	if err := createCollections(ctx, conn); err != nil {
 		return fmt.Errorf("failed to create collections: %w", err)
 	}
-
-	// Create indexes
 	if err := createIndexes(ctx, conn); err != nil {
 		return fmt.Errorf("failed to create indexes: %w", err)
 	}
-
-	// Create graph
 	if err := createGraph(ctx, conn); err != nil {
 		return fmt.Errorf("failed to create graph: %w", err)
 	}
-
 	return nil
 }
 
@@ -40,6 +149,14 @@ func createCollections(ctx context.Context, conn *Connection) error {
 		return fmt.Errorf("failed to create anchors collection: %w", err)
 	}
 
+	// Create anchor pose history collection
+	_, err = conn.CreateCollection(ctx, AnchorPosesCollection, &driver.CreateCollectionOptions{
+		Type: driver.CollectionTypeDocument,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create anchor poses collection: %w", err)
+	}
+
 	// Create meshes collection
 	_, err = conn.CreateCollection(ctx, MeshesCollection, &driver.CreateCollectionOptions{
 		Type: driver.CollectionTypeDocument,
@@ -82,6 +199,16 @@ func createIndexes(ctx context.Context, conn *Connection) error {
 		return fmt.Errorf("failed to create session_id index: %w", err)
 	}
 
+	// Index on anchor ID for lookups without a session
+	_, _, err = anchorsCol.EnsurePersistentIndex(ctx, []string{"id"}, &driver.EnsurePersistentIndexOptions{
+		Name:   "idx_anchor_id",
+		Unique: false,
+		Sparse: false,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create anchor id index: %w", err)
+	}
+
 	// Index on timestamp for time-based queries
 	_, _, err = anchorsCol.EnsurePersistentIndex(ctx, []string{"timestamp"}, &driver.EnsurePersistentIndexOptions{
 		Name:   "idx_timestamp",
@@ -101,6 +228,40 @@ func createIndexes(ctx context.Context, conn *Connection) error {
 		return fmt.Errorf("failed to create geo index: %w", err)
 	}
 
+	// GeoJSON index on the floor position for polygon queries, see MetersPerDegree
+	_, _, err = anchorsCol.EnsureGeoIndex(ctx, []string{"location"}, &driver.EnsureGeoIndexOptions{
+		Name:    "idx_geo_location",
+		GeoJSON: true,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create GeoJSON location index: %w", err)
+	}
+
+	// Index pose history by anchor, then time, for trajectory lookups
+	posesCol, err := conn.Database().Collection(ctx, AnchorPosesCollection)
+	if err != nil {
+		return fmt.Errorf("failed to get anchor poses collection: %w", err)
+	}
+
+	_, _, err = posesCol.EnsurePersistentIndex(ctx, []string{"anchor_id", "timestamp"}, &driver.EnsurePersistentIndexOptions{
+		Name:   "idx_pose_anchor_timestamp",
+		Unique: false,
+		Sparse: false,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create pose history index: %w", err)
+	}
+
+	// Index pose history by session, then time, for history queries and purges
+	_, _, err = posesCol.EnsurePersistentIndex(ctx, []string{"session_id", "timestamp"}, &driver.EnsurePersistentIndexOptions{
+		Name:   "idx_pose_session_timestamp",
+		Unique: false,
+		Sparse: false,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create pose session index: %w", err)
+	}
+
 	// Create indexes for meshes
 	// Index on anchor_id for fast lookups
 	_, _, err = meshesCol.EnsurePersistentIndex(ctx, []string{"anchor_id"}, &driver.EnsurePersistentIndexOptions{
@@ -112,6 +273,26 @@ func createIndexes(ctx context.Context, conn *Connection) error {
 		return fmt.Errorf("failed to create anchor_id index: %w", err)
 	}
 
+	// Index on session and anchor for session and per-anchor lookups
+	_, _, err = meshesCol.EnsurePersistentIndex(ctx, []string{"session_id", "anchor_id"}, &driver.EnsurePersistentIndexOptions{
+		Name:   "idx_mesh_session_anchor",
+		Unique: false,
+		Sparse: false,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create mesh session index: %w", err)
+	}
+
+	// Index on mesh id for direct and base mesh lookups
+	_, _, err = meshesCol.EnsurePersistentIndex(ctx, []string{"id"}, &driver.EnsurePersistentIndexOptions{
+		Name:   "idx_mesh_id",
+		Unique: false,
+		Sparse: false,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create mesh id index: %w", err)
+	}
+
 	// Index on hash for deduplication
 	_, _, err = meshesCol.EnsureHashIndex(ctx, []string{"hash"}, &driver.EnsureHashIndexOptions{
 		Name:   "idx_mesh_hash",
@@ -122,6 +303,16 @@ func createIndexes(ctx context.Context, conn *Connection) error {
 		return fmt.Errorf("failed to create hash index: %w", err)
 	}
 
+	// Index on referencing sessions for releasing shared meshes
+	_, _, err = meshesCol.EnsurePersistentIndex(ctx, []string{"referenced_by[*].session_id"}, &driver.EnsurePersistentIndexOptions{
+		Name:   "idx_mesh_referenced_by",
+		Unique: false,
+		Sparse: false,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create mesh references index: %w", err)
+	}
+
 	// Index on base_mesh_id for delta queries
 	_, _, err = meshesCol.EnsurePersistentIndex(ctx, []string{"base_mesh_id"}, &driver.EnsurePersistentIndexOptions{
 		Name:   "idx_base_mesh_id",
@@ -132,6 +323,58 @@ func createIndexes(ctx context.Context, conn *Connection) error {
 		return fmt.Errorf("failed to create base_mesh_id index: %w", err)
 	}
 
+	// Index on lod_of for level of detail lookups
+	_, _, err = meshesCol.EnsurePersistentIndex(ctx, []string{"lod_of"}, &driver.EnsurePersistentIndexOptions{
+		Name:   "idx_lod_of",
+		Unique: false,
+		Sparse: true,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create lod_of index: %w", err)
+	}
+
+	// Unique index on edge endpoints so each anchor pair is linked once
+	edgesCol, err := conn.Database().Collection(ctx, TopologyEdges)
+	if err != nil {
+		return fmt.Errorf("failed to get topology edges collection: %w", err)
+	}
+
+	_, _, err = edgesCol.EnsurePersistentIndex(ctx, []string{"_from", "_to"}, &driver.EnsurePersistentIndexOptions{
+		Name:   "idx_edge_endpoints",
+		Unique: true,
+		Sparse: false,
+	})
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("failed to create edge endpoints index: %w", err)
+	}
+
+	return nil
+}
+
+// createMetadataIndexes adds a persistent index per metadata key to anchors,
+// after session_id since queries name their session. Indexes of keys no
+// longer configured are left in place.
+func createMetadataIndexes(ctx context.Context, conn *Connection, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	anchorsCol, err := conn.Database().Collection(ctx, AnchorsCollection)
+	if err != nil {
+		return fmt.Errorf("failed to get anchors collection: %w", err)
+	}
+
+	for _, key := range keys {
+		_, _, err = anchorsCol.EnsurePersistentIndex(ctx, []string{"session_id", "metadata." + key}, &driver.EnsurePersistentIndexOptions{
+			Name:   "idx_anchor_metadata_" + key,
+			Unique: false,
+			Sparse: false,
+		})
+		if err != nil && !driver.IsConflict(err) {
+			return fmt.Errorf("failed to create metadata %s index: %w", key, err)
+		}
+	}
+
 	return nil
 }
 
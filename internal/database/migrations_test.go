@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memorySchema records migrations in memory, undoing those recorded in a
+// transaction that fails
+type memorySchema struct {
+	applied      []int
+	transactions [][]string // Collections of each transaction begun
+	aborted      int
+}
+
+func (m *memorySchema) SchemaVersion(ctx context.Context) (int, error) {
+	version := 0
+	for _, v := range m.applied {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+func (m *memorySchema) recordMigration(ctx context.Context, step migration) error {
+	m.applied = append(m.applied, step.version)
+	return nil
+}
+
+func (m *memorySchema) withTransaction(ctx context.Context, collections []string, fn func(ctx context.Context) error) error {
+	m.transactions = append(m.transactions, collections)
+	applied := len(m.applied)
+	if err := fn(ctx); err != nil {
+		m.applied = m.applied[:applied]
+		m.aborted++
+		return err
+	}
+	return nil
+}
+
+// countingSteps returns migrations 1 to n, appending their versions to ran
+// as they run
+func countingSteps(n int, ran *[]int) []migration {
+	steps := make([]migration, n)
+	for i := range steps {
+		version := i + 1
+		steps[i] = migration{version: version, name: "step", up: func(ctx context.Context, conn *Connection) error {
+			*ran = append(*ran, version)
+			return nil
+		}}
+	}
+	return steps
+}
+
+func TestApplyMigrationsOnMigratedDatabase(t *testing.T) {
+	var ran []int
+	steps := countingSteps(4, &ran)
+
+	// A database migrated by an older build, to version 4
+	store := &memorySchema{applied: []int{1, 2, 3, 4}}
+
+	// A new data migration runs alone, in a transaction over what it writes
+	steps = append(steps, migration{version: 5, name: "backfill", writes: []string{AnchorsCollection}, up: func(ctx context.Context, conn *Connection) error {
+		ran = append(ran, 5)
+		return nil
+	}})
+	if err := applyMigrations(context.Background(), store, nil, steps, 0); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if !reflect.DeepEqual(ran, []int{5}) || !reflect.DeepEqual(store.applied, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("Expected only migration 5 applied and recorded, got %v run and %v recorded", ran, store.applied)
+	}
+	if want := [][]string{{MigrationsCollection, AnchorsCollection}}; !reflect.DeepEqual(store.transactions, want) {
+		t.Errorf("Expected one transaction over %v, got %v", want, store.transactions)
+	}
+
+	// Migrating again applies nothing
+	ran = nil
+	if err := applyMigrations(context.Background(), store, nil, steps, 0); err != nil {
+		t.Fatalf("Failed to migrate again: %v", err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("Expected nothing applied at version 5, got %v", ran)
+	}
+}
+
+func TestApplyMigrationsResumes(t *testing.T) {
+	var ran []int
+	steps := countingSteps(3, &ran)
+	failing := append(append([]migration(nil), steps...), migration{version: 4, name: "broken", writes: []string{MeshesCollection}, up: func(ctx context.Context, conn *Connection) error {
+		return errors.New("conflict")
+	}})
+
+	// A failed step is rolled back and leaves the steps before it recorded
+	store := &memorySchema{applied: []int{1}}
+	if err := applyMigrations(context.Background(), store, nil, failing, 0); err == nil {
+		t.Fatal("Expected the failed step to fail the migration")
+	}
+	if version, _ := store.SchemaVersion(context.Background()); version != 3 || store.aborted != 1 {
+		t.Errorf("Expected version 3 after aborting migration 4, got version %d after %d aborts", version, store.aborted)
+	}
+	if !reflect.DeepEqual(ran, []int{2, 3}) {
+		t.Errorf("Expected migrations 2 and 3 run, got %v", ran)
+	}
+
+	// The next migration resumes from the step that failed
+	ran = nil
+	if err := applyMigrations(context.Background(), store, nil, countingSteps(5, &ran), 0); err != nil {
+		t.Fatalf("Failed to resume migration: %v", err)
+	}
+	if !reflect.DeepEqual(ran, []int{4, 5}) {
+		t.Errorf("Expected migrations 4 and 5 run on resuming, got %v", ran)
+	}
+}
+
+func TestApplyMigrationsDataDeadline(t *testing.T) {
+	deadlines := make(map[int]time.Time)
+	step := func(version int, data bool) migration {
+		return migration{version: version, name: "step", data: data, up: func(ctx context.Context, conn *Connection) error {
+			deadlines[version], _ = ctx.Deadline()
+			return ctx.Err()
+		}}
+	}
+	steps := []migration{step(1, false), step(2, true)}
+
+	// Data migrations outlive the schema setup deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if err := applyMigrations(ctx, &memorySchema{}, nil, steps, 0); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if deadlines[1].IsZero() || !deadlines[2].IsZero() {
+		t.Errorf("Expected only the schema step under a deadline, got %v", deadlines)
+	}
+
+	// A data timeout replaces the schema setup deadline
+	start := time.Now()
+	if err := applyMigrations(ctx, &memorySchema{}, nil, steps, time.Minute); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if deadlines[2].Before(start) || deadlines[2].After(start.Add(2*time.Minute)) {
+		t.Errorf("Expected the data step due within a minute, got %v", deadlines[2])
+	}
+
+	// An expired schema setup deadline leaves data migrations running
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := applyMigrations(expired, &memorySchema{applied: []int{1}}, nil, steps, 0)
+	if err != nil {
+		t.Errorf("Expected the data step to run past a cancelled setup, got %v", err)
+	}
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, step := range migrations {
+		if step.version != i+1 {
+			t.Errorf("Expected migration %q at version %d, got %d", step.name, i+1, step.version)
+		}
+	}
+	if SchemaVersion != len(migrations) {
+		t.Errorf("Expected schema version %d, got %d", len(migrations), SchemaVersion)
+	}
+}
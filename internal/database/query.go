@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/arangodb/go-driver"
+)
+
+type queryTimeoutKey struct{}
+
+// WithQueryTimeout returns a context whose AQL queries are limited to timeout
+// instead of the configured default; 0 disables the limit
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, timeout)
+}
+
+// QueryContext derives the context for one AQL query. The query is given a
+// deadline and ArangoDB is asked to kill it at the same time, so a slow query
+// neither holds the connection nor keeps running on the server. The timeout set
+// by WithQueryTimeout takes precedence over fallback.
+func QueryContext(ctx context.Context, fallback time.Duration) (context.Context, context.CancelFunc) {
+	timeout := fallback
+	if override, ok := ctx.Value(queryTimeoutKey{}).(time.Duration); ok {
+		timeout = override
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	ctx = driver.WithQueryMaxRuntime(ctx, timeout.Seconds())
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	arangohttp "github.com/arangodb/go-driver/http"
+)
+
+func TestQueryContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		fallback     time.Duration
+		wantDeadline time.Duration
+	}{
+		{"Fallback", context.Background(), time.Second, time.Second},
+		{"Override", WithQueryTimeout(context.Background(), time.Minute), time.Second, time.Minute},
+		{"Disabled", context.Background(), 0, 0},
+		{"OverrideDisables", WithQueryTimeout(context.Background(), 0), time.Second, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := QueryContext(tt.ctx, tt.fallback)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if tt.wantDeadline == 0 {
+				if ok {
+					t.Errorf("Expected no deadline, got %v", time.Until(deadline))
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("Expected a deadline")
+			}
+			if remaining := time.Until(deadline); remaining > tt.wantDeadline || remaining < tt.wantDeadline-time.Second {
+				t.Errorf("Expected deadline in %v, got %v", tt.wantDeadline, remaining)
+			}
+		})
+	}
+}
+
+// slowDatabase opens a database on a fake ArangoDB whose cursor endpoint
+// never answers in time. The returned function reports the maxRuntime of the
+// last query sent.
+func slowDatabase(t *testing.T) (driver.Database, func() float64) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var maxRuntime float64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_api/database/current") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"error":false,"code":200,"result":{"name":"stag","id":"1","path":"","isSystem":false}}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/_api/cursor") {
+			var request struct {
+				Options struct {
+					MaxRuntime float64 `json:"maxRuntime"`
+				} `json:"options"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			mu.Lock()
+			maxRuntime = request.Options.MaxRuntime
+			mu.Unlock()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := arangohttp.NewConnection(arangohttp.ConnectionConfig{Endpoints: []string{server.URL}})
+	if err != nil {
+		t.Fatalf("Failed to create connection: %v", err)
+	}
+	client, err := driver.NewClient(driver.ClientConfig{Connection: conn})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	db, err := client.Database(context.Background(), "stag")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	return db, func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return maxRuntime
+	}
+}
+
+func TestQueryContextTimesOutSlowQuery(t *testing.T) {
+	db, maxRuntime := slowDatabase(t)
+
+	ctx, cancel := QueryContext(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := db.Query(ctx, "FOR a IN anchors RETURN a", nil)
+	if err == nil {
+		t.Fatal("Expected the slow query to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the query to be abandoned at its deadline, took %v", elapsed)
+	}
+	if class := ClassifyError(err); class != ErrorClassTimeout {
+		t.Errorf("Expected class %s, got %s (%v)", ErrorClassTimeout, class, err)
+	}
+	if got := maxRuntime(); got != 0.05 {
+		t.Errorf("Expected maxRuntime 0.05 to be sent, got %v", got)
+	}
+}
+
+func TestRequestContextEndsQuery(t *testing.T) {
+	db, _ := slowDatabase(t)
+
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want ErrorClass
+	}{
+		// The request's deadline passes long before the query's own limit
+		{"Deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}, ErrorClassTimeout},
+		// The client disconnects while the query runs
+		{"Disconnect", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			return ctx, cancel
+		}, ErrorClassCanceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, cancelParent := tt.ctx()
+			defer cancelParent()
+			ctx, cancel := QueryContext(parent, 10*time.Second)
+			defer cancel()
+
+			start := time.Now()
+			_, err := db.Query(ctx, "FOR a IN anchors RETURN a", nil)
+			if err == nil {
+				t.Fatal("Expected the query to fail")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Expected the query to be abandoned with the request, took %v", elapsed)
+			}
+			if class := ClassifyError(err); class != tt.want {
+				t.Errorf("Expected class %s, got %s (%v)", tt.want, class, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/arangodb/go-driver"
+)
+
+// SchemaVersion returns the schema version of the database, the latest
+// migration applied to it; 0 if it has never been migrated or was migrated
+// before migrations were recorded
+func (c *Connection) SchemaVersion(ctx context.Context) (int, error) {
+	exists, err := c.database.CollectionExists(ctx, MigrationsCollection)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check migrations collection: %w", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	query := `
+		FOR m IN @@migrations
+		COLLECT AGGREGATE version = MAX(m.version)
+		RETURN NOT_NULL(version, 0)
+	`
+	cursor, err := c.database.Query(ctx, query, map[string]interface{}{
+		"@migrations": MigrationsCollection,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	defer cursor.Close()
+
+	var version int
+	if _, err := cursor.ReadDocument(ctx, &version); err != nil && !driver.IsNoMoreDocuments(err) {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// recordMigration records step as applied, keyed by its version so that
+// instances migrating concurrently record it once
+func (c *Connection) recordMigration(ctx context.Context, step migration) error {
+	query := `
+		UPSERT { _key: @key }
+		INSERT { _key: @key, version: @version, name: @name, applied_at: DATE_ISO8601(DATE_NOW()) }
+		UPDATE {}
+		IN @@migrations
+	`
+	if err := runMigrationQuery(ctx, c, query, map[string]interface{}{
+		"@migrations": MigrationsCollection,
+		"key":         strconv.Itoa(step.version),
+		"version":     step.version,
+		"name":        step.name,
+	}); err != nil {
+		return fmt.Errorf("failed to record schema version %d: %w", step.version, err)
+	}
+	return nil
+}
+
+// withTransaction runs fn inside a stream transaction writing collections,
+// committing on success and aborting on error
+func (c *Connection) withTransaction(ctx context.Context, collections []string, fn func(ctx context.Context) error) error {
+	tid, err := c.database.BeginTransaction(ctx, driver.TransactionCollections{Write: collections}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(driver.WithTransactionID(ctx, tid)); err != nil {
+		if abortErr := c.database.AbortTransaction(ctx, tid, nil); abortErr != nil {
+			return fmt.Errorf("%w (and failed to abort transaction %s: %v)", err, tid, abortErr)
+		}
+		return err
+	}
+
+	if err := c.database.CommitTransaction(ctx, tid, nil); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
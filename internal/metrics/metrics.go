@@ -1,8 +1,27 @@
 package metrics
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// Ways of reporting the session_id label
+const (
+	SessionLabelKeep = "keep" // The session ID as is
+	SessionLabelHash = "hash" // A short digest of the session ID
+	SessionLabelDrop = "drop" // Empty, so all sessions share one series
 )
 
 // Metrics holds all Prometheus metrics
@@ -10,35 +29,90 @@ type Metrics struct {
 	// HTTP metrics
 	HTTPRequestsTotal   *prometheus.CounterVec
 	HTTPRequestDuration *prometheus.HistogramVec
+	InFlightRequests    *prometheus.GaugeVec
 	
 	// WebSocket metrics
 	WSConnectionsActive *prometheus.GaugeVec
 	WSMessagesTotal     *prometheus.CounterVec
+	WSOutboundBytes     *prometheus.CounterVec
+	WSDisconnectsTotal  *prometheus.CounterVec
+	WSDeadLettersTotal  *prometheus.CounterVec
+	WSDeadLettersQueued prometheus.Gauge
+	WSDroppedMessages   *prometheus.CounterVec
+	WSSlowDisconnects   *prometheus.CounterVec
 	
 	// Database metrics
 	DBOperationsTotal   *prometheus.CounterVec
 	DBOperationDuration *prometheus.HistogramVec
+	DBInFlightQueries   prometheus.Gauge
+	DBRetriesTotal      *prometheus.CounterVec
 	
 	// Business metrics
 	AnchorsTotal         *prometheus.CounterVec
 	MeshesTotal          *prometheus.CounterVec
 	CompressionRatio     *prometheus.GaugeVec
+	MeshCompressionLevel *prometheus.SummaryVec
 	StorageSizeBytes     *prometheus.GaugeVec
 	MeshDedupSavedBytes  *prometheus.CounterVec
+	MeshDedupCacheSize   prometheus.Gauge
+	MeshDedupCacheHits   prometheus.Counter
+	MeshDedupCacheMisses prometheus.Counter
+	AnchorsSuppressed    *prometheus.CounterVec
+	IngestBatchSize      prometheus.Histogram
+	IngestQueueDepth     prometheus.Gauge
+	IngestQueueWait      prometheus.Histogram
+	IngestQueueRejected  *prometheus.CounterVec
+	MeshBytes            *prometheus.HistogramVec
+	AnchorBytes          prometheus.Histogram
+	ExportBytes          *prometheus.HistogramVec
+	IdempotentReplays    prometheus.Counter
+	MeshChecksumFailures *prometheus.CounterVec
+	MeshObjectOperations *prometheus.CounterVec
+	RetentionExpired     *prometheus.CounterVec
+	RetentionRuns        *prometheus.CounterVec
+	PoseSamplesPruned    prometheus.Counter
+	MeshUploads          *prometheus.CounterVec
+	MeshUploadBytes      prometheus.Gauge
+	AuditFailures        prometheus.Counter
+
+	sessionLabel string
+	registry     *prometheus.Registry
+	log          logger.Logger
+
+	// Last time each session's business series were recorded, so ended
+	// sessions can be forgotten
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
 }
 
-// New creates a new metrics instance
-func New() *Metrics {
+// New creates a new metrics instance, registered with a registry of its own
+// rather than the global default. A metric that fails to register is logged
+// and left out of the exposition instead of stopping the process; it can
+// still be recorded.
+func New(cfg config.MetricsConfig, log logger.Logger) *Metrics {
+	registry := prometheus.NewRegistry()
+	reg := &registerer{Registry: registry, log: log}
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	factory := promauto.With(reg)
+
 	return &Metrics{
+		sessionLabel: cfg.SessionLabel,
+		registry:     registry,
+		log:          log,
+		lastSeen:     make(map[string]time.Time),
+
 		// HTTP metrics
-		HTTPRequestsTotal: promauto.NewCounterVec(
+		HTTPRequestsTotal: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "stag_http_requests_total",
 				Help: "Total number of HTTP requests",
 			},
 			[]string{"method", "endpoint", "status"},
 		),
-		HTTPRequestDuration: promauto.NewHistogramVec(
+		HTTPRequestDuration: factory.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "stag_http_request_duration_seconds",
 				Help:    "HTTP request duration in seconds",
@@ -46,32 +120,80 @@ func New() *Metrics {
 			},
 			[]string{"method", "endpoint"},
 		),
+		InFlightRequests: factory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "stag_http_requests_in_flight",
+				Help: "Number of HTTP requests currently being served",
+			},
+			[]string{"endpoint"},
+		),
 		
 		// WebSocket metrics
-		WSConnectionsActive: promauto.NewGaugeVec(
+		WSConnectionsActive: factory.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "stag_ws_connections_active",
 				Help: "Number of active WebSocket connections",
 			},
 			[]string{"session_id"},
 		),
-		WSMessagesTotal: promauto.NewCounterVec(
+		WSMessagesTotal: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "stag_ws_messages_total",
 				Help: "Total number of WebSocket messages",
 			},
 			[]string{"direction", "type", "status"},
 		),
+		WSOutboundBytes: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_ws_outbound_bytes_total",
+				Help: "WebSocket bytes sent, as message payloads before compression and as written to the network",
+			},
+			[]string{"encoding"},
+		),
+		WSDisconnectsTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_ws_disconnects_total",
+				Help: "WebSocket connections closed by the server, by reason",
+			},
+			[]string{"reason"},
+		),
+		WSDeadLettersTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_ws_dead_letters_total",
+				Help: "WebSocket updates dead-lettered after failing processing, by type and error class",
+			},
+			[]string{"type", "error_class"},
+		),
+		WSDeadLettersQueued: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "stag_ws_dead_letters_queued",
+				Help: "Number of dead-lettered WebSocket updates held for inspection or retry",
+			},
+		),
+		WSDroppedMessages: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_ws_dropped_messages_total",
+				Help: "Outbound WebSocket messages dropped because the client's send queue was full, by the client's session",
+			},
+			[]string{"session_id"},
+		),
+		WSSlowDisconnects: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_ws_slow_consumer_disconnects_total",
+				Help: "WebSocket clients disconnected after their send queue stayed overflowed, by the client's session",
+			},
+			[]string{"session_id"},
+		),
 		
 		// Database metrics
-		DBOperationsTotal: promauto.NewCounterVec(
+		DBOperationsTotal: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "stag_db_operations_total",
 				Help: "Total number of database operations",
 			},
 			[]string{"operation", "collection", "status"},
 		),
-		DBOperationDuration: promauto.NewHistogramVec(
+		DBOperationDuration: factory.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "stag_db_operation_duration_seconds",
 				Help:    "Database operation duration in seconds",
@@ -79,42 +201,331 @@ func New() *Metrics {
 			},
 			[]string{"operation", "collection"},
 		),
+		DBInFlightQueries: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "stag_db_queries_in_flight",
+				Help: "Number of AQL queries awaiting a response from ArangoDB",
+			},
+		),
+		DBRetriesTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_db_retries_total",
+				Help: "Database writes retried after a transient failure, by error class",
+			},
+			[]string{"operation", "class"},
+		),
 		
 		// Business metrics
-		AnchorsTotal: promauto.NewCounterVec(
+		AnchorsTotal: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "stag_anchors_total",
 				Help: "Total number of anchors processed",
 			},
 			[]string{"session_id", "operation"},
 		),
-		MeshesTotal: promauto.NewCounterVec(
+		MeshesTotal: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "stag_meshes_total",
 				Help: "Total number of meshes processed",
 			},
 			[]string{"session_id", "type", "operation"},
 		),
-		CompressionRatio: promauto.NewGaugeVec(
+		CompressionRatio: factory.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "stag_compression_ratio",
 				Help: "Current compression ratio",
 			},
 			[]string{"session_id"},
 		),
-		StorageSizeBytes: promauto.NewGaugeVec(
+		MeshCompressionLevel: factory.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Name: "stag_mesh_compression_level",
+				Help: "Storage compression level of stored meshes; sum over count is the average",
+			},
+			[]string{"session_id"},
+		),
+		StorageSizeBytes: factory.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "stag_storage_size_bytes",
 				Help: "Total storage size in bytes",
 			},
 			[]string{"type"},
 		),
-		MeshDedupSavedBytes: promauto.NewCounterVec(
+		MeshDedupSavedBytes: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "stag_mesh_dedup_saved_bytes",
 				Help: "Bytes saved through mesh deduplication",
 			},
 			[]string{"session_id"},
 		),
+		MeshDedupCacheSize: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "stag_mesh_dedup_cache_entries",
+				Help: "Number of mesh hashes held in the dedup cache",
+			},
+		),
+		MeshDedupCacheHits: factory.NewCounter(
+			prometheus.CounterOpts{
+				Name: "stag_mesh_dedup_cache_hits_total",
+				Help: "Ingested mesh hashes found in the dedup cache",
+			},
+		),
+		MeshDedupCacheMisses: factory.NewCounter(
+			prometheus.CounterOpts{
+				Name: "stag_mesh_dedup_cache_misses_total",
+				Help: "Ingested mesh hashes not in the dedup cache, looked up in the database instead",
+			},
+		),
+		AnchorsSuppressed: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_anchor_dedup_suppressed_total",
+				Help: "Ingested anchor updates neither stored nor broadcast for repeating the last stored pose",
+			},
+			[]string{"session_id"},
+		),
+		IngestBatchSize: factory.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "stag_ingest_batch_size",
+				Help:    "Number of events per batch ingest request",
+				Buckets: []float64{1, 2, 5, 10, 25, 50, 100, 250, 500},
+			},
+		),
+		IngestQueueDepth: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "stag_ingest_queue_depth",
+				Help: "Ingest requests waiting for a worker",
+			},
+		),
+		IngestQueueWait: factory.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "stag_ingest_queue_wait_seconds",
+				Help:    "Time ingest requests waited for a worker",
+				Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30},
+			},
+		),
+		IngestQueueRejected: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_ingest_queue_rejected_total",
+				Help: "Ingest requests not processed, as the queue was full or the request was canceled while queued",
+			},
+			[]string{"reason"},
+		),
+		MeshBytes: factory.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "stag_mesh_bytes",
+				Help:    "Size of ingested mesh vertices, faces and normals as received, before storage compression",
+				Buckets: prometheus.ExponentialBuckets(1<<10, 4, 9), // 1 KiB to 64 MiB
+			},
+			[]string{"type"},
+		),
+		AnchorBytes: factory.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "stag_anchor_bytes",
+				Help:    "Size of ingested anchors, pose and metadata, as JSON",
+				Buckets: prometheus.ExponentialBuckets(64, 4, 9), // 64 B to 4 MiB
+			},
+		),
+		ExportBytes: factory.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "stag_export_bytes",
+				Help:    "Size of glTF exports and snapshots as sent, after their content encoding",
+				Buckets: prometheus.ExponentialBuckets(1<<10, 4, 11), // 1 KiB to 1 GiB
+			},
+			[]string{"endpoint", "encoding"},
+		),
+		IdempotentReplays: factory.NewCounter(
+			prometheus.CounterOpts{
+				Name: "stag_ingest_idempotent_replays_total",
+				Help: "Retried ingest requests answered with the original response",
+			},
+		),
+		MeshChecksumFailures: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_mesh_checksum_failures_total",
+				Help: "Mesh updates rejected for a checksum mismatch",
+			},
+			[]string{"session_id"},
+		),
+		MeshObjectOperations: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_mesh_object_operations_total",
+				Help: "Mesh buffer reads, writes and deletes against object storage, by operation and result",
+			},
+			[]string{"operation", "result"},
+		),
+		RetentionExpired: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_retention_expired_total",
+				Help: "Sessions and documents deleted by retention sweeps, by kind",
+			},
+			[]string{"kind"},
+		),
+		RetentionRuns: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_retention_runs_total",
+				Help: "Retention sweeps, by result",
+			},
+			[]string{"result"},
+		),
+		PoseSamplesPruned: factory.NewCounter(
+			prometheus.CounterOpts{
+				Name: "stag_pose_samples_pruned_total",
+				Help: "Pose history samples deleted to keep anchors within the pose history limits",
+			},
+		),
+		MeshUploads: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "stag_mesh_uploads_total",
+				Help: "Chunked mesh uploads finished, by result",
+			},
+			[]string{"result"},
+		),
+		MeshUploadBytes: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "stag_mesh_upload_buffered_bytes",
+				Help: "Chunk bytes held in memory for unfinished mesh uploads",
+			},
+		),
+		AuditFailures: factory.NewCounter(
+			prometheus.CounterOpts{
+				Name: "stag_audit_failures_total",
+				Help: "Audit records that could not be written",
+			},
+		),
 	}
-}
\ No newline at end of file
+}
+
+// SessionLabel returns the session_id label value to record for sessionID.
+// Prometheus treats an empty label as absent, so dropped labels collapse
+// every session into one series.
+func (m *Metrics) SessionLabel(sessionID string) string {
+	switch m.sessionLabel {
+	case SessionLabelHash:
+		sum := sha256.Sum256([]byte(sessionID))
+		return hex.EncodeToString(sum[:6])
+	case SessionLabelDrop:
+		return ""
+	default:
+		return sessionID
+	}
+}
+
+// SessionSeries returns the session_id label value for recording a business
+// metric of sessionID, noting the session as active
+func (m *Metrics) SessionSeries(sessionID string) string {
+	label := m.SessionLabel(sessionID)
+	if label != "" {
+		m.mu.Lock()
+		if m.lastSeen == nil {
+			m.lastSeen = make(map[string]time.Time)
+		}
+		m.lastSeen[sessionID] = time.Now()
+		m.mu.Unlock()
+	}
+	return label
+}
+
+// ForgetSession deletes the business series of a session that has ended.
+// Dropped labels are shared by every session and are never deleted.
+func (m *Metrics) ForgetSession(sessionID string) {
+	m.mu.Lock()
+	delete(m.lastSeen, sessionID)
+	m.mu.Unlock()
+
+	label := m.SessionLabel(sessionID)
+	if label == "" {
+		return
+	}
+
+	m.AnchorsTotal.DeletePartialMatch(prometheus.Labels{"session_id": label})
+	m.MeshesTotal.DeletePartialMatch(prometheus.Labels{"session_id": label})
+	m.CompressionRatio.DeleteLabelValues(label)
+	m.MeshCompressionLevel.DeleteLabelValues(label)
+	m.MeshDedupSavedBytes.DeleteLabelValues(label)
+	m.AnchorsSuppressed.DeleteLabelValues(label)
+	m.MeshChecksumFailures.DeleteLabelValues(label)
+	m.WSDroppedMessages.DeleteLabelValues(label)
+	m.WSSlowDisconnects.DeleteLabelValues(label)
+}
+
+// ForgetIdleSessions forgets sessions whose business series were last
+// recorded before cutoff and returns how many were forgotten
+func (m *Metrics) ForgetIdleSessions(cutoff time.Time) int {
+	m.mu.Lock()
+	var idle []string
+	for sessionID, seen := range m.lastSeen {
+		if seen.Before(cutoff) {
+			idle = append(idle, sessionID)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, sessionID := range idle {
+		m.ForgetSession(sessionID)
+	}
+	return len(idle)
+}
+
+// ForgetSessionConnections deletes the connection gauge of a session once its
+// last WebSocket client has left
+func (m *Metrics) ForgetSessionConnections(sessionID string) {
+	if label := m.SessionLabel(sessionID); label != "" {
+		m.WSConnectionsActive.DeleteLabelValues(label)
+	}
+}
+
+// Handler serves the registered metrics. A metric that fails to collect is
+// logged and left out of the scrape, rather than failing it.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{
+		ErrorLog:      scrapeErrorLog{log: m.log},
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+}
+
+// registerer registers collectors with a registry, logging those that fail,
+// such as a metric whose name is already taken, instead of panicking
+type registerer struct {
+	*prometheus.Registry
+	log logger.Logger
+}
+
+// MustRegister registers each collector, logging failures
+func (r *registerer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			r.log.Errorf("Failed to register metric %s, it will not be exported: %v", collectorName(c), err)
+		}
+	}
+}
+
+// collectorName returns the name of a collector's first metric
+func collectorName(c prometheus.Collector) string {
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(descs)
+		close(descs)
+	}()
+
+	name := ""
+	for desc := range descs {
+		if name != "" {
+			continue
+		}
+		// Descriptors expose their name only through their description
+		name = desc.String()
+		if _, rest, ok := strings.Cut(name, `fqName: "`); ok {
+			name, _, _ = strings.Cut(rest, `"`)
+		}
+	}
+	return name
+}
+
+// scrapeErrorLog logs errors met while serving a scrape
+type scrapeErrorLog struct {
+	log logger.Logger
+}
+
+func (l scrapeErrorLog) Println(v ...interface{}) {
+	l.log.Error(append([]interface{}{"Failed to collect metrics: "}, v...)...)
+}
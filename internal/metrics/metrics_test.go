@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestSessionLabel(t *testing.T) {
+	keep := &Metrics{sessionLabel: SessionLabelKeep}
+	hash := &Metrics{sessionLabel: SessionLabelHash}
+	drop := &Metrics{sessionLabel: SessionLabelDrop}
+
+	if got := keep.SessionLabel("scan-1"); got != "scan-1" {
+		t.Errorf("Expected the session ID kept, got %q", got)
+	}
+	if got := (&Metrics{}).SessionLabel("scan-1"); got != "scan-1" {
+		t.Errorf("Expected the session ID kept by default, got %q", got)
+	}
+
+	hashed := hash.SessionLabel("scan-1")
+	if hashed == "scan-1" || len(hashed) != 12 || hashed != hash.SessionLabel("scan-1") {
+		t.Errorf("Expected a stable 12 character digest, got %q", hashed)
+	}
+	if hashed == hash.SessionLabel("scan-2") {
+		t.Error("Expected distinct sessions to hash differently")
+	}
+
+	if got := drop.SessionLabel("scan-1"); got != "" {
+		t.Errorf("Expected the label dropped, got %q", got)
+	}
+}
+
+func TestForgetSession(t *testing.T) {
+	m := &Metrics{
+		sessionLabel:         SessionLabelKeep,
+		lastSeen:             make(map[string]time.Time),
+		AnchorsTotal:         prometheus.NewCounterVec(prometheus.CounterOpts{Name: "anchors"}, []string{"session_id", "operation"}),
+		MeshesTotal:          prometheus.NewCounterVec(prometheus.CounterOpts{Name: "meshes"}, []string{"session_id", "type", "operation"}),
+		CompressionRatio:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "ratio"}, []string{"session_id"}),
+		MeshCompressionLevel: prometheus.NewSummaryVec(prometheus.SummaryOpts{Name: "level"}, []string{"session_id"}),
+		MeshDedupSavedBytes:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "saved"}, []string{"session_id"}),
+		AnchorsSuppressed:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "suppressed"}, []string{"session_id"}),
+		MeshChecksumFailures: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "checksum"}, []string{"session_id"}),
+		WSConnectionsActive:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "connections"}, []string{"session_id"}),
+		WSDroppedMessages:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dropped"}, []string{"session_id"}),
+		WSSlowDisconnects:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "slow"}, []string{"session_id"}),
+	}
+
+	for _, sessionID := range []string{"old", "active"} {
+		m.AnchorsTotal.WithLabelValues(m.SessionSeries(sessionID), "ingest").Add(3)
+		m.MeshesTotal.WithLabelValues(m.SessionSeries(sessionID), "full", "ingest").Inc()
+		m.CompressionRatio.WithLabelValues(m.SessionSeries(sessionID)).Set(0.5)
+		m.MeshCompressionLevel.WithLabelValues(m.SessionSeries(sessionID)).Observe(3)
+	}
+	m.lastSeen["old"] = time.Now().Add(-time.Hour)
+
+	if forgotten := m.ForgetIdleSessions(time.Now().Add(-time.Minute)); forgotten != 1 {
+		t.Fatalf("Expected 1 idle session forgotten, got %d", forgotten)
+	}
+	if got := testutil.CollectAndCount(m.AnchorsTotal); got != 1 {
+		t.Errorf("Expected only the active session's anchor series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(m.MeshesTotal); got != 1 {
+		t.Errorf("Expected only the active session's mesh series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(m.MeshCompressionLevel); got != 1 {
+		t.Errorf("Expected only the active session's compression level series, got %d", got)
+	}
+	if got := testutil.ToFloat64(m.AnchorsTotal.WithLabelValues("active", "ingest")); got != 3 {
+		t.Errorf("Expected the active session's count kept, got %v", got)
+	}
+
+	m.WSConnectionsActive.WithLabelValues(m.SessionLabel("active")).Inc()
+	m.WSConnectionsActive.WithLabelValues(m.SessionLabel("active")).Dec()
+	m.ForgetSessionConnections("active")
+	if got := testutil.CollectAndCount(m.WSConnectionsActive); got != 0 {
+		t.Errorf("Expected the connection gauge deleted, got %d series", got)
+	}
+
+	// A dropped label is shared by every session and survives
+	m.sessionLabel = SessionLabelDrop
+	m.AnchorsTotal.WithLabelValues(m.SessionSeries("active"), "ingest").Inc()
+	m.ForgetSession("active")
+	if got := testutil.ToFloat64(m.AnchorsTotal.WithLabelValues("", "ingest")); got != 1 {
+		t.Errorf("Expected the aggregate series kept, got %v", got)
+	}
+}
+
+func TestNewIsolatesRegistries(t *testing.T) {
+	log := logger.New(logger.Config{})
+	first := New(config.MetricsConfig{}, log)
+	second := New(config.MetricsConfig{}, log) // Would panic on a shared registry
+
+	first.IdempotentReplays.Inc()
+	if got := testutil.ToFloat64(second.IdempotentReplays); got != 0 {
+		t.Errorf("Expected separate metrics, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	first.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, name := range []string{"stag_ingest_idempotent_replays_total 1", "go_goroutines", "process_"} {
+		if w.Code != http.StatusOK || !strings.Contains(body, name) {
+			t.Errorf("Expected %q exposed, got %d: %.200s", name, w.Code, body)
+		}
+	}
+}
+
+func TestRegisterFailureIsLogged(t *testing.T) {
+	var out bytes.Buffer
+	log := logger.New(logger.Config{})
+	log.(*logger.LogrusLogger).Logger.SetOutput(&out)
+
+	reg := &registerer{Registry: prometheus.NewRegistry(), log: log}
+	first := prometheus.NewCounter(prometheus.CounterOpts{Name: "stag_duplicate_total", Help: "First"})
+	second := prometheus.NewCounter(prometheus.CounterOpts{Name: "stag_duplicate_total", Help: "Second"})
+	reg.MustRegister(first)
+	reg.MustRegister(second) // Logged rather than panicking
+
+	if !strings.Contains(out.String(), "Failed to register metric stag_duplicate_total") {
+		t.Errorf("Expected the failed registration logged, got %q", out.String())
+	}
+
+	// The unregistered metric can still be recorded, it is just not exported
+	second.Inc()
+	first.Add(2)
+	if count, err := testutil.GatherAndCount(reg.Registry, "stag_duplicate_total"); err != nil || count != 1 {
+		t.Errorf("Expected only the first metric exported, got %d: %v", count, err)
+	}
+}
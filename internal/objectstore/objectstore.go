@@ -0,0 +1,82 @@
+// Package objectstore keeps large mesh buffers outside the database, in a
+// local directory or an S3-compatible bucket
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+// Backends an object store may use
+const (
+	BackendDatabase   = "database" // No object store; buffers stay in mesh documents
+	BackendFilesystem = "filesystem"
+	BackendS3         = "s3"
+)
+
+// ErrNotFound is returned for objects that are not stored
+var ErrNotFound = errors.New("object not found")
+
+// Store holds objects by key. Objects are written once and never change, so
+// writing an existing key again is harmless, and are deleted once nothing
+// refers to them.
+type Store interface {
+	// Put stores data under key
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Get returns the object stored under key, or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Exists reports whether an object is stored under key
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete removes the object stored under key; deleting a missing object
+	// is not an error
+	Delete(ctx context.Context, key string) error
+
+	// Backend names the kind of store
+	Backend() string
+
+	// Bucket names where objects are kept: a bucket or a directory
+	Bucket() string
+}
+
+// New creates the configured store, or returns nil when mesh buffers are
+// kept in the database
+func New(cfg config.MeshStorageConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", BackendDatabase:
+		return nil, nil
+	case BackendFilesystem:
+		store, err := NewFileStore(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case BackendS3:
+		store, err := NewS3Store(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown mesh storage backend %q", cfg.Backend)
+	}
+}
+
+// validKey reports whether key is a relative slash-separated path without
+// empty, "." or ".." segments, so it cannot escape a store's root
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// AdminHandler handles operator requests on server internals
+type AdminHandler struct {
+	repository *spatial.Repository
+	logger     logger.Logger
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(repository *spatial.Repository, logger logger.Logger) *AdminHandler {
+	return &AdminHandler{
+		repository: repository,
+		logger:     logger,
+	}
+}
+
+// Dedup handles GET /api/v1/admin/dedup
+func (h *AdminHandler) Dedup(c *gin.Context) {
+	var params api.DedupStatsParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logger.Warnf("Invalid dedup stats parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
+	}
+
+	if params.Limit <= 0 {
+		params.Limit = 10
+	}
+	if params.Limit > 100 {
+		params.Limit = 100
+	}
+
+	stats, err := h.repository.DedupStats(c.Request.Context(), params.Limit)
+	if err != nil {
+		h.respondError(c, "Failed to get dedup stats", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// RebuildDedup handles POST /api/v1/admin/dedup/rebuild
+func (h *AdminHandler) RebuildDedup(c *gin.Context) {
+	result, err := h.repository.RebuildDedupCache(c.Request.Context())
+	if err != nil {
+		h.respondError(c, "Failed to rebuild dedup cache", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Snapshot handles GET /api/v1/admin/snapshot, streaming a tar archive of the
+// stored documents
+func (h *AdminHandler) Snapshot(c *gin.Context) {
+	var params api.SnapshotParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logger.Warnf("Invalid snapshot parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
+	}
+
+	filename := "stag-snapshot.tar"
+	if params.SessionID != "" {
+		filename = "stag-snapshot-" + params.SessionID + ".tar"
+	}
+
+	started := false
+	out := writerFunc(func(p []byte) (int, error) {
+		if !started {
+			c.Header("Content-Type", "application/x-tar")
+			c.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+			c.Status(http.StatusOK)
+			started = true
+
+			// A large snapshot outlasts the server write timeout
+			clearWriteDeadline(c, h.logger)
+		}
+		return c.Writer.Write(p)
+	})
+
+	if err := h.repository.WriteSnapshot(c.Request.Context(), params.SessionID, out); err != nil {
+		// Once streaming has begun the status is sent, so the error can only
+		// end the archive early
+		if started {
+			h.logger.Errorf("Snapshot ended early: %v", err)
+			return
+		}
+		h.respondError(c, "Failed to write snapshot", err)
+	}
+}
+
+// RestoreSnapshot handles POST /api/v1/admin/snapshot, restoring an archive
+// written by Snapshot
+func (h *AdminHandler) RestoreSnapshot(c *gin.Context) {
+	// A large snapshot outlasts the server read timeout, and its restore the
+	// write timeout
+	clearDeadlines(c, h.logger)
+
+	result, err := h.repository.RestoreSnapshot(c.Request.Context(), c.Request.Body)
+	if err != nil {
+		h.respondError(c, "Failed to restore snapshot", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// respondError writes an API error as is and logs anything else as a 500
+func (h *AdminHandler) respondError(c *gin.Context, message string, err error) {
+	if apiErr, ok := errors.IsAPIError(err); ok {
+		c.JSON(apiErr.StatusCode, gin.H{
+			"error": apiErr.Message,
+			"code":  apiErr.Code,
+		})
+		return
+	}
+
+	h.logger.Errorf("%s: %v", message, err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": message,
+	})
+}
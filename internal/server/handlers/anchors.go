@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/server/websocket"
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// AnchorsHandler handles changes to existing anchors
+type AnchorsHandler struct {
+	repository *spatial.Repository
+	hub        *websocket.Hub
+	logger     logger.Logger
+}
+
+// NewAnchorsHandler creates a new anchors handler
+func NewAnchorsHandler(repository *spatial.Repository, hub *websocket.Hub, logger logger.Logger) *AnchorsHandler {
+	return &AnchorsHandler{
+		repository: repository,
+		hub:        hub,
+		logger:     logger,
+	}
+}
+
+// Update handles PUT /api/v1/anchors/:id
+func (h *AnchorsHandler) Update(c *gin.Context) {
+	var update api.AnchorUpdate
+
+	if err := c.ShouldBindJSON(&update); err != nil {
+		h.logger.Warnf("Invalid anchor update: %v", err)
+		respondBindingError(c, "Invalid request body", err)
+		return
+	}
+
+	// The body may omit the ID, but must not name a different anchor
+	anchorID := c.Param("id")
+	if update.ID == "" {
+		update.ID = anchorID
+	}
+	if update.ID != anchorID {
+		apiErr := errors.BadRequest("anchor ID in body does not match path")
+		c.JSON(apiErr.StatusCode, gin.H{
+			"error": apiErr.Message,
+			"code":  apiErr.Code,
+		})
+		return
+	}
+
+	if !requireSessionID(c, c.Query("session_id")) {
+		return
+	}
+	sessionID, err := h.repository.ResolveAnchorSession(c.Request.Context(), anchorID, c.Query("session_id"))
+	if err != nil {
+		respondAnchorError(c, err)
+		return
+	}
+	if !authorizeSession(c, sessionID) {
+		return
+	}
+
+	anchor, err := h.repository.UpdateAnchor(c.Request.Context(), sessionID, &update)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to update anchor: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update anchor",
+		})
+		return
+	}
+
+	h.broadcastUpdate(logger.TraceID(c.Request.Context()), anchor)
+
+	c.JSON(http.StatusOK, anchor)
+}
+
+// broadcastUpdate streams an updated anchor to its session's subscribers
+func (h *AnchorsHandler) broadcastUpdate(traceID string, anchor *api.Anchor) {
+	message, err := anchorUpdateMessage(anchor, traceID)
+	if err != nil {
+		h.logger.Errorf("Failed to marshal anchor update: %v", err)
+		return
+	}
+
+	if err := h.hub.BroadcastUpdate(anchor.SessionID, message, websocket.AnchorLocation(anchor)); err != nil {
+		h.logger.Errorf("Failed to broadcast anchor update: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/tabular/stag-v2/internal/server/websocket"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// ingestBroadcaster streams HTTP-ingested data to a session's WebSocket clients
+type ingestBroadcaster struct {
+	hub    *websocket.Hub
+	limit  int // Updates per session above which a summary is sent instead
+	logger logger.Logger
+}
+
+// sessionUpdate is a message for a session and the anchor it is about, nil
+// for summaries every client receives
+type sessionUpdate struct {
+	message  *api.WSMessage
+	location *websocket.UpdateLocation
+}
+
+// sessionUpdates collects the messages one request produced for a session
+type sessionUpdates struct {
+	messages []sessionUpdate
+	summary  api.IngestSummary
+	seen     map[string]bool // Event IDs already in the summary
+}
+
+// publish broadcasts the anchors and meshes of stored events, coalescing a
+// session's updates into one summary message when there are too many. Every
+// message carries the trace ID of the request that stored the events.
+func (b *ingestBroadcaster) publish(traceID string, events []api.SpatialEvent) {
+	sessions := make(map[string]*sessionUpdates)
+	order := []string{}
+
+	add := func(sessionID, eventID string, message *api.WSMessage, location *websocket.UpdateLocation, isMesh bool) {
+		updates, ok := sessions[sessionID]
+		if !ok {
+			updates = &sessionUpdates{seen: make(map[string]bool)}
+			sessions[sessionID] = updates
+			order = append(order, sessionID)
+		}
+
+		updates.messages = append(updates.messages, sessionUpdate{message: message, location: location})
+		if isMesh {
+			updates.summary.MeshCount++
+		} else {
+			updates.summary.AnchorCount++
+		}
+		if !updates.seen[eventID] {
+			updates.seen[eventID] = true
+			updates.summary.EventIDs = append(updates.summary.EventIDs, eventID)
+		}
+	}
+
+	for i := range events {
+		event := &events[i]
+		for j := range event.Anchors {
+			anchor := &event.Anchors[j]
+			message, err := anchorUpdateMessage(anchor, traceID)
+			if err != nil {
+				b.logger.Errorf("Failed to marshal anchor update: %v", err)
+				continue
+			}
+			add(anchor.SessionID, event.EventID, message, websocket.AnchorLocation(anchor), false)
+		}
+		for j := range event.Meshes {
+			mesh := &event.Meshes[j]
+			message, err := meshUpdateMessage(event.SessionID, mesh, traceID)
+			if err != nil {
+				b.logger.Errorf("Failed to marshal mesh update: %v", err)
+				continue
+			}
+			add(event.SessionID, event.EventID, message, websocket.MeshLocation(mesh.AnchorID), true)
+		}
+	}
+
+	for _, sessionID := range order {
+		updates := sessions[sessionID]
+		if len(updates.messages) > b.limit {
+			data, err := json.Marshal(updates.summary)
+			if err != nil {
+				b.logger.Errorf("Failed to marshal ingest summary: %v", err)
+				continue
+			}
+			updates.messages = []sessionUpdate{{message: &api.WSMessage{
+				Type:      api.WSTypeIngestSummary,
+				SessionID: sessionID,
+				Data:      data,
+				Timestamp: time.Now().UnixMilli(),
+			}}}
+		}
+
+		for _, update := range updates.messages {
+			if err := b.hub.BroadcastUpdate(sessionID, update.message, update.location); err != nil {
+				b.logger.Errorf("Failed to broadcast %s: %v", update.message.Type, err)
+			}
+		}
+	}
+}
+
+// anchorUpdateMessage builds the WebSocket message announcing an anchor's pose
+func anchorUpdateMessage(anchor *api.Anchor, traceID string) (*api.WSMessage, error) {
+	data, err := json.Marshal(api.AnchorUpdate{
+		ID: anchor.ID,
+		Pose: api.PoseData{
+			X:        anchor.Pose.X,
+			Y:        anchor.Pose.Y,
+			Z:        anchor.Pose.Z,
+			Rotation: anchor.Pose.Rotation,
+		},
+		Metadata: anchor.Metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.WSMessage{
+		Type:      api.WSTypeAnchorUpdate,
+		SessionID: anchor.SessionID,
+		Data:      data,
+		Timestamp: time.Now().UnixMilli(),
+		TraceID:   traceID,
+	}, nil
+}
+
+// meshUpdateMessage builds the WebSocket message carrying a mesh as ingested
+func meshUpdateMessage(sessionID string, mesh *api.Mesh, traceID string) (*api.WSMessage, error) {
+	// Delta meshes carry their patch in place of vertices, as over WebSocket
+	vertices := mesh.Vertices
+	if mesh.IsDelta && len(mesh.DeltaData) > 0 {
+		vertices = mesh.DeltaData
+	}
+
+	data, err := json.Marshal(api.MeshUpdate{
+		ID:               mesh.ID,
+		AnchorID:         mesh.AnchorID,
+		Vertices:         base64.StdEncoding.EncodeToString(vertices),
+		Faces:            base64.StdEncoding.EncodeToString(mesh.Faces),
+		Normals:          base64.StdEncoding.EncodeToString(mesh.Normals),
+		CompressionLevel: mesh.CompressionLevel,
+		CompressionCodec: mesh.CompressionCodec,
+		VertexStride:     mesh.VertexStride,
+		IndexFormat:      mesh.IndexFormat,
+		IsDelta:          mesh.IsDelta,
+		BaseMeshID:       mesh.BaseMeshID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.WSMessage{
+		Type:      api.WSTypeMeshUpdate,
+		SessionID: sessionID,
+		Data:      data,
+		Timestamp: time.Now().UnixMilli(),
+		TraceID:   traceID,
+	}, nil
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/server/middleware"
+	"github.com/tabular/stag-v2/internal/server/websocket"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// DeadLetterHandler exposes WebSocket updates that failed processing
+type DeadLetterHandler struct {
+	hub    *websocket.Hub
+	logger logger.Logger
+}
+
+// NewDeadLetterHandler creates a new dead-letter handler
+func NewDeadLetterHandler(hub *websocket.Hub, logger logger.Logger) *DeadLetterHandler {
+	return &DeadLetterHandler{
+		hub:    hub,
+		logger: logger,
+	}
+}
+
+// List handles GET /api/v1/deadletter
+func (h *DeadLetterHandler) List(c *gin.Context) {
+	var params api.DeadLetterParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logger.Warnf("Invalid dead-letter parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
+	}
+
+	if params.Limit <= 0 {
+		params.Limit = 100
+	}
+	if params.Limit > 1000 {
+		params.Limit = 1000
+	}
+
+	// JWT callers only see their own sessions' failures
+	claims := middleware.RequestClaims(c)
+	allows := func(sessionID string) bool {
+		if params.SessionID != "" && sessionID != params.SessionID {
+			return false
+		}
+		return claims == nil || claims.Allows(sessionID)
+	}
+
+	c.JSON(http.StatusOK, h.hub.DeadLetters(allows, params.Limit, params.IncludeData))
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// clearWriteDeadline lifts the server write timeout from a streamed response
+// that may outlast it. The stream is still sent if the deadline cannot be
+// lifted, but ends at the timeout.
+func clearWriteDeadline(c *gin.Context, log logger.Logger) {
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Warnf("Failed to lift the write deadline of %s, the response ends at the server write timeout: %v", c.Request.URL.Path, err)
+	}
+}
+
+// clearDeadlines lifts the server read and write timeouts from a request
+// whose body may take longer to upload than them, answered once it has
+func clearDeadlines(c *gin.Context, log logger.Logger) {
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		log.Warnf("Failed to lift the read deadline of %s, the body must arrive within the server read timeout: %v", c.Request.URL.Path, err)
+		return
+	}
+	clearWriteDeadline(c, log)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/internal/server/middleware"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// streamingServer serves router with the given timeouts, behind the
+// middleware that wraps the response writer of streamed downloads
+func streamingServer(t *testing.T, timeout time.Duration, route func(router *gin.Engine)) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := logger.New(logger.Config{})
+	m := metrics.New(config.MetricsConfig{}, log)
+
+	router := gin.New()
+	router.Use(middleware.Trace())
+	router.Use(middleware.Compress(config.ResponseCompressionConfig{Enabled: true, MinSize: 1, Level: 1}))
+	router.Use(middleware.ExportEncoding("snapshot", config.ExportCompressionConfig{Level: 1}, m))
+	route(router)
+
+	server := httptest.NewUnstartedServer(router)
+	server.Config.ReadTimeout = timeout
+	server.Config.WriteTimeout = timeout
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStreamOutlastsServerTimeouts(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	log := logger.New(logger.Config{})
+
+	server := streamingServer(t, timeout, func(router *gin.Engine) {
+		// A download written past the write timeout, as snapshots and replays are
+		router.GET("/download", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+			clearWriteDeadline(c, log)
+			for _, part := range []string{"first\n", "second\n", "third\n"} {
+				c.Writer.WriteString(part)
+				c.Writer.Flush()
+				time.Sleep(timeout)
+			}
+		})
+
+		// An upload whose body arrives past the read timeout, as restores may
+		router.POST("/upload", func(c *gin.Context) {
+			clearDeadlines(c, log)
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.Status(http.StatusBadRequest)
+				return
+			}
+			c.String(http.StatusOK, "%d", len(body))
+		})
+	})
+
+	// Each request on a connection of its own, so none finds it closed idle
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	resp, err := client.Get(server.URL + "/download")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "first\nsecond\nthird\n" {
+		t.Errorf("Expected the whole download past the write timeout, got %q (%v)", body, err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 3; i++ {
+			pw.Write([]byte(strings.Repeat("x", 10)))
+			time.Sleep(timeout)
+		}
+		pw.Close()
+	}()
+	resp, err = client.Post(server.URL+"/upload", "application/x-tar", pr)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "30" {
+		t.Errorf("Expected the whole upload read past the read timeout, got %d %q", resp.StatusCode, body)
+	}
+}
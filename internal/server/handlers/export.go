@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// ExportHandler handles geometry exports
+type ExportHandler struct {
+	repository *spatial.Repository
+	logger     logger.Logger
+}
+
+// NewExportHandler creates a new export handler
+func NewExportHandler(repository *spatial.Repository, logger logger.Logger) *ExportHandler {
+	return &ExportHandler{
+		repository: repository,
+		logger:     logger,
+	}
+}
+
+// ExportGLTF handles GET /api/v1/sessions/:id/export.gltf
+func (h *ExportHandler) ExportGLTF(c *gin.Context) {
+	sessionID := c.Param("id")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "session ID is required",
+		})
+		return
+	}
+
+	binary := c.Query("binary") == "true"
+
+	data, err := h.repository.ExportSessionGLTF(c.Request.Context(), sessionID, binary)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to export session %s: %v", sessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to export session",
+		})
+		return
+	}
+
+	if binary {
+		c.Header("Content-Disposition", "attachment; filename=\""+sessionID+".glb\"")
+		c.Data(http.StatusOK, "model/gltf-binary", data)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=\""+sessionID+".gltf\"")
+	c.Data(http.StatusOK, "model/gltf+json", data)
+}
+
+// Replay handles GET /api/v1/sessions/:id/replay, streaming the session as
+// NDJSON events that can be posted back to /ingest
+func (h *ExportHandler) Replay(c *gin.Context) {
+	var params api.ReplayParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logger.Warnf("Invalid replay parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
+	}
+
+	sessionID := c.Param("id")
+	started := false
+	start := func() {
+		speed := params.Speed
+		if speed == 0 {
+			speed = 1
+		}
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", "attachment; filename=\""+sessionID+".ndjson\"")
+		c.Header("X-Replay-Speed", strconv.FormatFloat(speed, 'f', -1, 64))
+		c.Status(http.StatusOK)
+		started = true
+
+		// A long replay outlasts the server write timeout; the query timeouts
+		// still bound it
+		clearWriteDeadline(c, h.logger)
+	}
+
+	encoder := json.NewEncoder(c.Writer)
+	err := h.repository.ReplaySession(c.Request.Context(), sessionID, &params, func(event *api.ReplayEvent) error {
+		if !started {
+			start()
+		}
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+
+	if err != nil {
+		// Once streaming has begun the status is sent, so the error can only
+		// end the stream early
+		if started {
+			h.logger.Errorf("Replay of session %s ended early: %v", sessionID, err)
+			return
+		}
+
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to replay session %s: %v", sessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to replay session",
+		})
+		return
+	}
+
+	if !started {
+		start()
+	}
+}
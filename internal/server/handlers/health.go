@@ -1,34 +1,99 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/tabular/stag-v2/internal/database"
 	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
 )
 
+// dbPingTimeout bounds how long a health check waits on ArangoDB
+const dbPingTimeout = 2 * time.Second
+
 // HealthHandler handles health check requests
 type HealthHandler struct {
 	version string
+	db      *database.Connection
+	ready   *atomic.Bool // Set once startup completes, cleared on shutdown
+	logger  logger.Logger
 }
 
-// NewHealthHandler creates a new health handler
-func NewHealthHandler(version string) *HealthHandler {
+// NewHealthHandler creates a new health handler reporting readiness while
+// ready is set
+func NewHealthHandler(version string, db *database.Connection, ready *atomic.Bool, logger logger.Logger) *HealthHandler {
 	return &HealthHandler{
 		version: version,
+		db:      db,
+		ready:   ready,
+		logger:  logger,
 	}
 }
 
-// Health returns the service health status
+// Health returns the service health status, including database connectivity
 func (h *HealthHandler) Health(c *gin.Context) {
+	h.Ready(c)
+}
+
+// Live handles GET /health/live. It only reports that the process is serving
+// requests, so a database outage does not get the instance restarted.
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "alive",
+		"version":   h.version,
+		"timestamp": time.Now(),
+	})
+}
+
+// Ready handles GET /health/ready, returning 503 until startup completes,
+// once shutdown begins, and while ArangoDB is unreachable
+func (h *HealthHandler) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), dbPingTimeout)
+	defer cancel()
+
 	response := api.HealthResponse{
 		Status:    "healthy",
 		Version:   h.version,
 		Timestamp: time.Now(),
 		Database:  "connected",
+
+		LatestSchemaVersion: database.SchemaVersion,
+		DatabaseEndpoints:   h.db.EndpointStatus(),
+	}
+
+	if err := h.db.Ping(ctx); err != nil {
+		h.logger.Warnf("Health check failed: %v", err)
+		response.Status = "unhealthy"
+		response.Database = "disconnected"
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+
+	// The version is informational, so failing to read it fails no check
+	if version, err := h.db.SchemaVersion(ctx); err != nil {
+		h.logger.Warnf("Health check failed to read schema version: %v", err)
+	} else {
+		response.SchemaVersion = version
+	}
+
+	if !h.ready.Load() {
+		response.Status = "not_ready"
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+
+	// Requests fail over to the remaining endpoints
+	for _, up := range response.DatabaseEndpoints {
+		if !up {
+			response.Status = "degraded"
+			break
+		}
 	}
 
 	c.JSON(http.StatusOK, response)
-}
\ No newline at end of file
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/server/websocket"
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// ImportHandler handles OBJ and PLY mesh uploads
+type ImportHandler struct {
+	repository  *spatial.Repository
+	broadcaster *ingestBroadcaster
+	logger      logger.Logger
+}
+
+// NewImportHandler creates a new import handler. Imported meshes are streamed
+// to the hub's clients like ingested ones.
+func NewImportHandler(repository *spatial.Repository, hub *websocket.Hub, broadcastLimit int, logger logger.Logger) *ImportHandler {
+	return &ImportHandler{
+		repository:  repository,
+		broadcaster: &ingestBroadcaster{hub: hub, limit: broadcastLimit, logger: logger},
+		logger:      logger,
+	}
+}
+
+// Import handles POST /api/v1/import, a multipart upload with the mesh in
+// "file" and its target in the session_id and anchor_id fields
+func (h *ImportHandler) Import(c *gin.Context) {
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if stderrors.As(err, &tooLarge) {
+			h.respondError(c, errors.PayloadTooLarge("mesh file exceeds the upload size limit"))
+			return
+		}
+		h.respondError(c, errors.BadRequest("multipart field \"file\" is required"))
+		return
+	}
+	defer file.Close()
+
+	sessionID := c.PostForm("session_id")
+	anchorID := c.PostForm("anchor_id")
+	if sessionID == "" || anchorID == "" {
+		h.respondError(c, errors.BadRequest("session_id and anchor_id are required"))
+		return
+	}
+
+	result, event, err := h.repository.ImportMesh(c.Request.Context(), sessionID, anchorID, header.Filename, file)
+	if err != nil {
+		if _, ok := errors.IsAPIError(err); ok {
+			h.respondError(c, err)
+			return
+		}
+
+		h.logger.Errorf("Failed to import mesh: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to import mesh",
+		})
+		return
+	}
+
+	h.broadcaster.publish(logger.TraceID(c.Request.Context()), []api.SpatialEvent{*event})
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// respondError writes an APIError response
+func (h *ImportHandler) respondError(c *gin.Context, err error) {
+	apiErr, _ := errors.IsAPIError(err)
+	c.JSON(apiErr.StatusCode, gin.H{
+		"error": apiErr.Message,
+		"code":  apiErr.Code,
+	})
+}
@@ -1,27 +1,45 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 
+	"github.com/tabular/stag-v2/internal/server/websocket"
 	"github.com/tabular/stag-v2/internal/spatial"
 	"github.com/tabular/stag-v2/pkg/api"
 	"github.com/tabular/stag-v2/pkg/errors"
 	"github.com/tabular/stag-v2/pkg/logger"
 )
 
+// CompressionLevelHeader sets the storage compression level of the ingested
+// sessions' meshes that do not set their own
+const CompressionLevelHeader = "X-Compression-Level"
+
+// BackfillHeader marks an ingest request as a backfill import, whose event
+// timestamps are not validated against the server clock or their session's
+// latest event
+const BackfillHeader = "X-Backfill"
+
 // IngestHandler handles spatial data ingestion
 type IngestHandler struct {
-	repository *spatial.Repository
-	logger     logger.Logger
+	repository  *spatial.Repository
+	broadcaster *ingestBroadcaster
+	logger      logger.Logger
 }
 
-// NewIngestHandler creates a new ingest handler
-func NewIngestHandler(repository *spatial.Repository, logger logger.Logger) *IngestHandler {
+// NewIngestHandler creates a new ingest handler. Stored data is streamed to
+// the hub's clients, summarized once a session has more than broadcastLimit updates.
+func NewIngestHandler(repository *spatial.Repository, hub *websocket.Hub, broadcastLimit int, logger logger.Logger) *IngestHandler {
 	return &IngestHandler{
-		repository: repository,
-		logger:     logger,
+		repository:  repository,
+		broadcaster: &ingestBroadcaster{hub: hub, limit: broadcastLimit, logger: logger},
+		logger:      logger,
 	}
 }
 
@@ -32,10 +50,7 @@ func (h *IngestHandler) Ingest(c *gin.Context) {
 	// Bind and validate request
 	if err := c.ShouldBindJSON(&event); err != nil {
 		h.logger.Warnf("Invalid request body: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
+		respondBindingError(c, "Invalid request body", err)
 		return
 	}
 
@@ -54,8 +69,30 @@ func (h *IngestHandler) Ingest(c *gin.Context) {
 		return
 	}
 
+	if err := h.repository.ValidateEventSize(&event); err != nil {
+		apiErr, _ := errors.IsAPIError(err)
+		c.JSON(apiErr.StatusCode, gin.H{
+			"error": apiErr.Message,
+			"code":  apiErr.Code,
+		})
+		return
+	}
+
+	level, ok := compressionLevelHeader(c)
+	if !ok {
+		return
+	}
+	if level != nil {
+		h.repository.SetSessionCompressionLevel(event.SessionID, *level)
+	}
+
+	ctx, ok := backfillContext(c)
+	if !ok {
+		return
+	}
+
 	// Process the event
-	if err := h.repository.Ingest(c.Request.Context(), &event); err != nil {
+	if err := h.repository.Ingest(ctx, &event); err != nil {
 		// Check if it's an API error
 		if apiErr, ok := errors.IsAPIError(err); ok {
 			c.JSON(apiErr.StatusCode, gin.H{
@@ -73,6 +110,9 @@ func (h *IngestHandler) Ingest(c *gin.Context) {
 		return
 	}
 
+	// Stream the stored data to live subscribers
+	h.broadcaster.publish(logger.TraceID(c.Request.Context()), []api.SpatialEvent{event})
+
 	// Success response
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Event ingested successfully",
@@ -80,4 +120,183 @@ func (h *IngestHandler) Ingest(c *gin.Context) {
 		"anchors_count": len(event.Anchors),
 		"meshes_count": len(event.Meshes),
 	})
-}
\ No newline at end of file
+}
+
+// IngestBatch handles POST /api/v1/ingest/batch
+func (h *IngestHandler) IngestBatch(c *gin.Context) {
+	var rawEvents []json.RawMessage
+
+	// Decode the envelope only; events are validated individually below
+	if err := json.NewDecoder(c.Request.Body).Decode(&rawEvents); err != nil {
+		h.logger.Warnf("Invalid batch request body: %v", err)
+		respondBindingError(c, "Invalid request body", err)
+		return
+	}
+
+	if len(rawEvents) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "batch must contain at least one event",
+		})
+		return
+	}
+
+	level, ok := compressionLevelHeader(c)
+	if !ok {
+		return
+	}
+	ctx, ok := backfillContext(c)
+	if !ok {
+		return
+	}
+
+	atomic := c.Query("atomic") == "true"
+	results := make([]api.BatchIngestResult, len(rawEvents))
+	events := make([]api.SpatialEvent, 0, len(rawEvents))
+	indexes := make([]int, 0, len(rawEvents))
+
+	for i, raw := range rawEvents {
+		results[i].Index = i
+
+		var event api.SpatialEvent
+		if err := json.Unmarshal(raw, &event); err != nil {
+			setBatchFailure(&results[i], errors.ValidationError(fmt.Sprintf("invalid event: %v", err)))
+			results[i].Details = fieldErrors(err)
+			continue
+		}
+		results[i].EventID = event.EventID
+
+		if err := binding.Validator.ValidateStruct(&event); err != nil {
+			setBatchFailure(&results[i], errors.ValidationError(err.Error()))
+			results[i].Details = fieldErrors(err)
+			continue
+		}
+		if err := h.repository.ValidateEventSize(&event); err != nil {
+			setBatchFailure(&results[i], err)
+			continue
+		}
+
+		events = append(events, event)
+		indexes = append(indexes, i)
+	}
+
+	// In atomic mode a single invalid event rejects the whole batch
+	if atomic && len(events) != len(rawEvents) {
+		abortBatch(results)
+		c.JSON(http.StatusBadRequest, newBatchResponse(results))
+		return
+	}
+
+	if level != nil {
+		for _, event := range events {
+			h.repository.SetSessionCompressionLevel(event.SessionID, *level)
+		}
+	}
+
+	errs, err := h.repository.IngestBatch(ctx, events, atomic)
+	stored := make([]api.SpatialEvent, 0, len(events))
+	for j, ingestErr := range errs {
+		result := &results[indexes[j]]
+		if ingestErr != nil {
+			h.logger.Warnf("Failed to ingest batch event %s: %v", result.EventID, ingestErr)
+			setBatchFailure(result, ingestErr)
+			continue
+		}
+		result.Success = true
+		stored = append(stored, events[j])
+	}
+
+	if err != nil {
+		abortBatch(results)
+
+		statusCode := http.StatusInternalServerError
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			statusCode = apiErr.StatusCode
+		}
+		h.logger.Errorf("Atomic batch ingest failed: %v", err)
+		c.JSON(statusCode, newBatchResponse(results))
+		return
+	}
+
+	// Stream the stored events to live subscribers
+	h.broadcaster.publish(logger.TraceID(c.Request.Context()), stored)
+
+	c.JSON(http.StatusOK, newBatchResponse(results))
+}
+
+// compressionLevelHeader parses the X-Compression-Level header, returning nil
+// if it is absent. An invalid header is answered with 400 and ok is false.
+func compressionLevelHeader(c *gin.Context) (level *int, ok bool) {
+	header := c.GetHeader(CompressionLevelHeader)
+	if header == "" {
+		return nil, true
+	}
+
+	value, err := strconv.Atoi(header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid %s header %q", CompressionLevelHeader, header),
+		})
+		return nil, false
+	}
+	value = spatial.ClampCompressionLevel(value)
+	return &value, true
+}
+
+// backfillContext returns the request context, marked as a backfill when the
+// X-Backfill header is true. An invalid header is answered with 400 and ok is
+// false.
+func backfillContext(c *gin.Context) (ctx context.Context, ok bool) {
+	header := c.GetHeader(BackfillHeader)
+	if header == "" {
+		return c.Request.Context(), true
+	}
+
+	backfill, err := strconv.ParseBool(header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid %s header %q", BackfillHeader, header),
+		})
+		return nil, false
+	}
+	if !backfill {
+		return c.Request.Context(), true
+	}
+	return spatial.WithBackfill(c.Request.Context()), true
+}
+
+// setBatchFailure records an error on a batch result
+func setBatchFailure(result *api.BatchIngestResult, err error) {
+	result.Success = false
+	if apiErr, ok := errors.IsAPIError(err); ok {
+		result.Code = apiErr.Code
+		result.Error = apiErr.Message
+		result.Retryable = apiErr.IsRetryable()
+		return
+	}
+	result.Code = "INTERNAL_ERROR"
+	result.Error = "Failed to ingest event"
+}
+
+// abortBatch marks every event without its own error as rolled back
+func abortBatch(results []api.BatchIngestResult) {
+	for i := range results {
+		if results[i].Code == "" {
+			results[i].Success = false
+			results[i].Code = "ABORTED"
+			results[i].Error = "batch rolled back"
+		}
+	}
+}
+
+// newBatchResponse summarizes batch results
+func newBatchResponse(results []api.BatchIngestResult) api.BatchIngestResponse {
+	response := api.BatchIngestResponse{Results: results}
+	for _, result := range results {
+		if result.Success {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	return response
+}
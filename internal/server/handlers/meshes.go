@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// MeshesHandler handles changes derived from stored meshes
+type MeshesHandler struct {
+	repository *spatial.Repository
+	logger     logger.Logger
+}
+
+// NewMeshesHandler creates a new meshes handler
+func NewMeshesHandler(repository *spatial.Repository, logger logger.Logger) *MeshesHandler {
+	return &MeshesHandler{
+		repository: repository,
+		logger:     logger,
+	}
+}
+
+// CreateLOD handles POST /api/v1/meshes/:id/lod?ratio=
+func (h *MeshesHandler) CreateLOD(c *gin.Context) {
+	ratio, err := strconv.ParseFloat(c.Query("ratio"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "ratio must be a number between 0 and 1",
+		})
+		return
+	}
+
+	// The level of detail is stored in the mesh's own session, so sharing
+	// the mesh does not grant creating it
+	if !authorizeMeshes(c, h.repository, false, c.Param("id")) {
+		return
+	}
+
+	result, err := h.repository.GenerateLOD(c.Request.Context(), c.Param("id"), ratio)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to generate level of detail: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate level of detail",
+		})
+		return
+	}
+
+	status := http.StatusOK
+	if result.Created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, result)
+}
+
+// CreateDiff handles POST /api/v1/meshes/:id/diff, whose body is a full mesh
+// to store as a delta against mesh :id
+func (h *MeshesHandler) CreateDiff(c *gin.Context) {
+	var mesh api.Mesh
+	if err := c.ShouldBindJSON(&mesh); err != nil {
+		h.logger.Warnf("Invalid mesh diff request body: %v", err)
+		respondBindingError(c, "Invalid mesh", err)
+		return
+	}
+
+	// The delta is stored in the base mesh's session
+	if !authorizeMeshes(c, h.repository, false, c.Param("id")) {
+		return
+	}
+
+	result, err := h.repository.DiffMesh(c.Request.Context(), c.Param("id"), &mesh)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to diff mesh: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to diff mesh",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
@@ -11,6 +16,12 @@ import (
 	"github.com/tabular/stag-v2/pkg/logger"
 )
 
+// metadataParamPrefix starts the name of a metadata filter parameter
+const metadataParamPrefix = "meta."
+
+// maxMetadataFilters caps the metadata filters of one query
+const maxMetadataFilters = 16
+
 // QueryHandler handles spatial queries
 type QueryHandler struct {
 	repository *spatial.Repository
@@ -25,19 +36,26 @@ func NewQueryHandler(repository *spatial.Repository, logger logger.Logger) *Quer
 	}
 }
 
-// Query handles GET /api/v1/query
+// Query handles GET /api/v1/query and GET /api/v2/query, which share the
+// query and differ only in the response shape
 func (h *QueryHandler) Query(c *gin.Context) {
 	var params api.QueryParams
 
 	// Bind query parameters
 	if err := c.ShouldBindQuery(&params); err != nil {
 		h.logger.Warnf("Invalid query parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
+	}
+
+	metadata, err := metadataFilters(c.Request.URL.Query())
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid query parameters",
-			"details": err.Error(),
+			"error": err.Error(),
 		})
 		return
 	}
+	params.Metadata = metadata
 
 	// Validate parameters
 	if params.SessionID == "" && params.AnchorID == "" {
@@ -47,6 +65,36 @@ func (h *QueryHandler) Query(c *gin.Context) {
 		return
 	}
 
+	if params.Polygon != "" && (params.AnchorID != "" || params.Radius > 0 || hasBoundingBox(&params)) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "polygon, radius and bounding box filters are mutually exclusive",
+		})
+		return
+	}
+
+	if hasBoundingBox(&params) {
+		if params.AnchorID != "" || params.Radius > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "bounding box and radius filters are mutually exclusive",
+			})
+			return
+		}
+
+		if err := validateBoundingBox(&params); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if params.MaxTriangles < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "max_triangles must not be negative",
+		})
+		return
+	}
+
 	if params.AnchorID != "" && params.Radius <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "radius must be provided when using anchor_id",
@@ -54,14 +102,22 @@ func (h *QueryHandler) Query(c *gin.Context) {
 		return
 	}
 
-	// Set default limit
-	if params.Limit <= 0 {
-		params.Limit = 100
-	} else if params.Limit > 1000 {
-		params.Limit = 1000
+	// Without a session a radius query searches every session
+	if !requireSessionID(c, params.SessionID) {
+		return
+	}
+
+	switch params.HasMesh {
+	case "", "any", "true", "false":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "has_mesh must be any, true or false",
+		})
+		return
 	}
 
-	// Execute query
+	// Execute query; the repository applies the configured default and
+	// maximum limits
 	response, err := h.repository.Query(c.Request.Context(), &params)
 	if err != nil {
 		// Check if it's an API error
@@ -81,9 +137,29 @@ func (h *QueryHandler) Query(c *gin.Context) {
 		return
 	}
 
+	if apiVersion(c) == 2 {
+		c.JSON(http.StatusOK, api.NewQueryResponseV2(response))
+		return
+	}
 	c.JSON(http.StatusOK, response)
 }
 
+// apiVersion negotiates the response shape of an endpoint served by both API
+// versions. /api/v2 paths get version 2, as do /api/v1 requests that accept
+// api.MediaTypeV2.
+func apiVersion(c *gin.Context) int {
+	if strings.HasPrefix(c.FullPath(), "/api/v2/") {
+		return 2
+	}
+
+	// The shape of /api/v1 responses depends on the Accept header
+	c.Writer.Header().Add("Vary", "Accept")
+	if strings.Contains(c.GetHeader("Accept"), api.MediaTypeV2) {
+		return 2
+	}
+	return 1
+}
+
 // GetAnchor handles GET /api/v1/anchors/:id
 func (h *QueryHandler) GetAnchor(c *gin.Context) {
 	anchorID := c.Param("id")
@@ -94,13 +170,40 @@ func (h *QueryHandler) GetAnchor(c *gin.Context) {
 		return
 	}
 
-	// Query for specific anchor
-	params := &api.QueryParams{
-		AnchorID: anchorID,
-		Limit:    1,
+	var params api.QueryParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logger.Warnf("Invalid anchor query parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
 	}
 
-	response, err := h.repository.Query(c.Request.Context(), params)
+	if !requireSessionID(c, params.SessionID) {
+		return
+	}
+	sessionID, err := h.repository.ResolveAnchorSession(c.Request.Context(), anchorID, params.SessionID)
+	if err != nil {
+		respondAnchorError(c, err)
+		return
+	}
+	if !authorizeSession(c, sessionID) {
+		return
+	}
+
+	// The latest pose by default, or a page of pose samples with ?history=true
+	query := &api.QueryParams{SessionID: sessionID, AnchorID: anchorID, Limit: 1}
+	if params.History {
+		query = &api.QueryParams{
+			SessionID: sessionID,
+			AnchorID:  anchorID,
+			History:   true,
+			Since:     params.Since,
+			Until:     params.Until,
+			Limit:     params.Limit,
+			Cursor:    params.Cursor,
+		}
+	}
+
+	response, err := h.repository.Query(c.Request.Context(), query)
 	if err != nil {
 		if apiErr, ok := errors.IsAPIError(err); ok {
 			c.JSON(apiErr.StatusCode, gin.H{
@@ -117,12 +220,356 @@ func (h *QueryHandler) GetAnchor(c *gin.Context) {
 		return
 	}
 
-	if len(response.Anchors) == 0 {
+	// A later history page may be empty, but the first one never is
+	if len(response.Anchors) == 0 && params.Cursor == "" {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Anchor not found",
 		})
 		return
 	}
 
+	if params.History {
+		c.JSON(http.StatusOK, response)
+		return
+	}
 	c.JSON(http.StatusOK, response.Anchors[0])
-}
\ No newline at end of file
+}
+
+// GetNeighbors handles GET /api/v1/anchors/:id/neighbors
+func (h *QueryHandler) GetNeighbors(c *gin.Context) {
+	anchorID := c.Param("id")
+	if anchorID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "anchor ID is required",
+		})
+		return
+	}
+
+	depth := 1
+	if raw := c.Query("depth"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "depth must be a positive integer",
+			})
+			return
+		}
+		depth = parsed
+	}
+
+	if !requireSessionID(c, c.Query("session_id")) {
+		return
+	}
+	sessionID, err := h.repository.ResolveAnchorSession(c.Request.Context(), anchorID, c.Query("session_id"))
+	if err != nil {
+		respondAnchorError(c, err)
+		return
+	}
+	if !authorizeSession(c, sessionID) {
+		return
+	}
+
+	response, err := h.repository.GetNeighbors(c.Request.Context(), sessionID, anchorID, depth)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to get neighbors: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get neighbors",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetPath handles the shortest topology path between two anchors
+func (h *QueryHandler) GetPath(c *gin.Context) {
+	fromID, toID := c.Param("id"), c.Param("to")
+	if fromID == "" || toID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "both anchor IDs are required",
+		})
+		return
+	}
+
+	if !requireSessionID(c, c.Query("session_id")) {
+		return
+	}
+	sessionID, err := h.repository.ResolveAnchorSession(c.Request.Context(), fromID, c.Query("session_id"))
+	if err != nil {
+		respondAnchorError(c, err)
+		return
+	}
+	if !authorizeSession(c, sessionID) {
+		return
+	}
+
+	response, err := h.repository.ShortestPath(c.Request.Context(), sessionID, fromID, toID)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to find path: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to find path",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// respondAnchorError reports a failure to resolve an anchor's session. The
+// repository only returns API errors for it.
+func respondAnchorError(c *gin.Context, err error) {
+	apiErr, ok := errors.IsAPIError(err)
+	if !ok {
+		apiErr = errors.InternalServerError("Failed to look up anchor")
+	}
+	c.JSON(apiErr.StatusCode, gin.H{
+		"error": apiErr.Message,
+		"code":  apiErr.Code,
+	})
+}
+
+// hasBoundingBox reports whether any bounding box bound was supplied
+func hasBoundingBox(params *api.QueryParams) bool {
+	return params.MinX != nil || params.MinY != nil || params.MinZ != nil ||
+		params.MaxX != nil || params.MaxY != nil || params.MaxZ != nil
+}
+
+// metadataFilters collects the meta.<key>=<value> parameters of a query,
+// ordered by key and value. Repeated parameters must all match.
+func metadataFilters(query url.Values) ([]api.MetadataFilter, error) {
+	var filters []api.MetadataFilter
+	for name, values := range query {
+		key, ok := strings.CutPrefix(name, metadataParamPrefix)
+		if !ok {
+			continue
+		}
+		if key == "" {
+			return nil, fmt.Errorf("metadata filter %q names no key", name)
+		}
+		for _, value := range values {
+			filters = append(filters, api.MetadataFilter{Key: key, Value: value})
+		}
+	}
+	if len(filters) > maxMetadataFilters {
+		return nil, fmt.Errorf("at most %d metadata filters may be given", maxMetadataFilters)
+	}
+
+	sort.Slice(filters, func(i, j int) bool {
+		if filters[i].Key != filters[j].Key {
+			return filters[i].Key < filters[j].Key
+		}
+		return filters[i].Value < filters[j].Value
+	})
+	return filters, nil
+}
+
+// validateBoundingBox checks that a bounding box is complete and not inverted
+func validateBoundingBox(params *api.QueryParams) error {
+	for _, bound := range []*float64{params.MinX, params.MinY, params.MinZ, params.MaxX, params.MaxY, params.MaxZ} {
+		if bound == nil {
+			return fmt.Errorf("bounding box requires min_x, min_y, min_z, max_x, max_y and max_z")
+		}
+	}
+
+	if *params.MinX > *params.MaxX || *params.MinY > *params.MaxY || *params.MinZ > *params.MaxZ {
+		return fmt.Errorf("bounding box minimums must not exceed maximums")
+	}
+
+	return nil
+}
+
+// GetPose handles GET /api/v1/anchors/:id/pose
+func (h *QueryHandler) GetPose(c *gin.Context) {
+	at, err := strconv.ParseInt(c.Query("at"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "at must be a Unix timestamp in milliseconds",
+		})
+		return
+	}
+
+	clamp := false
+	if raw := c.Query("clamp"); raw != "" {
+		if clamp, err = strconv.ParseBool(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "clamp must be a boolean",
+			})
+			return
+		}
+	}
+
+	anchorID := c.Param("id")
+	if !requireSessionID(c, c.Query("session_id")) {
+		return
+	}
+	sessionID, err := h.repository.ResolveAnchorSession(c.Request.Context(), anchorID, c.Query("session_id"))
+	if err != nil {
+		respondAnchorError(c, err)
+		return
+	}
+	if !authorizeSession(c, sessionID) {
+		return
+	}
+
+	pose, err := h.repository.PoseAt(c.Request.Context(), sessionID, anchorID, at, clamp)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to interpolate pose: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to interpolate pose",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, pose)
+}
+
+// GetMesh handles GET /api/v1/meshes/:id. JSON returns the mesh document,
+// while Accept: application/octet-stream returns its decompressed vertex buffer.
+func (h *QueryHandler) GetMesh(c *gin.Context) {
+	raw := false
+	if value := c.Query("raw"); value != "" {
+		var err error
+		if raw, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "raw must be a boolean",
+			})
+			return
+		}
+	}
+
+	if !authorizeMeshes(c, h.repository, true, c.Param("id")) {
+		return
+	}
+
+	mesh, err := h.repository.GetMesh(c.Request.Context(), c.Param("id"), raw)
+	if err != nil {
+		h.meshError(c, err)
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEJSON, "application/octet-stream") == "application/octet-stream" {
+		vertices, err := spatial.MeshVertices(mesh)
+		if err != nil {
+			h.meshError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "application/octet-stream", vertices)
+		return
+	}
+
+	c.JSON(http.StatusOK, mesh)
+}
+
+// GetMeshChain handles GET /api/v1/meshes/:id/chain, listing the delta chain
+// the mesh is resolved against
+func (h *QueryHandler) GetMeshChain(c *gin.Context) {
+	if !authorizeMeshes(c, h.repository, true, c.Param("id")) {
+		return
+	}
+
+	chain, err := h.repository.MeshChain(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.meshError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, chain)
+}
+
+// GetMeshes handles POST /api/v1/meshes/batch, whose body is a JSON array
+// of mesh IDs
+func (h *QueryHandler) GetMeshes(c *gin.Context) {
+	var meshIDs []string
+	if err := c.ShouldBindJSON(&meshIDs); err != nil {
+		h.logger.Warnf("Invalid mesh batch request body: %v", err)
+		respondBindingError(c, "Invalid request body", err)
+		return
+	}
+
+	if !authorizeMeshes(c, h.repository, true, meshIDs...) {
+		return
+	}
+
+	response, err := h.repository.GetMeshes(c.Request.Context(), meshIDs)
+	if err != nil {
+		h.meshError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetLatestPoses handles POST /api/v1/anchors/latest, returning the latest
+// pose of each anchor named in the body
+func (h *QueryHandler) GetLatestPoses(c *gin.Context) {
+	var req api.LatestPosesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warnf("Invalid latest poses request body: %v", err)
+		respondBindingError(c, "Invalid request body", err)
+		return
+	}
+
+	// The middleware checks the session named in the body
+	if !requireSessionID(c, req.SessionID) {
+		return
+	}
+
+	response, err := h.repository.LatestPoses(c.Request.Context(), req.SessionID, req.AnchorIDs)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to get latest poses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get latest poses",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// meshError writes the response for a failed mesh lookup
+func (h *QueryHandler) meshError(c *gin.Context, err error) {
+	if apiErr, ok := errors.IsAPIError(err); ok {
+		c.JSON(apiErr.StatusCode, gin.H{
+			"error": apiErr.Message,
+			"code":  apiErr.Code,
+		})
+		return
+	}
+
+	h.logger.Errorf("Failed to get mesh: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": "Failed to get mesh",
+	})
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/server/middleware"
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Sessions a request names are checked against its JWT by the middleware.
+// Routes reaching a session through an anchor or mesh ID check the session
+// they resolve here. Anchor IDs are only unique within a session, so under a
+// JWT those routes must also name it in session_id.
+
+// requireSessionID rejects a request authorized by a JWT that names no
+// session, writing a 400
+func requireSessionID(c *gin.Context, sessionID string) bool {
+	if sessionID != "" || middleware.RequestClaims(c) == nil {
+		return true
+	}
+	respondAuthError(c, errors.ValidationError("session_id is required when authorizing with a token"))
+	return false
+}
+
+// authorizeSession checks a session the request resolved against its JWT
+// claims, if any, writing a 403 when they do not grant it
+func authorizeSession(c *gin.Context, sessionID string) bool {
+	claims := middleware.RequestClaims(c)
+	if claims == nil || claims.Allows(sessionID) {
+		return true
+	}
+	respondAuthError(c, errors.Forbidden("token does not grant access to session "+sessionID))
+	return false
+}
+
+// authorizeMeshes checks that the request's JWT claims, if any, grant the
+// session of each stored mesh among meshIDs, writing a 403 when one is not
+// granted. With shared, as for reads, a mesh is also granted to the sessions
+// sharing it through deduplication. Meshes not stored are left to the lookup
+// to report.
+func authorizeMeshes(c *gin.Context, repository *spatial.Repository, shared bool, meshIDs ...string) bool {
+	claims := middleware.RequestClaims(c)
+	if claims == nil {
+		return true
+	}
+
+	owners, err := repository.MeshSessions(c.Request.Context(), meshIDs)
+	if err != nil {
+		apiErr, ok := errors.IsAPIError(err)
+		if !ok {
+			apiErr = errors.InternalServerError("Failed to look up mesh sessions")
+		}
+		respondAuthError(c, apiErr)
+		return false
+	}
+
+	for _, meshID := range meshIDs {
+		owner, ok := owners[meshID]
+		if !ok || claims.Allows(owner.SessionID) || (shared && slices.ContainsFunc(owner.Sharing, claims.Allows)) {
+			continue
+		}
+		respondAuthError(c, errors.Forbidden("token does not grant access to mesh "+meshID))
+		return false
+	}
+	return true
+}
+
+// respondAuthError writes the response for a request its claims do not allow
+func respondAuthError(c *gin.Context, apiErr *errors.APIError) {
+	c.JSON(apiErr.StatusCode, gin.H{
+		"error": apiErr.Message,
+		"code":  apiErr.Code,
+	})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/server/middleware"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// withClaims sets claims on the request as the JWT middleware does
+func withClaims(claims *middleware.SessionClaims) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(middleware.ClaimsContextKey, claims)
+	}
+}
+
+func TestAnchorRoutesRequireSessionUnderToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(withClaims(&middleware.SessionClaims{Tenant: "acme"}))
+
+	// The requests are rejected before the repository is used
+	queryHandler := NewQueryHandler(nil, logger.New(logger.Config{}))
+	router.GET("/query", queryHandler.Query)
+	router.GET("/anchors/:id", queryHandler.GetAnchor)
+	router.GET("/anchors/:id/neighbors", queryHandler.GetNeighbors)
+	router.GET("/anchors/:id/path/:to", queryHandler.GetPath)
+	router.GET("/anchors/:id/pose", queryHandler.GetPose)
+	router.POST("/anchors/latest", queryHandler.GetLatestPoses)
+
+	tests := []struct {
+		method, target, body string
+	}{
+		{http.MethodGet, "/query?anchor_id=a1&radius=2", ""},
+		{http.MethodGet, "/anchors/a1", ""},
+		{http.MethodGet, "/anchors/a1/neighbors", ""},
+		{http.MethodGet, "/anchors/a1/path/a2", ""},
+		{http.MethodGet, "/anchors/a1/pose?at=1000", ""},
+		{http.MethodPost, "/anchors/latest", `{"anchor_ids":["a1"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "session_id is required") {
+				t.Errorf("Expected 400 requiring session_id, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthorizeSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name      string
+		claims    *middleware.SessionClaims
+		sessionID string
+		want      bool
+	}{
+		{"NoToken", nil, "other", true},
+		{"TenantSession", &middleware.SessionClaims{Tenant: "acme"}, "acme:site-1", true},
+		{"NamedSession", &middleware.SessionClaims{Sessions: []string{"s1"}}, "s1", true},
+		{"OtherSession", &middleware.SessionClaims{Tenant: "acme", Sessions: []string{"s1"}}, "other:site-1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			if tt.claims != nil {
+				c.Set(middleware.ClaimsContextKey, tt.claims)
+			}
+
+			if got := authorizeSession(c, tt.sessionID); got != tt.want {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			if tt.want {
+				return
+			}
+			var resp struct{ Code string }
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != http.StatusForbidden || resp.Code != "FORBIDDEN" {
+				t.Errorf("Expected a 403, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
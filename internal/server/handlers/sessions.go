@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/server/middleware"
+	"github.com/tabular/stag-v2/internal/server/websocket"
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// SessionsHandler handles session discovery
+type SessionsHandler struct {
+	repository *spatial.Repository
+	hub        *websocket.Hub
+	logger     logger.Logger
+}
+
+// NewSessionsHandler creates a new sessions handler
+func NewSessionsHandler(repository *spatial.Repository, hub *websocket.Hub, logger logger.Logger) *SessionsHandler {
+	return &SessionsHandler{
+		repository: repository,
+		hub:        hub,
+		logger:     logger,
+	}
+}
+
+// List handles GET /api/v1/sessions
+func (h *SessionsHandler) List(c *gin.Context) {
+	var params api.SessionListParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logger.Warnf("Invalid session list parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
+	}
+
+	if params.Limit > 1000 {
+		params.Limit = 1000
+	}
+
+	// JWT callers only see the sessions their token grants
+	if claims := middleware.RequestClaims(c); claims != nil {
+		params.Scope = &api.SessionScope{Sessions: claims.Sessions, Tenant: claims.Tenant}
+	}
+
+	response, err := h.repository.ListSessions(c.Request.Context(), &params)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to list sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list sessions",
+		})
+		return
+	}
+
+	for i := range response.Sessions {
+		response.Sessions[i].Live = h.hub.GetSessionConnections(response.Sessions[i].SessionID) > 0
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Activity handles GET /api/v1/sessions/:id/activity
+func (h *SessionsHandler) Activity(c *gin.Context) {
+	var params api.ActivityParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logger.Warnf("Invalid activity parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
+	}
+
+	response, err := h.repository.SessionActivity(c.Request.Context(), c.Param("id"), &params)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to get session activity: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get session activity",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Clusters handles GET /api/v1/sessions/:id/clusters
+func (h *SessionsHandler) Clusters(c *gin.Context) {
+	var params api.ClusterParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logger.Warnf("Invalid cluster parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
+	}
+
+	response, err := h.repository.SessionClusters(c.Request.Context(), c.Param("id"), &params)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to cluster session anchors: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to cluster session anchors",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Delete handles DELETE /api/v1/sessions/:id
+func (h *SessionsHandler) Delete(c *gin.Context) {
+	dryRun := false
+	if raw := c.Query("dry_run"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "dry_run must be a boolean",
+			})
+			return
+		}
+		dryRun = parsed
+	}
+
+	sessionID := c.Param("id")
+	response, err := h.repository.DeleteSession(c.Request.Context(), sessionID, dryRun)
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to delete session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete session",
+		})
+		return
+	}
+
+	if !dryRun {
+		h.logger.Infof("Deleted session %s: %d anchors, %d meshes, %d edges",
+			sessionID, response.Anchors, response.Meshes, response.Edges)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
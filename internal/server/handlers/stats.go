@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/server/middleware"
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// StatsHandler handles storage statistics requests
+type StatsHandler struct {
+	repository *spatial.Repository
+	logger     logger.Logger
+}
+
+// NewStatsHandler creates a new stats handler
+func NewStatsHandler(repository *spatial.Repository, logger logger.Logger) *StatsHandler {
+	return &StatsHandler{
+		repository: repository,
+		logger:     logger,
+	}
+}
+
+// Storage handles GET /api/v1/stats/storage
+func (h *StatsHandler) Storage(c *gin.Context) {
+	var params api.StorageStatsParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		h.logger.Warnf("Invalid storage stats parameters: %v", err)
+		respondQueryError(c, "Invalid query parameters", &params, err)
+		return
+	}
+
+	if params.Limit <= 0 {
+		params.Limit = 100
+	}
+	if params.Limit > 1000 {
+		params.Limit = 1000
+	}
+
+	stats, err := h.repository.StorageStats(c.Request.Context())
+	if err != nil {
+		if apiErr, ok := errors.IsAPIError(err); ok {
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		h.logger.Errorf("Failed to get storage stats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get storage stats",
+		})
+		return
+	}
+
+	// The cached result is shared, so limit a copy. JWT callers only see
+	// the sessions their token grants, and not the collections, whose
+	// figures cover every session.
+	response := *stats
+	if claims := middleware.RequestClaims(c); claims != nil {
+		response.Collections = []api.CollectionStorage{}
+		response.Sessions = make([]api.SessionStorage, 0, len(stats.Sessions))
+		for _, session := range stats.Sessions {
+			if claims.Allows(session.SessionID) {
+				response.Sessions = append(response.Sessions, session)
+			}
+		}
+		response.TotalSessions = len(response.Sessions)
+	}
+	if len(response.Sessions) > params.Limit {
+		response.Sessions = response.Sessions[:params.Limit]
+	}
+
+	c.JSON(http.StatusOK, response)
+}
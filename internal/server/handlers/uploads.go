@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/tabular/stag-v2/internal/server/websocket"
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// UploadHandler handles chunked mesh uploads
+type UploadHandler struct {
+	repository  *spatial.Repository
+	broadcaster *ingestBroadcaster
+	logger      logger.Logger
+}
+
+// NewUploadHandler creates a new upload handler. Uploaded meshes are
+// streamed to the hub's clients like ingested ones.
+func NewUploadHandler(repository *spatial.Repository, hub *websocket.Hub, broadcastLimit int, logger logger.Logger) *UploadHandler {
+	return &UploadHandler{
+		repository:  repository,
+		broadcaster: &ingestBroadcaster{hub: hub, limit: broadcastLimit, logger: logger},
+		logger:      logger,
+	}
+}
+
+// Init handles POST /api/v1/meshes/upload/init
+func (h *UploadHandler) Init(c *gin.Context) {
+	var req api.MeshUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warnf("Invalid mesh upload request body: %v", err)
+		respondBindingError(c, "Invalid mesh upload request", err)
+		return
+	}
+
+	status, err := h.repository.StartMeshUpload(&req)
+	if err != nil {
+		h.respondError(c, "Failed to start mesh upload", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, status)
+}
+
+// Status handles GET /api/v1/meshes/upload/:id
+func (h *UploadHandler) Status(c *gin.Context) {
+	status, err := h.repository.MeshUploadStatus(c.Param("id"))
+	if err != nil {
+		h.respondError(c, "Failed to get mesh upload", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// Chunk handles PUT /api/v1/meshes/upload/:id/chunk/:n, whose body is the
+// chunk's bytes
+func (h *UploadHandler) Chunk(c *gin.Context) {
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil {
+		h.respondError(c, "Invalid chunk", errors.BadRequest("chunk number must be an integer"))
+		return
+	}
+
+	// Oversized chunks are refused by the body size limit
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		h.respondError(c, "Invalid chunk", errors.BadRequest("failed to read chunk"))
+		return
+	}
+
+	status, err := h.repository.PutMeshUploadChunk(c.Param("id"), n, data)
+	if err != nil {
+		h.respondError(c, "Failed to store chunk", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// Complete handles POST /api/v1/meshes/upload/:id/complete, storing the
+// assembled mesh as POST /api/v1/ingest would
+func (h *UploadHandler) Complete(c *gin.Context) {
+	ctx, ok := backfillContext(c)
+	if !ok {
+		return
+	}
+
+	uploadID := c.Param("id")
+	sessionID, data, err := h.repository.AssembleMeshUpload(uploadID)
+	if err != nil {
+		h.respondError(c, "Failed to complete mesh upload", err)
+		return
+	}
+
+	// The chunks are final, so an invalid mesh cannot be fixed by resuming
+	var mesh api.Mesh
+	if err := json.Unmarshal(data, &mesh); err != nil {
+		h.repository.DiscardMeshUpload(uploadID)
+		respondBindingError(c, "Invalid uploaded mesh", err)
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&mesh); err != nil {
+		h.repository.DiscardMeshUpload(uploadID)
+		respondBindingError(c, "Invalid uploaded mesh", err)
+		return
+	}
+
+	result, event, err := h.repository.IngestMeshUpload(ctx, uploadID, sessionID, &mesh)
+	if err != nil {
+		h.respondError(c, "Failed to store uploaded mesh", err)
+		return
+	}
+
+	h.broadcaster.publish(logger.TraceID(c.Request.Context()), []api.SpatialEvent{*event})
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// respondError writes an APIError response, or a 500 for other errors
+func (h *UploadHandler) respondError(c *gin.Context, message string, err error) {
+	if apiErr, ok := errors.IsAPIError(err); ok {
+		c.JSON(apiErr.StatusCode, gin.H{
+			"error": apiErr.Message,
+			"code":  apiErr.Code,
+		})
+		return
+	}
+
+	h.logger.Errorf("%s: %v", message, err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": message,
+	})
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// Requests whose body or parameters fail to bind are answered with 400 and
+// an api.ErrorResponse whose details map each invalid field, named as in the
+// request, to the rule it broke.
+
+func init() {
+	// Name fields in validation errors by their JSON key or query parameter,
+	// as clients know them. Struct types cache their field names on first
+	// validation, so this must run before any request is bound.
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterTagNameFunc(requestFieldName)
+	}
+}
+
+// requestFieldName returns the name a request gives a struct field: its
+// json tag, else its form tag, else its Go name
+func requestFieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(field.Tag.Get(key), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// respondBindingError answers a request whose JSON body failed to bind
+func respondBindingError(c *gin.Context, message string, err error) {
+	c.JSON(http.StatusBadRequest, bindingError(message, err, fieldErrors(err)))
+}
+
+// respondQueryError answers a request whose query parameters failed to bind
+// into params. Gin does not name the parameter that failed to parse, so each
+// parameter given is parsed on its own to find it.
+func respondQueryError(c *gin.Context, message string, params interface{}, err error) {
+	details := fieldErrors(err)
+	if details == nil {
+		details = invalidParameters(params, c.Request.URL.Query())
+	}
+	c.JSON(http.StatusBadRequest, bindingError(message, err, details))
+}
+
+// bindingError builds the response for a binding failure. Without details,
+// the message carries the error itself.
+func bindingError(message string, err error, details map[string]interface{}) api.ErrorResponse {
+	if len(details) == 0 {
+		message = fmt.Sprintf("%s: %v", message, err)
+		details = nil
+	}
+	return api.ErrorResponse{
+		Code:    "VALIDATION_ERROR",
+		Message: message,
+		Error:   message,
+		Details: details,
+	}
+}
+
+// fieldErrors maps the fields a binding error names to why each is invalid,
+// or returns nil if it names none, as for malformed JSON
+func fieldErrors(err error) map[string]interface{} {
+	var validationErrs validator.ValidationErrors
+	if stderrors.As(err, &validationErrs) {
+		details := make(map[string]interface{}, len(validationErrs))
+		for _, fe := range validationErrs {
+			details[fieldPath(fe.Namespace())] = api.FieldError{
+				Rule:    fe.Tag(),
+				Param:   fe.Param(),
+				Message: ruleMessage(fe.Tag(), fe.Param()),
+			}
+		}
+		return details
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if stderrors.As(err, &typeErr) && typeErr.Field != "" {
+		kind := typeName(typeErr.Type)
+		return map[string]interface{}{
+			typeErr.Field: api.FieldError{Rule: "type", Param: kind, Message: "must be " + withArticle(kind)},
+		}
+	}
+	return nil
+}
+
+// fieldPath drops the struct type from a validation error's namespace,
+// leaving the field's path in the request, such as anchors[0].session_id
+func fieldPath(namespace string) string {
+	if _, path, ok := strings.Cut(namespace, "."); ok {
+		return path
+	}
+	return namespace
+}
+
+// invalidParameters returns the query parameters that do not parse into the
+// type of their field in params
+func invalidParameters(params interface{}, query url.Values) map[string]interface{} {
+	t := reflect.TypeOf(params)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	details := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("form"), ",")
+		values, ok := query[name]
+		if name == "" || name == "-" || !ok {
+			continue
+		}
+		probe := reflect.New(t).Interface()
+		if binding.MapFormWithTag(probe, map[string][]string{name: values}, "form") != nil {
+			kind := typeName(field.Type)
+			details[name] = api.FieldError{Rule: "type", Param: kind, Message: "must be " + withArticle(kind)}
+		}
+	}
+	return details
+}
+
+// ruleMessage describes a broken validation rule
+func ruleMessage(rule, param string) string {
+	switch rule {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		return "must be at least " + param
+	case "max", "lte":
+		return "must be at most " + param
+	case "gt":
+		return "must be greater than " + param
+	case "lt":
+		return "must be less than " + param
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(param, " ", ", ")
+	}
+	return fmt.Sprintf("fails the %s rule", rule)
+}
+
+// typeName names the JSON type a Go type is read from
+func typeName(t reflect.Type) string {
+	if t == nil {
+		return "value"
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return "value"
+}
+
+// withArticle prefixes a type name with its indefinite article
+func withArticle(kind string) string {
+	if strings.IndexByte("aeiou", kind[0]) >= 0 {
+		return "an " + kind
+	}
+	return "a " + kind
+}
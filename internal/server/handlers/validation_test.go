@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// bindResponse binds a request as the handlers do and returns the 400 body
+func bindResponse(t *testing.T, method, target, body string, bind func(c *gin.Context)) api.ErrorResponse {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, "/bind", bind)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp api.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Code != "VALIDATION_ERROR" || resp.Error == "" || resp.Error != resp.Message {
+		t.Errorf("Expected a VALIDATION_ERROR with its message under error, got %+v", resp)
+	}
+	return resp
+}
+
+func bindEvent(c *gin.Context) {
+	var event api.SpatialEvent
+	if err := c.ShouldBindJSON(&event); err != nil {
+		respondBindingError(c, "Invalid event", err)
+	}
+}
+
+// fieldDetail decodes the details reported for one field
+func fieldDetail(t *testing.T, resp api.ErrorResponse, field string) api.FieldError {
+	t.Helper()
+	raw, ok := resp.Details[field]
+	if !ok {
+		t.Fatalf("Expected details for %s, got %v", field, resp.Details)
+	}
+	data, _ := json.Marshal(raw)
+	var fe api.FieldError
+	if err := json.Unmarshal(data, &fe); err != nil {
+		t.Fatalf("Failed to decode details for %s: %v", field, err)
+	}
+	return fe
+}
+
+func TestBindingErrorDetails(t *testing.T) {
+	resp := bindResponse(t, http.MethodPost, "/bind", `{"event_id":"e1","timestamp":1}`, bindEvent)
+	if fe := fieldDetail(t, resp, "session_id"); fe.Rule != "required" || fe.Message != "is required" {
+		t.Errorf("Expected session_id required, got %+v", fe)
+	}
+	if len(resp.Details) != 1 {
+		t.Errorf("Expected only session_id reported, got %v", resp.Details)
+	}
+
+	resp = bindResponse(t, http.MethodPost, "/bind", `{"session_id":"s1","event_id":"e1","timestamp":"soon"}`, bindEvent)
+	if fe := fieldDetail(t, resp, "timestamp"); fe.Rule != "type" || fe.Param != "integer" || fe.Message != "must be an integer" {
+		t.Errorf("Expected timestamp to need an integer, got %+v", fe)
+	}
+
+	resp = bindResponse(t, http.MethodPost, "/bind", `{"id":"m1","anchor_id":"a1","compression_level":12}`, func(c *gin.Context) {
+		var mesh api.Mesh
+		if err := c.ShouldBindJSON(&mesh); err != nil {
+			respondBindingError(c, "Invalid mesh", err)
+		}
+	})
+	if fe := fieldDetail(t, resp, "compression_level"); fe.Rule != "max" || fe.Param != "9" || fe.Message != "must be at most 9" {
+		t.Errorf("Expected compression_level capped at 9, got %+v", fe)
+	}
+
+	// Malformed JSON names no field, so the message carries the error
+	resp = bindResponse(t, http.MethodPost, "/bind", `{"session_id":`, bindEvent)
+	if resp.Details != nil || !strings.HasPrefix(resp.Message, "Invalid event: ") {
+		t.Errorf("Expected the parse error in the message and no details, got %+v", resp)
+	}
+}
+
+func TestQueryErrorDetails(t *testing.T) {
+	bindQuery := func(c *gin.Context) {
+		var params api.QueryParams
+		if err := c.ShouldBindQuery(&params); err != nil {
+			respondQueryError(c, "Invalid query parameters", &params, err)
+		}
+	}
+
+	resp := bindResponse(t, http.MethodGet, "/bind?session_id=s1&limit=abc&radius=2.5", "", bindQuery)
+	if fe := fieldDetail(t, resp, "limit"); fe.Rule != "type" || fe.Param != "integer" {
+		t.Errorf("Expected limit to need an integer, got %+v", fe)
+	}
+	if len(resp.Details) != 1 {
+		t.Errorf("Expected only limit reported, got %v", resp.Details)
+	}
+
+	resp = bindResponse(t, http.MethodGet, "/bind?include_meshes=maybe&since=yesterday", "", bindQuery)
+	if fe := fieldDetail(t, resp, "include_meshes"); fe.Param != "boolean" || fe.Message != "must be a boolean" {
+		t.Errorf("Expected include_meshes to need a boolean, got %+v", fe)
+	}
+	if fe := fieldDetail(t, resp, "since"); fe.Param != "integer" {
+		t.Errorf("Expected since to need an integer, got %+v", fe)
+	}
+}
@@ -6,24 +6,40 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 
-	"github.com/tabular/stag-v2/internal/server/websocket"
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/server/middleware"
+	wshub "github.com/tabular/stag-v2/internal/server/websocket"
+	"github.com/tabular/stag-v2/pkg/errors"
 	"github.com/tabular/stag-v2/pkg/logger"
 )
 
 // WebSocketHandler handles WebSocket connections
 type WebSocketHandler struct {
-	hub      *websocket.Hub
+	hub      *wshub.Hub
+	auth     *middleware.APIKeyAuth
+	jwtAuth  *middleware.JWTAuth
 	upgrader websocket.Upgrader
 	logger   logger.Logger
 }
 
 // NewWebSocketHandler creates a new WebSocket handler
-func NewWebSocketHandler(hub *websocket.Hub, logger logger.Logger) *WebSocketHandler {
+func NewWebSocketHandler(hub *wshub.Hub, auth *middleware.APIKeyAuth, jwtAuth *middleware.JWTAuth, cfg config.WebSocketConfig, logger logger.Logger) *WebSocketHandler {
+	// Clients not offering the binary subprotocol are answered without one
+	var subprotocols []string
+	if cfg.BinarySubprotocol != "" {
+		subprotocols = []string{cfg.BinarySubprotocol}
+	}
+
 	return &WebSocketHandler{
-		hub: hub,
+		hub:     hub,
+		auth:    auth,
+		jwtAuth: jwtAuth,
 		upgrader: websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
+			ReadBufferSize:    cfg.ReadBufferSize,
+			WriteBufferSize:   cfg.WriteBufferSize,
+			EnableCompression: cfg.EnableCompression,
+			Subprotocols:      subprotocols,
 			CheckOrigin: func(r *http.Request) bool {
 				// TODO: Implement proper origin check for production
 				return true
@@ -44,25 +60,56 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	// Upgrade connection
-	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	// Authenticate before upgrading
+	scope, err := h.auth.Authenticate(c.Request)
+	if err != nil {
+		apiErr, _ := errors.IsAPIError(err)
+		c.JSON(apiErr.StatusCode, gin.H{
+			"error": apiErr.Message,
+			"code":  apiErr.Code,
+		})
+		return
+	}
+
+	// Restrict the connection to the sessions its token grants
+	var claims *middleware.SessionClaims
+	if h.jwtAuth.Enabled() {
+		claims, err = h.jwtAuth.Authenticate(c.Request)
+		if err == nil && !claims.Allows(sessionID) {
+			err = errors.Forbidden("token does not grant access to session " + sessionID)
+		}
+		if err != nil {
+			apiErr, _ := errors.IsAPIError(err)
+			c.JSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+	}
+
+	// Upgrade connection, metering the bytes it sends
+	conn, err := h.upgrader.Upgrade(h.hub.MeterResponse(c.Writer), c.Request, nil)
 	if err != nil {
 		h.logger.Errorf("Failed to upgrade connection: %v", err)
 		return
 	}
 
 	// Create client
-	client := websocket.NewClient(h.hub, conn, sessionID, h.logger.WithField("session_id", sessionID))
+	client := wshub.NewClient(h.hub, conn, sessionID, h.logger.WithField("session_id", sessionID))
+	client.SetReadOnly(scope < middleware.ScopeWrite)
+	if claims != nil {
+		client.SetSessionFilter(claims.Allows)
+	}
+	client.SetActor(audit.Actor{Principal: middleware.RequestPrincipal(c.Request, claims), ClientIP: c.ClientIP()})
 
-	// Register client
-	h.hub.Register(client)
+	// Register client; a rejected client has already been sent a close frame
+	if err := h.hub.Register(client); err != nil {
+		h.logger.Warnf("Rejected WebSocket connection for session %s: %v", sessionID, err)
+		return
+	}
 
 	// Start client goroutines
 	go client.WritePump()
 	go client.ReadPump()
 }
-
-// Register registers the client with the hub
-func (h *websocket.Hub) Register(client *websocket.Client) {
-	h.register <- client
-}
\ No newline at end of file
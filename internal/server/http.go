@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+// NewHTTPServer builds the HTTP server for handler from the server config.
+// With HTTP/2 enabled it is negotiated via ALPN under TLS and spoken in
+// cleartext (h2c) otherwise, for load balancers that terminate TLS in front
+// of us. HTTP/1.1 requests, including WebSocket upgrades, are served as
+// before either way.
+func NewHTTPServer(cfg config.ServerConfig, handler http.Handler) (*http.Server, error) {
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	httpServer.SetKeepAlivesEnabled(cfg.KeepAlives)
+
+	if !cfg.HTTP2 {
+		// A non-nil map stops net/http from enabling HTTP/2 under TLS on its own
+		httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return httpServer, nil
+	}
+
+	h2Server := &http2.Server{
+		MaxConcurrentStreams: cfg.HTTP2MaxConcurrentStreams,
+		IdleTimeout:          cfg.IdleTimeout,
+	}
+	if cfg.TLSEnabled() {
+		if err := http2.ConfigureServer(httpServer, h2Server); err != nil {
+			return nil, fmt.Errorf("failed to configure HTTP/2: %w", err)
+		}
+		return httpServer, nil
+	}
+
+	httpServer.Handler = h2c.NewHandler(handler, h2Server)
+	return httpServer, nil
+}
+
+// ListenAndServe serves httpServer, over TLS when the config names a certificate
+func ListenAndServe(httpServer *http.Server, cfg config.ServerConfig) error {
+	if cfg.TLSEnabled() {
+		return httpServer.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+	return httpServer.ListenAndServe()
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/http2"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+// testServerConfig mirrors the configured defaults
+func testServerConfig() config.ServerConfig {
+	return config.ServerConfig{
+		ReadTimeout:               30 * time.Second,
+		ReadHeaderTimeout:         10 * time.Second,
+		WriteTimeout:              30 * time.Second,
+		IdleTimeout:               120 * time.Second,
+		MaxHeaderBytes:            1 << 20,
+		KeepAlives:                true,
+		HTTP2MaxConcurrentStreams: 250,
+	}
+}
+
+// startHTTPServer serves handler on a loopback port and counts accepted connections
+func startHTTPServer(tb testing.TB, cfg config.ServerConfig, handler http.Handler) (string, *atomic.Int64) {
+	tb.Helper()
+	httpServer, err := NewHTTPServer(cfg, handler)
+	if err != nil {
+		tb.Fatalf("Failed to create server: %v", err)
+	}
+
+	var conns atomic.Int64
+	httpServer.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("Failed to listen: %v", err)
+	}
+	go httpServer.Serve(listener)
+	tb.Cleanup(func() { httpServer.Close() })
+
+	return listener.Addr().String(), &conns
+}
+
+// h2cClient speaks HTTP/2 over cleartext with prior knowledge
+func h2cClient() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func protoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})
+}
+
+func TestNewHTTPServerAppliesConfig(t *testing.T) {
+	cfg := testServerConfig()
+	cfg.Host, cfg.Port = "127.0.0.1", "9090"
+
+	httpServer, err := NewHTTPServer(cfg, protoHandler())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if httpServer.Addr != "127.0.0.1:9090" {
+		t.Errorf("Expected address 127.0.0.1:9090, got %s", httpServer.Addr)
+	}
+	if httpServer.ReadHeaderTimeout != 10*time.Second || httpServer.IdleTimeout != 120*time.Second {
+		t.Errorf("Expected configured timeouts, got header %v idle %v", httpServer.ReadHeaderTimeout, httpServer.IdleTimeout)
+	}
+	if httpServer.MaxHeaderBytes != 1<<20 {
+		t.Errorf("Expected max header bytes %d, got %d", 1<<20, httpServer.MaxHeaderBytes)
+	}
+	if httpServer.TLSNextProto == nil || len(httpServer.TLSNextProto) != 0 {
+		t.Error("Expected HTTP/2 to be disabled under TLS")
+	}
+}
+
+func TestHTTPServerProtocols(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cfg := testServerConfig()
+		cfg.HTTP2 = enabled
+		addr, _ := startHTTPServer(t, cfg, protoHandler())
+
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatalf("HTTP/1.1 request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "HTTP/1.1" {
+			t.Errorf("http2=%t: expected HTTP/1.1, got %s", enabled, body)
+		}
+
+		resp, err = h2cClient().Get("http://" + addr + "/")
+		if !enabled {
+			if err == nil {
+				resp.Body.Close()
+				t.Error("Expected h2c request to fail with HTTP/2 disabled")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("h2c request failed: %v", err)
+		}
+		body, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "HTTP/2.0" {
+			t.Errorf("Expected HTTP/2.0, got %s", body)
+		}
+	}
+}
+
+func TestHTTPServerWebSocketUpgradeWithH2C(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(messageType, data)
+	})
+
+	cfg := testServerConfig()
+	cfg.HTTP2 = true
+	addr, _ := startHTTPServer(t, cfg, handler)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatalf("WebSocket upgrade failed: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	if string(data) != "ping" {
+		t.Errorf("Expected echo of ping, got %q", data)
+	}
+}
+
+// BenchmarkConnectionReuse compares ingest-sized POSTs over fresh connections
+// with keep-alive and HTTP/2 connection reuse, reporting connections per request
+func BenchmarkConnectionReuse(b *testing.B) {
+	payload := strings.Repeat(`{"session_id":"bench","anchors":[]}`, 32)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	run := func(b *testing.B, cfg config.ServerConfig, client *http.Client) {
+		addr, conns := startHTTPServer(b, cfg, handler)
+		url := "http://" + addr + "/api/v1/ingest"
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			resp, err := client.Post(url, "application/json", strings.NewReader(payload))
+			if err != nil {
+				b.Fatalf("Request failed: %v", err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+	}
+
+	b.Run("NoKeepAlive", func(b *testing.B) {
+		cfg := testServerConfig()
+		cfg.KeepAlives = false
+		run(b, cfg, &http.Client{Transport: &http.Transport{}})
+	})
+	b.Run("KeepAlive", func(b *testing.B) {
+		run(b, testServerConfig(), &http.Client{Transport: &http.Transport{}})
+	})
+	b.Run("H2C", func(b *testing.B) {
+		cfg := testServerConfig()
+		cfg.HTTP2 = true
+		run(b, cfg, h2cClient())
+	})
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/audit"
+)
+
+// Principals of callers that present no token, or a token without a name
+const (
+	AnonymousPrincipal = "anonymous"
+	tokenPrincipal     = "jwt"
+)
+
+// AuditActor makes the caller of a request, as named by RequestPrincipal,
+// and its client IP the actor of the writes the request makes. It must run
+// after JWT authorization so the caller's claims are known.
+func AuditActor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := audit.Actor{Principal: RequestPrincipal(c.Request, RequestClaims(c)), ClientIP: c.ClientIP()}
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), actor))
+		c.Next()
+	}
+}
+
+// RequestPrincipal names the caller of r in audit records: the subject of
+// its JWT claims, or their tenant, or else the start of its API key's
+// SHA-256 digest, so that keys are told apart without being recorded
+func RequestPrincipal(r *http.Request, claims *SessionClaims) string {
+	if claims != nil {
+		switch {
+		case claims.Subject != "":
+			return claims.Subject
+		case claims.Tenant != "":
+			return "tenant:" + claims.Tenant
+		default:
+			return tokenPrincipal
+		}
+	}
+
+	key, err := bearerToken(r)
+	if err != nil {
+		return AnonymousPrincipal
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:4])
+}
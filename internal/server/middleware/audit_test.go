@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/audit"
+)
+
+func TestAuditActor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var actor audit.Actor
+	router := gin.New()
+	router.POST("/write", AuditActor(), func(c *gin.Context) {
+		actor, _ = audit.ActorFrom(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+	claimed := func(claims *SessionClaims) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set(ClaimsContextKey, claims)
+			c.Next()
+		}
+	}
+	router.POST("/subject", claimed(&SessionClaims{Subject: "alice", Tenant: "acme"}), AuditActor(), func(c *gin.Context) {
+		actor, _ = audit.ActorFrom(c.Request.Context())
+	})
+	router.POST("/tenant", claimed(&SessionClaims{Tenant: "acme"}), AuditActor(), func(c *gin.Context) {
+		actor, _ = audit.ActorFrom(c.Request.Context())
+	})
+
+	serve := func(path, header string) audit.Actor {
+		actor = audit.Actor{}
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		return actor
+	}
+
+	if got := serve("/write", ""); got.Principal != AnonymousPrincipal || got.ClientIP != "10.0.0.1" {
+		t.Errorf("Expected an anonymous caller from 10.0.0.1, got %+v", got)
+	}
+
+	// Keys are named by their digest, never recorded themselves
+	key := serve("/write", "Bearer secret-key").Principal
+	if !strings.HasPrefix(key, "key:") || strings.Contains(key, "secret") || key == serve("/write", "Bearer other-key").Principal {
+		t.Errorf("Expected distinct key digests, got %s", key)
+	}
+
+	if got := serve("/subject", "Bearer token").Principal; got != "alice" {
+		t.Errorf("Expected the token's subject, got %s", got)
+	}
+	if got := serve("/tenant", "Bearer token").Principal; got != "tenant:acme" {
+		t.Errorf("Expected the token's tenant, got %s", got)
+	}
+}
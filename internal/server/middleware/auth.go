@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Scope is the level of access granted to an API key
+type Scope int
+
+// API key scopes
+const (
+	ScopeRead Scope = iota + 1
+	ScopeWrite
+)
+
+// ScopeContextKey is the gin context key holding the authenticated scope
+const ScopeContextKey = "auth_scope"
+
+// APIKeyAuth validates bearer API keys against the configured key sets
+type APIKeyAuth struct {
+	// Keys are indexed by SHA-256 digest so lookups do not compare raw secrets
+	keys map[[sha256.Size]byte]Scope
+}
+
+// NewAPIKeyAuth creates an authenticator from configuration
+func NewAPIKeyAuth(cfg config.AuthConfig) *APIKeyAuth {
+	keys := make(map[[sha256.Size]byte]Scope)
+	for _, key := range cfg.ReadKeys {
+		if key != "" {
+			keys[sha256.Sum256([]byte(key))] = ScopeRead
+		}
+	}
+	// Write keys take precedence when a key is listed in both sets
+	for _, key := range cfg.WriteKeys {
+		if key != "" {
+			keys[sha256.Sum256([]byte(key))] = ScopeWrite
+		}
+	}
+
+	return &APIKeyAuth{keys: keys}
+}
+
+// Enabled reports whether any API keys are configured
+func (a *APIKeyAuth) Enabled() bool {
+	return len(a.keys) > 0
+}
+
+// Authenticate returns the scope granted by the request's bearer key. When
+// authentication is disabled every request is granted write access.
+func (a *APIKeyAuth) Authenticate(r *http.Request) (Scope, error) {
+	if !a.Enabled() {
+		return ScopeWrite, nil
+	}
+
+	key, err := bearerToken(r)
+	if err != nil {
+		return 0, err
+	}
+
+	scope, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return 0, errors.Unauthorized("invalid API key")
+	}
+
+	return scope, nil
+}
+
+// bearerToken extracts the credential from a Bearer Authorization header
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", errors.Unauthorized("missing Authorization header")
+	}
+
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || strings.TrimSpace(token) == "" {
+		return "", errors.Unauthorized("Authorization header must use the Bearer scheme")
+	}
+
+	return strings.TrimSpace(token), nil
+}
+
+// Require returns a middleware that rejects requests without the given scope
+func (a *APIKeyAuth) Require(required Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope, err := a.Authenticate(c.Request)
+		if err == nil && scope < required {
+			err = errors.Forbidden("API key does not allow write access")
+		}
+
+		if err != nil {
+			apiErr, _ := errors.IsAPIError(err)
+			c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		c.Set(ScopeContextKey, scope)
+		c.Next()
+	}
+}
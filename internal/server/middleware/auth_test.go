@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	auth := NewAPIKeyAuth(config.AuthConfig{
+		ReadKeys:  []string{"reader"},
+		WriteKeys: []string{"writer"},
+	})
+
+	router := gin.New()
+	router.GET("/read", auth.Require(ScopeRead), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/write", auth.Require(ScopeWrite), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		status int
+	}{
+		{"MissingHeader", http.MethodGet, "/read", "", http.StatusUnauthorized},
+		{"WrongScheme", http.MethodGet, "/read", "Basic reader", http.StatusUnauthorized},
+		{"UnknownKey", http.MethodGet, "/read", "Bearer nope", http.StatusUnauthorized},
+		{"ReadKeyReads", http.MethodGet, "/read", "Bearer reader", http.StatusOK},
+		{"ReadKeyCannotWrite", http.MethodPost, "/write", "Bearer reader", http.StatusForbidden},
+		{"WriteKeyReads", http.MethodGet, "/read", "Bearer writer", http.StatusOK},
+		{"WriteKeyWrites", http.MethodPost, "/write", "Bearer writer", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	t.Run("DisabledWithoutKeys", func(t *testing.T) {
+		open := NewAPIKeyAuth(config.AuthConfig{})
+		scope, err := open.Authenticate(httptest.NewRequest(http.MethodPost, "/write", nil))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if scope != ScopeWrite {
+			t.Errorf("Expected write scope when auth is disabled, got %v", scope)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"bytes"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// MaxBodySize rejects request bodies over limit bytes with 413. It must run
+// before anything reads the body. Bodies are read up front, so later
+// middleware and handlers see either the whole body or none of it; multipart
+// uploads are instead streamed through http.MaxBytesReader, and their
+// handlers report *http.MaxBytesError as 413.
+func MaxBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			abortTooLarge(c, limit)
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		if isMultipart(c) || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if stderrors.As(err, &tooLarge) {
+				abortTooLarge(c, limit)
+				return
+			}
+			apiErr := errors.BadRequest(fmt.Sprintf("failed to read request body: %v", err))
+			c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+// abortTooLarge responds with 413 for a body over limit bytes
+func abortTooLarge(c *gin.Context, limit int64) {
+	apiErr := errors.PayloadTooLarge(fmt.Sprintf("request body exceeds the %d byte limit", limit))
+	c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+		"error": apiErr.Message,
+		"code":  apiErr.Code,
+	})
+}
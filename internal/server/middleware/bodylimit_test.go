@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var received string
+	router := gin.New()
+	router.Use(MaxBodySize(64))
+	router.POST("/ingest", func(c *gin.Context) {
+		data, _ := c.GetRawData()
+		received = string(data)
+		c.Status(http.StatusOK)
+	})
+
+	oversized := `{"session_id":"` + strings.Repeat("x", 100) + `"}`
+
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool // Send without a Content-Length so only the read is capped
+		status  int
+	}{
+		{"WithinLimit", `{"session_id":"s1"}`, false, http.StatusOK},
+		{"Oversized", oversized, false, http.StatusRequestEntityTooLarge},
+		{"OversizedChunked", oversized, true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = ""
+			var body io.Reader = strings.NewReader(tt.body)
+			if tt.chunked {
+				body = io.MultiReader(body) // Hides the length from httptest
+			}
+			req := httptest.NewRequest(http.MethodPost, "/ingest", body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status == http.StatusOK {
+				if received != tt.body {
+					t.Errorf("Expected handler to receive the body, got %q", received)
+				}
+				return
+			}
+
+			var response map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response["code"] != "PAYLOAD_TOO_LARGE" {
+				t.Errorf("Expected a PAYLOAD_TOO_LARGE error, got %s", w.Body.String())
+			}
+			if received != "" {
+				t.Error("Expected the handler not to run")
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// compressRouter serves a large query response, a small one and a binary one
+func compressRouter(cfg config.ResponseCompressionConfig, large *api.QueryResponse) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Compress(cfg))
+	router.GET("/query", func(c *gin.Context) { c.JSON(http.StatusOK, large) })
+	router.GET("/small", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"count": 0}) })
+	router.GET("/vertices", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/octet-stream", bytes.Repeat([]byte{1}, 4096))
+	})
+	router.GET("/encoded", func(c *gin.Context) {
+		c.Header("Content-Encoding", "gzip")
+		c.Data(http.StatusOK, "text/plain", bytes.Repeat([]byte{2}, 4096))
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Type", "application/x-ndjson")
+		for i := 0; i < 100; i++ {
+			c.Writer.WriteString(`{"type":"anchor","padding":"` + strings.Repeat("x", 64) + `"}` + "\n")
+			c.Writer.Flush()
+		}
+	})
+	return router
+}
+
+// largeQueryResponse is a query result with mesh byte buffers
+func largeQueryResponse() *api.QueryResponse {
+	response := &api.QueryResponse{}
+	for i := 0; i < 50; i++ {
+		response.Anchors = append(response.Anchors, api.Anchor{ID: "anchor", SessionID: "s1", Timestamp: int64(i)})
+		response.Meshes = append(response.Meshes, api.Mesh{
+			ID:       "mesh",
+			AnchorID: "anchor",
+			Vertices: bytes.Repeat([]byte{0, 0, 128, 63}, 256),
+			Faces:    bytes.Repeat([]byte{0, 1, 2}, 128),
+		})
+	}
+	response.Count = len(response.Anchors)
+	return response
+}
+
+func TestCompressLargeQueryResponse(t *testing.T) {
+	large := largeQueryResponse()
+	router := compressRouter(config.ResponseCompressionConfig{Enabled: true, MinSize: 1024, Level: 5}, large)
+
+	req := httptest.NewRequest(http.MethodGet, "/query", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("Expected Content-Encoding gzip, got %q", encoding)
+	}
+	if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got %v", vary)
+	}
+
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Failed to open gzip body: %v", err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decompress body: %v", err)
+	}
+
+	var got api.QueryResponse
+	if err := json.Unmarshal(decoded, &got); err != nil {
+		t.Fatalf("Failed to decode decompressed body: %v", err)
+	}
+	if !reflect.DeepEqual(&got, large) {
+		t.Error("Expected the decompressed response to match the query result")
+	}
+
+	plain, _ := json.Marshal(large)
+	if compressed := w.Body.Len(); compressed >= len(plain) {
+		t.Errorf("Expected the encoded body smaller than %d bytes, got %d", len(plain), compressed)
+	}
+}
+
+func TestCompressDeflate(t *testing.T) {
+	large := largeQueryResponse()
+	router := compressRouter(config.ResponseCompressionConfig{Enabled: true, MinSize: 1024, Level: 9}, large)
+
+	req := httptest.NewRequest(http.MethodGet, "/query", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0.5, deflate")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "deflate" {
+		t.Fatalf("Expected Content-Encoding deflate, got %q", encoding)
+	}
+	reader, err := zlib.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Failed to open deflate body: %v", err)
+	}
+	var got api.QueryResponse
+	if err := json.NewDecoder(reader).Decode(&got); err != nil || got.Count != large.Count {
+		t.Errorf("Expected the decoded query result, got %+v, %v", got, err)
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	router := compressRouter(config.ResponseCompressionConfig{Enabled: true, MinSize: 1024, Level: 5}, largeQueryResponse())
+
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		upgrade  bool
+		encoding string // Expected Content-Encoding
+	}{
+		{"NoAcceptEncoding", "/query", "", false, ""},
+		{"Refused", "/query", "gzip;q=0, deflate;q=0", false, ""},
+		{"BelowMinSize", "/small", "gzip", false, ""},
+		{"BinaryMesh", "/vertices", "gzip", false, ""},
+		{"AlreadyEncoded", "/encoded", "gzip", false, "gzip"},
+		{"Stream", "/stream", "gzip", false, ""},
+		{"WebSocketUpgrade", "/query", "gzip", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			if tt.upgrade {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			if encoding := w.Header().Get("Content-Encoding"); encoding != tt.encoding {
+				t.Errorf("Expected Content-Encoding %q, got %q", tt.encoding, encoding)
+			}
+			if tt.encoding == "" && w.Body.Len() == 0 {
+				t.Error("Expected the unencoded body")
+			}
+		})
+	}
+}
+
+func TestCompressDisabled(t *testing.T) {
+	router := compressRouter(config.ResponseCompressionConfig{}, largeQueryResponse())
+
+	req := httptest.NewRequest(http.MethodGet, "/query", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+		t.Errorf("Expected no Content-Encoding when disabled, got %q", encoding)
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate;q=0.8", "deflate"},
+		{"br, *", "gzip"},
+		{"*;q=0.5, gzip;q=0", "deflate"},
+		{"identity", ""},
+		{"GZIP; q=1.0", "gzip"},
+		{"gzip;q=bad", ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.accept, encodingGzip, encodingDeflate); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// encodingIdentity is the export encoding of responses sent as they are
+const encodingIdentity = "identity"
+
+// exportEncodings are the codecs exports can be encoded with, in order of
+// preference
+var exportEncodings = []string{spatial.CodecZstd, spatial.CodecGzip}
+
+// ExportEncoding returns a middleware that encodes successful downloads of an
+// export endpoint with zstd or gzip, using the mesh storage codecs. The codec
+// is the one named by the compression query parameter, which may also be
+// "none", or else the one preferred of those the request's Accept-Encoding
+// accepts; clients accepting neither get the download unencoded. Downloads
+// are encoded as they are written rather than buffered, and their size as
+// sent is recorded under endpoint.
+func ExportEncoding(endpoint string, cfg config.ExportCompressionConfig, m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Response compression may already vary on the header
+		if !slices.Contains(c.Writer.Header().Values("Vary"), "Accept-Encoding") {
+			c.Writer.Header().Add("Vary", "Accept-Encoding")
+		}
+
+		encoding, err := exportEncoding(c.Query("compression"), c.GetHeader("Accept-Encoding"))
+		if err != nil {
+			apiErr, _ := errors.IsAPIError(err)
+			c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		writer := &exportWriter{
+			ResponseWriter: c.Writer,
+			encoding:       encoding,
+			level:          cfg.Level,
+		}
+		writer.sent.w = c.Writer
+		c.Writer = writer
+		c.Next()
+
+		writer.close()
+		if writer.decided && writer.successful() {
+			m.ExportBytes.WithLabelValues(endpoint, writer.sentEncoding()).Observe(float64(writer.sent.n))
+		}
+	}
+}
+
+// exportEncoding returns the codec requested by a compression parameter, or
+// else negotiated from an Accept-Encoding header, or "" for none
+func exportEncoding(requested, accept string) (string, error) {
+	switch requested = strings.ToLower(requested); requested {
+	case "":
+		return negotiateEncoding(accept, exportEncodings...), nil
+	case "none", encodingIdentity:
+		return "", nil
+	}
+	for _, encoding := range exportEncodings {
+		if requested == encoding {
+			return encoding, nil
+		}
+	}
+	return "", errors.ValidationError(fmt.Sprintf("compression must be one of %s or none", strings.Join(exportEncodings, ", ")))
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	n, err := w.w.Write(data)
+	w.n += int64(n)
+	return n, err
+}
+
+// exportWriter encodes a successful response with its codec once the
+// handler starts writing it. Error responses are sent as they are.
+type exportWriter struct {
+	gin.ResponseWriter
+	encoding string // "" sends every response unencoded
+	level    int
+
+	sent    countingWriter // Bytes sent, after encoding
+	decided bool
+	encoder io.WriteCloser // nil when passing through
+}
+
+// Unwrap lets streamed downloads lift the server's write deadline through
+// http.ResponseController
+func (w *exportWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *exportWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+	return w.sent.Write(data)
+}
+
+func (w *exportWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been encoded so far
+func (w *exportWriter) Flush() {
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide starts encoding the response if it succeeded
+func (w *exportWriter) decide() error {
+	w.decided = true
+	if w.encoding == "" || !w.successful() || w.Header().Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	encoder, err := spatial.NewCodecWriter(w.encoding, &w.sent, w.level)
+	if err != nil {
+		return err
+	}
+	header := w.Header()
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	w.encoder = encoder
+	return nil
+}
+
+// successful reports whether the response has a success status
+func (w *exportWriter) successful() bool {
+	status := w.Status()
+	return status >= http.StatusOK && status < http.StatusMultipleChoices
+}
+
+// sentEncoding returns the encoding the response was sent with
+func (w *exportWriter) sentEncoding() string {
+	if w.encoder == nil {
+		return encodingIdentity
+	}
+	return w.encoding
+}
+
+// close ends the encoded stream
+func (w *exportWriter) close() error {
+	if w.encoder == nil {
+		return nil
+	}
+	return w.encoder.Close()
+}
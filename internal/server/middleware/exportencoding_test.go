@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/metrics"
+)
+
+func exportRouter(body []byte, m *metrics.Metrics) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/export", ExportEncoding("test", config.ExportCompressionConfig{Level: 5}, m), func(c *gin.Context) {
+		if c.Query("missing") == "true" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.Data(http.StatusOK, "model/gltf-binary", body)
+	})
+	return router
+}
+
+func TestExportEncoding(t *testing.T) {
+	body := bytes.Repeat([]byte("glTF binary payload "), 1000)
+	m := &metrics.Metrics{
+		ExportBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "export_bytes"}, []string{"endpoint", "encoding"}),
+	}
+	router := exportRouter(body, m)
+
+	tests := []struct {
+		name     string
+		query    string
+		accept   string
+		encoding string
+	}{
+		{"negotiated zstd", "", "gzip, zstd", "zstd"},
+		{"negotiated gzip", "", "gzip, br", "gzip"},
+		{"requested codec", "?compression=gzip", "zstd", "gzip"},
+		{"requested without accept", "?compression=zstd", "", "zstd"},
+		{"requested none", "?compression=none", "gzip", ""},
+		{"not accepted", "", "br", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/export"+tt.query, nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Expected Content-Encoding %q, got %q", tt.encoding, got)
+			}
+			if !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+				t.Error("Expected the response to vary on Accept-Encoding")
+			}
+
+			var decoded []byte
+			var err error
+			switch tt.encoding {
+			case "gzip":
+				var r *gzip.Reader
+				if r, err = gzip.NewReader(w.Body); err == nil {
+					decoded, err = io.ReadAll(r)
+				}
+			case "zstd":
+				var r *zstd.Decoder
+				if r, err = zstd.NewReader(w.Body); err == nil {
+					decoded, err = io.ReadAll(r)
+					r.Close()
+				}
+			default:
+				decoded = w.Body.Bytes()
+			}
+			if err != nil || !bytes.Equal(decoded, body) {
+				t.Errorf("Expected the body decoded intact, got %d bytes, %v", len(decoded), err)
+			}
+			if tt.encoding != "" && w.Body.Len() >= len(body) {
+				t.Errorf("Expected the body compressed, got %d bytes", w.Body.Len())
+			}
+
+			label := tt.encoding
+			if label == "" {
+				label = "identity"
+			}
+			if !m.ExportBytes.DeleteLabelValues("test", label) || testutil.CollectAndCount(m.ExportBytes) != 0 {
+				t.Errorf("Expected the sent size recorded as %s only", label)
+			}
+		})
+	}
+}
+
+func TestExportEncodingErrors(t *testing.T) {
+	m := &metrics.Metrics{
+		ExportBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "export_bytes"}, []string{"endpoint", "encoding"}),
+	}
+	router := exportRouter([]byte("payload"), m)
+
+	// Error responses are sent unencoded
+	req := httptest.NewRequest(http.MethodGet, "/export?missing=true", nil)
+	req.Header.Set("Accept-Encoding", "zstd")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Encoding") != "" || !strings.Contains(w.Body.String(), "not found") {
+		t.Errorf("Expected an unencoded 404, got %d %q %s", w.Code, w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+
+	// Unknown codecs are refused
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export?compression=br", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown codec, got %d", w.Code)
+	}
+	if testutil.CollectAndCount(m.ExportBytes) != 0 {
+		t.Error("Expected no sizes recorded for errors")
+	}
+}
@@ -0,0 +1,294 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// IdempotencyKeyHeader names a request so that retries of it are answered
+// with the original response instead of being processed again
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayHeader is set on responses replayed for a retried request
+const IdempotentReplayHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength bounds client-supplied idempotency keys
+const maxIdempotencyKeyLength = 255
+
+// IdempotentResponse is a recorded response to a request
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	Fingerprint string // Digest of the request body it answered
+}
+
+// IdempotencyStore records responses by idempotency key. Only one caller at
+// a time may hold a key, so concurrent duplicates are processed once.
+type IdempotencyStore interface {
+	// Claim reserves key for the caller and returns nil, or returns the
+	// response recorded for key. While another caller holds the key, Claim
+	// waits for it to finish or ctx to end.
+	Claim(ctx context.Context, key string) (*IdempotentResponse, error)
+
+	// Complete records the response to a claimed key and releases it
+	Complete(key string, response *IdempotentResponse)
+
+	// Release gives up a claim without recording a response, so the next
+	// request with the key is processed
+	Release(key string)
+}
+
+// idempotencyEntry is a claimed key, or its recorded response
+type idempotencyEntry struct {
+	response *IdempotentResponse // nil while claimed
+	done     chan struct{}       // Closed when the claim ends
+	expires  time.Time
+	element  *list.Element // Position in the recorded order
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore held in memory, so recorded
+// responses are lost on restart and not shared between instances
+type MemoryIdempotencyStore struct {
+	mu       sync.Mutex
+	entries  map[string]*idempotencyEntry
+	recorded *list.List // Keys with responses, oldest first
+	ttl      time.Duration
+	maxKeys  int // 0 is unlimited
+}
+
+// NewMemoryIdempotencyStore creates a store keeping responses for the
+// configured TTL
+func NewMemoryIdempotencyStore(cfg config.IdempotencyConfig) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		entries:  make(map[string]*idempotencyEntry),
+		recorded: list.New(),
+		ttl:      cfg.TTL,
+		maxKeys:  cfg.MaxKeys,
+	}
+}
+
+// Claim implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Claim(ctx context.Context, key string) (*IdempotentResponse, error) {
+	for {
+		s.mu.Lock()
+		s.prune(time.Now())
+
+		entry, ok := s.entries[key]
+		if !ok {
+			s.entries[key] = &idempotencyEntry{done: make(chan struct{})}
+			s.mu.Unlock()
+			return nil, nil
+		}
+		if entry.response != nil {
+			s.mu.Unlock()
+			return entry.response, nil
+		}
+		done := entry.done
+		s.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Complete implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Complete(key string, response *IdempotentResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || entry.response != nil {
+		return
+	}
+
+	now := time.Now()
+	entry.response = response
+	entry.expires = now.Add(s.ttl)
+	entry.element = s.recorded.PushBack(key)
+	close(entry.done)
+
+	s.prune(now)
+}
+
+// Release implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.response == nil {
+		delete(s.entries, key)
+		close(entry.done)
+	}
+}
+
+// prune forgets expired responses and the oldest beyond maxKeys. Responses
+// share one TTL, so they expire in the order they were recorded. Callers
+// must hold s.mu.
+func (s *MemoryIdempotencyStore) prune(now time.Time) {
+	for front := s.recorded.Front(); front != nil; front = s.recorded.Front() {
+		key := front.Value.(string)
+		if now.Before(s.entries[key].expires) && (s.maxKeys == 0 || s.recorded.Len() <= s.maxKeys) {
+			return
+		}
+		s.recorded.Remove(front)
+		delete(s.entries, key)
+	}
+}
+
+// Len returns the number of recorded responses
+func (s *MemoryIdempotencyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.recorded.Len()
+}
+
+// recordingWriter keeps a copy of the response body written through it
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency returns a middleware that answers a retried ingest request with
+// the response to the original instead of processing it again. Requests are
+// identified within their session by the Idempotency-Key header, or else by
+// their event_id and body. Only successful responses are recorded, so failed
+// requests may be retried. A nil store disables the middleware.
+func Idempotency(store IdempotencyStore, m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if store == nil || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader(IdempotencyKeyHeader)
+		if len(header) > maxIdempotencyKeyLength {
+			apiErr := errors.BadRequest("Idempotency-Key must be at most 255 characters")
+			c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		// Restore the body for the handler
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		key, fingerprint := idempotencyKey(header, body)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		recorded, err := store.Claim(c.Request.Context(), key)
+		if err != nil {
+			apiErr := errors.Conflict("a request with this idempotency key is still being processed")
+			c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		if recorded != nil {
+			if recorded.Fingerprint != fingerprint {
+				apiErr := errors.UnprocessableEntity("Idempotency-Key was already used for a different request")
+				c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+					"error": apiErr.Message,
+					"code":  apiErr.Code,
+				})
+				return
+			}
+
+			m.IdempotentReplays.Inc()
+			c.Header(IdempotentReplayHeader, "true")
+			c.Data(recorded.StatusCode, recorded.ContentType, recorded.Body)
+			c.Abort()
+			return
+		}
+
+		// Release the key however the handler ends unless its response is kept
+		completed := false
+		defer func() {
+			if !completed {
+				store.Release(key)
+			}
+		}()
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		if status := writer.Status(); status >= 200 && status < 300 {
+			store.Complete(key, &IdempotentResponse{
+				StatusCode:  status,
+				ContentType: writer.Header().Get("Content-Type"),
+				Body:        writer.body.Bytes(),
+				Fingerprint: fingerprint,
+			})
+			completed = true
+		}
+	}
+}
+
+// idempotencyKey derives the key identifying an ingest request, scoped to
+// its session, and a fingerprint of its body. Without a header, only
+// identical bodies share a key. It returns an empty key for requests that
+// cannot be identified.
+func idempotencyKey(header string, body []byte) (key, fingerprint string) {
+	events := decodeBodyEvents(body)
+	if len(events) != 1 || events[0].SessionID == "" {
+		return "", ""
+	}
+	event := events[0]
+
+	sum := sha256.Sum256(body)
+	fingerprint = hex.EncodeToString(sum[:])
+
+	switch {
+	case header != "":
+		return event.SessionID + "\x00key\x00" + header, fingerprint
+	case event.EventID != "":
+		return event.SessionID + "\x00event\x00" + event.EventID + "\x00" + fingerprint, fingerprint
+	default:
+		return "", ""
+	}
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	store := NewMemoryIdempotencyStore(config.IdempotencyConfig{TTL: time.Minute, MaxKeys: 2})
+	ctx := context.Background()
+
+	if recorded, err := store.Claim(ctx, "a"); recorded != nil || err != nil {
+		t.Fatalf("Expected a new key claimed, got %v, %v", recorded, err)
+	}
+
+	// A released key may be claimed again
+	store.Release("a")
+	if recorded, _ := store.Claim(ctx, "a"); recorded != nil {
+		t.Fatalf("Expected a released key claimed again, got %v", recorded)
+	}
+
+	store.Complete("a", &IdempotentResponse{StatusCode: http.StatusOK, Body: []byte("first")})
+	if recorded, _ := store.Claim(ctx, "a"); recorded == nil || string(recorded.Body) != "first" {
+		t.Fatalf("Expected the recorded response, got %v", recorded)
+	}
+
+	// Beyond max keys the oldest response is forgotten
+	for _, key := range []string{"b", "c"} {
+		store.Claim(ctx, key)
+		store.Complete(key, &IdempotentResponse{StatusCode: http.StatusOK})
+	}
+	if store.Len() != 2 {
+		t.Errorf("Expected 2 recorded responses, got %d", store.Len())
+	}
+	if recorded, _ := store.Claim(ctx, "a"); recorded != nil {
+		t.Errorf("Expected the oldest response forgotten, got %v", recorded)
+	}
+	store.Release("a")
+
+	// Responses expire after the TTL
+	store.prune(time.Now().Add(2 * time.Minute))
+	if store.Len() != 0 {
+		t.Errorf("Expected expired responses forgotten, got %d", store.Len())
+	}
+}
+
+func TestMemoryIdempotencyStoreWaitsForClaim(t *testing.T) {
+	store := NewMemoryIdempotencyStore(config.IdempotencyConfig{TTL: time.Minute})
+	store.Claim(context.Background(), "key")
+
+	// A duplicate waits while the key is held
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := store.Claim(ctx, "key"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the duplicate to wait until its context ended, got %v", err)
+	}
+
+	result := make(chan *IdempotentResponse, 1)
+	go func() {
+		recorded, _ := store.Claim(context.Background(), "key")
+		result <- recorded
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	store.Complete("key", &IdempotentResponse{StatusCode: http.StatusOK, Body: []byte("done")})
+
+	select {
+	case recorded := <-result:
+		if recorded == nil || string(recorded.Body) != "done" {
+			t.Errorf("Expected the waiting duplicate to get the response, got %v", recorded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Duplicate still waiting after the key was completed")
+	}
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := NewMemoryIdempotencyStore(config.IdempotencyConfig{TTL: time.Minute})
+	var processed atomic.Int32
+	release := make(chan struct{})
+	close(release)
+	var gate atomic.Value
+	gate.Store(release)
+
+	router := gin.New()
+	router.POST("/ingest", Idempotency(store, testMetrics), func(c *gin.Context) {
+		<-gate.Load().(chan struct{})
+		n := processed.Add(1)
+		if c.GetHeader("X-Fail") == "yes" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"processed": n})
+	})
+
+	send := func(body, key string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	replays := testutil.ToFloat64(testMetrics.IdempotentReplays)
+	event := `{"session_id":"s1","event_id":"e1","timestamp":1}`
+
+	t.Run("Header", func(t *testing.T) {
+		first := send(event, "k1")
+		retry := send(event, "k1")
+		if first.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+			t.Fatalf("Expected the original response replayed, got %d %q then %d %q", first.Code, first.Body, retry.Code, retry.Body)
+		}
+		if retry.Header().Get(IdempotentReplayHeader) != "true" || first.Header().Get(IdempotentReplayHeader) != "" {
+			t.Error("Expected only the replay marked as replayed")
+		}
+
+		if reused := send(`{"session_id":"s1","event_id":"e2","timestamp":1}`, "k1"); reused.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for a key reused with another body, got %d", reused.Code)
+		}
+
+		// Keys are scoped to their session
+		if other := send(`{"session_id":"s2","event_id":"e1","timestamp":1}`, "k1"); other.Header().Get(IdempotentReplayHeader) != "" {
+			t.Error("Expected another session's request with the key processed")
+		}
+	})
+
+	t.Run("EventID", func(t *testing.T) {
+		before := processed.Load()
+		body := `{"session_id":"s1","event_id":"e3","timestamp":1}`
+		send(body, "")
+		if retry := send(body, ""); retry.Header().Get(IdempotentReplayHeader) != "true" {
+			t.Error("Expected an identical event replayed")
+		}
+
+		// The same event ID with different data is processed
+		send(`{"session_id":"s1","event_id":"e3","timestamp":2}`, "")
+		if got := processed.Load() - before; got != 2 {
+			t.Errorf("Expected 2 events processed, got %d", got)
+		}
+	})
+
+	t.Run("FailureNotRecorded", func(t *testing.T) {
+		if failed := send(event, "k2", "X-Fail", "yes"); failed.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected the failure returned, got %d", failed.Code)
+		}
+		if retry := send(event, "k2"); retry.Code != http.StatusOK || retry.Header().Get(IdempotentReplayHeader) != "" {
+			t.Errorf("Expected the retry processed, got %d", retry.Code)
+		}
+	})
+
+	t.Run("ConcurrentDuplicates", func(t *testing.T) {
+		before := processed.Load()
+		blocked := make(chan struct{})
+		gate.Store(blocked)
+
+		var wg sync.WaitGroup
+		responses := make([]*httptest.ResponseRecorder, 5)
+		for i := range responses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				responses[i] = send(event, "k3")
+			}(i)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(blocked)
+		wg.Wait()
+
+		if got := processed.Load() - before; got != 1 {
+			t.Errorf("Expected concurrent duplicates processed once, got %d", got)
+		}
+		for i, response := range responses {
+			if response.Code != http.StatusOK || response.Body.String() != responses[0].Body.String() {
+				t.Errorf("Expected response %d to match the original, got %d %q", i, response.Code, response.Body)
+			}
+		}
+	})
+
+	if got := testutil.ToFloat64(testMetrics.IdempotentReplays) - replays; got != 6 {
+		t.Errorf("Expected 6 replays counted, got %v", got)
+	}
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// ingestQueueRetryAfter is the Retry-After, in seconds, of requests refused
+// with the queue full
+const ingestQueueRetryAfter = "1"
+
+// IngestQueue bounds how many ingest requests are processed at once.
+// Requests beyond the worker count wait for a free worker, up to a maximum
+// queue depth past which they are refused.
+type IngestQueue struct {
+	workers  chan struct{} // Holds a token per request being processed
+	maxQueue int
+	metrics  *metrics.Metrics
+
+	mu      sync.Mutex
+	waiting int
+}
+
+// NewIngestQueue creates an ingest queue, or returns nil if concurrency is
+// unlimited
+func NewIngestQueue(cfg config.IngestQueueConfig, m *metrics.Metrics) *IngestQueue {
+	if cfg.Workers <= 0 {
+		return nil
+	}
+	return &IngestQueue{
+		workers:  make(chan struct{}, cfg.Workers),
+		maxQueue: cfg.MaxQueue,
+		metrics:  m,
+	}
+}
+
+// Acquire waits for a free worker, returning the function that frees it. It
+// fails with a 503 when the queue is full, and with ctx's error when ctx is
+// done first, leaving the queue.
+func (q *IngestQueue) Acquire(ctx context.Context) (func(), error) {
+	release := func() { <-q.workers }
+
+	// Take a free worker without queueing
+	select {
+	case q.workers <- struct{}{}:
+		q.metrics.IngestQueueWait.Observe(0)
+		return release, nil
+	default:
+	}
+
+	q.mu.Lock()
+	if q.waiting >= q.maxQueue {
+		q.mu.Unlock()
+		q.metrics.IngestQueueRejected.WithLabelValues("full").Inc()
+		return nil, errors.ServerBusy("ingest queue is full")
+	}
+	q.waiting++
+	q.metrics.IngestQueueDepth.Set(float64(q.waiting))
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		q.waiting--
+		q.metrics.IngestQueueDepth.Set(float64(q.waiting))
+		q.mu.Unlock()
+	}()
+
+	start := time.Now()
+	select {
+	case q.workers <- struct{}{}:
+		q.metrics.IngestQueueWait.Observe(time.Since(start).Seconds())
+		return release, nil
+	case <-ctx.Done():
+		q.metrics.IngestQueueRejected.WithLabelValues("canceled").Inc()
+		return nil, ctx.Err()
+	}
+}
+
+// IngestLimit returns a middleware that processes each request with a worker
+// of q. A nil queue leaves concurrency unlimited.
+func IngestLimit(q *IngestQueue) gin.HandlerFunc {
+	if q == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		release, err := q.Acquire(c.Request.Context())
+		if err != nil {
+			apiErr, ok := errors.IsAPIError(err)
+			if !ok {
+				// The client went away while queued
+				apiErr = errors.ServerBusy("request canceled while waiting for an ingest worker")
+			} else {
+				c.Header("Retry-After", ingestQueueRetryAfter)
+			}
+			c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+		defer release()
+
+		c.Next()
+	}
+}
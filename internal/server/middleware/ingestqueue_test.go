@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+func TestIngestQueueAcquire(t *testing.T) {
+	if NewIngestQueue(config.IngestQueueConfig{MaxQueue: 10}, testMetrics) != nil {
+		t.Error("Expected no queue without workers")
+	}
+
+	q := NewIngestQueue(config.IngestQueueConfig{Workers: 1, MaxQueue: 1}, testMetrics)
+	release, err := q.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Expected a free worker, got %v", err)
+	}
+
+	// The next request waits for the worker
+	acquired := make(chan func())
+	go func() {
+		next, err := q.Acquire(context.Background())
+		if err != nil {
+			t.Errorf("Expected the queued request to get a worker, got %v", err)
+		}
+		acquired <- next
+	}()
+	waitForQueued(t, q, 1)
+
+	// With the queue full, further requests are refused
+	rejected := testutil.ToFloat64(testMetrics.IngestQueueRejected.WithLabelValues("full"))
+	_, err = q.Acquire(context.Background())
+	if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.StatusCode != http.StatusServiceUnavailable || !apiErr.Retryable {
+		t.Errorf("Expected a retryable 503 with the queue full, got %v", err)
+	}
+	if got := testutil.ToFloat64(testMetrics.IngestQueueRejected.WithLabelValues("full")) - rejected; got != 1 {
+		t.Errorf("Expected 1 rejection counted, got %v", got)
+	}
+
+	release()
+	next := <-acquired
+	waitForQueued(t, q, 0)
+
+	// Canceled requests leave the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := q.Acquire(ctx)
+		done <- err
+	}()
+	waitForQueued(t, q, 1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected the canceled request to fail with its context, got %v", err)
+	}
+	waitForQueued(t, q, 0)
+
+	next()
+	if release, err := q.Acquire(context.Background()); err != nil {
+		t.Errorf("Expected the worker free again, got %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestIngestLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	q := NewIngestQueue(config.IngestQueueConfig{Workers: 1}, testMetrics)
+	router := gin.New()
+	router.POST("/ingest", IngestLimit(q), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ingest", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 with a free worker, got %d", w.Code)
+	}
+
+	// The worker is released after each request; hold it to fill the queue
+	release, err := q.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the worker released, got %v", err)
+	}
+	defer release()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ingest", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 503 with Retry-After when busy, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Without a queue requests are not limited
+	router = gin.New()
+	router.POST("/ingest", IngestLimit(nil), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ingest", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 without a queue, got %d", w.Code)
+	}
+}
+
+// waitForQueued waits until n requests are queued
+func waitForQueued(t *testing.T, q *IngestQueue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		q.mu.Lock()
+		waiting := q.waiting
+		q.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued requests, got %d", n, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
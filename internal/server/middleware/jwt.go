@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// ClaimsContextKey is the gin context key holding the caller's SessionClaims
+const ClaimsContextKey = "auth_claims"
+
+// SessionClaims are the JWT claims granting access to sessions
+type SessionClaims struct {
+	Subject   string   `json:"sub"`      // Names the caller in audit records
+	Sessions  []string `json:"sessions"` // Session IDs the caller may access
+	Tenant    string   `json:"tenant"`   // Grants the session named after the tenant and every "<tenant>:" session
+	ExpiresAt float64  `json:"exp"`
+	NotBefore float64  `json:"nbf"`
+}
+
+// Allows reports whether the claims grant access to sessionID
+func (c *SessionClaims) Allows(sessionID string) bool {
+	if c.Tenant != "" && (sessionID == c.Tenant || strings.HasPrefix(sessionID, c.Tenant+":")) {
+		return true
+	}
+	for _, allowed := range c.Sessions {
+		if allowed == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestClaims returns the claims the request's token was verified with, or
+// nil when it was not authorized by a JWT
+func RequestClaims(c *gin.Context) *SessionClaims {
+	value, _ := c.Get(ClaimsContextKey)
+	claims, _ := value.(*SessionClaims)
+	return claims
+}
+
+// JWTAuth restricts callers to the sessions named in a signed JWT
+type JWTAuth struct {
+	secret    []byte         // HS256 key
+	publicKey *rsa.PublicKey // RS256 key
+	clockSkew time.Duration
+}
+
+// NewJWTAuth creates a JWT authorizer, loading the RS256 public key if configured
+func NewJWTAuth(cfg config.JWTConfig) (*JWTAuth, error) {
+	a := &JWTAuth{
+		secret:    []byte(cfg.Secret),
+		clockSkew: cfg.ClockSkew,
+	}
+
+	if cfg.PublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+		}
+		if a.publicKey, err = parseRSAPublicKey(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// parseRSAPublicKey decodes a PEM-encoded PKIX or PKCS#1 RSA public key
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT public key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT public key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("JWT public key is not an RSA key")
+	}
+	return key, nil
+}
+
+// Enabled reports whether a signing key is configured
+func (a *JWTAuth) Enabled() bool {
+	return len(a.secret) > 0 || a.publicKey != nil
+}
+
+// Authenticate verifies the request's bearer token and returns its claims
+func (a *JWTAuth) Authenticate(r *http.Request) (*SessionClaims, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	return a.ParseToken(token, time.Now())
+}
+
+// ParseToken verifies a compact JWT's signature, expiry and not-before time,
+// allowing for the configured clock skew
+func (a *JWTAuth) ParseToken(token string, now time.Time) (*SessionClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.Unauthorized("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.Unauthorized("malformed token header")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Unauthorized("malformed token signature")
+	}
+
+	// Only the algorithm matching the configured key is accepted, so a token
+	// cannot pick "none" or verify an RSA key as an HMAC secret
+	signed := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(signed)
+	switch {
+	case header.Alg == "HS256" && len(a.secret) > 0:
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errors.Unauthorized("invalid token signature")
+		}
+	case header.Alg == "RS256" && a.publicKey != nil:
+		if err := rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errors.Unauthorized("invalid token signature")
+		}
+	default:
+		return nil, errors.Unauthorized(fmt.Sprintf("unsupported token algorithm %q", header.Alg))
+	}
+
+	var claims SessionClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.Unauthorized("malformed token claims")
+	}
+
+	if claims.ExpiresAt == 0 {
+		return nil, errors.Unauthorized("token has no expiry")
+	}
+	if now.Add(-a.clockSkew).After(unixTime(claims.ExpiresAt)) {
+		return nil, errors.Unauthorized("token has expired")
+	}
+	if claims.NotBefore != 0 && now.Add(a.clockSkew).Before(unixTime(claims.NotBefore)) {
+		return nil, errors.Unauthorized("token is not valid yet")
+	}
+
+	return &claims, nil
+}
+
+// Require returns a middleware that verifies the caller's token and checks
+// every session ID in the query string, the named path parameters and the
+// JSON or multipart form body against its claims
+func (a *JWTAuth) Require(params ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+
+		claims, err := a.Authenticate(c.Request)
+		if err == nil {
+			for _, sessionID := range requestSessionIDs(c, params) {
+				if !claims.Allows(sessionID) {
+					err = errors.Forbidden(fmt.Sprintf("token does not grant access to session %s", sessionID))
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			apiErr, _ := errors.IsAPIError(err)
+			c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		c.Set(ClaimsContextKey, claims)
+		c.Next()
+	}
+}
+
+// requestSessionIDs collects the session IDs a request refers to
+func requestSessionIDs(c *gin.Context, params []string) []string {
+	var sessionIDs []string
+	if sessionID := c.Query("session_id"); sessionID != "" {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	for _, param := range params {
+		if sessionID := c.Param(param); sessionID != "" {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	}
+
+	if isMultipart(c) {
+		if sessionID := c.PostForm("session_id"); sessionID != "" {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	} else if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		// Restore the body for the handler
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		if err == nil {
+			sessionIDs = append(sessionIDs, bodySessionIDs(body)...)
+		}
+	}
+
+	return sessionIDs
+}
+
+// decodeSegment decodes a base64url JSON token segment into v
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// unixTime converts a JWT NumericDate to a time
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
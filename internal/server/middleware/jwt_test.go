@@ -0,0 +1,273 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// signHS256 builds a compact JWT signed with secret
+func signHS256(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	signing := jwtSigningInput(t, "HS256", claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signing))
+	return signing + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signRS256 builds a compact JWT signed with key
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	signing := jwtSigningInput(t, "RS256", claims)
+	digest := sha256.Sum256([]byte(signing))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func jwtSigningInput(t *testing.T, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to marshal claims: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func TestJWTParseToken(t *testing.T) {
+	auth, err := NewJWTAuth(config.JWTConfig{Secret: "secret", ClockSkew: 30 * time.Second})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	now := time.Now()
+	valid := map[string]interface{}{"sessions": []string{"s1"}, "exp": now.Add(time.Minute).Unix()}
+	unsigned := jwtSigningInput(t, "none", valid) + "."
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"Valid", signHS256(t, "secret", valid), false},
+		{"WrongSecret", signHS256(t, "other", valid), true},
+		{"AlgNone", unsigned, true},
+		{"Malformed", "not-a-token", true},
+		{"MissingExpiry", signHS256(t, "secret", map[string]interface{}{"sessions": []string{"s1"}}), true},
+		{"Expired", signHS256(t, "secret", map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}), true},
+		{"ExpiredWithinSkew", signHS256(t, "secret", map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()}), false},
+		{"NotYetValid", signHS256(t, "secret", map[string]interface{}{"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(time.Minute).Unix()}), true},
+		{"NotBeforeWithinSkew", signHS256(t, "secret", map[string]interface{}{"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(10 * time.Second).Unix()}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := auth.ParseToken(tt.token, now)
+			if tt.wantErr {
+				apiErr, ok := errors.IsAPIError(err)
+				if !ok || apiErr.StatusCode != http.StatusUnauthorized {
+					t.Errorf("Expected unauthorized error, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestJWTParseTokenRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	auth, err := NewJWTAuth(config.JWTConfig{PublicKeyFile: path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	claims := map[string]interface{}{"tenant": "acme", "exp": time.Now().Add(time.Minute).Unix()}
+	parsed, err := auth.ParseToken(signRS256(t, key, claims), time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if parsed.Tenant != "acme" {
+		t.Errorf("Expected tenant acme, got %q", parsed.Tenant)
+	}
+
+	// An HMAC token keyed with the public key must not verify
+	if _, err := auth.ParseToken(signHS256(t, string(der), claims), time.Now()); err == nil {
+		t.Error("Expected HS256 token to be rejected for an RSA key")
+	}
+}
+
+func TestSessionClaimsAllows(t *testing.T) {
+	claims := &SessionClaims{Sessions: []string{"shared"}, Tenant: "acme"}
+
+	for sessionID, want := range map[string]bool{
+		"shared":     true,
+		"acme":       true,
+		"acme:scan1": true,
+		"acmecorp":   false,
+		"other":      false,
+	} {
+		if got := claims.Allows(sessionID); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", sessionID, got, want)
+		}
+	}
+}
+
+func TestJWTRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	auth, err := NewJWTAuth(config.JWTConfig{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	token := signHS256(t, "secret", map[string]interface{}{
+		"sessions": []string{"mine"},
+		"exp":      time.Now().Add(time.Minute).Unix(),
+	})
+
+	var body string
+	router := gin.New()
+	router.Use(auth.Require())
+	router.GET("/query", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/sessions/:id", auth.Require("id"), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/import", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/ingest", func(c *gin.Context) {
+		data, _ := c.GetRawData()
+		body = string(data)
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+		status int
+	}{
+		{"MissingToken", http.MethodGet, "/query?session_id=mine", "", "", http.StatusUnauthorized},
+		{"OwnQuery", http.MethodGet, "/query?session_id=mine", "", token, http.StatusOK},
+		{"OtherQuery", http.MethodGet, "/query?session_id=theirs", "", token, http.StatusForbidden},
+		{"OwnPath", http.MethodGet, "/sessions/mine", "", token, http.StatusOK},
+		{"OtherPath", http.MethodGet, "/sessions/theirs", "", token, http.StatusForbidden},
+		{"OwnBody", http.MethodPost, "/ingest", `{"session_id":"mine"}`, token, http.StatusOK},
+		{"OtherBody", http.MethodPost, "/ingest", `{"session_id":"theirs"}`, token, http.StatusForbidden},
+		{"OtherAnchor", http.MethodPost, "/ingest", `{"session_id":"mine","anchors":[{"session_id":"theirs"}]}`, token, http.StatusForbidden},
+		{"OtherInBatch", http.MethodPost, "/ingest", `[{"session_id":"mine"},{"session_id":"theirs"}]`, token, http.StatusForbidden},
+		{"OtherBesideMalformed", http.MethodPost, "/ingest", `[1,{"session_id":"theirs"}]`, token, http.StatusForbidden},
+		{"OwnForm", http.MethodPost, "/import", "mine", token, http.StatusOK},
+		{"OtherForm", http.MethodPost, "/import", "theirs", token, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.path == "/import" {
+				req = multipartRequest(t, tt.path, tt.body)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	if body != `{"session_id":"mine"}` {
+		t.Errorf("Expected handler to receive the original body, got %q", body)
+	}
+}
+
+func TestJWTRequireAfterBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	auth, err := NewJWTAuth(config.JWTConfig{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	token := signHS256(t, "secret", map[string]interface{}{
+		"sessions": []string{"mine"},
+		"exp":      time.Now().Add(time.Minute).Unix(),
+	})
+
+	// The token's session check reads the body, so the cap must come first
+	called := false
+	router := gin.New()
+	router.Use(MaxBodySize(64), auth.Require())
+	router.POST("/anchors/latest", func(c *gin.Context) {
+		called = true
+		c.Status(http.StatusOK)
+	})
+
+	// A chunked body declares no length for the cap to check up front
+	body := strings.NewReader(`{"session_id":"mine","anchor_ids":["` + strings.Repeat("a", 1<<20) + `"]}`)
+	req := httptest.NewRequest(http.MethodPost, "/anchors/latest", io.MultiReader(body))
+	req.ContentLength = -1
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge || called {
+		t.Errorf("Expected 413 before the handler, got %d", w.Code)
+	}
+	if read := body.Size() - int64(body.Len()); read > 64<<10 {
+		t.Errorf("Expected the body read to stop near the cap, read %d bytes", read)
+	}
+}
+
+// multipartRequest builds a form upload naming sessionID
+func multipartRequest(t *testing.T, path, sessionID string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("session_id", sessionID)
+	part, err := writer.CreateFormFile("file", "mesh.obj")
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write([]byte("v 0 0 0\n"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
@@ -30,7 +30,7 @@ func Logger(log logger.Logger) gin.HandlerFunc {
 			path = path + "?" + raw
 		}
 
-		log.WithFields(map[string]interface{}{
+		logger.FromContext(c.Request.Context(), log).WithFields(map[string]interface{}{
 			"method":     c.Request.Method,
 			"path":       path,
 			"status":     statusCode,
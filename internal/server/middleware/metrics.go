@@ -21,6 +21,11 @@ func Metrics(m *metrics.Metrics) gin.HandlerFunc {
 			endpoint = "unknown"
 		}
 
+		// Track the request as in flight, even if a handler panics
+		inFlight := m.InFlightRequests.WithLabelValues(endpoint)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
 		// Process request
 		c.Next()
 
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// Metrics shared by the package's tests
+var testMetrics = metrics.New(config.MetricsConfig{}, logger.New(logger.Config{}))
+
+func TestMetricsInFlight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := testMetrics
+
+	router := gin.New()
+	router.Use(gin.Recovery(), Metrics(m))
+
+	var during float64
+	router.GET("/ok", func(c *gin.Context) {
+		during = testutil.ToFloat64(m.InFlightRequests.WithLabelValues("/ok"))
+		c.Status(http.StatusOK)
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	if during != 1 {
+		t.Errorf("Expected 1 request in flight while serving, got %v", during)
+	}
+	if got := testutil.ToFloat64(m.InFlightRequests.WithLabelValues("/ok")); got != 0 {
+		t.Errorf("Expected 0 requests in flight after serving, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d", w.Code)
+	}
+	if got := testutil.ToFloat64(m.InFlightRequests.WithLabelValues("/panic")); got != 0 {
+		t.Errorf("Expected in-flight gauge to be released after a panic, got %v", got)
+	}
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// MetricsAuth guards the metrics endpoints with a credential of their own, so
+// scrapers need no data access and data clients cannot read metrics
+type MetricsAuth struct {
+	// Credentials are compared by SHA-256 digest in constant time
+	token    *[sha256.Size]byte
+	username *[sha256.Size]byte
+	password [sha256.Size]byte
+}
+
+// NewMetricsAuth creates a metrics authenticator from configuration
+func NewMetricsAuth(cfg config.MetricsConfig) *MetricsAuth {
+	a := &MetricsAuth{}
+	if cfg.BearerToken != "" {
+		token := sha256.Sum256([]byte(cfg.BearerToken))
+		a.token = &token
+	}
+	if cfg.Username != "" {
+		username := sha256.Sum256([]byte(cfg.Username))
+		a.username = &username
+		a.password = sha256.Sum256([]byte(cfg.Password))
+	}
+	return a
+}
+
+// Enabled reports whether a metrics credential is configured
+func (a *MetricsAuth) Enabled() bool {
+	return a.token != nil || a.username != nil
+}
+
+// MetricsAuthorizationHeader carries the metrics credential on requests
+// whose Authorization header holds an API key or JWT, as the admin
+// endpoints require both
+const MetricsAuthorizationHeader = "X-Metrics-Authorization"
+
+// Authenticate checks the request's bearer token or basic auth credentials,
+// taken from MetricsAuthorizationHeader when it is set
+func (a *MetricsAuth) Authenticate(r *http.Request) error {
+	if value := r.Header.Get(MetricsAuthorizationHeader); value != "" {
+		r = &http.Request{Header: http.Header{"Authorization": {value}}}
+	}
+
+	if a.token != nil {
+		token, err := bearerToken(r)
+		if err != nil {
+			return err
+		}
+		if !digestEqual(a.token, token) {
+			return errors.Unauthorized("invalid metrics token")
+		}
+		return nil
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return errors.Unauthorized("metrics require basic authentication")
+	}
+	// Both digests are compared so a wrong username takes as long as a wrong password
+	usernameOK := digestEqual(a.username, username)
+	passwordOK := digestEqual(&a.password, password)
+	if !usernameOK || !passwordOK {
+		return errors.Unauthorized("invalid metrics credentials")
+	}
+	return nil
+}
+
+// Require returns a middleware that rejects requests without the metrics credential
+func (a *MetricsAuth) Require() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+
+		if err := a.Authenticate(c.Request); err != nil {
+			if a.username != nil {
+				c.Header("WWW-Authenticate", `Basic realm="metrics"`)
+			}
+			apiErr, _ := errors.IsAPIError(err)
+			c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// digestEqual compares value against a SHA-256 digest in constant time
+func digestEqual(digest *[sha256.Size]byte, value string) bool {
+	sum := sha256.Sum256([]byte(value))
+	return subtle.ConstantTimeCompare(digest[:], sum[:]) == 1
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+func TestMetricsAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		cfg    config.MetricsConfig
+		setup  func(r *http.Request)
+		status int
+	}{
+		{"Disabled", config.MetricsConfig{}, func(r *http.Request) {}, http.StatusOK},
+		{"ValidToken", config.MetricsConfig{BearerToken: "scrape"}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer scrape") }, http.StatusOK},
+		{"WrongToken", config.MetricsConfig{BearerToken: "scrape"}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer other") }, http.StatusUnauthorized},
+		{"MissingToken", config.MetricsConfig{BearerToken: "scrape"}, func(r *http.Request) {}, http.StatusUnauthorized},
+		{"ValidBasic", config.MetricsConfig{Username: "prom", Password: "secret"}, func(r *http.Request) { r.SetBasicAuth("prom", "secret") }, http.StatusOK},
+		{"WrongPassword", config.MetricsConfig{Username: "prom", Password: "secret"}, func(r *http.Request) { r.SetBasicAuth("prom", "guess") }, http.StatusUnauthorized},
+		{"SeparateHeader", config.MetricsConfig{BearerToken: "scrape"}, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer write-key")
+			r.Header.Set(MetricsAuthorizationHeader, "Bearer scrape")
+		}, http.StatusOK},
+		{"SeparateHeaderWrong", config.MetricsConfig{BearerToken: "scrape"}, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer scrape")
+			r.Header.Set(MetricsAuthorizationHeader, "Bearer other")
+		}, http.StatusUnauthorized},
+		{"WrongUsername", config.MetricsConfig{Username: "prom", Password: "secret"}, func(r *http.Request) { r.SetBasicAuth("admin", "secret") }, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := NewMetricsAuth(tt.cfg)
+			router := gin.New()
+			router.GET("/metrics", auth.Require(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			tt.setup(req)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && tt.cfg.Username != "" && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a basic auth challenge")
+			}
+		})
+	}
+}
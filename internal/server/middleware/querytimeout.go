@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/database"
+)
+
+// Timeouts returns a middleware that limits the database work of the request
+// to operation, 0 leaving it unlimited, and each AQL query run for it to
+// query when that is set, overriding the configured default. Work still
+// running at the operation deadline is abandoned, and fails with a 504.
+func Timeouts(operation time.Duration, query *time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if operation > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, operation)
+			defer cancel()
+		}
+		if query != nil {
+			ctx = database.WithQueryTimeout(ctx, *query)
+		}
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/database"
+)
+
+func TestTimeouts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	query := time.Minute
+	tests := []struct {
+		name      string
+		operation time.Duration
+		query     *time.Duration
+		wantOp    bool
+		wantQuery time.Duration
+	}{
+		{"Operation", 5 * time.Second, nil, true, 10 * time.Second},
+		{"QueryOverride", 0, &query, false, time.Minute},
+		{"Disabled", 0, nil, false, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requestCtx context.Context
+			router := gin.New()
+			router.GET("/query", Timeouts(tt.operation, tt.query), func(c *gin.Context) {
+				requestCtx = c.Request.Context()
+
+				_, hasDeadline := requestCtx.Deadline()
+				if hasDeadline != tt.wantOp {
+					t.Errorf("Expected request deadline %v, got %v", tt.wantOp, hasDeadline)
+				}
+
+				// Queries take the sooner of their own limit and the request's
+				ctx, cancel := database.QueryContext(requestCtx, 10*time.Second)
+				defer cancel()
+				deadline, _ := ctx.Deadline()
+				want := tt.wantQuery
+				if tt.wantOp && tt.operation < want {
+					want = tt.operation
+				}
+				if remaining := time.Until(deadline); remaining > want || remaining < want-time.Second {
+					t.Errorf("Expected query deadline in %v, got %v", want, remaining)
+				}
+				c.Status(http.StatusOK)
+			})
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/query", nil))
+
+			// The deadline's timer is released once the request is answered
+			if tt.wantOp && requestCtx.Err() != context.Canceled {
+				t.Errorf("Expected the request context released, got %v", requestCtx.Err())
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// SessionIDHeader lets clients name their session without the limiter parsing the body
+const SessionIDHeader = "X-Session-ID"
+
+// tokenBucket holds the tokens available to one session
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter is a token-bucket rate limiter keyed by session ID
+type RateLimiter struct {
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	rate        float64 // Tokens added per second
+	burst       float64
+	idleTimeout time.Duration
+}
+
+// NewRateLimiter creates a rate limiter and starts sweeping idle buckets
+func NewRateLimiter(cfg config.RateLimitConfig) *RateLimiter {
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	l := &RateLimiter{
+		buckets:     make(map[string]*tokenBucket),
+		rate:        cfg.RequestsPerSecond,
+		burst:       float64(burst),
+		idleTimeout: cfg.IdleTimeout,
+	}
+
+	if l.Enabled() && l.idleTimeout > 0 {
+		go l.runSweeper()
+	}
+
+	return l
+}
+
+// Enabled reports whether a request rate is configured
+func (l *RateLimiter) Enabled() bool {
+	return l.rate > 0
+}
+
+// Allow takes a token for key, returning how long to wait when none is left
+func (l *RateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+
+	// Refill for the time since the last request
+	elapsed := now.Sub(bucket.lastSeen).Seconds()
+	if elapsed > 0 {
+		bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+		bucket.lastSeen = now
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep removes buckets idle for longer than the idle timeout
+func (l *RateLimiter) sweep(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	removed := 0
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > l.idleTimeout {
+			delete(l.buckets, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// runSweeper periodically expires idle session buckets
+func (l *RateLimiter) runSweeper() {
+	ticker := time.NewTicker(l.idleTimeout)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		l.sweep(now)
+	}
+}
+
+// RateLimit returns a middleware that limits requests per session
+func RateLimit(l *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.Enabled() {
+			c.Next()
+			return
+		}
+
+		allowed, wait := l.Allow(sessionKey(c), time.Now())
+		if !allowed {
+			apiErr := errors.RateLimitError("rate limit exceeded for session")
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(apiErr.StatusCode, gin.H{
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// sessionKey identifies the session a request belongs to, from the
+// X-Session-ID header or the body's session_id, falling back to the client IP
+func sessionKey(c *gin.Context) string {
+	if sessionID := c.GetHeader(SessionIDHeader); sessionID != "" {
+		return "session:" + sessionID
+	}
+
+	if isMultipart(c) {
+		// Uploads are parsed as a form rather than buffered
+		if sessionID := c.PostForm("session_id"); sessionID != "" {
+			return "session:" + sessionID
+		}
+	} else if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		// Restore the body for the handler
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		if err == nil {
+			if sessionID := bodySessionID(body); sessionID != "" {
+				return "session:" + sessionID
+			}
+		}
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+// isMultipart reports whether the request body is a multipart form
+func isMultipart(c *gin.Context) bool {
+	return c.ContentType() == gin.MIMEMultipartPOSTForm
+}
+
+// bodySessionID extracts session_id from an event or the first event of a batch
+func bodySessionID(body []byte) string {
+	for _, event := range decodeBodyEvents(body) {
+		return event.SessionID
+	}
+	return ""
+}
+
+// bodySessionIDs returns every session ID named by an event or batch,
+// including those of individual anchors
+func bodySessionIDs(body []byte) []string {
+	var sessionIDs []string
+	for _, event := range decodeBodyEvents(body) {
+		if event.SessionID != "" {
+			sessionIDs = append(sessionIDs, event.SessionID)
+		}
+		for _, anchor := range event.Anchors {
+			if anchor.SessionID != "" {
+				sessionIDs = append(sessionIDs, anchor.SessionID)
+			}
+		}
+	}
+	return sessionIDs
+}
+
+// bodyEvent holds the identifying fields of an ingested event
+type bodyEvent struct {
+	EventID   string `json:"event_id"`
+	SessionID string `json:"session_id"`
+	Anchors   []struct {
+		SessionID string `json:"session_id"`
+	} `json:"anchors"`
+}
+
+// decodeBodyEvents parses a single event or the events of a batch array,
+// returning nil if the body is neither
+func decodeBodyEvents(body []byte) []bodyEvent {
+	// Decode with a streaming decoder like the handlers, which ignore
+	// anything after the first JSON value
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		var event bodyEvent
+		if err := json.NewDecoder(bytes.NewReader(trimmed)).Decode(&event); err != nil {
+			return nil
+		}
+		return []bodyEvent{event}
+	}
+
+	// Decode batch events one by one, as the batch handler does, so one
+	// malformed event does not hide the sessions of the others
+	var raw []json.RawMessage
+	if err := json.NewDecoder(bytes.NewReader(trimmed)).Decode(&raw); err != nil {
+		return nil
+	}
+
+	events := make([]bodyEvent, 0, len(raw))
+	for _, data := range raw {
+		var event bodyEvent
+		if err := json.Unmarshal(data, &event); err == nil {
+			events = append(events, event)
+		}
+	}
+	return events
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+func TestRateLimiterBucket(t *testing.T) {
+	limiter := NewRateLimiter(config.RateLimitConfig{RequestsPerSecond: 2, Burst: 2})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.Allow("a", now); !allowed {
+			t.Fatalf("Expected request %d within burst to be allowed", i+1)
+		}
+	}
+
+	allowed, wait := limiter.Allow("a", now)
+	if allowed {
+		t.Error("Expected request beyond burst to be limited")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("Expected 500ms wait, got %v", wait)
+	}
+
+	// Other sessions have their own bucket
+	if allowed, _ := limiter.Allow("b", now); !allowed {
+		t.Error("Expected a different session to be allowed")
+	}
+
+	// Tokens refill over time
+	if allowed, _ := limiter.Allow("a", now.Add(500*time.Millisecond)); !allowed {
+		t.Error("Expected request to be allowed after refill")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	limiter := NewRateLimiter(config.RateLimitConfig{RequestsPerSecond: 1, Burst: 1})
+	limiter.idleTimeout = time.Minute
+	now := time.Now()
+
+	limiter.Allow("idle", now)
+	limiter.Allow("active", now.Add(50*time.Second))
+
+	if removed := limiter.sweep(now.Add(90 * time.Second)); removed != 1 {
+		t.Errorf("Expected 1 idle bucket removed, got %d", removed)
+	}
+	if _, ok := limiter.buckets["active"]; !ok || len(limiter.buckets) != 1 {
+		t.Errorf("Expected only the active bucket to remain, got %v", limiter.buckets)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter := NewRateLimiter(config.RateLimitConfig{RequestsPerSecond: 0.5, Burst: 1})
+	router := gin.New()
+	router.POST("/ingest", RateLimit(limiter), func(c *gin.Context) {
+		var event struct {
+			SessionID string `json:"session_id"`
+		}
+		if err := c.ShouldBindJSON(&event); err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		c.String(http.StatusOK, event.SessionID)
+	})
+
+	send := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body)))
+		return w
+	}
+
+	w := send(`{"session_id": "s1"}`)
+	if w.Code != http.StatusOK || w.Body.String() != "s1" {
+		t.Fatalf("Expected first request to reach the handler with its body, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = send(`{"session_id": "s1"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429, got %d", w.Code)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", retry)
+	}
+
+	if w = send(`{"session_id": "s2"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected other session to be allowed, got %d", w.Code)
+	}
+}
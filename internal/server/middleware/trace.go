@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+const (
+	// TraceIDHeader carries a request's trace ID in both directions
+	TraceIDHeader = "X-Trace-Id"
+
+	// TraceIDContextKey is the gin context key holding the request's trace ID
+	TraceIDContextKey = "trace_id"
+
+	maxTraceIDLength = 128
+)
+
+// Trace returns a middleware that adopts the caller's X-Trace-Id, or else the
+// ID of the OpenTelemetry trace the request is part of, or generates one, and
+// makes it available to handlers through the request context. The ID is
+// echoed in the response header and added to JSON error bodies.
+func Trace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		traceID := c.GetHeader(TraceIDHeader)
+		if !validTraceID(traceID) {
+			if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.HasTraceID() {
+				traceID = spanContext.TraceID().String()
+			} else {
+				traceID = uuid.NewString()
+			}
+		}
+
+		c.Set(TraceIDContextKey, traceID)
+		c.Request = c.Request.WithContext(logger.WithTraceID(c.Request.Context(), traceID))
+		c.Header(TraceIDHeader, traceID)
+		c.Writer = &traceWriter{ResponseWriter: c.Writer, traceID: traceID}
+
+		c.Next()
+	}
+}
+
+// validTraceID reports whether a caller-supplied trace ID is safe to adopt
+func validTraceID(traceID string) bool {
+	if traceID == "" || len(traceID) > maxTraceIDLength {
+		return false
+	}
+	for _, r := range traceID {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// traceWriter adds the trace ID to JSON error responses
+type traceWriter struct {
+	gin.ResponseWriter
+	traceID string
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *traceWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Write adds a trace_id field to a JSON error object written in one piece
+func (w *traceWriter) Write(data []byte) (int, error) {
+	if w.Status() < http.StatusBadRequest || w.Written() ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), gin.MIMEJSON) {
+		return w.ResponseWriter.Write(data)
+	}
+
+	// Numbers are kept verbatim rather than round-tripped through float64
+	var body map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil || body == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, ok := body[TraceIDContextKey]; !ok {
+		body[TraceIDContextKey] = w.traceID
+	}
+
+	traced, err := json.Marshal(body)
+	if err != nil {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, err := w.ResponseWriter.Write(traced); err != nil {
+		return 0, err
+	}
+	// Report the caller's bytes as written, as their length is what it expects
+	return len(data), nil
+}
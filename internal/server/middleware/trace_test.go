@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestTrace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var contextTraceID string
+	router := gin.New()
+	router.Use(Trace())
+	router.GET("/ok", func(c *gin.Context) {
+		contextTraceID = logger.TraceID(c.Request.Context())
+		c.JSON(http.StatusOK, gin.H{"count": 12345678901234})
+	})
+	router.GET("/fail", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "anchor not found", "code": "NOT_FOUND"})
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		adopt  bool
+	}{
+		{"Adopted", "/ok", "req-42.abc", true},
+		{"Generated", "/ok", "", false},
+		{"Invalid", "/ok", "bad id\n", false},
+		{"ErrorBody", "/fail", "req-43", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contextTraceID = ""
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(TraceIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			traceID := w.Header().Get(TraceIDHeader)
+			if traceID == "" {
+				t.Fatal("Expected a trace ID response header")
+			}
+			if tt.adopt != (traceID == tt.header) {
+				t.Errorf("Unexpected trace ID %q for header %q", traceID, tt.header)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode body: %v", err)
+			}
+			if tt.path == "/fail" {
+				if body["trace_id"] != traceID || body["code"] != "NOT_FOUND" {
+					t.Errorf("Expected the error body to carry trace ID %s, got %s", traceID, w.Body.String())
+				}
+				return
+			}
+
+			if contextTraceID != traceID {
+				t.Errorf("Expected request context trace ID %s, got %q", traceID, contextTraceID)
+			}
+			if _, ok := body["trace_id"]; ok || w.Body.String() != `{"count":12345678901234}` {
+				t.Errorf("Expected the success body untouched, got %s", w.Body.String())
+			}
+		})
+	}
+}
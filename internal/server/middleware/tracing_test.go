@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/tabular/stag-v2/internal/tracing"
+)
+
+func TestTracing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(previous)
+
+	router := gin.New()
+	router.Use(Tracing(), Trace())
+	router.GET("/api/v1/sessions/:id/activity", func(c *gin.Context) {
+		_, span := tracing.Start(c.Request.Context(), "child")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+	router.GET("/api/v1/anchors/:id", func(c *gin.Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+
+	t.Run("Traceparent", func(t *testing.T) {
+		recorder.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions/s1/activity", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if got := w.Header().Get(TraceIDHeader); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Expected the traceparent trace ID adopted, got %q", got)
+		}
+
+		spans := recorder.Ended()
+		if len(spans) != 2 {
+			t.Fatalf("Expected a request span and its child, got %d spans", len(spans))
+		}
+		child, server := spans[0], spans[1]
+		if server.Name() != "GET /api/v1/sessions/:id/activity" {
+			t.Errorf("Expected the span named by route, got %q", server.Name())
+		}
+		if server.Parent().SpanID().String() != "00f067aa0ba902b7" || server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Expected the span to continue the incoming trace, got parent %v", server.Parent())
+		}
+		if child.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Error("Expected handler spans to be children of the request span")
+		}
+
+		attrs := attributeMap(server.Attributes())
+		if attrs[tracing.SessionIDKey] != "s1" || attrs["http.route"] != "/api/v1/sessions/:id/activity" ||
+			attrs["http.response.status_code"] != "200" {
+			t.Errorf("Unexpected span attributes %v", attrs)
+		}
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		recorder.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/anchors/a1?session_id=s2", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		spans := recorder.Ended()
+		if len(spans) != 1 {
+			t.Fatalf("Expected one span, got %d", len(spans))
+		}
+		span := spans[0]
+		if span.Status().Code.String() != "Error" {
+			t.Errorf("Expected an error status, got %v", span.Status())
+		}
+		if attrs := attributeMap(span.Attributes()); attrs[tracing.SessionIDKey] != "s2" {
+			t.Errorf("Expected the session from the query, got %v", attrs)
+		}
+		if w.Header().Get(TraceIDHeader) != span.SpanContext().TraceID().String() {
+			t.Error("Expected a new trace's ID adopted as the trace ID")
+		}
+	})
+}
+
+// attributeMap renders span attributes as strings by key
+func attributeMap(attrs []attribute.KeyValue) map[attribute.Key]string {
+	m := make(map[attribute.Key]string, len(attrs))
+	for _, attr := range attrs {
+		m[attr.Key] = attr.Value.Emit()
+	}
+	return m
+}
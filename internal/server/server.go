@@ -1,13 +1,14 @@
 package server
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/database"
 	"github.com/tabular/stag-v2/internal/metrics"
 	"github.com/tabular/stag-v2/internal/server/handlers"
 	"github.com/tabular/stag-v2/internal/server/middleware"
@@ -20,11 +21,13 @@ import (
 const Version = "2.0.0"
 
 // New creates a new server instance
-func New(cfg *config.Config, repository *spatial.Repository, logger logger.Logger, metrics *metrics.Metrics) *gin.Engine {
+func New(cfg *config.Config, db *database.Connection, repository *spatial.Repository, wsHub *websocket.Hub, ready *atomic.Bool, logger logger.Logger, metrics *metrics.Metrics) (*gin.Engine, error) {
 	router := gin.New()
 
 	// Global middleware
 	router.Use(gin.Recovery())
+	router.Use(middleware.Tracing())
+	router.Use(middleware.Trace())
 	router.Use(middleware.Logger(logger))
 	router.Use(middleware.Metrics(metrics))
 
@@ -32,45 +35,152 @@ func New(cfg *config.Config, repository *spatial.Repository, logger logger.Logge
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, // TODO: Configure for production
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", middleware.TraceIDHeader, "traceparent"},
+		ExposeHeaders:    []string{"Content-Length", middleware.TraceIDHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	// Initialize WebSocket hub
-	wsHub := websocket.NewHub(repository, logger, metrics)
-	go wsHub.Run()
+	// API key authentication
+	auth := middleware.NewAPIKeyAuth(cfg.Auth)
+
+	// JWT session authorization, an alternative to API keys
+	jwtAuth, err := middleware.NewJWTAuth(cfg.Auth.JWT)
+	if err != nil {
+		return nil, err
+	}
+	if !auth.Enabled() && !jwtAuth.Enabled() {
+		logger.Warn("No API keys or JWT key configured, API authentication is disabled")
+	}
+
+	// Per-session ingest rate limiting
+	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit)
+
+	// Bounded ingest concurrency, queueing requests beyond it
+	ingestLimit := middleware.IngestLimit(middleware.NewIngestQueue(cfg.IngestQueue, metrics))
+
+	// Responses kept to answer retried ingest requests
+	var idempotencyStore middleware.IdempotencyStore
+	if cfg.Idempotency.TTL > 0 {
+		idempotencyStore = middleware.NewMemoryIdempotencyStore(cfg.Idempotency)
+	}
 
 	// Initialize handlers
-	healthHandler := handlers.NewHealthHandler(Version)
-	ingestHandler := handlers.NewIngestHandler(repository, logger)
+	healthHandler := handlers.NewHealthHandler(Version, db, ready, logger)
+	ingestHandler := handlers.NewIngestHandler(repository, wsHub, cfg.WebSocket.IngestBroadcastLimit, logger)
 	queryHandler := handlers.NewQueryHandler(repository, logger)
-	wsHandler := handlers.NewWebSocketHandler(wsHub, logger)
+	exportHandler := handlers.NewExportHandler(repository, logger)
+	sessionsHandler := handlers.NewSessionsHandler(repository, wsHub, logger)
+	anchorsHandler := handlers.NewAnchorsHandler(repository, wsHub, logger)
+	meshesHandler := handlers.NewMeshesHandler(repository, logger)
+	importHandler := handlers.NewImportHandler(repository, wsHub, cfg.WebSocket.IngestBroadcastLimit, logger)
+	uploadHandler := handlers.NewUploadHandler(repository, wsHub, cfg.WebSocket.IngestBroadcastLimit, logger)
+	deadLetterHandler := handlers.NewDeadLetterHandler(wsHub, logger)
+	statsHandler := handlers.NewStatsHandler(repository, logger)
+	adminHandler := handlers.NewAdminHandler(repository, logger)
+	wsHandler := handlers.NewWebSocketHandler(wsHub, auth, jwtAuth, cfg.WebSocket, logger)
 
 	// Health check endpoint
 	router.GET("/health", healthHandler.Health)
+	router.GET("/health/live", healthHandler.Live)
+	router.GET("/health/ready", healthHandler.Ready)
 
-	// Metrics endpoint
+	// Encoding of JSON query and metrics responses
+	compress := middleware.Compress(cfg.Server.ResponseCompression)
+
+	// Metrics endpoint, optionally behind its own credential
+	metricsAuth := middleware.NewMetricsAuth(cfg.Metrics)
 	if cfg.Metrics.Enabled {
-		router.GET(cfg.Metrics.Path, gin.WrapH(promhttp.Handler()))
+		router.GET(cfg.Metrics.Path, metricsAuth.Require(), compress, gin.WrapH(metrics.Handler()))
+	}
+
+	// Encoding of glTF exports and snapshots, chosen per request
+	exportEncoding := func(endpoint string) gin.HandlerFunc {
+		return middleware.ExportEncoding(endpoint, cfg.Server.ExportCompression, metrics)
+	}
+
+	// Deadline of each request's database work, and per-endpoint overrides of
+	// it and of the default AQL query timeout
+	queryTimeout := func(endpoint string) gin.HandlerFunc {
+		operation := cfg.Database.OperationTimeout
+		if timeout, ok := cfg.Database.OperationTimeouts[endpoint]; ok {
+			operation = timeout
+		}
+		var query *time.Duration
+		if timeout, ok := cfg.Database.QueryTimeouts[endpoint]; ok {
+			query = &timeout
+		}
+		return middleware.Timeouts(operation, query)
 	}
 
 	// API v1 routes
 	v1 := router.Group("/api/v1")
+	// Body size caps precede auth, which reads JSON bodies for session IDs
+	read := v1.Group("", middleware.MaxBodySize(cfg.Server.MaxBodyBytes), auth.Require(middleware.ScopeRead), jwtAuth.Require(), compress)
+	write := v1.Group("",
+		middleware.MaxBodySize(cfg.Server.MaxBodyBytes),
+		auth.Require(middleware.ScopeWrite),
+		jwtAuth.Require(),
+		middleware.AuditActor(),
+		middleware.RateLimit(rateLimiter),
+	)
 	{
 		// Ingestion
-		v1.POST("/ingest", ingestHandler.Ingest)
+		write.POST("/ingest", middleware.Idempotency(idempotencyStore, metrics), ingestLimit, queryTimeout("ingest"), ingestHandler.Ingest)
+		write.POST("/ingest/batch", ingestLimit, queryTimeout("ingest_batch"), ingestHandler.IngestBatch)
+
+		// Mesh file import; the size cap must apply before auth parses the form
+		v1.POST("/import",
+			middleware.MaxBodySize(cfg.Import.MaxFileSize),
+			auth.Require(middleware.ScopeWrite),
+			jwtAuth.Require(),
+			middleware.AuditActor(),
+			middleware.RateLimit(rateLimiter),
+			ingestLimit,
+			queryTimeout("import"),
+			importHandler.Import,
+		)
+
+		// Chunked mesh uploads; completing one stores the mesh as ingest does
+		write.POST("/meshes/upload/init", uploadHandler.Init)
+		write.PUT("/meshes/upload/:id/chunk/:n", uploadHandler.Chunk)
+		write.POST("/meshes/upload/:id/complete", ingestLimit, queryTimeout("mesh_upload"), uploadHandler.Complete)
+		read.GET("/meshes/upload/:id", uploadHandler.Status)
 
 		// Queries
-		v1.GET("/query", queryHandler.Query)
-		v1.GET("/anchors/:id", queryHandler.GetAnchor)
-
-		// WebSocket
+		read.GET("/query", queryTimeout("query"), queryHandler.Query)
+		read.POST("/anchors/latest", queryTimeout("latest_poses"), queryHandler.GetLatestPoses)
+		read.GET("/anchors/:id", queryTimeout("anchor"), queryHandler.GetAnchor)
+		write.PUT("/anchors/:id", queryTimeout("update_anchor"), anchorsHandler.Update)
+		read.GET("/anchors/:id/neighbors", queryTimeout("neighbors"), queryHandler.GetNeighbors)
+		read.GET("/anchors/:id/path/:to", queryTimeout("path"), queryHandler.GetPath)
+		read.GET("/anchors/:id/pose", queryTimeout("pose"), queryHandler.GetPose)
+		read.GET("/meshes/:id", queryTimeout("mesh"), queryHandler.GetMesh)
+		read.GET("/meshes/:id/chain", queryTimeout("mesh_chain"), queryHandler.GetMeshChain)
+		read.POST("/meshes/batch", queryTimeout("mesh_batch"), queryHandler.GetMeshes)
+		write.POST("/meshes/:id/lod", queryTimeout("mesh_lod"), meshesHandler.CreateLOD)
+		write.POST("/meshes/:id/diff", queryTimeout("mesh_diff"), meshesHandler.CreateDiff)
+
+		// Sessions
+		read.GET("/sessions", queryTimeout("sessions"), sessionsHandler.List)
+		read.GET("/sessions/:id/activity", jwtAuth.Require("id"), queryTimeout("activity"), sessionsHandler.Activity)
+		read.GET("/sessions/:id/clusters", jwtAuth.Require("id"), queryTimeout("clusters"), sessionsHandler.Clusters)
+		write.DELETE("/sessions/:id", jwtAuth.Require("id"), queryTimeout("delete_session"), sessionsHandler.Delete)
+
+		// Exports
+		read.GET("/sessions/:id/export.gltf", jwtAuth.Require("id"), queryTimeout("export"), exportEncoding("gltf"), exportHandler.ExportGLTF)
+		read.GET("/sessions/:id/replay", jwtAuth.Require("id"), queryTimeout("replay"), exportHandler.Replay)
+
+		// WebSocket (authenticated by the handler before upgrading)
 		v1.GET("/ws", wsHandler.HandleWebSocket)
-
-		// Metrics
-		v1.GET("/metrics", func(c *gin.Context) {
+		read.GET("/deadletter", deadLetterHandler.List)
+
+		// Metrics; a configured metrics credential replaces the read key
+		metricsRoutes := read
+		if metricsAuth.Enabled() {
+			metricsRoutes = v1.Group("", metricsAuth.Require(), compress)
+		}
+		metricsRoutes.GET("/metrics", queryTimeout("metrics"), func(c *gin.Context) {
 			info, err := repository.GetMetrics(c.Request.Context())
 			if err != nil {
 				c.JSON(500, gin.H{"error": "Failed to get metrics"})
@@ -79,7 +189,23 @@ func New(cfg *config.Config, repository *spatial.Repository, logger logger.Logge
 			info.ActiveConnections = wsHub.GetActiveConnections()
 			c.JSON(200, info)
 		})
+		metricsRoutes.GET("/stats/storage", queryTimeout("storage_stats"), statsHandler.Storage)
+
+		// Admin; the routes change server state, so they take a write key
+		// and, when one is configured, the metrics credential as well
+		admin := v1.Group("/admin", auth.Require(middleware.ScopeWrite), jwtAuth.Require(), metricsAuth.Require(), compress)
+		admin.GET("/dedup", queryTimeout("admin_dedup"), adminHandler.Dedup)
+		admin.POST("/dedup/rebuild", queryTimeout("admin_dedup_rebuild"), adminHandler.RebuildDedup)
+		admin.GET("/snapshot", queryTimeout("admin_snapshot"), exportEncoding("snapshot"), adminHandler.Snapshot)
+		admin.POST("/snapshot", queryTimeout("admin_snapshot_restore"), adminHandler.RestoreSnapshot)
+	}
+
+	// API v2 routes, serving new response shapes of v1 endpoints
+	v2 := router.Group("/api/v2")
+	readV2 := v2.Group("", middleware.MaxBodySize(cfg.Server.MaxBodyBytes), auth.Require(middleware.ScopeRead), jwtAuth.Require(), compress)
+	{
+		readV2.GET("/query", queryTimeout("query"), queryHandler.Query)
 	}
 
-	return router
+	return router, nil
 }
\ No newline at end of file
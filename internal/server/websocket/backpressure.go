@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// defaultSendBuffer is the send buffer of hubs configured without one
+const defaultSendBuffer = 256
+
+// enqueue queues data for the client without blocking, dropping it if the
+// send queue is full or the client has been closed. A client whose queue
+// rises above the hub's send buffer is sent a slow_consumer warning, and
+// overdue reports that the queue has stayed above it for the slow consumer
+// timeout.
+func (c *Client) enqueue(data []byte) (queued, overdue bool) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	return c.enqueueLocked(data)
+}
+
+// enqueueLocked is enqueue for callers holding c.sendMu
+func (c *Client) enqueueLocked(data []byte) (queued, overdue bool) {
+	if c.closed {
+		return false, false
+	}
+
+	select {
+	case c.send <- data:
+		queued = true
+	default:
+		c.hub.metrics.WSDroppedMessages.WithLabelValues(c.hub.metrics.SessionSeries(c.sessionID)).Inc()
+	}
+
+	if queued && len(c.send) <= c.hub.sendBuffer {
+		c.overflowSince = time.Time{}
+		return true, false
+	}
+
+	now := time.Now()
+	if c.overflowSince.IsZero() {
+		c.overflowSince = now
+		c.warnSlow()
+	}
+	return queued, now.Sub(c.overflowSince) >= c.hub.slowConsumerTimeout
+}
+
+// warnSlow queues a slow_consumer warning if the queue has room for it.
+// Callers must hold c.sendMu.
+func (c *Client) warnSlow() {
+	c.logger.Warnf("Client of session %s is falling behind with %d messages queued", c.sessionID, len(c.send))
+
+	warning := api.WSMessage{
+		Type:      api.WSTypeSlowConsumer,
+		SessionID: c.sessionID,
+		Data: mustMarshal(api.SlowConsumerWarning{
+			Queued:            len(c.send),
+			Buffer:            c.hub.sendBuffer,
+			Capacity:          cap(c.send),
+			DisconnectAfterMs: c.hub.slowConsumerTimeout.Milliseconds(),
+		}),
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	data, err := json.Marshal(warning)
+	if err != nil {
+		c.logger.Errorf("Failed to marshal slow consumer warning: %v", err)
+		return
+	}
+
+	select {
+	case c.send <- data:
+	default:
+	}
+}
+
+// disconnectSlowClients closes clients whose send queue stayed overflowed for
+// the slow consumer timeout
+func (h *Hub) disconnectSlowClients(clients []*Client) {
+	if len(clients) == 0 {
+		return
+	}
+
+	reason := fmt.Sprintf("slow consumer: send queue overflowed for %s", h.slowConsumerTimeout)
+	frame := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, reason)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, client := range clients {
+		if !h.clients[client.sessionID][client] {
+			continue // Client already disconnected
+		}
+
+		h.logger.Warnf("Closing slow client of session %s", client.sessionID)
+		h.removeClient(client, frame)
+		h.metrics.WSDisconnectsTotal.WithLabelValues("slow_consumer").Inc()
+		h.metrics.WSSlowDisconnects.WithLabelValues(h.metrics.SessionSeries(client.sessionID)).Inc()
+	}
+}
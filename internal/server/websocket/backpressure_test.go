@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestClientEnqueueBackpressure(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{SendBuffer: 2, SendOverflow: 2, SlowConsumerTimeout: time.Hour}, nil, logger.New(logger.Config{}), testMetrics)
+	client := NewClient(hub, nil, "backpressure", logger.New(logger.Config{}))
+	dropped := testMetrics.WSDroppedMessages.WithLabelValues("backpressure")
+	droppedBefore := testutil.ToFloat64(dropped)
+
+	for i := 0; i < 2; i++ {
+		if queued, overdue := client.enqueue([]byte("update")); !queued || overdue {
+			t.Fatalf("Expected message %d queued within the buffer, got queued %t overdue %t", i, queued, overdue)
+		}
+	}
+
+	// Overflowing queues the message and a warning behind it
+	if queued, overdue := client.enqueue([]byte("update")); !queued || overdue {
+		t.Fatalf("Expected the overflowing message queued, got queued %t overdue %t", queued, overdue)
+	}
+	if len(client.send) != cap(client.send) {
+		t.Fatalf("Expected a warning filling the queue, got %d of %d queued", len(client.send), cap(client.send))
+	}
+
+	// A full queue drops messages without disconnecting before the timeout
+	if queued, overdue := client.enqueue([]byte("update")); queued || overdue {
+		t.Errorf("Expected the message dropped, got queued %t overdue %t", queued, overdue)
+	}
+	if got := testutil.ToFloat64(dropped) - droppedBefore; got != 1 {
+		t.Errorf("Expected 1 dropped message counted, got %v", got)
+	}
+
+	var last []byte
+	for len(client.send) > 0 {
+		last = <-client.send
+	}
+	var msg api.WSMessage
+	var warning api.SlowConsumerWarning
+	if err := json.Unmarshal(last, &msg); err != nil || msg.Type != api.WSTypeSlowConsumer || json.Unmarshal(msg.Data, &warning) != nil {
+		t.Fatalf("Expected a slow_consumer warning, got %s", last)
+	}
+	if warning.Queued != 3 || warning.Buffer != 2 || warning.Capacity != 4 || warning.DisconnectAfterMs != time.Hour.Milliseconds() {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+
+	// Draining back within the buffer recovers the client
+	if queued, overdue := client.enqueue([]byte("update")); !queued || overdue || !client.overflowSince.IsZero() {
+		t.Errorf("Expected the client recovered, got queued %t overdue %t since %v", queued, overdue, client.overflowSince)
+	}
+
+	// Without a timeout, overflowing is overdue at once
+	hub.slowConsumerTimeout = 0
+	client.enqueue([]byte("update"))
+	if _, overdue := client.enqueue([]byte("update")); !overdue {
+		t.Error("Expected an immediately overdue client without a timeout")
+	}
+}
+
+func TestBroadcastDisconnectsSlowClients(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{SendBuffer: 1}, nil, logger.New(logger.Config{}), testMetrics)
+	slow := NewClient(hub, nil, "slow", logger.New(logger.Config{}))
+	listener := NewClient(hub, nil, "slow", logger.New(logger.Config{}))
+	hub.clients["slow"] = map[*Client]bool{slow: true, listener: true}
+	disconnects := testMetrics.WSSlowDisconnects.WithLabelValues("slow")
+	disconnectsBefore := testutil.ToFloat64(disconnects)
+
+	// The hub loop is not running, so this must not wait on it
+	hub.broadcastMessage(BroadcastMessage{SessionID: "slow", Message: []byte("first")})
+	<-listener.send
+	hub.broadcastMessage(BroadcastMessage{SessionID: "slow", Message: []byte("second")})
+
+	if !slow.closed || listener.closed {
+		t.Fatalf("Expected only the slow client closed, got slow %t listener %t", slow.closed, listener.closed)
+	}
+	if hub.GetSessionConnections("slow") != 1 {
+		t.Errorf("Expected the listener left in the session, got %d clients", hub.GetSessionConnections("slow"))
+	}
+	if code := int(slow.closeFrame[0])<<8 | int(slow.closeFrame[1]); code != websocket.CloseTryAgainLater {
+		t.Errorf("Expected close code %d, got %d", websocket.CloseTryAgainLater, code)
+	}
+	if got := testutil.ToFloat64(disconnects) - disconnectsBefore; got != 1 {
+		t.Errorf("Expected 1 slow consumer disconnect counted, got %v", got)
+	}
+}
@@ -0,0 +1,189 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// Binary mesh frames, sent on connections that negotiated the binary
+// subprotocol, carry a mesh_update with its buffers raw instead of base64
+// encoded. All integers are little-endian:
+//
+//	offset  size  field
+//	0       4     magic "STAG"
+//	4       1     version, 1
+//	5       1     kind, 1 for mesh_update
+//	6       2     header length H
+//	8       4     vertices length V
+//	12      4     faces length F
+//	16      4     normals length N
+//	20      H     api.MeshFrameHeader as JSON
+//	20+H    V     vertices, then F bytes of faces and N of normals
+const (
+	meshFrameVersion    = 1
+	meshFrameKindUpdate = 1
+	meshFramePrefixSize = 20
+)
+
+// meshFrameMagic opens every binary frame. JSON messages open with '{', so
+// the two cannot be mistaken for each other in a client's send queue.
+var meshFrameMagic = []byte("STAG")
+
+// meshUpdatePrefix opens every JSON mesh_update, as api.WSMessage marshals
+// its type first
+var meshUpdatePrefix = []byte(`{"type":"` + api.WSTypeMeshUpdate + `"`)
+
+// MeshFrame is a decoded binary mesh_update frame
+type MeshFrame struct {
+	Header   api.MeshFrameHeader
+	Vertices []byte
+	Faces    []byte
+	Normals  []byte
+}
+
+// EncodeMeshFrame encodes a mesh update as a binary frame
+func EncodeMeshFrame(frame *MeshFrame) ([]byte, error) {
+	header, err := json.Marshal(frame.Header)
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > math.MaxUint16 {
+		return nil, fmt.Errorf("mesh frame header of %d bytes exceeds %d", len(header), math.MaxUint16)
+	}
+	for _, buf := range [][]byte{frame.Vertices, frame.Faces, frame.Normals} {
+		if int64(len(buf)) > math.MaxUint32 {
+			return nil, fmt.Errorf("mesh frame buffer of %d bytes exceeds %d", len(buf), uint32(math.MaxUint32))
+		}
+	}
+
+	data := make([]byte, meshFramePrefixSize, meshFramePrefixSize+len(header)+
+		len(frame.Vertices)+len(frame.Faces)+len(frame.Normals))
+	copy(data, meshFrameMagic)
+	data[4] = meshFrameVersion
+	data[5] = meshFrameKindUpdate
+	binary.LittleEndian.PutUint16(data[6:], uint16(len(header)))
+	binary.LittleEndian.PutUint32(data[8:], uint32(len(frame.Vertices)))
+	binary.LittleEndian.PutUint32(data[12:], uint32(len(frame.Faces)))
+	binary.LittleEndian.PutUint32(data[16:], uint32(len(frame.Normals)))
+
+	data = append(data, header...)
+	data = append(data, frame.Vertices...)
+	data = append(data, frame.Faces...)
+	data = append(data, frame.Normals...)
+	return data, nil
+}
+
+// DecodeMeshFrame decodes a binary frame. The buffers share data's memory.
+func DecodeMeshFrame(data []byte) (*MeshFrame, error) {
+	if len(data) < meshFramePrefixSize || !bytes.Equal(data[:4], meshFrameMagic) {
+		return nil, fmt.Errorf("not a binary mesh frame")
+	}
+	if data[4] != meshFrameVersion {
+		return nil, fmt.Errorf("unsupported binary frame version %d", data[4])
+	}
+	if data[5] != meshFrameKindUpdate {
+		return nil, fmt.Errorf("unsupported binary frame kind %d", data[5])
+	}
+
+	headerLen := int64(binary.LittleEndian.Uint16(data[6:]))
+	verticesLen := int64(binary.LittleEndian.Uint32(data[8:]))
+	facesLen := int64(binary.LittleEndian.Uint32(data[12:]))
+	normalsLen := int64(binary.LittleEndian.Uint32(data[16:]))
+	if want := meshFramePrefixSize + headerLen + verticesLen + facesLen + normalsLen; int64(len(data)) != want {
+		return nil, fmt.Errorf("binary frame is %d bytes, its lengths add up to %d", len(data), want)
+	}
+
+	frame := &MeshFrame{}
+	rest := data[meshFramePrefixSize:]
+	if err := json.Unmarshal(rest[:headerLen], &frame.Header); err != nil {
+		return nil, fmt.Errorf("invalid binary frame header: %w", err)
+	}
+	rest = rest[headerLen:]
+	frame.Vertices, rest = rest[:verticesLen:verticesLen], rest[verticesLen:]
+	frame.Faces, rest = rest[:facesLen:facesLen], rest[facesLen:]
+	frame.Normals = rest
+	return frame, nil
+}
+
+// isMeshFrame reports whether a queued message is a binary frame
+func isMeshFrame(data []byte) bool {
+	return bytes.HasPrefix(data, meshFrameMagic)
+}
+
+// meshFrameMessage converts a binary frame to the mesh_update message it
+// stands for
+func meshFrameMessage(frame *MeshFrame) (*api.WSMessage, error) {
+	data, err := json.Marshal(api.MeshUpdate{
+		ID:               frame.Header.ID,
+		AnchorID:         frame.Header.AnchorID,
+		Vertices:         base64.StdEncoding.EncodeToString(frame.Vertices),
+		Faces:            base64.StdEncoding.EncodeToString(frame.Faces),
+		Normals:          base64.StdEncoding.EncodeToString(frame.Normals),
+		CompressionLevel: frame.Header.CompressionLevel,
+		CompressionCodec: frame.Header.CompressionCodec,
+		IsDelta:          frame.Header.IsDelta,
+		BaseMeshID:       frame.Header.BaseMeshID,
+		Checksum:         frame.Header.Checksum,
+		VertexStride:     frame.Header.VertexStride,
+		IndexFormat:      frame.Header.IndexFormat,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.WSMessage{
+		Type:      api.WSTypeMeshUpdate,
+		SessionID: frame.Header.SessionID,
+		Data:      data,
+		Timestamp: frame.Header.Timestamp,
+		TraceID:   frame.Header.TraceID,
+	}, nil
+}
+
+// binaryMeshFrame re-encodes a JSON mesh_update as a binary frame. It
+// returns nil for other messages, which binary clients receive as JSON.
+func binaryMeshFrame(message []byte) ([]byte, error) {
+	if !bytes.HasPrefix(message, meshUpdatePrefix) {
+		return nil, nil
+	}
+
+	var msg api.WSMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, err
+	}
+	var update api.MeshUpdate
+	if err := json.Unmarshal(msg.Data, &update); err != nil {
+		return nil, err
+	}
+
+	frame := &MeshFrame{Header: api.MeshFrameHeader{
+		SessionID:        msg.SessionID,
+		Timestamp:        msg.Timestamp,
+		TraceID:          msg.TraceID,
+		ID:               update.ID,
+		AnchorID:         update.AnchorID,
+		CompressionLevel: update.CompressionLevel,
+		CompressionCodec: update.CompressionCodec,
+		IsDelta:          update.IsDelta,
+		BaseMeshID:       update.BaseMeshID,
+		Checksum:         update.Checksum,
+		VertexStride:     update.VertexStride,
+		IndexFormat:      update.IndexFormat,
+	}}
+	var err error
+	for _, buf := range []struct {
+		encoded string
+		decoded *[]byte
+	}{{update.Vertices, &frame.Vertices}, {update.Faces, &frame.Faces}, {update.Normals, &frame.Normals}} {
+		if *buf.decoded, err = base64.StdEncoding.DecodeString(buf.encoded); err != nil {
+			return nil, err
+		}
+	}
+	return EncodeMeshFrame(frame)
+}
@@ -0,0 +1,162 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestMeshFrameRoundTrip(t *testing.T) {
+	frame := &MeshFrame{
+		Header:   api.MeshFrameHeader{SessionID: "s1", Timestamp: 42, TraceID: "t1", ID: "m1", AnchorID: "a1", CompressionLevel: 3, VertexStride: 24},
+		Vertices: []byte{1, 2, 3, 4},
+		Faces:    []byte{5, 6},
+	}
+
+	data, err := EncodeMeshFrame(frame)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !isMeshFrame(data) {
+		t.Fatal("Expected the frame to open with the magic")
+	}
+
+	decoded, err := DecodeMeshFrame(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Header != frame.Header {
+		t.Errorf("Expected header %+v, got %+v", frame.Header, decoded.Header)
+	}
+	if !bytes.Equal(decoded.Vertices, frame.Vertices) || !bytes.Equal(decoded.Faces, frame.Faces) || len(decoded.Normals) != 0 {
+		t.Errorf("Expected the buffers back, got %v %v %v", decoded.Vertices, decoded.Faces, decoded.Normals)
+	}
+
+	// Appending to a buffer must not overwrite the next
+	decoded.Vertices = append(decoded.Vertices, 9)
+	if decoded.Faces[0] != 5 {
+		t.Error("Expected buffers not to share capacity")
+	}
+}
+
+func TestDecodeMeshFrameRejects(t *testing.T) {
+	valid, err := EncodeMeshFrame(&MeshFrame{Header: api.MeshFrameHeader{ID: "m1"}, Vertices: []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	modified := func(change func(data []byte) []byte) []byte {
+		return change(append([]byte(nil), valid...))
+	}
+
+	tests := map[string][]byte{
+		"short":     valid[:meshFramePrefixSize-1],
+		"json":      []byte(`{"type":"mesh_update"}`),
+		"version":   modified(func(data []byte) []byte { data[4] = 2; return data }),
+		"kind":      modified(func(data []byte) []byte { data[5] = 7; return data }),
+		"truncated": valid[:len(valid)-1],
+		"trailing":  append(append([]byte(nil), valid...), 0),
+		"header": modified(func(data []byte) []byte {
+			binary.LittleEndian.PutUint16(data[6:], 2)
+			binary.LittleEndian.PutUint32(data[8:], uint32(len(data)-meshFramePrefixSize-2))
+			return data
+		}),
+	}
+	for name, data := range tests {
+		if _, err := DecodeMeshFrame(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMeshFrameMessage(t *testing.T) {
+	message, err := meshFrameMessage(&MeshFrame{
+		Header:   api.MeshFrameHeader{SessionID: "s1", Timestamp: 42, ID: "m1", AnchorID: "a1", Checksum: "abcd"},
+		Vertices: []byte{1, 2, 3},
+	})
+	if err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if message.Type != api.WSTypeMeshUpdate || message.SessionID != "s1" || message.Timestamp != 42 {
+		t.Errorf("Unexpected envelope %+v", message)
+	}
+
+	var update api.MeshUpdate
+	if err := json.Unmarshal(message.Data, &update); err != nil {
+		t.Fatalf("Failed to decode update: %v", err)
+	}
+	if update.ID != "m1" || update.Checksum != "abcd" || update.Vertices != base64.StdEncoding.EncodeToString([]byte{1, 2, 3}) {
+		t.Errorf("Unexpected update %+v", update)
+	}
+}
+
+func TestHubBinaryMeshBroadcast(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{BinarySubprotocol: "stag.binary.v1"}, nil, logger.New(logger.Config{}), testMetrics)
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	server := newTestServer(t, hub)
+	binaryConn := dialWith(t, &websocket.Dialer{Subprotocols: []string{"stag.binary.v1"}}, server, "binary")
+	jsonConn := dial(t, server, "binary")
+	waitFor(t, func() bool { return hub.GetSessionConnections("binary") == 2 })
+
+	if binaryConn.Subprotocol() != "stag.binary.v1" || jsonConn.Subprotocol() != "" {
+		t.Fatalf("Unexpected subprotocols %q and %q", binaryConn.Subprotocol(), jsonConn.Subprotocol())
+	}
+
+	vertices := []byte{0, 0, 128, 63, 0, 0, 0, 64}
+	data, _ := json.Marshal(api.MeshUpdate{ID: "m1", AnchorID: "a1", Vertices: base64.StdEncoding.EncodeToString(vertices)})
+	if err := hub.BroadcastToSession("binary", &api.WSMessage{Type: api.WSTypeMeshUpdate, SessionID: "binary", Data: data, Timestamp: 7}); err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+
+	binaryConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	messageType, received, err := binaryConn.ReadMessage()
+	if err != nil || messageType != websocket.BinaryMessage {
+		t.Fatalf("Expected a binary frame, got type %d (%v)", messageType, err)
+	}
+	frame, err := DecodeMeshFrame(received)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if frame.Header.ID != "m1" || frame.Header.Timestamp != 7 || !bytes.Equal(frame.Vertices, vertices) {
+		t.Errorf("Unexpected frame %+v", frame)
+	}
+
+	jsonConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if messageType, _, err := jsonConn.ReadMessage(); err != nil || messageType != websocket.TextMessage {
+		t.Errorf("Expected a text frame for the JSON client, got type %d (%v)", messageType, err)
+	}
+
+	// Other messages reach binary clients as JSON
+	if err := hub.BroadcastToSession("binary", &api.WSMessage{Type: api.WSTypeAnchorDiff, SessionID: "binary"}); err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+	if messageType, _, err := binaryConn.ReadMessage(); err != nil || messageType != websocket.TextMessage {
+		t.Errorf("Expected a text frame for an anchor_diff, got type %d (%v)", messageType, err)
+	}
+	if _, _, err := jsonConn.ReadMessage(); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	// Binary frames need the subprotocol
+	frameData, _ := EncodeMeshFrame(&MeshFrame{Header: api.MeshFrameHeader{ID: "m2"}})
+	if err := jsonConn.WriteMessage(websocket.BinaryMessage, frameData); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	var reply api.WSMessage
+	if err := jsonConn.ReadJSON(&reply); err != nil || reply.Type != api.WSTypeError || !strings.Contains(string(reply.Data), "INVALID_MESSAGE") {
+		t.Errorf("Expected an INVALID_MESSAGE error, got %+v (%v)", reply, err)
+	}
+}
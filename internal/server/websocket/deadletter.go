@@ -0,0 +1,264 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/internal/tracing"
+	"github.com/tabular/stag-v2/pkg/api"
+	apierrors "github.com/tabular/stag-v2/pkg/errors"
+)
+
+// DeadLetterQueue is a bounded in-memory buffer of updates that failed
+// processing. Entries whose failure was transient are retried with
+// exponential backoff; the rest stay until evicted so they can be inspected.
+type DeadLetterQueue struct {
+	mu       sync.Mutex
+	entries  []*deadLetter // Oldest first
+	bytes    int64         // Data bytes held by entries
+	dropped  int64         // Entries evicted to stay within the caps
+	size     int
+	maxBytes int64 // 0 is unlimited
+
+	retries    int
+	retryDelay time.Duration
+}
+
+// deadLetter is a queued update and its retry state
+type deadLetter struct {
+	api.DeadLetter
+	message     *api.WSMessage
+	actor       audit.Actor // Sender of the update, whose it stays on retry
+	nextAttempt time.Time
+	inFlight    bool // Being retried outside the lock
+}
+
+// NewDeadLetterQueue creates a queue holding at most size entries and
+// maxBytes of update data, or returns nil if size is 0
+func NewDeadLetterQueue(size int, maxBytes int64, retries int, retryDelay time.Duration) *DeadLetterQueue {
+	if size <= 0 {
+		return nil
+	}
+	return &DeadLetterQueue{
+		size:       size,
+		maxBytes:   maxBytes,
+		retries:    retries,
+		retryDelay: retryDelay,
+	}
+}
+
+// shouldDeadLetter reports whether a processing error is the server's fault
+// or transient. Rejections of the update itself are only reported to the
+// client, since retrying them cannot help. Updates whose processing was
+// cancelled, as on shutdown, are kept for inspection.
+func shouldDeadLetter(err error) bool {
+	if apiErr, ok := apierrors.IsAPIError(err); ok {
+		return apiErr.StatusCode >= 500 || apiErr.IsRetryable() || errorClass(err) == database.ErrorClassCanceled
+	}
+	return err != nil
+}
+
+// errorClass classifies a processing error by its database cause
+func errorClass(err error) database.ErrorClass {
+	if apiErr, ok := apierrors.IsAPIError(err); ok && apiErr.Err != nil {
+		err = apiErr.Err
+	}
+	return database.ClassifyError(err)
+}
+
+// Add queues msg, sent by actor, which failed with err, evicting the oldest
+// entries beyond the caps. It returns the error class and how many entries
+// were evicted.
+func (q *DeadLetterQueue) Add(msg *api.WSMessage, actor audit.Actor, err error, now time.Time) (database.ErrorClass, int) {
+	class := errorClass(err)
+	entry := &deadLetter{
+		DeadLetter: api.DeadLetter{
+			ID:          uuid.New().String(),
+			Type:        msg.Type,
+			SessionID:   msg.SessionID,
+			TraceID:     msg.TraceID,
+			ErrorClass:  string(class),
+			Error:       err.Error(),
+			Attempts:    1,
+			Retrying:    class.Retryable() && q.retries > 0,
+			FailedAt:    now.UnixMilli(),
+			LastAttempt: now.UnixMilli(),
+			Size:        len(msg.Data),
+		},
+		message:     msg,
+		actor:       actor,
+		nextAttempt: now.Add(q.retryDelay),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.entries = append(q.entries, entry)
+	q.bytes += int64(entry.Size)
+
+	evicted := 0
+	for len(q.entries) > 1 && (len(q.entries) > q.size || (q.maxBytes > 0 && q.bytes > q.maxBytes)) {
+		q.bytes -= int64(q.entries[0].Size)
+		q.entries[0] = nil
+		q.entries = q.entries[1:]
+		evicted++
+	}
+	q.dropped += int64(evicted)
+
+	return class, evicted
+}
+
+// Len returns the number of queued entries
+func (q *DeadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries)
+}
+
+// List returns up to limit entries, newest first, for sessions allowed by
+// allows (nil allows all)
+func (q *DeadLetterQueue) List(allows func(sessionID string) bool, limit int, includeData bool) *api.DeadLetterList {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := &api.DeadLetterList{DeadLetters: []api.DeadLetter{}, Dropped: q.dropped}
+	for i := len(q.entries) - 1; i >= 0 && len(list.DeadLetters) < limit; i-- {
+		entry := q.entries[i]
+		if allows != nil && !allows(entry.SessionID) {
+			continue
+		}
+
+		deadLetter := entry.DeadLetter
+		if includeData {
+			deadLetter.Data = entry.message.Data
+		}
+		list.DeadLetters = append(list.DeadLetters, deadLetter)
+	}
+	list.Count = len(list.DeadLetters)
+	return list
+}
+
+// retryDue reprocesses entries whose next attempt is due, removing those
+// that succeed and returning their messages. Failed entries back off, and
+// stop retrying once attempts are exhausted or the failure is permanent.
+func (q *DeadLetterQueue) retryDue(now time.Time, process func(msg *api.WSMessage, actor audit.Actor) error) []*api.WSMessage {
+	q.mu.Lock()
+	var due []*deadLetter
+	for _, entry := range q.entries {
+		if entry.Retrying && !entry.inFlight && !now.Before(entry.nextAttempt) {
+			entry.inFlight = true
+			due = append(due, entry)
+		}
+	}
+	q.mu.Unlock()
+
+	var succeeded []*api.WSMessage
+	for _, entry := range due {
+		err := process(entry.message, entry.actor)
+
+		q.mu.Lock()
+		entry.inFlight = false
+		if err == nil {
+			// Stored even if evicted meanwhile, so announce it either way
+			q.remove(entry)
+			q.mu.Unlock()
+			succeeded = append(succeeded, entry.message)
+			continue
+		}
+
+		class := errorClass(err)
+		entry.Attempts++
+		entry.ErrorClass = string(class)
+		entry.Error = err.Error()
+		entry.LastAttempt = now.UnixMilli()
+		entry.Retrying = class.Retryable() && entry.Attempts <= q.retries
+		entry.nextAttempt = now.Add(q.retryDelay << (entry.Attempts - 1))
+		q.mu.Unlock()
+	}
+	return succeeded
+}
+
+// remove drops entry if it has not been evicted. Callers must hold q.mu.
+func (q *DeadLetterQueue) remove(entry *deadLetter) {
+	for i, queued := range q.entries {
+		if queued == entry {
+			q.bytes -= int64(entry.Size)
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// deadLetter queues an update from actor that failed processing for a
+// server-side reason
+func (h *Hub) deadLetter(msg *api.WSMessage, actor audit.Actor, err error) {
+	if h.deadLetters == nil || !shouldDeadLetter(err) {
+		return
+	}
+
+	class, evicted := h.deadLetters.Add(msg, actor, err, time.Now())
+	if evicted > 0 {
+		h.logger.Warnf("Dead-letter buffer full, dropped %d oldest entries", evicted)
+	}
+	h.metrics.WSDeadLettersTotal.WithLabelValues(msg.Type, string(class)).Inc()
+	h.metrics.WSDeadLettersQueued.Set(float64(h.deadLetters.Len()))
+}
+
+// retryDeadLetters reprocesses transiently failed updates until Shutdown,
+// broadcasting what each one that is stored changed to its session
+func (h *Hub) retryDeadLetters() {
+	ticker := time.NewTicker(h.deadLetters.retryDelay)
+	defer ticker.Stop()
+
+	// What to broadcast for each update stored by the current round
+	broadcasts := make(map[*api.WSMessage]*api.WSMessage)
+	process := func(msg *api.WSMessage, actor audit.Actor) error {
+		ctx, cancel := updateContext(audit.WithActor(context.Background(), actor), msg)
+		defer cancel()
+		ctx, span := tracing.Start(ctx, "WebSocket "+msg.Type+" retry",
+			tracing.SessionIDKey.String(msg.SessionID), tracing.OperationKey.String(msg.Type))
+		broadcast, err := h.repository.ProcessWebSocketMessage(ctx, msg)
+		tracing.End(span, err)
+		if broadcast != nil {
+			broadcasts[msg] = broadcast
+		}
+		return err
+	}
+
+	for {
+		select {
+		case <-h.done:
+			return
+
+		case now := <-ticker.C:
+			stored := h.deadLetters.retryDue(now, process)
+			h.metrics.WSDeadLettersQueued.Set(float64(h.deadLetters.Len()))
+
+			for _, msg := range stored {
+				h.logger.Infof("Stored dead-lettered %s for session %s on retry", msg.Type, msg.SessionID)
+				h.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "retried").Inc()
+				if broadcast := broadcasts[msg]; broadcast != nil {
+					if err := h.BroadcastUpdate(msg.SessionID, broadcast, locateUpdate(msg)); err != nil {
+						h.logger.Errorf("Failed to broadcast retried %s: %v", msg.Type, err)
+					}
+				}
+			}
+			clear(broadcasts)
+		}
+	}
+}
+
+// DeadLetters lists recent dead-lettered updates, newest first, for
+// sessions allowed by allows (nil allows all)
+func (h *Hub) DeadLetters(allows func(sessionID string) bool, limit int, includeData bool) *api.DeadLetterList {
+	if h.deadLetters == nil {
+		return &api.DeadLetterList{DeadLetters: []api.DeadLetter{}}
+	}
+	return h.deadLetters.List(allows, limit, includeData)
+}
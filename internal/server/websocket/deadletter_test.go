@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/pkg/api"
+	apierrors "github.com/tabular/stag-v2/pkg/errors"
+)
+
+// unavailableError is how the repository reports an unreachable database
+func unavailableError() error {
+	err := apierrors.DatabaseUnavailable("connection refused")
+	err.Err = syscall.ECONNREFUSED
+	return err
+}
+
+func deadLetterMessage(sessionID, data string) *api.WSMessage {
+	return &api.WSMessage{Type: api.WSTypeAnchorUpdate, SessionID: sessionID, Data: json.RawMessage(data)}
+}
+
+func TestShouldDeadLetter(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Invalid", apierrors.BadRequest("bad pose"), false},
+		{"Unavailable", unavailableError(), true},
+		{"Conflict", apierrors.DatabaseConflict("write conflict"), true},
+		{"Internal", errors.New("boom"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldDeadLetter(tt.err); got != tt.want {
+				t.Errorf("shouldDeadLetter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeadLetterQueueEvictsOldest(t *testing.T) {
+	if NewDeadLetterQueue(0, 0, 0, 0) != nil {
+		t.Error("Expected a zero size to disable the queue")
+	}
+
+	queue := NewDeadLetterQueue(2, 10, 0, time.Second)
+	now := time.Now()
+
+	queue.Add(deadLetterMessage("s1", `"a"`), audit.Actor{}, errors.New("first"), now)
+	queue.Add(deadLetterMessage("s2", `"b"`), audit.Actor{}, errors.New("second"), now)
+	if _, evicted := queue.Add(deadLetterMessage("s1", `"c"`), audit.Actor{}, errors.New("third"), now); evicted != 1 {
+		t.Errorf("Expected the oldest entry evicted by count, got %d", evicted)
+	}
+
+	list := queue.List(nil, 10, false)
+	if list.Count != 2 || list.Dropped != 1 || list.DeadLetters[0].Error != "third" || list.DeadLetters[1].Error != "second" {
+		t.Fatalf("Expected the two newest entries, newest first, got %+v", list)
+	}
+	if list.DeadLetters[0].Data != nil {
+		t.Error("Expected data omitted unless requested")
+	}
+
+	// A 9 byte update leaves room for nothing else under the 10 byte cap
+	if _, evicted := queue.Add(deadLetterMessage("s3", `"1234567"`), audit.Actor{}, errors.New("large"), now); evicted != 2 {
+		t.Errorf("Expected both older entries evicted by size, got %d", evicted)
+	}
+
+	list = queue.List(func(sessionID string) bool { return sessionID == "s3" }, 10, true)
+	if list.Count != 1 || string(list.DeadLetters[0].Data) != `"1234567"` {
+		t.Errorf("Expected the large entry with its data, got %+v", list)
+	}
+	if list := queue.List(func(sessionID string) bool { return sessionID == "s1" }, 10, false); list.Count != 0 {
+		t.Errorf("Expected no entries for an evicted session, got %d", list.Count)
+	}
+}
+
+func TestDeadLetterQueueRetry(t *testing.T) {
+	queue := NewDeadLetterQueue(10, 0, 2, time.Second)
+	start := time.Now()
+
+	transient := deadLetterMessage("s1", `"transient"`)
+	permanent := deadLetterMessage("s1", `"permanent"`)
+	sender := audit.Actor{Principal: "key:0123abcd", ClientIP: "10.0.0.1"}
+	if class, _ := queue.Add(transient, sender, unavailableError(), start); class != "unavailable" {
+		t.Errorf("Expected class unavailable, got %s", class)
+	}
+	queue.Add(permanent, audit.Actor{}, errors.New("boom"), start)
+
+	calls := 0
+	failing := func(msg *api.WSMessage, actor audit.Actor) error {
+		calls++
+		return unavailableError()
+	}
+
+	if stored := queue.retryDue(start.Add(500*time.Millisecond), failing); len(stored) != 0 || calls != 0 {
+		t.Fatalf("Expected nothing due before the retry delay, got %d calls", calls)
+	}
+
+	// First retry fails and backs off to twice the delay
+	queue.retryDue(start.Add(time.Second), failing)
+	if calls != 1 {
+		t.Fatalf("Expected only the transient failure retried, got %d calls", calls)
+	}
+	queue.retryDue(start.Add(2*time.Second), failing)
+	if calls != 1 {
+		t.Fatalf("Expected the retry to back off, got %d calls", calls)
+	}
+
+	// Retries are made on behalf of the update's sender
+	var retriedBy audit.Actor
+	stored := queue.retryDue(start.Add(3*time.Second), func(msg *api.WSMessage, actor audit.Actor) error {
+		retriedBy = actor
+		return nil
+	})
+	if len(stored) != 1 || stored[0] != transient {
+		t.Fatalf("Expected the transient update stored, got %v", stored)
+	}
+	if retriedBy != sender {
+		t.Errorf("Expected the retry made as %+v, got %+v", sender, retriedBy)
+	}
+
+	list := queue.List(nil, 10, false)
+	if list.Count != 1 || list.DeadLetters[0].Retrying || list.DeadLetters[0].ErrorClass != "permanent" {
+		t.Errorf("Expected only the permanent failure left, not retrying, got %+v", list)
+	}
+}
+
+func TestDeadLetterQueueRetriesExhausted(t *testing.T) {
+	queue := NewDeadLetterQueue(10, 0, 2, time.Second)
+	start := time.Now()
+	queue.Add(deadLetterMessage("s1", `{}`), audit.Actor{}, unavailableError(), start)
+
+	failing := func(msg *api.WSMessage, actor audit.Actor) error { return unavailableError() }
+	for now := start; now.Before(start.Add(time.Minute)); now = now.Add(time.Second) {
+		queue.retryDue(now, failing)
+	}
+
+	entry := queue.List(nil, 1, false).DeadLetters[0]
+	if entry.Attempts != 3 || entry.Retrying {
+		t.Errorf("Expected 3 attempts and no further retries, got %d (retrying %v)", entry.Attempts, entry.Retrying)
+	}
+}
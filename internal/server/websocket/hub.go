@@ -3,17 +3,31 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/internal/config"
 	"github.com/tabular/stag-v2/internal/metrics"
 	"github.com/tabular/stag-v2/internal/spatial"
+	"github.com/tabular/stag-v2/internal/tracing"
 	"github.com/tabular/stag-v2/pkg/api"
+	apierrors "github.com/tabular/stag-v2/pkg/errors"
 	"github.com/tabular/stag-v2/pkg/logger"
 )
 
+// Liveness timings of hubs configured without them; the ping period
+// defaults to nine tenths of the pong wait
+const (
+	defaultPongWait  = 60 * time.Second
+	defaultWriteWait = 10 * time.Second
+)
+
 // Hub manages WebSocket connections and message routing
 type Hub struct {
 	// Clients organized by session ID
@@ -21,17 +35,46 @@ type Hub struct {
 	mu      sync.RWMutex
 
 	// Channels for client management
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan BroadcastMessage
+	register    chan registration
+	unregister  chan *Client
+	broadcast   chan BroadcastMessage
+	subscribe   chan subscription
+	unsubscribe chan subscription
 
 	// Dependencies
 	repository *spatial.Repository
+	snapshots  snapshotSource // nil without a repository
 	logger     logger.Logger
 	metrics    *metrics.Metrics
 
 	// Configuration
-	maxClientsPerSession int
+	maxClientsPerSession      int
+	maxSubscriptionsPerClient int
+	maxMessageSize            int64 // Inbound frame limit in bytes, 0 is unlimited
+	compression               bool  // Compress outbound messages on connections that negotiated it
+	compressionLevel          int
+	compressionThreshold      int           // Shorter messages are sent uncompressed
+	idleTimeout               time.Duration // Clients sending nothing for longer are disconnected, 0 disables
+	pongWait                  time.Duration // Clients whose pong is later are disconnected
+	pingPeriod                time.Duration // Interval between pings, shorter than pongWait
+	writeWait                 time.Duration // Deadline of each outbound write
+	sendBuffer                int           // Outbound messages a client may queue without being warned
+	sendOverflow              int           // Further messages queued for a client falling behind
+	slowConsumerTimeout       time.Duration // Clients overflowing for longer are disconnected
+	binarySubprotocol         string        // Subprotocol of binary mesh frames, "" when disabled
+	snapshotPageSize          int           // Anchors per snapshot message
+	snapshotMaxAnchors        int           // Anchors a snapshot sends at most
+
+	// Updates that failed processing, nil when disabled
+	deadLetters *DeadLetterQueue
+
+	// Last broadcast anchor positions by session and anchor ID, placing mesh
+	// updates for clients subscribed with a region. See locate.
+	positions map[string]map[string]Position
+
+	// Shutdown signalling
+	done         chan struct{}
+	shutdownOnce sync.Once
 }
 
 // Client represents a WebSocket client connection
@@ -41,68 +84,220 @@ type Client struct {
 	sessionID string
 	send      chan []byte
 	logger    logger.Logger
+	readOnly  bool
+	allows    func(sessionID string) bool // Sessions the client may use, nil allows all
+	binary    bool                        // Mesh updates are exchanged as binary frames
+
+	// Sessions joined after connecting, guarded by hub.mu
+	subscriptions map[string]bool
+
+	// Regions the client receives updates in by session, guarded by hub.mu
+	regions map[string]*regionFilter
+
+	// Unix nanoseconds of the last message read; pongs do not count
+	lastMessageAt atomic.Int64
+
+	// ctx parents the processing of the client's updates and is cancelled
+	// when either pump exits, aborting work for a connection that is gone
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// sendMu guards closing send so no goroutine sends on a closed channel
+	sendMu     sync.Mutex
+	closed     bool
+	closeFrame []byte
+	pumps      sync.WaitGroup
+
+	// When the send queue last rose above the hub's send buffer, zero while
+	// within it. Guarded by sendMu.
+	overflowSince time.Time
+
+	// Broadcasts held while a snapshot is sent, and whether more arrived
+	// than could be held. Guarded by sendMu.
+	holding bool
+	held    [][]byte
+	overran bool
 }
 
 // BroadcastMessage represents a message to broadcast
 type BroadcastMessage struct {
 	SessionID string
 	Message   []byte
-	Exclude   *Client // Exclude this client from broadcast
+	Exclude   *Client         // Exclude this client from broadcast
+	Location  *UpdateLocation // Anchor the message is about, nil if every client receives it
+}
+
+// registration is a request to add a client, answered on result
+type registration struct {
+	client *Client
+	result chan error
+}
+
+// Registration errors
+var (
+	ErrSessionFull = errors.New("session has reached its connection limit")
+	ErrHubClosed   = errors.New("server shutting down")
+)
+
+// subscription is a request to join or leave a session's broadcasts
+type subscription struct {
+	client           *Client
+	sessionID        string
+	compressionLevel *int // Storage compression level to set for the session once subscribed
+
+	// Region to receive the session's updates in once subscribed, when
+	// setRegion; nil removes the client's region
+	region    *regionFilter
+	setRegion bool
 }
 
 // NewHub creates a new WebSocket hub
-func NewHub(repository *spatial.Repository, logger logger.Logger, metrics *metrics.Metrics) *Hub {
-	return &Hub{
-		clients:              make(map[string]map[*Client]bool),
-		register:             make(chan *Client),
-		unregister:           make(chan *Client),
-		broadcast:            make(chan BroadcastMessage),
-		repository:           repository,
-		logger:               logger,
-		metrics:              metrics,
-		maxClientsPerSession: 10,
+func NewHub(cfg config.WebSocketConfig, repository *spatial.Repository, logger logger.Logger, metrics *metrics.Metrics) *Hub {
+	hub := &Hub{
+		clients:                   make(map[string]map[*Client]bool),
+		register:                  make(chan registration),
+		unregister:                make(chan *Client),
+		broadcast:                 make(chan BroadcastMessage),
+		subscribe:                 make(chan subscription),
+		unsubscribe:               make(chan subscription),
+		repository:                repository,
+		logger:                    logger,
+		metrics:                   metrics,
+		maxClientsPerSession:      10,
+		maxSubscriptionsPerClient: 16,
+		maxMessageSize:            cfg.MaxMessageSize,
+		compression:               cfg.EnableCompression,
+		compressionLevel:          cfg.CompressionLevel,
+		compressionThreshold:      cfg.CompressionThreshold,
+		idleTimeout:               cfg.IdleTimeout,
+		pongWait:                  cfg.PongWait,
+		pingPeriod:                cfg.PingPeriod,
+		writeWait:                 cfg.WriteWait,
+		sendBuffer:                cfg.SendBuffer,
+		sendOverflow:              cfg.SendOverflow,
+		slowConsumerTimeout:       cfg.SlowConsumerTimeout,
+		binarySubprotocol:         cfg.BinarySubprotocol,
+		snapshotPageSize:          cfg.SnapshotPageSize,
+		snapshotMaxAnchors:        cfg.SnapshotMaxAnchors,
+		deadLetters:               NewDeadLetterQueue(cfg.DeadLetterSize, cfg.DeadLetterMaxBytes, cfg.DeadLetterRetries, cfg.DeadLetterRetryDelay),
+		positions:                 make(map[string]map[string]Position),
+		done:                      make(chan struct{}),
+	}
+	if hub.pongWait <= 0 {
+		hub.pongWait = defaultPongWait
+	}
+	if hub.pingPeriod <= 0 || hub.pingPeriod >= hub.pongWait {
+		hub.pingPeriod = hub.pongWait * 9 / 10
+	}
+	if hub.writeWait <= 0 {
+		hub.writeWait = defaultWriteWait
+	}
+	if hub.sendBuffer <= 0 {
+		hub.sendBuffer = defaultSendBuffer
+	}
+	if hub.snapshotPageSize <= 0 {
+		hub.snapshotPageSize = defaultSnapshotPageSize
 	}
+	if hub.snapshotMaxAnchors <= 0 {
+		hub.snapshotMaxAnchors = defaultSnapshotMaxAnchors
+	}
+	if repository != nil {
+		hub.snapshots = repository
+	}
+	return hub
 }
 
-// Run starts the hub's main event loop
+// Run starts the hub's main event loop until Shutdown is called
 func (h *Hub) Run() {
+	// Sweep several times per idle window so clients are not kept much past it
+	var sweep <-chan time.Time
+	if h.idleTimeout > 0 {
+		ticker := time.NewTicker(h.idleTimeout / 4)
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
+
+	if h.deadLetters != nil && h.deadLetters.retries > 0 {
+		go h.retryDeadLetters()
+	}
+
 	for {
 		select {
-		case client := <-h.register:
-			h.registerClient(client)
+		case <-h.done:
+			return
+
+		case now := <-sweep:
+			h.disconnectIdleClients(now)
+
+		case reg := <-h.register:
+			reg.result <- h.registerClient(reg.client)
 
 		case client := <-h.unregister:
 			h.unregisterClient(client)
 
 		case message := <-h.broadcast:
 			h.broadcastMessage(message)
+
+		case sub := <-h.subscribe:
+			h.subscribeClient(sub)
+
+		case sub := <-h.unsubscribe:
+			h.unsubscribeClient(sub)
 		}
 	}
 }
 
+// Register adds a client to the hub. If the hub rejects it, the client is
+// sent a close frame with the reason, its connection is closed and the
+// rejection is returned; its pumps must not be started.
+func (h *Hub) Register(client *Client) error {
+	reg := registration{client: client, result: make(chan error, 1)}
+
+	var err error
+	select {
+	case h.register <- reg:
+		err = <-reg.result
+	case <-h.done:
+		err = ErrHubClosed
+	}
+
+	if err != nil {
+		client.reject(err)
+	}
+	return err
+}
+
 // registerClient adds a new client to the hub
-func (h *Hub) registerClient(client *Client) {
+func (h *Hub) registerClient(client *Client) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	// Initialize session map if needed
-	if h.clients[client.sessionID] == nil {
-		h.clients[client.sessionID] = make(map[*Client]bool)
+	// Turn away clients that raced with shutdown
+	select {
+	case <-h.done:
+		return ErrHubClosed
+	default:
 	}
 
 	// Check connection limit
 	if len(h.clients[client.sessionID]) >= h.maxClientsPerSession {
 		h.logger.Warnf("Session %s exceeded max connections (%d)", client.sessionID, h.maxClientsPerSession)
-		close(client.send)
-		return
+		return ErrSessionFull
+	}
+
+	// Initialize session map if needed
+	if h.clients[client.sessionID] == nil {
+		h.clients[client.sessionID] = make(map[*Client]bool)
 	}
 
-	// Add client
+	// Add client; Shutdown waits on its pumps from here on
 	h.clients[client.sessionID][client] = true
-	h.metrics.WSConnectionsActive.WithLabelValues(client.sessionID).Inc()
+	client.pumps.Add(2)
+	h.metrics.WSConnectionsActive.WithLabelValues(h.metrics.SessionLabel(client.sessionID)).Inc()
 
-	h.logger.Infof("Client connected to session %s (total: %d)", 
+	h.logger.Infof("Client connected to session %s (total: %d)",
 		client.sessionID, len(h.clients[client.sessionID]))
+	return nil
 }
 
 // unregisterClient removes a client from the hub
@@ -110,16 +305,23 @@ func (h *Hub) unregisterClient(client *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	h.removeClient(client, nil)
+}
+
+// removeClient drops a client from every session it is in and closes it with
+// frame as the close message. Callers must hold h.mu.
+func (h *Hub) removeClient(client *Client, frame []byte) {
 	if clients, ok := h.clients[client.sessionID]; ok {
 		if _, ok := clients[client]; ok {
-			delete(clients, client)
-			close(client.send)
-			h.metrics.WSConnectionsActive.WithLabelValues(client.sessionID).Dec()
-
-			// Clean up empty session
-			if len(clients) == 0 {
-				delete(h.clients, client.sessionID)
+			// Leave subscribed sessions first
+			for sessionID := range client.subscriptions {
+				h.removeFromSession(client, sessionID)
 			}
+			client.subscriptions = nil
+			client.regions = nil
+
+			h.removeFromSession(client, client.sessionID)
+			client.closeSend(frame)
 
 			h.logger.Infof("Client disconnected from session %s (remaining: %d)",
 				client.sessionID, len(clients))
@@ -127,96 +329,415 @@ func (h *Hub) unregisterClient(client *Client) {
 	}
 }
 
-// broadcastMessage sends a message to all clients in a session
-func (h *Hub) broadcastMessage(msg BroadcastMessage) {
-	h.mu.RLock()
-	clients := h.clients[msg.SessionID]
-	h.mu.RUnlock()
+// disconnectIdleClients closes clients that have sent no message within the
+// idle timeout. Ping/pong liveness is left to the pumps' deadlines.
+func (h *Hub) disconnectIdleClients(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Visit each client once, under the session it connected with
+	var idle []*Client
+	for sessionID, clients := range h.clients {
+		for client := range clients {
+			if client.sessionID == sessionID && now.Sub(client.lastMessage()) >= h.idleTimeout {
+				idle = append(idle, client)
+			}
+		}
+	}
+
+	reason := fmt.Sprintf("idle timeout: no messages for %s", h.idleTimeout)
+	frame := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	for _, client := range idle {
+		h.logger.Infof("Closing idle client of session %s, last message %s ago",
+			client.sessionID, now.Sub(client.lastMessage()).Round(time.Second))
+		h.removeClient(client, frame)
+		h.metrics.WSDisconnectsTotal.WithLabelValues("idle").Inc()
+	}
+}
+
+// subscribeClient adds a registered client to another session's broadcasts
+func (h *Hub) subscribeClient(sub subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client := sub.client
+	if !h.clients[client.sessionID][client] {
+		return // Client already disconnected
+	}
+
+	if sub.sessionID == client.sessionID || client.subscriptions[sub.sessionID] {
+		h.applySubscribeOptions(sub)
+		client.sendAck(api.WSTypeSubscribe, sub.sessionID)
+		return
+	}
+
+	if !client.allowsSession(sub.sessionID) {
+		client.sendError("FORBIDDEN", "token does not grant access to session "+sub.sessionID)
+		return
+	}
+
+	if len(client.subscriptions) >= h.maxSubscriptionsPerClient {
+		client.sendError("SUBSCRIPTION_LIMIT", fmt.Sprintf("maximum of %d subscriptions per connection", h.maxSubscriptionsPerClient))
+		return
+	}
+
+	if len(h.clients[sub.sessionID]) >= h.maxClientsPerSession {
+		h.logger.Warnf("Session %s exceeded max connections (%d)", sub.sessionID, h.maxClientsPerSession)
+		client.sendError("SESSION_FULL", "session has reached its connection limit")
+		return
+	}
+
+	if h.clients[sub.sessionID] == nil {
+		h.clients[sub.sessionID] = make(map[*Client]bool)
+	}
+	h.clients[sub.sessionID][client] = true
+	client.subscriptions[sub.sessionID] = true
+	h.metrics.WSConnectionsActive.WithLabelValues(h.metrics.SessionLabel(sub.sessionID)).Inc()
+
+	h.logger.Infof("Client from session %s subscribed to session %s", client.sessionID, sub.sessionID)
+	h.applySubscribeOptions(sub)
+	client.sendAck(api.WSTypeSubscribe, sub.sessionID)
+}
+
+// applySubscribeOptions applies the options of a granted subscription.
+// Callers must hold h.mu.
+func (h *Hub) applySubscribeOptions(sub subscription) {
+	if sub.compressionLevel != nil {
+		level := h.repository.SetSessionCompressionLevel(sub.sessionID, *sub.compressionLevel)
+		h.logger.Infof("Session %s meshes are stored at compression level %d", sub.sessionID, level)
+	}
 
-	if clients == nil {
+	if sub.setRegion {
+		client := sub.client
+		if sub.region == nil {
+			delete(client.regions, sub.sessionID)
+			return
+		}
+		if client.regions == nil {
+			client.regions = make(map[string]*regionFilter)
+		}
+		client.regions[sub.sessionID] = sub.region
+	}
+}
+
+// unsubscribeClient removes a client from a session it subscribed to
+func (h *Hub) unsubscribeClient(sub subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client := sub.client
+	if !h.clients[client.sessionID][client] {
+		return // Client already disconnected
+	}
+
+	if sub.sessionID == client.sessionID {
+		client.sendError("INVALID_SUBSCRIPTION", "cannot unsubscribe from the connection's own session")
+		return
+	}
+
+	if !client.subscriptions[sub.sessionID] {
+		client.sendError("NOT_SUBSCRIBED", fmt.Sprintf("not subscribed to session %s", sub.sessionID))
 		return
 	}
 
-	for client := range clients {
+	delete(client.subscriptions, sub.sessionID)
+	delete(client.regions, sub.sessionID)
+	h.removeFromSession(client, sub.sessionID)
+
+	h.logger.Infof("Client from session %s unsubscribed from session %s", client.sessionID, sub.sessionID)
+	client.sendAck(api.WSTypeUnsubscribe, sub.sessionID)
+}
+
+// removeFromSession drops a client from a session's client set. Callers must hold h.mu.
+func (h *Hub) removeFromSession(client *Client, sessionID string) {
+	clients, ok := h.clients[sessionID]
+	if !ok || !clients[client] {
+		return
+	}
+
+	delete(clients, client)
+	h.metrics.WSConnectionsActive.WithLabelValues(h.metrics.SessionLabel(sessionID)).Dec()
+
+	// Clean up empty session, including its connection gauge series
+	if len(clients) == 0 {
+		delete(h.clients, sessionID)
+		delete(h.positions, sessionID)
+		h.metrics.ForgetSessionConnections(sessionID)
+	}
+}
+
+// broadcastMessage sends a message to all clients in or subscribed to a
+// session, disconnecting those that have fallen behind for too long.
+// Updates of anchors outside a client's region are not sent to it.
+func (h *Hub) broadcastMessage(msg BroadcastMessage) {
+	h.mu.RLock()
+	position := h.locate(msg)
+	clients := make([]*Client, 0, len(h.clients[msg.SessionID]))
+	filtered := 0
+	for client := range h.clients[msg.SessionID] {
 		// Skip excluded client
 		if client == msg.Exclude {
 			continue
 		}
+		if position != nil && !client.inRegion(msg.SessionID, msg.Location.AnchorID, *position) {
+			filtered++
+			continue
+		}
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
 
-		select {
-		case client.send <- msg.Message:
-			// Message sent successfully
-		default:
-			// Client's send channel is full, close it
-			h.logger.Warnf("Client send buffer full, closing connection")
-			h.unregister <- client
+	if filtered > 0 {
+		h.metrics.WSMessagesTotal.WithLabelValues("outbound", "data", "filtered").Add(float64(filtered))
+	}
+
+	// Mesh updates are encoded for binary clients once, if any are listening
+	var frame []byte
+	encoded := false
+	var slow []*Client
+	for _, client := range clients {
+		message := msg.Message
+		if client.binary {
+			if !encoded {
+				var err error
+				if frame, err = binaryMeshFrame(msg.Message); err != nil {
+					h.logger.Errorf("Failed to encode binary mesh frame: %v", err)
+				}
+				encoded = true
+			}
+			if frame != nil {
+				message = frame
+			}
+		}
+		if _, overdue := client.enqueueUpdate(message); overdue {
+			slow = append(slow, client)
 		}
 	}
+	h.disconnectSlowClients(slow)
 }
 
 // BroadcastToSession sends a message to all clients in a session
 func (h *Hub) BroadcastToSession(sessionID string, message *api.WSMessage) error {
+	return h.BroadcastUpdate(sessionID, message, nil)
+}
+
+// BroadcastUpdate sends an update of the anchor at location to the clients
+// in a session whose region it falls in, and to those without a region. A
+// nil location sends it to every client.
+func (h *Hub) BroadcastUpdate(sessionID string, message *api.WSMessage, location *UpdateLocation) error {
 	data, err := json.Marshal(message)
 	if err != nil {
 		return err
 	}
 
-	h.broadcast <- BroadcastMessage{
-		SessionID: sessionID,
-		Message:   data,
+	select {
+	case h.broadcast <- BroadcastMessage{SessionID: sessionID, Message: data, Location: location}:
+	case <-h.done:
 	}
 
 	return nil
 }
 
+// Shutdown stops the hub, sends every client a close frame and waits for
+// their pumps to exit. Connections still open when ctx expires are closed.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.shutdownOnce.Do(func() { close(h.done) })
+
+	// Detach all clients so normal unregistration becomes a no-op
+	h.mu.Lock()
+	var clients []*Client
+	for sessionID, sessionClients := range h.clients {
+		for client := range sessionClients {
+			if client.sessionID == sessionID {
+				clients = append(clients, client)
+			}
+			h.metrics.WSConnectionsActive.WithLabelValues(h.metrics.SessionLabel(sessionID)).Dec()
+		}
+	}
+	h.clients = make(map[string]map[*Client]bool)
+	h.mu.Unlock()
+
+	h.logger.Infof("Closing %d WebSocket connections", len(clients))
+
+	closeFrame := websocket.FormatCloseMessage(websocket.CloseGoingAway, ErrHubClosed.Error())
+	for _, client := range clients {
+		client.closeSend(closeFrame)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		for _, client := range clients {
+			client.pumps.Wait()
+		}
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		for _, client := range clients {
+			client.conn.Close()
+		}
+		return ctx.Err()
+	}
+}
+
 // GetActiveConnections returns the number of active connections
 func (h *Hub) GetActiveConnections() int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
+	// Subscribed clients also appear under other sessions, so only count
+	// each connection under the session it connected with
 	count := 0
-	for _, clients := range h.clients {
-		count += len(clients)
+	for sessionID, clients := range h.clients {
+		for client := range clients {
+			if client.sessionID == sessionID {
+				count++
+			}
+		}
 	}
 	return count
 }
 
+// GetSessionConnections returns the number of clients connected or
+// subscribed to a session
+func (h *Hub) GetSessionConnections(sessionID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.clients[sessionID])
+}
+
 // NewClient creates a new WebSocket client
 func NewClient(hub *Hub, conn *websocket.Conn, sessionID string, logger logger.Logger) *Client {
-	return &Client{
+	if hub.compression {
+		if err := conn.SetCompressionLevel(hub.compressionLevel); err != nil {
+			logger.Warnf("Invalid WebSocket compression level: %v", err)
+		}
+	}
+
+	client := &Client{
 		hub:       hub,
 		conn:      conn,
 		sessionID: sessionID,
-		send:      make(chan []byte, 256),
+		send:      make(chan []byte, hub.sendBuffer+hub.sendOverflow),
 		logger:    logger,
+		binary:    hub.binarySubprotocol != "" && conn.Subprotocol() == hub.binarySubprotocol,
+
+		subscriptions: make(map[string]bool),
+	}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	client.lastMessageAt.Store(time.Now().UnixNano())
+	return client
+}
+
+// lastMessage returns when the client last sent a message, or connected
+func (c *Client) lastMessage() time.Time {
+	return time.Unix(0, c.lastMessageAt.Load())
+}
+
+// reject closes a client that was never registered, telling it why
+func (c *Client) reject(reason error) {
+	code := websocket.CloseTryAgainLater
+	if errors.Is(reason, ErrHubClosed) {
+		code = websocket.CloseGoingAway
 	}
+
+	frame := websocket.FormatCloseMessage(code, reason.Error())
+	if err := c.conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(time.Second)); err != nil {
+		c.logger.Debugf("Failed to send close frame: %v", err)
+	}
+	c.conn.Close()
+}
+
+// trySend queues data without blocking. It reports false if the send queue
+// is full or the client has been closed.
+func (c *Client) trySend(data []byte) bool {
+	queued, _ := c.enqueue(data)
+	return queued
+}
+
+// closeSend closes the send channel once, making WritePump write frame as
+// the close message and exit
+func (c *Client) closeSend(frame []byte) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeFrame = frame
+	close(c.send)
+}
+
+// SetReadOnly restricts the client to receiving updates. Must be called
+// before the client's pumps are started.
+func (c *Client) SetReadOnly(readOnly bool) {
+	c.readOnly = readOnly
+}
+
+// SetSessionFilter limits the sessions the client may subscribe to or send
+// updates for. Must be called before the client's pumps are started.
+func (c *Client) SetSessionFilter(allows func(sessionID string) bool) {
+	c.allows = allows
+}
+
+// SetActor records the client's updates as actor's in the audit log. Must be
+// called before the client's pumps are started.
+func (c *Client) SetActor(actor audit.Actor) {
+	c.ctx = audit.WithActor(c.ctx, actor)
+}
+
+// allowsSession reports whether the client may use sessionID
+func (c *Client) allowsSession(sessionID string) bool {
+	return c.allows == nil || c.allows(sessionID)
 }
 
 // ReadPump handles incoming messages from the WebSocket connection
 func (c *Client) ReadPump() {
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
+		c.cancel()
 		c.conn.Close()
+		c.pumps.Done()
 	}()
 
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.conn.SetReadLimit(c.hub.maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
 		return nil
 	})
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		messageType, message, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				// The connection has already been sent a message-too-big close frame
+				c.logger.Warnf("Closing connection after message over %d bytes", c.hub.maxMessageSize)
+				c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", "unknown", "rejected").Inc()
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				c.logger.Errorf("WebSocket error: %v", err)
 			}
 			break
 		}
+		c.lastMessageAt.Store(time.Now().UnixNano())
 
-		// Parse message
-		var wsMessage api.WSMessage
-		if err := json.Unmarshal(message, &wsMessage); err != nil {
+		// Parse message; binary frames carry mesh updates
+		wsMessage := &api.WSMessage{}
+		if messageType == websocket.BinaryMessage {
+			if wsMessage, err = c.parseMeshFrame(message); err != nil {
+				c.logger.Errorf("Failed to parse binary frame: %v", err)
+				c.sendError("INVALID_MESSAGE", err.Error())
+				continue
+			}
+		} else if err := json.Unmarshal(message, wsMessage); err != nil {
 			c.logger.Errorf("Failed to parse message: %v", err)
 			c.sendError("INVALID_MESSAGE", "Failed to parse message")
 			continue
@@ -233,10 +754,29 @@ func (c *Client) ReadPump() {
 		// Handle different message types
 		switch wsMessage.Type {
 		case api.WSTypePing:
-			c.handlePing(&wsMessage)
+			c.handlePing(wsMessage)
 
 		case api.WSTypeAnchorUpdate, api.WSTypeMeshUpdate:
-			c.handleDataUpdate(&wsMessage)
+			c.handleDataUpdate(wsMessage)
+
+		case api.WSTypeSubscribe:
+			sub, ok := c.subscribeRequest(wsMessage)
+			if !ok {
+				continue
+			}
+			select {
+			case c.hub.subscribe <- sub:
+			case <-c.hub.done:
+			}
+
+		case api.WSTypeUnsubscribe:
+			select {
+			case c.hub.unsubscribe <- subscription{client: c, sessionID: wsMessage.SessionID}:
+			case <-c.hub.done:
+			}
+
+		case api.WSTypeSnapshot:
+			c.handleSnapshot(wsMessage)
 
 		default:
 			c.logger.Warnf("Unknown message type: %s", wsMessage.Type)
@@ -245,34 +785,61 @@ func (c *Client) ReadPump() {
 	}
 }
 
+// parseMeshFrame decodes a binary frame as the mesh_update it carries. Only
+// connections that negotiated the binary subprotocol may send them.
+func (c *Client) parseMeshFrame(data []byte) (*api.WSMessage, error) {
+	if c.hub.binarySubprotocol == "" {
+		return nil, fmt.Errorf("binary frames are not enabled")
+	}
+	if !c.binary {
+		return nil, fmt.Errorf("binary frames require the %s subprotocol", c.hub.binarySubprotocol)
+	}
+	frame, err := DecodeMeshFrame(data)
+	if err != nil {
+		return nil, err
+	}
+	return meshFrameMessage(frame)
+}
+
 // WritePump handles sending messages to the WebSocket connection
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(c.hub.pingPeriod)
 	defer func() {
 		ticker.Stop()
+		c.cancel()
 		c.conn.Close()
+		c.pumps.Done()
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.writeWait))
 			if !ok {
 				// Hub closed the channel
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeFrame)
 				return
 			}
 
+			// Deflating small messages costs more than it saves. Control
+			// frames such as pings are never compressed.
+			c.conn.EnableWriteCompression(c.hub.compression && len(message) >= c.hub.compressionThreshold)
+
 			// Write message
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			messageType := websocket.TextMessage
+			if isMeshFrame(message) {
+				messageType = websocket.BinaryMessage
+			}
+			if err := c.conn.WriteMessage(messageType, message); err != nil {
 				return
 			}
 
-			// Record metric
+			// Record metrics
 			c.hub.metrics.WSMessagesTotal.WithLabelValues("outbound", "data", "sent").Inc()
+			c.hub.metrics.WSOutboundBytes.WithLabelValues("uncompressed").Add(float64(len(message)))
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -295,39 +862,141 @@ func (c *Client) handlePing(msg *api.WSMessage) {
 		return
 	}
 
-	select {
-	case c.send <- data:
-	default:
+	if !c.trySend(data) {
 		c.logger.Warn("Send buffer full, dropping pong")
 	}
 }
 
 // handleDataUpdate processes anchor and mesh updates
 func (c *Client) handleDataUpdate(msg *api.WSMessage) {
+	if c.readOnly {
+		c.sendError("FORBIDDEN", "API key does not allow write access")
+		c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "rejected").Inc()
+		return
+	}
+	if !c.allowsSession(msg.SessionID) {
+		c.sendError("FORBIDDEN", "token does not grant access to session "+msg.SessionID)
+		c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "rejected").Inc()
+		return
+	}
+
 	// Process the update
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := updateContext(c.ctx, msg)
 	defer cancel()
 
-	if err := c.hub.repository.ProcessWebSocketMessage(ctx, msg); err != nil {
-		c.logger.Errorf("Failed to process %s: %v", msg.Type, err)
-		c.sendError("PROCESSING_ERROR", err.Error())
+	ctx, span := tracing.Start(ctx, "WebSocket "+msg.Type,
+		tracing.SessionIDKey.String(msg.SessionID), tracing.OperationKey.String(msg.Type))
+	broadcast, err := c.hub.repository.ProcessWebSocketMessage(ctx, msg)
+	tracing.End(span, err)
+	if err != nil && c.ctx.Err() != nil {
+		// The client disconnected mid-update; there is no one to tell and
+		// nothing to retry on its behalf
+		logger.FromContext(ctx, c.logger).Debugf("Abandoned %s after client disconnected: %v", msg.Type, err)
+		c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "cancelled").Inc()
+		return
+	}
+	if err != nil {
+		logger.FromContext(ctx, c.logger).Errorf("Failed to process %s: %v", msg.Type, err)
+		if apiErr, ok := apierrors.IsAPIError(err); ok {
+			c.sendTracedError(apiErr.Code, apiErr.Message, msg.TraceID)
+		} else {
+			c.sendTracedError("PROCESSING_ERROR", err.Error(), msg.TraceID)
+		}
 		c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "error").Inc()
+		actor, _ := audit.ActorFrom(c.ctx)
+		c.hub.deadLetter(msg, actor, err)
 		return
 	}
 
 	c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "success").Inc()
 
-	// Broadcast to other clients in the session
-	data, _ := json.Marshal(msg)
-	c.hub.broadcast <- BroadcastMessage{
-		SessionID: c.sessionID,
-		Message:   data,
-		Exclude:   c,
+	if broadcast != nil {
+		c.broadcastUpdate(msg, broadcast)
+	}
+}
+
+// broadcastUpdate sends what an update changed to the other clients of the
+// session it was made in, which need not be the client's own
+func (c *Client) broadcastUpdate(msg *api.WSMessage, broadcast *api.WSMessage) {
+	data, _ := json.Marshal(broadcast)
+	select {
+	case c.hub.broadcast <- BroadcastMessage{SessionID: msg.SessionID, Message: data, Exclude: c, Location: locateUpdate(msg)}:
+	case <-c.hub.done:
+	}
+}
+
+// updateContext bounds the processing of an update under parent. A
+// client-supplied trace ID follows the update into storage logs, its spans
+// and the broadcast to other clients.
+func updateContext(parent context.Context, msg *api.WSMessage) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, 5*time.Second)
+	if msg.TraceID != "" {
+		ctx = logger.WithTraceID(ctx, msg.TraceID)
+		ctx = tracing.ContextWithTraceID(ctx, msg.TraceID)
+	}
+	return ctx, cancel
+}
+
+// subscribeRequest builds a subscription from a subscribe message and its
+// optional options, answering malformed or forbidden options with an error
+func (c *Client) subscribeRequest(msg *api.WSMessage) (subscription, bool) {
+	sub := subscription{client: c, sessionID: msg.SessionID}
+	if len(msg.Data) == 0 {
+		return sub, true
+	}
+
+	var options api.SubscribeOptions
+	if err := json.Unmarshal(msg.Data, &options); err != nil {
+		c.sendError("INVALID_MESSAGE", "Failed to parse subscribe options")
+		return sub, false
+	}
+	if options.CompressionLevel != nil && c.readOnly {
+		c.sendError("FORBIDDEN", "API key does not allow write access")
+		return sub, false
+	}
+	if options.Region != nil {
+		region, err := c.regionRequest(msg.SessionID, options.Region)
+		if err != nil {
+			if apiErr, ok := apierrors.IsAPIError(err); ok {
+				c.sendError(apiErr.Code, apiErr.Message)
+			} else {
+				c.sendError("INVALID_REGION", err.Error())
+			}
+			return sub, false
+		}
+		sub.region, sub.setRegion = region, true
+	}
+
+	sub.compressionLevel = options.CompressionLevel
+	return sub, true
+}
+
+// sendAck confirms a subscription change to the client
+func (c *Client) sendAck(msgType, sessionID string) {
+	ack := api.WSMessage{
+		Type:      msgType,
+		SessionID: sessionID,
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	data, err := json.Marshal(ack)
+	if err != nil {
+		c.logger.Errorf("Failed to marshal %s ack: %v", msgType, err)
+		return
+	}
+
+	if !c.trySend(data) {
+		c.logger.Warnf("Send buffer full, dropping %s ack", msgType)
 	}
 }
 
 // sendError sends an error message to the client
 func (c *Client) sendError(code, message string) {
+	c.sendTracedError(code, message, "")
+}
+
+// sendTracedError sends an error message answering the message with traceID
+func (c *Client) sendTracedError(code, message, traceID string) {
 	errorMsg := api.WSMessage{
 		Type:      api.WSTypeError,
 		SessionID: c.sessionID,
@@ -336,6 +1005,7 @@ func (c *Client) sendError(code, message string) {
 			Message: message,
 		}),
 		Timestamp: time.Now().UnixMilli(),
+		TraceID:   traceID,
 	}
 
 	data, err := json.Marshal(errorMsg)
@@ -344,10 +1014,9 @@ func (c *Client) sendError(code, message string) {
 		return
 	}
 
-	select {
-	case c.send <- data:
+	if c.trySend(data) {
 		c.hub.metrics.WSMessagesTotal.WithLabelValues("outbound", "error", "sent").Inc()
-	default:
+	} else {
 		c.logger.Warn("Send buffer full, dropping error message")
 	}
 }
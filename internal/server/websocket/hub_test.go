@@ -0,0 +1,415 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// Metrics shared by the package's tests
+var testMetrics = metrics.New(config.MetricsConfig{}, logger.New(logger.Config{}))
+
+// newTestServer serves WebSocket connections registered with hub
+func newTestServer(t *testing.T, hub *Hub) *httptest.Server {
+	upgrader := websocket.Upgrader{EnableCompression: hub.compression}
+	if hub.binarySubprotocol != "" {
+		upgrader.Subprotocols = []string{hub.binarySubprotocol}
+	}
+	log := logger.New(logger.Config{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(hub.MeterResponse(w), r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+
+		client := NewClient(hub, conn, r.URL.Query().Get("session_id"), log)
+		if err := hub.Register(client); err != nil {
+			return
+		}
+
+		go client.WritePump()
+		go client.ReadPump()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dial(t *testing.T, server *httptest.Server, sessionID string) *websocket.Conn {
+	return dialWith(t, websocket.DefaultDialer, server, sessionID)
+}
+
+func dialWith(t *testing.T, dialer *websocket.Dialer, server *httptest.Server, sessionID string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "?session_id=" + sessionID
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestHubShutdown(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{}, nil, logger.New(logger.Config{}), testMetrics)
+	go hub.Run()
+
+	server := newTestServer(t, hub)
+	conns := []*websocket.Conn{dial(t, server, "s1"), dial(t, server, "s1"), dial(t, server, "s2")}
+
+	waitFor(t, func() bool { return hub.GetActiveConnections() == len(conns) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- hub.Shutdown(ctx) }()
+
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := conn.ReadMessage()
+
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("Expected close frame, got %v", err)
+		}
+		if closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "server shutting down" {
+			t.Errorf("Unexpected close frame: %d %q", closeErr.Code, closeErr.Text)
+		}
+
+		// Complete the close handshake so the server's read pump exits
+		conn.Close()
+	}
+
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown did not drain: %v", err)
+	}
+	if active := hub.GetActiveConnections(); active != 0 {
+		t.Errorf("Expected no active connections, got %d", active)
+	}
+
+	// Late broadcasts must not block once the hub has stopped
+	if err := hub.BroadcastToSession("s1", nil); err != nil {
+		t.Errorf("Unexpected broadcast error: %v", err)
+	}
+}
+
+func TestHubRegisterAtCapacity(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{}, nil, logger.New(logger.Config{}), testMetrics)
+	hub.maxClientsPerSession = 1
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	server := newTestServer(t, hub)
+	dial(t, server, "full")
+	waitFor(t, func() bool { return hub.GetActiveConnections() == 1 })
+
+	rejected := dial(t, server, "full")
+	rejected.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := rejected.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("Expected close frame, got %v", err)
+	}
+	if closeErr.Code != websocket.CloseTryAgainLater || closeErr.Text != ErrSessionFull.Error() {
+		t.Errorf("Unexpected close frame: %d %q", closeErr.Code, closeErr.Text)
+	}
+
+	if active := hub.GetActiveConnections(); active != 1 {
+		t.Errorf("Expected rejected client not to be counted, got %d active", active)
+	}
+
+	// Other sessions are unaffected
+	dial(t, server, "other")
+	waitFor(t, func() bool { return hub.GetActiveConnections() == 2 })
+}
+
+func TestReadPumpMessageLimit(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{MaxMessageSize: 512}, nil, logger.New(logger.Config{}), testMetrics)
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	server := newTestServer(t, hub)
+	conn := dial(t, server, "limited")
+	waitFor(t, func() bool { return hub.GetActiveConnections() == 1 })
+
+	if err := conn.WriteMessage(websocket.TextMessage, make([]byte, 1024)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("Expected close frame, got %v", err)
+	}
+	if closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("Expected close code %d, got %d", websocket.CloseMessageTooBig, closeErr.Code)
+	}
+
+	waitFor(t, func() bool { return hub.GetActiveConnections() == 0 })
+}
+
+func TestWritePumpCompression(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{EnableCompression: true, CompressionLevel: 1, CompressionThreshold: 512}, nil, logger.New(logger.Config{}), testMetrics)
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	server := newTestServer(t, hub)
+	conn := dialWith(t, &websocket.Dialer{EnableCompression: true}, server, "compressed")
+	waitFor(t, func() bool { return hub.GetActiveConnections() == 1 })
+
+	uncompressed := testMetrics.WSOutboundBytes.WithLabelValues("uncompressed")
+	wire := testMetrics.WSOutboundBytes.WithLabelValues("wire")
+	payloadBefore, wireBefore := testutil.ToFloat64(uncompressed), testutil.ToFloat64(wire)
+
+	data, _ := json.Marshal(map[string]string{"vertices": strings.Repeat("AAAA", 4096)})
+	if err := hub.BroadcastToSession("compressed", &api.WSMessage{Type: api.WSTypeMeshUpdate, SessionID: "compressed", Data: data}); err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, received, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	var message api.WSMessage
+	if err := json.Unmarshal(received, &message); err != nil || !bytes.Equal(message.Data, data) {
+		t.Fatalf("Expected the broadcast message, got %q (%v)", received, err)
+	}
+
+	payload := testutil.ToFloat64(uncompressed) - payloadBefore
+	sent := testutil.ToFloat64(wire) - wireBefore
+	if payload != float64(len(received)) {
+		t.Errorf("Expected %d uncompressed bytes, got %v", len(received), payload)
+	}
+	if sent == 0 || sent >= payload/10 {
+		t.Errorf("Expected compressed wire bytes well under %v, got %v", payload, sent)
+	}
+}
+
+func TestHubDisconnectsIdleClients(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{IdleTimeout: 200 * time.Millisecond}, nil, logger.New(logger.Config{}), testMetrics)
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	server := newTestServer(t, hub)
+	silent := dial(t, server, "idle")
+	active := dial(t, server, "idle")
+	waitFor(t, func() bool { return hub.GetActiveConnections() == 2 })
+
+	idleBefore := testutil.ToFloat64(testMetrics.WSDisconnectsTotal.WithLabelValues("idle"))
+
+	// The active client keeps sending application messages
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := active.WriteJSON(api.WSMessage{Type: api.WSTypePing}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	silent.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := silent.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("Expected close frame, got %v", err)
+	}
+	if closeErr.Code != websocket.CloseNormalClosure || !strings.Contains(closeErr.Text, "idle timeout") {
+		t.Errorf("Unexpected close frame: %d %q", closeErr.Code, closeErr.Text)
+	}
+
+	waitFor(t, func() bool { return hub.GetActiveConnections() == 1 })
+	if got := testutil.ToFloat64(testMetrics.WSDisconnectsTotal.WithLabelValues("idle")) - idleBefore; got != 1 {
+		t.Errorf("Expected 1 idle disconnect, got %v", got)
+	}
+
+	// Well past the idle window, the active client is still connected
+	time.Sleep(400 * time.Millisecond)
+	if active := hub.GetActiveConnections(); active != 1 {
+		t.Errorf("Expected the active client to stay connected, got %d connections", active)
+	}
+}
+
+func TestHubDisconnectsUnresponsiveClients(t *testing.T) {
+	pongWait := 300 * time.Millisecond
+	hub := NewHub(config.WebSocketConfig{PongWait: pongWait, PingPeriod: 50 * time.Millisecond}, nil, logger.New(logger.Config{}), testMetrics)
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	server := newTestServer(t, hub)
+	responsive := dial(t, server, "liveness")
+	silent := dial(t, server, "liveness")
+	waitFor(t, func() bool { return hub.GetActiveConnections() == 2 })
+
+	// Reading answers pings with pongs, unless the ping handler is replaced
+	go func() {
+		for {
+			if _, _, err := responsive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	var pings atomic.Int32
+	silent.SetPingHandler(func(string) error {
+		pings.Add(1)
+		return nil
+	})
+
+	start := time.Now()
+	silent.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := silent.ReadMessage(); err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				t.Fatal("Expected the silent client disconnected within the pong wait")
+			}
+			break
+		}
+	}
+	if elapsed := time.Since(start); elapsed < pongWait-50*time.Millisecond {
+		t.Errorf("Expected a disconnect after the %v pong wait, got one after %v", pongWait, elapsed)
+	}
+	if pings.Load() < 2 {
+		t.Errorf("Expected pings every ping period, got %d", pings.Load())
+	}
+
+	// Well past the pong wait, the responsive client is still connected
+	waitFor(t, func() bool { return hub.GetActiveConnections() == 1 })
+	time.Sleep(2 * pongWait)
+	if active := hub.GetActiveConnections(); active != 1 {
+		t.Errorf("Expected the responsive client to stay connected, got %d connections", active)
+	}
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubscribeRequestOptions(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{}, nil, logger.New(logger.Config{}), testMetrics)
+	client := &Client{hub: hub, sessionID: "scan", send: make(chan []byte, 4), logger: logger.New(logger.Config{})}
+
+	sub, ok := client.subscribeRequest(&api.WSMessage{Type: api.WSTypeSubscribe, SessionID: "other"})
+	if !ok || sub.sessionID != "other" || sub.compressionLevel != nil {
+		t.Errorf("Expected a plain subscription, got %+v", sub)
+	}
+
+	sub, ok = client.subscribeRequest(&api.WSMessage{Type: api.WSTypeSubscribe, SessionID: "other", Data: json.RawMessage(`{"compression_level":6}`)})
+	if !ok || sub.compressionLevel == nil || *sub.compressionLevel != 6 {
+		t.Errorf("Expected compression level 6, got %+v", sub)
+	}
+
+	expectError := func(data, code string) {
+		t.Helper()
+		if _, ok := client.subscribeRequest(&api.WSMessage{Type: api.WSTypeSubscribe, SessionID: "other", Data: json.RawMessage(data)}); ok {
+			t.Errorf("Expected %s rejected", data)
+			return
+		}
+		var msg api.WSMessage
+		var errResp api.ErrorResponse
+		if err := json.Unmarshal(<-client.send, &msg); err != nil || json.Unmarshal(msg.Data, &errResp) != nil || errResp.Code != code {
+			t.Errorf("Expected a %s error for %s, got %s", code, data, msg.Data)
+		}
+	}
+
+	expectError(`{"compression_level":"high"}`, "INVALID_MESSAGE")
+	client.SetReadOnly(true)
+	expectError(`{"compression_level":6}`, "FORBIDDEN")
+}
+
+func TestClientDisconnectCancelsUpdates(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{}, nil, logger.New(logger.Config{}), testMetrics)
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	server := newTestServer(t, hub)
+	conn := dial(t, server, "cancelled")
+	waitFor(t, func() bool { return hub.GetSessionConnections("cancelled") == 1 })
+
+	var client *Client
+	hub.mu.RLock()
+	for registered := range hub.clients["cancelled"] {
+		client = registered
+	}
+	hub.mu.RUnlock()
+
+	// Simulate a database operation that outlasts the connection
+	ctx, cancel := updateContext(client.ctx, &api.WSMessage{Type: api.WSTypeAnchorUpdate})
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			result <- ctx.Err()
+		case <-time.After(5 * time.Second):
+			result <- nil
+		}
+	}()
+
+	conn.Close()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the operation cancelled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Operation was not cancelled by the disconnect")
+	}
+}
+
+func TestBroadcastUpdateToMessageSession(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{}, nil, logger.New(logger.Config{}), testMetrics)
+	sender := &Client{hub: hub, sessionID: "own", send: make(chan []byte, 4), logger: logger.New(logger.Config{})}
+
+	// An update to a subscribed session goes to that session's clients
+	msg := &api.WSMessage{Type: api.WSTypeAnchorUpdate, SessionID: "subscribed"}
+	go sender.broadcastUpdate(msg, &api.WSMessage{Type: api.WSTypeAnchorUpdate, SessionID: "subscribed"})
+
+	select {
+	case broadcast := <-hub.broadcast:
+		if broadcast.SessionID != "subscribed" || broadcast.Exclude != sender {
+			t.Errorf("Expected a broadcast to session subscribed excluding the sender, got session %q", broadcast.SessionID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the update broadcast")
+	}
+}
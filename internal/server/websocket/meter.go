@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MeterResponse wraps w so a connection upgraded from it counts the bytes it
+// writes to the network, after compression and framing
+func (h *Hub) MeterResponse(w http.ResponseWriter) http.ResponseWriter {
+	return &meteredResponseWriter{
+		ResponseWriter: w,
+		written:        h.metrics.WSOutboundBytes.WithLabelValues("wire"),
+	}
+}
+
+// meteredResponseWriter hands the upgrader a counting connection on hijack
+type meteredResponseWriter struct {
+	http.ResponseWriter
+	written prometheus.Counter
+}
+
+// Hijack implements http.Hijacker
+func (w *meteredResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not implement http.Hijacker")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &meteredConn{Conn: conn, written: w.written}, rw, nil
+}
+
+// meteredConn counts the bytes written to a connection
+type meteredConn struct {
+	net.Conn
+	written prometheus.Counter
+}
+
+// Write implements net.Conn
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	apierrors "github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Position is a point in session coordinates, in meters
+type Position struct {
+	X, Y, Z float64
+}
+
+// UpdateLocation names the anchor a broadcast update is about, so clients
+// subscribed with a region receive only updates inside it
+type UpdateLocation struct {
+	AnchorID string
+	Position *Position // Where the anchor now is, nil when the update does not say
+}
+
+// regionLookupTimeout bounds looking up the center anchor of a region
+const regionLookupTimeout = 5 * time.Second
+
+// poseLocation locates an update carrying an anchor's pose
+func poseLocation(anchorID string, pose api.PoseData) *UpdateLocation {
+	return &UpdateLocation{AnchorID: anchorID, Position: &Position{X: pose.X, Y: pose.Y, Z: pose.Z}}
+}
+
+// AnchorLocation locates an update of a stored anchor
+func AnchorLocation(anchor *api.Anchor) *UpdateLocation {
+	return &UpdateLocation{AnchorID: anchor.ID, Position: &Position{X: anchor.Pose.X, Y: anchor.Pose.Y, Z: anchor.Pose.Z}}
+}
+
+// MeshLocation locates an update of a mesh, placed by its anchor's last
+// broadcast position
+func MeshLocation(anchorID string) *UpdateLocation {
+	return &UpdateLocation{AnchorID: anchorID}
+}
+
+// locateUpdate locates an inbound anchor or mesh update. Mesh updates are
+// only scanned for their anchor ID.
+func locateUpdate(msg *api.WSMessage) *UpdateLocation {
+	switch msg.Type {
+	case api.WSTypeAnchorUpdate:
+		var update api.AnchorUpdate
+		if err := json.Unmarshal(msg.Data, &update); err != nil {
+			return nil
+		}
+		return poseLocation(update.ID, update.Pose)
+	case api.WSTypeMeshUpdate:
+		var update struct {
+			AnchorID string `json:"anchor_id"`
+		}
+		if err := json.Unmarshal(msg.Data, &update); err != nil {
+			return nil
+		}
+		return MeshLocation(update.AnchorID)
+	}
+	return nil
+}
+
+// regionFilter is the region a client receives a session's updates in:
+// a box, or a sphere around an anchor
+type regionFilter struct {
+	min, max Position // Box corners, when anchorID is ""
+
+	anchorID string
+	center   Position // Last known position of anchorID
+	radius   float64
+}
+
+// newRegionFilter validates a subscription's region. Anchor regions are
+// centered on center, the anchor's stored position.
+func newRegionFilter(region *api.RegionFilter, center Position) (*regionFilter, error) {
+	if region.AnchorID != "" {
+		if len(region.Min) > 0 || len(region.Max) > 0 {
+			return nil, fmt.Errorf("region takes either min and max or anchor_id and radius")
+		}
+		if !(region.Radius > 0) || math.IsInf(region.Radius, 0) {
+			return nil, fmt.Errorf("region radius must be positive")
+		}
+		return &regionFilter{anchorID: region.AnchorID, center: center, radius: region.Radius}, nil
+	}
+
+	if region.Radius != 0 {
+		return nil, fmt.Errorf("region radius requires anchor_id")
+	}
+	if len(region.Min) != 3 || len(region.Max) != 3 {
+		return nil, fmt.Errorf("region min and max must each be [x, y, z]")
+	}
+	filter := &regionFilter{
+		min: Position{X: region.Min[0], Y: region.Min[1], Z: region.Min[2]},
+		max: Position{X: region.Max[0], Y: region.Max[1], Z: region.Max[2]},
+	}
+	if filter.min.X > filter.max.X || filter.min.Y > filter.max.Y || filter.min.Z > filter.max.Z {
+		return nil, fmt.Errorf("region min must not exceed max")
+	}
+	return filter, nil
+}
+
+// contains reports whether a position is inside the region
+func (f *regionFilter) contains(p Position) bool {
+	if f.anchorID == "" {
+		return p.X >= f.min.X && p.X <= f.max.X &&
+			p.Y >= f.min.Y && p.Y <= f.max.Y &&
+			p.Z >= f.min.Z && p.Z <= f.max.Z
+	}
+	dx, dy, dz := p.X-f.center.X, p.Y-f.center.Y, p.Z-f.center.Z
+	return dx*dx+dy*dy+dz*dz <= f.radius*f.radius
+}
+
+// isEmptyRegion reports whether a region sets nothing, removing the filter
+func isEmptyRegion(region *api.RegionFilter) bool {
+	return len(region.Min) == 0 && len(region.Max) == 0 && region.AnchorID == "" && region.Radius == 0
+}
+
+// regionRequest builds the region filter a subscribe message asks for,
+// looking up the stored position of an anchor region's center
+func (c *Client) regionRequest(sessionID string, region *api.RegionFilter) (*regionFilter, error) {
+	if isEmptyRegion(region) {
+		return nil, nil
+	}
+
+	var center Position
+	if region.AnchorID != "" {
+		ctx, cancel := context.WithTimeout(c.ctx, regionLookupTimeout)
+		defer cancel()
+		response, err := c.hub.repository.Query(ctx, &api.QueryParams{SessionID: sessionID, AnchorID: region.AnchorID, Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(response.Anchors) == 0 {
+			return nil, apierrors.NotFound(fmt.Sprintf("anchor %s not found in session %s", region.AnchorID, sessionID))
+		}
+		pose := response.Anchors[0].Pose
+		center = Position{X: pose.X, Y: pose.Y, Z: pose.Z}
+	}
+	return newRegionFilter(region, center)
+}
+
+// locate returns where the anchor of a broadcast is, recording the position
+// the broadcast carries for later mesh updates, or nil if the broadcast is
+// not about an anchor or its position is unknown. Positions are only kept
+// for sessions with clients, and only touched by the Run goroutine. Callers
+// must hold h.mu for reading.
+func (h *Hub) locate(msg BroadcastMessage) *Position {
+	location := msg.Location
+	if location == nil || location.AnchorID == "" {
+		return nil
+	}
+	if location.Position == nil {
+		if position, ok := h.positions[msg.SessionID][location.AnchorID]; ok {
+			return &position
+		}
+		return nil
+	}
+
+	if len(h.clients[msg.SessionID]) > 0 {
+		if h.positions[msg.SessionID] == nil {
+			h.positions[msg.SessionID] = make(map[string]Position)
+		}
+		h.positions[msg.SessionID][location.AnchorID] = *location.Position
+	}
+	return location.Position
+}
+
+// inRegion reports whether a client receives an update of anchorID at
+// position in a session, moving the center of a region that follows the
+// anchor. Callers must hold h.mu for reading, on the Run goroutine.
+func (c *Client) inRegion(sessionID, anchorID string, position Position) bool {
+	filter := c.regions[sessionID]
+	if filter == nil {
+		return true
+	}
+	if filter.anchorID == anchorID {
+		filter.center = position
+		return true
+	}
+	return filter.contains(position)
+}
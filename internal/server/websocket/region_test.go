@@ -0,0 +1,160 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestNewRegionFilter(t *testing.T) {
+	valid := []*api.RegionFilter{
+		{Min: []float64{-1, -1, -1}, Max: []float64{1, 1, 1}},
+		{Min: []float64{0, 0, 0}, Max: []float64{0, 0, 0}},
+		{AnchorID: "a1", Radius: 2},
+	}
+	for _, region := range valid {
+		if _, err := newRegionFilter(region, Position{}); err != nil {
+			t.Errorf("Expected %+v accepted, got %v", region, err)
+		}
+	}
+
+	invalid := []*api.RegionFilter{
+		{Min: []float64{0, 0}, Max: []float64{1, 1, 1}},
+		{Min: []float64{1, 0, 0}, Max: []float64{0, 1, 1}},
+		{Min: []float64{0, 0, 0}, Max: []float64{1, 1, 1}, Radius: 1},
+		{AnchorID: "a1"},
+		{AnchorID: "a1", Radius: -1},
+		{AnchorID: "a1", Radius: 1, Min: []float64{0, 0, 0}, Max: []float64{1, 1, 1}},
+	}
+	for _, region := range invalid {
+		if _, err := newRegionFilter(region, Position{}); err == nil {
+			t.Errorf("Expected %+v rejected", region)
+		}
+	}
+}
+
+func TestRegionFilterContains(t *testing.T) {
+	box, _ := newRegionFilter(&api.RegionFilter{Min: []float64{0, 0, 0}, Max: []float64{2, 2, 2}}, Position{})
+	sphere, _ := newRegionFilter(&api.RegionFilter{AnchorID: "a1", Radius: 1}, Position{X: 5})
+
+	tests := []struct {
+		name   string
+		filter *regionFilter
+		p      Position
+		want   bool
+	}{
+		{"box inside", box, Position{1, 1, 1}, true},
+		{"box edge", box, Position{2, 0, 2}, true},
+		{"box outside", box, Position{1, 3, 1}, false},
+		{"sphere inside", sphere, Position{5.5, 0.5, 0}, true},
+		{"sphere outside", sphere, Position{4, 0, 0.5}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.contains(tt.p); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestSubscribeRequestRegion(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{}, nil, logger.New(logger.Config{}), testMetrics)
+	client := &Client{hub: hub, sessionID: "scan", send: make(chan []byte, 4), logger: logger.New(logger.Config{})}
+
+	sub, ok := client.subscribeRequest(&api.WSMessage{Type: api.WSTypeSubscribe, SessionID: "other", Data: json.RawMessage(`{"region":{"min":[0,0,0],"max":[1,1,1]}}`)})
+	if !ok || !sub.setRegion || sub.region == nil || sub.region.max.Y != 1 {
+		t.Errorf("Expected a box region, got %+v", sub)
+	}
+
+	sub, ok = client.subscribeRequest(&api.WSMessage{Type: api.WSTypeSubscribe, SessionID: "other", Data: json.RawMessage(`{"region":{}}`)})
+	if !ok || !sub.setRegion || sub.region != nil {
+		t.Errorf("Expected the region removed, got %+v", sub)
+	}
+
+	sub, ok = client.subscribeRequest(&api.WSMessage{Type: api.WSTypeSubscribe, SessionID: "other"})
+	if !ok || sub.setRegion {
+		t.Errorf("Expected the region left as it is, got %+v", sub)
+	}
+
+	if _, ok := client.subscribeRequest(&api.WSMessage{Type: api.WSTypeSubscribe, SessionID: "other", Data: json.RawMessage(`{"region":{"min":[1,1,1],"max":[0,0,0]}}`)}); ok {
+		t.Error("Expected an inverted box rejected")
+	}
+	var msg api.WSMessage
+	var errResp api.ErrorResponse
+	if err := json.Unmarshal(<-client.send, &msg); err != nil || json.Unmarshal(msg.Data, &errResp) != nil || errResp.Code != "INVALID_REGION" {
+		t.Errorf("Expected an INVALID_REGION error, got %s", msg.Data)
+	}
+}
+
+func TestBroadcastRegionFilter(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{}, nil, logger.New(logger.Config{}), testMetrics)
+	newClient := func() *Client {
+		return &Client{hub: hub, sessionID: "scan", send: make(chan []byte, 8), logger: logger.New(logger.Config{})}
+	}
+	everywhere, boxed, following := newClient(), newClient(), newClient()
+	hub.clients["scan"] = map[*Client]bool{everywhere: true, boxed: true, following: true}
+
+	box, _ := newRegionFilter(&api.RegionFilter{Min: []float64{0, 0, 0}, Max: []float64{1, 1, 1}}, Position{})
+	sphere, _ := newRegionFilter(&api.RegionFilter{AnchorID: "head", Radius: 1}, Position{})
+	hub.applySubscribeOptions(subscription{client: boxed, sessionID: "scan", region: box, setRegion: true})
+	hub.applySubscribeOptions(subscription{client: following, sessionID: "scan", region: sphere, setRegion: true})
+
+	received := func(c *Client) int {
+		n := len(c.send)
+		for len(c.send) > 0 {
+			<-c.send
+		}
+		return n
+	}
+	expect := func(step string, want ...int) {
+		t.Helper()
+		for i, c := range []*Client{everywhere, boxed, following} {
+			if got := received(c); got != want[i] {
+				t.Errorf("%s: expected client %d to receive %d, got %d", step, i, want[i], got)
+			}
+		}
+	}
+	broadcast := func(location *UpdateLocation) {
+		hub.broadcastMessage(BroadcastMessage{SessionID: "scan", Message: []byte(`{}`), Location: location})
+	}
+	at := func(anchorID string, x float64) *UpdateLocation {
+		return &UpdateLocation{AnchorID: anchorID, Position: &Position{X: x}}
+	}
+
+	filtered := testMetrics.WSMessagesTotal.WithLabelValues("outbound", "data", "filtered")
+	before := testutil.ToFloat64(filtered)
+
+	broadcast(at("a1", 0.5))
+	expect("anchor inside both regions", 1, 1, 1)
+	broadcast(at("a2", 5))
+	expect("anchor outside both regions", 1, 0, 0)
+	broadcast(nil)
+	expect("unlocated message", 1, 1, 1)
+
+	// Mesh updates are placed by their anchor's last broadcast position
+	broadcast(MeshLocation("a1"))
+	expect("mesh of an anchor inside", 1, 1, 1)
+	broadcast(MeshLocation("a2"))
+	expect("mesh of an anchor outside", 1, 0, 0)
+	broadcast(MeshLocation("unknown"))
+	expect("mesh of an unknown anchor", 1, 1, 1)
+
+	// The sphere follows its anchor
+	broadcast(at("head", 5))
+	expect("center anchor moved", 1, 0, 1)
+	broadcast(at("a2", 5.5))
+	expect("anchor near the moved center", 1, 0, 1)
+
+	if got := testutil.ToFloat64(filtered) - before; got != 6 {
+		t.Errorf("Expected 6 filtered sends counted, got %v", got)
+	}
+
+	// Updating the region replaces it, and an empty one removes it
+	hub.applySubscribeOptions(subscription{client: boxed, sessionID: "scan", setRegion: true})
+	broadcast(at("a2", 5))
+	expect("region removed", 1, 1, 1)
+}
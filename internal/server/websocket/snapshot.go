@@ -0,0 +1,216 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tabular/stag-v2/internal/tracing"
+	"github.com/tabular/stag-v2/pkg/api"
+	apierrors "github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// A snapshot answers a client's snapshot message with the session's anchors
+// in pages. Broadcasts to the client are held from before the anchors are
+// read until the last page is queued, then queued behind it. Updates are
+// broadcast once stored, so one the read missed is among those held: a client
+// may see an update twice, but never lose one.
+
+// Snapshot defaults for hubs configured without them, and the limits of a
+// single snapshot
+const (
+	defaultSnapshotPageSize   = 100
+	defaultSnapshotMaxAnchors = 10000
+	snapshotTimeout           = 30 * time.Second
+	snapshotPollInterval      = 10 * time.Millisecond
+)
+
+// errSnapshotOverrun aborts a snapshot during which more updates arrived
+// than the client's send queue holds
+var errSnapshotOverrun = errors.New("snapshot overrun")
+
+// snapshotSource reads the state a snapshot sends, implemented by
+// spatial.Repository
+type snapshotSource interface {
+	SessionState(ctx context.Context, sessionID string, limit int) ([]api.Anchor, bool, error)
+	AnchorMeshes(ctx context.Context, anchors []api.Anchor) ([]api.Mesh, error)
+}
+
+// handleSnapshot streams the state of the connection's own session, or one
+// it subscribed to, before the live updates that arrived meanwhile
+func (c *Client) handleSnapshot(msg *api.WSMessage) {
+	var options api.SnapshotRequest
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &options); err != nil {
+			c.sendError("INVALID_MESSAGE", "Failed to parse snapshot options")
+			return
+		}
+	}
+
+	c.hub.mu.RLock()
+	member := msg.SessionID == c.sessionID || c.subscriptions[msg.SessionID]
+	c.hub.mu.RUnlock()
+	if !member {
+		c.sendTracedError("NOT_SUBSCRIBED", fmt.Sprintf("not subscribed to session %s", msg.SessionID), msg.TraceID)
+		c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "rejected").Inc()
+		return
+	}
+	if c.hub.snapshots == nil {
+		c.sendTracedError("UNAVAILABLE", "snapshots are not available", msg.TraceID)
+		c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "error").Inc()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, snapshotTimeout)
+	defer cancel()
+	if msg.TraceID != "" {
+		ctx = logger.WithTraceID(ctx, msg.TraceID)
+		ctx = tracing.ContextWithTraceID(ctx, msg.TraceID)
+	}
+
+	c.holdUpdates()
+	ctx, span := tracing.Start(ctx, "WebSocket "+msg.Type,
+		tracing.SessionIDKey.String(msg.SessionID), tracing.OperationKey.String(msg.Type))
+	err := c.streamSnapshot(ctx, msg, options)
+	tracing.End(span, err)
+	overran, overdue := c.releaseUpdates()
+	if overdue {
+		c.hub.disconnectSlowClients([]*Client{c})
+	}
+
+	switch {
+	case err == nil:
+		c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "success").Inc()
+		return
+	case c.ctx.Err() != nil:
+		c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "cancelled").Inc()
+		return
+	case overran:
+		c.sendTracedError("SNAPSHOT_OVERRUN", "live updates overflowed the send queue during the snapshot; request it again", msg.TraceID)
+	default:
+		logger.FromContext(ctx, c.logger).Errorf("Failed to send snapshot of session %s: %v", msg.SessionID, err)
+		if apiErr, ok := apierrors.IsAPIError(err); ok {
+			c.sendTracedError(apiErr.Code, apiErr.Message, msg.TraceID)
+		} else {
+			c.sendTracedError("SNAPSHOT_FAILED", err.Error(), msg.TraceID)
+		}
+	}
+	c.hub.metrics.WSMessagesTotal.WithLabelValues("inbound", msg.Type, "error").Inc()
+}
+
+// streamSnapshot reads the session's anchors and queues them in pages, each
+// with its anchors' meshes if requested. An empty session is sent as one
+// empty page.
+func (c *Client) streamSnapshot(ctx context.Context, msg *api.WSMessage, options api.SnapshotRequest) error {
+	anchors, truncated, err := c.hub.snapshots.SessionState(ctx, msg.SessionID, c.hub.snapshotMaxAnchors)
+	if err != nil {
+		return err
+	}
+
+	pageSize := c.hub.snapshotPageSize
+	for page, start := 0, 0; page == 0 || start < len(anchors); page, start = page+1, start+pageSize {
+		end := min(start+pageSize, len(anchors))
+		snapshot := api.SnapshotPage{Page: page, Anchors: anchors[start:end]}
+		if options.IncludeMeshes {
+			if snapshot.Meshes, err = c.hub.snapshots.AnchorMeshes(ctx, snapshot.Anchors); err != nil {
+				return err
+			}
+		}
+		if end == len(anchors) {
+			snapshot.Done, snapshot.Total, snapshot.Truncated = true, len(anchors), truncated
+		}
+
+		data, err := json.Marshal(api.WSMessage{
+			Type:      api.WSTypeSnapshot,
+			SessionID: msg.SessionID,
+			Data:      mustMarshal(snapshot),
+			Timestamp: time.Now().UnixMilli(),
+			TraceID:   msg.TraceID,
+		})
+		if err != nil {
+			return err
+		}
+		if err := c.enqueuePaced(ctx, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueuePaced queues a snapshot page once the send queue is within the
+// hub's send buffer, so a large snapshot never overflows it
+func (c *Client) enqueuePaced(ctx context.Context, data []byte) error {
+	for {
+		c.sendMu.Lock()
+		switch {
+		case c.closed:
+			c.sendMu.Unlock()
+			return context.Canceled
+		case c.overran:
+			c.sendMu.Unlock()
+			return errSnapshotOverrun
+		case len(c.send) < c.hub.sendBuffer:
+			c.send <- data
+			c.sendMu.Unlock()
+			return nil
+		}
+		c.sendMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(snapshotPollInterval):
+		}
+	}
+}
+
+// holdUpdates starts holding the broadcasts queued for the client
+func (c *Client) holdUpdates() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.holding = true
+	c.overran = false
+}
+
+// enqueueUpdate queues a broadcast like enqueue, or holds it during a
+// snapshot. Holding more than the send queue's capacity drops the held
+// updates and stops holding, aborting the snapshot.
+func (c *Client) enqueueUpdate(data []byte) (queued, overdue bool) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.holding && !c.closed {
+		if len(c.held) < cap(c.send) {
+			c.held = append(c.held, data)
+			return true, false
+		}
+
+		c.logger.Warnf("Client of session %s received over %d updates during a snapshot", c.sessionID, len(c.held))
+		c.hub.metrics.WSDroppedMessages.WithLabelValues(c.hub.metrics.SessionSeries(c.sessionID)).Add(float64(len(c.held)))
+		c.holding, c.held, c.overran = false, nil, true
+	}
+	return c.enqueueLocked(data)
+}
+
+// releaseUpdates stops holding broadcasts and queues those held. overran
+// reports that some were dropped, and overdue that the client has been
+// overflowing for the slow consumer timeout.
+func (c *Client) releaseUpdates() (overran, overdue bool) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	held := c.held
+	overran = c.overran
+	c.holding, c.held, c.overran = false, nil, false
+
+	for _, data := range held {
+		if _, late := c.enqueueLocked(data); late {
+			overdue = true
+		}
+	}
+	return overran, overdue
+}
@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// fakeSnapshots serves a fixed session state, running read as it is read
+type fakeSnapshots struct {
+	anchors []api.Anchor
+	read    func()
+}
+
+func (f *fakeSnapshots) SessionState(ctx context.Context, sessionID string, limit int) ([]api.Anchor, bool, error) {
+	if f.read != nil {
+		f.read()
+	}
+	if len(f.anchors) > limit {
+		return f.anchors[:limit], true, nil
+	}
+	return f.anchors, false, nil
+}
+
+func (f *fakeSnapshots) AnchorMeshes(ctx context.Context, anchors []api.Anchor) ([]api.Mesh, error) {
+	meshes := make([]api.Mesh, len(anchors))
+	for i, anchor := range anchors {
+		meshes[i] = api.Mesh{ID: "mesh-" + anchor.ID, AnchorID: anchor.ID}
+	}
+	return meshes, nil
+}
+
+// drainMessages returns the messages queued for client
+func drainMessages(t *testing.T, client *Client) []api.WSMessage {
+	var messages []api.WSMessage
+	for len(client.send) > 0 {
+		var msg api.WSMessage
+		if err := json.Unmarshal(<-client.send, &msg); err != nil {
+			t.Fatalf("Failed to parse queued message: %v", err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func TestSnapshotHoldsUpdates(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{SendBuffer: 8, SnapshotPageSize: 2, SnapshotMaxAnchors: 5}, nil, logger.New(logger.Config{}), testMetrics)
+	client := NewClient(hub, nil, "snap", logger.New(logger.Config{}))
+	hub.clients["snap"] = map[*Client]bool{client: true}
+
+	source := &fakeSnapshots{}
+	for i := 0; i < 6; i++ {
+		source.anchors = append(source.anchors, api.Anchor{ID: fmt.Sprintf("a%d", i), SessionID: "snap"})
+	}
+	hub.snapshots = source
+
+	// An update stored while the session is read arrives after the snapshot
+	hub.broadcastMessage(BroadcastMessage{SessionID: "snap", Message: []byte(`{"type":"anchor_update","data":"before"}`)})
+	source.read = func() {
+		hub.broadcastMessage(BroadcastMessage{SessionID: "snap", Message: []byte(`{"type":"anchor_update","data":"during"}`)})
+	}
+	client.handleSnapshot(&api.WSMessage{Type: api.WSTypeSnapshot, SessionID: "snap", Data: json.RawMessage(`{"include_meshes":true}`)})
+
+	messages := drainMessages(t, client)
+	if len(messages) != 5 {
+		t.Fatalf("Expected an update, 3 pages and the held update, got %d messages", len(messages))
+	}
+	if string(messages[0].Data) != `"before"` || string(messages[4].Data) != `"during"` {
+		t.Errorf("Expected the held update after the snapshot, got %s first and %s last", messages[0].Data, messages[4].Data)
+	}
+
+	sent := 0
+	for i, msg := range messages[1:4] {
+		var page api.SnapshotPage
+		if msg.Type != api.WSTypeSnapshot || json.Unmarshal(msg.Data, &page) != nil {
+			t.Fatalf("Expected snapshot page %d, got %+v", i, msg)
+		}
+		if page.Page != i || len(page.Meshes) != len(page.Anchors) || page.Done != (i == 2) {
+			t.Errorf("Unexpected page %d: %+v", i, page)
+		}
+		sent += len(page.Anchors)
+		if page.Done && (page.Total != 5 || !page.Truncated) {
+			t.Errorf("Expected 5 anchors sent and the rest truncated, got %+v", page)
+		}
+	}
+	if sent != 5 {
+		t.Errorf("Expected 5 anchors across pages, got %d", sent)
+	}
+
+	// Without holding, updates are queued at once
+	hub.broadcastMessage(BroadcastMessage{SessionID: "snap", Message: []byte(`{"type":"anchor_update"}`)})
+	if len(client.send) != 1 {
+		t.Errorf("Expected the update queued after the snapshot, got %d queued", len(client.send))
+	}
+}
+
+func TestSnapshotOverrun(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{SendBuffer: 2, SendOverflow: 1}, nil, logger.New(logger.Config{}), testMetrics)
+	client := NewClient(hub, nil, "overrun", logger.New(logger.Config{}))
+	hub.clients["overrun"] = map[*Client]bool{client: true}
+	hub.snapshots = &fakeSnapshots{
+		anchors: []api.Anchor{{ID: "a1", SessionID: "overrun"}},
+		read: func() {
+			for i := 0; i < 4; i++ {
+				hub.broadcastMessage(BroadcastMessage{SessionID: "overrun", Message: []byte(`{"type":"anchor_update"}`)})
+			}
+		},
+	}
+
+	client.handleSnapshot(&api.WSMessage{Type: api.WSTypeSnapshot, SessionID: "overrun"})
+
+	messages := drainMessages(t, client)
+	last := messages[len(messages)-1]
+	var errResp api.ErrorResponse
+	if last.Type != api.WSTypeError || json.Unmarshal(last.Data, &errResp) != nil || errResp.Code != "SNAPSHOT_OVERRUN" {
+		t.Fatalf("Expected a SNAPSHOT_OVERRUN error, got %+v", last)
+	}
+	for _, msg := range messages {
+		if msg.Type == api.WSTypeSnapshot {
+			t.Errorf("Expected no page sent after an overrun, got %s", msg.Data)
+		}
+	}
+	if client.holding || client.overran || client.held != nil {
+		t.Error("Expected the client to stop holding updates")
+	}
+}
+
+func TestSnapshotRequiresSubscription(t *testing.T) {
+	hub := NewHub(config.WebSocketConfig{}, nil, logger.New(logger.Config{}), testMetrics)
+	client := NewClient(hub, nil, "own", logger.New(logger.Config{}))
+	hub.clients["own"] = map[*Client]bool{client: true}
+	hub.snapshots = &fakeSnapshots{}
+
+	client.handleSnapshot(&api.WSMessage{Type: api.WSTypeSnapshot, SessionID: "other"})
+	client.handleSnapshot(&api.WSMessage{Type: api.WSTypeSnapshot, SessionID: "own"})
+
+	messages := drainMessages(t, client)
+	var errResp api.ErrorResponse
+	if len(messages) != 2 || json.Unmarshal(messages[0].Data, &errResp) != nil || errResp.Code != "NOT_SUBSCRIBED" {
+		t.Fatalf("Expected another session's snapshot refused, got %+v", messages)
+	}
+
+	var page api.SnapshotPage
+	if messages[1].Type != api.WSTypeSnapshot || json.Unmarshal(messages[1].Data, &page) != nil || !page.Done || len(page.Anchors) != 0 {
+		t.Errorf("Expected an empty session sent as one empty page, got %s", messages[1].Data)
+	}
+}
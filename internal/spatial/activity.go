@@ -0,0 +1,194 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Activity bucket bounds
+const (
+	defaultActivityBucket = time.Minute
+	minActivityBucket     = time.Second
+	maxActivityBucket     = 7 * 24 * time.Hour
+	maxActivityBuckets    = 10000 // Upper bound on buckets a query range may span
+)
+
+// activityRange is a validated activity query range in Unix milliseconds
+type activityRange struct {
+	bucket int64
+	since  int64
+	until  int64
+}
+
+// parseActivityBucket parses a bucket width such as 30s, 5m, 1h or 1d
+func parseActivityBucket(raw string) (time.Duration, error) {
+	if raw == "" {
+		return defaultActivityBucket, nil
+	}
+
+	var bucket time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		// time.ParseDuration has no day unit; bound n before scaling it
+		var n int
+		if n, err = strconv.Atoi(days); err == nil && n > int(maxActivityBucket/(24*time.Hour)) {
+			n = -1
+		}
+		bucket = time.Duration(n) * 24 * time.Hour
+	} else {
+		bucket, err = time.ParseDuration(raw)
+	}
+	if err != nil {
+		return 0, errors.ValidationError(fmt.Sprintf("invalid bucket %q", raw))
+	}
+
+	if bucket < minActivityBucket || bucket > maxActivityBucket {
+		return 0, errors.ValidationError(fmt.Sprintf("bucket must be between %s and %s", minActivityBucket, maxActivityBucket))
+	}
+	if bucket%time.Second != 0 {
+		return 0, errors.ValidationError("bucket must be a whole number of seconds")
+	}
+	return bucket, nil
+}
+
+// newActivityRange validates the query range, which must be bounded and span
+// at most maxActivityBuckets buckets
+func newActivityRange(params *api.ActivityParams, now time.Time) (*activityRange, error) {
+	bucket, err := parseActivityBucket(params.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Since <= 0 {
+		return nil, errors.ValidationError("since is required")
+	}
+	until := params.Until
+	if until == 0 {
+		until = now.UnixMilli()
+	}
+	if until <= params.Since {
+		return nil, errors.ValidationError("until must be after since")
+	}
+
+	r := &activityRange{bucket: bucket.Milliseconds(), since: params.Since, until: until}
+	if buckets := (until - params.Since + r.bucket - 1) / r.bucket; buckets > maxActivityBuckets {
+		return nil, errors.ValidationError(fmt.Sprintf("range spans %d buckets, at most %d are allowed", buckets, maxActivityBuckets))
+	}
+	return r, nil
+}
+
+// SessionActivity counts a session's anchors and meshes per time bucket.
+// Buckets are aligned to the Unix epoch, so consecutive queries line up.
+func (r *Repository) SessionActivity(ctx context.Context, sessionID string, params *api.ActivityParams) (*api.ActivityResponse, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("query", "activity").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	activity, err := newActivityRange(params, startTime)
+	if err != nil {
+		return nil, err
+	}
+
+	query, bindVars := buildActivityQuery(sessionID, activity)
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("query", "activity", "error").Inc()
+		return nil, databaseError("failed to query session activity", err)
+	}
+	defer cursor.Close()
+
+	var counts struct {
+		Anchors []activityCount `json:"anchors"`
+		Meshes  []activityCount `json:"meshes"`
+	}
+	if _, err := cursor.ReadDocument(ctx, &counts); err != nil && !driver.IsNoMoreDocuments(err) {
+		return nil, databaseError("failed to read session activity", err)
+	}
+
+	r.metrics.DBOperationsTotal.WithLabelValues("query", "activity", "success").Inc()
+	return &api.ActivityResponse{
+		SessionID: sessionID,
+		BucketMs:  activity.bucket,
+		Since:     activity.since,
+		Until:     activity.until,
+		Series:    mergeActivity(counts.Anchors, counts.Meshes),
+	}, nil
+}
+
+// activityCount is the document count of one bucket
+type activityCount struct {
+	Bucket int64 `json:"bucket"` // Bucket start, Unix milliseconds
+	Count  int   `json:"count"`
+}
+
+// buildActivityQuery groups anchors and meshes into time buckets. Anchors
+// are range-scanned on idx_timestamp and meshes found by session.
+func buildActivityQuery(sessionID string, activity *activityRange) (string, map[string]interface{}) {
+	query := `LET anchors = (
+	FOR a IN @@anchors OPTIONS { indexHint: "idx_timestamp" }
+	FILTER a.timestamp >= @since AND a.timestamp < @until
+	FILTER a.session_id == @session_id
+	COLLECT bucket = FLOOR(a.timestamp / @bucket) * @bucket WITH COUNT INTO count
+	RETURN { bucket, count }
+)
+LET meshes = (
+	FOR m IN @@meshes
+	FILTER m.session_id == @session_id
+	FILTER m.timestamp >= @since AND m.timestamp < @until
+	COLLECT bucket = FLOOR(m.timestamp / @bucket) * @bucket WITH COUNT INTO count
+	RETURN { bucket, count }
+)
+RETURN { anchors, meshes }`
+
+	bindVars := map[string]interface{}{
+		"@anchors":   database.AnchorsCollection,
+		"@meshes":    database.MeshesCollection,
+		"session_id": sessionID,
+		"since":      activity.since,
+		"until":      activity.until,
+		"bucket":     activity.bucket,
+	}
+	return query, bindVars
+}
+
+// mergeActivity combines anchor and mesh bucket counts into one series
+// ordered by time
+func mergeActivity(anchors, meshes []activityCount) []api.ActivityBucket {
+	buckets := make(map[int64]*api.ActivityBucket)
+	get := func(timestamp int64) *api.ActivityBucket {
+		bucket, ok := buckets[timestamp]
+		if !ok {
+			bucket = &api.ActivityBucket{Timestamp: timestamp}
+			buckets[timestamp] = bucket
+		}
+		return bucket
+	}
+
+	for _, count := range anchors {
+		get(count.Bucket).AnchorCount += count.Count
+	}
+	for _, count := range meshes {
+		get(count.Bucket).MeshCount += count.Count
+	}
+
+	series := make([]api.ActivityBucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		series = append(series, *bucket)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Timestamp < series[j].Timestamp
+	})
+	return series
+}
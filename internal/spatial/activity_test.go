@@ -0,0 +1,89 @@
+package spatial
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func TestParseActivityBucket(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", time.Minute, false},
+		{"60s", time.Minute, false},
+		{"15m", 15 * time.Minute, false},
+		{"1d", 24 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"8d", 0, true},
+		{"500ms", 0, true},
+		{"1500ms", 0, true},
+		{"-1d", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseActivityBucket(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %s", tt.raw, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseActivityBucket(%q) = %s, %v; want %s", tt.raw, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewActivityRange(t *testing.T) {
+	now := time.UnixMilli(10 * 3600 * 1000)
+
+	activity, err := newActivityRange(&api.ActivityParams{Since: now.Add(-time.Hour).UnixMilli()}, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if activity.until != now.UnixMilli() || activity.bucket != 60000 {
+		t.Errorf("Expected until to default to now with 60s buckets, got %+v", activity)
+	}
+
+	for name, params := range map[string]*api.ActivityParams{
+		"MissingSince":   {},
+		"Inverted":       {Since: 2000, Until: 1000},
+		"TooManyBuckets": {Bucket: "1s", Since: 1, Until: 2 * maxActivityBuckets * 1000},
+	} {
+		if _, err := newActivityRange(params, now); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestMergeActivity(t *testing.T) {
+	series := mergeActivity(
+		[]activityCount{{Bucket: 120000, Count: 2}, {Bucket: 0, Count: 1}},
+		[]activityCount{{Bucket: 60000, Count: 4}, {Bucket: 120000, Count: 3}},
+	)
+
+	want := []api.ActivityBucket{
+		{Timestamp: 0, AnchorCount: 1},
+		{Timestamp: 60000, MeshCount: 4},
+		{Timestamp: 120000, AnchorCount: 2, MeshCount: 3},
+	}
+	if len(series) != len(want) {
+		t.Fatalf("Expected %d buckets, got %+v", len(want), series)
+	}
+	for i := range want {
+		if series[i] != want[i] {
+			t.Errorf("Bucket %d: expected %+v, got %+v", i, want[i], series[i])
+		}
+	}
+
+	if empty := mergeActivity(nil, nil); empty == nil || len(empty) != 0 {
+		t.Errorf("Expected an empty, non-nil series, got %#v", empty)
+	}
+}
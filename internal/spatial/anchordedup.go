@@ -0,0 +1,264 @@
+package spatial
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// anchorKey identifies an anchor within its session
+type anchorKey struct {
+	sessionID string
+	anchorID  string
+}
+
+// storedPose is the last pose stored for an anchor, with the metadata its
+// updates have set
+type storedPose struct {
+	pose     api.Pose
+	metadata map[string]interface{}
+	storedAt time.Time
+}
+
+// anchorDedup suppresses anchor updates that repeat the last pose stored for
+// their anchor, as headsets keep sending anchors that have not moved. Poses
+// are held in memory by each instance, so an anchor's first update after a
+// restart, or on another instance, is always stored.
+type anchorDedup struct {
+	epsilon         float64       // Largest position change in meters treated as unchanged
+	rotationEpsilon float64       // Largest rotation component change treated as unchanged
+	ttl             time.Duration // 0 keeps poses until their session is deleted
+	maxAnchors      int           // 0 is unlimited
+
+	mu    sync.Mutex
+	poses map[anchorKey]storedPose
+}
+
+// newAnchorDedup creates a dedup tracker, or returns nil if it is disabled
+func newAnchorDedup(cfg config.AnchorDedupConfig) *anchorDedup {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &anchorDedup{
+		epsilon:         cfg.Epsilon,
+		rotationEpsilon: cfg.RotationEpsilon,
+		ttl:             cfg.TTL,
+		maxAnchors:      cfg.MaxAnchors,
+		poses:           make(map[anchorKey]storedPose),
+	}
+}
+
+// filter drops anchors repeating the last pose stored for them from events,
+// returning how many were dropped per session. Anchors are also compared to
+// those kept before them, so an anchor repeated within a batch is stored once.
+func (d *anchorDedup) filter(events []api.SpatialEvent) map[string]int {
+	suppressed := make(map[string]int)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending := make(map[anchorKey]*api.Anchor)
+	for i := range events {
+		event := &events[i]
+		var kept []api.Anchor
+		for j := range event.Anchors {
+			anchor := &event.Anchors[j]
+			key := anchorKey{sessionID: anchor.SessionID, anchorID: anchor.ID}
+
+			var duplicate bool
+			if previous, ok := pending[key]; ok {
+				duplicate = d.unchanged(previous.Pose, previous.Metadata, anchor)
+			} else if stored, ok := d.poses[key]; ok {
+				duplicate = d.unchanged(stored.pose, stored.metadata, anchor)
+			}
+
+			if duplicate {
+				suppressed[anchor.SessionID]++
+				if kept == nil {
+					kept = append(make([]api.Anchor, 0, len(event.Anchors)), event.Anchors[:j]...)
+				}
+				continue
+			}
+			pending[key] = anchor
+			if kept != nil {
+				kept = append(kept, *anchor)
+			}
+		}
+		if kept != nil {
+			event.Anchors = kept
+		}
+	}
+	return suppressed
+}
+
+// unchanged reports whether anchor repeats a pose and sets no metadata that
+// differs from what was stored with it
+func (d *anchorDedup) unchanged(pose api.Pose, metadata map[string]interface{}, anchor *api.Anchor) bool {
+	dx, dy, dz := anchor.Pose.X-pose.X, anchor.Pose.Y-pose.Y, anchor.Pose.Z-pose.Z
+	if math.Sqrt(dx*dx+dy*dy+dz*dz) > d.epsilon {
+		return false
+	}
+	if !rotationsEqual(pose.Rotation, anchor.Pose.Rotation, d.rotationEpsilon) {
+		return false
+	}
+	for key, value := range anchor.Metadata {
+		if stored, ok := metadata[key]; !ok || !metadataValueEqual(stored, value, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// accept records the poses of the stored anchors of events, merging their
+// metadata into what was stored before as ingest does
+func (d *anchorDedup) accept(events []api.SpatialEvent, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range events {
+		for j := range events[i].Anchors {
+			anchor := &events[i].Anchors[j]
+			key := anchorKey{sessionID: anchor.SessionID, anchorID: anchor.ID}
+
+			entry, ok := d.poses[key]
+			if !ok && d.maxAnchors > 0 && len(d.poses) >= d.maxAnchors {
+				d.evictOldest()
+			}
+			entry.pose = anchor.Pose
+			if len(anchor.Metadata) > 0 {
+				merged := make(map[string]interface{}, len(entry.metadata)+len(anchor.Metadata))
+				for key, value := range entry.metadata {
+					merged[key] = value
+				}
+				for key, value := range anchor.Metadata {
+					merged[key] = value
+				}
+				entry.metadata = merged
+			}
+			entry.storedAt = now
+			d.poses[key] = entry
+		}
+	}
+}
+
+// evictOldest forgets the least recently stored pose. It scans every pose,
+// but only runs when a new anchor arrives with the tracker full.
+func (d *anchorDedup) evictOldest() {
+	var oldest anchorKey
+	var oldestAt time.Time
+	found := false
+	for key, entry := range d.poses {
+		if !found || entry.storedAt.Before(oldestAt) {
+			oldest, oldestAt, found = key, entry.storedAt, true
+		}
+	}
+	if found {
+		delete(d.poses, oldest)
+	}
+}
+
+// forget drops the poses of a session's anchors
+func (d *anchorDedup) forget(sessionID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key := range d.poses {
+		if key.sessionID == sessionID {
+			delete(d.poses, key)
+		}
+	}
+}
+
+// forgetAnchor drops the pose of one anchor
+func (d *anchorDedup) forgetAnchor(sessionID, anchorID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.poses, anchorKey{sessionID: sessionID, anchorID: anchorID})
+}
+
+// forgetAll drops every pose
+func (d *anchorDedup) forgetAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	clear(d.poses)
+}
+
+// evictIdle forgets poses of anchors not stored since ttl before now
+func (d *anchorDedup) evictIdle(now time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	evicted := 0
+	for key, entry := range d.poses {
+		if now.Sub(entry.storedAt) > d.ttl {
+			delete(d.poses, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// dedupeAnchors drops anchors repeating the last pose stored for them from
+// events about to be stored, so they are neither stored nor broadcast.
+// Backfill requests and repositories without anchor dedup keep every anchor.
+func (r *Repository) dedupeAnchors(ctx context.Context, events []api.SpatialEvent) {
+	if r.anchorDedup == nil || isBackfill(ctx) {
+		return
+	}
+	for sessionID, count := range r.anchorDedup.filter(events) {
+		r.metrics.AnchorsSuppressed.WithLabelValues(r.metrics.SessionSeries(sessionID)).Add(float64(count))
+		r.log(ctx).Debugf("Suppressed %d unchanged anchor poses for session %s", count, sessionID)
+	}
+}
+
+// acceptAnchorPoses records the poses of stored anchors. Backfilled anchors
+// may be older than those stored, so they are not recorded.
+func (r *Repository) acceptAnchorPoses(ctx context.Context, events []api.SpatialEvent) {
+	if r.anchorDedup == nil || isBackfill(ctx) {
+		return
+	}
+	r.anchorDedup.accept(events, time.Now())
+}
+
+// forgetAnchorPoses drops the poses recorded for anchors changed other than
+// by ingest, so the next update of each is compared to nothing and stored.
+// An empty sessionID drops the poses of every session.
+func (r *Repository) forgetAnchorPoses(sessionID string, anchorIDs ...string) {
+	switch {
+	case r.anchorDedup == nil:
+	case sessionID == "":
+		r.anchorDedup.forgetAll()
+	case len(anchorIDs) == 0:
+		r.anchorDedup.forget(sessionID)
+	default:
+		for _, anchorID := range anchorIDs {
+			r.anchorDedup.forgetAnchor(sessionID, anchorID)
+		}
+	}
+}
+
+// runAnchorDedupJanitor periodically forgets the poses of anchors not
+// stored for ttl
+func (r *Repository) runAnchorDedupJanitor(ttl time.Duration) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if evicted := r.anchorDedup.evictIdle(now); evicted > 0 {
+				r.logger.Debugf("Forgot the stored poses of %d idle anchors", evicted)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package spatial
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestAnchorDedup(t *testing.T) {
+	now := time.Now()
+	dedup := newAnchorDedup(config.AnchorDedupConfig{
+		Enabled: true, Epsilon: 0.01, RotationEpsilon: 0.001, TTL: time.Hour, MaxAnchors: 2,
+	})
+	anchor := func(id string, x float64, metadata map[string]interface{}) api.Anchor {
+		return api.Anchor{ID: id, SessionID: "s1", Pose: api.Pose{X: x, Rotation: []float64{0, 0, 0, 1}}, Metadata: metadata}
+	}
+
+	if newAnchorDedup(config.AnchorDedupConfig{Epsilon: 0.01}) != nil {
+		t.Error("Expected no dedup when disabled")
+	}
+
+	// Nothing is suppressed before a pose is stored, except repeats within the batch
+	events := []api.SpatialEvent{
+		{Anchors: []api.Anchor{anchor("a1", 1, nil), anchor("a2", 2, nil)}},
+		{Anchors: []api.Anchor{anchor("a1", 1.005, nil), anchor("a2", 2.5, nil)}},
+	}
+	if suppressed := dedup.filter(events); suppressed["s1"] != 1 {
+		t.Errorf("Expected 1 repeat within the batch suppressed, got %v", suppressed)
+	}
+	if len(events[0].Anchors) != 2 || len(events[1].Anchors) != 1 || events[1].Anchors[0].ID != "a2" {
+		t.Fatalf("Expected the repeated a1 dropped, got %+v", events)
+	}
+	dedup.accept(events, now)
+
+	// Poses within epsilon of the stored pose are suppressed, but not new metadata
+	events = []api.SpatialEvent{{Anchors: []api.Anchor{
+		anchor("a1", 1.009, nil),
+		anchor("a2", 2.5, map[string]interface{}{"label": "door"}),
+		anchor("a2", 2.52, nil),
+	}}}
+	dedup.filter(events)
+	if len(events[0].Anchors) != 2 || events[0].Anchors[0].Metadata["label"] != "door" || events[0].Anchors[1].Pose.X != 2.52 {
+		t.Errorf("Expected a2's metadata change and move kept, got %+v", events[0].Anchors)
+	}
+	dedup.accept(events, now.Add(time.Second))
+
+	// Metadata already stored, and rotations within epsilon, are unchanged
+	repeat := anchor("a2", 2.52, map[string]interface{}{"label": "door"})
+	repeat.Pose.Rotation = []float64{0, 0, 0.0005, 1}
+	events = []api.SpatialEvent{{Anchors: []api.Anchor{repeat}}}
+	if suppressed := dedup.filter(events); suppressed["s1"] != 1 || len(events[0].Anchors) != 0 {
+		t.Errorf("Expected the repeated pose and metadata suppressed, got %v", events[0].Anchors)
+	}
+
+	// A full tracker evicts the least recently stored pose
+	dedup.accept([]api.SpatialEvent{{Anchors: []api.Anchor{anchor("a3", 3, nil)}}}, now.Add(time.Minute))
+	if _, ok := dedup.poses[anchorKey{"s1", "a1"}]; ok || len(dedup.poses) != 2 {
+		t.Errorf("Expected a1 evicted, got %v", dedup.poses)
+	}
+
+	// Idle anchors and deleted sessions are forgotten
+	if evicted := dedup.evictIdle(now.Add(time.Hour + 30*time.Second)); evicted != 1 {
+		t.Errorf("Expected 1 idle anchor evicted, got %d", evicted)
+	}
+	dedup.forget("s1")
+	if len(dedup.poses) != 0 {
+		t.Errorf("Expected no poses left, got %v", dedup.poses)
+	}
+}
+
+func TestDedupeAnchorsBackfill(t *testing.T) {
+	repo := &Repository{
+		logger:      logger.New(logger.Config{}),
+		anchorDedup: newAnchorDedup(config.AnchorDedupConfig{Enabled: true, Epsilon: 0.01}),
+		metrics: &metrics.Metrics{
+			AnchorsSuppressed: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "suppressed"}, []string{"session_id"}),
+		},
+	}
+	events := func() []api.SpatialEvent {
+		return []api.SpatialEvent{{SessionID: "s1", Anchors: []api.Anchor{{ID: "a1", SessionID: "s1"}}}}
+	}
+
+	// Backfilled anchors are neither deduplicated nor recorded
+	backfill := WithBackfill(context.Background())
+	repo.acceptAnchorPoses(backfill, events())
+	if len(repo.anchorDedup.poses) != 0 {
+		t.Errorf("Expected backfilled poses not recorded, got %v", repo.anchorDedup.poses)
+	}
+
+	repo.acceptAnchorPoses(context.Background(), events())
+	repeated := events()
+	repo.dedupeAnchors(backfill, repeated)
+	if len(repeated[0].Anchors) != 1 {
+		t.Error("Expected backfill to keep a repeated pose")
+	}
+	repo.dedupeAnchors(context.Background(), repeated)
+	if len(repeated[0].Anchors) != 0 {
+		t.Error("Expected a repeated pose suppressed")
+	}
+	if got := testutil.ToFloat64(repo.metrics.AnchorsSuppressed.WithLabelValues("s1")); got != 1 {
+		t.Errorf("Expected 1 suppressed update counted, got %v", got)
+	}
+}
+
+func TestUpdateAnchorForgetsPose(t *testing.T) {
+	repo, _ := fakeArangoRepository(t, 0, func(fakeQuery) int { return 0 })
+	repo.anchorDedup = newAnchorDedup(config.AnchorDedupConfig{Enabled: true, Epsilon: 0.01})
+	ingest := func(x float64) *api.SpatialEvent {
+		event := &api.SpatialEvent{SessionID: "s1", EventID: "e1", Timestamp: 1000, Anchors: []api.Anchor{
+			{ID: "a1", SessionID: "s1", Pose: api.Pose{X: x, Rotation: []float64{0, 0, 0, 1}}},
+		}}
+		if err := repo.Ingest(context.Background(), event); err != nil {
+			t.Fatalf("Failed to ingest: %v", err)
+		}
+		return event
+	}
+
+	ingest(1)
+	update := &api.AnchorUpdate{ID: "a1", Pose: api.PoseData{X: 2, Rotation: []float64{0, 0, 0, 1}}}
+	if _, err := repo.UpdateAnchor(context.Background(), "s1", update); err != nil {
+		t.Fatalf("Failed to update anchor: %v", err)
+	}
+
+	// The stored pose is now the update's, so the first pose is a change
+	if event := ingest(1); len(event.Anchors) != 1 {
+		t.Error("Expected the first pose stored again after an update")
+	}
+	if event := ingest(1); len(event.Anchors) != 0 {
+		t.Error("Expected a repeat of the ingested pose suppressed")
+	}
+
+	// A restore forgets the poses of the sessions it restored
+	repo.forgetAnchorPoses("")
+	if event := ingest(1); len(event.Anchors) != 1 {
+		t.Error("Expected the pose stored again after a restore")
+	}
+}
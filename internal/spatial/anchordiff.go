@@ -0,0 +1,92 @@
+package spatial
+
+import (
+	"math"
+	"reflect"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// diffAnchor returns the fields of current that differ from previous, the
+// anchor as stored before the update, or nil if none do. Numbers within
+// tolerance of the stored value count as unchanged. Updates merge metadata
+// into the stored anchor, so only keys that current sets are compared. A new
+// anchor, with a nil previous, differs in every field.
+func diffAnchor(previous, current *api.Anchor, tolerance float64) *api.AnchorDiff {
+	diff := &api.AnchorDiff{ID: current.ID}
+	if previous == nil {
+		diff.Created = true
+		diff.X, diff.Y, diff.Z = &current.Pose.X, &current.Pose.Y, &current.Pose.Z
+		diff.Rotation = current.Pose.Rotation
+		if len(current.Metadata) > 0 {
+			diff.Metadata = current.Metadata
+		}
+		return diff
+	}
+
+	changed := false
+	for _, axis := range []struct {
+		field    **float64
+		previous float64
+		current  *float64
+	}{
+		{&diff.X, previous.Pose.X, &current.Pose.X},
+		{&diff.Y, previous.Pose.Y, &current.Pose.Y},
+		{&diff.Z, previous.Pose.Z, &current.Pose.Z},
+	} {
+		if !withinTolerance(axis.previous, *axis.current, tolerance) {
+			*axis.field = axis.current
+			changed = true
+		}
+	}
+
+	if !rotationsEqual(previous.Pose.Rotation, current.Pose.Rotation, tolerance) {
+		diff.Rotation = current.Pose.Rotation
+		changed = true
+	}
+
+	for key, value := range current.Metadata {
+		if stored, ok := previous.Metadata[key]; ok && metadataValueEqual(stored, value, tolerance) {
+			continue
+		}
+		if diff.Metadata == nil {
+			diff.Metadata = make(map[string]interface{})
+		}
+		diff.Metadata[key] = value
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return diff
+}
+
+// withinTolerance reports whether two numbers differ by at most tolerance
+func withinTolerance(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+// rotationsEqual compares quaternions component by component
+func rotationsEqual(a, b []float64, tolerance float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !withinTolerance(a[i], b[i], tolerance) {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataValueEqual compares decoded JSON metadata values, numbers within
+// tolerance
+func metadataValueEqual(a, b interface{}, tolerance float64) bool {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return withinTolerance(x, y, tolerance)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
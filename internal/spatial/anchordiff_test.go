@@ -0,0 +1,49 @@
+package spatial
+
+import (
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func TestDiffAnchor(t *testing.T) {
+	stored := &api.Anchor{
+		ID:       "a1",
+		Pose:     api.Pose{X: 1, Y: 2, Z: 3, Rotation: []float64{0, 0, 0, 1}},
+		Metadata: map[string]interface{}{"label": "door", "confidence": 0.9},
+	}
+	update := func(change func(a *api.Anchor)) *api.Anchor {
+		anchor := *stored
+		anchor.Pose.Rotation = []float64{0, 0, 0, 1}
+		anchor.Metadata = map[string]interface{}{"label": "door"}
+		change(&anchor)
+		return &anchor
+	}
+
+	if diff := diffAnchor(stored, update(func(a *api.Anchor) {}), 1e-6); diff != nil {
+		t.Errorf("Expected no diff for an unchanged anchor, got %+v", diff)
+	}
+	if diff := diffAnchor(stored, update(func(a *api.Anchor) { a.Pose.X += 1e-7; a.Metadata["confidence"] = 0.9 + 1e-7 }), 1e-6); diff != nil {
+		t.Errorf("Expected changes within tolerance ignored, got %+v", diff)
+	}
+
+	diff := diffAnchor(stored, update(func(a *api.Anchor) {
+		a.Pose.Y = 5
+		a.Pose.Rotation = []float64{0, 0, 1, 0}
+		a.Metadata["floor"] = 2.0
+	}), 1e-6)
+	if diff == nil || diff.Created || diff.X != nil || diff.Z != nil || diff.Y == nil || *diff.Y != 5 {
+		t.Fatalf("Expected a diff of y alone among the position, got %+v", diff)
+	}
+	if len(diff.Rotation) != 4 || diff.Rotation[2] != 1 {
+		t.Errorf("Expected the changed rotation, got %v", diff.Rotation)
+	}
+	if len(diff.Metadata) != 1 || diff.Metadata["floor"] != 2.0 {
+		t.Errorf("Expected only the new metadata key, got %v", diff.Metadata)
+	}
+
+	created := diffAnchor(nil, update(func(a *api.Anchor) {}), 1e-6)
+	if created == nil || !created.Created || created.X == nil || *created.X != 1 || created.Metadata["label"] != "door" {
+		t.Errorf("Expected a new anchor to carry every field, got %+v", created)
+	}
+}
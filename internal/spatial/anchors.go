@@ -0,0 +1,228 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Anchor IDs are chosen by clients and only unique within a session, so an
+// anchor is identified by its session and ID together. Endpoints addressing
+// an anchor by ID alone take the session from ?session_id=, or from the one
+// session using the ID.
+
+// ResolveAnchorSession returns the session of the anchor with anchorID. The
+// given sessionID, if any, must hold the anchor; otherwise exactly one session
+// may use the ID, and Conflict is returned when several do.
+func (r *Repository) ResolveAnchorSession(ctx context.Context, anchorID, sessionID string) (string, error) {
+	sessions, err := r.anchorSessions(ctx, anchorID, sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(sessions) {
+	case 0:
+		return "", errors.NotFound(fmt.Sprintf("anchor %s not found", anchorID))
+	case 1:
+		return sessions[0], nil
+	default:
+		return "", errors.Conflict(fmt.Sprintf("anchor %s exists in more than one session, pass session_id to choose one", anchorID))
+	}
+}
+
+// anchorSessions returns up to two sessions holding an anchor with anchorID,
+// only sessionID if given
+func (r *Repository) anchorSessions(ctx context.Context, anchorID, sessionID string) ([]string, error) {
+	query := `
+		FOR a IN @@collection OPTIONS { indexHint: "idx_anchor_id" }
+		FILTER a.id == @id
+		FILTER @session_id == "" OR a.session_id == @session_id
+		LIMIT 2
+		RETURN a.session_id
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.AnchorsCollection,
+		"id":          anchorID,
+		"session_id":  sessionID,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to look up anchor", err)
+	}
+	defer cursor.Close()
+
+	var sessions []string
+	for {
+		var session string
+		if _, err := cursor.ReadDocument(ctx, &session); driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, databaseError("failed to read anchor", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// UpdateAnchor changes the pose and metadata of an existing anchor of a
+// session and relinks it in the topology graph. Metadata is replaced when
+// given and left untouched when omitted.
+func (r *Repository) UpdateAnchor(ctx context.Context, sessionID string, update *api.AnchorUpdate) (*api.Anchor, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("update", "anchors").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	pose := api.Pose{
+		X:        update.Pose.X,
+		Y:        update.Pose.Y,
+		Z:        update.Pose.Z,
+		Rotation: update.Pose.Rotation,
+	}
+	if err := validateRotation(update.ID, &pose, r.normalizeRotations); err != nil {
+		return nil, err
+	}
+	if err := validateMetadata(update.ID, update.Metadata, r.metadataSchema); err != nil {
+		return nil, err
+	}
+
+	patch := map[string]interface{}{
+		"pose":      pose,
+		"location":  poseLocation(pose),
+		"timestamp": time.Now().UnixMilli(),
+	}
+	if update.Metadata != nil {
+		patch["metadata"] = update.Metadata
+	}
+
+	// UPDATE rather than UPSERT so unknown anchors are not created
+	query := `
+		FOR a IN @@collection
+		FILTER a.session_id == @session_id AND a.id == @id
+		LIMIT 1
+		UPDATE a WITH @patch IN @@collection OPTIONS { mergeObjects: false }
+		RETURN NEW
+	`
+
+	bindVars := map[string]interface{}{
+		"@collection": database.AnchorsCollection,
+		"session_id":  sessionID,
+		"id":          update.ID,
+		"patch":       patch,
+	}
+
+	var anchor api.Anchor
+	err := r.withTransaction(ctx, func(txCtx context.Context) error {
+		cursor, err := r.runQuery(txCtx, query, bindVars)
+		if err != nil {
+			return databaseError("failed to update anchor", err)
+		}
+		defer cursor.Close()
+
+		if _, err := cursor.ReadDocument(txCtx, &anchor); driver.IsNoMoreDocuments(err) {
+			return errors.NotFound(fmt.Sprintf("anchor %s not found", update.ID))
+		} else if err != nil {
+			return databaseError("failed to read updated anchor", err)
+		}
+
+		if err := r.recordPose(txCtx, &anchor); err != nil {
+			return err
+		}
+		return r.buildTopology(txCtx, sessionID, update.ID)
+	})
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("update", "anchors", "error").Inc()
+		return nil, err
+	}
+
+	r.metrics.DBOperationsTotal.WithLabelValues("update", "anchors", "success").Inc()
+	// The update replaces the metadata dedup merged, so forget the anchor
+	// rather than record its pose
+	r.forgetAnchorPoses(sessionID, update.ID)
+	r.audit(ctx, audit.Record{Action: audit.ActionAnchorUpdate, SessionID: sessionID, AnchorIDs: []string{update.ID}})
+	return &anchor, nil
+}
+
+// MaxLatestPoseIDs caps the anchor IDs of one latest pose fetch
+const MaxLatestPoseIDs = 500
+
+// LatestPoses returns the latest pose of each anchor in anchorIDs, read in
+// one query and listed in request order. Without sessionID, an anchor ID used
+// in several sessions resolves to the most recently updated anchor.
+func (r *Repository) LatestPoses(ctx context.Context, sessionID string, anchorIDs []string) (*api.LatestPosesResponse, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("get", "latest_poses").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	if len(anchorIDs) == 0 {
+		return nil, errors.ValidationError("at least one anchor ID is required")
+	}
+	if len(anchorIDs) > MaxLatestPoseIDs {
+		return nil, errors.ValidationError(fmt.Sprintf("at most %d anchor IDs may be fetched at once", MaxLatestPoseIDs))
+	}
+	for _, id := range anchorIDs {
+		if id == "" {
+			return nil, errors.ValidationError("anchor IDs must not be empty")
+		}
+	}
+
+	// Anchors hold their latest pose, so history need not be searched
+	query := `
+		FOR a IN @@collection OPTIONS { indexHint: "idx_anchor_id" }
+		FILTER a.id IN @ids
+		FILTER @session_id == "" OR a.session_id == @session_id
+		COLLECT id = a.id INTO matches = { session_id: a.session_id, pose: a.pose, timestamp: a.timestamp }
+		LET latest = FIRST(FOR m IN matches SORT m.timestamp DESC LIMIT 1 RETURN m)
+		RETURN MERGE(latest, { anchor_id: id, found: true })
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.AnchorsCollection,
+		"ids":         anchorIDs,
+		"session_id":  sessionID,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("get", "latest_poses", "error").Inc()
+		return nil, databaseError("failed to query latest poses", err)
+	}
+	defer cursor.Close()
+
+	byID := make(map[string]api.LatestPose, len(anchorIDs))
+	for {
+		var pose api.LatestPose
+		if _, err := cursor.ReadDocument(ctx, &pose); driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			r.metrics.DBOperationsTotal.WithLabelValues("get", "latest_poses", "error").Inc()
+			return nil, databaseError("failed to read latest pose", err)
+		}
+		byID[pose.AnchorID] = pose
+	}
+
+	response := &api.LatestPosesResponse{Poses: make([]api.LatestPose, len(anchorIDs))}
+	for i, id := range anchorIDs {
+		pose, ok := byID[id]
+		if ok {
+			response.Found++
+		} else {
+			pose = api.LatestPose{AnchorID: id}
+			response.Missing++
+		}
+		response.Poses[i] = pose
+	}
+
+	r.metrics.DBOperationsTotal.WithLabelValues("get", "latest_poses", "success").Inc()
+	return response, nil
+}
@@ -0,0 +1,60 @@
+package spatial
+
+import (
+	"context"
+
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// unknownPrincipal names the caller of writes whose context has no actor
+const unknownPrincipal = "unknown"
+
+// SetAuditLog records the repository's ingests, anchor updates and session
+// deletes to log. Must be called before the repository is used; nil
+// disables auditing.
+func (r *Repository) SetAuditLog(log *audit.Logger) {
+	r.auditLog = log
+}
+
+// audit writes record with the actor and trace ID of ctx. The write it
+// describes is already stored, so a record that cannot be written is
+// logged rather than failing it.
+func (r *Repository) audit(ctx context.Context, record audit.Record) {
+	if r.auditLog == nil {
+		return
+	}
+
+	actor, ok := audit.ActorFrom(ctx)
+	if !ok {
+		actor.Principal = unknownPrincipal
+	}
+	record.Principal, record.ClientIP = actor.Principal, actor.ClientIP
+	record.TraceID = logger.TraceID(ctx)
+
+	if err := r.auditLog.Record(record); err != nil {
+		r.metrics.AuditFailures.Inc()
+		r.log(ctx).Errorf("Failed to write audit record of %s for session %s: %v", record.Action, record.SessionID, err)
+	}
+}
+
+// auditEvent records a stored event by the IDs of its anchors and meshes
+func (r *Repository) auditEvent(ctx context.Context, event *api.SpatialEvent) {
+	if r.auditLog == nil {
+		return
+	}
+
+	record := audit.Record{
+		Action:    audit.ActionIngest,
+		SessionID: event.SessionID,
+		EventID:   event.EventID,
+	}
+	for _, anchor := range event.Anchors {
+		record.AnchorIDs = append(record.AnchorIDs, anchor.ID)
+	}
+	for _, mesh := range event.Meshes {
+		record.MeshIDs = append(record.MeshIDs, mesh.ID)
+	}
+	r.audit(ctx, record)
+}
@@ -0,0 +1,53 @@
+package spatial
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestIngestAuditRecord(t *testing.T) {
+	repo, _ := fakeArangoRepository(t, 0, func(fakeQuery) int { return 0 })
+	var sink bytes.Buffer
+	repo.SetAuditLog(audit.NewWriter(&sink))
+
+	ctx := audit.WithActor(context.Background(), audit.Actor{Principal: "key:0123abcd", ClientIP: "10.0.0.1"})
+	ctx = logger.WithTraceID(ctx, "trace-1")
+	event := &api.SpatialEvent{
+		SessionID: "s1",
+		EventID:   "e1",
+		Timestamp: 1000,
+		Anchors:   testAnchors(2),
+	}
+	if err := repo.Ingest(ctx, event); err != nil {
+		t.Fatalf("Failed to ingest: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(sink.Bytes()), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("Expected one audit record, got %d: %s", len(lines), sink.Bytes())
+	}
+	var record audit.Record
+	if err := json.Unmarshal(lines[0], &record); err != nil {
+		t.Fatalf("Failed to parse audit record: %v", err)
+	}
+	if record.Action != audit.ActionIngest || record.Principal != "key:0123abcd" || record.ClientIP != "10.0.0.1" ||
+		record.TraceID != "trace-1" || record.SessionID != "s1" || record.EventID != "e1" || record.Time.IsZero() {
+		t.Errorf("Unexpected audit record: %+v", record)
+	}
+	if len(record.AnchorIDs) != 2 || record.AnchorIDs[1] != "anchor-1" {
+		t.Errorf("Expected the event's anchor IDs, got %v", record.AnchorIDs)
+	}
+
+	// Poses and geometry are never recorded
+	for _, field := range []string{"pose", "vertices"} {
+		if bytes.Contains(lines[0], []byte(`"`+field+`"`)) {
+			t.Errorf("Expected no %s in the audit record, got %s", field, lines[0])
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// storeAnchors upserts an event's anchors and records their poses. Events
+// with enough anchors are written in bulk; smaller ones, and bulk writes
+// that fail permanently, anchor by anchor, so errors name the anchor at fault.
+func (r *Repository) storeAnchors(ctx context.Context, anchors []api.Anchor) error {
+	if r.bulkAnchors > 0 && len(anchors) >= r.bulkAnchors {
+		err := r.ingestAnchors(ctx, anchors)
+		if err == nil {
+			return nil
+		}
+		if apiErr, ok := errors.IsAPIError(err); ctx.Err() != nil || ok && apiErr.IsRetryable() {
+			return fmt.Errorf("failed to ingest %d anchors: %w", len(anchors), err)
+		}
+		r.log(ctx).Debugf("Bulk upsert of %d anchors failed, storing them one by one: %v", len(anchors), err)
+	}
+
+	for _, anchor := range anchors {
+		if _, err := r.ingestAnchor(ctx, &anchor); err != nil {
+			return fmt.Errorf("failed to ingest anchor %s: %w", anchor.ID, err)
+		}
+	}
+	return nil
+}
+
+// ingestAnchors upserts anchors in one query and records their poses in
+// another, rather than making a round trip for each. Anchors repeated in the
+// list are stored as their last occurrence, as upserting them in turn would
+// leave them.
+func (r *Repository) ingestAnchors(ctx context.Context, anchors []api.Anchor) error {
+	docs, samples := bulkAnchorDocuments(anchors)
+
+	upsertAnchors := `
+		FOR a IN @anchors
+		UPSERT { session_id: a.session_id, id: a.id }
+		INSERT a
+		UPDATE a
+		IN @@collection
+	`
+	recordPoses := `
+		FOR s IN @samples
+		UPSERT { session_id: s.session_id, anchor_id: s.anchor_id, timestamp: s.timestamp }
+		INSERT s
+		REPLACE s
+		IN @@collection
+	`
+
+	// Concurrent upserts of one anchor conflict, so transient failures are retried
+	err := r.retryWrite(ctx, "ingest_anchors", func() error {
+		cursor, err := r.runQuery(ctx, upsertAnchors, map[string]interface{}{
+			"@collection": database.AnchorsCollection,
+			"anchors":     docs,
+		})
+		if err != nil {
+			return databaseError(fmt.Sprintf("failed to upsert %d anchors", len(docs)), err)
+		}
+		cursor.Close()
+
+		cursor, err = r.runQuery(ctx, recordPoses, map[string]interface{}{
+			"@collection": database.AnchorPosesCollection,
+			"samples":     samples,
+		})
+		if err != nil {
+			return databaseError(fmt.Sprintf("failed to record poses of %d anchors", len(docs)), err)
+		}
+		cursor.Close()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if err := r.prunePoses(ctx, doc.SessionID, doc.ID); err != nil {
+			return fmt.Errorf("failed to ingest anchor %s: %w", doc.ID, err)
+		}
+	}
+	return nil
+}
+
+// bulkAnchorDocuments returns the documents and pose samples of anchors,
+// keeping the last of each anchor and of each sample in the list, since one
+// query cannot upsert the same document twice
+func bulkAnchorDocuments(anchors []api.Anchor) ([]anchorDocument, []poseSample) {
+	type anchorKey struct{ sessionID, id string }
+	type sampleKey struct {
+		anchorKey
+		timestamp int64
+	}
+
+	docIndex := make(map[anchorKey]int, len(anchors))
+	sampleIndex := make(map[sampleKey]int, len(anchors))
+	docs := make([]anchorDocument, 0, len(anchors))
+	samples := make([]poseSample, 0, len(anchors))
+	for i := range anchors {
+		anchor := &anchors[i]
+		key := anchorKey{anchor.SessionID, anchor.ID}
+
+		doc := anchorDocument{Anchor: anchor, Location: poseLocation(anchor.Pose)}
+		if j, ok := docIndex[key]; ok {
+			docs[j] = doc
+		} else {
+			docIndex[key] = len(docs)
+			docs = append(docs, doc)
+		}
+
+		sample := poseSample{AnchorID: anchor.ID, SessionID: anchor.SessionID, Pose: anchor.Pose, Timestamp: anchor.Timestamp}
+		if j, ok := sampleIndex[sampleKey{key, anchor.Timestamp}]; ok {
+			samples[j] = sample
+		} else {
+			sampleIndex[sampleKey{key, anchor.Timestamp}] = len(samples)
+			samples = append(samples, sample)
+		}
+	}
+	return docs, samples
+}
@@ -0,0 +1,203 @@
+package spatial
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+// fakeQuery is an AQL query received by a fake ArangoDB
+type fakeQuery struct {
+	Query    string                     `json:"query"`
+	BindVars map[string]json.RawMessage `json:"bindVars"`
+
+	Transaction string `json:"-"` // ID of the stream transaction it ran in, if any
+}
+
+// fakeArangoRepository returns a repository on a fake ArangoDB that answers
+// each cursor request after latency, failing those fail returns an error
+// number for, and the queries it received
+func fakeArangoRepository(tb testing.TB, latency time.Duration, fail func(q fakeQuery) int) (*Repository, func() []fakeQuery) {
+	tb.Helper()
+	return fakeArangoResults(tb, latency, func(q fakeQuery) (string, int) {
+		return `[{"old":null}]`, fail(q)
+	})
+}
+
+// fakeArangoResults is fakeArangoRepository with the results of each query,
+// a JSON array, chosen by respond
+func fakeArangoResults(tb testing.TB, latency time.Duration, respond func(q fakeQuery) (result string, errorNum int)) (*Repository, func() []fakeQuery) {
+	tb.Helper()
+
+	var mu sync.Mutex
+	var queries []fakeQuery
+	var transactions int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_api/database/current"):
+			w.Write([]byte(`{"error":false,"code":200,"result":{"name":"stag","id":"1","path":"","isSystem":false}}`))
+		case strings.Contains(r.URL.Path, "/_api/transaction/"):
+			// Stream transactions begin, commit and abort without effect
+			id := strings.TrimPrefix(r.URL.Path[strings.LastIndex(r.URL.Path, "/"):], "/")
+			if id == "begin" {
+				mu.Lock()
+				transactions++
+				id = fmt.Sprint(transactions)
+				mu.Unlock()
+				w.WriteHeader(http.StatusCreated)
+			}
+			fmt.Fprintf(w, `{"error":false,"code":200,"result":{"id":%q,"status":"running"}}`, id)
+		case strings.HasSuffix(r.URL.Path, "/_api/cursor"):
+			var q fakeQuery
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &q)
+			q.Transaction = r.Header.Get("x-arango-trx-id")
+			mu.Lock()
+			queries = append(queries, q)
+			mu.Unlock()
+
+			time.Sleep(latency)
+			result, errorNum := respond(q)
+			if errorNum != 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error":true,"code":400,"errorNum":%d,"errorMessage":"query failed"}`, errorNum)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"error":false,"code":201,"result":%s,"hasMore":false}`, result)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	tb.Cleanup(server.Close)
+
+	log := logger.New(logger.Config{})
+	conn, err := database.Connect(context.Background(), config.DatabaseConfig{URL: server.URL, Database: "stag", MaxAttempts: 1}, log)
+	if err != nil {
+		tb.Fatalf("Failed to connect to fake ArangoDB: %v", err)
+	}
+	repo := &Repository{
+		db:      conn,
+		logger:  log,
+		metrics: metrics.New(config.MetricsConfig{}, log),
+	}
+	return repo, func() []fakeQuery {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]fakeQuery(nil), queries...)
+	}
+}
+
+// testAnchors returns n anchors of one session
+func testAnchors(n int) []api.Anchor {
+	anchors := make([]api.Anchor, n)
+	for i := range anchors {
+		anchors[i] = api.Anchor{
+			ID:        fmt.Sprintf("anchor-%d", i),
+			SessionID: "s1",
+			Pose:      api.Pose{X: float64(i), Rotation: []float64{0, 0, 0, 1}},
+			Timestamp: int64(1000 + i),
+		}
+	}
+	return anchors
+}
+
+func TestStoreAnchorsBulk(t *testing.T) {
+	never := func(fakeQuery) int { return 0 }
+
+	// Events below the threshold make a round trip per anchor and pose
+	repo, queries := fakeArangoRepository(t, 0, never)
+	repo.bulkAnchors = 4
+	if err := repo.storeAnchors(context.Background(), testAnchors(3)); err != nil {
+		t.Fatalf("Failed to store anchors: %v", err)
+	}
+	if got := len(queries()); got != 6 {
+		t.Errorf("Expected 6 queries for 3 anchors stored one by one, got %d", got)
+	}
+
+	// Larger events take one query for the anchors and one for the poses,
+	// keeping the last of a repeated anchor
+	repo, queries = fakeArangoRepository(t, 0, never)
+	repo.bulkAnchors = 4
+	anchors := testAnchors(4)
+	repeated := anchors[1]
+	repeated.Pose.X = 42
+	anchors = append(anchors, repeated)
+	if err := repo.storeAnchors(context.Background(), anchors); err != nil {
+		t.Fatalf("Failed to store anchors: %v", err)
+	}
+	sent := queries()
+	if len(sent) != 2 {
+		t.Fatalf("Expected 2 queries for a bulk upsert, got %d", len(sent))
+	}
+	var docs []api.Anchor
+	if err := json.Unmarshal(sent[0].BindVars["anchors"], &docs); err != nil {
+		t.Fatalf("Failed to decode anchors: %v", err)
+	}
+	if len(docs) != 4 || docs[1].ID != "anchor-1" || docs[1].Pose.X != 42 {
+		t.Errorf("Expected 4 anchors with the repeat's pose, got %+v", docs)
+	}
+	var samples []poseSample
+	if err := json.Unmarshal(sent[1].BindVars["samples"], &samples); err != nil {
+		t.Fatalf("Failed to decode samples: %v", err)
+	}
+	if len(samples) != 4 || samples[1].Pose.X != 42 {
+		t.Errorf("Expected 4 pose samples with the repeat's pose, got %+v", samples)
+	}
+
+	// A bulk upsert that fails permanently is repeated anchor by anchor,
+	// naming the anchor at fault
+	repo, _ = fakeArangoRepository(t, 0, func(q fakeQuery) int {
+		if strings.Contains(q.Query, "@anchors") || string(q.BindVars["id"]) == `"anchor-2"` {
+			return 1501
+		}
+		return 0
+	})
+	repo.bulkAnchors = 4
+	err := repo.storeAnchors(context.Background(), testAnchors(4))
+	if err == nil || !strings.Contains(err.Error(), "failed to ingest anchor anchor-2") {
+		t.Errorf("Expected the failing anchor named, got %v", err)
+	}
+}
+
+// BenchmarkStoreAnchors compares storing a 500-anchor event one anchor at a
+// time with the bulk upsert, against a fake ArangoDB answering each query
+// after a 200µs round trip
+func BenchmarkStoreAnchors(b *testing.B) {
+	anchors := testAnchors(500)
+	for _, bench := range []struct {
+		name        string
+		bulkAnchors int
+	}{
+		{"Loop", 0},
+		{"Bulk", 16},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			repo, queries := fakeArangoRepository(b, 200*time.Microsecond, func(fakeQuery) int { return 0 })
+			repo.bulkAnchors = bench.bulkAnchors
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := repo.storeAnchors(context.Background(), anchors); err != nil {
+					b.Fatalf("Failed to store anchors: %v", err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(len(queries()))/float64(b.N), "queries/op")
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package spatial
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hashCacheEntry is a cached mesh ID with its insertion time
+type hashCacheEntry struct {
+	meshID   string
+	cachedAt time.Time
+}
+
+// hashCache maps mesh content hashes to stored mesh IDs. Entries older than
+// expiry are treated as missing; an expiry of zero disables eviction.
+type hashCache struct {
+	mu      sync.Mutex
+	entries map[string]hashCacheEntry
+	expiry  time.Duration
+
+	// Lookups through get since the cache was created
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// newHashCache creates an empty hash cache
+func newHashCache(expiry time.Duration) *hashCache {
+	return &hashCache{
+		entries: make(map[string]hashCacheEntry),
+		expiry:  expiry,
+	}
+}
+
+// get returns the mesh ID cached for a hash, evicting it if expired, and
+// counts the lookup as a hit or miss
+func (c *hashCache) get(hash string) (string, bool) {
+	meshID, ok := c.peek(hash)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return meshID, ok
+}
+
+// peek is get without counting the lookup
+func (c *hashCache) peek(hash string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[hash]
+	if !ok {
+		return "", false
+	}
+	if c.expired(entry, time.Now()) {
+		delete(c.entries, hash)
+		return "", false
+	}
+	return entry.meshID, true
+}
+
+// putIfAbsent caches meshID for a hash unless a live entry exists, in which
+// case the existing mesh ID is returned with ok set to true
+func (c *hashCache) putIfAbsent(hash, meshID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := c.entries[hash]; ok && !c.expired(entry, now) {
+		return entry.meshID, true
+	}
+	c.entries[hash] = hashCacheEntry{meshID: meshID, cachedAt: now}
+	return meshID, false
+}
+
+// remove drops a hash if it still maps to meshID
+func (c *hashCache) remove(hash, meshID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[hash]; ok && entry.meshID == meshID {
+		delete(c.entries, hash)
+	}
+}
+
+// evictExpired removes all entries that expired before now
+func (c *hashCache) evictExpired(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	evicted := 0
+	for hash, entry := range c.entries {
+		if c.expired(entry, now) {
+			delete(c.entries, hash)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// replace swaps all entries for meshIDs, keyed by hash, and returns how
+// many entries there were before
+func (c *hashCache) replace(meshIDs map[string]string) int {
+	now := time.Now()
+	entries := make(map[string]hashCacheEntry, len(meshIDs))
+	for hash, meshID := range meshIDs {
+		entries[hash] = hashCacheEntry{meshID: meshID, cachedAt: now}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := len(c.entries)
+	c.entries = entries
+	return previous
+}
+
+// lookups returns the hits and misses counted by get
+func (c *hashCache) lookups() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// size returns the number of cached entries
+func (c *hashCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+func (c *hashCache) expired(entry hashCacheEntry, now time.Time) bool {
+	return c.expiry > 0 && now.Sub(entry.cachedAt) > c.expiry
+}
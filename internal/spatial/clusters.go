@@ -0,0 +1,153 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Cluster grid bounds
+const (
+	defaultClusterGrid = 1.0  // Meters
+	minClusterGrid     = 0.01 // Meters
+	maxClusters        = 10000
+
+	// clusterAreaMargin pads the indexed floor area of a bounding box, in
+	// meters, so anchors on its edges survive the sphere's curvature
+	clusterAreaMargin = 1.0
+)
+
+// clusterRegion is a validated clustering grid and optional bounding box
+type clusterRegion struct {
+	grid float64
+	box  *[6]float64 // Min x, y, z then max x, y, z; nil clusters the whole session
+}
+
+// newClusterRegion applies the default grid and validates the parameters
+func newClusterRegion(params *api.ClusterParams) (*clusterRegion, error) {
+	region := &clusterRegion{grid: params.Grid}
+	if region.grid == 0 {
+		region.grid = defaultClusterGrid
+	}
+	if math.IsNaN(region.grid) || math.IsInf(region.grid, 0) || region.grid < minClusterGrid {
+		return nil, errors.ValidationError(fmt.Sprintf("grid must be at least %g meters", minClusterGrid))
+	}
+
+	bounds := []*float64{params.MinX, params.MinY, params.MinZ, params.MaxX, params.MaxY, params.MaxZ}
+	set := 0
+	for _, bound := range bounds {
+		if bound != nil {
+			set++
+		}
+	}
+	switch set {
+	case 0:
+		return region, nil
+	case len(bounds):
+	default:
+		return nil, errors.ValidationError("bounding box requires min_x, min_y, min_z, max_x, max_y and max_z")
+	}
+
+	region.box = &[6]float64{}
+	for i, bound := range bounds {
+		region.box[i] = *bound
+	}
+	if region.box[0] > region.box[3] || region.box[1] > region.box[4] || region.box[2] > region.box[5] {
+		return nil, errors.ValidationError("bounding box minimums must not exceed maximums")
+	}
+	return region, nil
+}
+
+// SessionClusters groups a session's anchors into cells of a 3D grid,
+// returning each occupied cell's centroid and anchor count. The grouping runs
+// in the database, so only the cells are read.
+func (r *Repository) SessionClusters(ctx context.Context, sessionID string, params *api.ClusterParams) (*api.ClusterResponse, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("query", "clusters").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	region, err := newClusterRegion(params)
+	if err != nil {
+		return nil, err
+	}
+
+	query, bindVars := buildClusterQuery(sessionID, region)
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("query", "clusters", "error").Inc()
+		return nil, databaseError("failed to cluster session anchors", err)
+	}
+	defer cursor.Close()
+
+	response := &api.ClusterResponse{SessionID: sessionID, Grid: region.grid}
+	var result struct {
+		Clusters []api.AnchorCluster `json:"clusters"`
+		Total    int                 `json:"total"`
+		Anchors  int                 `json:"anchors"`
+	}
+	if _, err := cursor.ReadDocument(ctx, &result); err != nil && !driver.IsNoMoreDocuments(err) {
+		return nil, databaseError("failed to read session clusters", err)
+	}
+
+	response.Clusters = result.Clusters
+	if response.Clusters == nil {
+		response.Clusters = []api.AnchorCluster{}
+	}
+	response.Count = len(response.Clusters)
+	response.TotalClusters = result.Total
+	response.Anchors = result.Anchors
+
+	r.metrics.DBOperationsTotal.WithLabelValues("query", "clusters", "success").Inc()
+	return response, nil
+}
+
+// buildClusterQuery groups anchors by floored grid coordinates. Anchors are
+// found on idx_session_id, or within a bounding box with a floor area on the
+// GeoJSON location index, which the exact bounds then trim.
+func buildClusterQuery(sessionID string, region *clusterRegion) (string, map[string]interface{}) {
+	bindVars := map[string]interface{}{
+		"@anchors":   database.AnchorsCollection,
+		"session_id": sessionID,
+		"grid":       region.grid,
+		"limit":      maxClusters,
+	}
+
+	filters := "FILTER a.session_id == @session_id"
+	hint := "idx_session_id"
+	if box := region.box; box != nil {
+		minX, minY := box[0]-clusterAreaMargin, box[1]-clusterAreaMargin
+		maxX, maxY := box[3]+clusterAreaMargin, box[4]+clusterAreaMargin
+		ring := [][2]float64{{minX, minY}, {maxX, minY}, {maxX, maxY}, {minX, maxY}, {minX, minY}}
+		bindVars["area"] = polygonGeoJSON(ring)
+		hint = "idx_geo_location"
+
+		filters += `
+	FILTER GEO_INTERSECTS(@area, a.location)
+	FILTER a.pose.x >= @min_x AND a.pose.x <= @max_x
+	FILTER a.pose.y >= @min_y AND a.pose.y <= @max_y
+	FILTER a.pose.z >= @min_z AND a.pose.z <= @max_z`
+		bindVars["min_x"], bindVars["min_y"], bindVars["min_z"] = box[0], box[1], box[2]
+		bindVars["max_x"], bindVars["max_y"], bindVars["max_z"] = box[3], box[4], box[5]
+	}
+
+	query := `LET cells = (
+	FOR a IN @@anchors OPTIONS { indexHint: "` + hint + `" }
+	` + filters + `
+	COLLECT x = FLOOR(a.pose.x / @grid), y = FLOOR(a.pose.y / @grid), z = FLOOR(a.pose.z / @grid)
+	AGGREGATE count = LENGTH(1), cx = AVERAGE(a.pose.x), cy = AVERAGE(a.pose.y), cz = AVERAGE(a.pose.z)
+	SORT count DESC, x, y, z
+	RETURN { cell: [x, y, z], x: cx, y: cy, z: cz, count }
+)
+RETURN { clusters: SLICE(cells, 0, @limit), total: LENGTH(cells), anchors: SUM(cells[*].count) }`
+
+	return query, bindVars
+}
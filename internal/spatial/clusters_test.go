@@ -0,0 +1,56 @@
+package spatial
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func TestNewClusterRegion(t *testing.T) {
+	region, err := newClusterRegion(&api.ClusterParams{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if region.grid != defaultClusterGrid || region.box != nil {
+		t.Errorf("Expected the default grid over the whole session, got %+v", region)
+	}
+
+	lo, hi := 0.0, 4.0
+	region, err = newClusterRegion(&api.ClusterParams{Grid: 0.5, MinX: &lo, MinY: &lo, MinZ: &lo, MaxX: &hi, MaxY: &hi, MaxZ: &hi})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if region.grid != 0.5 || region.box == nil || *region.box != [6]float64{0, 0, 0, 4, 4, 4} {
+		t.Errorf("Unexpected region: %+v", region)
+	}
+
+	for name, params := range map[string]*api.ClusterParams{
+		"NegativeGrid": {Grid: -1},
+		"TinyGrid":     {Grid: 0.001},
+		"PartialBox":   {MinX: &lo, MaxX: &hi},
+		"InvertedBox":  {MinX: &hi, MinY: &lo, MinZ: &lo, MaxX: &lo, MaxY: &hi, MaxZ: &hi},
+	} {
+		if _, err := newClusterRegion(params); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestBuildClusterQuery(t *testing.T) {
+	query, bindVars := buildClusterQuery("s1", &clusterRegion{grid: 0.5})
+	if !strings.Contains(query, `indexHint: "idx_session_id"`) || strings.Contains(query, "@area") {
+		t.Errorf("Expected a session scan without a region: %s", query)
+	}
+	if !strings.Contains(query, "COLLECT x = FLOOR(a.pose.x / @grid)") || bindVars["grid"] != 0.5 {
+		t.Errorf("Expected anchors grouped by floored coordinates: %s %v", query, bindVars)
+	}
+
+	query, bindVars = buildClusterQuery("s1", &clusterRegion{grid: 1, box: &[6]float64{0, 0, 0, 4, 4, 1}})
+	if !strings.Contains(query, `indexHint: "idx_geo_location"`) || !strings.Contains(query, "GEO_INTERSECTS(@area, a.location)") {
+		t.Errorf("Expected the region found on the location index: %s", query)
+	}
+	if !strings.Contains(query, "a.pose.z >= @min_z AND a.pose.z <= @max_z") || bindVars["max_z"] != 1.0 {
+		t.Errorf("Expected exact bounds on every axis: %s %v", query, bindVars)
+	}
+}
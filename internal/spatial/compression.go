@@ -0,0 +1,354 @@
+package spatial
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Codec compresses and decompresses mesh buffers
+type Codec interface {
+	// Name returns the codec identifier used in configuration
+	Name() string
+	// Compress encodes data at a compression level from 1 (fastest) to 9 (smallest)
+	Compress(data []byte, level int) ([]byte, error)
+	// Decompress decodes data produced by Compress
+	Decompress(data []byte) ([]byte, error)
+	// Detect reports whether data starts with this codec's framing
+	Detect(data []byte) bool
+	// NewWriter returns a writer encoding a stream to w at a compression level
+	// from 1 to 9. The stream ends when the writer is closed.
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	// NewReader returns a reader decoding a stream read from r
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Codec names
+const (
+	CodecRaw     = "raw"
+	CodecGzip    = "gzip"
+	CodecZstd    = "zstd"
+	CodecDraco   = "draco"
+	CodecMeshopt = "meshopt"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// codecs is the registry of codecs the server can decode. Their framing is
+// detected on input when a mesh does not declare its codec.
+var codecs = []Codec{gzipCodec{}, zstdCodec{}}
+
+// opaqueCodecs are geometry codecs clients may send but the server cannot
+// decode. Such meshes are stored as sent and cannot be exported.
+var opaqueCodecs = map[string]bool{
+	CodecDraco:   true,
+	CodecMeshopt: true,
+}
+
+// lookupCodec returns a registered codec by name, or nil if there is none
+func lookupCodec(name string) Codec {
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec
+		}
+	}
+	return nil
+}
+
+// NewCodecWriter returns a writer encoding a stream to w with the named
+// codec, for responses compressed as they are written
+func NewCodecWriter(name string, w io.Writer, level int) (io.WriteCloser, error) {
+	codec := lookupCodec(name)
+	if codec == nil {
+		return nil, fmt.Errorf("unknown compression codec %q", name)
+	}
+	return codec.NewWriter(w, level)
+}
+
+// decodeStream returns a reader decoding r if it opens with a known codec
+// framing, or reading it as is otherwise
+func decodeStream(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	prefix, _ := buffered.Peek(len(zstdMagic))
+	if codec := detectCodec(prefix); codec != nil {
+		return codec.NewReader(buffered)
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// isOpaqueCodec reports whether geometry in the named codec cannot be decoded
+func isOpaqueCodec(name string) bool {
+	return opaqueCodecs[name]
+}
+
+// codecByName returns a storage codec by name, or nil for raw storage
+func codecByName(name string) (Codec, error) {
+	if name == "" || name == CodecRaw {
+		return nil, nil
+	}
+	if codec := lookupCodec(name); codec != nil {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("unknown compression codec %q", name)
+}
+
+// detectCodec returns the codec whose framing data carries, or nil if raw
+func detectCodec(data []byte) Codec {
+	for _, codec := range codecs {
+		if codec.Detect(data) {
+			return codec
+		}
+	}
+	return nil
+}
+
+// decompressBuffer decodes a buffer if it carries a known codec framing
+func decompressBuffer(data []byte) ([]byte, error) {
+	codec := detectCodec(data)
+	if codec == nil {
+		return data, nil
+	}
+
+	decoded, err := codec.Decompress(data)
+	if err != nil {
+		return nil, errors.CompressionError(fmt.Sprintf("corrupt %s payload: %v", codec.Name(), err))
+	}
+	return decoded, nil
+}
+
+// compressBuffer encodes a buffer for storage. Level 0 or a nil codec keeps it raw.
+func compressBuffer(codec Codec, data []byte, level int) ([]byte, error) {
+	if codec == nil || level <= 0 || len(data) == 0 {
+		return data, nil
+	}
+
+	encoded, err := codec.Compress(data, level)
+	if err != nil {
+		return nil, errors.CompressionError(fmt.Sprintf("failed to %s compress: %v", codec.Name(), err))
+	}
+	return encoded, nil
+}
+
+// decodeWithCodec decodes a buffer declared to use codec
+func decodeWithCodec(codec Codec, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	decoded, err := codec.Decompress(data)
+	if err != nil {
+		return nil, errors.CompressionError(fmt.Sprintf("corrupt %s payload: %v", codec.Name(), err))
+	}
+	return decoded, nil
+}
+
+// decodeMeshBuffers returns a copy of the mesh with its geometry decoded to
+// raw vertex, index and normal buffers. Meshes that do not declare a codec
+// are decoded by detecting each buffer's framing.
+func decodeMeshBuffers(mesh *api.Mesh) (*api.Mesh, error) {
+	decoded := *mesh
+
+	decode := decompressBuffer
+	switch name := mesh.CompressionCodec; {
+	case name == "":
+	case name == CodecRaw:
+		return &decoded, nil
+	case isOpaqueCodec(name):
+		return nil, errors.UnprocessableEntity(fmt.Sprintf("mesh %s uses %s geometry, which cannot be decoded by the server", mesh.ID, name))
+	default:
+		codec := lookupCodec(name)
+		if codec == nil {
+			return nil, errors.ValidationError(fmt.Sprintf("unknown compression codec %q", name))
+		}
+		decode = func(data []byte) ([]byte, error) { return decodeWithCodec(codec, data) }
+	}
+
+	var err error
+	if decoded.Vertices, err = decode(mesh.Vertices); err != nil {
+		return nil, err
+	}
+	if decoded.Faces, err = decode(mesh.Faces); err != nil {
+		return nil, err
+	}
+	if decoded.Normals, err = decode(mesh.Normals); err != nil {
+		return nil, err
+	}
+
+	return &decoded, nil
+}
+
+// encodeMeshBuffers compresses the geometry of a decoded mesh in place
+func encodeMeshBuffers(mesh *api.Mesh, codec Codec, level int) error {
+	var err error
+	if mesh.Vertices, err = compressBuffer(codec, mesh.Vertices, level); err != nil {
+		return err
+	}
+	if mesh.Faces, err = compressBuffer(codec, mesh.Faces, level); err != nil {
+		return err
+	}
+	if mesh.Normals, err = compressBuffer(codec, mesh.Normals, level); err != nil {
+		return err
+	}
+	return nil
+}
+
+// gzipCodec implements Codec using gzip
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return CodecGzip }
+
+func (gzipCodec) Detect(data []byte) bool { return bytes.HasPrefix(data, gzipMagic) }
+
+func (gzipCodec) Compress(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// zstdCodec implements Codec using zstd
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return CodecZstd }
+
+func (zstdCodec) Detect(data []byte) bool { return bytes.HasPrefix(data, zstdMagic) }
+
+func (zstdCodec) Compress(data []byte, level int) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdLevel(level)))
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+	return enc.EncodeAll(data, nil), nil
+}
+
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel(level)))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+func (zstdCodec) Decompress(data []byte) ([]byte, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	return dec.DecodeAll(data, nil)
+}
+
+// zstdLevel maps the 1-9 mesh compression level onto zstd encoder levels
+func zstdLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 2:
+		return zstd.SpeedFastest
+	case level <= 5:
+		return zstd.SpeedDefault
+	case level <= 8:
+		return zstd.SpeedBetterCompression
+	default:
+		return zstd.SpeedBestCompression
+	}
+}
+
+// MaxCompressionLevel is the highest storage compression level. Level 0
+// stores geometry raw.
+const MaxCompressionLevel = 9
+
+// ClampCompressionLevel limits a storage compression level to 0 through
+// MaxCompressionLevel
+func ClampCompressionLevel(level int) int {
+	return min(max(level, 0), MaxCompressionLevel)
+}
+
+// checkCompressionLevel returns a mesh's compression level if it is within 0
+// to MaxCompressionLevel. Levels outside the range are limited to it when
+// clamping is configured, and rejected otherwise.
+func (r *Repository) checkCompressionLevel(meshID string, level int) (int, error) {
+	if level >= 0 && level <= MaxCompressionLevel {
+		return level, nil
+	}
+	if r.clampLevels {
+		return ClampCompressionLevel(level), nil
+	}
+	return 0, errors.ValidationError(fmt.Sprintf("mesh %s: compression_level must be between 0 and %d, got %d", meshID, MaxCompressionLevel, level))
+}
+
+// SetSessionCompressionLevel sets the storage compression level for a
+// session's meshes that do not set their own, replacing the configured
+// default. The level is clamped and returned.
+func (r *Repository) SetSessionCompressionLevel(sessionID string, level int) int {
+	level = ClampCompressionLevel(level)
+
+	r.levelsMu.Lock()
+	defer r.levelsMu.Unlock()
+	r.sessionLevels[sessionID] = level
+	return level
+}
+
+// forgetSessionCompressionLevel drops a session's compression level override
+func (r *Repository) forgetSessionCompressionLevel(sessionID string) {
+	r.levelsMu.Lock()
+	defer r.levelsMu.Unlock()
+	delete(r.sessionLevels, sessionID)
+}
+
+// storageLevel returns the level a mesh is stored at: its own level if set,
+// else its session's override, else the configured default. Geometry is
+// stored raw, at level 0, when no storage codec is configured.
+func (r *Repository) storageLevel(mesh *api.Mesh) int {
+	if r.storageCodec == nil {
+		return 0
+	}
+	if mesh.CompressionLevel != 0 {
+		return ClampCompressionLevel(mesh.CompressionLevel)
+	}
+
+	r.levelsMu.RLock()
+	defer r.levelsMu.RUnlock()
+	if level, ok := r.sessionLevels[mesh.SessionID]; ok {
+		return level
+	}
+	return r.compressionLevel
+}
@@ -0,0 +1,202 @@
+package spatial
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 256)
+
+	for _, codec := range codecs {
+		encoded, err := compressBuffer(codec, data, 5)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", codec.Name(), err)
+		}
+		if detected := detectCodec(encoded); detected == nil || detected.Name() != codec.Name() {
+			t.Errorf("%s: expected codec to be detected from framing", codec.Name())
+		}
+
+		decoded, err := decompressBuffer(encoded)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", codec.Name(), err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("%s: round trip mismatch", codec.Name())
+		}
+	}
+
+	// Raw buffers pass through untouched
+	raw, err := decompressBuffer([]byte{1, 2, 3})
+	if err != nil || !bytes.Equal(raw, []byte{1, 2, 3}) {
+		t.Errorf("Expected raw passthrough, got %v (%v)", raw, err)
+	}
+}
+
+func TestCorruptPayload(t *testing.T) {
+	encoded, err := compressBuffer(zstdCodec{}, bytes.Repeat([]byte{9}, 128), 3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = decompressBuffer(encoded[:len(encoded)/2])
+	apiErr, ok := errors.IsAPIError(err)
+	if !ok || apiErr.Code != "COMPRESSION_ERROR" {
+		t.Errorf("Expected compression error, got %v", err)
+	}
+}
+
+func TestMeshHashIgnoresCompression(t *testing.T) {
+	repo := &Repository{}
+	mesh := &api.Mesh{
+		Vertices: bytes.Repeat([]byte{1, 2, 3, 4}, 64),
+		Faces:    []byte{0, 1, 2},
+	}
+
+	compressed := *mesh
+	if err := encodeMeshBuffers(&compressed, gzipCodec{}, 6); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	decoded, err := decodeMeshBuffers(&compressed)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if repo.computeMeshHash(decoded) != repo.computeMeshHash(mesh) {
+		t.Error("Expected identical hashes for raw and compressed geometry")
+	}
+}
+
+func TestDecodeMeshBuffersDeclaredCodec(t *testing.T) {
+	mesh := api.Mesh{
+		ID:       "mesh-1",
+		Vertices: bytes.Repeat([]byte{1, 2, 3, 4}, 64),
+		Faces:    []byte{0, 1, 2},
+	}
+
+	for _, name := range []string{CodecRaw, CodecGzip, CodecZstd} {
+		encoded := mesh
+		encoded.CompressionCodec = name
+		if codec := lookupCodec(name); codec != nil {
+			if err := encodeMeshBuffers(&encoded, codec, 5); err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+		}
+
+		decoded, err := decodeMeshBuffers(&encoded)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !bytes.Equal(decoded.Vertices, mesh.Vertices) || !bytes.Equal(decoded.Faces, mesh.Faces) {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+
+	// A declared codec is trusted over framing detection
+	mismatched := mesh
+	mismatched.CompressionCodec = CodecZstd
+	if err := encodeMeshBuffers(&mismatched, gzipCodec{}, 5); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := decodeMeshBuffers(&mismatched); err == nil {
+		t.Error("Expected gzip geometry declared as zstd to fail")
+	}
+
+	for _, name := range []string{CodecDraco, CodecMeshopt} {
+		opaque := mesh
+		opaque.CompressionCodec = name
+		_, err := decodeMeshBuffers(&opaque)
+		if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.Code != "UNPROCESSABLE_ENTITY" {
+			t.Errorf("%s: expected unprocessable entity error, got %v", name, err)
+		}
+	}
+
+	unknown := mesh
+	unknown.CompressionCodec = "lzma"
+	_, err := decodeMeshBuffers(&unknown)
+	if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.Code != "VALIDATION_ERROR" {
+		t.Errorf("Expected validation error for unknown codec, got %v", err)
+	}
+}
+
+func TestOpaqueMeshHashIncludesCodec(t *testing.T) {
+	repo := &Repository{}
+	draco := &api.Mesh{Vertices: []byte{1, 2, 3}, CompressionCodec: CodecDraco}
+	meshopt := &api.Mesh{Vertices: []byte{1, 2, 3}, CompressionCodec: CodecMeshopt}
+
+	if repo.computeMeshHash(draco) == repo.computeMeshHash(meshopt) {
+		t.Error("Expected identical bytes in different opaque codecs to hash differently")
+	}
+}
+
+func TestMeshVertices(t *testing.T) {
+	vertices := bytes.Repeat([]byte{1, 2, 3, 4}, 64)
+	patch := []byte{7, 8, 9}
+
+	compressed, err := compressBuffer(gzipCodec{}, vertices, 5)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	compressedPatch, err := compressBuffer(zstdCodec{}, patch, 3)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		mesh *api.Mesh
+		want []byte
+	}{
+		{"Full", &api.Mesh{ID: "m1", Vertices: compressed}, vertices},
+		{"Raw", &api.Mesh{ID: "m2", Vertices: vertices, CompressionCodec: CodecRaw}, vertices},
+		{"Delta", &api.Mesh{ID: "m3", IsDelta: true, DeltaData: compressedPatch}, patch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MeshVertices(tt.mesh)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Expected %d vertex bytes, got %d", len(tt.want), len(got))
+			}
+		})
+	}
+}
+
+func TestStorageLevel(t *testing.T) {
+	repo := &Repository{storageCodec: zstdCodec{}, compressionLevel: 3, sessionLevels: make(map[string]int)}
+
+	if level := repo.storageLevel(&api.Mesh{SessionID: "s1"}); level != 3 {
+		t.Errorf("Expected the default level 3, got %d", level)
+	}
+
+	if level := repo.SetSessionCompressionLevel("s1", 12); level != MaxCompressionLevel {
+		t.Errorf("Expected the override clamped to %d, got %d", MaxCompressionLevel, level)
+	}
+	repo.SetSessionCompressionLevel("s2", 0)
+	if level := repo.storageLevel(&api.Mesh{SessionID: "s1"}); level != MaxCompressionLevel {
+		t.Errorf("Expected the session override, got %d", level)
+	}
+	if level := repo.storageLevel(&api.Mesh{SessionID: "s2"}); level != 0 {
+		t.Errorf("Expected an override to 0 to store raw, got %d", level)
+	}
+	if level := repo.storageLevel(&api.Mesh{SessionID: "s1", CompressionLevel: 2}); level != 2 {
+		t.Errorf("Expected the mesh's own level to win, got %d", level)
+	}
+
+	repo.forgetSessionCompressionLevel("s1")
+	if level := repo.storageLevel(&api.Mesh{SessionID: "s1"}); level != 3 {
+		t.Errorf("Expected the default after the override is forgotten, got %d", level)
+	}
+
+	raw := &Repository{compressionLevel: 3, sessionLevels: make(map[string]int)}
+	if level := raw.storageLevel(&api.Mesh{CompressionLevel: 5}); level != 0 {
+		t.Errorf("Expected level 0 without a storage codec, got %d", level)
+	}
+}
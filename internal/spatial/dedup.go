@@ -0,0 +1,89 @@
+package spatial
+
+import (
+	"context"
+	"time"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// DedupStats reports the dedup cache's size and lookups since startup, with
+// the limit stored hashes most referenced through deduplication
+func (r *Repository) DedupStats(ctx context.Context, limit int) (*api.DedupStats, error) {
+	hits, misses := r.meshHashCache.lookups()
+	stats := &api.DedupStats{
+		Entries:   r.meshHashCache.size(),
+		Hits:      hits,
+		Misses:    misses,
+		TopHashes: []api.DedupHash{},
+	}
+	if lookups := hits + misses; lookups > 0 {
+		stats.HitRatio = float64(hits) / float64(lookups)
+	}
+
+	// Meshes stored before references were recorded count their own anchor
+	query := `
+		FOR m IN @@collection
+		FILTER m.hash != null
+		LET ref_count = NOT_NULL(m.ref_count, 1)
+		SORT ref_count DESC, m.hash
+		LIMIT @limit
+		RETURN { hash: m.hash, mesh_id: m.id, ref_count: ref_count }
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+		"limit":       limit,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to rank mesh hashes", err)
+	}
+	defer cursor.Close()
+
+	for {
+		var hash api.DedupHash
+		_, err := cursor.ReadDocument(ctx, &hash)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, databaseError("failed to read mesh hash", err)
+		}
+
+		_, hash.Cached = r.meshHashCache.peek(hash.Hash)
+		stats.TopHashes = append(stats.TopHashes, hash)
+	}
+	return stats, nil
+}
+
+// RebuildDedupCache replaces the dedup cache with the hashes of the stored
+// meshes, dropping entries for meshes that are gone. The cache is swapped
+// once the scan completes, so ingests keep deduplicating meanwhile; hashes
+// reserved by ingests still in flight are dropped with it, which at worst
+// stores their geometry twice.
+func (r *Repository) RebuildDedupCache(ctx context.Context) (*api.DedupRebuildResponse, error) {
+	startTime := time.Now()
+
+	meshIDs := make(map[string]string)
+	err := r.scanMeshHashes(ctx, func(hash, meshID string) {
+		if _, exists := meshIDs[hash]; !exists {
+			meshIDs[hash] = meshID
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	previous := r.meshHashCache.replace(meshIDs)
+	r.updateCacheSize()
+	r.log(ctx).Infof("Rebuilt mesh dedup cache with %d hashes, replacing %d", len(meshIDs), previous)
+
+	return &api.DedupRebuildResponse{
+		PreviousEntries: previous,
+		Entries:         len(meshIDs),
+		DurationMs:      time.Since(startTime).Milliseconds(),
+	}, nil
+}
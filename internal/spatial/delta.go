@@ -0,0 +1,260 @@
+package spatial
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Delta wire format
+//
+// A delta is a little-endian binary patch applied to the buffers of a base
+// mesh. It starts with a header followed by a sequence of operations:
+//
+//	magic   [4]byte  "SMD1"
+//	count   uint32   number of operations
+//	ops     count x {
+//	    target uint8   buffer to patch (vertices, faces, normals)
+//	    op     uint8   modify, insert or remove
+//	    offset uint32  byte offset into the target buffer
+//	    length uint32  number of bytes affected
+//	    data   [length]byte (modify and insert only)
+//	}
+//
+// Operations are applied in order, so each offset refers to the target buffer
+// as left by the previous operations.
+const (
+	deltaMagic      = "SMD1"
+	deltaHeaderSize = 8
+	deltaOpHeader   = 10
+)
+
+// Delta buffer targets
+const (
+	deltaTargetVertices byte = iota
+	deltaTargetFaces
+	deltaTargetNormals
+)
+
+// Delta operations
+const (
+	deltaOpModify byte = iota + 1 // Overwrite bytes in place
+	deltaOpInsert                 // Insert bytes at offset
+	deltaOpRemove                 // Remove a byte range
+)
+
+// deltaOp is a single patch operation on one mesh buffer
+type deltaOp struct {
+	Target byte
+	Op     byte
+	Offset uint32
+	Length uint32
+	Data   []byte
+}
+
+// meshDelta is a decoded delta patch
+type meshDelta struct {
+	Ops []deltaOp
+}
+
+// encodeMeshDelta serializes a delta to its binary wire format
+func encodeMeshDelta(delta *meshDelta) []byte {
+	size := deltaHeaderSize
+	for _, op := range delta.Ops {
+		size += deltaOpHeader + len(op.Data)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, deltaMagic...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(delta.Ops)))
+	for _, op := range delta.Ops {
+		buf = append(buf, op.Target, op.Op)
+		buf = binary.LittleEndian.AppendUint32(buf, op.Offset)
+		buf = binary.LittleEndian.AppendUint32(buf, op.Length)
+		buf = append(buf, op.Data...)
+	}
+
+	return buf
+}
+
+// decodeMeshDelta parses a binary delta and validates its structure
+func decodeMeshDelta(data []byte) (*meshDelta, error) {
+	if len(data) < deltaHeaderSize || string(data[:4]) != deltaMagic {
+		return nil, errors.ValidationError("invalid delta header")
+	}
+
+	count := binary.LittleEndian.Uint32(data[4:8])
+	pos := deltaHeaderSize
+
+	delta := &meshDelta{}
+	for i := uint32(0); i < count; i++ {
+		if len(data)-pos < deltaOpHeader {
+			return nil, errors.ValidationError(fmt.Sprintf("delta truncated at operation %d", i))
+		}
+
+		op := deltaOp{
+			Target: data[pos],
+			Op:     data[pos+1],
+			Offset: binary.LittleEndian.Uint32(data[pos+2 : pos+6]),
+			Length: binary.LittleEndian.Uint32(data[pos+6 : pos+10]),
+		}
+		pos += deltaOpHeader
+
+		if op.Target > deltaTargetNormals {
+			return nil, errors.ValidationError(fmt.Sprintf("delta operation %d has unknown target %d", i, op.Target))
+		}
+
+		switch op.Op {
+		case deltaOpModify, deltaOpInsert:
+			if uint64(len(data)-pos) < uint64(op.Length) {
+				return nil, errors.ValidationError(fmt.Sprintf("delta truncated in operation %d payload", i))
+			}
+			op.Data = data[pos : pos+int(op.Length)]
+			pos += int(op.Length)
+		case deltaOpRemove:
+		default:
+			return nil, errors.ValidationError(fmt.Sprintf("delta operation %d has unknown op %d", i, op.Op))
+		}
+
+		delta.Ops = append(delta.Ops, op)
+	}
+
+	if pos != len(data) {
+		return nil, errors.ValidationError("delta has trailing bytes")
+	}
+
+	return delta, nil
+}
+
+// applyMeshDelta applies a delta onto a copy of the base mesh buffers
+func applyMeshDelta(base *api.Mesh, delta *meshDelta) (*api.Mesh, error) {
+	result := *base
+	buffers := [][]byte{
+		append([]byte(nil), base.Vertices...),
+		append([]byte(nil), base.Faces...),
+		append([]byte(nil), base.Normals...),
+	}
+
+	for i, op := range delta.Ops {
+		buf := buffers[op.Target]
+		offset := uint64(op.Offset)
+		end := offset + uint64(op.Length)
+
+		switch op.Op {
+		case deltaOpModify:
+			if end > uint64(len(buf)) {
+				return nil, errors.ValidationError(fmt.Sprintf("delta operation %d modifies bytes %d-%d outside base buffer of %d bytes",
+					i, offset, end, len(buf)))
+			}
+			copy(buf[offset:end], op.Data)
+		case deltaOpInsert:
+			if offset > uint64(len(buf)) {
+				return nil, errors.ValidationError(fmt.Sprintf("delta operation %d inserts at offset %d outside base buffer of %d bytes",
+					i, offset, len(buf)))
+			}
+			patched := make([]byte, 0, len(buf)+len(op.Data))
+			patched = append(patched, buf[:offset]...)
+			patched = append(patched, op.Data...)
+			buf = append(patched, buf[offset:]...)
+		case deltaOpRemove:
+			if end > uint64(len(buf)) {
+				return nil, errors.ValidationError(fmt.Sprintf("delta operation %d removes bytes %d-%d outside base buffer of %d bytes",
+					i, offset, end, len(buf)))
+			}
+			buf = append(buf[:offset], buf[end:]...)
+		}
+
+		buffers[op.Target] = buf
+	}
+
+	result.Vertices = buffers[deltaTargetVertices]
+	result.Faces = buffers[deltaTargetFaces]
+	result.Normals = buffers[deltaTargetNormals]
+
+	return &result, nil
+}
+
+// diffMeshBuffers computes the delta that applyMeshDelta turns base into
+// target. Each buffer keeps its common prefix and suffix; the bytes between
+// are overwritten in runs, with the remainder inserted or removed at the end.
+// Runs of differing bytes closer than an operation header are merged, as a
+// new operation would cost more than rewriting the equal bytes between them.
+func diffMeshBuffers(base, target *api.Mesh) *meshDelta {
+	delta := &meshDelta{}
+	pairs := [][2][]byte{
+		deltaTargetVertices: {base.Vertices, target.Vertices},
+		deltaTargetFaces:    {base.Faces, target.Faces},
+		deltaTargetNormals:  {base.Normals, target.Normals},
+	}
+	for buffer, pair := range pairs {
+		delta.Ops = append(delta.Ops, diffBuffer(byte(buffer), pair[0], pair[1])...)
+	}
+	return delta
+}
+
+// diffBuffer computes the operations turning one buffer into another
+func diffBuffer(target byte, from, to []byte) []deltaOp {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	oldMiddle := from[prefix : len(from)-suffix]
+	newMiddle := to[prefix : len(to)-suffix]
+
+	var ops []deltaOp
+	modify := func(start, end int) {
+		ops = append(ops, deltaOp{
+			Target: target,
+			Op:     deltaOpModify,
+			Offset: uint32(prefix + start),
+			Length: uint32(end - start),
+			Data:   newMiddle[start:end],
+		})
+	}
+
+	// Overwrite the differing runs of the overlapping part
+	overlap := min(len(oldMiddle), len(newMiddle))
+	runStart, runEnd := -1, -1
+	for i := 0; i < overlap; i++ {
+		if oldMiddle[i] == newMiddle[i] {
+			continue
+		}
+		if runStart >= 0 && i-runEnd >= deltaOpHeader {
+			modify(runStart, runEnd)
+			runStart = -1
+		}
+		if runStart < 0 {
+			runStart = i
+		}
+		runEnd = i + 1
+	}
+	if runStart >= 0 {
+		modify(runStart, runEnd)
+	}
+
+	// Then grow or shrink the buffer after it
+	switch {
+	case len(newMiddle) > overlap:
+		ops = append(ops, deltaOp{
+			Target: target,
+			Op:     deltaOpInsert,
+			Offset: uint32(prefix + overlap),
+			Length: uint32(len(newMiddle) - overlap),
+			Data:   newMiddle[overlap:],
+		})
+	case len(oldMiddle) > overlap:
+		ops = append(ops, deltaOp{
+			Target: target,
+			Op:     deltaOpRemove,
+			Offset: uint32(prefix + overlap),
+			Length: uint32(len(oldMiddle) - overlap),
+		})
+	}
+	return ops
+}
@@ -0,0 +1,198 @@
+package spatial
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func TestDeltaEncodeDecode(t *testing.T) {
+	delta := &meshDelta{
+		Ops: []deltaOp{
+			{Target: deltaTargetVertices, Op: deltaOpModify, Offset: 2, Length: 2, Data: []byte{9, 9}},
+			{Target: deltaTargetFaces, Op: deltaOpRemove, Offset: 0, Length: 3},
+			{Target: deltaTargetNormals, Op: deltaOpInsert, Offset: 0, Length: 1, Data: []byte{7}},
+		},
+	}
+
+	decoded, err := decodeMeshDelta(encodeMeshDelta(delta))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(decoded.Ops) != len(delta.Ops) {
+		t.Fatalf("Expected %d ops, got %d", len(delta.Ops), len(decoded.Ops))
+	}
+	for i, op := range decoded.Ops {
+		want := delta.Ops[i]
+		if op.Target != want.Target || op.Op != want.Op || op.Offset != want.Offset ||
+			op.Length != want.Length || !bytes.Equal(op.Data, want.Data) {
+			t.Errorf("Op %d mismatch: got %+v, want %+v", i, op, want)
+		}
+	}
+
+	// Truncated and malformed payloads should be rejected
+	encoded := encodeMeshDelta(delta)
+	if _, err := decodeMeshDelta(encoded[:len(encoded)-1]); err == nil {
+		t.Error("Expected error for truncated delta")
+	}
+	if _, err := decodeMeshDelta([]byte{10, 11, 12}); err == nil {
+		t.Error("Expected error for invalid delta header")
+	}
+}
+
+func TestApplyMeshDelta(t *testing.T) {
+	base := &api.Mesh{
+		ID:       "base",
+		Vertices: []byte{1, 2, 3, 4, 5, 6},
+		Faces:    []byte{0, 1, 2, 3, 4, 5},
+	}
+
+	delta := &meshDelta{
+		Ops: []deltaOp{
+			{Target: deltaTargetVertices, Op: deltaOpModify, Offset: 0, Length: 2, Data: []byte{10, 20}},
+			{Target: deltaTargetVertices, Op: deltaOpInsert, Offset: 6, Length: 3, Data: []byte{7, 8, 9}},
+			{Target: deltaTargetFaces, Op: deltaOpRemove, Offset: 3, Length: 3},
+			{Target: deltaTargetNormals, Op: deltaOpInsert, Offset: 0, Length: 2, Data: []byte{1, 1}},
+		},
+	}
+
+	result, err := applyMeshDelta(base, delta)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(result.Vertices, []byte{10, 20, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("Unexpected vertices: %v", result.Vertices)
+	}
+	if !bytes.Equal(result.Faces, []byte{0, 1, 2}) {
+		t.Errorf("Unexpected faces: %v", result.Faces)
+	}
+	if !bytes.Equal(result.Normals, []byte{1, 1}) {
+		t.Errorf("Unexpected normals: %v", result.Normals)
+	}
+
+	// Base mesh must not be mutated
+	if !bytes.Equal(base.Vertices, []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("Base vertices were mutated: %v", base.Vertices)
+	}
+
+	// Chained delta applies on top of the previous result
+	chained := &meshDelta{
+		Ops: []deltaOp{
+			{Target: deltaTargetVertices, Op: deltaOpRemove, Offset: 6, Length: 3},
+		},
+	}
+	result, err = applyMeshDelta(result, chained)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(result.Vertices, []byte{10, 20, 3, 4, 5, 6}) {
+		t.Errorf("Unexpected chained vertices: %v", result.Vertices)
+	}
+}
+
+func TestApplyMeshDeltaOutOfBounds(t *testing.T) {
+	base := &api.Mesh{
+		ID:       "base",
+		Vertices: []byte{1, 2, 3},
+	}
+
+	cases := map[string]deltaOp{
+		"modify": {Target: deltaTargetVertices, Op: deltaOpModify, Offset: 2, Length: 2, Data: []byte{0, 0}},
+		"insert": {Target: deltaTargetVertices, Op: deltaOpInsert, Offset: 4, Length: 1, Data: []byte{0}},
+		"remove": {Target: deltaTargetFaces, Op: deltaOpRemove, Offset: 0, Length: 1},
+	}
+
+	for name, op := range cases {
+		if _, err := applyMeshDelta(base, &meshDelta{Ops: []deltaOp{op}}); err == nil {
+			t.Errorf("%s: expected out-of-bounds error", name)
+		}
+	}
+}
+
+func TestDiffMeshBuffersRoundTrip(t *testing.T) {
+	// A base of 64 vertices with normals, and 20 triangles
+	base := &api.Mesh{ID: "base"}
+	for i := 0; i < 64*12; i++ {
+		base.Vertices = append(base.Vertices, byte(i*7))
+		base.Normals = append(base.Normals, byte(i*3))
+	}
+	for i := 0; i < 20*3; i++ {
+		base.Faces = append(base.Faces, byte(i%64), 0, 0, 0)
+	}
+
+	modified := func(edit func(m *api.Mesh)) *api.Mesh {
+		m := &api.Mesh{
+			Vertices: append([]byte(nil), base.Vertices...),
+			Faces:    append([]byte(nil), base.Faces...),
+			Normals:  append([]byte(nil), base.Normals...),
+		}
+		edit(m)
+		return m
+	}
+
+	tests := []struct {
+		name   string
+		target *api.Mesh
+	}{
+		{"Unchanged", modified(func(m *api.Mesh) {})},
+		{"MovedVertices", modified(func(m *api.Mesh) {
+			m.Vertices[13], m.Vertices[14] = 1, 2
+			m.Vertices[400] = 9
+			m.Vertices[405] = 9 // Close enough to merge with the previous run
+		})},
+		{"AddedVertices", modified(func(m *api.Mesh) {
+			m.Vertices = append(m.Vertices, bytes.Repeat([]byte{5}, 24)...)
+			m.Normals = append(m.Normals[:100], append(bytes.Repeat([]byte{6}, 24), m.Normals[100:]...)...)
+		})},
+		{"RemovedFaces", modified(func(m *api.Mesh) {
+			m.Faces = append(m.Faces[:12], m.Faces[48:]...)
+		})},
+		{"DroppedNormals", modified(func(m *api.Mesh) { m.Normals = nil })},
+		{"Replaced", &api.Mesh{Vertices: bytes.Repeat([]byte{1}, 36), Faces: []byte{0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}}},
+		{"FromEmpty", &api.Mesh{Vertices: []byte{1, 2, 3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := base
+			if tt.name == "FromEmpty" {
+				from = &api.Mesh{ID: "empty"}
+			}
+
+			delta := diffMeshBuffers(from, tt.target)
+			decoded, err := decodeMeshDelta(encodeMeshDelta(delta))
+			if err != nil {
+				t.Fatalf("Generated delta does not decode: %v", err)
+			}
+			result, err := applyMeshDelta(from, decoded)
+			if err != nil {
+				t.Fatalf("Generated delta does not apply: %v", err)
+			}
+
+			if !bytes.Equal(result.Vertices, tt.target.Vertices) {
+				t.Error("Expected the vertices reconstructed exactly")
+			}
+			if !bytes.Equal(result.Faces, tt.target.Faces) {
+				t.Error("Expected the faces reconstructed exactly")
+			}
+			if !bytes.Equal(result.Normals, tt.target.Normals) {
+				t.Error("Expected the normals reconstructed exactly")
+			}
+		})
+	}
+
+	// Small edits produce small deltas, with nearby runs merged
+	delta := diffMeshBuffers(base, tests[1].target)
+	if len(delta.Ops) != 2 {
+		t.Errorf("Expected 2 modify operations, got %+v", delta.Ops)
+	}
+	if size := len(encodeMeshDelta(delta)); size > 64 {
+		t.Errorf("Expected a small delta for a few moved bytes, got %d bytes", size)
+	}
+	if ops := diffMeshBuffers(base, tests[0].target).Ops; len(ops) != 0 {
+		t.Errorf("Expected no operations for an unchanged mesh, got %+v", ops)
+	}
+}
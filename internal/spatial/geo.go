@@ -0,0 +1,94 @@
+package spatial
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// maxPolygonVertices bounds the size of a polygon query
+const maxPolygonVertices = 1000
+
+// geoPoint is a GeoJSON point
+type geoPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // Longitude, latitude
+}
+
+// poseLocation returns the GeoJSON point indexing a pose's floor position,
+// converted from meters as described by database.MetersPerDegree
+func poseLocation(pose api.Pose) geoPoint {
+	return geoPoint{
+		Type:        "Point",
+		Coordinates: [2]float64{pose.X / database.MetersPerDegree, pose.Y / database.MetersPerDegree},
+	}
+}
+
+// anchorDocument is an anchor as stored, with its indexed location
+type anchorDocument struct {
+	*api.Anchor
+	Location geoPoint `json:"location"`
+}
+
+// parsePolygon parses a polygon given as "x1,y1;x2,y2;..." floor coordinates
+// in meters. The ring is closed if needed and wound counterclockwise.
+func parsePolygon(raw string) ([][2]float64, error) {
+	pairs := strings.Split(strings.TrimSuffix(raw, ";"), ";")
+	if len(pairs) > maxPolygonVertices+1 {
+		return nil, errors.ValidationError(fmt.Sprintf("polygon has more than %d vertices", maxPolygonVertices))
+	}
+
+	ring := make([][2]float64, 0, len(pairs)+1)
+	for _, pair := range pairs {
+		xs, ys, ok := strings.Cut(pair, ",")
+		if !ok {
+			return nil, errors.ValidationError(fmt.Sprintf("polygon vertex %q is not x,y", pair))
+		}
+		x, errX := strconv.ParseFloat(strings.TrimSpace(xs), 64)
+		y, errY := strconv.ParseFloat(strings.TrimSpace(ys), 64)
+		if errX != nil || errY != nil || math.IsInf(x, 0) || math.IsInf(y, 0) || math.IsNaN(x) || math.IsNaN(y) {
+			return nil, errors.ValidationError(fmt.Sprintf("polygon vertex %q is not a pair of numbers", pair))
+		}
+		ring = append(ring, [2]float64{x, y})
+	}
+
+	if ring[0] != ring[len(ring)-1] {
+		ring = append(ring, ring[0])
+	}
+	if len(ring) < 4 {
+		return nil, errors.ValidationError("polygon needs at least three vertices")
+	}
+
+	// Shoelace formula: twice the signed area, negative when clockwise
+	area := 0.0
+	for i := 0; i < len(ring)-1; i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	if area == 0 {
+		return nil, errors.ValidationError("polygon has no area")
+	}
+	if area < 0 {
+		for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+			ring[i], ring[j] = ring[j], ring[i]
+		}
+	}
+
+	return ring, nil
+}
+
+// polygonGeoJSON converts a ring in meters to a GeoJSON polygon
+func polygonGeoJSON(ring [][2]float64) map[string]interface{} {
+	coordinates := make([][2]float64, len(ring))
+	for i, vertex := range ring {
+		coordinates[i] = [2]float64{vertex[0] / database.MetersPerDegree, vertex[1] / database.MetersPerDegree}
+	}
+	return map[string]interface{}{
+		"type":        "Polygon",
+		"coordinates": [][][2]float64{coordinates},
+	}
+}
@@ -0,0 +1,75 @@
+package spatial
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func TestParsePolygon(t *testing.T) {
+	// Clockwise input is closed and rewound counterclockwise
+	ring, err := parsePolygon("0,0; 0,2; 2,2; 2,0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := [][2]float64{{0, 0}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}
+	if len(ring) != len(want) {
+		t.Fatalf("Expected %d vertices, got %v", len(want), ring)
+	}
+	for i := range want {
+		if ring[i] != want[i] {
+			t.Errorf("Expected vertex %d to be %v, got %v", i, want[i], ring[i])
+		}
+	}
+
+	// An already closed ring is not closed again
+	if ring, err := parsePolygon("0,0;1,0;0,1;0,0"); err != nil || len(ring) != 4 {
+		t.Errorf("Expected closed triangle of 4 vertices, got %v (%v)", ring, err)
+	}
+
+	for _, raw := range []string{"", "0,0;1,1", "0,0;1,1;2,2", "0,0;1,0;a,b", "0,0;1;0,1", "0,0;1,0;NaN,1"} {
+		if _, err := parsePolygon(raw); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestBuildQueryPolygon(t *testing.T) {
+	repo := &Repository{}
+
+	query, bindVars, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", Polygon: "0,0;10,0;10,10"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(query, "GEO_CONTAINS(@polygon, doc.location)") {
+		t.Errorf("Expected indexed polygon filter in query: %s", query)
+	}
+
+	polygon := bindVars["polygon"].(map[string]interface{})
+	coordinates := polygon["coordinates"].([][][2]float64)
+	if polygon["type"] != "Polygon" || coordinates[0][1] != [2]float64{10 / database.MetersPerDegree, 0} {
+		t.Errorf("Unexpected polygon bind var: %v", polygon)
+	}
+
+	// Pose samples have their location computed
+	query, bindVars, err = repo.buildQuery(&api.QueryParams{SessionID: "s1", Polygon: "0,0;10,0;10,10", History: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(query, "GEO_CONTAINS(@polygon, GEO_POINT(doc.pose.x / @meters_per_degree") || bindVars["meters_per_degree"] != database.MetersPerDegree {
+		t.Errorf("Expected computed location in history query: %s", query)
+	}
+
+	if _, _, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", Polygon: "0,0;1,1"}); err == nil {
+		t.Error("Expected error for degenerate polygon")
+	}
+}
+
+func TestPoseLocation(t *testing.T) {
+	location := poseLocation(api.Pose{X: database.MetersPerDegree, Y: -database.MetersPerDegree / 2, Z: 7})
+	if location.Type != "Point" || location.Coordinates != [2]float64{1, -0.5} {
+		t.Errorf("Unexpected location %+v", location)
+	}
+}
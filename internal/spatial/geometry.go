@@ -0,0 +1,129 @@
+package spatial
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Face index formats. Stored meshes always use uint32 indices.
+const (
+	IndexFormatUint16 = "uint16"
+	IndexFormatUint32 = "uint32"
+)
+
+// maxVertexStride bounds the per-vertex attributes a mesh may carry
+const maxVertexStride = 256
+
+// meshLayout is the buffer layout a mesh declares. Each vertex starts with
+// an XYZ float32 position and may carry further attributes up to its stride;
+// faces are triangles of indexSize byte indices; normals are XYZ float32,
+// one per vertex.
+type meshLayout struct {
+	vertexStride int
+	indexSize    int
+}
+
+// layoutOf returns a mesh's declared layout, applying the packed defaults
+func layoutOf(mesh *api.Mesh) (meshLayout, error) {
+	layout := meshLayout{vertexStride: gltfVec3Stride, indexSize: gltfIndexStride}
+
+	if mesh.VertexStride != 0 {
+		if mesh.VertexStride < gltfVec3Stride || mesh.VertexStride > maxVertexStride || mesh.VertexStride%4 != 0 {
+			return layout, errors.ValidationError(fmt.Sprintf("mesh %s vertex_stride %d must be a multiple of 4 between %d and %d",
+				mesh.ID, mesh.VertexStride, gltfVec3Stride, maxVertexStride))
+		}
+		layout.vertexStride = mesh.VertexStride
+	}
+
+	switch mesh.IndexFormat {
+	case "", IndexFormatUint32:
+	case IndexFormatUint16:
+		layout.indexSize = 2
+	default:
+		return layout, errors.ValidationError(fmt.Sprintf("mesh %s index_format %q must be %s or %s",
+			mesh.ID, mesh.IndexFormat, IndexFormatUint16, IndexFormatUint32))
+	}
+
+	return layout, nil
+}
+
+// validateGeometry checks decoded buffers against the mesh's declared
+// layout: whole vertices, whole triangles with every index in range, and
+// one normal per vertex when normals are present
+func validateGeometry(mesh *api.Mesh) error {
+	layout, err := layoutOf(mesh)
+	if err != nil {
+		return err
+	}
+
+	if len(mesh.Vertices) == 0 {
+		return errors.ValidationError(fmt.Sprintf("mesh %s has no vertices", mesh.ID))
+	}
+	if len(mesh.Vertices)%layout.vertexStride != 0 {
+		return errors.ValidationError(fmt.Sprintf("mesh %s vertices are %d bytes, not a multiple of the %d byte vertex stride",
+			mesh.ID, len(mesh.Vertices), layout.vertexStride))
+	}
+	vertexCount := len(mesh.Vertices) / layout.vertexStride
+
+	if len(mesh.Normals) != 0 && len(mesh.Normals) != vertexCount*gltfVec3Stride {
+		return errors.ValidationError(fmt.Sprintf("mesh %s normals are %d bytes, expected %d for %d vertices",
+			mesh.ID, len(mesh.Normals), vertexCount*gltfVec3Stride, vertexCount))
+	}
+
+	triangleSize := 3 * layout.indexSize
+	if len(mesh.Faces)%triangleSize != 0 {
+		return errors.ValidationError(fmt.Sprintf("mesh %s faces are %d bytes, not a whole number of %d byte triangles",
+			mesh.ID, len(mesh.Faces), triangleSize))
+	}
+	for i := 0; i < len(mesh.Faces); i += layout.indexSize {
+		if index := readIndex(mesh.Faces[i:], layout.indexSize); index >= vertexCount {
+			return errors.ValidationError(fmt.Sprintf("mesh %s face index %d in triangle %d is out of range for %d vertices",
+				mesh.ID, index, i/triangleSize, vertexCount))
+		}
+	}
+
+	return nil
+}
+
+// readIndex reads one little-endian face index of size bytes
+func readIndex(data []byte, size int) int {
+	if size == 2 {
+		return int(binary.LittleEndian.Uint16(data))
+	}
+	return int(binary.LittleEndian.Uint32(data))
+}
+
+// normalizeLayout rewrites validated geometry into the stored layout:
+// uint16 indices are widened to uint32 and default layout fields are cleared
+func normalizeLayout(mesh *api.Mesh) {
+	if mesh.IndexFormat == IndexFormatUint16 {
+		faces := make([]byte, 0, len(mesh.Faces)*2)
+		for i := 0; i+2 <= len(mesh.Faces); i += 2 {
+			faces = binary.LittleEndian.AppendUint32(faces, uint32(binary.LittleEndian.Uint16(mesh.Faces[i:])))
+		}
+		mesh.Faces = faces
+	}
+	mesh.IndexFormat = ""
+
+	if mesh.VertexStride == gltfVec3Stride {
+		mesh.VertexStride = 0
+	}
+}
+
+// meshPositions returns a decoded mesh's vertex positions packed as XYZ
+// float32, dropping any further per-vertex attributes
+func meshPositions(mesh *api.Mesh) []byte {
+	stride := mesh.VertexStride
+	if stride == 0 || stride == gltfVec3Stride {
+		return mesh.Vertices
+	}
+
+	positions := make([]byte, 0, len(mesh.Vertices)/stride*gltfVec3Stride)
+	for i := 0; i+stride <= len(mesh.Vertices); i += stride {
+		positions = append(positions, mesh.Vertices[i:i+gltfVec3Stride]...)
+	}
+	return positions
+}
@@ -0,0 +1,126 @@
+package spatial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// uint16Faces packs triangle indices as uint16
+func uint16Faces(indices ...uint16) []byte {
+	var faces []byte
+	for _, i := range indices {
+		faces = binary.LittleEndian.AppendUint16(faces, i)
+	}
+	return faces
+}
+
+// interleave appends extra zero bytes to each packed XYZ vertex
+func interleave(positions []byte, extra int) []byte {
+	var vertices []byte
+	for i := 0; i < len(positions); i += gltfVec3Stride {
+		vertices = append(vertices, positions[i:i+gltfVec3Stride]...)
+		vertices = append(vertices, make([]byte, extra)...)
+	}
+	return vertices
+}
+
+func TestValidateGeometry(t *testing.T) {
+	valid := triangleMesh()
+
+	with := func(change func(m *api.Mesh)) *api.Mesh {
+		mesh := *valid
+		change(&mesh)
+		return &mesh
+	}
+
+	tests := []struct {
+		name    string
+		mesh    *api.Mesh
+		wantErr string
+	}{
+		{"Packed", valid, ""},
+		{"PointCloud", with(func(m *api.Mesh) { m.Faces = nil }), ""},
+		{"Normals", with(func(m *api.Mesh) { m.Normals = m.Vertices }), ""},
+		{"Uint16", with(func(m *api.Mesh) { m.IndexFormat = IndexFormatUint16; m.Faces = uint16Faces(0, 1, 2) }), ""},
+		{"Interleaved", with(func(m *api.Mesh) { m.VertexStride = 20; m.Vertices = interleave(m.Vertices, 8) }), ""},
+		{"NoVertices", with(func(m *api.Mesh) { m.Vertices = nil }), "no vertices"},
+		{"PartialVertex", with(func(m *api.Mesh) { m.Vertices = m.Vertices[:30] }), "not a multiple of the 12 byte vertex stride"},
+		{"StrideMismatch", with(func(m *api.Mesh) { m.VertexStride = 16 }), "not a multiple of the 16 byte vertex stride"},
+		{"BadStride", with(func(m *api.Mesh) { m.VertexStride = 14 }), "vertex_stride 14"},
+		{"BadIndexFormat", with(func(m *api.Mesh) { m.IndexFormat = "uint8" }), `index_format "uint8"`},
+		{"PartialTriangle", with(func(m *api.Mesh) { m.Faces = m.Faces[:8] }), "not a whole number of 12 byte triangles"},
+		{"Uint16OutOfRange", with(func(m *api.Mesh) { m.IndexFormat = IndexFormatUint16; m.Faces = uint16Faces(0, 1, 5) }),
+			"face index 5 in triangle 0 is out of range for 3 vertices"},
+		{"OutOfRange", with(func(m *api.Mesh) { m.Faces = append(append([]byte{}, m.Faces...), 0, 0, 0, 0, 1, 0, 0, 0, 3, 0, 0, 0) }),
+			"face index 3 in triangle 1 is out of range for 3 vertices"},
+		{"NormalsCount", with(func(m *api.Mesh) { m.Normals = m.Vertices[:24] }), "normals are 24 bytes, expected 36 for 3 vertices"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateGeometry(tt.mesh)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			apiErr, ok := errors.IsAPIError(err)
+			if !ok || apiErr.Code != "VALIDATION_ERROR" {
+				t.Fatalf("Expected validation error, got %v", err)
+			}
+			if !strings.Contains(apiErr.Message, tt.wantErr) {
+				t.Errorf("Expected %q in %q", tt.wantErr, apiErr.Message)
+			}
+		})
+	}
+}
+
+func TestNormalizeLayout(t *testing.T) {
+	packed := triangleMesh()
+
+	mesh := triangleMesh()
+	mesh.VertexStride = gltfVec3Stride
+	mesh.IndexFormat = IndexFormatUint16
+	mesh.Faces = uint16Faces(0, 1, 2)
+	normalizeLayout(mesh)
+
+	if mesh.IndexFormat != "" || mesh.VertexStride != 0 {
+		t.Errorf("Expected default layout fields cleared, got %q and %d", mesh.IndexFormat, mesh.VertexStride)
+	}
+	if !bytes.Equal(mesh.Faces, packed.Faces) {
+		t.Errorf("Expected uint16 indices widened to uint32, got %v", mesh.Faces)
+	}
+
+	repo := &Repository{}
+	if repo.computeMeshHash(mesh) != repo.computeMeshHash(packed) {
+		t.Error("Expected the same triangle to hash alike regardless of declared index width")
+	}
+}
+
+func TestMeshPositions(t *testing.T) {
+	packed := triangleMesh()
+	if positions := meshPositions(packed); !bytes.Equal(positions, packed.Vertices) {
+		t.Error("Expected packed vertices returned as is")
+	}
+
+	interleaved := triangleMesh()
+	interleaved.VertexStride = 24
+	interleaved.Vertices = interleave(packed.Vertices, 12)
+	if positions := meshPositions(interleaved); !bytes.Equal(positions, packed.Vertices) {
+		t.Errorf("Expected positions extracted from the interleaved vertices, got %v", positions)
+	}
+
+	repo := &Repository{}
+	stride := *packed
+	stride.VertexStride = 24
+	if repo.computeMeshHash(&stride) == repo.computeMeshHash(packed) {
+		t.Error("Expected the same bytes at another stride to hash differently")
+	}
+}
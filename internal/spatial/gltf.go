@@ -0,0 +1,322 @@
+package spatial
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// glTF constants
+const (
+	gltfComponentFloat       = 5126
+	gltfComponentUnsignedInt = 5125
+	gltfTargetArrayBuffer    = 34962
+	gltfTargetElementBuffer  = 34963
+	gltfModeTriangles        = 4
+
+	glbMagic     = 0x46546C67 // "glTF"
+	glbVersion   = 2
+	glbChunkJSON = 0x4E4F534A // "JSON"
+	glbChunkBIN  = 0x004E4942 // "BIN\0"
+)
+
+// Exported geometry layout: vertices and normals are tightly packed
+// little-endian float32 XYZ, faces are little-endian uint32 triangle indices
+const (
+	gltfVec3Stride  = 12
+	gltfIndexStride = 4
+)
+
+type gltfDocument struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+}
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+
+type gltfScene struct {
+	Name  string `json:"name,omitempty"`
+	Nodes []int  `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name        string    `json:"name,omitempty"`
+	Mesh        int       `json:"mesh"`
+	Translation []float64 `json:"translation,omitempty"`
+	Rotation    []float64 `json:"rotation,omitempty"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name,omitempty"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices,omitempty"`
+	Mode       int            `json:"mode"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float64 `json:"min,omitempty"`
+	Max           []float64 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target,omitempty"`
+}
+
+type gltfBuffer struct {
+	ByteLength int    `json:"byteLength"`
+	URI        string `json:"uri,omitempty"`
+}
+
+// gltfBuilder accumulates nodes and binary data for a glTF document
+type gltfBuilder struct {
+	doc gltfDocument
+	bin bytes.Buffer
+}
+
+// ExportSessionGLTF assembles all meshes of a session into a glTF 2.0 document,
+// returning JSON glTF with an embedded buffer or a binary GLB container
+func (r *Repository) ExportSessionGLTF(ctx context.Context, sessionID string, binaryGLB bool) ([]byte, error) {
+	anchors, err := r.loadSessionAnchors(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(anchors) == 0 {
+		return nil, errors.NotFound(fmt.Sprintf("session %s has no meshes", sessionID))
+	}
+
+	meshes, err := r.loadMeshesForAnchors(ctx, anchors)
+	if err != nil {
+		return nil, err
+	}
+
+	poses := make(map[string]api.Pose, len(anchors))
+	for _, anchor := range anchors {
+		poses[anchor.ID] = anchor.Pose
+	}
+
+	builder := newGLTFBuilder(sessionID)
+	for _, mesh := range meshes {
+		// Unresolved deltas and geometry the server cannot decode are not exported
+		if mesh.IsDelta || isOpaqueCodec(mesh.CompressionCodec) {
+			continue
+		}
+
+		decoded, err := decodeMeshBuffers(&mesh)
+		if err != nil {
+			r.log(ctx).Warnf("Skipping mesh %s in glTF export: %v", mesh.ID, err)
+			continue
+		}
+
+		if err := builder.addMesh(decoded, poses[mesh.AnchorID]); err != nil {
+			r.log(ctx).Warnf("Skipping mesh %s in glTF export: %v", mesh.ID, err)
+		}
+	}
+
+	if len(builder.doc.Meshes) == 0 {
+		return nil, errors.NotFound(fmt.Sprintf("session %s has no meshes", sessionID))
+	}
+
+	if binaryGLB {
+		return builder.encodeGLB()
+	}
+	return builder.encodeGLTF()
+}
+
+// loadSessionAnchors loads every anchor in a session
+func (r *Repository) loadSessionAnchors(ctx context.Context, sessionID string) ([]api.Anchor, error) {
+	query := `
+		FOR doc IN @@collection
+		FILTER doc.session_id == @session_id
+		RETURN doc
+	`
+
+	bindVars := map[string]interface{}{
+		"@collection": database.AnchorsCollection,
+		"session_id":  sessionID,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to query session anchors", err)
+	}
+	defer cursor.Close()
+
+	var anchors []api.Anchor
+	for {
+		var anchor api.Anchor
+		_, err := cursor.ReadDocument(ctx, &anchor)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, databaseError("failed to read anchor", err)
+		}
+		anchors = append(anchors, anchor)
+	}
+
+	return anchors, nil
+}
+
+// newGLTFBuilder creates a builder with a single scene
+func newGLTFBuilder(name string) *gltfBuilder {
+	return &gltfBuilder{
+		doc: gltfDocument{
+			Asset:  gltfAsset{Version: "2.0", Generator: "STAG v2"},
+			Scenes: []gltfScene{{Name: name, Nodes: []int{}}},
+		},
+	}
+}
+
+// addMesh appends a decoded mesh as a node positioned by its anchor pose
+func (b *gltfBuilder) addMesh(mesh *api.Mesh, pose api.Pose) error {
+	positions := meshPositions(mesh)
+	if len(positions) == 0 || len(positions)%gltfVec3Stride != 0 {
+		return fmt.Errorf("vertex buffer of %d bytes is not float32 XYZ", len(mesh.Vertices))
+	}
+	if len(mesh.Faces)%gltfIndexStride != 0 {
+		return fmt.Errorf("face buffer of %d bytes is not uint32 indices", len(mesh.Faces))
+	}
+
+	vertexCount := len(positions) / gltfVec3Stride
+	minPos, maxPos := vec3Bounds(positions)
+
+	primitive := gltfPrimitive{
+		Attributes: map[string]int{
+			"POSITION": b.addAccessor(positions, gltfTargetArrayBuffer, gltfComponentFloat, vertexCount, "VEC3", minPos, maxPos),
+		},
+		Mode: gltfModeTriangles,
+	}
+
+	if len(mesh.Normals) == len(positions) {
+		primitive.Attributes["NORMAL"] = b.addAccessor(mesh.Normals, gltfTargetArrayBuffer, gltfComponentFloat, vertexCount, "VEC3", nil, nil)
+	}
+
+	if len(mesh.Faces) > 0 {
+		indices := b.addAccessor(mesh.Faces, gltfTargetElementBuffer, gltfComponentUnsignedInt, len(mesh.Faces)/gltfIndexStride, "SCALAR", nil, nil)
+		primitive.Indices = &indices
+	}
+
+	b.doc.Meshes = append(b.doc.Meshes, gltfMesh{Name: mesh.ID, Primitives: []gltfPrimitive{primitive}})
+
+	node := gltfNode{
+		Name:        mesh.AnchorID,
+		Mesh:        len(b.doc.Meshes) - 1,
+		Translation: []float64{pose.X, pose.Y, pose.Z},
+	}
+	if len(pose.Rotation) == 4 {
+		node.Rotation = pose.Rotation // glTF and poses both use [x, y, z, w]
+	}
+	b.doc.Nodes = append(b.doc.Nodes, node)
+	b.doc.Scenes[0].Nodes = append(b.doc.Scenes[0].Nodes, len(b.doc.Nodes)-1)
+
+	return nil
+}
+
+// addAccessor appends data to the binary buffer and returns its accessor index
+func (b *gltfBuilder) addAccessor(data []byte, target, componentType, count int, accessorType string, min, max []float64) int {
+	b.doc.BufferViews = append(b.doc.BufferViews, gltfBufferView{
+		Buffer:     0,
+		ByteOffset: b.bin.Len(),
+		ByteLength: len(data),
+		Target:     target,
+	})
+	b.bin.Write(data)
+
+	b.doc.Accessors = append(b.doc.Accessors, gltfAccessor{
+		BufferView:    len(b.doc.BufferViews) - 1,
+		ComponentType: componentType,
+		Count:         count,
+		Type:          accessorType,
+		Min:           min,
+		Max:           max,
+	})
+	return len(b.doc.Accessors) - 1
+}
+
+// encodeGLTF renders a JSON glTF with the buffer embedded as a data URI
+func (b *gltfBuilder) encodeGLTF() ([]byte, error) {
+	b.doc.Buffers = []gltfBuffer{{
+		ByteLength: b.bin.Len(),
+		URI:        "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(b.bin.Bytes()),
+	}}
+	return json.Marshal(b.doc)
+}
+
+// encodeGLB renders a binary GLB container with JSON and BIN chunks
+func (b *gltfBuilder) encodeGLB() ([]byte, error) {
+	b.doc.Buffers = []gltfBuffer{{ByteLength: b.bin.Len()}}
+
+	jsonChunk, err := json.Marshal(b.doc)
+	if err != nil {
+		return nil, err
+	}
+	jsonChunk = padChunk(jsonChunk, ' ')
+	binChunk := padChunk(b.bin.Bytes(), 0)
+
+	total := 12 + 8 + len(jsonChunk) + 8 + len(binChunk)
+	out := make([]byte, 0, total)
+	out = binary.LittleEndian.AppendUint32(out, glbMagic)
+	out = binary.LittleEndian.AppendUint32(out, glbVersion)
+	out = binary.LittleEndian.AppendUint32(out, uint32(total))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(jsonChunk)))
+	out = binary.LittleEndian.AppendUint32(out, glbChunkJSON)
+	out = append(out, jsonChunk...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(binChunk)))
+	out = binary.LittleEndian.AppendUint32(out, glbChunkBIN)
+	out = append(out, binChunk...)
+
+	return out, nil
+}
+
+// padChunk pads a GLB chunk to a 4-byte boundary
+func padChunk(data []byte, pad byte) []byte {
+	for len(data)%4 != 0 {
+		data = append(data, pad)
+	}
+	return data
+}
+
+// vec3Bounds computes the per-axis min and max of packed float32 XYZ data
+func vec3Bounds(data []byte) ([]float64, []float64) {
+	min := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+
+	for i := 0; i+gltfVec3Stride <= len(data); i += gltfVec3Stride {
+		for axis := 0; axis < 3; axis++ {
+			v := float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i+axis*4:])))
+			min[axis] = math.Min(min[axis], v)
+			max[axis] = math.Max(max[axis], v)
+		}
+	}
+
+	return min, max
+}
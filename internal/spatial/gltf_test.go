@@ -0,0 +1,121 @@
+package spatial
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func triangleMesh() *api.Mesh {
+	var vertices []byte
+	for _, v := range []float32{0, 0, 0, 1, 0, 0, 0, 2, -1} {
+		vertices = binary.LittleEndian.AppendUint32(vertices, math.Float32bits(v))
+	}
+	var faces []byte
+	for _, i := range []uint32{0, 1, 2} {
+		faces = binary.LittleEndian.AppendUint32(faces, i)
+	}
+	return &api.Mesh{ID: "mesh-1", AnchorID: "anchor-1", Vertices: vertices, Faces: faces}
+}
+
+func TestGLTFBuilder(t *testing.T) {
+	pose := api.Pose{X: 1, Y: 2, Z: 3, Rotation: []float64{0, 0, 0, 1}}
+
+	t.Run("JSON", func(t *testing.T) {
+		builder := newGLTFBuilder("session-1")
+		if err := builder.addMesh(triangleMesh(), pose); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		data, err := builder.encodeGLTF()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		var doc gltfDocument
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("Invalid glTF JSON: %v", err)
+		}
+		if doc.Asset.Version != "2.0" {
+			t.Errorf("Expected glTF 2.0, got %s", doc.Asset.Version)
+		}
+		if len(doc.Nodes) != 1 {
+			t.Fatalf("Expected 1 node, got %d", len(doc.Nodes))
+		}
+		if !reflect.DeepEqual(doc.Nodes[0].Translation, []float64{1, 2, 3}) {
+			t.Errorf("Unexpected translation: %v", doc.Nodes[0].Translation)
+		}
+		if !reflect.DeepEqual(doc.Nodes[0].Rotation, []float64{0, 0, 0, 1}) {
+			t.Errorf("Unexpected rotation: %v", doc.Nodes[0].Rotation)
+		}
+
+		position := doc.Accessors[doc.Meshes[0].Primitives[0].Attributes["POSITION"]]
+		if position.Count != 3 {
+			t.Errorf("Expected 3 vertices, got %d", position.Count)
+		}
+		if !reflect.DeepEqual(position.Min, []float64{0, 0, -1}) || !reflect.DeepEqual(position.Max, []float64{1, 2, 0}) {
+			t.Errorf("Unexpected bounds: min %v max %v", position.Min, position.Max)
+		}
+
+		if len(doc.Buffers) != 1 {
+			t.Fatalf("Expected 1 buffer, got %d", len(doc.Buffers))
+		}
+		encoded := strings.TrimPrefix(doc.Buffers[0].URI, "data:application/octet-stream;base64,")
+		buffer, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatalf("Invalid buffer URI: %v", err)
+		}
+		if len(buffer) != doc.Buffers[0].ByteLength {
+			t.Errorf("Buffer length %d does not match byteLength %d", len(buffer), doc.Buffers[0].ByteLength)
+		}
+	})
+
+	t.Run("GLB", func(t *testing.T) {
+		builder := newGLTFBuilder("session-1")
+		if err := builder.addMesh(triangleMesh(), pose); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		data, err := builder.encodeGLB()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if binary.LittleEndian.Uint32(data[0:]) != glbMagic || binary.LittleEndian.Uint32(data[4:]) != glbVersion {
+			t.Error("Invalid GLB header")
+		}
+		if int(binary.LittleEndian.Uint32(data[8:])) != len(data) {
+			t.Error("GLB header length does not match output")
+		}
+
+		jsonLength := binary.LittleEndian.Uint32(data[12:])
+		if jsonLength%4 != 0 {
+			t.Errorf("JSON chunk length %d is not 4-byte aligned", jsonLength)
+		}
+		if binary.LittleEndian.Uint32(data[16:]) != glbChunkJSON {
+			t.Error("Expected JSON chunk first")
+		}
+		if binary.LittleEndian.Uint32(data[20+jsonLength+4:]) != glbChunkBIN {
+			t.Error("Expected BIN chunk after JSON chunk")
+		}
+	})
+
+	t.Run("RejectsMalformedVertices", func(t *testing.T) {
+		mesh := triangleMesh()
+		mesh.Vertices = mesh.Vertices[:10]
+
+		builder := newGLTFBuilder("session-1")
+		if err := builder.addMesh(mesh, pose); err == nil {
+			t.Error("Expected error for truncated vertex buffer")
+		}
+		if len(builder.doc.Nodes) != 0 {
+			t.Error("Rejected mesh should not add a node")
+		}
+	})
+}
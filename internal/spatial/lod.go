@@ -0,0 +1,419 @@
+package spatial
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/google/uuid"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// maxClusterResolution is the finest vertex clustering grid, in cells per
+// axis; it keeps cell coordinates within 21 bits each
+const maxClusterResolution = 1 << 20
+
+// GenerateLOD decimates a mesh to about ratio of its triangles and stores the
+// result as a new mesh of the same anchor, linked to the source by lod_of.
+// A level with the same triangle count is reused rather than stored twice.
+func (r *Repository) GenerateLOD(ctx context.Context, meshID string, ratio float64) (*api.LODResponse, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("lod", "meshes").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	if !(ratio > 0 && ratio < 1) {
+		return nil, errors.ValidationError("ratio must be between 0 and 1, exclusive")
+	}
+
+	source, err := r.GetMesh(ctx, meshID, false)
+	if err != nil {
+		return nil, err
+	}
+	if source.LODOf != "" {
+		return nil, errors.BadRequest(fmt.Sprintf("mesh %s is a level of detail of %s; decimate the original instead", meshID, source.LODOf))
+	}
+
+	decoded, err := decodeMeshBuffers(source)
+	if err != nil {
+		return nil, err
+	}
+	geometry, err := geometryFromMesh(decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceTriangles := len(geometry.faces) / 3
+	target := int(float64(sourceTriangles) * ratio)
+	if target < 1 {
+		return nil, errors.ValidationError(fmt.Sprintf("ratio %g leaves no triangles of the %d in mesh %s", ratio, sourceTriangles, meshID))
+	}
+
+	lod := decimate(geometry, target)
+	if len(lod.faces) == 0 {
+		return nil, errors.UnprocessableEntity(fmt.Sprintf("mesh %s cannot be decimated to %d triangles", meshID, target))
+	}
+
+	response := &api.LODResponse{
+		LODOf:               source.ID,
+		VertexCount:         lod.vertexCount(),
+		TriangleCount:       len(lod.faces) / 3,
+		SourceTriangleCount: sourceTriangles,
+	}
+
+	existingID, err := r.findLOD(ctx, source.ID, response.TriangleCount)
+	if err != nil {
+		return nil, err
+	}
+	if existingID != "" {
+		response.MeshID = existingID
+		return response, nil
+	}
+
+	vertices, faces, normals := lod.buffers()
+	mesh := &api.Mesh{
+		ID:               uuid.NewString(),
+		AnchorID:         source.AnchorID,
+		SessionID:        source.SessionID,
+		Vertices:         vertices,
+		Faces:            faces,
+		Normals:          normals,
+		CompressionLevel: source.CompressionLevel,
+		Timestamp:        time.Now().UnixMilli(),
+		TriangleCount:    response.TriangleCount,
+		LODOf:            source.ID,
+	}
+	mesh.RawSize = meshBufferSize(mesh)
+	if err := encodeMeshBuffers(mesh, r.storageCodec, mesh.CompressionLevel); err != nil {
+		return nil, err
+	}
+
+	// Levels carry no hash, so ingested meshes never deduplicate against them
+	stored := *mesh
+	r.offloadMeshBuffers(ctx, &stored)
+	query := `INSERT @mesh INTO @@collection`
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+		"mesh":        &stored,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("insert", "meshes", "error").Inc()
+		return nil, databaseError("failed to store level of detail", err)
+	}
+	cursor.Close()
+
+	r.metrics.DBOperationsTotal.WithLabelValues("insert", "meshes", "success").Inc()
+	r.metrics.StorageSizeBytes.WithLabelValues("meshes").Add(float64(meshBufferSize(mesh)))
+
+	response.MeshID = mesh.ID
+	response.Created = true
+	return response, nil
+}
+
+// findLOD returns the ID of a stored level of meshID with the given triangle
+// count, or "" if there is none
+func (r *Repository) findLOD(ctx context.Context, meshID string, triangles int) (string, error) {
+	query := `
+		FOR doc IN @@collection
+		FILTER doc.lod_of == @id AND doc.triangle_count == @triangles
+		LIMIT 1
+		RETURN doc.id
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+		"id":          meshID,
+		"triangles":   triangles,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return "", databaseError("failed to look up levels of detail", err)
+	}
+	defer cursor.Close()
+
+	var id string
+	if _, err := cursor.ReadDocument(ctx, &id); driver.IsNoMoreDocuments(err) {
+		return "", nil
+	} else if err != nil {
+		return "", databaseError("failed to read level of detail", err)
+	}
+	return id, nil
+}
+
+// substituteLODs replaces each mesh with more than maxTriangles triangles by
+// its most detailed stored level within the limit, or by its coarsest level
+// when none fits. Meshes without levels are returned unchanged.
+func (r *Repository) substituteLODs(ctx context.Context, meshes []api.Mesh, maxTriangles int) ([]api.Mesh, error) {
+	var oversized []string
+	for i := range meshes {
+		if meshTriangleCount(&meshes[i]) > maxTriangles {
+			oversized = append(oversized, meshes[i].ID)
+		}
+	}
+	if len(oversized) == 0 {
+		return meshes, nil
+	}
+
+	query := `
+		FOR doc IN @@collection
+		FILTER doc.lod_of IN @mesh_ids
+		RETURN doc
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+		"mesh_ids":    oversized,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to query levels of detail", err)
+	}
+	defer cursor.Close()
+
+	levels := make(map[string][]api.Mesh)
+	for {
+		var lod api.Mesh
+		_, err := cursor.ReadDocument(ctx, &lod)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, databaseError("failed to read level of detail", err)
+		}
+		levels[lod.LODOf] = append(levels[lod.LODOf], lod)
+	}
+
+	result := make([]api.Mesh, len(meshes))
+	for i, mesh := range meshes {
+		result[i] = selectLOD(mesh, levels[mesh.ID], maxTriangles)
+		// A level whose geometry cannot be fetched is served at full detail
+		if err := r.fetchMeshBuffers(ctx, &result[i]); err != nil {
+			r.log(ctx).Warnf("Serving mesh %s without its level of detail %s: %v", mesh.ID, result[i].ID, err)
+			result[i] = mesh
+		}
+	}
+	return result, nil
+}
+
+// selectLOD picks the most detailed of a mesh and its levels within
+// maxTriangles, falling back to the coarsest when none fits
+func selectLOD(mesh api.Mesh, levels []api.Mesh, maxTriangles int) api.Mesh {
+	if len(levels) == 0 || meshTriangleCount(&mesh) <= maxTriangles {
+		return mesh
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i].TriangleCount > levels[j].TriangleCount
+	})
+	for _, lod := range levels {
+		if lod.TriangleCount <= maxTriangles {
+			return lod
+		}
+	}
+	return levels[len(levels)-1]
+}
+
+// meshTriangleCount returns a mesh's triangle count, decoding meshes stored
+// before counts were recorded. Geometry that cannot be decoded counts as 0.
+func meshTriangleCount(mesh *api.Mesh) int {
+	if mesh.TriangleCount > 0 || mesh.IsDelta || isOpaqueCodec(mesh.CompressionCodec) {
+		return mesh.TriangleCount
+	}
+
+	decoded, err := decodeMeshBuffers(mesh)
+	if err != nil {
+		return 0
+	}
+	mesh.TriangleCount = len(decoded.Faces) / gltfIndexStride / 3
+	return mesh.TriangleCount
+}
+
+// geometryFromMesh unpacks a decoded mesh's buffers
+func geometryFromMesh(mesh *api.Mesh) (*meshGeometry, error) {
+	// Stored meshes have uint32 indices; extra vertex attributes are dropped
+	positions := meshPositions(mesh)
+	if mesh.IndexFormat == IndexFormatUint16 || len(positions)%gltfVec3Stride != 0 ||
+		len(mesh.Faces)%(3*gltfIndexStride) != 0 || (len(mesh.Normals) != 0 && len(mesh.Normals) != len(positions)) {
+		return nil, errors.UnprocessableEntity(fmt.Sprintf("mesh %s geometry is not a packed triangle mesh", mesh.ID))
+	}
+
+	g := &meshGeometry{
+		vertices: make([]float32, len(positions)/4),
+		normals:  make([]float32, len(mesh.Normals)/4),
+		faces:    make([]uint32, len(mesh.Faces)/4),
+	}
+	for i := range g.vertices {
+		g.vertices[i] = math.Float32frombits(binary.LittleEndian.Uint32(positions[i*4:]))
+	}
+	for i := range g.normals {
+		g.normals[i] = math.Float32frombits(binary.LittleEndian.Uint32(mesh.Normals[i*4:]))
+	}
+	for i := range g.faces {
+		g.faces[i] = binary.LittleEndian.Uint32(mesh.Faces[i*4:])
+		if int(g.faces[i]) >= g.vertexCount() {
+			return nil, errors.UnprocessableEntity(fmt.Sprintf("mesh %s face index %d out of range for %d vertices", mesh.ID, g.faces[i], g.vertexCount()))
+		}
+	}
+
+	if len(g.faces) == 0 {
+		return nil, errors.UnprocessableEntity(fmt.Sprintf("mesh %s has no triangles", mesh.ID))
+	}
+	return g, nil
+}
+
+// decimate simplifies geometry by vertex clustering: vertices are merged per
+// cell of a uniform grid over the bounding box and collapsed triangles are
+// dropped. The finest grid leaving at most target triangles is chosen.
+func decimate(g *meshGeometry, target int) *meshGeometry {
+	// Double the grid resolution until it keeps too much detail, then
+	// bisect for the finest resolution within the target
+	best := clusterVertices(g, 1)
+	lo, hi := 1, 2
+	for hi <= maxClusterResolution {
+		candidate := clusterVertices(g, hi)
+		if len(candidate.faces)/3 > target {
+			break
+		}
+		best, lo = candidate, hi
+		hi *= 2
+	}
+	if hi > maxClusterResolution {
+		return best
+	}
+
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		candidate := clusterVertices(g, mid)
+		if len(candidate.faces)/3 > target {
+			hi = mid
+		} else {
+			best, lo = candidate, mid
+		}
+	}
+	return best
+}
+
+// triangleKey identifies a triangle regardless of winding
+type triangleKey [3]uint32
+
+// clusterVertices merges vertices on a grid of resolution cells along the
+// longest axis of the bounding box
+func clusterVertices(g *meshGeometry, resolution int) *meshGeometry {
+	var lower, upper [3]float64
+	for axis := 0; axis < 3; axis++ {
+		lower[axis], upper[axis] = math.Inf(1), math.Inf(-1)
+	}
+	for i := 0; i < len(g.vertices); i += 3 {
+		for axis := 0; axis < 3; axis++ {
+			v := float64(g.vertices[i+axis])
+			lower[axis] = math.Min(lower[axis], v)
+			upper[axis] = math.Max(upper[axis], v)
+		}
+	}
+	extent := math.Max(upper[0]-lower[0], math.Max(upper[1]-lower[1], upper[2]-lower[2]))
+	cellSize := extent / float64(resolution)
+
+	// Assign every vertex to a cell
+	cellOf := make([]uint32, g.vertexCount())
+	cells := make(map[uint64]uint32)
+	var sums, normalSums [][3]float64
+	var counts []float64
+	for v := range cellOf {
+		var key uint64
+		for axis := 0; axis < 3; axis++ {
+			index := 0
+			if cellSize > 0 {
+				index = int((float64(g.vertices[v*3+axis]) - lower[axis]) / cellSize)
+				if index >= resolution {
+					index = resolution - 1
+				}
+			}
+			key = key<<21 | uint64(index)
+		}
+
+		cell, ok := cells[key]
+		if !ok {
+			cell = uint32(len(counts))
+			cells[key] = cell
+			sums = append(sums, [3]float64{})
+			normalSums = append(normalSums, [3]float64{})
+			counts = append(counts, 0)
+		}
+		cellOf[v] = cell
+		counts[cell]++
+		for axis := 0; axis < 3; axis++ {
+			sums[cell][axis] += float64(g.vertices[v*3+axis])
+			if len(g.normals) > 0 {
+				normalSums[cell][axis] += float64(g.normals[v*3+axis])
+			}
+		}
+	}
+
+	// Keep triangles spanning three cells, once each, renumbering the cells
+	// they use as the new vertices
+	out := &meshGeometry{}
+	remap := make(map[uint32]uint32)
+	seen := make(map[triangleKey]bool)
+	for i := 0; i+2 < len(g.faces); i += 3 {
+		a, b, c := cellOf[g.faces[i]], cellOf[g.faces[i+1]], cellOf[g.faces[i+2]]
+		if a == b || b == c || a == c {
+			continue
+		}
+
+		key := sortedTriangle(a, b, c)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		for _, cell := range []uint32{a, b, c} {
+			index, ok := remap[cell]
+			if !ok {
+				index = uint32(out.vertexCount())
+				remap[cell] = index
+				for axis := 0; axis < 3; axis++ {
+					out.vertices = append(out.vertices, float32(sums[cell][axis]/counts[cell]))
+				}
+				if len(g.normals) > 0 {
+					out.normals = append(out.normals, unitNormal(normalSums[cell])...)
+				}
+			}
+			out.faces = append(out.faces, index)
+		}
+	}
+
+	return out
+}
+
+// sortedTriangle orders a triangle's vertices into its key
+func sortedTriangle(a, b, c uint32) triangleKey {
+	if a > b {
+		a, b = b, a
+	}
+	if b > c {
+		b, c = c, b
+	}
+	if a > b {
+		a, b = b, a
+	}
+	return triangleKey{a, b, c}
+}
+
+// unitNormal normalizes a summed normal, pointing up the z axis when the
+// merged normals cancel out
+func unitNormal(n [3]float64) []float32 {
+	length := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+	if length == 0 {
+		return []float32{0, 0, 1}
+	}
+	return []float32{float32(n[0] / length), float32(n[1] / length), float32(n[2] / length)}
+}
@@ -0,0 +1,123 @@
+package spatial
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// gridGeometry builds an n by n vertex grid on the z=0 plane with up normals
+func gridGeometry(n int) *meshGeometry {
+	g := &meshGeometry{}
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			g.vertices = append(g.vertices, float32(x), float32(y), 0)
+			g.normals = append(g.normals, 0, 0, 1)
+		}
+	}
+	for y := 0; y < n-1; y++ {
+		for x := 0; x < n-1; x++ {
+			i := uint32(y*n + x)
+			g.addPolygon([]uint32{i, i + 1, i + uint32(n) + 1, i + uint32(n)})
+		}
+	}
+	return g
+}
+
+func TestDecimate(t *testing.T) {
+	g := gridGeometry(32)
+	source := len(g.faces) / 3
+
+	for _, ratio := range []float64{0.5, 0.25, 0.05} {
+		target := int(float64(source) * ratio)
+		lod := decimate(g, target)
+
+		triangles := len(lod.faces) / 3
+		if triangles == 0 || triangles > target {
+			t.Errorf("Ratio %g: expected 1..%d triangles, got %d", ratio, target, triangles)
+		}
+		// Clustering should not discard far more detail than asked
+		if triangles < target/4 {
+			t.Errorf("Ratio %g: expected close to %d triangles, got %d", ratio, target, triangles)
+		}
+
+		if len(lod.normals) != len(lod.vertices) {
+			t.Fatalf("Ratio %g: expected a normal per vertex", ratio)
+		}
+		for i := 0; i < len(lod.normals); i += 3 {
+			n := lod.normals[i : i+3]
+			if math.Abs(float64(n[0]*n[0]+n[1]*n[1]+n[2]*n[2])-1) > 1e-5 {
+				t.Fatalf("Ratio %g: normal %v is not unit length", ratio, n)
+			}
+		}
+		for _, index := range lod.faces {
+			if int(index) >= lod.vertexCount() {
+				t.Fatalf("Ratio %g: face index %d out of range for %d vertices", ratio, index, lod.vertexCount())
+			}
+		}
+		for i := 0; i < len(lod.vertices); i++ {
+			if lod.vertices[i] < 0 || lod.vertices[i] > 31 {
+				t.Fatalf("Ratio %g: vertex %v outside the source bounds", ratio, lod.vertices[i])
+			}
+		}
+	}
+}
+
+func TestGeometryFromMesh(t *testing.T) {
+	g := gridGeometry(3)
+	vertices, faces, normals := g.buffers()
+
+	parsed, err := geometryFromMesh(&api.Mesh{ID: "m1", Vertices: vertices, Faces: faces, Normals: normals})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if parsed.vertexCount() != 9 || len(parsed.faces) != len(g.faces) || parsed.vertices[3] != 1 {
+		t.Errorf("Unexpected geometry: %+v", parsed)
+	}
+
+	outOfRange := append([]byte{}, faces...)
+	outOfRange[0] = 99
+	for name, mesh := range map[string]*api.Mesh{
+		"PartialVertex": {ID: "m1", Vertices: vertices[:10], Faces: faces},
+		"PartialFace":   {ID: "m1", Vertices: vertices, Faces: faces[:8]},
+		"NoFaces":       {ID: "m1", Vertices: vertices},
+		"OutOfRange":    {ID: "m1", Vertices: vertices, Faces: outOfRange},
+		"NormalsCount":  {ID: "m1", Vertices: vertices, Faces: faces, Normals: normals[:12]},
+	} {
+		if _, err := geometryFromMesh(mesh); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSelectLOD(t *testing.T) {
+	original := api.Mesh{ID: "full", TriangleCount: 1000}
+	levels := []api.Mesh{
+		{ID: "half", LODOf: "full", TriangleCount: 500},
+		{ID: "tenth", LODOf: "full", TriangleCount: 100},
+		{ID: "quarter", LODOf: "full", TriangleCount: 250},
+	}
+
+	tests := []struct {
+		maxTriangles int
+		want         string
+	}{
+		{2000, "full"},
+		{1000, "full"},
+		{600, "half"},
+		{300, "quarter"},
+		{100, "tenth"},
+		{10, "tenth"}, // Nothing fits, so the coarsest level
+	}
+
+	for _, tt := range tests {
+		if got := selectLOD(original, levels, tt.maxTriangles); got.ID != tt.want {
+			t.Errorf("max_triangles=%d: expected %s, got %s", tt.maxTriangles, tt.want, got.ID)
+		}
+	}
+
+	if got := selectLOD(original, nil, 10); got.ID != "full" {
+		t.Errorf("Expected a mesh without levels to be kept, got %s", got.ID)
+	}
+}
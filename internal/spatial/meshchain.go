@@ -0,0 +1,108 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// MeshChain lists the meshes a stored mesh is resolved against, following
+// base_mesh_id from the mesh down to the full mesh at the root of its delta
+// chain. A chain ending at a base that is not stored is returned as far as it
+// goes, with the missing ID.
+func (r *Repository) MeshChain(ctx context.Context, meshID string) (*api.MeshChainResponse, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("get", "mesh_chain").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	chain, err := walkMeshChain(meshID, r.maxDeltaDepth, func(id string) (*api.Mesh, error) {
+		return r.getStoredMesh(ctx, id)
+	})
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("get", "mesh_chain", "error").Inc()
+		return nil, err
+	}
+	r.metrics.DBOperationsTotal.WithLabelValues("get", "mesh_chain", "success").Inc()
+	return chain, nil
+}
+
+// walkMeshChain follows a mesh's delta chain of at most maxDepth deltas with
+// load, which returns nil for meshes that are not stored
+func walkMeshChain(meshID string, maxDepth int, load func(id string) (*api.Mesh, error)) (*api.MeshChainResponse, error) {
+	mesh, err := load(meshID)
+	if err != nil {
+		return nil, err
+	}
+	if mesh == nil {
+		return nil, errors.NotFound(fmt.Sprintf("mesh %s not found", meshID))
+	}
+
+	response := &api.MeshChainResponse{MeshID: meshID}
+	var path []string
+	for {
+		link := api.MeshChainLink{
+			MeshID:     mesh.ID,
+			IsDelta:    mesh.IsDelta,
+			BaseMeshID: mesh.BaseMeshID,
+			Bytes:      storedGeometryBytes(mesh),
+		}
+		response.Chain = append(response.Chain, link)
+		response.TotalBytes += link.Bytes
+		if !mesh.IsDelta || mesh.BaseMeshID == "" {
+			return response, nil
+		}
+		response.Depth++
+
+		path = append(path, mesh.ID)
+		if err := checkDeltaChain(path, mesh.BaseMeshID, maxDepth); err != nil {
+			return nil, err
+		}
+
+		baseID := mesh.BaseMeshID
+		if mesh, err = load(baseID); err != nil {
+			return nil, err
+		}
+		if mesh == nil {
+			response.MissingBaseMeshID = baseID
+			return response, nil
+		}
+	}
+}
+
+// checkDeltaChain rejects a base mesh already passed through on the way down
+// a delta chain, which would otherwise be followed forever, and chains of
+// more than maxDepth deltas
+func checkDeltaChain(path []string, baseID string, maxDepth int) error {
+	if maxDepth > 0 && len(path) > maxDepth {
+		return errors.UnprocessableEntity(fmt.Sprintf("delta chain of mesh %s is longer than the limit of %d deltas", path[0], maxDepth))
+	}
+	for i, id := range path {
+		if id == baseID {
+			cycle := append(append([]string(nil), path[i:]...), baseID)
+			return errors.UnprocessableEntity(fmt.Sprintf("delta chain of mesh %s is cyclic: %s", path[0], strings.Join(cycle, " -> ")))
+		}
+	}
+	return nil
+}
+
+// storedGeometryBytes returns the stored size of a mesh's geometry: the
+// patch of a delta, or the buffers of a full mesh wherever they are kept
+func storedGeometryBytes(mesh *api.Mesh) int64 {
+	if mesh.IsDelta {
+		// Delta payload is stored in both delta_data and vertices
+		if len(mesh.DeltaData) > 0 {
+			return int64(len(mesh.DeltaData))
+		}
+		return int64(len(mesh.Vertices))
+	}
+	if mesh.BufferRef != nil {
+		return mesh.BufferRef.Size
+	}
+	return meshBufferSize(mesh)
+}
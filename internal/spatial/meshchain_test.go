@@ -0,0 +1,78 @@
+package spatial
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestWalkMeshChain(t *testing.T) {
+	stored := map[string]*api.Mesh{
+		"root":   {ID: "root", Vertices: make([]byte, 100), Faces: make([]byte, 20)},
+		"d1":     {ID: "d1", IsDelta: true, BaseMeshID: "root", DeltaData: make([]byte, 8)},
+		"d2":     {ID: "d2", IsDelta: true, BaseMeshID: "d1", Vertices: make([]byte, 5)},
+		"orphan": {ID: "orphan", IsDelta: true, BaseMeshID: "gone", DeltaData: make([]byte, 3)},
+		"loop1":  {ID: "loop1", IsDelta: true, BaseMeshID: "loop2"},
+		"loop2":  {ID: "loop2", IsDelta: true, BaseMeshID: "loop3"},
+		"loop3":  {ID: "loop3", IsDelta: true, BaseMeshID: "loop2"},
+		"self":   {ID: "self", IsDelta: true, BaseMeshID: "self"},
+	}
+	load := func(id string) (*api.Mesh, error) {
+		return stored[id], nil
+	}
+
+	chain, err := walkMeshChain("d2", 0, load)
+	if err != nil {
+		t.Fatalf("Failed to walk chain: %v", err)
+	}
+	var ids []string
+	for _, link := range chain.Chain {
+		ids = append(ids, link.MeshID)
+	}
+	if strings.Join(ids, ",") != "d2,d1,root" || chain.Depth != 2 || chain.TotalBytes != 133 || chain.Chain[2].IsDelta {
+		t.Errorf("Expected d2 -> d1 -> root of 133 bytes, got %+v", chain)
+	}
+
+	chain, err = walkMeshChain("orphan", 0, load)
+	if err != nil || len(chain.Chain) != 1 || chain.MissingBaseMeshID != "gone" {
+		t.Errorf("Expected the chain to end at the missing base, got %+v, %v", chain, err)
+	}
+
+	_, err = walkMeshChain("nothing", 0, load)
+	if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a missing mesh not found, got %v", err)
+	}
+
+	for id, want := range map[string]string{"loop1": "loop2 -> loop3 -> loop2", "self": "self -> self"} {
+		_, err := walkMeshChain(id, 0, load)
+		apiErr, ok := errors.IsAPIError(err)
+		if !ok || apiErr.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(apiErr.Message, want) {
+			t.Errorf("Expected the cycle %s of %s reported, got %v", want, id, err)
+		}
+	}
+
+	// Chains are followed to the depth limit and no further
+	if _, err := walkMeshChain("d2", 2, load); err != nil {
+		t.Errorf("Expected a chain at the depth limit accepted, got %v", err)
+	}
+	_, err = walkMeshChain("d2", 1, load)
+	if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(apiErr.Message, "limit of 1 deltas") {
+		t.Errorf("Expected a chain past the depth limit rejected, got %v", err)
+	}
+}
+
+func TestResolveDeltaMeshCycle(t *testing.T) {
+	repo := &Repository{logger: logger.New(logger.Config{})}
+
+	// The cycle is caught before its base would be loaded again
+	mesh := &api.Mesh{ID: "m1", IsDelta: true, BaseMeshID: "m1", DeltaData: []byte{1}}
+	_, err := repo.resolveDeltaMesh(context.Background(), mesh)
+	if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(apiErr.Message, "m1 -> m1") {
+		t.Errorf("Expected the self-referencing delta rejected, got %v", err)
+	}
+}
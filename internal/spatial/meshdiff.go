@@ -0,0 +1,123 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// DiffMesh stores a full mesh as a delta against the stored mesh baseID,
+// for clients that cannot compute deltas themselves. The delta is the
+// inverse of resolveDeltaMesh: applied to the resolved base, it reproduces
+// the mesh's geometry exactly. The mesh keeps its own ID, anchor and
+// timestamp and belongs to the base's session.
+func (r *Repository) DiffMesh(ctx context.Context, baseID string, mesh *api.Mesh) (*api.MeshDiffResponse, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("diff", "meshes").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	if mesh.IsDelta {
+		return nil, errors.ValidationError("mesh must be sent in full to be diffed")
+	}
+	if isOpaqueCodec(mesh.CompressionCodec) {
+		return nil, errors.ValidationError(fmt.Sprintf("%s geometry cannot be diffed", mesh.CompressionCodec))
+	}
+
+	base, err := r.GetMesh(ctx, baseID, false)
+	if err != nil {
+		return nil, err
+	}
+	if isOpaqueCodec(base.CompressionCodec) {
+		return nil, errors.UnprocessableEntity(fmt.Sprintf("base mesh %s holds %s geometry, which cannot be diffed", baseID, base.CompressionCodec))
+	}
+
+	existing, err := r.getMeshByID(ctx, mesh.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.Conflict(fmt.Sprintf("mesh %s already exists", mesh.ID))
+	}
+
+	decodedBase, err := decodeMeshBuffers(base)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeMeshBuffers(mesh)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateGeometry(decoded); err != nil {
+		return nil, err
+	}
+	normalizeLayout(decoded)
+
+	// Deltas patch bytes only, so the base must share the mesh's layout
+	baseLayout, err := layoutOf(decodedBase)
+	if err != nil {
+		return nil, err
+	}
+	layout, _ := layoutOf(decoded)
+	if layout != baseLayout {
+		return nil, errors.ValidationError(fmt.Sprintf("mesh %s has a %d byte vertex stride and %d byte indices, but base mesh %s has %d and %d",
+			mesh.ID, layout.vertexStride, layout.indexSize, baseID, baseLayout.vertexStride, baseLayout.indexSize))
+	}
+
+	delta := diffMeshBuffers(decodedBase, decoded)
+
+	stored := &api.Mesh{
+		ID:               mesh.ID,
+		AnchorID:         mesh.AnchorID,
+		SessionID:        base.SessionID,
+		IsDelta:          true,
+		BaseMeshID:       base.ID,
+		CompressionLevel: mesh.CompressionLevel,
+		Timestamp:        mesh.Timestamp,
+	}
+	stored.CompressionLevel = r.storageLevel(stored)
+	if stored.DeltaData, err = compressBuffer(r.storageCodec, encodeMeshDelta(delta), stored.CompressionLevel); err != nil {
+		return nil, err
+	}
+	// Delta payload is stored in both delta_data and vertices, as on ingest
+	stored.Vertices = stored.DeltaData
+
+	// The savings are measured against the mesh stored in full
+	full := &api.Mesh{Vertices: decoded.Vertices, Faces: decoded.Faces, Normals: decoded.Normals}
+	if err := encodeMeshBuffers(full, r.storageCodec, stored.CompressionLevel); err != nil {
+		return nil, err
+	}
+
+	ownReference(stored)
+	query := `INSERT @mesh INTO @@collection`
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+		"mesh":        stored,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("insert", "meshes", "error").Inc()
+		return nil, databaseError("failed to store delta mesh", err)
+	}
+	cursor.Close()
+
+	r.metrics.DBOperationsTotal.WithLabelValues("insert", "meshes", "success").Inc()
+	r.metrics.MeshesTotal.WithLabelValues(r.metrics.SessionSeries(stored.SessionID), "delta", "diff").Inc()
+	r.metrics.StorageSizeBytes.WithLabelValues("meshes").Add(float64(meshBufferSize(stored)))
+
+	deltaBytes := int64(len(stored.DeltaData))
+	return &api.MeshDiffResponse{
+		MeshID:     stored.ID,
+		BaseMeshID: base.ID,
+		Operations: len(delta.Ops),
+		DeltaBytes: deltaBytes,
+		FullBytes:  meshBufferSize(full),
+		SavedBytes: meshBufferSize(full) - deltaBytes,
+	}, nil
+}
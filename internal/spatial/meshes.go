@@ -0,0 +1,141 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// GetMesh loads a stored mesh by ID. Delta meshes are resolved against their
+// base chain unless raw is set, in which case the stored delta is returned.
+func (r *Repository) GetMesh(ctx context.Context, meshID string, raw bool) (*api.Mesh, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("get", "meshes").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	mesh, err := r.getMeshByID(ctx, meshID)
+	if err != nil {
+		return nil, err
+	}
+	if mesh == nil {
+		return nil, errors.NotFound(fmt.Sprintf("mesh %s not found", meshID))
+	}
+
+	if raw || !mesh.IsDelta {
+		return mesh, nil
+	}
+	return r.resolveDeltaMesh(ctx, mesh)
+}
+
+// MaxMeshBatchIDs caps the mesh IDs of one batch fetch
+const MaxMeshBatchIDs = 100
+
+// GetMeshes loads stored meshes by ID in one query, with delta meshes
+// resolved, returning a result for each requested ID in request order
+func (r *Repository) GetMeshes(ctx context.Context, meshIDs []string) (*api.MeshBatchResponse, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("get", "meshes_batch").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	if len(meshIDs) == 0 {
+		return nil, errors.ValidationError("at least one mesh ID is required")
+	}
+	if len(meshIDs) > MaxMeshBatchIDs {
+		return nil, errors.ValidationError(fmt.Sprintf("at most %d mesh IDs may be fetched at once", MaxMeshBatchIDs))
+	}
+	for _, id := range meshIDs {
+		if id == "" {
+			return nil, errors.ValidationError("mesh IDs must not be empty")
+		}
+	}
+
+	query := `
+		FOR doc IN @@collection
+		FILTER doc.id IN @ids
+		RETURN doc
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+		"ids":         meshIDs,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("get", "meshes_batch", "error").Inc()
+		return nil, databaseError("failed to query meshes", err)
+	}
+	defer cursor.Close()
+
+	var meshes []api.Mesh
+	for {
+		var mesh api.Mesh
+		_, err := cursor.ReadDocument(ctx, &mesh)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, databaseError("failed to read mesh", err)
+		}
+		meshes = append(meshes, mesh)
+	}
+
+	meshes, unfetched := r.fetchMeshesBuffers(ctx, meshes)
+	resolved, failed := r.resolveDeltaMeshes(ctx, meshes)
+	byID := make(map[string]*api.Mesh, len(resolved))
+	for i := range resolved {
+		if _, ok := byID[resolved[i].ID]; !ok {
+			byID[resolved[i].ID] = &resolved[i]
+		}
+	}
+	unresolved := make(map[string]string, len(failed)+len(unfetched))
+	for _, id := range failed {
+		unresolved[id] = "delta mesh could not be resolved against its base"
+	}
+	for _, id := range unfetched {
+		unresolved[id] = "mesh geometry could not be fetched from object storage"
+	}
+
+	response := &api.MeshBatchResponse{Meshes: make([]api.MeshBatchResult, len(meshIDs))}
+	for i, id := range meshIDs {
+		result := api.MeshBatchResult{ID: id, Mesh: byID[id]}
+		result.Found = result.Mesh != nil
+		if result.Found {
+			response.Found++
+		} else {
+			response.Missing++
+			result.Error = unresolved[id]
+		}
+		response.Meshes[i] = result
+	}
+
+	r.metrics.DBOperationsTotal.WithLabelValues("get", "meshes_batch", "success").Inc()
+	return response, nil
+}
+
+// MeshVertices returns a mesh's decompressed vertex buffer, or the
+// decompressed delta patch for an unresolved delta mesh
+func MeshVertices(mesh *api.Mesh) ([]byte, error) {
+	if mesh.IsDelta {
+		// Delta payload is stored in both delta_data and vertices
+		deltaData := mesh.DeltaData
+		if len(deltaData) == 0 {
+			deltaData = mesh.Vertices
+		}
+		return decompressBuffer(deltaData)
+	}
+
+	decoded, err := decodeMeshBuffers(mesh)
+	if err != nil {
+		return nil, err
+	}
+	return decoded.Vertices, nil
+}
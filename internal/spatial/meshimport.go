@@ -0,0 +1,588 @@
+package spatial
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Mesh file formats accepted for import
+const (
+	MeshFormatOBJ = "obj"
+	MeshFormatPLY = "ply"
+)
+
+// importCompressionLevel is the storage compression level for imported meshes
+const importCompressionLevel = 6
+
+// meshGeometry is parsed geometry in the stored layout: XYZ float32 vertices
+// and normals, and uint32 triangle indices
+type meshGeometry struct {
+	vertices []float32
+	normals  []float32 // One per vertex, or empty
+	faces    []uint32
+}
+
+// vertexCount returns the number of vertices
+func (g *meshGeometry) vertexCount() int {
+	return len(g.vertices) / 3
+}
+
+// validate checks the geometry forms an indexable triangle mesh
+func (g *meshGeometry) validate() error {
+	if g.vertexCount() < 3 {
+		return errors.ValidationError("mesh file has fewer than 3 vertices")
+	}
+	if len(g.faces) == 0 {
+		return errors.ValidationError("mesh file has no faces")
+	}
+	if len(g.normals) != 0 && len(g.normals) != len(g.vertices) {
+		return errors.ValidationError("mesh file normals do not match its vertices")
+	}
+	for _, index := range g.faces {
+		if int(index) >= g.vertexCount() {
+			return errors.ValidationError(fmt.Sprintf("face index %d out of range for %d vertices", index, g.vertexCount()))
+		}
+	}
+	return nil
+}
+
+// buffers packs the geometry into little-endian mesh buffers
+func (g *meshGeometry) buffers() (vertices, faces, normals []byte) {
+	vertices = make([]byte, 0, len(g.vertices)*4)
+	for _, v := range g.vertices {
+		vertices = binary.LittleEndian.AppendUint32(vertices, math.Float32bits(v))
+	}
+
+	faces = make([]byte, 0, len(g.faces)*4)
+	for _, index := range g.faces {
+		faces = binary.LittleEndian.AppendUint32(faces, index)
+	}
+
+	if len(g.normals) > 0 {
+		normals = make([]byte, 0, len(g.normals)*4)
+		for _, n := range g.normals {
+			normals = binary.LittleEndian.AppendUint32(normals, math.Float32bits(n))
+		}
+	}
+	return vertices, faces, normals
+}
+
+// addPolygon triangulates a convex polygon as a fan
+func (g *meshGeometry) addPolygon(indices []uint32) {
+	for i := 1; i+1 < len(indices); i++ {
+		g.faces = append(g.faces, indices[0], indices[i], indices[i+1])
+	}
+}
+
+// detectMeshFormat picks the format from the file extension, sniffing the
+// content when the extension is not recognized
+func detectMeshFormat(filename string, head []byte) (string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".obj":
+		return MeshFormatOBJ, nil
+	case ".ply":
+		return MeshFormatPLY, nil
+	}
+
+	if bytes.HasPrefix(head, []byte("ply\n")) || bytes.HasPrefix(head, []byte("ply\r\n")) {
+		return MeshFormatPLY, nil
+	}
+
+	// OBJ has no magic; accept text whose first statement is an OBJ keyword
+	for _, line := range strings.Split(string(head), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "v", "vn", "vt", "f", "o", "g", "mtllib", "usemtl", "s":
+			return MeshFormatOBJ, nil
+		}
+		break
+	}
+
+	return "", errors.ValidationError("unrecognized mesh file format, expected OBJ or PLY")
+}
+
+// parseMeshFile parses an OBJ or PLY file
+func parseMeshFile(filename string, r io.Reader) (*meshGeometry, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+
+	format, err := detectMeshFormat(filename, head)
+	if err != nil {
+		return nil, err
+	}
+
+	var geometry *meshGeometry
+	if format == MeshFormatPLY {
+		geometry, err = parsePLY(br)
+	} else {
+		geometry, err = parseOBJ(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := geometry.validate(); err != nil {
+		return nil, err
+	}
+	return geometry, nil
+}
+
+// parseOBJ parses vertices, vertex normals and faces from Wavefront OBJ.
+// Polygons are triangulated; other statements are ignored.
+func parseOBJ(r io.Reader) (*meshGeometry, error) {
+	g := &meshGeometry{}
+	var normals []float32
+	var vertexNormals []int // Normal index per vertex, -1 if none
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "v", "vn":
+			xyz, err := parseFloats(fields[1:], 3)
+			if err != nil {
+				return nil, errors.ValidationError(fmt.Sprintf("obj line %d: %v", line, err))
+			}
+			if fields[0] == "v" {
+				g.vertices = append(g.vertices, xyz...)
+				vertexNormals = append(vertexNormals, -1)
+			} else {
+				normals = append(normals, xyz...)
+			}
+
+		case "f":
+			if len(fields) < 4 {
+				return nil, errors.ValidationError(fmt.Sprintf("obj line %d: face needs at least 3 vertices", line))
+			}
+
+			polygon := make([]uint32, 0, len(fields)-1)
+			for _, ref := range fields[1:] {
+				parts := strings.Split(ref, "/")
+				vertex, err := resolveOBJIndex(parts[0], len(vertexNormals))
+				if err != nil {
+					return nil, errors.ValidationError(fmt.Sprintf("obj line %d: %v", line, err))
+				}
+				if len(parts) == 3 && parts[2] != "" {
+					normal, err := resolveOBJIndex(parts[2], len(normals)/3)
+					if err != nil {
+						return nil, errors.ValidationError(fmt.Sprintf("obj line %d: %v", line, err))
+					}
+					vertexNormals[vertex] = normal
+				}
+				polygon = append(polygon, uint32(vertex))
+			}
+			g.addPolygon(polygon)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.ValidationError(fmt.Sprintf("failed to read obj: %v", err))
+	}
+
+	// OBJ indexes normals separately; keep the last normal each vertex used
+	for _, normal := range vertexNormals {
+		if normal >= 0 {
+			g.normals = make([]float32, 0, len(g.vertices))
+			for _, n := range vertexNormals {
+				if n < 0 {
+					g.normals = append(g.normals, 0, 0, 0)
+				} else {
+					g.normals = append(g.normals, normals[n*3:n*3+3]...)
+				}
+			}
+			break
+		}
+	}
+
+	return g, nil
+}
+
+// resolveOBJIndex converts a 1-based or negative relative OBJ index to 0-based
+func resolveOBJIndex(field string, count int) (int, error) {
+	index, err := strconv.Atoi(field)
+	if err != nil {
+		return 0, fmt.Errorf("invalid index %q", field)
+	}
+	if index < 0 {
+		index += count
+	} else {
+		index--
+	}
+	if index < 0 || index >= count {
+		return 0, fmt.Errorf("index %s out of range", field)
+	}
+	return index, nil
+}
+
+// parseFloats parses the first n fields as float32 values
+func parseFloats(fields []string, n int) ([]float32, error) {
+	if len(fields) < n {
+		return nil, fmt.Errorf("expected %d coordinates, got %d", n, len(fields))
+	}
+	values := make([]float32, n)
+	for i := range values {
+		v, err := strconv.ParseFloat(fields[i], 32)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid coordinate %q", fields[i])
+		}
+		values[i] = float32(v)
+	}
+	return values, nil
+}
+
+// plyProperty is a scalar or list property of a PLY element
+type plyProperty struct {
+	name      string
+	typ       string
+	countType string // Set for list properties
+}
+
+// plyElement is an element declared in a PLY header
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+// plyTypeSizes maps PLY scalar types to their binary sizes
+var plyTypeSizes = map[string]int{
+	"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+	"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+	"int": 4, "int32": 4, "uint": 4, "uint32": 4,
+	"float": 4, "float32": 4, "double": 8, "float64": 8,
+}
+
+// parsePLY parses vertices, vertex normals and faces from ASCII or binary PLY
+func parsePLY(br *bufio.Reader) (*meshGeometry, error) {
+	format, elements, err := parsePLYHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var values plyValueReader
+	switch format {
+	case "ascii":
+		scanner := bufio.NewScanner(br)
+		scanner.Split(bufio.ScanWords)
+		values = &plyASCIIReader{scanner: scanner}
+	case "binary_little_endian":
+		values = &plyBinaryReader{r: br, order: binary.LittleEndian}
+	case "binary_big_endian":
+		values = &plyBinaryReader{r: br, order: binary.BigEndian}
+	default:
+		return nil, errors.ValidationError(fmt.Sprintf("unsupported ply format %q", format))
+	}
+
+	g := &meshGeometry{}
+	for _, element := range elements {
+		if err := readPLYElement(g, element, values); err != nil {
+			return nil, errors.ValidationError(fmt.Sprintf("ply %s data: %v", element.name, err))
+		}
+	}
+	return g, nil
+}
+
+// parsePLYHeader reads the header up to end_header
+func parsePLYHeader(br *bufio.Reader) (string, []plyElement, error) {
+	var format string
+	var elements []plyElement
+
+	for line := 1; ; line++ {
+		text, err := br.ReadString('\n')
+		if err != nil {
+			return "", nil, errors.ValidationError("ply header is not terminated by end_header")
+		}
+		fields := strings.Fields(text)
+
+		if line == 1 {
+			if len(fields) != 1 || fields[0] != "ply" {
+				return "", nil, errors.ValidationError("ply file does not start with ply magic")
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "format":
+			if len(fields) != 3 {
+				return "", nil, errors.ValidationError(fmt.Sprintf("ply header line %d: malformed format", line))
+			}
+			format = fields[1]
+		case "element":
+			if len(fields) != 3 {
+				return "", nil, errors.ValidationError(fmt.Sprintf("ply header line %d: malformed element", line))
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return "", nil, errors.ValidationError(fmt.Sprintf("ply header line %d: invalid element count", line))
+			}
+			elements = append(elements, plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 {
+				return "", nil, errors.ValidationError(fmt.Sprintf("ply header line %d: property outside an element", line))
+			}
+			property, err := parsePLYProperty(fields[1:])
+			if err != nil {
+				return "", nil, errors.ValidationError(fmt.Sprintf("ply header line %d: %v", line, err))
+			}
+			current := &elements[len(elements)-1]
+			current.properties = append(current.properties, property)
+		case "end_header":
+			if format == "" {
+				return "", nil, errors.ValidationError("ply header has no format")
+			}
+			return format, elements, nil
+		}
+	}
+}
+
+// parsePLYProperty parses "<type> <name>" or "list <count type> <type> <name>"
+func parsePLYProperty(fields []string) (plyProperty, error) {
+	var property plyProperty
+	if len(fields) == 4 && fields[0] == "list" {
+		property = plyProperty{countType: fields[1], typ: fields[2], name: fields[3]}
+		if _, ok := plyTypeSizes[property.countType]; !ok {
+			return property, fmt.Errorf("unknown type %q", property.countType)
+		}
+	} else if len(fields) == 2 {
+		property = plyProperty{typ: fields[0], name: fields[1]}
+	} else {
+		return property, fmt.Errorf("malformed property")
+	}
+
+	if _, ok := plyTypeSizes[property.typ]; !ok {
+		return property, fmt.Errorf("unknown type %q", property.typ)
+	}
+	return property, nil
+}
+
+// readPLYElement reads every instance of an element, keeping vertex
+// positions and normals and face indices
+func readPLYElement(g *meshGeometry, element plyElement, values plyValueReader) error {
+	hasNormals := false
+	if element.name == "vertex" {
+		found := 0
+		for _, property := range element.properties {
+			if property.name == "nx" || property.name == "ny" || property.name == "nz" {
+				found++
+			}
+		}
+		hasNormals = found == 3
+	}
+
+	for i := 0; i < element.count; i++ {
+		var position, normal [3]float32
+
+		for _, property := range element.properties {
+			if property.countType != "" {
+				count, err := values.next(property.countType)
+				if err != nil {
+					return err
+				}
+				if count < 0 || count != math.Trunc(count) {
+					return fmt.Errorf("invalid list length %v", count)
+				}
+
+				polygon := []uint32{}
+				for j := 0; j < int(count); j++ {
+					index, err := values.next(property.typ)
+					if err != nil {
+						return err
+					}
+					if index < 0 || index > math.MaxUint32 || index != math.Trunc(index) {
+						return fmt.Errorf("invalid vertex index %v", index)
+					}
+					polygon = append(polygon, uint32(index))
+				}
+
+				if element.name == "face" && (property.name == "vertex_indices" || property.name == "vertex_index") {
+					if len(polygon) < 3 {
+						return fmt.Errorf("face needs at least 3 vertices")
+					}
+					g.addPolygon(polygon)
+				}
+				continue
+			}
+
+			value, err := values.next(property.typ)
+			if err != nil {
+				return err
+			}
+			if element.name != "vertex" {
+				continue
+			}
+			switch property.name {
+			case "x":
+				position[0] = float32(value)
+			case "y":
+				position[1] = float32(value)
+			case "z":
+				position[2] = float32(value)
+			case "nx":
+				normal[0] = float32(value)
+			case "ny":
+				normal[1] = float32(value)
+			case "nz":
+				normal[2] = float32(value)
+			}
+		}
+
+		if element.name == "vertex" {
+			g.vertices = append(g.vertices, position[:]...)
+			if hasNormals {
+				g.normals = append(g.normals, normal[:]...)
+			}
+		}
+	}
+	return nil
+}
+
+// plyValueReader reads successive PLY values of a given type
+type plyValueReader interface {
+	next(typ string) (float64, error)
+}
+
+// plyASCIIReader reads whitespace-separated PLY values
+type plyASCIIReader struct {
+	scanner *bufio.Scanner
+}
+
+func (p *plyASCIIReader) next(typ string) (float64, error) {
+	if !p.scanner.Scan() {
+		if err := p.scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	value, err := strconv.ParseFloat(p.scanner.Text(), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid %s value %q", typ, p.scanner.Text())
+	}
+	return value, nil
+}
+
+// plyBinaryReader reads packed PLY values in a fixed byte order
+type plyBinaryReader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	buf   [8]byte
+}
+
+func (p *plyBinaryReader) next(typ string) (float64, error) {
+	data := p.buf[:plyTypeSizes[typ]]
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	switch typ {
+	case "char", "int8":
+		return float64(int8(data[0])), nil
+	case "uchar", "uint8":
+		return float64(data[0]), nil
+	case "short", "int16":
+		return float64(int16(p.order.Uint16(data))), nil
+	case "ushort", "uint16":
+		return float64(p.order.Uint16(data)), nil
+	case "int", "int32":
+		return float64(int32(p.order.Uint32(data))), nil
+	case "uint", "uint32":
+		return float64(p.order.Uint32(data)), nil
+	case "float", "float32":
+		value := float64(math.Float32frombits(p.order.Uint32(data)))
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return 0, fmt.Errorf("invalid %s value", typ)
+		}
+		return value, nil
+	default:
+		value := math.Float64frombits(p.order.Uint64(data))
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return 0, fmt.Errorf("invalid %s value", typ)
+		}
+		return value, nil
+	}
+}
+
+// ImportMesh parses an OBJ or PLY file and stores it through the ingest path
+// as a mesh of anchorID, returning the import summary and the stored event.
+// The anchor is created at the session origin if it does not exist yet.
+func (r *Repository) ImportMesh(ctx context.Context, sessionID, anchorID, filename string, file io.Reader) (*api.ImportResponse, *api.SpatialEvent, error) {
+	geometry, err := parseMeshFile(filename, file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sessions, err := r.anchorSessions(ctx, anchorID, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	exists := len(sessions) > 0
+
+	now := time.Now().UnixMilli()
+	vertices, faces, normals := geometry.buffers()
+	mesh := api.Mesh{
+		ID:               uuid.NewString(),
+		AnchorID:         anchorID,
+		Vertices:         vertices,
+		Faces:            faces,
+		Normals:          normals,
+		CompressionLevel: importCompressionLevel,
+		Timestamp:        now,
+	}
+
+	event := api.SpatialEvent{
+		SessionID: sessionID,
+		EventID:   "import-" + mesh.ID,
+		Timestamp: now,
+		Meshes:    []api.Mesh{mesh},
+	}
+	if !exists {
+		event.Anchors = []api.Anchor{{
+			ID:        anchorID,
+			SessionID: sessionID,
+			Pose:      api.Pose{Rotation: []float64{0, 0, 0, 1}},
+			Timestamp: now,
+		}}
+	}
+
+	if err := r.Ingest(ctx, &event); err != nil {
+		return nil, nil, err
+	}
+
+	meshID := r.storedMeshID(&mesh)
+	event.Meshes[0].ID = meshID
+
+	return &api.ImportResponse{
+		MeshID:        meshID,
+		SessionID:     sessionID,
+		AnchorID:      anchorID,
+		VertexCount:   geometry.vertexCount(),
+		TriangleCount: len(geometry.faces) / 3,
+		Deduplicated:  meshID != mesh.ID,
+		AnchorCreated: !exists,
+	}, &event, nil
+}
@@ -0,0 +1,131 @@
+package spatial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+func TestParseOBJ(t *testing.T) {
+	obj := `# quad
+o quad
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+vt 0 0
+vn 0 0 1
+f 1//1 2//1 3//1 4//1
+f -4 -2 -1
+`
+	geometry, err := parseMeshFile("quad.obj", strings.NewReader(obj))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if geometry.vertexCount() != 4 {
+		t.Errorf("Expected 4 vertices, got %d", geometry.vertexCount())
+	}
+	wantFaces := []uint32{0, 1, 2, 0, 2, 3, 0, 2, 3}
+	if !equalUint32s(geometry.faces, wantFaces) {
+		t.Errorf("Expected faces %v, got %v", wantFaces, geometry.faces)
+	}
+	if len(geometry.normals) != 12 || geometry.normals[2] != 1 {
+		t.Errorf("Expected per-vertex normals, got %v", geometry.normals)
+	}
+
+	vertices, faces, normals := geometry.buffers()
+	if len(vertices) != 48 || len(faces) != 36 || len(normals) != 48 {
+		t.Errorf("Unexpected buffer sizes %d, %d, %d", len(vertices), len(faces), len(normals))
+	}
+	if got := math.Float32frombits(binary.LittleEndian.Uint32(vertices[12:])); got != 1 {
+		t.Errorf("Expected second vertex x of 1, got %v", got)
+	}
+}
+
+func TestParsePLY(t *testing.T) {
+	header := func(format string) string {
+		return "ply\nformat " + format + " 1.0\ncomment test\n" +
+			"element vertex 3\nproperty float x\nproperty float y\nproperty float z\n" +
+			"property uchar red\n" +
+			"element face 1\nproperty list uchar int vertex_indices\nend_header\n"
+	}
+
+	ascii := header("ascii") + "0 0 0 255\n1 0 0 255\n0 1 0 255\n3 0 1 2\n"
+
+	var binaryBody bytes.Buffer
+	binaryBody.WriteString(header("binary_big_endian"))
+	for _, v := range [][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}} {
+		binary.Write(&binaryBody, binary.BigEndian, v)
+		binaryBody.WriteByte(255)
+	}
+	binaryBody.WriteByte(3)
+	binary.Write(&binaryBody, binary.BigEndian, []int32{0, 1, 2})
+
+	for name, data := range map[string][]byte{
+		"ASCII":     []byte(ascii),
+		"BigEndian": binaryBody.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			// No extension, so the format is sniffed
+			geometry, err := parseMeshFile("upload", bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if geometry.vertexCount() != 3 || geometry.vertices[3] != 1 {
+				t.Errorf("Unexpected vertices %v", geometry.vertices)
+			}
+			if !equalUint32s(geometry.faces, []uint32{0, 1, 2}) {
+				t.Errorf("Unexpected faces %v", geometry.faces)
+			}
+			if len(geometry.normals) != 0 {
+				t.Errorf("Expected no normals, got %v", geometry.normals)
+			}
+		})
+	}
+}
+
+func TestParseMeshFileMalformed(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		data     string
+	}{
+		{"UnknownFormat", "mesh.bin", "\x00\x01\x02"},
+		{"BadCoordinate", "mesh.obj", "v 0 0 x\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"},
+		{"IndexOutOfRange", "mesh.obj", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 4\n"},
+		{"ZeroIndex", "mesh.obj", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 0 1 2\n"},
+		{"NoFaces", "mesh.obj", "v 0 0 0\nv 1 0 0\nv 0 1 0\n"},
+		{"UnterminatedHeader", "mesh.ply", "ply\nformat ascii 1.0\nelement vertex 3\n"},
+		{"UnknownType", "mesh.ply", "ply\nformat ascii 1.0\nelement vertex 3\nproperty quad x\nend_header\n"},
+		{"TruncatedData", "mesh.ply", "ply\nformat ascii 1.0\nelement vertex 3\nproperty float x\nproperty float y\nproperty float z\nend_header\n0 0 0\n1 0\n"},
+		{"PLYIndexOutOfRange", "mesh.ply", "ply\nformat ascii 1.0\nelement vertex 3\nproperty float x\nproperty float y\nproperty float z\nelement face 1\nproperty list uchar int vertex_indices\nend_header\n0 0 0\n1 0 0\n0 1 0\n3 0 1 7\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMeshFile(tt.filename, strings.NewReader(tt.data))
+			apiErr, ok := errors.IsAPIError(err)
+			if !ok || apiErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected validation error, got %v", err)
+			}
+		})
+	}
+}
+
+func equalUint32s(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,219 @@
+package spatial
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/internal/objectstore"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// offloadMeshBuffers moves the stored buffers of a full mesh to object
+// storage when they reach the threshold, leaving a reference in their place.
+// Objects are named by their content hash, so meshes whose stored buffers
+// are identical share one object. If the object cannot be written the mesh
+// keeps its buffers, to be stored in the database.
+func (r *Repository) offloadMeshBuffers(ctx context.Context, mesh *api.Mesh) {
+	size := meshBufferSize(mesh)
+	if r.objects == nil || mesh.IsDelta || mesh.BufferRef != nil || size == 0 || size < r.objectThreshold {
+		return
+	}
+
+	object := make([]byte, 0, size)
+	object = append(object, mesh.Vertices...)
+	object = append(object, mesh.Faces...)
+	object = append(object, mesh.Normals...)
+	sum := sha256.Sum256(object)
+	hash := hex.EncodeToString(sum[:])
+	ref := &api.MeshBufferRef{
+		Backend:      r.objects.Backend(),
+		Bucket:       r.objects.Bucket(),
+		Key:          r.objectPrefix + hash,
+		Hash:         hash,
+		Size:         size,
+		VerticesSize: int64(len(mesh.Vertices)),
+		FacesSize:    int64(len(mesh.Faces)),
+	}
+
+	if err := r.putMeshObject(ctx, ref.Key, object); err != nil {
+		r.log(ctx).Warnf("Storing mesh %s buffers in the database, object storage failed: %v", mesh.ID, err)
+		return
+	}
+
+	mesh.BufferRef = ref
+	mesh.Vertices, mesh.Faces, mesh.Normals = nil, nil, nil
+}
+
+// putMeshObject writes an object unless one is already stored under key
+func (r *Repository) putMeshObject(ctx context.Context, key string, object []byte) error {
+	ctx, cancel := r.objectContext(ctx)
+	defer cancel()
+
+	exists, err := r.objects.Exists(ctx, key)
+	if err != nil {
+		r.metrics.MeshObjectOperations.WithLabelValues("put", "error").Inc()
+		return err
+	}
+	if exists {
+		r.metrics.MeshObjectOperations.WithLabelValues("put", "dedup").Inc()
+		return nil
+	}
+
+	if err := r.objects.Put(ctx, key, object); err != nil {
+		r.metrics.MeshObjectOperations.WithLabelValues("put", "error").Inc()
+		return err
+	}
+	r.metrics.MeshObjectOperations.WithLabelValues("put", "success").Inc()
+	return nil
+}
+
+// fetchMeshBuffers restores the buffers of a mesh offloaded to object
+// storage, checking them against the hash they are stored under
+func (r *Repository) fetchMeshBuffers(ctx context.Context, mesh *api.Mesh) error {
+	ref := mesh.BufferRef
+	if ref == nil {
+		return nil
+	}
+	if r.objects == nil || r.objects.Backend() != ref.Backend {
+		return errors.StorageUnavailable(fmt.Sprintf("mesh %s geometry is in %s object storage, which is not configured", mesh.ID, ref.Backend))
+	}
+
+	ctx, cancel := r.objectContext(ctx)
+	defer cancel()
+
+	object, err := r.objects.Get(ctx, ref.Key)
+	if err != nil {
+		r.metrics.MeshObjectOperations.WithLabelValues("get", "error").Inc()
+		if stderrors.Is(err, objectstore.ErrNotFound) {
+			return errors.NotFound(fmt.Sprintf("mesh %s geometry object %s not found", mesh.ID, ref.Key))
+		}
+		return errors.StorageUnavailable(fmt.Sprintf("failed to fetch mesh %s geometry: %v", mesh.ID, err))
+	}
+
+	sum := sha256.Sum256(object)
+	if hex.EncodeToString(sum[:]) != ref.Hash || int64(len(object)) != ref.Size ||
+		ref.VerticesSize < 0 || ref.FacesSize < 0 || ref.VerticesSize+ref.FacesSize > ref.Size {
+		r.metrics.MeshObjectOperations.WithLabelValues("get", "corrupt").Inc()
+		return errors.InternalServerError(fmt.Sprintf("mesh %s geometry object %s does not match its hash", mesh.ID, ref.Key))
+	}
+	r.metrics.MeshObjectOperations.WithLabelValues("get", "success").Inc()
+
+	mesh.Vertices = object[:ref.VerticesSize]
+	mesh.Faces = object[ref.VerticesSize : ref.VerticesSize+ref.FacesSize]
+	if normals := object[ref.VerticesSize+ref.FacesSize:]; len(normals) > 0 {
+		mesh.Normals = normals
+	}
+	if len(mesh.Faces) == 0 {
+		mesh.Faces = nil
+	}
+	mesh.BufferRef = nil
+	return nil
+}
+
+// fetchMeshesBuffers restores the offloaded buffers of meshes, keeping their
+// order. Meshes whose buffers cannot be fetched are logged and left out, and
+// their IDs returned.
+func (r *Repository) fetchMeshesBuffers(ctx context.Context, meshes []api.Mesh) ([]api.Mesh, []string) {
+	fetched := meshes[:0]
+	var failed []string
+	for _, mesh := range meshes {
+		if err := r.fetchMeshBuffers(ctx, &mesh); err != nil {
+			r.log(ctx).Warnf("Leaving out mesh %s: %v", mesh.ID, err)
+			failed = append(failed, mesh.ID)
+			continue
+		}
+		fetched = append(fetched, mesh)
+	}
+	return fetched, failed
+}
+
+// deleteMeshObjects deletes the objects holding the geometry of removed
+// meshes once no stored mesh refers to them; meshes with identical buffers
+// share one object. The meshes are already gone, so failures are logged and
+// leave the object behind. An ingest that found the object stored just
+// before it is deleted keeps a reference to it, and fetching that mesh then
+// reports its geometry missing.
+func (r *Repository) deleteMeshObjects(ctx context.Context, removed []removedMesh) {
+	if r.objects == nil {
+		return
+	}
+
+	var keys []string
+	seen := make(map[string]bool)
+	for _, mesh := range removed {
+		ref := mesh.BufferRef
+		if ref == nil || ref.Backend != r.objects.Backend() || ref.Bucket != r.objects.Bucket() || seen[ref.Key] {
+			continue
+		}
+		seen[ref.Key] = true
+		keys = append(keys, ref.Key)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	unreferenced, err := r.unreferencedMeshObjects(ctx, keys)
+	if err != nil {
+		r.log(ctx).Warnf("Keeping %d mesh geometry objects, checking their references failed: %v", len(keys), err)
+		return
+	}
+
+	for _, key := range unreferenced {
+		objectCtx, cancel := r.objectContext(ctx)
+		err := r.objects.Delete(objectCtx, key)
+		cancel()
+		if err != nil {
+			r.metrics.MeshObjectOperations.WithLabelValues("delete", "error").Inc()
+			r.log(ctx).Warnf("Failed to delete mesh geometry object %s: %v", key, err)
+			continue
+		}
+		r.metrics.MeshObjectOperations.WithLabelValues("delete", "success").Inc()
+	}
+}
+
+// unreferencedMeshObjects returns the object keys no stored mesh refers to
+func (r *Repository) unreferencedMeshObjects(ctx context.Context, keys []string) ([]string, error) {
+	query := `
+		FOR key IN @keys
+		FILTER LENGTH(FOR m IN @@meshes FILTER m.buffer_ref.key == key LIMIT 1 RETURN 1) == 0
+		RETURN key
+	`
+	bindVars := map[string]interface{}{
+		"@meshes": database.MeshesCollection,
+		"keys":    keys,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to find unreferenced mesh objects", err)
+	}
+	defer cursor.Close()
+
+	var unreferenced []string
+	for {
+		var key string
+		_, err := cursor.ReadDocument(ctx, &key)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, databaseError("failed to read unreferenced mesh objects", err)
+		}
+		unreferenced = append(unreferenced, key)
+	}
+	return unreferenced, nil
+}
+
+// objectContext bounds one object storage operation by the fetch timeout
+func (r *Repository) objectContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.objectTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.objectTimeout)
+}
@@ -0,0 +1,161 @@
+package spatial
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/internal/objectstore"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestMeshBufferOffload(t *testing.T) {
+	root := t.TempDir()
+	store, err := objectstore.NewFileStore(root)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	repo := &Repository{
+		logger: logger.New(logger.Config{}),
+		metrics: &metrics.Metrics{
+			MeshObjectOperations: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_mesh_object_operations_total"}, []string{"operation", "result"}),
+		},
+		objects:         store,
+		objectThreshold: 16,
+		objectPrefix:    "meshes/",
+	}
+	ctx := context.Background()
+
+	newMesh := func(id string) api.Mesh {
+		return api.Mesh{
+			ID:       id,
+			Vertices: bytes.Repeat([]byte{1}, 36),
+			Faces:    bytes.Repeat([]byte{2}, 12),
+			Normals:  bytes.Repeat([]byte{3}, 36),
+		}
+	}
+
+	mesh := newMesh("m1")
+	repo.offloadMeshBuffers(ctx, &mesh)
+	ref := mesh.BufferRef
+	if ref == nil || mesh.Vertices != nil || mesh.Faces != nil || mesh.Normals != nil {
+		t.Fatalf("Expected the buffers replaced by a reference, got %+v", mesh)
+	}
+	if ref.Backend != objectstore.BackendFilesystem || ref.Size != 84 || ref.VerticesSize != 36 || ref.FacesSize != 12 {
+		t.Errorf("Unexpected reference %+v", ref)
+	}
+
+	// Identical buffers share the object
+	duplicate := newMesh("m2")
+	repo.offloadMeshBuffers(ctx, &duplicate)
+	if duplicate.BufferRef == nil || duplicate.BufferRef.Key != ref.Key {
+		t.Errorf("Expected the duplicate to share object %s, got %+v", ref.Key, duplicate.BufferRef)
+	}
+	if got := testutil.ToFloat64(repo.metrics.MeshObjectOperations.WithLabelValues("put", "dedup")); got != 1 {
+		t.Errorf("Expected 1 deduplicated write, got %v", got)
+	}
+
+	if err := repo.fetchMeshBuffers(ctx, &mesh); err != nil {
+		t.Fatalf("Failed to fetch buffers: %v", err)
+	}
+	want := newMesh("m1")
+	if mesh.BufferRef != nil || !bytes.Equal(mesh.Vertices, want.Vertices) ||
+		!bytes.Equal(mesh.Faces, want.Faces) || !bytes.Equal(mesh.Normals, want.Normals) {
+		t.Errorf("Expected the buffers restored, got %+v", mesh)
+	}
+
+	// Small meshes and deltas stay in the database
+	small := api.Mesh{ID: "small", Vertices: []byte{1, 2, 3}}
+	repo.offloadMeshBuffers(ctx, &small)
+	delta := newMesh("delta")
+	delta.IsDelta = true
+	repo.offloadMeshBuffers(ctx, &delta)
+	if small.BufferRef != nil || delta.BufferRef != nil {
+		t.Error("Expected small and delta meshes to keep their buffers")
+	}
+
+	// A corrupted object is not served
+	if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(ref.Key)), []byte("corrupt"), 0o644); err != nil {
+		t.Fatalf("Failed to corrupt object: %v", err)
+	}
+	if err := repo.fetchMeshBuffers(ctx, &duplicate); err == nil {
+		t.Error("Expected a corrupted object to be rejected")
+	}
+
+	// Meshes that cannot be fetched are left out of a list
+	meshes, failed := repo.fetchMeshesBuffers(ctx, []api.Mesh{small, duplicate})
+	if len(meshes) != 1 || meshes[0].ID != "small" || len(failed) != 1 || failed[0] != "m2" {
+		t.Errorf("Expected only m2 left out, got %d meshes and failures %v", len(meshes), failed)
+	}
+
+	// Offloaded meshes cannot be read without the object store
+	repo.objects = nil
+	apiErr, ok := errors.IsAPIError(repo.fetchMeshBuffers(ctx, &duplicate))
+	if !ok || apiErr.Code != "STORAGE_UNAVAILABLE" {
+		t.Errorf("Expected storage unavailable, got %v", apiErr)
+	}
+}
+
+func TestDeleteSessionMeshObjects(t *testing.T) {
+	store, err := objectstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"meshes/owned", "meshes/shared"} {
+		if err := store.Put(ctx, key, []byte("geometry")); err != nil {
+			t.Fatalf("Failed to store %s: %v", key, err)
+		}
+	}
+
+	// The session's two meshes are removed, but another session's mesh
+	// still refers to the shared object
+	repo, queries := fakeArangoResults(t, 0, func(q fakeQuery) (string, int) {
+		switch {
+		case strings.Contains(q.Query, "REMOVE m IN"):
+			return `[[
+				{"id":"m1","hash":"h1","buffer_ref":{"backend":"filesystem","bucket":"` + store.Bucket() + `","key":"meshes/owned"}},
+				{"id":"m2","hash":"h2","buffer_ref":{"backend":"filesystem","bucket":"` + store.Bucket() + `","key":"meshes/shared"}},
+				{"id":"m3","hash":"h3"}
+			]]`, 0
+		case strings.Contains(q.Query, "FOR key IN @keys"):
+			return `["meshes/owned"]`, 0
+		default:
+			return `[0]`, 0
+		}
+	})
+	repo.objects = store
+	repo.meshHashCache = newHashCache(time.Minute)
+
+	deleted, err := repo.DeleteSession(ctx, "s1", false)
+	if err != nil {
+		t.Fatalf("Failed to delete session: %v", err)
+	}
+	if deleted.Meshes != 3 {
+		t.Errorf("Expected 3 meshes deleted, got %d", deleted.Meshes)
+	}
+
+	if exists, _ := store.Exists(ctx, "meshes/owned"); exists {
+		t.Error("Expected the unreferenced object deleted")
+	}
+	if exists, _ := store.Exists(ctx, "meshes/shared"); !exists {
+		t.Error("Expected the object another mesh refers to kept")
+	}
+
+	// Only the offloaded meshes' objects are checked for references
+	for _, q := range queries() {
+		if strings.Contains(q.Query, "FOR key IN @keys") && string(q.BindVars["keys"]) != `["meshes/owned","meshes/shared"]` {
+			t.Errorf("Expected both object keys checked, got %s", q.BindVars["keys"])
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package spatial
+
+import (
+	"context"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// Deduplication shares one stored mesh among every anchor that sent its
+// geometry. The mesh document lists those anchors in referenced_by, with
+// ref_count their number, and belongs to the session of the first. Deleting
+// a session drops its references; a mesh still referenced by another session
+// is handed to that session's first anchor instead of being removed. Meshes
+// stored before references were recorded count their own anchor alone.
+
+// meshReferences is the AQL expression for a mesh document m's references
+const meshReferences = `NOT_NULL(m.referenced_by, [{ session_id: m.session_id, anchor_id: m.anchor_id }])`
+
+// ownReference records the anchor storing a mesh as its only reference
+func ownReference(mesh *api.Mesh) {
+	mesh.ReferencedBy = []api.MeshReference{{SessionID: mesh.SessionID, AnchorID: mesh.AnchorID}}
+	mesh.RefCount = len(mesh.ReferencedBy)
+}
+
+// referenceMesh adds mesh's anchor to the references of the stored mesh
+// meshID with the same hash, atomically and at most once per anchor. It
+// reports false if that mesh is not stored, as when the ingest that reserved
+// its hash has yet to commit or has failed.
+func (r *Repository) referenceMesh(ctx context.Context, meshID string, mesh *api.Mesh) (bool, error) {
+	query := `
+		FOR m IN @@meshes
+		FILTER m.hash == @hash AND m.id == @id
+		LIMIT 1
+		LET refs = PUSH(` + meshReferences + `, @ref, true)
+		UPDATE m WITH { ref_count: LENGTH(refs), referenced_by: refs } IN @@meshes
+		RETURN NEW.ref_count
+	`
+	bindVars := map[string]interface{}{
+		"@meshes": database.MeshesCollection,
+		"hash":    mesh.Hash,
+		"id":      meshID,
+		"ref":     api.MeshReference{SessionID: mesh.SessionID, AnchorID: mesh.AnchorID},
+	}
+
+	referenced := false
+	err := r.retryWrite(ctx, "reference_mesh", func() error {
+		cursor, err := r.runQuery(ctx, query, bindVars)
+		if err != nil {
+			return databaseError("failed to reference mesh", err)
+		}
+		defer cursor.Close()
+
+		referenced = cursor.HasMore()
+		return nil
+	})
+	return referenced, err
+}
+
+// releaseSessionMeshes drops a session's references to meshes other sessions
+// still reference, handing those it stored to the next referencing anchor,
+// and returns how many were released. Its remaining meshes are referenced by
+// the session alone and removed with it.
+func (r *Repository) releaseSessionMeshes(ctx context.Context, sessionID string) (int, error) {
+	query := `
+		LET released = (
+			FOR m IN @@meshes
+			FILTER m.session_id == @session_id OR @session_id IN m.referenced_by[*].session_id
+			LET remaining = ` + meshReferences + `[* FILTER CURRENT.session_id != @session_id]
+			FILTER LENGTH(remaining) > 0
+			LET owner = m.session_id == @session_id ? FIRST(remaining) : { session_id: m.session_id, anchor_id: m.anchor_id }
+			UPDATE m WITH {
+				session_id: owner.session_id,
+				anchor_id: owner.anchor_id,
+				ref_count: LENGTH(remaining),
+				referenced_by: remaining
+			} IN @@meshes
+			RETURN 1
+		)
+		RETURN LENGTH(released)
+	`
+	bindVars := map[string]interface{}{
+		"@meshes":    database.MeshesCollection,
+		"session_id": sessionID,
+	}
+
+	var released int
+	if err := r.readSingle(ctx, query, bindVars, &released); err != nil {
+		return 0, databaseError("failed to release shared session meshes", err)
+	}
+	return released, nil
+}
+
+// MeshOwners holds the session a stored mesh belongs to and the sessions of
+// the anchors referencing it, which share it through deduplication
+type MeshOwners struct {
+	SessionID string   `json:"session_id"`
+	Sharing   []string `json:"sharing"`
+}
+
+// MeshSessions returns, by mesh ID, the sessions of the stored meshes among
+// meshIDs. Meshes not stored are left out.
+func (r *Repository) MeshSessions(ctx context.Context, meshIDs []string) (map[string]MeshOwners, error) {
+	query := `
+		RETURN MERGE(
+			FOR m IN @@meshes
+			FILTER m.id IN @ids
+			RETURN { [m.id]: { session_id: m.session_id, sharing: UNIQUE(` + meshReferences + `[*].session_id) } }
+		)
+	`
+	bindVars := map[string]interface{}{
+		"@meshes": database.MeshesCollection,
+		"ids":     meshIDs,
+	}
+
+	owners := make(map[string]MeshOwners)
+	if err := r.readSingle(ctx, query, bindVars, &owners); err != nil {
+		return nil, databaseError("failed to look up mesh sessions", err)
+	}
+	return owners, nil
+}
@@ -0,0 +1,99 @@
+package spatial
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// validateMetadata checks anchor metadata against schema, a map of allowed
+// key to type. Every unknown key and mistyped value is listed in the error.
+// A nil schema accepts any metadata.
+func validateMetadata(anchorID string, metadata map[string]interface{}, schema map[string]string) error {
+	if schema == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		want, ok := schema[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown field %q", key))
+			continue
+		}
+		if got := metadataType(metadata[key]); !metadataTypeMatches(want, got, metadata[key]) {
+			problems = append(problems, fmt.Sprintf("field %q must be %s, got %s", key, want, got))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.ValidationError(fmt.Sprintf("anchor %s: invalid metadata: %s", anchorID, strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+// metadataType names the JSON type of a decoded metadata value
+func metadataType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64, json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// metadataTypeMatches reports whether a value of JSON type got satisfies want
+func metadataTypeMatches(want, got string, value interface{}) bool {
+	switch want {
+	case "any":
+		return true
+	case "integer":
+		if got != "number" {
+			return false
+		}
+		switch n := value.(type) {
+		case float64:
+			return n == math.Trunc(n) && !math.IsInf(n, 0)
+		case json.Number:
+			_, err := n.Int64()
+			return err == nil
+		}
+		return false
+	default:
+		return want == got
+	}
+}
+
+// metadataFilterValues returns the metadata values a query filter value
+// matches: the string itself, and the number or boolean it spells
+func metadataFilterValues(value string) []interface{} {
+	values := []interface{}{value}
+	if number, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(number, 0) && !math.IsNaN(number) {
+		values = append(values, number)
+	}
+	if value == "true" || value == "false" {
+		values = append(values, value == "true")
+	}
+	return values
+}
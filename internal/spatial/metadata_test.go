@@ -0,0 +1,86 @@
+package spatial
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+func TestValidateMetadata(t *testing.T) {
+	schema := map[string]string{
+		"label":      "string",
+		"confidence": "number",
+		"floor":      "integer",
+		"visible":    "boolean",
+		"tags":       "array",
+		"extra":      "object",
+		"note":       "any",
+	}
+
+	accepted := []string{
+		`{}`,
+		`{"label": "door", "confidence": 0.93, "floor": 2}`,
+		`{"visible": false, "tags": ["a", "b"], "extra": {"nested": 1}}`,
+		`{"floor": 2.0, "note": null}`,
+		`{"note": [1, "two"]}`,
+	}
+	for _, raw := range accepted {
+		var metadata map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+			t.Fatalf("Invalid test metadata %s: %v", raw, err)
+		}
+		if err := validateMetadata("a1", metadata, schema); err != nil {
+			t.Errorf("Expected %s to be accepted, got %v", raw, err)
+		}
+	}
+
+	rejected := []struct {
+		raw    string
+		fields []string
+	}{
+		{`{"lable": "door"}`, []string{`unknown field "lable"`}},
+		{`{"confidence": "high"}`, []string{`field "confidence" must be number, got string`}},
+		{`{"floor": 2.5}`, []string{`field "floor" must be integer, got number`}},
+		{`{"visible": "yes", "tags": "a,b"}`, []string{`field "tags" must be array, got string`, `field "visible" must be boolean, got string`}},
+		{`{"label": null}`, []string{`field "label" must be string, got null`}},
+		{`{"extra": [], "zzz": 1, "aaa": 2}`, []string{`unknown field "aaa"`, `field "extra" must be object, got array`, `unknown field "zzz"`}},
+	}
+	for _, tt := range rejected {
+		var metadata map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.raw), &metadata); err != nil {
+			t.Fatalf("Invalid test metadata %s: %v", tt.raw, err)
+		}
+
+		err := validateMetadata("a1", metadata, schema)
+		apiErr, ok := errors.IsAPIError(err)
+		if !ok || apiErr.Code != "VALIDATION_ERROR" {
+			t.Errorf("Expected validation error for %s, got %v", tt.raw, err)
+			continue
+		}
+
+		// Every failing field is listed, in key order
+		want := "anchor a1: invalid metadata: " + strings.Join(tt.fields, "; ")
+		if !strings.HasSuffix(apiErr.Message, want) {
+			t.Errorf("Expected message ending %q, got %q", want, apiErr.Message)
+		}
+	}
+
+	// Without a schema any metadata is accepted
+	if err := validateMetadata("a1", map[string]interface{}{"anything": struct{}{}}, nil); err != nil {
+		t.Errorf("Expected no validation without a schema, got %v", err)
+	}
+}
+
+func TestValidateMetadataNumbers(t *testing.T) {
+	schema := map[string]string{"floor": "integer", "confidence": "number"}
+
+	// Decoders configured with UseNumber produce json.Number
+	if err := validateMetadata("a1", map[string]interface{}{"floor": json.Number("3"), "confidence": json.Number("0.5")}, schema); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validateMetadata("a1", map[string]interface{}{"floor": json.Number("3.5")}, schema); err == nil {
+		t.Error("Expected fractional json.Number to be rejected as an integer")
+	}
+}
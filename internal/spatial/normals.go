@@ -0,0 +1,84 @@
+package spatial
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// vec3 is a position or direction in pose meters
+type vec3 [3]float64
+
+func (a vec3) sub(b vec3) vec3 {
+	return vec3{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func (a vec3) cross(b vec3) vec3 {
+	return vec3{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func (a vec3) dot(b vec3) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func (a vec3) length() float64 {
+	return math.Sqrt(a.dot(a))
+}
+
+// angleBetween returns the angle in radians between two non-zero vectors
+func angleBetween(a, b vec3) float64 {
+	cos := a.dot(b) / (a.length() * b.length())
+	return math.Acos(math.Max(-1, math.Min(1, cos)))
+}
+
+// smoothNormals computes per-vertex normals for a decoded mesh with uint32
+// faces. Each vertex averages the unit normals of its triangles weighted by
+// the triangle's angle at the vertex, so the result does not depend on how
+// flat regions were triangulated. Triangles wind counter-clockwise around
+// their normal; degenerate triangles are ignored, and vertices that no
+// triangle uses point up the z axis.
+func smoothNormals(mesh *api.Mesh) []byte {
+	positions := meshPositions(mesh)
+	position := func(index uint32) vec3 {
+		offset := int(index) * gltfVec3Stride
+		var p vec3
+		for axis := range p {
+			p[axis] = float64(math.Float32frombits(binary.LittleEndian.Uint32(positions[offset+axis*4:])))
+		}
+		return p
+	}
+
+	sums := make([][3]float64, len(positions)/gltfVec3Stride)
+	const triangleSize = 3 * gltfIndexStride
+	for i := 0; i+triangleSize <= len(mesh.Faces); i += triangleSize {
+		var indices [3]uint32
+		var corners [3]vec3
+		for k := range indices {
+			indices[k] = binary.LittleEndian.Uint32(mesh.Faces[i+k*gltfIndexStride:])
+			corners[k] = position(indices[k])
+		}
+
+		normal := corners[1].sub(corners[0]).cross(corners[2].sub(corners[0]))
+		area := normal.length()
+		if area == 0 {
+			continue
+		}
+
+		for k, index := range indices {
+			next, prev := corners[(k+1)%3].sub(corners[k]), corners[(k+2)%3].sub(corners[k])
+			weight := angleBetween(next, prev) / area
+			for axis := range normal {
+				sums[index][axis] += normal[axis] * weight
+			}
+		}
+	}
+
+	normals := make([]byte, 0, len(positions))
+	for _, sum := range sums {
+		for _, n := range unitNormal(sum) {
+			normals = binary.LittleEndian.AppendUint32(normals, math.Float32bits(n))
+		}
+	}
+	return normals
+}
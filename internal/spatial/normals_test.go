@@ -0,0 +1,82 @@
+package spatial
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// cubeMesh returns a cube of side 2 centered on the origin, its corners
+// shared between faces and its triangles wound counter-clockwise outward
+func cubeMesh() *api.Mesh {
+	var vertices []byte
+	for i := 0; i < 8; i++ {
+		for axis := 0; axis < 3; axis++ {
+			v := float32(-1)
+			if i&(1<<axis) != 0 {
+				v = 1
+			}
+			vertices = binary.LittleEndian.AppendUint32(vertices, math.Float32bits(v))
+		}
+	}
+
+	var faces []byte
+	for _, index := range []uint32{
+		0, 2, 3, 0, 3, 1, // -z
+		4, 5, 7, 4, 7, 6, // +z
+		0, 1, 5, 0, 5, 4, // -y
+		2, 6, 7, 2, 7, 3, // +y
+		0, 4, 6, 0, 6, 2, // -x
+		1, 3, 7, 1, 7, 5, // +x
+	} {
+		faces = binary.LittleEndian.AppendUint32(faces, index)
+	}
+	return &api.Mesh{ID: "cube", Vertices: vertices, Faces: faces}
+}
+
+// readVec3s unpacks XYZ float32 triples
+func readVec3s(data []byte) []vec3 {
+	out := make([]vec3, len(data)/gltfVec3Stride)
+	for i := range out {
+		for axis := 0; axis < 3; axis++ {
+			out[i][axis] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*gltfVec3Stride+axis*4:])))
+		}
+	}
+	return out
+}
+
+func TestSmoothNormalsCube(t *testing.T) {
+	cube := cubeMesh()
+	normals := smoothNormals(cube)
+	if len(normals) != len(cube.Vertices) {
+		t.Fatalf("Expected one normal per vertex, got %d bytes for %d", len(normals), len(cube.Vertices))
+	}
+
+	// Each corner points diagonally away from the center, however its
+	// faces were split into triangles
+	corners := readVec3s(cube.Vertices)
+	for i, normal := range readVec3s(normals) {
+		for axis := 0; axis < 3; axis++ {
+			want := corners[i][axis] / math.Sqrt(3)
+			if math.Abs(normal[axis]-want) > 1e-6 {
+				t.Errorf("Corner %d: expected normal %v, got %v", i, corners[i], normal)
+				break
+			}
+		}
+	}
+}
+
+func TestSmoothNormalsUnusedVertex(t *testing.T) {
+	mesh := triangleMesh()
+	mesh.Vertices = append(mesh.Vertices, make([]byte, gltfVec3Stride)...)
+
+	normals := readVec3s(smoothNormals(mesh))
+	if len(normals) != 4 {
+		t.Fatalf("Expected 4 normals, got %d", len(normals))
+	}
+	if normals[3] != (vec3{0, 0, 1}) {
+		t.Errorf("Expected the unused vertex to point up, got %v", normals[3])
+	}
+}
@@ -0,0 +1,51 @@
+package spatial
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// quaternionTolerance is how far a rotation's magnitude may drift from 1.0
+// before it is rejected as not normalized
+const quaternionTolerance = 1e-3
+
+// validateRotation checks that an anchor's rotation is a unit quaternion
+// [x, y, z, w]. With normalize set, any finite non-zero quaternion is scaled
+// to unit length in place instead of being rejected for its magnitude.
+func validateRotation(anchorID string, pose *api.Pose, normalize bool) error {
+	if len(pose.Rotation) != 4 {
+		return errors.ValidationError(fmt.Sprintf("anchor %s: rotation must have 4 elements, got %d", anchorID, len(pose.Rotation)))
+	}
+
+	var sumSquares float64
+	for _, v := range pose.Rotation {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.ValidationError(fmt.Sprintf("anchor %s: rotation contains a non-finite value", anchorID))
+		}
+		sumSquares += v * v
+	}
+
+	magnitude := math.Sqrt(sumSquares)
+	if magnitude == 0 || math.IsInf(magnitude, 0) {
+		return errors.ValidationError(fmt.Sprintf("anchor %s: rotation is a degenerate quaternion", anchorID))
+	}
+
+	if math.Abs(magnitude-1) <= quaternionTolerance {
+		return nil
+	}
+
+	if !normalize {
+		return errors.ValidationError(fmt.Sprintf("anchor %s: rotation is not a unit quaternion (magnitude %.4f)", anchorID, magnitude))
+	}
+
+	// Copy so a rotation slice shared with the caller's request is left intact
+	normalized := make([]float64, 4)
+	for i, v := range pose.Rotation {
+		normalized[i] = v / magnitude
+	}
+	pose.Rotation = normalized
+	return nil
+}
@@ -0,0 +1,85 @@
+package spatial
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+func TestValidateRotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		rotation  []float64
+		normalize bool
+		wantErr   bool
+	}{
+		{"identity", []float64{0, 0, 0, 1}, false, false},
+		{"within tolerance", []float64{0, 0, 0, 1.0005}, false, false},
+		{"missing", nil, false, true},
+		{"three elements", []float64{0, 0, 1}, false, true},
+		{"five elements", []float64{0, 0, 0, 1, 0}, true, true},
+		{"zero magnitude", []float64{0, 0, 0, 0}, false, true},
+		{"zero magnitude normalized", []float64{0, 0, 0, 0}, true, true},
+		{"NaN", []float64{0, math.NaN(), 0, 1}, true, true},
+		{"infinite", []float64{0, 0, math.Inf(1), 1}, true, true},
+		{"overflowing magnitude", []float64{math.MaxFloat64, math.MaxFloat64, 0, 0}, true, true},
+		{"not unit", []float64{0, 0, 0, 2}, false, true},
+		{"not unit normalized", []float64{0, 0, 0, 2}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pose := api.Pose{Rotation: tt.rotation}
+			err := validateRotation("anchor-1", &pose, tt.normalize)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected error, got nil")
+				}
+				apiErr, ok := errors.IsAPIError(err)
+				if !ok || apiErr.Code != "VALIDATION_ERROR" {
+					t.Errorf("Expected validation error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateRotationNormalizes(t *testing.T) {
+	original := []float64{0, 3, 0, 4}
+	pose := api.Pose{Rotation: original}
+
+	if err := validateRotation("anchor-1", &pose, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []float64{0, 0.6, 0, 0.8}
+	for i, v := range pose.Rotation {
+		if math.Abs(v-want[i]) > 1e-9 {
+			t.Errorf("Expected rotation %v, got %v", want, pose.Rotation)
+			break
+		}
+	}
+	if original[3] != 4 {
+		t.Errorf("Expected caller's rotation to be left intact, got %v", original)
+	}
+}
+
+func TestValidateRotationNamesAnchor(t *testing.T) {
+	pose := api.Pose{Rotation: []float64{0, 0, 0, 0}}
+	err := validateRotation("anchor-42", &pose, false)
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+
+	apiErr, _ := errors.IsAPIError(err)
+	if want := "anchor anchor-42"; !strings.Contains(apiErr.Message, want) {
+		t.Errorf("Expected message to contain %q, got %q", want, apiErr.Message)
+	}
+}
@@ -0,0 +1,93 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/database"
+)
+
+// Pose history is pruned as it is written: each recorded pose is followed by
+// a query deleting the samples of that anchor the limits no longer keep.
+// Anchors that are no longer updated keep their history as it was last
+// pruned. Interpolation and replay work on whatever samples remain, so a
+// thinned span is reconstructed as straight motion between the kept samples.
+
+// poseHistoryLimits bound the pose samples kept per anchor
+type poseHistoryLimits struct {
+	maxSamples     int   // Newest samples kept, 0 keeps any number
+	fullResolution int64 // Milliseconds before the latest sample kept unthinned
+	interval       int64 // Milliseconds between thinned samples, 0 disables thinning
+}
+
+// newPoseHistoryLimits converts the pose history configuration
+func newPoseHistoryLimits(cfg config.PoseHistoryConfig) poseHistoryLimits {
+	return poseHistoryLimits{
+		maxSamples:     cfg.MaxSamples,
+		fullResolution: cfg.FullResolution.Milliseconds(),
+		interval:       cfg.Interval.Milliseconds(),
+	}
+}
+
+// enabled reports whether any samples are ever pruned
+func (l poseHistoryLimits) enabled() bool {
+	return l.maxSamples > 0 || l.interval > 0
+}
+
+// prunePoses deletes the pose samples of an anchor beyond the limits. Samples
+// older than fullResolution before the anchor's latest keep only the earliest
+// in each interval, then all but the newest maxSamples go.
+func (r *Repository) prunePoses(ctx context.Context, sessionID, anchorID string) error {
+	if !r.poseHistory.enabled() {
+		return nil
+	}
+
+	query := `
+		LET samples = (
+			FOR p IN @@collection
+			FILTER p.anchor_id == @anchor_id AND p.session_id == @session_id
+			SORT p.timestamp DESC
+			RETURN { key: p._key, timestamp: p.timestamp }
+		)
+		LET cutoff = LENGTH(samples) > 0 ? samples[0].timestamp - @full_resolution : 0
+		LET firsts = @interval > 0 ? (
+			FOR s IN samples
+			FILTER s.timestamp < cutoff
+			COLLECT bucket = FLOOR(s.timestamp / @interval) AGGREGATE first = MIN(s.timestamp)
+			RETURN TO_STRING(first)
+		) : []
+		LET thinned = ZIP(firsts, firsts)
+		LET retained = (
+			FOR s IN samples
+			FILTER @interval <= 0 OR s.timestamp >= cutoff OR HAS(thinned, TO_STRING(s.timestamp))
+			RETURN s.key
+		)
+		LET kept = @max_samples > 0 ? SLICE(retained, 0, @max_samples) : retained
+		LET keep = ZIP(kept, kept)
+		LET removed = (
+			FOR s IN samples
+			FILTER NOT HAS(keep, s.key)
+			REMOVE s.key IN @@collection
+			RETURN 1
+		)
+		RETURN LENGTH(removed)
+	`
+	bindVars := map[string]interface{}{
+		"@collection":     database.AnchorPosesCollection,
+		"session_id":      sessionID,
+		"anchor_id":       anchorID,
+		"max_samples":     r.poseHistory.maxSamples,
+		"full_resolution": r.poseHistory.fullResolution,
+		"interval":        r.poseHistory.interval,
+	}
+
+	var removed int
+	if err := r.readSingle(ctx, query, bindVars, &removed); err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("delete", "anchor_poses", "error").Inc()
+		return databaseError(fmt.Sprintf("failed to prune pose history of anchor %s", anchorID), err)
+	}
+	r.metrics.DBOperationsTotal.WithLabelValues("delete", "anchor_poses", "success").Inc()
+	r.metrics.PoseSamplesPruned.Add(float64(removed))
+	return nil
+}
@@ -0,0 +1,254 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// A replay rebuilds a session's ingest traffic from what was stored: every
+// recorded pose sample becomes an anchor write carrying the anchor's current
+// metadata, and every mesh except generated levels of detail is sent again.
+// Records are grouped into events by timestamp, so posting the events back to
+// /ingest in order reproduces the session.
+
+// replayEventSize caps the anchors and meshes in one replayed event, keeping
+// each well within the ingest body limit
+const replayEventSize = 100
+
+// replayWindow is a validated replay time window and speed
+type replayWindow struct {
+	from  int64
+	to    int64 // Exclusive
+	speed float64
+}
+
+// newReplayWindow applies defaults to replay parameters and validates them
+func newReplayWindow(params *api.ReplayParams) (*replayWindow, error) {
+	window := &replayWindow{from: params.From, to: params.To, speed: params.Speed}
+	if window.to == 0 {
+		window.to = math.MaxInt64
+	}
+	if window.speed == 0 {
+		window.speed = 1
+	}
+
+	if window.from < 0 {
+		return nil, errors.ValidationError("from must not be negative")
+	}
+	if window.to <= window.from {
+		return nil, errors.ValidationError("to must be after from")
+	}
+	if window.speed < 0 || math.IsInf(window.speed, 0) || math.IsNaN(window.speed) {
+		return nil, errors.ValidationError("speed must be a positive number")
+	}
+	return window, nil
+}
+
+// ReplaySession streams a session's pose samples and meshes within the
+// window to emit as events in timestamp order. Delta meshes are sent as
+// stored unless params.ResolveDeltas is set. Returns NotFound for a session
+// without anchors; an error from emit stops the replay and is returned.
+func (r *Repository) ReplaySession(ctx context.Context, sessionID string, params *api.ReplayParams, emit func(*api.ReplayEvent) error) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("query", "replay").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	window, err := newReplayWindow(params)
+	if err != nil {
+		return err
+	}
+
+	err = r.replaySession(ctx, sessionID, window, params.ResolveDeltas, emit)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("query", "replay", "error").Inc()
+		return err
+	}
+	r.metrics.DBOperationsTotal.WithLabelValues("query", "replay", "success").Inc()
+	return nil
+}
+
+func (r *Repository) replaySession(ctx context.Context, sessionID string, window *replayWindow, resolveDeltas bool, emit func(*api.ReplayEvent) error) error {
+	anchors, err := r.loadSessionAnchors(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if len(anchors) == 0 {
+		return errors.NotFound(fmt.Sprintf("session %s not found", sessionID))
+	}
+
+	// Pose history records poses only, so samples carry the current metadata
+	metadata := make(map[string]map[string]interface{}, len(anchors))
+	for _, anchor := range anchors {
+		metadata[anchor.ID] = anchor.Metadata
+	}
+
+	// Anchors written before pose history was recorded have no samples, so
+	// their current pose stands in for one
+	poseQuery := `
+		FOR sample IN UNION(
+			(FOR p IN @@poses OPTIONS { indexHint: "idx_pose_session_timestamp" }
+			FILTER p.session_id == @session_id
+			FILTER p.timestamp >= @from AND p.timestamp < @to
+			RETURN { anchor_id: p.anchor_id, session_id: p.session_id, pose: p.pose, timestamp: p.timestamp }),
+			(FOR a IN @@anchors OPTIONS { indexHint: "idx_session_id" }
+			FILTER a.session_id == @session_id
+			FILTER a.timestamp >= @from AND a.timestamp < @to
+			FILTER LENGTH(FOR p IN @@poses FILTER p.anchor_id == a.id AND p.session_id == a.session_id LIMIT 1 RETURN 1) == 0
+			RETURN { anchor_id: a.id, session_id: a.session_id, pose: a.pose, timestamp: a.timestamp })
+		)
+		SORT sample.timestamp, sample.anchor_id
+		RETURN sample
+	`
+	poseCursor, err := r.runQuery(ctx, poseQuery, map[string]interface{}{
+		"@poses":     database.AnchorPosesCollection,
+		"@anchors":   database.AnchorsCollection,
+		"session_id": sessionID,
+		"from":       window.from,
+		"to":         window.to,
+	})
+	if err != nil {
+		return databaseError("failed to query pose history", err)
+	}
+	defer poseCursor.Close()
+
+	meshQuery := `
+		FOR m IN @@meshes
+		FILTER m.session_id == @session_id AND m.lod_of == null
+		FILTER m.timestamp >= @from AND m.timestamp < @to
+		SORT m.timestamp, m.id
+		RETURN m
+	`
+	meshCursor, err := r.runQuery(ctx, meshQuery, map[string]interface{}{
+		"@meshes":    database.MeshesCollection,
+		"session_id": sessionID,
+		"from":       window.from,
+		"to":         window.to,
+	})
+	if err != nil {
+		return databaseError("failed to query meshes", err)
+	}
+	defer meshCursor.Close()
+
+	nextAnchor := func() (*api.Anchor, error) {
+		var sample poseSample
+		if _, err := poseCursor.ReadDocument(ctx, &sample); driver.IsNoMoreDocuments(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, databaseError("failed to read pose sample", err)
+		}
+		return &api.Anchor{
+			ID:        sample.AnchorID,
+			SessionID: sample.SessionID,
+			Pose:      sample.Pose,
+			Timestamp: sample.Timestamp,
+			Metadata:  metadata[sample.AnchorID],
+		}, nil
+	}
+
+	nextMesh := func() (*api.Mesh, error) {
+		var mesh api.Mesh
+		if _, err := meshCursor.ReadDocument(ctx, &mesh); driver.IsNoMoreDocuments(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, databaseError("failed to read mesh", err)
+		}
+		if err := r.fetchMeshBuffers(ctx, &mesh); err != nil {
+			r.log(ctx).Warnf("Replaying mesh %s without its geometry: %v", mesh.ID, err)
+			return &mesh, nil
+		}
+		if !mesh.IsDelta || !resolveDeltas {
+			return &mesh, nil
+		}
+		resolved, err := r.resolveDeltaMesh(ctx, &mesh)
+		if err != nil {
+			r.log(ctx).Warnf("Replaying delta mesh %s unresolved: %v", mesh.ID, err)
+			return &mesh, nil
+		}
+		return resolved, nil
+	}
+
+	return mergeReplay(sessionID, window.speed, nextAnchor, nextMesh, emit)
+}
+
+// mergeReplay merges anchors and meshes, each already in timestamp order, into
+// events of up to replayEventSize records sharing a timestamp. Anchors come
+// before meshes of the same timestamp so that meshes find their anchor.
+// nextAnchor and nextMesh return nil once exhausted.
+func mergeReplay(sessionID string, speed float64, nextAnchor func() (*api.Anchor, error), nextMesh func() (*api.Mesh, error), emit func(*api.ReplayEvent) error) error {
+	anchor, err := nextAnchor()
+	if err != nil {
+		return err
+	}
+	mesh, err := nextMesh()
+	if err != nil {
+		return err
+	}
+
+	var event *api.ReplayEvent
+	var start int64
+	started := false
+	part := 0
+
+	for anchor != nil || mesh != nil {
+		var timestamp int64
+		takeAnchor := anchor != nil && (mesh == nil || anchor.Timestamp <= mesh.Timestamp)
+		if takeAnchor {
+			timestamp = anchor.Timestamp
+		} else {
+			timestamp = mesh.Timestamp
+		}
+
+		if event != nil && (event.Timestamp != timestamp || len(event.Anchors)+len(event.Meshes) >= replayEventSize) {
+			if event.Timestamp == timestamp {
+				part++
+			} else {
+				part = 0
+			}
+			if err := emit(event); err != nil {
+				return err
+			}
+			event = nil
+		}
+		if event == nil {
+			if !started {
+				start, started = timestamp, true
+			}
+			event = &api.ReplayEvent{
+				SpatialEvent: api.SpatialEvent{
+					SessionID: sessionID,
+					EventID:   fmt.Sprintf("replay-%d-%d", timestamp, part),
+					Timestamp: timestamp,
+					Anchors:   []api.Anchor{},
+					Meshes:    []api.Mesh{},
+				},
+				ReplayOffsetMs: int64(float64(timestamp-start) / speed),
+			}
+		}
+
+		if takeAnchor {
+			event.Anchors = append(event.Anchors, *anchor)
+			anchor, err = nextAnchor()
+		} else {
+			event.Meshes = append(event.Meshes, *mesh)
+			mesh, err = nextMesh()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if event != nil {
+		return emit(event)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package spatial
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func TestNewReplayWindow(t *testing.T) {
+	window, err := newReplayWindow(&api.ReplayParams{From: 1000})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if window.to != math.MaxInt64 || window.speed != 1 {
+		t.Errorf("Expected an open end at speed 1, got %+v", window)
+	}
+
+	for name, params := range map[string]*api.ReplayParams{
+		"NegativeFrom":  {From: -1},
+		"Inverted":      {From: 2000, To: 1000},
+		"Empty":         {From: 1000, To: 1000},
+		"NegativeSpeed": {Speed: -2},
+		"InfiniteSpeed": {Speed: math.Inf(1)},
+	} {
+		if _, err := newReplayWindow(params); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+// replaySource returns a next function over records
+func replaySource[T any](records []T) func() (*T, error) {
+	return func() (*T, error) {
+		if len(records) == 0 {
+			return nil, nil
+		}
+		record := &records[0]
+		records = records[1:]
+		return record, nil
+	}
+}
+
+func TestMergeReplay(t *testing.T) {
+	anchors := []api.Anchor{
+		{ID: "a1", Timestamp: 1000},
+		{ID: "a1", Timestamp: 2000},
+		{ID: "a2", Timestamp: 2000},
+	}
+	meshes := []api.Mesh{
+		{ID: "m1", Timestamp: 500},
+		{ID: "m2", Timestamp: 2000},
+		{ID: "m3", Timestamp: 3000},
+	}
+
+	var events []*api.ReplayEvent
+	err := mergeReplay("s1", 2, replaySource(anchors), replaySource(meshes), func(event *api.ReplayEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []struct {
+		timestamp int64
+		offset    int64
+		anchors   int
+		meshes    int
+	}{
+		{500, 0, 0, 1},
+		{1000, 250, 1, 0},
+		{2000, 750, 2, 1},
+		{3000, 1250, 0, 1},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events, got %d", len(want), len(events))
+	}
+	for i, w := range want {
+		event := events[i]
+		if event.Timestamp != w.timestamp || event.ReplayOffsetMs != w.offset ||
+			len(event.Anchors) != w.anchors || len(event.Meshes) != w.meshes {
+			t.Errorf("Event %d: expected %+v, got timestamp %d offset %d with %d anchors and %d meshes",
+				i, w, event.Timestamp, event.ReplayOffsetMs, len(event.Anchors), len(event.Meshes))
+		}
+		if event.SessionID != "s1" || event.EventID != fmt.Sprintf("replay-%d-0", w.timestamp) {
+			t.Errorf("Event %d: unexpected session %q or event ID %q", i, event.SessionID, event.EventID)
+		}
+	}
+}
+
+func TestMergeReplaySplitsLargeTimestamps(t *testing.T) {
+	anchors := make([]api.Anchor, replayEventSize+1)
+	for i := range anchors {
+		anchors[i] = api.Anchor{ID: fmt.Sprintf("a%d", i), Timestamp: 1000}
+	}
+
+	var events []*api.ReplayEvent
+	err := mergeReplay("s1", 1, replaySource(anchors), replaySource([]api.Mesh{{ID: "m1", Timestamp: 1000}}), func(event *api.ReplayEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(events) != 2 || len(events[0].Anchors) != replayEventSize {
+		t.Fatalf("Expected a full event and a remainder, got %d events", len(events))
+	}
+	if len(events[1].Anchors) != 1 || len(events[1].Meshes) != 1 || events[1].EventID != "replay-1000-1" {
+		t.Errorf("Expected the remaining anchor and the mesh in replay-1000-1, got %+v", events[1].SpatialEvent)
+	}
+}
@@ -7,13 +7,22 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/arangodb/go-driver"
-	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/internal/config"
 	"github.com/tabular/stag-v2/internal/database"
 	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/internal/objectstore"
+	"github.com/tabular/stag-v2/internal/tracing"
 	"github.com/tabular/stag-v2/pkg/api"
 	"github.com/tabular/stag-v2/pkg/errors"
 	"github.com/tabular/stag-v2/pkg/logger"
@@ -21,107 +30,649 @@ import (
 
 // Repository handles spatial data operations
 type Repository struct {
-	db               *database.Connection
-	logger           logger.Logger
-	metrics          *metrics.Metrics
-	meshHashCache    map[string]string // hash -> mesh ID
-	compressionCache map[string][]byte // mesh ID -> compressed data
-	cacheExpiry      time.Duration
+	db                 *database.Connection
+	logger             logger.Logger
+	metrics            *metrics.Metrics
+	meshHashCache      *hashCache        // hash -> mesh ID
+	compressionCache   map[string][]byte // mesh ID -> compressed data
+	cacheExpiry        time.Duration
+	storageCodec       Codec             // nil stores geometry uncompressed
+	compressionLevel   int               // Storage level for meshes and sessions that set none
+	maxDeltaDepth      int               // Most deltas applied to resolve one mesh, 0 is unlimited
+	neighborDistance   float64           // Topology edge range in meters, 0 disables
+	maxHops            int               // Upper bound on neighbor traversal depth
+	maxPathDepth       int               // Most edges a shortest path may cross, 0 is unlimited
+	normalizeRotations bool              // Scale non-unit quaternions instead of rejecting them
+	generateNormals    bool              // Compute normals for full meshes sent without them
+	clampLevels        bool              // Limit out of range mesh compression levels instead of rejecting them
+	maxEventAnchors    int               // Most anchors per ingested event, 0 disables
+	maxEventMeshes     int               // Most meshes per ingested event, 0 disables
+	diffTolerance      float64           // Largest change an anchor_diff treats as unchanged
+	metadataSchema     map[string]string // Allowed anchor metadata keys and types, nil accepts any
+	maxMeshSize        int64             // Largest decoded mesh update geometry in bytes, 0 disables
+	writeRetries       int               // Extra attempts for ingest writes that fail transiently
+	writeRetryDelay    time.Duration     // Backoff before the first retry, doubled per attempt
+	bulkAnchors        int               // Fewest anchors of an event upserted in one query, 0 disables
+	metricsSessionTTL  time.Duration     // Idle time after which a session's metric series are deleted
+	queryTimeout       time.Duration     // Default limit on one AQL query, 0 disables
+	defaultLimit       int               // Page size of queries that set no limit
+	maxLimit           int               // Largest page size a query may request, 0 is unlimited
+	metadataIndexes    []string          // Indexed anchor metadata keys, recreated by snapshot restores
+	migrationTimeout   time.Duration     // Deadline of data migrations run by snapshot restores, 0 is none
+	poseHistory        poseHistoryLimits // Pose samples kept per anchor
+
+	// Object storage for large mesh buffers, nil keeps them in the database
+	objects         objectstore.Store
+	objectThreshold int64         // Smallest offloaded geometry in stored bytes
+	objectTimeout   time.Duration // Limit on one object read or write, 0 disables
+	objectPrefix    string        // Prepended to object keys
+
+	// Latest event timestamps per session, nil when timestamps are not validated
+	timestamps *timestampGuard
+
+	// Last stored pose per anchor, nil when anchor updates are not deduplicated
+	anchorDedup *anchorDedup
+
+	// Unfinished chunked mesh uploads
+	uploads *meshUploads
+
+	// Records of writes and who made them, nil when auditing is disabled
+	auditLog *audit.Logger
+
+	// Per-session storage compression levels, overriding compressionLevel
+	levelsMu      sync.RWMutex
+	sessionLevels map[string]int
+
+	// Last storage breakdown, see StorageStats
+	storageStats storageStatsCache
+
+	// Background janitor lifecycle
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
 // NewRepository creates a new spatial repository
-func NewRepository(db *database.Connection, logger logger.Logger, metrics *metrics.Metrics) *Repository {
-	return &Repository{
-		db:               db,
-		logger:           logger,
-		metrics:          metrics,
-		meshHashCache:    make(map[string]string),
-		compressionCache: make(map[string][]byte),
-		cacheExpiry:      5 * time.Minute,
+func NewRepository(cfg *config.Config, db *database.Connection, logger logger.Logger, metrics *metrics.Metrics) *Repository {
+	r := &Repository{
+		db:                 db,
+		logger:             logger,
+		metrics:            metrics,
+		meshHashCache:      newHashCache(cfg.Dedup.CacheExpiry),
+		compressionCache:   make(map[string][]byte),
+		cacheExpiry:        cfg.Dedup.CacheExpiry,
+		compressionLevel:   ClampCompressionLevel(cfg.Compression.DefaultLevel),
+		maxDeltaDepth:      cfg.Compression.MaxDeltaDepth,
+		sessionLevels:      make(map[string]int),
+		neighborDistance:   cfg.Topology.NeighborDistance,
+		maxHops:            cfg.Topology.MaxHops,
+		maxPathDepth:       cfg.Topology.MaxPathDepth,
+		normalizeRotations: cfg.Validation.NormalizeRotations,
+		clampLevels:        cfg.Validation.ClampCompressionLevels,
+		generateNormals:    cfg.Validation.GenerateNormals,
+		maxEventAnchors:    cfg.Validation.MaxAnchorsPerEvent,
+		maxEventMeshes:     cfg.Validation.MaxMeshesPerEvent,
+		diffTolerance:      cfg.WebSocket.AnchorDiffTolerance,
+		maxMeshSize:        cfg.WebSocket.MaxMeshSize,
+		writeRetries:       cfg.Database.WriteRetries,
+		writeRetryDelay:    cfg.Database.WriteRetryDelay,
+		bulkAnchors:        cfg.Database.BulkAnchorThreshold,
+		metricsSessionTTL:  cfg.Metrics.SessionTTL,
+		queryTimeout:       cfg.Database.QueryTimeout,
+		defaultLimit:       cfg.Query.DefaultLimit,
+		maxLimit:           cfg.Query.MaxLimit,
+		metadataIndexes:    cfg.Database.MetadataIndexes,
+		migrationTimeout:   cfg.Database.MigrationTimeout,
+		poseHistory:        newPoseHistoryLimits(cfg.PoseHistory),
+		objectThreshold:    cfg.MeshStorage.Threshold,
+		objectTimeout:      cfg.MeshStorage.FetchTimeout,
+		objectPrefix:       cfg.MeshStorage.Prefix,
+		done:               make(chan struct{}),
 	}
+	r.timestamps = newTimestampGuard(cfg.Validation.MaxFutureSkew, cfg.Validation.MonotonicTimestamps,
+		cfg.Validation.MonotonicTolerance, cfg.Validation.TimestampSessionTTL)
+	r.anchorDedup = newAnchorDedup(cfg.Dedup.Anchors)
+	r.uploads = newMeshUploads(cfg.Upload)
+
+	codec, err := codecByName(cfg.Compression.Codec)
+	if err != nil {
+		logger.Warnf("%v, storing meshes uncompressed", err)
+	}
+	r.storageCodec = codec
+
+	objects, err := objectstore.New(cfg.MeshStorage)
+	if err != nil {
+		logger.Warnf("%v, storing mesh buffers in the database", err)
+	}
+	r.objects = objects
+
+	schema, err := cfg.Validation.MetadataFields()
+	if err != nil {
+		logger.Warnf("%v, accepting any anchor metadata", err)
+	}
+	r.metadataSchema = schema
+
+	if r.cacheExpiry > 0 {
+		r.wg.Add(1)
+		go r.runCacheJanitor()
+	}
+	if r.metricsSessionTTL > 0 {
+		r.wg.Add(1)
+		go r.runMetricsJanitor()
+	}
+	if r.timestamps != nil && r.timestamps.ttl > 0 {
+		r.wg.Add(1)
+		go r.runTimestampJanitor(r.timestamps.ttl)
+	}
+	if r.anchorDedup != nil && r.anchorDedup.ttl > 0 {
+		r.wg.Add(1)
+		go r.runAnchorDedupJanitor(r.anchorDedup.ttl)
+	}
+	if r.uploads.ttl > 0 {
+		r.wg.Add(1)
+		go r.runUploadJanitor()
+	}
+
+	return r
+}
+
+// Close stops background work started by the repository
+func (r *Repository) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+	r.wg.Wait()
+}
+
+// runCacheJanitor periodically evicts expired dedup cache entries
+func (r *Repository) runCacheJanitor() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.cacheExpiry / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if evicted := r.meshHashCache.evictExpired(now); evicted > 0 {
+				r.logger.Debugf("Evicted %d expired mesh hashes", evicted)
+			}
+			r.updateCacheSize()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// runMetricsJanitor periodically deletes the metric series of idle sessions
+func (r *Repository) runMetricsJanitor() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.metricsSessionTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if forgotten := r.metrics.ForgetIdleSessions(now.Add(-r.metricsSessionTTL)); forgotten > 0 {
+				r.logger.Debugf("Deleted metric series of %d idle sessions", forgotten)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// log returns the repository logger annotated with the trace ID of ctx
+func (r *Repository) log(ctx context.Context) logger.Logger {
+	return logger.FromContext(ctx, r.logger)
+}
+
+// updateCacheSize publishes the current dedup cache size
+func (r *Repository) updateCacheSize() {
+	r.metrics.MeshDedupCacheSize.Set(float64(r.meshHashCache.size()))
+}
+
+// ingestResult tracks the side effects of ingesting one event so they can be
+// published once stored or rolled back if the write is abandoned
+type ingestResult struct {
+	reserved    []hashCacheRef // dedup cache entries added for new meshes
+	savedBytes  int64
+	rawBytes    int64 // Decompressed geometry of newly stored meshes
+	storedBytes int64 // The same geometry as stored
+	levels      []int // Compression levels of newly stored meshes
+}
+
+// hashCacheRef identifies a dedup cache entry
+type hashCacheRef struct {
+	hash   string
+	meshID string
+}
+
+// meshPayloadSize is the size of a mesh's buffers as received, before they
+// are compressed for storage: the delta data of a delta mesh, otherwise its
+// vertices, faces and normals
+func meshPayloadSize(mesh *api.Mesh) int {
+	if mesh.IsDelta && len(mesh.DeltaData) > 0 {
+		return len(mesh.DeltaData)
+	}
+	return len(mesh.Vertices) + len(mesh.Faces) + len(mesh.Normals)
+}
+
+// observeAnchorSize records the size of an ingested anchor as JSON
+func (r *Repository) observeAnchorSize(anchor *api.Anchor) {
+	data, err := json.Marshal(anchor)
+	if err != nil {
+		return
+	}
+	r.metrics.AnchorBytes.Observe(float64(len(data)))
+}
+
+// ValidateEventSize rejects an event carrying more anchors or meshes than
+// the configured per-event limits
+func (r *Repository) ValidateEventSize(event *api.SpatialEvent) error {
+	if r.maxEventAnchors > 0 && len(event.Anchors) > r.maxEventAnchors {
+		return errors.ValidationError(fmt.Sprintf("event %s has %d anchors, above the limit of %d per event",
+			event.EventID, len(event.Anchors), r.maxEventAnchors))
+	}
+	if r.maxEventMeshes > 0 && len(event.Meshes) > r.maxEventMeshes {
+		return errors.ValidationError(fmt.Sprintf("event %s has %d meshes, above the limit of %d per event",
+			event.EventID, len(event.Meshes), r.maxEventMeshes))
+	}
+	return nil
 }
 
 // Ingest processes and stores spatial events
-func (r *Repository) Ingest(ctx context.Context, event *api.SpatialEvent) error {
+func (r *Repository) Ingest(ctx context.Context, event *api.SpatialEvent) (err error) {
 	startTime := time.Now()
 	defer func() {
 		r.metrics.DBOperationDuration.WithLabelValues("ingest", "spatial_event").
 			Observe(time.Since(startTime).Seconds())
 	}()
 
+	ctx, span := tracing.Start(ctx, "Repository.Ingest",
+		tracing.SessionIDKey.String(event.SessionID), tracing.OperationKey.String("ingest"))
+	defer func() { tracing.End(span, err) }()
+
+	events := []api.SpatialEvent{*event}
+	if errs := r.checkEventTimestamps(ctx, events); errs[0] != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("ingest", "spatial_event", "error").Inc()
+		return errs[0]
+	}
+
+	// Unchanged poses are dropped before the event is stored, and so are not
+	// broadcast either
+	r.dedupeAnchors(ctx, events)
+	*event = events[0]
+
+	// Anchors and meshes of an event are stored atomically, retrying the
+	// whole transaction when it fails transiently
+	var result *ingestResult
+	err = r.retryWrite(ctx, "ingest", func() error {
+		result = nil
+		err := r.withTransaction(ctx, func(txCtx context.Context) error {
+			var err error
+			result, err = r.ingestEvent(txCtx, event)
+			return err
+		})
+		if err != nil && result != nil {
+			r.rollbackIngest(result)
+		}
+		return err
+	})
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("ingest", "spatial_event", "error").Inc()
+		r.log(ctx).Debugf("Failed to store event %s: %v", event.EventID, err)
+		return err
+	}
+
+	r.recordIngest(event, result)
+	r.auditEvent(ctx, event)
+	r.acceptEventTimestamps(ctx, events)
+	r.acceptAnchorPoses(ctx, events)
+	r.log(ctx).Debugf("Stored event %s for session %s (%d anchors, %d meshes)",
+		event.EventID, event.SessionID, len(event.Anchors), len(event.Meshes))
+	return nil
+}
+
+// IngestBatch processes multiple spatial events. In atomic mode all events are
+// written in a single transaction and any failure rolls back the whole batch;
+// otherwise each event succeeds or fails independently.
+func (r *Repository) IngestBatch(ctx context.Context, events []api.SpatialEvent, atomic bool) ([]error, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("ingest_batch", "spatial_event").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	r.metrics.IngestBatchSize.Observe(float64(len(events)))
+
+	errs := make([]error, len(events))
+	if !atomic {
+		for i := range events {
+			errs[i] = r.Ingest(ctx, &events[i])
+		}
+		return errs, nil
+	}
+
+	// Timestamps are checked up front, so a rejected event writes nothing
+	for i, err := range r.checkEventTimestamps(ctx, events) {
+		if err != nil {
+			errs[i] = err
+			return errs, err
+		}
+	}
+	r.dedupeAnchors(ctx, events)
+
+	var results []*ingestResult
+	err := r.retryWrite(ctx, "ingest_batch", func() error {
+		results = make([]*ingestResult, 0, len(events))
+		clear(errs)
+		err := r.withTransaction(ctx, func(txCtx context.Context) error {
+			for i := range events {
+				result, err := r.ingestEvent(txCtx, &events[i])
+				if err != nil {
+					errs[i] = err
+					return err
+				}
+				results = append(results, result)
+			}
+			return nil
+		})
+		if err != nil {
+			for _, result := range results {
+				r.rollbackIngest(result)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return errs, err
+	}
+
+	for i, result := range results {
+		r.recordIngest(&events[i], result)
+		r.auditEvent(ctx, &events[i])
+	}
+	r.acceptEventTimestamps(ctx, events)
+	r.acceptAnchorPoses(ctx, events)
+	r.log(ctx).Debugf("Stored atomic batch of %d events", len(events))
+	return errs, nil
+}
+
+// ingestEvent writes the anchors and meshes of one event
+func (r *Repository) ingestEvent(ctx context.Context, event *api.SpatialEvent) (*ingestResult, error) {
+	result := &ingestResult{}
+
+	// Reject malformed rotations and metadata before anything is written
+	for i := range event.Anchors {
+		anchor := &event.Anchors[i]
+		if err := validateRotation(anchor.ID, &anchor.Pose, r.normalizeRotations); err != nil {
+			return nil, err
+		}
+		if err := validateMetadata(anchor.ID, anchor.Metadata, r.metadataSchema); err != nil {
+			return nil, err
+		}
+	}
+
 	// Process anchors
+	if err := r.storeAnchors(ctx, event.Anchors); err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("ingest", "anchors", "error").Inc()
+		r.rollbackIngest(result)
+		return nil, err
+	}
+
+	// Link anchors once all of the event's anchors are stored
 	for _, anchor := range event.Anchors {
-		if err := r.ingestAnchor(ctx, &anchor); err != nil {
-			r.metrics.DBOperationsTotal.WithLabelValues("ingest", "anchors", "error").Inc()
-			return fmt.Errorf("failed to ingest anchor %s: %w", anchor.ID, err)
+		if err := r.buildTopology(ctx, anchor.SessionID, anchor.ID); err != nil {
+			r.rollbackIngest(result)
+			return nil, fmt.Errorf("failed to link anchor %s: %w", anchor.ID, err)
 		}
-		r.metrics.AnchorsTotal.WithLabelValues(event.SessionID, "ingest").Inc()
 	}
 
 	// Process meshes
 	for _, mesh := range event.Meshes {
+		mesh.SessionID = event.SessionID
 		processedMesh, saved, err := r.processMeshForStorage(ctx, &mesh)
 		if err != nil {
 			r.metrics.DBOperationsTotal.WithLabelValues("ingest", "meshes", "error").Inc()
-			return fmt.Errorf("failed to process mesh %s: %w", mesh.ID, err)
+			r.rollbackIngest(result)
+			return nil, fmt.Errorf("failed to process mesh %s: %w", mesh.ID, err)
 		}
 
-		if err := r.ingestMesh(ctx, processedMesh); err != nil {
-			r.metrics.DBOperationsTotal.WithLabelValues("ingest", "meshes", "error").Inc()
-			return fmt.Errorf("failed to ingest mesh %s: %w", mesh.ID, err)
+		// Duplicates reference the already stored mesh
+		if saved == 0 {
+			if err := r.ingestMesh(ctx, processedMesh); err != nil {
+				r.releaseMeshHash(processedMesh.Hash, processedMesh.ID)
+				r.metrics.DBOperationsTotal.WithLabelValues("ingest", "meshes", "error").Inc()
+				r.rollbackIngest(result)
+				return nil, fmt.Errorf("failed to ingest mesh %s: %w", mesh.ID, err)
+			}
+			if processedMesh.Hash != "" {
+				result.reserved = append(result.reserved, hashCacheRef{hash: processedMesh.Hash, meshID: processedMesh.ID})
+			}
+			if processedMesh.RawSize > 0 {
+				result.rawBytes += processedMesh.RawSize
+				result.storedBytes += meshBufferSize(processedMesh)
+				result.levels = append(result.levels, processedMesh.CompressionLevel)
+			}
 		}
 
-		// Track deduplication savings
-		if saved > 0 {
-			r.metrics.MeshDedupSavedBytes.WithLabelValues(event.SessionID).Add(float64(saved))
-		}
+		result.savedBytes += saved
+	}
+
+	return result, nil
+}
+
+// recordIngest publishes metrics for a stored event
+func (r *Repository) recordIngest(event *api.SpatialEvent, result *ingestResult) {
+	r.metrics.AnchorsTotal.WithLabelValues(r.metrics.SessionSeries(event.SessionID), "ingest").Add(float64(len(event.Anchors)))
+	for i := range event.Anchors {
+		r.observeAnchorSize(&event.Anchors[i])
+	}
 
+	for i, mesh := range event.Meshes {
 		meshType := "full"
 		if mesh.IsDelta {
 			meshType = "delta"
 		}
-		r.metrics.MeshesTotal.WithLabelValues(event.SessionID, meshType, "ingest").Inc()
+		r.metrics.MeshesTotal.WithLabelValues(r.metrics.SessionSeries(event.SessionID), meshType, "ingest").Inc()
+		r.metrics.MeshBytes.WithLabelValues(meshType).Observe(float64(meshPayloadSize(&event.Meshes[i])))
+	}
+
+	// Track deduplication savings
+	if result.savedBytes > 0 {
+		r.metrics.MeshDedupSavedBytes.WithLabelValues(r.metrics.SessionSeries(event.SessionID)).Add(float64(result.savedBytes))
+	}
+
+	if result.rawBytes > 0 {
+		r.metrics.CompressionRatio.WithLabelValues(r.metrics.SessionSeries(event.SessionID)).Set(float64(result.storedBytes) / float64(result.rawBytes))
+	}
+	for _, level := range result.levels {
+		r.metrics.MeshCompressionLevel.WithLabelValues(r.metrics.SessionSeries(event.SessionID)).Observe(float64(level))
 	}
 
 	r.metrics.DBOperationsTotal.WithLabelValues("ingest", "spatial_event", "success").Inc()
-	return nil
 }
 
-// ingestAnchor stores an anchor in the database
-func (r *Repository) ingestAnchor(ctx context.Context, anchor *api.Anchor) error {
-	col, err := r.db.Database().Collection(ctx, database.AnchorsCollection)
+// rollbackIngest drops dedup cache entries for meshes that were not kept
+func (r *Repository) rollbackIngest(result *ingestResult) {
+	for _, ref := range result.reserved {
+		r.releaseMeshHash(ref.hash, ref.meshID)
+	}
+	result.reserved = nil
+}
+
+// transactionKey marks the context of writes inside a stream transaction
+type transactionKey struct{}
+
+// withTransaction runs fn inside an ArangoDB stream transaction over the
+// spatial collections, committing on success and aborting on error
+func (r *Repository) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	db := r.db.Database()
+
+	tid, err := db.BeginTransaction(ctx, driver.TransactionCollections{
+		Write: []string{database.AnchorsCollection, database.AnchorPosesCollection, database.MeshesCollection, database.TopologyEdges},
+	}, nil)
 	if err != nil {
-		return errors.DatabaseError(fmt.Sprintf("failed to get collection: %v", err))
+		return databaseError("failed to begin transaction", err)
+	}
+
+	txCtx := context.WithValue(driver.WithTransactionID(ctx, tid), transactionKey{}, true)
+	if err := fn(txCtx); err != nil {
+		if abortErr := db.AbortTransaction(ctx, tid, nil); abortErr != nil {
+			r.log(ctx).Errorf("Failed to abort transaction %s: %v", tid, abortErr)
+		}
+		if _, ok := errors.IsAPIError(err); ok {
+			return err
+		}
+		return databaseError("transaction aborted", err)
+	}
+
+	if err := db.CommitTransaction(ctx, tid, nil); err != nil {
+		return databaseError("failed to commit transaction", err)
+	}
+
+	return nil
+}
+
+// runQuery executes an AQL query under the query timeout, counting it as in
+// flight until ArangoDB responds
+func (r *Repository) runQuery(ctx context.Context, query string, bindVars map[string]interface{}) (driver.Cursor, error) {
+	r.metrics.DBInFlightQueries.Inc()
+	defer r.metrics.DBInFlightQueries.Dec()
+
+	// The first batch is complete once Query returns, and later batches are
+	// fetched with the context the caller passes to the cursor
+	ctx, cancel := database.QueryContext(ctx, r.queryTimeout)
+	defer cancel()
+
+	// The query text is parameterized, so it names the operation without
+	// carrying values
+	ctx, span := tracing.Tracer().Start(ctx, "arangodb.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "arangodb"),
+			attribute.String("db.query.text", strings.TrimSpace(query)),
+		))
+	cursor, err := r.db.Database().Query(ctx, query, bindVars)
+	tracing.End(span, err)
+	return cursor, err
+}
+
+// databaseError maps a failed database operation to an API error, reporting
+// transient failures as retryable conflicts or unavailability rather than 500s
+func databaseError(message string, err error) *errors.APIError {
+	message = fmt.Sprintf("%s: %v", message, err)
+
+	var apiErr *errors.APIError
+	switch database.ClassifyError(err) {
+	case database.ErrorClassConflict:
+		apiErr = errors.DatabaseConflict(message)
+	case database.ErrorClassTimeout:
+		apiErr = errors.QueryTimeout(message)
+	case database.ErrorClassUnavailable, database.ErrorClassLeaderChanged:
+		apiErr = errors.DatabaseUnavailable(message)
+	case database.ErrorClassCanceled:
+		apiErr = errors.ClientClosedRequest(message)
+	default:
+		apiErr = errors.DatabaseError(message)
+	}
+	apiErr.Err = err
+	return apiErr
+}
+
+// retryWrite runs a write, repeating it with exponential backoff while it
+// fails transiently and retries remain. ArangoDB aborts a stream transaction
+// on a write conflict, so writes inside one run once; the transaction is
+// retried as a whole instead.
+func (r *Repository) retryWrite(ctx context.Context, operation string, write func() error) error {
+	if ctx.Value(transactionKey{}) != nil {
+		return write()
+	}
+
+	delay := r.writeRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := write()
+		if err == nil || attempt >= r.writeRetries {
+			return err
+		}
+
+		apiErr, ok := errors.IsAPIError(err)
+		if !ok || !apiErr.IsRetryable() {
+			return err
+		}
+
+		class := database.ClassifyError(apiErr.Unwrap())
+		r.metrics.DBRetriesTotal.WithLabelValues(operation, string(class)).Inc()
+		r.log(ctx).Debugf("Retrying %s after %v (attempt %d/%d)", operation, err, attempt+1, r.writeRetries)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
 	}
+}
 
-	// Use UPSERT to handle updates
+// ingestAnchor stores an anchor in the database, returning the anchor as it
+// was stored before or nil if it is new
+func (r *Repository) ingestAnchor(ctx context.Context, anchor *api.Anchor) (*api.Anchor, error) {
+	// Anchor IDs are unique per session, see ResolveAnchorSession
 	query := `
-		UPSERT { id: @id }
+		UPSERT { session_id: @session_id, id: @id }
 		INSERT @anchor
 		UPDATE @anchor
 		IN @@collection
-		RETURN NEW
+		RETURN { old: OLD }
 	`
 
 	bindVars := map[string]interface{}{
-		"id":         anchor.ID,
-		"anchor":     anchor,
+		"session_id":  anchor.SessionID,
+		"id":          anchor.ID,
+		"anchor":      anchorDocument{Anchor: anchor, Location: poseLocation(anchor.Pose)},
 		"@collection": database.AnchorsCollection,
 	}
 
-	cursor, err := r.db.Database().Query(ctx, query, bindVars)
-	if err != nil {
-		return errors.DatabaseError(fmt.Sprintf("failed to upsert anchor: %v", err))
-	}
-	defer cursor.Close()
+	// Concurrent upserts of one anchor conflict, so transient failures are retried
+	var previous *api.Anchor
+	err := r.retryWrite(ctx, "ingest_anchor", func() error {
+		cursor, err := r.runQuery(ctx, query, bindVars)
+		if err != nil {
+			return databaseError("failed to upsert anchor", err)
+		}
+		defer cursor.Close()
 
-	return nil
+		var result struct {
+			Old *api.Anchor `json:"old"`
+		}
+		if _, err := cursor.ReadDocument(ctx, &result); err != nil {
+			return databaseError("failed to read upserted anchor", err)
+		}
+		previous = result.Old
+
+		return r.recordPose(ctx, anchor)
+	})
+	return previous, err
 }
 
 // processMeshForStorage handles mesh deduplication and delta processing
 func (r *Repository) processMeshForStorage(ctx context.Context, mesh *api.Mesh) (*api.Mesh, int64, error) {
 	var savedBytes int64
 
+	// Nested meshes escape the binding tag on HTTP ingest, and delta meshes
+	// keep their level until resolved
+	level, err := r.checkCompressionLevel(mesh.ID, mesh.CompressionLevel)
+	if err != nil {
+		return nil, 0, err
+	}
+	mesh.CompressionLevel = level
+
+	// Levels of detail are only generated by the server
+	mesh.LODOf = ""
+	mesh.TriangleCount = 0
+
 	// If it's a delta mesh, validate and store as-is
 	if mesh.IsDelta {
 		if mesh.BaseMeshID == "" {
@@ -136,77 +687,276 @@ func (r *Repository) processMeshForStorage(ctx context.Context, mesh *api.Mesh)
 		return mesh, 0, nil
 	}
 
+	// Decompress to validate the payload and hash the geometry itself, so the
+	// same mesh dedups regardless of how the client compressed it. Geometry the
+	// server cannot decode is hashed as sent.
+	decoded := mesh
+	opaque := isOpaqueCodec(mesh.CompressionCodec)
+	if opaque {
+		// Only the declared layout of opaque geometry can be checked
+		if _, err := layoutOf(mesh); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		var err error
+		if decoded, err = decodeMeshBuffers(mesh); err != nil {
+			return nil, 0, err
+		}
+		if err := validateGeometry(decoded); err != nil {
+			return nil, 0, err
+		}
+		normalizeLayout(decoded)
+	}
+
 	// Compute hash for deduplication
-	hash := r.computeMeshHash(mesh)
+	hash := r.computeMeshHash(decoded)
 	mesh.Hash = hash
+	if !opaque {
+		mesh.TriangleCount = len(decoded.Faces) / gltfIndexStride / 3
+	}
 
 	// Check if we've seen this mesh before
-	if existingMeshID, exists := r.meshHashCache[hash]; exists {
-		// Mesh already exists, just reference it
-		r.logger.Debugf("Mesh %s is duplicate of %s", mesh.ID, existingMeshID)
-		
-		// Calculate saved bytes
-		savedBytes = int64(len(mesh.Vertices) + len(mesh.Faces) + len(mesh.Normals))
-		
-		// Replace with reference
-		mesh.ID = existingMeshID
-		return mesh, savedBytes, nil
+	existingMeshID, exists, err := r.resolveMeshHash(ctx, hash, mesh.ID)
+	if err != nil {
+		return nil, 0, err
 	}
+	if exists {
+		referenced, err := r.referenceMesh(ctx, existingMeshID, mesh)
+		if err != nil {
+			return nil, 0, err
+		}
+		if referenced {
+			// Mesh already exists, just reference it
+			r.log(ctx).Debugf("Mesh %s is duplicate of %s", mesh.ID, existingMeshID)
 
-	// Add to cache
-	r.meshHashCache[hash] = mesh.ID
+			// Calculate saved bytes
+			savedBytes = int64(len(mesh.Vertices) + len(mesh.Faces) + len(mesh.Normals))
+
+			// Replace with reference
+			mesh.ID = existingMeshID
+			return mesh, savedBytes, nil
+		}
+		// The copy holding the hash is not stored, so store this one too
+		r.log(ctx).Debugf("Mesh %s duplicates %s, which is not stored yet", mesh.ID, existingMeshID)
+	}
+
+	if opaque {
+		return mesh, 0, nil
+	}
+
+	// Meshes sent without normals are stored with smooth ones when enabled
+	if r.generateNormals && len(decoded.Normals) == 0 && len(decoded.Faces) > 0 {
+		decoded.Normals = smoothNormals(decoded)
+	}
+
+	// Re-compress with the storage codec, whose framing identifies it on read
+	mesh.Vertices, mesh.Faces, mesh.Normals = decoded.Vertices, decoded.Faces, decoded.Normals
+	mesh.VertexStride, mesh.IndexFormat = decoded.VertexStride, decoded.IndexFormat
+	mesh.CompressionCodec = ""
+	mesh.RawSize = meshBufferSize(mesh)
+	mesh.CompressionLevel = r.storageLevel(mesh)
+	if err := encodeMeshBuffers(mesh, r.storageCodec, mesh.CompressionLevel); err != nil {
+		r.releaseMeshHash(hash, mesh.ID)
+		return nil, 0, err
+	}
 
 	return mesh, 0, nil
 }
 
-// ingestMesh stores a mesh in the database
-func (r *Repository) ingestMesh(ctx context.Context, mesh *api.Mesh) error {
-	col, err := r.db.Database().Collection(ctx, database.MeshesCollection)
+// resolveMeshHash returns the ID of the mesh already stored for a hash, checking
+// the in-memory cache first and falling back to the idx_mesh_hash index. Unknown
+// hashes are reserved for meshID so concurrent ingests of the same geometry
+// dedup against it instead of inserting it twice.
+func (r *Repository) resolveMeshHash(ctx context.Context, hash, meshID string) (string, bool, error) {
+	if existingMeshID, exists := r.meshHashCache.get(hash); exists {
+		r.metrics.MeshDedupCacheHits.Inc()
+		return existingMeshID, true, nil
+	}
+	r.metrics.MeshDedupCacheMisses.Inc()
+
+	storedMeshID, err := r.lookupMeshHash(ctx, hash)
 	if err != nil {
-		return errors.DatabaseError(fmt.Sprintf("failed to get collection: %v", err))
+		return "", false, err
 	}
+	defer r.updateCacheSize()
 
-	// Check if mesh already exists (for deduplication)
-	var existingMesh api.Mesh
-	_, err = col.ReadDocument(ctx, mesh.ID, &existingMesh)
-	if err == nil {
-		// Mesh already exists, skip
-		return nil
-	} else if !driver.IsNotFound(err) {
-		return errors.DatabaseError(fmt.Sprintf("failed to check existing mesh: %v", err))
+	// Another ingest may have reserved the hash while we were querying
+	if storedMeshID != "" {
+		existingMeshID, _ := r.meshHashCache.putIfAbsent(hash, storedMeshID)
+		return existingMeshID, true, nil
 	}
 
-	// Insert new mesh
-	_, err = col.CreateDocument(ctx, mesh)
+	existingMeshID, exists := r.meshHashCache.putIfAbsent(hash, meshID)
+	return existingMeshID, exists, nil
+}
+
+// releaseMeshHash drops a hash reservation after a failed insert
+func (r *Repository) releaseMeshHash(hash, meshID string) {
+	r.meshHashCache.remove(hash, meshID)
+	r.updateCacheSize()
+}
+
+// lookupMeshHash finds a stored mesh ID by content hash, returning "" if none
+func (r *Repository) lookupMeshHash(ctx context.Context, hash string) (string, error) {
+	query := `
+		FOR doc IN @@collection
+		FILTER doc.hash == @hash
+		LIMIT 1
+		RETURN doc.id
+	`
+
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+		"hash":        hash,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
 	if err != nil {
-		return errors.DatabaseError(fmt.Sprintf("failed to create mesh: %v", err))
+		return "", databaseError("failed to look up mesh hash", err)
 	}
+	defer cursor.Close()
 
-	// Update storage metrics
-	meshSize := int64(len(mesh.Vertices) + len(mesh.Faces) + len(mesh.Normals))
-	r.metrics.StorageSizeBytes.WithLabelValues("meshes").Add(float64(meshSize))
+	var meshID string
+	_, err = cursor.ReadDocument(ctx, &meshID)
+	if driver.IsNoMoreDocuments(err) {
+		return "", nil
+	} else if err != nil {
+		return "", databaseError("failed to read mesh hash", err)
+	}
+
+	return meshID, nil
+}
+
+// WarmMeshHashCache loads the hashes of all stored meshes into the dedup cache
+func (r *Repository) WarmMeshHashCache(ctx context.Context) (int, error) {
+	loaded := 0
+	err := r.scanMeshHashes(ctx, func(hash, meshID string) {
+		if _, exists := r.meshHashCache.putIfAbsent(hash, meshID); !exists {
+			loaded++
+		}
+	})
+
+	r.updateCacheSize()
+	return loaded, err
+}
+
+// scanMeshHashes calls visit with the hash and ID of every stored mesh that
+// has a hash, through the sparse idx_mesh_hash index
+func (r *Repository) scanMeshHashes(ctx context.Context, visit func(hash, meshID string)) error {
+	query := `
+		FOR doc IN @@collection
+		FILTER doc.hash != null
+		RETURN { hash: doc.hash, id: doc.id }
+	`
+
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return databaseError("failed to scan mesh hashes", err)
+	}
+	defer cursor.Close()
+
+	for {
+		var entry struct {
+			Hash string `json:"hash"`
+			ID   string `json:"id"`
+		}
+		_, err := cursor.ReadDocument(ctx, &entry)
+		if driver.IsNoMoreDocuments(err) {
+			return nil
+		} else if err != nil {
+			return databaseError("failed to read mesh hash", err)
+		}
+
+		visit(entry.Hash, entry.ID)
+	}
+}
+
+// ingestMesh stores a mesh in the database. A mesh already stored under its
+// ID is kept as it is.
+func (r *Repository) ingestMesh(ctx context.Context, mesh *api.Mesh) error {
+	ownReference(mesh)
+	stored := *mesh
+	r.offloadMeshBuffers(ctx, &stored)
 
+	// Mesh IDs are unique, see database.meshKeyIndex
+	query := `
+		UPSERT { id: @id }
+		INSERT @mesh
+		UPDATE {}
+		IN @@collection
+		RETURN OLD == null
+	`
+
+	bindVars := map[string]interface{}{
+		"id":          mesh.ID,
+		"mesh":        &stored,
+		"@collection": database.MeshesCollection,
+	}
+
+	// A mesh inserted concurrently conflicts; the retry then finds it stored
+	var inserted bool
+	err := r.retryWrite(ctx, "ingest_mesh", func() error {
+		cursor, err := r.runQuery(ctx, query, bindVars)
+		if err != nil {
+			return databaseError("failed to upsert mesh", err)
+		}
+		defer cursor.Close()
+
+		if _, err := cursor.ReadDocument(ctx, &inserted); err != nil {
+			return databaseError("failed to read upserted mesh", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Update storage metrics
+	if inserted {
+		r.metrics.StorageSizeBytes.WithLabelValues("meshes").Add(float64(meshBufferSize(mesh)))
+	}
 	return nil
 }
 
 // Query retrieves spatial data based on parameters
-func (r *Repository) Query(ctx context.Context, params *api.QueryParams) (*api.QueryResponse, error) {
+func (r *Repository) Query(ctx context.Context, params *api.QueryParams) (_ *api.QueryResponse, err error) {
 	startTime := time.Now()
 	defer func() {
 		r.metrics.DBOperationDuration.WithLabelValues("query", "spatial").
 			Observe(time.Since(startTime).Seconds())
 	}()
 
+	ctx, span := tracing.Start(ctx, "Repository.Query",
+		tracing.SessionIDKey.String(params.SessionID), tracing.OperationKey.String("query"))
+	defer func() { tracing.End(span, err) }()
+
 	// Build AQL query
-	query, bindVars := r.buildQuery(params)
+	query, bindVars, err := r.buildQuery(params)
+	if err != nil {
+		return nil, err
+	}
 
-	cursor, err := r.db.Database().Query(ctx, query, bindVars)
+	cursor, err := r.runQuery(ctx, query, bindVars)
 	if err != nil {
 		r.metrics.DBOperationsTotal.WithLabelValues("query", "spatial", "error").Inc()
-		return nil, errors.DatabaseError(fmt.Sprintf("failed to execute query: %v", err))
+		return nil, databaseError("failed to execute query", err)
 	}
 	defer cursor.Close()
 
+	// Counting queries return one number instead of documents
+	if params.CountOnly {
+		var count int
+		if _, err := cursor.ReadDocument(ctx, &count); err != nil && !driver.IsNoMoreDocuments(err) {
+			return nil, databaseError("failed to read count", err)
+		}
+		r.metrics.DBOperationsTotal.WithLabelValues("query", "spatial", "success").Inc()
+		return &api.QueryResponse{Anchors: []api.Anchor{}, Count: count}, nil
+	}
+
 	var anchors []api.Anchor
 	for {
 		var anchor api.Anchor
@@ -214,15 +964,26 @@ func (r *Repository) Query(ctx context.Context, params *api.QueryParams) (*api.Q
 		if driver.IsNoMoreDocuments(err) {
 			break
 		} else if err != nil {
-			return nil, errors.DatabaseError(fmt.Sprintf("failed to read anchor: %v", err))
+			return nil, databaseError("failed to read anchor", err)
 		}
 		anchors = append(anchors, anchor)
 	}
 
+	// One extra row is fetched to detect whether another page exists
+	limit := r.queryLimit(params)
+	hasMore := len(anchors) > limit
+	if hasMore {
+		anchors = anchors[:limit]
+	}
+
 	response := &api.QueryResponse{
 		Anchors: anchors,
 		Count:   len(anchors),
-		HasMore: len(anchors) >= params.Limit,
+		HasMore: hasMore,
+	}
+	if hasMore {
+		last := anchors[len(anchors)-1]
+		response.Cursor = encodeQueryCursor(last.Timestamp, last.ID)
 	}
 
 	// Load meshes if requested
@@ -231,6 +992,11 @@ func (r *Repository) Query(ctx context.Context, params *api.QueryParams) (*api.Q
 		if err != nil {
 			return nil, err
 		}
+		if params.MaxTriangles > 0 {
+			if meshes, err = r.substituteLODs(ctx, meshes, params.MaxTriangles); err != nil {
+				return nil, err
+			}
+		}
 		response.Meshes = meshes
 	}
 
@@ -239,7 +1005,8 @@ func (r *Repository) Query(ctx context.Context, params *api.QueryParams) (*api.Q
 }
 
 // buildQuery constructs an AQL query based on parameters
-func (r *Repository) buildQuery(params *api.QueryParams) (string, map[string]interface{}) {
+func (r *Repository) buildQuery(params *api.QueryParams) (string, map[string]interface{}, error) {
+	lets := []string{}
 	conditions := []string{}
 	bindVars := map[string]interface{}{
 		"@collection": database.AnchorsCollection,
@@ -261,24 +1028,124 @@ func (r *Repository) buildQuery(params *api.QueryParams) (string, map[string]int
 		bindVars["until"] = params.Until
 	}
 
+	// History queries read pose samples, which name their anchor in anchor_id
+	idField := "doc.id"
+	if params.History {
+		bindVars["@collection"] = database.AnchorPosesCollection
+		idField = "doc.anchor_id"
+	}
+
 	// Spatial filter
-	if params.AnchorID != "" && params.Radius > 0 {
+	if params.AnchorID != "" && params.Radius <= 0 {
+		conditions = append(conditions, idField+" == @anchor_id")
+		bindVars["anchor_id"] = params.AnchorID
+	} else if params.AnchorID != "" {
 		// First get the reference anchor
-		conditions = append(conditions, `
-			LET refAnchor = FIRST(
-				FOR a IN @@collection
-				FILTER a.id == @anchor_id
-				RETURN a
+		lets = append(lets, `LET refAnchor = FIRST(
+	FOR a IN @@anchors
+	FILTER a.id == @anchor_id AND (@session_id == "" OR a.session_id == @session_id)
+	RETURN a
+)`)
+		bindVars["session_id"] = params.SessionID
+		bindVars["@anchors"] = database.AnchorsCollection
+		conditions = append(conditions, "refAnchor != null")
+
+		// Coarse 2D pre-filter on the floor plane before the 3D distance
+		// check. Anchors are found through the geo index of their scaled
+		// floor position, see database.MetersPerDegree, whose distance on the
+		// sphere never exceeds the floor distance in meters, so no anchor
+		// within the radius is missed. Pose samples carry no location.
+		if params.History {
+			conditions = append(conditions,
+				"ABS(doc.pose.x - refAnchor.pose.x) <= @radius",
+				"ABS(doc.pose.y - refAnchor.pose.y) <= @radius",
 			)
-			FILTER refAnchor != null
-			FILTER GEO_DISTANCE([refAnchor.pose.x, refAnchor.pose.y], [doc.pose.x, doc.pose.y]) <= @radius
-		`)
+		} else {
+			conditions = append(conditions, "GEO_DISTANCE(refAnchor.location, doc.location) <= @radius")
+		}
+		conditions = append(conditions,
+			"SQRT(POW(doc.pose.x - refAnchor.pose.x, 2) + POW(doc.pose.y - refAnchor.pose.y, 2) + POW(doc.pose.z - refAnchor.pose.z, 2)) <= @radius",
+		)
 		bindVars["anchor_id"] = params.AnchorID
-		bindVars["radius"] = params.Radius * 1000 // Convert to millimeters
+		bindVars["radius"] = params.Radius // Pose coordinates are in meters
+	}
+
+	// Polygon filter on the floor plane. Pose samples carry no indexed
+	// location, so theirs is computed per document.
+	if params.Polygon != "" {
+		ring, err := parsePolygon(params.Polygon)
+		if err != nil {
+			return "", nil, err
+		}
+		location := "doc.location"
+		if params.History {
+			location = "GEO_POINT(doc.pose.x / @meters_per_degree, doc.pose.y / @meters_per_degree)"
+			bindVars["meters_per_degree"] = database.MetersPerDegree
+		}
+		conditions = append(conditions, "GEO_CONTAINS(@polygon, "+location+")")
+		bindVars["polygon"] = polygonGeoJSON(ring)
+	}
+
+	// Metadata filters bind keys as well as values, so neither can alter the
+	// query. Pose samples carry no metadata.
+	if len(params.Metadata) > 0 && params.History {
+		return "", nil, errors.ValidationError("metadata filters cannot be combined with history")
+	}
+	for i, filter := range params.Metadata {
+		keyVar, valuesVar := fmt.Sprintf("meta_key_%d", i), fmt.Sprintf("meta_values_%d", i)
+		conditions = append(conditions, "doc.metadata[@"+keyVar+"] IN @"+valuesVar)
+		bindVars[keyVar] = filter.Key
+		bindVars[valuesVar] = metadataFilterValues(filter.Value)
+	}
+
+	// Mesh presence filter. An anchor whose geometry deduplicated against
+	// another anchor's mesh is listed in that mesh's referenced_by instead.
+	switch params.HasMesh {
+	case "", "any":
+	case "true", "false":
+		hasMesh := "(LENGTH(FOR m IN @@meshes OPTIONS { indexHint: \"idx_anchor_id\" }" +
+			" FILTER m.anchor_id == " + idField + " AND m.session_id == doc.session_id LIMIT 1 RETURN 1) > 0" +
+			" OR LENGTH(FOR m IN @@meshes OPTIONS { indexHint: \"idx_mesh_referenced_by\" }" +
+			" FILTER doc.session_id IN m.referenced_by[*].session_id" +
+			" AND { session_id: doc.session_id, anchor_id: " + idField + " } IN m.referenced_by LIMIT 1 RETURN 1) > 0)"
+		if params.HasMesh == "false" {
+			hasMesh = "NOT " + hasMesh
+		}
+		conditions = append(conditions, hasMesh)
+		bindVars["@meshes"] = database.MeshesCollection
+	default:
+		return "", nil, errors.ValidationError(fmt.Sprintf("has_mesh must be any, true or false, got %q", params.HasMesh))
+	}
+
+	// Bounding box filter, inclusive on every face
+	if hasCompleteBoundingBox(params) {
+		conditions = append(conditions,
+			"doc.pose.x >= @min_x AND doc.pose.x <= @max_x",
+			"doc.pose.y >= @min_y AND doc.pose.y <= @max_y",
+			"doc.pose.z >= @min_z AND doc.pose.z <= @max_z",
+		)
+		bindVars["min_x"], bindVars["max_x"] = *params.MinX, *params.MaxX
+		bindVars["min_y"], bindVars["max_y"] = *params.MinY, *params.MaxY
+		bindVars["min_z"], bindVars["max_z"] = *params.MinZ, *params.MaxZ
+	}
+
+	// Pagination cursor resumes after the last anchor of the previous page
+	if params.Cursor != "" {
+		cursorTS, cursorID, err := decodeQueryCursor(params.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, "(doc.timestamp < @cursor_ts OR (doc.timestamp == @cursor_ts AND "+idField+" > @cursor_id))")
+		bindVars["cursor_ts"] = cursorTS
+		bindVars["cursor_id"] = cursorID
 	}
 
 	// Build query
-	query := "FOR doc IN @@collection"
+	query := ""
+	for _, let := range lets {
+		query += let + "\n"
+	}
+	query += "FOR doc IN @@collection"
 	if len(conditions) > 0 {
 		query += "\nFILTER " + conditions[0]
 		for _, cond := range conditions[1:] {
@@ -286,41 +1153,106 @@ func (r *Repository) buildQuery(params *api.QueryParams) (string, map[string]int
 		}
 	}
 
-	// Sort and limit
-	query += "\nSORT doc.timestamp DESC"
-	if params.Limit > 0 {
-		query += fmt.Sprintf("\nLIMIT %d", params.Limit)
-		bindVars["limit"] = params.Limit
+	// Counts cover every match, so they are neither sorted nor paged
+	if params.CountOnly {
+		query += "\nCOLLECT WITH COUNT INTO count"
+		query += "\nRETURN count"
+		return query, bindVars, nil
+	}
+
+	// Sort and limit, with a stable tiebreak so cursors are deterministic
+	query += "\nSORT doc.timestamp DESC, " + idField + " ASC"
+	query += "\nLIMIT @limit"
+	bindVars["limit"] = r.queryLimit(params) + 1
+
+	if params.History {
+		// Samples are returned as anchors so responses keep their shape
+		query += "\nRETURN { id: doc.anchor_id, session_id: doc.session_id, pose: doc.pose, timestamp: doc.timestamp }"
 	} else {
-		query += "\nLIMIT 100" // Default limit
+		query += "\nRETURN doc"
 	}
 
-	query += "\nRETURN doc"
+	return query, bindVars, nil
+}
+
+// hasCompleteBoundingBox reports whether all six bounding box bounds are set
+func hasCompleteBoundingBox(params *api.QueryParams) bool {
+	return params.MinX != nil && params.MinY != nil && params.MinZ != nil &&
+		params.MaxX != nil && params.MaxY != nil && params.MaxZ != nil
+}
 
-	return query, bindVars
+// queryLimit returns the page size for a query: its limit clamped to the
+// configured maximum, or the default if it sets none
+func (r *Repository) queryLimit(params *api.QueryParams) int {
+	limit := params.Limit
+	if limit <= 0 {
+		limit = r.defaultLimit
+	}
+	if r.maxLimit > 0 && limit > r.maxLimit {
+		limit = r.maxLimit
+	}
+	return limit
 }
 
-// loadMeshesForAnchors loads meshes associated with anchors
+// encodeQueryCursor builds an opaque pagination token from the last anchor of a page
+func encodeQueryCursor(timestamp int64, anchorID string) string {
+	raw := strconv.FormatInt(timestamp, 10) + ":" + anchorID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeQueryCursor parses a pagination token produced by encodeQueryCursor
+func decodeQueryCursor(cursor string) (int64, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", errors.ValidationError("invalid cursor")
+	}
+
+	ts, anchorID, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, "", errors.ValidationError("invalid cursor")
+	}
+
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return 0, "", errors.ValidationError("invalid cursor")
+	}
+
+	return timestamp, anchorID, nil
+}
+
+// loadMeshesForAnchors loads meshes associated with anchors, excluding
+// generated levels of detail
 func (r *Repository) loadMeshesForAnchors(ctx context.Context, anchors []api.Anchor) ([]api.Mesh, error) {
-	anchorIDs := make([]string, len(anchors))
-	for i, anchor := range anchors {
-		anchorIDs[i] = anchor.ID
+	// Anchor IDs may repeat across sessions, and history pages repeat anchors
+	type anchorKey struct {
+		SessionID string `json:"session_id"`
+		ID        string `json:"id"`
+	}
+	seen := make(map[anchorKey]bool, len(anchors))
+	keys := make([]anchorKey, 0, len(anchors))
+	for _, anchor := range anchors {
+		key := anchorKey{SessionID: anchor.SessionID, ID: anchor.ID}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
 	}
 
 	query := `
+		FOR key IN @anchors
 		FOR doc IN @@collection
-		FILTER doc.anchor_id IN @anchor_ids
+		FILTER doc.session_id == key.session_id AND doc.anchor_id == key.id AND doc.lod_of == null
 		RETURN doc
 	`
 
 	bindVars := map[string]interface{}{
 		"@collection": database.MeshesCollection,
-		"anchor_ids":  anchorIDs,
+		"anchors":     keys,
 	}
 
-	cursor, err := r.db.Database().Query(ctx, query, bindVars)
+	cursor, err := r.runQuery(ctx, query, bindVars)
 	if err != nil {
-		return nil, errors.DatabaseError(fmt.Sprintf("failed to query meshes: %v", err))
+		return nil, databaseError("failed to query meshes", err)
 	}
 	defer cursor.Close()
 
@@ -331,18 +1263,28 @@ func (r *Repository) loadMeshesForAnchors(ctx context.Context, anchors []api.Anc
 		if driver.IsNoMoreDocuments(err) {
 			break
 		} else if err != nil {
-			return nil, errors.DatabaseError(fmt.Sprintf("failed to read mesh: %v", err))
+			return nil, databaseError("failed to read mesh", err)
 		}
 		meshes = append(meshes, mesh)
 	}
 
-	// Resolve delta meshes
+	meshes, _ = r.fetchMeshesBuffers(ctx, meshes)
+	resolvedMeshes, _ := r.resolveDeltaMeshes(ctx, meshes)
+	return resolvedMeshes, nil
+}
+
+// resolveDeltaMeshes resolves the delta meshes among meshes, keeping their
+// order. Deltas that cannot be resolved are logged and left out, and their
+// IDs returned.
+func (r *Repository) resolveDeltaMeshes(ctx context.Context, meshes []api.Mesh) ([]api.Mesh, []string) {
 	resolvedMeshes := make([]api.Mesh, 0, len(meshes))
+	var failed []string
 	for _, mesh := range meshes {
 		if mesh.IsDelta {
 			resolved, err := r.resolveDeltaMesh(ctx, &mesh)
 			if err != nil {
-				r.logger.Warnf("Failed to resolve delta mesh %s: %v", mesh.ID, err)
+				r.log(ctx).Warnf("Failed to resolve delta mesh %s: %v", mesh.ID, err)
+				failed = append(failed, mesh.ID)
 				continue
 			}
 			resolvedMeshes = append(resolvedMeshes, *resolved)
@@ -351,49 +1293,142 @@ func (r *Repository) loadMeshesForAnchors(ctx context.Context, anchors []api.Anc
 		}
 	}
 
-	return resolvedMeshes, nil
+	return resolvedMeshes, failed
 }
 
 // resolveDeltaMesh reconstructs a full mesh from delta
 func (r *Repository) resolveDeltaMesh(ctx context.Context, deltaMesh *api.Mesh) (*api.Mesh, error) {
+	return r.resolveDeltaChain(ctx, deltaMesh, nil)
+}
+
+// resolveDeltaChain resolves a delta reached from the deltas in path, which
+// must not include its base nor be too long
+func (r *Repository) resolveDeltaChain(ctx context.Context, deltaMesh *api.Mesh, path []string) (*api.Mesh, error) {
 	if !deltaMesh.IsDelta || deltaMesh.BaseMeshID == "" {
 		return deltaMesh, nil
 	}
 
-	// Load base mesh
-	col, err := r.db.Database().Collection(ctx, database.MeshesCollection)
-	if err != nil {
-		return nil, errors.DatabaseError(fmt.Sprintf("failed to get collection: %v", err))
+	path = append(path, deltaMesh.ID)
+	if err := checkDeltaChain(path, deltaMesh.BaseMeshID, r.maxDeltaDepth); err != nil {
+		r.log(ctx).Warnf("Rejected delta chain %s -> %s: %v", strings.Join(path, " -> "), deltaMesh.BaseMeshID, err)
+		return nil, err
 	}
 
-	var baseMesh api.Mesh
-	_, err = col.ReadDocument(ctx, deltaMesh.BaseMeshID, &baseMesh)
+	// Load base mesh
+	base, err := r.getMeshByID(ctx, deltaMesh.BaseMeshID)
 	if err != nil {
-		return nil, errors.DatabaseError(fmt.Sprintf("failed to load base mesh: %v", err))
+		return nil, err
+	}
+	if base == nil {
+		return nil, errors.NotFound(fmt.Sprintf("base mesh %s not found", deltaMesh.BaseMeshID))
 	}
+	baseMesh := *base
 
 	// If base mesh is also a delta, resolve it first
 	if baseMesh.IsDelta {
-		resolvedBase, err := r.resolveDeltaMesh(ctx, &baseMesh)
+		resolvedBase, err := r.resolveDeltaChain(ctx, &baseMesh, path)
 		if err != nil {
 			return nil, err
 		}
 		baseMesh = *resolvedBase
 	}
 
+	// Deltas patch the decompressed geometry
+	decodedBase, err := decodeMeshBuffers(&baseMesh)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delta payload is stored in both delta_data and vertices
+	deltaData := deltaMesh.DeltaData
+	if len(deltaData) == 0 {
+		deltaData = deltaMesh.Vertices
+	}
+	if deltaData, err = decompressBuffer(deltaData); err != nil {
+		return nil, err
+	}
+
+	delta, err := decodeMeshDelta(deltaData)
+	if err != nil {
+		return nil, err
+	}
+
 	// Apply delta to base mesh
-	// In a real implementation, this would decode the delta data and apply it
-	// For now, we'll just return the base mesh with updated ID
-	result := baseMesh
+	result, err := applyMeshDelta(decodedBase, delta)
+	if err != nil {
+		return nil, err
+	}
 	result.ID = deltaMesh.ID
+	result.AnchorID = deltaMesh.AnchorID
 	result.Timestamp = deltaMesh.Timestamp
-	
-	return &result, nil
+	result.CompressionLevel = deltaMesh.CompressionLevel
+	result.TriangleCount = len(result.Faces) / gltfIndexStride / 3
+	result.LODOf = deltaMesh.LODOf
+	result.Hash = r.computeMeshHash(result)
+
+	// Return the resolved geometry compressed like stored full meshes
+	if err := encodeMeshBuffers(result, r.storageCodec, result.CompressionLevel); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// getMeshByID loads a stored mesh by its id attribute, returning nil if absent
+func (r *Repository) getMeshByID(ctx context.Context, meshID string) (*api.Mesh, error) {
+	mesh, err := r.getStoredMesh(ctx, meshID)
+	if err != nil || mesh == nil {
+		return nil, err
+	}
+
+	if err := r.fetchMeshBuffers(ctx, mesh); err != nil {
+		return nil, err
+	}
+	return mesh, nil
+}
+
+// getStoredMesh loads a mesh document as stored, leaving buffers offloaded to
+// object storage unfetched, returning nil if absent
+func (r *Repository) getStoredMesh(ctx context.Context, meshID string) (*api.Mesh, error) {
+	query := `
+		FOR doc IN @@collection
+		FILTER doc.id == @id
+		LIMIT 1
+		RETURN doc
+	`
+
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+		"id":          meshID,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to query mesh", err)
+	}
+	defer cursor.Close()
+
+	var mesh api.Mesh
+	_, err = cursor.ReadDocument(ctx, &mesh)
+	if driver.IsNoMoreDocuments(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, databaseError("failed to read mesh", err)
+	}
+	return &mesh, nil
 }
 
 // computeMeshHash calculates a hash for mesh deduplication
 func (r *Repository) computeMeshHash(mesh *api.Mesh) string {
 	h := sha256.New()
+	// Opaque geometry is hashed encoded, so keep codecs from colliding
+	if isOpaqueCodec(mesh.CompressionCodec) {
+		h.Write([]byte(mesh.CompressionCodec))
+	}
+	// The same bytes at another stride are different geometry
+	if mesh.VertexStride != 0 && mesh.VertexStride != gltfVec3Stride {
+		fmt.Fprintf(h, "stride:%d;", mesh.VertexStride)
+	}
 	h.Write(mesh.Vertices)
 	h.Write(mesh.Faces)
 	if len(mesh.Normals) > 0 {
@@ -402,23 +1437,49 @@ func (r *Repository) computeMeshHash(mesh *api.Mesh) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-// ProcessWebSocketMessage handles incoming WebSocket messages
-func (r *Repository) ProcessWebSocketMessage(ctx context.Context, msg *api.WSMessage) error {
+// storedMeshID returns the ID an ingested mesh is stored under: that of the
+// first mesh with the same geometry, as identical geometry is stored once
+func (r *Repository) storedMeshID(mesh *api.Mesh) string {
+	if mesh.IsDelta {
+		return mesh.ID
+	}
+	decoded := mesh
+	if !isOpaqueCodec(mesh.CompressionCodec) {
+		var err error
+		if decoded, err = decodeMeshBuffers(mesh); err != nil {
+			return mesh.ID
+		}
+		normalizeLayout(decoded)
+	}
+	if storedID, ok := r.meshHashCache.peek(r.computeMeshHash(decoded)); ok {
+		return storedID
+	}
+	return mesh.ID
+}
+
+// ProcessWebSocketMessage stores an anchor or mesh update and returns the
+// message to broadcast to the session's other clients, or nil when the update
+// changed nothing they would see
+func (r *Repository) ProcessWebSocketMessage(ctx context.Context, msg *api.WSMessage) (*api.WSMessage, error) {
 	switch msg.Type {
 	case api.WSTypeAnchorUpdate:
 		return r.processAnchorUpdate(ctx, msg)
 	case api.WSTypeMeshUpdate:
-		return r.processMeshUpdate(ctx, msg)
+		if err := r.processMeshUpdate(ctx, msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
 	default:
-		return nil
+		return nil, nil
 	}
 }
 
-// processAnchorUpdate handles anchor update messages
-func (r *Repository) processAnchorUpdate(ctx context.Context, msg *api.WSMessage) error {
+// processAnchorUpdate handles anchor update messages, returning an
+// anchor_diff of the fields that changed or nil if none did
+func (r *Repository) processAnchorUpdate(ctx context.Context, msg *api.WSMessage) (*api.WSMessage, error) {
 	var update api.AnchorUpdate
 	if err := json.Unmarshal(msg.Data, &update); err != nil {
-		return errors.ValidationError(fmt.Sprintf("invalid anchor update: %v", err))
+		return nil, errors.ValidationError(fmt.Sprintf("invalid anchor update: %v", err))
 	}
 
 	anchor := api.Anchor{
@@ -434,7 +1495,47 @@ func (r *Repository) processAnchorUpdate(ctx context.Context, msg *api.WSMessage
 		Metadata:  update.Metadata,
 	}
 
-	return r.ingestAnchor(ctx, &anchor)
+	if err := validateRotation(anchor.ID, &anchor.Pose, r.normalizeRotations); err != nil {
+		return nil, err
+	}
+	if err := validateMetadata(anchor.ID, anchor.Metadata, r.metadataSchema); err != nil {
+		return nil, err
+	}
+
+	// An update repeating the stored pose is dropped like one changing nothing
+	events := []api.SpatialEvent{{SessionID: msg.SessionID, Anchors: []api.Anchor{anchor}}}
+	r.dedupeAnchors(ctx, events)
+	if len(events[0].Anchors) == 0 {
+		return nil, nil
+	}
+
+	previous, err := r.ingestAnchor(ctx, &anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.buildTopology(ctx, anchor.SessionID, anchor.ID); err != nil {
+		return nil, err
+	}
+	r.acceptAnchorPoses(ctx, events)
+	r.observeAnchorSize(&anchor)
+	r.audit(ctx, audit.Record{Action: audit.ActionAnchorUpdate, SessionID: anchor.SessionID, AnchorIDs: []string{anchor.ID}})
+
+	diff := diffAnchor(previous, &anchor, r.diffTolerance)
+	if diff == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(diff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal anchor diff: %w", err)
+	}
+	return &api.WSMessage{
+		Type:      api.WSTypeAnchorDiff,
+		SessionID: msg.SessionID,
+		Data:      data,
+		Timestamp: msg.Timestamp,
+		TraceID:   msg.TraceID,
+	}, nil
 }
 
 // processMeshUpdate handles mesh update messages
@@ -443,6 +1544,20 @@ func (r *Repository) processMeshUpdate(ctx context.Context, msg *api.WSMessage)
 	if err := json.Unmarshal(msg.Data, &update); err != nil {
 		return errors.ValidationError(fmt.Sprintf("invalid mesh update: %v", err))
 	}
+	level, err := r.checkCompressionLevel(update.ID, update.CompressionLevel)
+	if err != nil {
+		return err
+	}
+
+	// Reject oversized geometry before allocating buffers for it
+	if r.maxMeshSize > 0 {
+		size := int64(base64.StdEncoding.DecodedLen(len(update.Vertices)) +
+			base64.StdEncoding.DecodedLen(len(update.Faces)) +
+			base64.StdEncoding.DecodedLen(len(update.Normals)))
+		if size > r.maxMeshSize {
+			return errors.ValidationError(fmt.Sprintf("mesh %s is too large: %d bytes exceeds the %d byte limit", update.ID, size, r.maxMeshSize))
+		}
+	}
 
 	// Decode base64 data
 	vertices, err := base64.StdEncoding.DecodeString(update.Vertices)
@@ -466,15 +1581,27 @@ func (r *Repository) processMeshUpdate(ctx context.Context, msg *api.WSMessage)
 		}
 	}
 
+	// Reject transfers truncated or corrupted in flight
+	if update.Checksum != "" {
+		if err := verifyMeshChecksum(update.Checksum, vertices, faces, normals); err != nil {
+			r.metrics.MeshChecksumFailures.WithLabelValues(r.metrics.SessionSeries(msg.SessionID)).Inc()
+			return err
+		}
+	}
+
 	mesh := api.Mesh{
 		ID:               update.ID,
 		AnchorID:         update.AnchorID,
+		SessionID:        msg.SessionID,
 		Vertices:         vertices,
 		Faces:            faces,
 		Normals:          normals,
 		IsDelta:          update.IsDelta,
 		BaseMeshID:       update.BaseMeshID,
-		CompressionLevel: update.CompressionLevel,
+		CompressionLevel: level,
+		CompressionCodec: update.CompressionCodec,
+		VertexStride:     update.VertexStride,
+		IndexFormat:      update.IndexFormat,
 		Timestamp:        msg.Timestamp,
 	}
 
@@ -483,24 +1610,60 @@ func (r *Repository) processMeshUpdate(ctx context.Context, msg *api.WSMessage)
 		mesh.DeltaData = vertices
 	}
 
+	// Sized as received, as processing compresses the buffers in place
+	size := meshPayloadSize(&mesh)
+
 	// Process and ingest
 	processedMesh, saved, err := r.processMeshForStorage(ctx, &mesh)
 	if err != nil {
 		return err
 	}
 
-	if err := r.ingestMesh(ctx, processedMesh); err != nil {
-		return err
+	// Duplicates reference the already stored mesh
+	if saved == 0 {
+		if err := r.ingestMesh(ctx, processedMesh); err != nil {
+			r.releaseMeshHash(processedMesh.Hash, processedMesh.ID)
+			return err
+		}
+		if processedMesh.RawSize > 0 {
+			r.metrics.MeshCompressionLevel.WithLabelValues(r.metrics.SessionSeries(msg.SessionID)).Observe(float64(processedMesh.CompressionLevel))
+		}
 	}
 
 	if saved > 0 {
-		r.metrics.MeshDedupSavedBytes.WithLabelValues(msg.SessionID).Add(float64(saved))
+		r.metrics.MeshDedupSavedBytes.WithLabelValues(r.metrics.SessionSeries(msg.SessionID)).Add(float64(saved))
 	}
 
+	meshType := "full"
+	if mesh.IsDelta {
+		meshType = "delta"
+	}
+	r.metrics.MeshBytes.WithLabelValues(meshType).Observe(float64(size))
+
+	r.audit(ctx, audit.Record{Action: audit.ActionMeshUpdate, SessionID: msg.SessionID, AnchorIDs: []string{update.AnchorID}, MeshIDs: []string{update.ID}})
 	return nil
 }
 
-// GetMetrics returns current metrics
+// verifyMeshChecksum compares a hex CRC32 (IEEE) against the mesh buffers
+func verifyMeshChecksum(checksum string, buffers ...[]byte) error {
+	expected, err := strconv.ParseUint(checksum, 16, 32)
+	if err != nil {
+		return errors.ValidationError(fmt.Sprintf("invalid checksum %q", checksum))
+	}
+
+	crc := crc32.NewIEEE()
+	for _, buf := range buffers {
+		crc.Write(buf)
+	}
+
+	if actual := crc.Sum32(); actual != uint32(expected) {
+		return errors.ValidationError(fmt.Sprintf("mesh checksum mismatch: expected %08x, got %08x", expected, actual))
+	}
+	return nil
+}
+
+// GetMetrics returns aggregate storage metrics and refreshes the matching
+// Prometheus gauges
 func (r *Repository) GetMetrics(ctx context.Context) (*api.MetricsInfo, error) {
 	// Count anchors
 	anchorCount, err := r.countDocuments(ctx, database.AnchorsCollection)
@@ -514,18 +1677,95 @@ func (r *Repository) GetMetrics(ctx context.Context) (*api.MetricsInfo, error) {
 		return nil, err
 	}
 
-	// Estimate storage size (simplified)
-	storageSize := anchorCount*500 + meshCount*50000 // Rough estimates
+	anchorBytes, err := r.collectionDocumentsSize(ctx, database.AnchorsCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	meshStats, err := r.meshStorageStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	compressionRatio := 1.0
+	if meshStats.RawBytes > 0 {
+		compressionRatio = float64(meshStats.CompressedBytes) / float64(meshStats.RawBytes)
+	}
+
+	r.metrics.StorageSizeBytes.WithLabelValues("anchors").Set(float64(anchorBytes))
+	r.metrics.StorageSizeBytes.WithLabelValues("meshes").Set(float64(meshStats.StoredBytes))
+	r.metrics.CompressionRatio.WithLabelValues("all").Set(compressionRatio)
 
 	return &api.MetricsInfo{
 		ActiveConnections: 0, // Will be set by WebSocket hub
 		TotalAnchors:      anchorCount,
 		TotalMeshes:       meshCount,
-		StorageSize:       storageSize,
-		CompressionRatio:  0.6, // Placeholder
+		StorageSize:       anchorBytes + meshStats.StoredBytes,
+		CompressionRatio:  compressionRatio,
 	}, nil
 }
 
+// meshStorage summarizes stored mesh geometry
+type meshStorage struct {
+	StoredBytes     int64 `json:"stored_bytes"`     // All stored geometry, including deltas
+	CompressedBytes int64 `json:"compressed_bytes"` // Stored geometry of meshes with a known raw size
+	RawBytes        int64 `json:"raw_bytes"`        // Decompressed size of the same meshes
+}
+
+// meshStorageStats sums geometry buffer sizes across all meshes. Buffers are
+// stored base64 encoded, so decoded sizes are derived from string lengths.
+func (r *Repository) meshStorageStats(ctx context.Context) (*meshStorage, error) {
+	query := `
+		FOR m IN @@collection
+		LET stored = FLOOR((LENGTH(m.vertices) + LENGTH(m.faces) + LENGTH(m.normals)) * 3 / 4)
+		COLLECT AGGREGATE
+			stored_bytes = SUM(stored),
+			compressed_bytes = SUM(m.raw_size > 0 ? stored : 0),
+			raw_bytes = SUM(m.raw_size > 0 ? m.raw_size : 0)
+		RETURN { stored_bytes, compressed_bytes, raw_bytes }
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.MeshesCollection,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to sum mesh storage", err)
+	}
+	defer cursor.Close()
+
+	var stats meshStorage
+	if _, err := cursor.ReadDocument(ctx, &stats); err != nil && !driver.IsNoMoreDocuments(err) {
+		return nil, databaseError("failed to read mesh storage", err)
+	}
+
+	return &stats, nil
+}
+
+// collectionDocumentsSize returns the size of a collection's documents as
+// reported by ArangoDB's collection figures
+func (r *Repository) collectionDocumentsSize(ctx context.Context, collectionName string) (int64, error) {
+	col, err := r.db.Database().Collection(ctx, collectionName)
+	if err != nil {
+		return 0, databaseError("failed to get collection", err)
+	}
+
+	stats, err := col.Statistics(ctx)
+	if err != nil {
+		return 0, databaseError("failed to get collection figures", err)
+	}
+
+	if stats.Figures.DocumentsSize == nil {
+		return 0, nil
+	}
+	return *stats.Figures.DocumentsSize, nil
+}
+
+// meshBufferSize returns the combined length of a mesh's geometry buffers
+func meshBufferSize(mesh *api.Mesh) int64 {
+	return int64(len(mesh.Vertices) + len(mesh.Faces) + len(mesh.Normals))
+}
+
 // countDocuments counts documents in a collection
 func (r *Repository) countDocuments(ctx context.Context, collectionName string) (int64, error) {
 	query := "RETURN COUNT(FOR doc IN @@collection RETURN 1)"
@@ -533,16 +1773,16 @@ func (r *Repository) countDocuments(ctx context.Context, collectionName string)
 		"@collection": collectionName,
 	}
 
-	cursor, err := r.db.Database().Query(ctx, query, bindVars)
+	cursor, err := r.runQuery(ctx, query, bindVars)
 	if err != nil {
-		return 0, errors.DatabaseError(fmt.Sprintf("failed to count documents: %v", err))
+		return 0, databaseError("failed to count documents", err)
 	}
 	defer cursor.Close()
 
 	var count int64
 	_, err = cursor.ReadDocument(ctx, &count)
 	if err != nil {
-		return 0, errors.DatabaseError(fmt.Sprintf("failed to read count: %v", err))
+		return 0, databaseError("failed to read count", err)
 	}
 
 	return count, nil
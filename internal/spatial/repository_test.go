@@ -2,10 +2,23 @@ package spatial
 
 import (
 	"context"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/arangodb/go-driver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/internal/metrics"
 	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+	"github.com/tabular/stag-v2/pkg/logger"
 )
 
 func TestMeshDeduplication(t *testing.T) {
@@ -13,7 +26,7 @@ func TestMeshDeduplication(t *testing.T) {
 	// For now, we'll test the hash computation
 
 	repo := &Repository{
-		meshHashCache: make(map[string]string),
+		meshHashCache: newHashCache(0),
 	}
 
 	// Create identical meshes
@@ -59,7 +72,7 @@ func TestMeshDeduplication(t *testing.T) {
 
 func TestDeltaMeshValidation(t *testing.T) {
 	repo := &Repository{
-		meshHashCache: make(map[string]string),
+		meshHashCache: newHashCache(0),
 	}
 
 	// Delta mesh without base should fail
@@ -88,4 +101,682 @@ func TestDeltaMeshValidation(t *testing.T) {
 	if len(processed.Vertices) == 0 {
 		t.Error("Expected delta data in vertices field")
 	}
-}
\ No newline at end of file
+}
+func TestMeshHashCacheExpiry(t *testing.T) {
+	cache := newHashCache(time.Minute)
+
+	if _, exists := cache.putIfAbsent("hash1", "mesh1"); exists {
+		t.Fatal("Expected new hash to be absent")
+	}
+
+	// Live entry is returned instead of being overwritten
+	if meshID, exists := cache.putIfAbsent("hash1", "mesh2"); !exists || meshID != "mesh1" {
+		t.Errorf("Expected existing mesh1, got %s (exists=%v)", meshID, exists)
+	}
+
+	// Entries older than the expiry are evicted
+	if evicted := cache.evictExpired(time.Now().Add(30 * time.Second)); evicted != 0 {
+		t.Errorf("Expected no evictions before expiry, got %d", evicted)
+	}
+	if evicted := cache.evictExpired(time.Now().Add(2 * time.Minute)); evicted != 1 {
+		t.Errorf("Expected 1 eviction after expiry, got %d", evicted)
+	}
+	if _, exists := cache.get("hash1"); exists {
+		t.Error("Expected hash1 to be evicted")
+	}
+	if cache.size() != 0 {
+		t.Errorf("Expected empty cache, got %d entries", cache.size())
+	}
+}
+
+func TestMeshHashCacheLookupsAndReplace(t *testing.T) {
+	cache := newHashCache(0)
+	cache.putIfAbsent("hash1", "mesh1")
+
+	cache.get("hash1")
+	cache.get("hash2")
+	cache.peek("hash1")
+	if hits, misses := cache.lookups(); hits != 1 || misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %d and %d", hits, misses)
+	}
+
+	if previous := cache.replace(map[string]string{"hash2": "mesh2", "hash3": "mesh3"}); previous != 1 {
+		t.Errorf("Expected 1 previous entry, got %d", previous)
+	}
+	if _, ok := cache.peek("hash1"); ok {
+		t.Error("Expected hash1 to be dropped")
+	}
+	if meshID, ok := cache.peek("hash3"); !ok || meshID != "mesh3" || cache.size() != 2 {
+		t.Errorf("Expected the replacement entries, got %q with %d entries", meshID, cache.size())
+	}
+}
+
+func TestQueryCursor(t *testing.T) {
+	cursor := encodeQueryCursor(1700000000000, "anchor:with:colons")
+
+	ts, anchorID, err := decodeQueryCursor(cursor)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ts != 1700000000000 || anchorID != "anchor:with:colons" {
+		t.Errorf("Unexpected cursor contents: %d %s", ts, anchorID)
+	}
+
+	if _, _, err := decodeQueryCursor("not a cursor"); err == nil {
+		t.Error("Expected error for invalid cursor")
+	}
+
+	// Cursor adds the keyset filter and over-fetches by one row
+	repo := &Repository{}
+	query, bindVars, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", Limit: 10, Cursor: cursor})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(query, "doc.timestamp < @cursor_ts") {
+		t.Errorf("Expected cursor filter in query: %s", query)
+	}
+	if bindVars["limit"] != 11 {
+		t.Errorf("Expected limit 11, got %v", bindVars["limit"])
+	}
+}
+
+func TestQueryLimit(t *testing.T) {
+	repo := &Repository{defaultLimit: 100, maxLimit: 1000}
+
+	for requested, want := range map[int]int{0: 100, -5: 100, 50: 50, 1000: 1000, 5000: 1000} {
+		if got := repo.queryLimit(&api.QueryParams{Limit: requested}); got != want {
+			t.Errorf("Limit %d: expected %d, got %d", requested, want, got)
+		}
+	}
+
+	// A limit over the maximum is clamped rather than rejected
+	_, bindVars, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", Limit: 5000})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if bindVars["limit"] != 1001 {
+		t.Errorf("Expected the clamped limit plus one, got %v", bindVars["limit"])
+	}
+}
+
+func TestBuildQueryCountOnly(t *testing.T) {
+	repo := &Repository{defaultLimit: 100}
+	minX, minY, minZ, maxX, maxY, maxZ := -1.0, 0.0, -2.5, 1.0, 3.0, 2.5
+
+	query, bindVars, err := repo.buildQuery(&api.QueryParams{
+		SessionID: "s1",
+		Since:     1700000000000,
+		MinX:      &minX, MinY: &minY, MinZ: &minZ,
+		MaxX: &maxX, MaxY: &maxY, MaxZ: &maxZ,
+		CountOnly: true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !strings.HasSuffix(query, "COLLECT WITH COUNT INTO count\nRETURN count") {
+		t.Errorf("Expected a counting query: %s", query)
+	}
+	if strings.Contains(query, "SORT") || strings.Contains(query, "LIMIT") {
+		t.Errorf("Expected the count to be neither sorted nor paged: %s", query)
+	}
+	if _, ok := bindVars["limit"]; ok {
+		t.Errorf("Expected no limit bind var, got %v", bindVars)
+	}
+
+	// Filters apply as they do to a full query
+	for _, clause := range []string{"doc.session_id == @session_id", "doc.timestamp >= @since", "doc.pose.x >= @min_x"} {
+		if !strings.Contains(query, clause) {
+			t.Errorf("Expected filter %q in counting query: %s", clause, query)
+		}
+	}
+}
+
+func TestBuildQueryMetadata(t *testing.T) {
+	repo := &Repository{}
+
+	// Keys are bound, never written into the query
+	key := `room"] OR true OR doc.metadata["x`
+	query, bindVars, err := repo.buildQuery(&api.QueryParams{
+		SessionID: "s1",
+		Metadata:  []api.MetadataFilter{{Key: key, Value: "kitchen"}, {Key: "floor", Value: "2"}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(query, "kitchen") || strings.Contains(query, "OR true") {
+		t.Errorf("Expected keys and values to be bound: %s", query)
+	}
+	for _, clause := range []string{"doc.metadata[@meta_key_0] IN @meta_values_0", "doc.metadata[@meta_key_1] IN @meta_values_1"} {
+		if !strings.Contains(query, clause) {
+			t.Errorf("Expected %q in query: %s", clause, query)
+		}
+	}
+	if bindVars["meta_key_0"] != key {
+		t.Errorf("Expected the key bound as given, got %v", bindVars["meta_key_0"])
+	}
+	if values := bindVars["meta_values_1"].([]interface{}); len(values) != 2 || values[0] != "2" || values[1] != 2.0 {
+		t.Errorf("Expected a numeric value to match its string and number, got %v", values)
+	}
+
+	// Pose samples carry no metadata
+	if _, _, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", History: true, Metadata: []api.MetadataFilter{{Key: "room", Value: "a"}}}); err == nil {
+		t.Error("Expected metadata filters on history to be rejected")
+	}
+}
+
+func TestMetadataFilterValues(t *testing.T) {
+	tests := map[string][]interface{}{
+		"kitchen": {"kitchen"},
+		"2.5":     {"2.5", 2.5},
+		"true":    {"true", true},
+		"TRUE":    {"TRUE"},
+		"NaN":     {"NaN"},
+	}
+	for value, want := range tests {
+		got := metadataFilterValues(value)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%q: expected %v, got %v", value, want, got)
+		}
+	}
+}
+
+func TestBuildQueryBoundingBox(t *testing.T) {
+	repo := &Repository{}
+	minX, minY, minZ, maxX, maxY, maxZ := -1.0, 0.0, -2.5, 1.0, 3.0, 2.5
+
+	query, bindVars, err := repo.buildQuery(&api.QueryParams{
+		SessionID: "s1",
+		MinX:      &minX, MinY: &minY, MinZ: &minZ,
+		MaxX: &maxX, MaxY: &maxY, MaxZ: &maxZ,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, axis := range []string{"x", "y", "z"} {
+		clause := "doc.pose." + axis + " >= @min_" + axis + " AND doc.pose." + axis + " <= @max_" + axis
+		if !strings.Contains(query, clause) {
+			t.Errorf("Expected inclusive %s filter in query: %s", axis, query)
+		}
+	}
+	if bindVars["min_z"] != -2.5 || bindVars["max_y"] != 3.0 {
+		t.Errorf("Unexpected bounding box bind vars: %v", bindVars)
+	}
+
+	// An incomplete box adds no spatial filter
+	query, _, err = repo.buildQuery(&api.QueryParams{SessionID: "s1", MinX: &minX})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(query, "@min_x") {
+		t.Errorf("Expected no bounding box filter for incomplete box: %s", query)
+	}
+}
+
+// poseComparison matches a comparison of a pose coordinate to a bind var
+var poseComparison = regexp.MustCompile(`doc\.pose\.([xyz]) (>=|<=|>|<) @(\w+)`)
+
+// matchesPoseFilters evaluates the pose comparisons of query, all of which
+// must hold, for a document at pose
+func matchesPoseFilters(t *testing.T, query string, bindVars map[string]interface{}, pose api.Pose) bool {
+	t.Helper()
+	coords := map[string]float64{"x": pose.X, "y": pose.Y, "z": pose.Z}
+	comparisons := poseComparison.FindAllStringSubmatch(query, -1)
+	if len(comparisons) == 0 {
+		t.Fatalf("Expected pose comparisons in query: %s", query)
+	}
+	for _, m := range comparisons {
+		value, bound := coords[m[1]], bindVars[m[3]].(float64)
+		var holds bool
+		switch m[2] {
+		case ">=":
+			holds = value >= bound
+		case "<=":
+			holds = value <= bound
+		case ">":
+			holds = value > bound
+		case "<":
+			holds = value < bound
+		}
+		if !holds {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBuildQueryBoundingBoxBoundary(t *testing.T) {
+	repo := &Repository{}
+	minX, minY, minZ, maxX, maxY, maxZ := -1.0, 0.0, -2.5, 1.0, 3.0, 2.5
+	query, bindVars, err := repo.buildQuery(&api.QueryParams{
+		MinX: &minX, MinY: &minY, MinZ: &minZ,
+		MaxX: &maxX, MaxY: &maxY, MaxZ: &maxZ,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	const outside = 1e-9
+	tests := []struct {
+		name string
+		pose api.Pose
+		want bool
+	}{
+		{"Inside", api.Pose{X: 0, Y: 1, Z: 0}, true},
+		{"MinCorner", api.Pose{X: minX, Y: minY, Z: minZ}, true},
+		{"MaxCorner", api.Pose{X: maxX, Y: maxY, Z: maxZ}, true},
+		{"MinXFace", api.Pose{X: minX, Y: 1, Z: 0}, true},
+		{"MaxYFace", api.Pose{X: 0, Y: maxY, Z: 0}, true},
+		{"MaxZFace", api.Pose{X: 0, Y: 1, Z: maxZ}, true},
+		{"BelowMinX", api.Pose{X: minX - outside, Y: 1, Z: 0}, false},
+		{"AboveMaxX", api.Pose{X: maxX + outside, Y: 1, Z: 0}, false},
+		{"BelowMinY", api.Pose{X: 0, Y: minY - outside, Z: 0}, false},
+		{"AboveMaxY", api.Pose{X: 0, Y: maxY + outside, Z: 0}, false},
+		{"BelowMinZ", api.Pose{X: 0, Y: 1, Z: minZ - outside}, false},
+		{"AboveMaxZ", api.Pose{X: 0, Y: 1, Z: maxZ + outside}, false},
+		{"OutsideCorner", api.Pose{X: maxX + outside, Y: maxY + outside, Z: maxZ + outside}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesPoseFilters(t, query, bindVars, tt.pose); got != tt.want {
+				t.Errorf("Expected %+v selected %t, got %t", tt.pose, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBuildSessionsQuery(t *testing.T) {
+	query, bindVars, err := buildSessionsQuery(&api.SessionListParams{Since: 1700000000000})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(query, "COLLECT session_id = doc.session_id") {
+		t.Errorf("Expected sessions to be grouped: %s", query)
+	}
+	if !strings.Contains(query, "FILTER last_ts >= @since") || bindVars["since"] != int64(1700000000000) {
+		t.Errorf("Expected since filter on last activity: %s", query)
+	}
+	if bindVars["limit"] != 51 {
+		t.Errorf("Expected default limit of 50 plus one, got %v", bindVars["limit"])
+	}
+
+	if _, _, err := buildSessionsQuery(&api.SessionListParams{Cursor: "bogus"}); err == nil {
+		t.Error("Expected error for invalid cursor")
+	}
+
+	// A token's sessions restrict the anchors grouped
+	if strings.Contains(query, "@scope_sessions") {
+		t.Errorf("Expected no scope filter without a scope: %s", query)
+	}
+	query, bindVars, err = buildSessionsQuery(&api.SessionListParams{Scope: &api.SessionScope{Tenant: "acme"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(query, "FILTER doc.session_id IN @scope_sessions") || strings.Index(query, "@scope_tenant") > strings.Index(query, "COLLECT") {
+		t.Errorf("Expected the scope filtered before grouping: %s", query)
+	}
+	if sessions, ok := bindVars["scope_sessions"].([]string); !ok || sessions == nil || bindVars["scope_tenant"] != "acme" {
+		t.Errorf("Expected an empty session list and tenant acme, got %v and %v", bindVars["scope_sessions"], bindVars["scope_tenant"])
+	}
+}
+
+func TestVerifyMeshChecksum(t *testing.T) {
+	vertices := []byte{1, 2, 3, 4}
+	faces := []byte{0, 1, 2}
+
+	// CRC32 (IEEE) of the concatenated buffers
+	checksum := fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte{1, 2, 3, 4, 0, 1, 2}))
+	if err := verifyMeshChecksum(checksum, vertices, faces, nil); err != nil {
+		t.Errorf("Expected checksum to match: %v", err)
+	}
+
+	// Truncated transfer
+	if err := verifyMeshChecksum(checksum, vertices[:3], faces, nil); err == nil {
+		t.Error("Expected mismatch for truncated vertices")
+	}
+
+	if err := verifyMeshChecksum("not-hex", vertices, faces, nil); err == nil {
+		t.Error("Expected error for malformed checksum")
+	}
+}
+
+func TestMeshUpdateCompressionLevel(t *testing.T) {
+	repo := &Repository{meshHashCache: newHashCache(0)}
+
+	for _, level := range []int{-1, 10, 1 << 20} {
+		msg := &api.WSMessage{
+			Type:      api.WSTypeMeshUpdate,
+			SessionID: "s1",
+			Data:      []byte(fmt.Sprintf(`{"id":"m1","anchor_id":"a1","vertices":"AQID","compression_level":%d}`, level)),
+		}
+		err := repo.processMeshUpdate(context.Background(), msg)
+		if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.Code != "VALIDATION_ERROR" || !strings.Contains(apiErr.Message, "compression_level") {
+			t.Errorf("Expected level %d rejected over WebSocket, got %v", level, err)
+		}
+	}
+
+	// HTTP ingest shares the check, as nested meshes escape the binding tag
+	mesh := &api.Mesh{ID: "d1", IsDelta: true, BaseMeshID: "base1", DeltaData: []byte{1}, CompressionLevel: 12}
+	if _, _, err := repo.processMeshForStorage(context.Background(), mesh); err == nil {
+		t.Error("Expected level 12 rejected on ingest")
+	}
+
+	repo.clampLevels = true
+	processed, _, err := repo.processMeshForStorage(context.Background(), mesh)
+	if err != nil || processed.CompressionLevel != MaxCompressionLevel {
+		t.Errorf("Expected level 12 clamped to %d, got %v", MaxCompressionLevel, err)
+	}
+	if level, err := repo.checkCompressionLevel("m1", -3); err != nil || level != 0 {
+		t.Errorf("Expected level -3 clamped to 0, got %d, %v", level, err)
+	}
+}
+
+func TestBuildQueryHistory(t *testing.T) {
+	repo := &Repository{}
+
+	query, bindVars, err := repo.buildQuery(&api.QueryParams{AnchorID: "a1", History: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if bindVars["@collection"] != database.AnchorPosesCollection {
+		t.Errorf("Expected history to read pose samples, got %v", bindVars["@collection"])
+	}
+	if !strings.Contains(query, "doc.anchor_id == @anchor_id") || !strings.Contains(query, "SORT doc.timestamp DESC, doc.anchor_id ASC") {
+		t.Errorf("Expected samples filtered and ordered by anchor_id: %s", query)
+	}
+	if _, ok := bindVars["@anchors"]; ok {
+		t.Errorf("Unexpected reference anchor bind var without a radius: %v", bindVars)
+	}
+
+	// The latest pose comes from the anchors themselves
+	query, bindVars, err = repo.buildQuery(&api.QueryParams{AnchorID: "a1", Limit: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if bindVars["@collection"] != database.AnchorsCollection || !strings.Contains(query, "doc.id == @anchor_id") {
+		t.Errorf("Expected latest anchor lookup by id: %s %v", query, bindVars)
+	}
+}
+
+func TestBuildQueryReferenceAnchorSession(t *testing.T) {
+	repo := &Repository{}
+
+	// Anchor IDs repeat across sessions, so the session picks the reference anchor
+	query, bindVars, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", AnchorID: "a1", Radius: 2})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(query, "a.id == @anchor_id AND (@session_id == \"\" OR a.session_id == @session_id)") {
+		t.Errorf("Expected the reference anchor looked up within the session: %s", query)
+	}
+	if bindVars["session_id"] != "s1" {
+		t.Errorf("Expected session s1, got %v", bindVars["session_id"])
+	}
+
+	_, bindVars, err = repo.buildQuery(&api.QueryParams{AnchorID: "a1", Radius: 2})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if session, ok := bindVars["session_id"]; !ok || session != "" {
+		t.Errorf("Expected an empty session bind var without a session, got %v", bindVars)
+	}
+}
+
+func TestBuildQueryRadius(t *testing.T) {
+	repo := &Repository{}
+	distance := "SQRT(POW(doc.pose.x - refAnchor.pose.x, 2) + POW(doc.pose.y - refAnchor.pose.y, 2) + POW(doc.pose.z - refAnchor.pose.z, 2)) <= @radius"
+
+	// Anchors are pre-filtered through the location geo index, then in 3D
+	query, _, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", AnchorID: "a1", Radius: 2})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	geo := strings.Index(query, "GEO_DISTANCE(refAnchor.location, doc.location) <= @radius")
+	if geo < 0 || geo > strings.Index(query, distance) {
+		t.Errorf("Expected the geo index pre-filter before the 3D distance check: %s", query)
+	}
+
+	// Pose samples have no location to index
+	query, _, err = repo.buildQuery(&api.QueryParams{SessionID: "s1", AnchorID: "a1", Radius: 2, History: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(query, "GEO_DISTANCE") || !strings.Contains(query, "ABS(doc.pose.x - refAnchor.pose.x) <= @radius") || !strings.Contains(query, distance) {
+		t.Errorf("Expected history pre-filtered by coordinates, then in 3D: %s", query)
+	}
+}
+
+func TestRetryWrite(t *testing.T) {
+	repo := &Repository{
+		logger: logger.New(logger.Config{}),
+		metrics: &metrics.Metrics{
+			DBRetriesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_db_retries_total"}, []string{"operation", "class"}),
+		},
+		writeRetries:    2,
+		writeRetryDelay: time.Millisecond,
+	}
+	conflict := driver.ArangoError{HasError: true, Code: 409, ErrorNum: driver.ErrArangoConflict}
+
+	// Transient conflicts are retried until the write succeeds
+	calls := 0
+	err := repo.retryWrite(context.Background(), "ingest_anchor", func() error {
+		calls++
+		if calls < 3 {
+			return databaseError("failed to upsert anchor", conflict)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("Expected success on the third attempt, got %v after %d calls", err, calls)
+	}
+	if got := testutil.ToFloat64(repo.metrics.DBRetriesTotal.WithLabelValues("ingest_anchor", "conflict")); got != 2 {
+		t.Errorf("Expected 2 counted retries, got %v", got)
+	}
+
+	// Conflicts outlasting the retries surface as a retryable 409
+	calls = 0
+	err = repo.retryWrite(context.Background(), "ingest_mesh", func() error {
+		calls++
+		return databaseError("failed to create mesh", conflict)
+	})
+	apiErr, ok := errors.IsAPIError(err)
+	if !ok || apiErr.StatusCode != 409 || !apiErr.IsRetryable() || calls != 3 {
+		t.Errorf("Expected a retryable conflict after 3 attempts, got %v after %d calls", err, calls)
+	}
+
+	// Writes in a stream transaction, which a conflict aborts, run once
+	calls = 0
+	txCtx := context.WithValue(context.Background(), transactionKey{}, true)
+	err = repo.retryWrite(txCtx, "ingest_anchor", func() error {
+		calls++
+		return databaseError("failed to upsert anchor", conflict)
+	})
+	if apiErr, ok := errors.IsAPIError(err); !ok || !apiErr.IsRetryable() || calls != 1 {
+		t.Errorf("Expected a single attempt inside a transaction, got %v after %d calls", err, calls)
+	}
+
+	// Permanent failures are not retried
+	calls = 0
+	err = repo.retryWrite(context.Background(), "ingest_mesh", func() error {
+		calls++
+		return databaseError("failed to create mesh", driver.ArangoError{HasError: true, Code: 400, ErrorNum: 1501})
+	})
+	apiErr, ok = errors.IsAPIError(err)
+	if !ok || apiErr.StatusCode != 500 || apiErr.IsRetryable() || calls != 1 {
+		t.Errorf("Expected a single non-retryable attempt, got %v after %d calls", err, calls)
+	}
+}
+
+func TestIngestRetriesTransaction(t *testing.T) {
+	var mu sync.Mutex
+	failed := false
+	repo, queries := fakeArangoRepository(t, 0, func(fakeQuery) int {
+		mu.Lock()
+		defer mu.Unlock()
+		if !failed {
+			failed = true
+			return driver.ErrArangoConflict
+		}
+		return 0
+	})
+	repo.writeRetries = 1
+	repo.writeRetryDelay = time.Millisecond
+
+	event := &api.SpatialEvent{SessionID: "s1", EventID: "e1", Timestamp: 1000, Anchors: testAnchors(1)}
+	if err := repo.Ingest(context.Background(), event); err != nil {
+		t.Fatalf("Expected the retried transaction to succeed, got %v", err)
+	}
+
+	// The conflict aborted the transaction, so the retry replays it from
+	// its first statement rather than resuming at the failed one
+	received := queries()
+	if len(received) < 2 {
+		t.Fatalf("Expected the transaction to run twice, got %d queries", len(received))
+	}
+	var runs []string
+	for _, q := range received {
+		if q.Query == received[0].Query {
+			runs = append(runs, q.Transaction)
+		}
+	}
+	if len(runs) != 2 || runs[0] == "" || runs[0] == runs[1] {
+		t.Errorf("Expected the first statement in two transactions, got it in %q", runs)
+	}
+}
+
+func TestDatabaseErrorEndedRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"Deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), 504, "QUERY_TIMEOUT"},
+		{"Disconnect", fmt.Errorf("query: %w", context.Canceled), 499, "CLIENT_CLOSED_REQUEST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := databaseError("failed to query anchors", tt.err)
+			if apiErr.StatusCode != tt.wantStatus || apiErr.Code != tt.wantCode {
+				t.Errorf("Expected %d %s, got %d %s", tt.wantStatus, tt.wantCode, apiErr.StatusCode, apiErr.Code)
+			}
+		})
+	}
+}
+
+func TestValidateEventSize(t *testing.T) {
+	repo := &Repository{maxEventAnchors: 2, maxEventMeshes: 1}
+
+	event := &api.SpatialEvent{EventID: "e1", Anchors: make([]api.Anchor, 2), Meshes: make([]api.Mesh, 1)}
+	if err := repo.ValidateEventSize(event); err != nil {
+		t.Errorf("Expected an event at the limits accepted, got %v", err)
+	}
+
+	event.Anchors = make([]api.Anchor, 3)
+	err := repo.ValidateEventSize(event)
+	if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.Code != "VALIDATION_ERROR" || !strings.Contains(apiErr.Message, "3 anchors, above the limit of 2") {
+		t.Errorf("Expected a validation error reporting the anchor limit, got %v", err)
+	}
+
+	event.Anchors = make([]api.Anchor, 2)
+	event.Meshes = make([]api.Mesh, 2)
+	err = repo.ValidateEventSize(event)
+	if apiErr, ok := errors.IsAPIError(err); !ok || !strings.Contains(apiErr.Message, "2 meshes, above the limit of 1") {
+		t.Errorf("Expected a validation error reporting the mesh limit, got %v", err)
+	}
+
+	unlimited := &Repository{}
+	if err := unlimited.ValidateEventSize(&api.SpatialEvent{Anchors: make([]api.Anchor, 100000)}); err != nil {
+		t.Errorf("Expected no limit when unset, got %v", err)
+	}
+}
+
+func TestRecordIngestPayloadSizes(t *testing.T) {
+	repo := &Repository{metrics: &metrics.Metrics{
+		AnchorsTotal:      prometheus.NewCounterVec(prometheus.CounterOpts{Name: "anchors"}, []string{"session_id", "operation"}),
+		MeshesTotal:       prometheus.NewCounterVec(prometheus.CounterOpts{Name: "meshes"}, []string{"session_id", "type", "operation"}),
+		DBOperationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ops"}, []string{"operation", "collection", "status"}),
+		MeshBytes:         prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "mesh_bytes", Buckets: []float64{100}}, []string{"type"}),
+		AnchorBytes:       prometheus.NewHistogram(prometheus.HistogramOpts{Name: "anchor_bytes"}),
+	}}
+
+	event := &api.SpatialEvent{
+		SessionID: "s1",
+		Anchors:   []api.Anchor{{ID: "a1"}, {ID: "a2"}},
+		Meshes: []api.Mesh{
+			{ID: "m1", Vertices: make([]byte, 36), Faces: make([]byte, 12), Normals: make([]byte, 36)},
+			{ID: "m2", IsDelta: true, BaseMeshID: "m1", DeltaData: make([]byte, 200)},
+		},
+	}
+	repo.recordIngest(event, &ingestResult{})
+
+	if count := testutil.CollectAndCount(repo.metrics.AnchorBytes); count != 1 {
+		t.Errorf("Expected one anchor size series, got %d", count)
+	}
+	expected := `
+		# HELP mesh_bytes 
+		# TYPE mesh_bytes histogram
+		mesh_bytes_bucket{type="delta",le="100"} 0
+		mesh_bytes_bucket{type="delta",le="+Inf"} 1
+		mesh_bytes_sum{type="delta"} 200
+		mesh_bytes_count{type="delta"} 1
+		mesh_bytes_bucket{type="full",le="100"} 1
+		mesh_bytes_bucket{type="full",le="+Inf"} 1
+		mesh_bytes_sum{type="full"} 84
+		mesh_bytes_count{type="full"} 1
+	`
+	if err := testutil.CollectAndCompare(repo.metrics.MeshBytes, strings.NewReader(expected)); err != nil {
+		t.Errorf("Unexpected mesh sizes: %v", err)
+	}
+}
+
+func TestBuildQueryHasMesh(t *testing.T) {
+	repo := &Repository{defaultLimit: 100}
+
+	for _, hasMesh := range []string{"true", "false"} {
+		query, bindVars, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", HasMesh: hasMesh})
+		if err != nil {
+			t.Fatalf("has_mesh=%s: unexpected error: %v", hasMesh, err)
+		}
+		if bindVars["@meshes"] != database.MeshesCollection {
+			t.Errorf("has_mesh=%s: expected the meshes collection bound, got %v", hasMesh, bindVars)
+		}
+		for _, clause := range []string{
+			`OPTIONS { indexHint: "idx_anchor_id" } FILTER m.anchor_id == doc.id AND m.session_id == doc.session_id LIMIT 1`,
+			"{ session_id: doc.session_id, anchor_id: doc.id } IN m.referenced_by",
+		} {
+			if !strings.Contains(query, clause) {
+				t.Errorf("has_mesh=%s: expected %q in query: %s", hasMesh, clause, query)
+			}
+		}
+		if negated := strings.Contains(query, "NOT (LENGTH("); negated != (hasMesh == "false") {
+			t.Errorf("has_mesh=%s: expected negation %v: %s", hasMesh, hasMesh == "false", query)
+		}
+	}
+
+	// History samples name their anchor in anchor_id
+	query, _, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", HasMesh: "false", History: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(query, "m.anchor_id == doc.anchor_id") {
+		t.Errorf("Expected history samples matched by anchor_id: %s", query)
+	}
+
+	for _, hasMesh := range []string{"", "any"} {
+		_, bindVars, err := repo.buildQuery(&api.QueryParams{SessionID: "s1", HasMesh: hasMesh})
+		if err != nil {
+			t.Fatalf("has_mesh=%q: unexpected error: %v", hasMesh, err)
+		}
+		if _, ok := bindVars["@meshes"]; ok {
+			t.Errorf("has_mesh=%q: expected no mesh filter, got %v", hasMesh, bindVars)
+		}
+	}
+
+	_, _, err = repo.buildQuery(&api.QueryParams{SessionID: "s1", HasMesh: "maybe"})
+	if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.Code != "VALIDATION_ERROR" {
+		t.Errorf("Expected a validation error for an unknown has_mesh, got %v", err)
+	}
+}
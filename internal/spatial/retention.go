@@ -0,0 +1,160 @@
+package spatial
+
+import (
+	"context"
+	"time"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// Retention deletes whole sessions rather than single old documents, so a
+// session is never left with anchors whose meshes or edges are gone. Expired
+// sessions are removed as DeleteSession removes them: meshes other sessions
+// still reference are handed to those sessions instead of being deleted.
+
+// StartRetention starts the sweeper deleting sessions idle for longer than
+// cfg.MaxAge, if set, until Close. Sessions for which active reports true,
+// such as those with WebSocket clients, are kept however old their anchors.
+func (r *Repository) StartRetention(cfg config.RetentionConfig, active func(sessionID string) bool) {
+	if cfg.MaxAge <= 0 {
+		return
+	}
+
+	r.logger.Infof("Deleting sessions idle for %s, checking every %s", cfg.MaxAge, cfg.Interval)
+	r.wg.Add(1)
+	go r.runRetention(cfg, active)
+}
+
+// runRetention sweeps expired sessions every cfg.Interval
+func (r *Repository) runRetention(cfg config.RetentionConfig, active func(sessionID string) bool) {
+	defer r.wg.Done()
+
+	// Deletes in progress are abandoned on Close, and audited as the server's
+	ctx, cancel := context.WithCancel(audit.WithActor(context.Background(), audit.Actor{Principal: audit.SystemPrincipal}))
+	defer cancel()
+	go func() {
+		<-r.done
+		cancel()
+	}()
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			result, err := r.expireSessions(ctx, now.Add(-cfg.MaxAge), cfg.MaxSessions, active)
+			if err != nil {
+				r.metrics.RetentionRuns.WithLabelValues("error").Inc()
+				r.logger.Errorf("Retention sweep failed: %v", err)
+				continue
+			}
+			r.metrics.RetentionRuns.WithLabelValues("success").Inc()
+			if len(result.sessions) > 0 || result.skipped > 0 {
+				r.logger.Infof("Retention sweep deleted %d sessions (%d anchors, %d meshes, %d edges, %d poses), keeping %d active",
+					len(result.sessions), result.anchors, result.meshes, result.edges, result.poses, result.skipped)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// retentionResult totals the documents one sweep deleted
+type retentionResult struct {
+	sessions []string // Deleted sessions
+	skipped  int      // Expired sessions kept as active
+
+	anchors int
+	meshes  int
+	edges   int
+	poses   int
+}
+
+// add counts a deleted session's documents
+func (res *retentionResult) add(deleted *api.SessionDeleteResponse) {
+	res.sessions = append(res.sessions, deleted.SessionID)
+	res.anchors += deleted.Anchors
+	res.meshes += deleted.Meshes
+	res.edges += deleted.Edges
+	res.poses += deleted.Poses
+}
+
+// expireSessions deletes up to limit sessions whose anchors were all last
+// updated before cutoff, oldest first, skipping those for which active
+// reports true. Sessions deleted before an error are counted in the result.
+func (r *Repository) expireSessions(ctx context.Context, cutoff time.Time, limit int, active func(sessionID string) bool) (*retentionResult, error) {
+	expired, err := r.expiredSessions(ctx, cutoff.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return r.expire(ctx, expired, active, r.DeleteSession)
+}
+
+// expire deletes the given sessions with remove unless active, recording
+// what was deleted
+func (r *Repository) expire(ctx context.Context, sessions []string, active func(sessionID string) bool,
+	remove func(ctx context.Context, sessionID string, dryRun bool) (*api.SessionDeleteResponse, error)) (*retentionResult, error) {
+	result := &retentionResult{}
+	defer func() {
+		r.metrics.RetentionExpired.WithLabelValues("sessions").Add(float64(len(result.sessions)))
+		r.metrics.RetentionExpired.WithLabelValues("anchors").Add(float64(result.anchors))
+		r.metrics.RetentionExpired.WithLabelValues("meshes").Add(float64(result.meshes))
+		r.metrics.RetentionExpired.WithLabelValues("edges").Add(float64(result.edges))
+		r.metrics.RetentionExpired.WithLabelValues("poses").Add(float64(result.poses))
+	}()
+
+	for _, sessionID := range sessions {
+		if active != nil && active(sessionID) {
+			result.skipped++
+			continue
+		}
+
+		deleted, err := remove(ctx, sessionID, false)
+		if err != nil {
+			return result, err
+		}
+		result.add(deleted)
+	}
+	return result, nil
+}
+
+// expiredSessions returns up to limit sessions whose latest anchor timestamp
+// is before cutoff, in Unix milliseconds, least recently active first
+func (r *Repository) expiredSessions(ctx context.Context, cutoff int64, limit int) ([]string, error) {
+	query := `
+		FOR a IN @@anchors
+		COLLECT session_id = a.session_id AGGREGATE last_ts = MAX(a.timestamp)
+		FILTER last_ts < @cutoff
+		SORT last_ts ASC
+		LIMIT @limit
+		RETURN session_id
+	`
+	bindVars := map[string]interface{}{
+		"@anchors": database.AnchorsCollection,
+		"cutoff":   cutoff,
+		"limit":    limit,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to find expired sessions", err)
+	}
+	defer cursor.Close()
+
+	var sessions []string
+	for {
+		var sessionID string
+		if _, err := cursor.ReadDocument(ctx, &sessionID); driver.IsNoMoreDocuments(err) {
+			return sessions, nil
+		} else if err != nil {
+			return nil, databaseError("failed to read expired session", err)
+		}
+		sessions = append(sessions, sessionID)
+	}
+}
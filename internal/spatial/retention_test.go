@@ -0,0 +1,81 @@
+package spatial
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/internal/metrics"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/logger"
+)
+
+func TestExpireSkipsActiveSessions(t *testing.T) {
+	repo := &Repository{metrics: &metrics.Metrics{
+		RetentionExpired: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "expired"}, []string{"kind"}),
+	}}
+
+	var removed []string
+	remove := func(ctx context.Context, sessionID string, dryRun bool) (*api.SessionDeleteResponse, error) {
+		if dryRun {
+			t.Errorf("Expected session %s deleted, not counted", sessionID)
+		}
+		if sessionID == "broken" {
+			return nil, errors.New("write conflict")
+		}
+		removed = append(removed, sessionID)
+		return &api.SessionDeleteResponse{SessionID: sessionID, Anchors: 3, Meshes: 2, SharedMeshes: 1, Edges: 4, Poses: 5}, nil
+	}
+	active := func(sessionID string) bool { return sessionID == "live" }
+
+	result, err := repo.expire(context.Background(), []string{"old-1", "live", "old-2"}, active, remove)
+	if err != nil {
+		t.Fatalf("Failed to expire sessions: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != "old-1" || removed[1] != "old-2" {
+		t.Errorf("Expected the idle sessions deleted, got %v", removed)
+	}
+	if len(result.sessions) != 2 || result.skipped != 1 || result.anchors != 6 || result.meshes != 4 || result.edges != 8 || result.poses != 10 {
+		t.Errorf("Expected two sessions' documents counted and one kept, got %+v", result)
+	}
+
+	counts := map[string]float64{"sessions": 2, "anchors": 6, "meshes": 4, "edges": 8, "poses": 10}
+	for kind, want := range counts {
+		if got := testutil.ToFloat64(repo.metrics.RetentionExpired.WithLabelValues(kind)); got != want {
+			t.Errorf("Expected %v expired %s recorded, got %v", want, kind, got)
+		}
+	}
+
+	// Sessions deleted before a failure are still recorded
+	result, err = repo.expire(context.Background(), []string{"old-3", "broken", "old-4"}, active, remove)
+	if err == nil || len(result.sessions) != 1 || result.sessions[0] != "old-3" {
+		t.Errorf("Expected the sweep stopped after old-3, got %+v, %v", result, err)
+	}
+	if got := testutil.ToFloat64(repo.metrics.RetentionExpired.WithLabelValues("sessions")); got != 3 {
+		t.Errorf("Expected 3 expired sessions recorded, got %v", got)
+	}
+}
+
+func TestStartRetentionStopsOnClose(t *testing.T) {
+	repo := &Repository{logger: logger.New(logger.Config{}), done: make(chan struct{})}
+
+	// Disabled retention starts nothing
+	repo.StartRetention(config.RetentionConfig{}, nil)
+
+	repo.StartRetention(config.RetentionConfig{MaxAge: time.Hour, Interval: time.Hour, MaxSessions: 10}, nil)
+	closed := make(chan struct{})
+	go func() {
+		repo.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to stop the sweeper")
+	}
+}
@@ -0,0 +1,319 @@
+package spatial
+
+import (
+	"context"
+	"time"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/audit"
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// ListSessions returns sessions with aggregate stats, most recently active first
+func (r *Repository) ListSessions(ctx context.Context, params *api.SessionListParams) (*api.SessionListResponse, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("query", "sessions").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	query, bindVars, err := buildSessionsQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("query", "sessions", "error").Inc()
+		return nil, databaseError("failed to list sessions", err)
+	}
+	defer cursor.Close()
+
+	sessions := []api.SessionSummary{}
+	for {
+		var session api.SessionSummary
+		_, err := cursor.ReadDocument(ctx, &session)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, databaseError("failed to read session", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	// One extra row is fetched to detect whether another page exists
+	limit := sessionsLimit(params)
+	hasMore := len(sessions) > limit
+	if hasMore {
+		sessions = sessions[:limit]
+	}
+
+	response := &api.SessionListResponse{
+		Sessions: sessions,
+		Count:    len(sessions),
+		HasMore:  hasMore,
+	}
+	if hasMore {
+		last := sessions[len(sessions)-1]
+		response.Cursor = encodeQueryCursor(last.LastTimestamp, last.SessionID)
+	}
+
+	r.metrics.DBOperationsTotal.WithLabelValues("query", "sessions", "success").Inc()
+	return response, nil
+}
+
+// buildSessionsQuery groups anchors by session and pages by last activity
+func buildSessionsQuery(params *api.SessionListParams) (string, map[string]interface{}, error) {
+	bindVars := map[string]interface{}{
+		"@anchors": database.AnchorsCollection,
+		"@meshes":  database.MeshesCollection,
+		"limit":    sessionsLimit(params) + 1,
+	}
+
+	query := "FOR doc IN @@anchors"
+	if scope := params.Scope; scope != nil {
+		query += `
+FILTER doc.session_id IN @scope_sessions OR (@scope_tenant != "" AND (doc.session_id == @scope_tenant OR STARTS_WITH(doc.session_id, CONCAT(@scope_tenant, ":"))))`
+		bindVars["scope_sessions"] = append([]string{}, scope.Sessions...)
+		bindVars["scope_tenant"] = scope.Tenant
+	}
+
+	query += `
+COLLECT session_id = doc.session_id INTO group = { id: doc.id, ts: doc.timestamp }
+LET first_ts = MIN(group[*].ts)
+LET last_ts = MAX(group[*].ts)`
+
+	if params.Since > 0 {
+		query += "\nFILTER last_ts >= @since"
+		bindVars["since"] = params.Since
+	}
+
+	if params.Cursor != "" {
+		cursorTS, cursorID, err := decodeQueryCursor(params.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		query += "\nFILTER last_ts < @cursor_ts OR (last_ts == @cursor_ts AND session_id > @cursor_id)"
+		bindVars["cursor_ts"] = cursorTS
+		bindVars["cursor_id"] = cursorID
+	}
+
+	query += `
+SORT last_ts DESC, session_id ASC
+LIMIT @limit
+LET mesh_count = LENGTH(FOR m IN @@meshes FILTER m.session_id == session_id AND m.lod_of == null RETURN 1)
+RETURN {
+	session_id: session_id,
+	anchor_count: LENGTH(group),
+	mesh_count: mesh_count,
+	first_timestamp: first_ts,
+	last_timestamp: last_ts
+}`
+
+	return query, bindVars, nil
+}
+
+// sessionsLimit returns the page size for a session listing
+func sessionsLimit(params *api.SessionListParams) int {
+	if params.Limit > 0 {
+		return params.Limit
+	}
+	return 50 // Default limit
+}
+
+// DeleteSession removes a session's anchors, their pose history, meshes and
+// topology edges in one transaction, keeping meshes other sessions share for
+// them. Offloaded geometry no remaining mesh refers to is deleted from object
+// storage once the transaction commits. A dry run only counts them.
+func (r *Repository) DeleteSession(ctx context.Context, sessionID string, dryRun bool) (*api.SessionDeleteResponse, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("delete", "sessions").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	response := &api.SessionDeleteResponse{SessionID: sessionID, DryRun: dryRun}
+
+	var err error
+	if dryRun {
+		err = r.countSessionDocuments(ctx, sessionID, response)
+	} else {
+		var removed []removedMesh
+		err = r.withTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			removed, err = r.removeSessionDocuments(ctx, sessionID, response)
+			return err
+		})
+
+		// Drop cache entries for removed meshes so their geometry is stored
+		// again on its next ingest. Copies held by other sessions are found
+		// again through the hash index.
+		if err == nil {
+			for _, mesh := range removed {
+				if mesh.Hash != "" {
+					r.meshHashCache.remove(mesh.Hash, mesh.ID)
+				}
+			}
+			r.updateCacheSize()
+			r.deleteMeshObjects(ctx, removed)
+			r.forgetSessionCompressionLevel(sessionID)
+			if r.timestamps != nil {
+				r.timestamps.forget(sessionID)
+			}
+			if r.anchorDedup != nil {
+				r.anchorDedup.forget(sessionID)
+			}
+			r.metrics.ForgetSession(sessionID)
+			r.audit(ctx, audit.Record{
+				Action:    audit.ActionDeleteSession,
+				SessionID: sessionID,
+				Anchors:   response.Anchors,
+				Meshes:    response.Meshes,
+				Edges:     response.Edges,
+				Poses:     response.Poses,
+			})
+		}
+	}
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("delete", "sessions", "error").Inc()
+		return nil, err
+	}
+
+	r.metrics.DBOperationsTotal.WithLabelValues("delete", "sessions", "success").Inc()
+	return response, nil
+}
+
+// removedMesh identifies a deleted mesh document's dedup cache entry and
+// offloaded geometry
+type removedMesh struct {
+	ID        string             `json:"id"`
+	Hash      string             `json:"hash"`
+	BufferRef *api.MeshBufferRef `json:"buffer_ref"`
+}
+
+// countSessionDocuments counts the documents DeleteSession would remove
+func (r *Repository) countSessionDocuments(ctx context.Context, sessionID string, response *api.SessionDeleteResponse) error {
+	query := `
+		LET anchors = (FOR a IN @@anchors FILTER a.session_id == @session_id RETURN { id: a.id, _id: a._id })
+		RETURN {
+			anchors: LENGTH(anchors),
+			meshes: LENGTH(FOR m IN @@meshes FILTER m.session_id == @session_id
+				FILTER LENGTH(` + meshReferences + `[* FILTER CURRENT.session_id != @session_id]) == 0 RETURN 1),
+			shared_meshes: LENGTH(FOR m IN @@meshes FILTER m.session_id == @session_id OR @session_id IN m.referenced_by[*].session_id
+				FILTER LENGTH(` + meshReferences + `[* FILTER CURRENT.session_id != @session_id]) > 0 RETURN 1),
+			edges: LENGTH(FOR e IN @@edges FILTER e._from IN anchors[*]._id OR e._to IN anchors[*]._id RETURN 1),
+			poses: LENGTH(FOR p IN @@poses FILTER p.session_id == @session_id RETURN 1)
+		}
+	`
+	bindVars := map[string]interface{}{
+		"@anchors":   database.AnchorsCollection,
+		"@meshes":    database.MeshesCollection,
+		"@edges":     database.TopologyEdges,
+		"@poses":     database.AnchorPosesCollection,
+		"session_id": sessionID,
+	}
+
+	if err := r.readSingle(ctx, query, bindVars, response); err != nil {
+		return databaseError("failed to count session documents", err)
+	}
+	return nil
+}
+
+// removeSessionDocuments deletes a session's edges, meshes, poses and anchors,
+// recording the counts in response and returning the removed meshes. Meshes
+// other sessions reference are released to them instead, see meshrefs.go.
+func (r *Repository) removeSessionDocuments(ctx context.Context, sessionID string, response *api.SessionDeleteResponse) ([]removedMesh, error) {
+	edgesQuery := `
+		LET ids = (FOR a IN @@anchors FILTER a.session_id == @session_id RETURN a._id)
+		LET removed = (
+			FOR e IN @@edges
+			FILTER e._from IN ids OR e._to IN ids
+			REMOVE e IN @@edges
+			RETURN 1
+		)
+		RETURN LENGTH(removed)
+	`
+	edgesVars := map[string]interface{}{
+		"@anchors":   database.AnchorsCollection,
+		"@edges":     database.TopologyEdges,
+		"session_id": sessionID,
+	}
+	if err := r.readSingle(ctx, edgesQuery, edgesVars, &response.Edges); err != nil {
+		return nil, databaseError("failed to delete session edges", err)
+	}
+
+	shared, err := r.releaseSessionMeshes(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	response.SharedMeshes = shared
+
+	meshesQuery := `
+		LET removed = (
+			FOR m IN @@meshes
+			FILTER m.session_id == @session_id
+			REMOVE m IN @@meshes
+			RETURN { id: OLD.id, hash: OLD.hash, buffer_ref: OLD.buffer_ref }
+		)
+		RETURN removed
+	`
+	meshesVars := map[string]interface{}{
+		"@meshes":    database.MeshesCollection,
+		"session_id": sessionID,
+	}
+	var meshes []removedMesh
+	if err := r.readSingle(ctx, meshesQuery, meshesVars, &meshes); err != nil {
+		return nil, databaseError("failed to delete session meshes", err)
+	}
+	response.Meshes = len(meshes)
+
+	posesQuery := `
+		LET removed = (
+			FOR p IN @@poses
+			FILTER p.session_id == @session_id
+			REMOVE p IN @@poses
+			RETURN 1
+		)
+		RETURN LENGTH(removed)
+	`
+	posesVars := map[string]interface{}{
+		"@poses":     database.AnchorPosesCollection,
+		"session_id": sessionID,
+	}
+	if err := r.readSingle(ctx, posesQuery, posesVars, &response.Poses); err != nil {
+		return nil, databaseError("failed to delete session pose history", err)
+	}
+
+	anchorsQuery := `
+		LET removed = (
+			FOR a IN @@anchors
+			FILTER a.session_id == @session_id
+			REMOVE a IN @@anchors
+			RETURN 1
+		)
+		RETURN LENGTH(removed)
+	`
+	anchorsVars := map[string]interface{}{
+		"@anchors":   database.AnchorsCollection,
+		"session_id": sessionID,
+	}
+	if err := r.readSingle(ctx, anchorsQuery, anchorsVars, &response.Anchors); err != nil {
+		return nil, databaseError("failed to delete session anchors", err)
+	}
+
+	return meshes, nil
+}
+
+// readSingle runs a query returning one value and decodes it into result
+func (r *Repository) readSingle(ctx context.Context, query string, bindVars map[string]interface{}, result interface{}) error {
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	_, err = cursor.ReadDocument(ctx, result)
+	return err
+}
@@ -0,0 +1,63 @@
+package spatial
+
+import (
+	"context"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// SessionState reads up to limit of a session's anchors in one query, newest
+// first, reporting whether the session holds more. Unlike Query, the limit
+// is not clamped to the configured maximum.
+func (r *Repository) SessionState(ctx context.Context, sessionID string, limit int) ([]api.Anchor, bool, error) {
+	query := `
+		FOR doc IN @@collection
+		FILTER doc.session_id == @session_id
+		SORT doc.timestamp DESC, doc.id ASC
+		LIMIT @limit
+		RETURN doc
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.AnchorsCollection,
+		"session_id":  sessionID,
+		"limit":       limit + 1,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("query", "session_state", "error").Inc()
+		return nil, false, databaseError("failed to query session anchors", err)
+	}
+	defer cursor.Close()
+
+	anchors := []api.Anchor{}
+	for {
+		var anchor api.Anchor
+		_, err := cursor.ReadDocument(ctx, &anchor)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			r.metrics.DBOperationsTotal.WithLabelValues("query", "session_state", "error").Inc()
+			return nil, false, databaseError("failed to read anchor", err)
+		}
+		anchors = append(anchors, anchor)
+	}
+	r.metrics.DBOperationsTotal.WithLabelValues("query", "session_state", "success").Inc()
+
+	if len(anchors) > limit {
+		return anchors[:limit], true, nil
+	}
+	return anchors, false, nil
+}
+
+// AnchorMeshes loads the meshes of anchors, resolving deltas, as queries
+// including meshes do
+func (r *Repository) AnchorMeshes(ctx context.Context, anchors []api.Anchor) ([]api.Mesh, error) {
+	if len(anchors) == 0 {
+		return nil, nil
+	}
+	return r.loadMeshesForAnchors(ctx, anchors)
+}
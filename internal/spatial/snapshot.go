@@ -0,0 +1,421 @@
+package spatial
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Snapshots are tar archives, compressed as they are sent by the export
+// encoding of the response. A manifest.json entry comes first, followed by the documents of each collection as NDJSON, split into
+// entries named <collection>/<sequence>.ndjson of about snapshotChunkSize
+// bytes each, since tar needs an entry's size before its contents.
+const (
+	snapshotFormat       = "stag-snapshot"
+	snapshotVersion      = 1
+	snapshotManifestName = "manifest.json"
+	snapshotChunkSize    = 8 << 20
+	snapshotBatchSize    = 500 // Documents upserted per restore query
+)
+
+// snapshotCollections are the collections a snapshot holds, in the order
+// they are written and restored
+var snapshotCollections = []string{
+	database.AnchorsCollection,
+	database.AnchorPosesCollection,
+	database.MeshesCollection,
+	database.TopologyEdges,
+}
+
+// snapshotFilters select a session's documents from each collection. Shared
+// meshes are included when the session references them, and edges when
+// either end is one of the session's anchors.
+var snapshotFilters = map[string]string{
+	database.AnchorsCollection:     `FILTER doc.session_id == @session_id`,
+	database.AnchorPosesCollection: `FILTER doc.session_id == @session_id`,
+	database.MeshesCollection:      `FILTER doc.session_id == @session_id OR @session_id IN doc.referenced_by[*].session_id`,
+	database.TopologyEdges:         `FILTER doc._from IN session_anchors OR doc._to IN session_anchors`,
+}
+
+// WriteSnapshot writes a snapshot of every anchor, pose, mesh and topology
+// edge to w, or only those of a session when sessionID is set. Nothing is
+// written if the documents cannot be counted, or the session has no anchors.
+// Offloaded mesh buffers stay in the object store; the snapshot holds their
+// references only.
+func (r *Repository) WriteSnapshot(ctx context.Context, sessionID string, w io.Writer) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("query", "snapshot").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	err := r.writeSnapshot(ctx, sessionID, w)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("query", "snapshot", "error").Inc()
+		return err
+	}
+	r.metrics.DBOperationsTotal.WithLabelValues("query", "snapshot", "success").Inc()
+	return nil
+}
+
+func (r *Repository) writeSnapshot(ctx context.Context, sessionID string, w io.Writer) error {
+	counts, err := r.countSnapshotDocuments(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if sessionID != "" && counts[database.AnchorsCollection] == 0 {
+		return errors.NotFound(fmt.Sprintf("session %s not found", sessionID))
+	}
+
+	sw, err := newSnapshotWriter(w, &api.SnapshotManifest{
+		Format:    snapshotFormat,
+		Version:   snapshotVersion,
+		CreatedAt: time.Now().UnixMilli(),
+		SessionID: sessionID,
+		Documents: counts,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range snapshotCollections {
+		if err := r.exportCollection(ctx, sessionID, collection, sw); err != nil {
+			return err
+		}
+	}
+	if err := sw.Close(); err != nil {
+		return err
+	}
+
+	r.log(ctx).Infof("Wrote snapshot of %v", counts)
+	return nil
+}
+
+// snapshotQuery returns the query reading a collection's documents into a
+// snapshot, with the given body, and its bind variables
+func snapshotQuery(sessionID, collection, body string) (string, map[string]interface{}) {
+	bindVars := map[string]interface{}{"@collection": collection}
+	if sessionID == "" {
+		return "FOR doc IN @@collection " + body, bindVars
+	}
+
+	query := "FOR doc IN @@collection " + snapshotFilters[collection] + " " + body
+	bindVars["session_id"] = sessionID
+	if collection == database.TopologyEdges {
+		query = `LET session_anchors = (FOR a IN @@anchors FILTER a.session_id == @session_id RETURN a._id) ` + query
+		bindVars["@anchors"] = database.AnchorsCollection
+	}
+	return query, bindVars
+}
+
+// countSnapshotDocuments counts the documents a snapshot will hold per
+// collection
+func (r *Repository) countSnapshotDocuments(ctx context.Context, sessionID string) (map[string]int, error) {
+	counts := make(map[string]int, len(snapshotCollections))
+	for _, collection := range snapshotCollections {
+		query, bindVars := snapshotQuery(sessionID, collection, "COLLECT WITH COUNT INTO n RETURN n")
+		cursor, err := r.runQuery(ctx, query, bindVars)
+		if err != nil {
+			return nil, databaseError("failed to count "+collection, err)
+		}
+		var count int
+		_, err = cursor.ReadDocument(ctx, &count)
+		cursor.Close()
+		if err != nil && !driver.IsNoMoreDocuments(err) {
+			return nil, databaseError("failed to count "+collection, err)
+		}
+		counts[collection] = count
+	}
+	return counts, nil
+}
+
+// exportCollection writes a collection's documents to a snapshot without
+// their _id and _rev, which the restoring database assigns
+func (r *Repository) exportCollection(ctx context.Context, sessionID, collection string, sw *snapshotWriter) error {
+	// A streaming cursor keeps the server from holding whole collections
+	// in memory
+	query, bindVars := snapshotQuery(sessionID, collection, `RETURN UNSET(doc, "_id", "_rev")`)
+	cursor, err := r.runQuery(driver.WithQueryStream(ctx, true), query, bindVars)
+	if err != nil {
+		return databaseError("failed to export "+collection, err)
+	}
+	defer cursor.Close()
+
+	for {
+		var doc json.RawMessage
+		if _, err := cursor.ReadDocument(ctx, &doc); driver.IsNoMoreDocuments(err) {
+			return nil
+		} else if err != nil {
+			return databaseError("failed to export "+collection, err)
+		}
+		if err := sw.Add(collection, doc); err != nil {
+			return err
+		}
+	}
+}
+
+// RestoreSnapshot restores a snapshot read from rd, creating any missing
+// collections and indexes first. Documents are upserted by key, so restoring
+// the same snapshot again, or over the data it was taken from, leaves one
+// copy of each. The mesh dedup cache is rebuilt afterwards to cover the
+// restored meshes, and anchor dedup forgets the poses of restored sessions.
+func (r *Repository) RestoreSnapshot(ctx context.Context, rd io.Reader) (*api.SnapshotRestoreResponse, error) {
+	startTime := time.Now()
+
+	if err := database.Migrate(r.db, r.metadataIndexes, r.migrationTimeout); err != nil {
+		return nil, fmt.Errorf("failed to prepare database for restore: %w", err)
+	}
+
+	restored := make(map[string]int, len(snapshotCollections))
+	manifest, err := readSnapshot(rd, snapshotBatchSize, func(collection string, docs []json.RawMessage) error {
+		if err := r.upsertSnapshotDocuments(ctx, collection, docs); err != nil {
+			return err
+		}
+		restored[collection] += len(docs)
+		return nil
+	})
+	// Restored anchors, even of a partial restore, replace the poses dedup
+	// recorded for their sessions
+	if manifest != nil {
+		r.forgetAnchorPoses(manifest.SessionID)
+	} else if len(restored) > 0 {
+		r.forgetAnchorPoses("")
+	}
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("insert", "restore_snapshot", "error").Inc()
+		return nil, err
+	}
+	r.metrics.DBOperationsTotal.WithLabelValues("insert", "restore_snapshot", "success").Inc()
+	r.metrics.DBOperationDuration.WithLabelValues("insert", "restore_snapshot").
+		Observe(time.Since(startTime).Seconds())
+
+	dedup, err := r.RebuildDedupCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.log(ctx).Infof("Restored snapshot taken at %d: %v", manifest.CreatedAt, restored)
+	return &api.SnapshotRestoreResponse{
+		SessionID:    manifest.SessionID,
+		CreatedAt:    manifest.CreatedAt,
+		Documents:    restored,
+		DedupEntries: dedup.Entries,
+		DurationMs:   time.Since(startTime).Milliseconds(),
+	}, nil
+}
+
+// upsertSnapshotDocuments writes a batch of snapshot documents, replacing
+// any stored under the same key
+func (r *Repository) upsertSnapshotDocuments(ctx context.Context, collection string, docs []json.RawMessage) error {
+	query := `
+		FOR doc IN @docs
+		UPSERT { _key: doc._key }
+		INSERT doc
+		REPLACE doc
+		IN @@collection
+	`
+	bindVars := map[string]interface{}{
+		"@collection": collection,
+		"docs":        docs,
+	}
+
+	return r.retryWrite(ctx, "restore_snapshot", func() error {
+		cursor, err := r.runQuery(ctx, query, bindVars)
+		if err != nil {
+			return databaseError("failed to restore "+collection, err)
+		}
+		cursor.Close()
+		return nil
+	})
+}
+
+// snapshotWriter writes the entries of a snapshot archive
+type snapshotWriter struct {
+	tw      *tar.Writer
+	modTime time.Time
+
+	chunk      bytes.Buffer // NDJSON not yet written as an entry
+	collection string       // Collection of the documents in chunk
+	sequence   map[string]int
+}
+
+// newSnapshotWriter starts a snapshot archive on w with its manifest
+func newSnapshotWriter(w io.Writer, manifest *api.SnapshotManifest) (*snapshotWriter, error) {
+	sw := &snapshotWriter{
+		tw:       tar.NewWriter(w),
+		modTime:  time.UnixMilli(manifest.CreatedAt),
+		sequence: make(map[string]int),
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := sw.writeEntry(snapshotManifestName, data); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// Add appends a document of collection to the archive
+func (sw *snapshotWriter) Add(collection string, doc json.RawMessage) error {
+	if collection != sw.collection {
+		if err := sw.flush(); err != nil {
+			return err
+		}
+		sw.collection = collection
+	}
+
+	sw.chunk.Write(doc)
+	sw.chunk.WriteByte('\n')
+	if sw.chunk.Len() >= snapshotChunkSize {
+		return sw.flush()
+	}
+	return nil
+}
+
+// Close writes any buffered documents and ends the archive
+func (sw *snapshotWriter) Close() error {
+	if err := sw.flush(); err != nil {
+		return err
+	}
+	return sw.tw.Close()
+}
+
+// flush writes the buffered documents as the collection's next entry
+func (sw *snapshotWriter) flush() error {
+	if sw.chunk.Len() == 0 {
+		return nil
+	}
+	sw.sequence[sw.collection]++
+	name := fmt.Sprintf("%s/%06d.ndjson", sw.collection, sw.sequence[sw.collection])
+	if err := sw.writeEntry(name, sw.chunk.Bytes()); err != nil {
+		return err
+	}
+	sw.chunk.Reset()
+	return nil
+}
+
+func (sw *snapshotWriter) writeEntry(name string, data []byte) error {
+	err := sw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     int64(len(data)),
+		ModTime:  sw.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = sw.tw.Write(data)
+	return err
+}
+
+// readSnapshot reads a snapshot archive, uncompressed or in a codec detected
+// from its framing, calling visit with batches of up to batchSize documents
+// of each collection in archive order. Malformed archives fail with a
+// validation error.
+func readSnapshot(rd io.Reader, batchSize int, visit func(collection string, docs []json.RawMessage) error) (*api.SnapshotManifest, error) {
+	decoded, err := decodeStream(rd)
+	if err != nil {
+		return nil, errors.ValidationError(fmt.Sprintf("invalid snapshot: %v", err))
+	}
+	defer decoded.Close()
+	tr := tar.NewReader(decoded)
+
+	header, err := tr.Next()
+	if err != nil {
+		return nil, errors.ValidationError(fmt.Sprintf("invalid snapshot: %v", err))
+	}
+	if header.Name != snapshotManifestName {
+		return nil, errors.ValidationError(fmt.Sprintf("invalid snapshot: expected %s first, got %s", snapshotManifestName, header.Name))
+	}
+	var manifest api.SnapshotManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, errors.ValidationError(fmt.Sprintf("invalid snapshot manifest: %v", err))
+	}
+	if manifest.Format != snapshotFormat {
+		return nil, errors.ValidationError(fmt.Sprintf("invalid snapshot: unknown format %q", manifest.Format))
+	}
+	if manifest.Version != snapshotVersion {
+		return nil, errors.ValidationError(fmt.Sprintf("unsupported snapshot version %d", manifest.Version))
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return &manifest, nil
+		}
+		if err != nil {
+			return nil, errors.ValidationError(fmt.Sprintf("invalid snapshot: %v", err))
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		collection := path.Dir(header.Name)
+		if !isSnapshotCollection(collection) || !strings.HasSuffix(header.Name, ".ndjson") {
+			return nil, errors.ValidationError(fmt.Sprintf("invalid snapshot: unexpected entry %s", header.Name))
+		}
+		if err := readSnapshotEntry(tr, header.Name, collection, batchSize, visit); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readSnapshotEntry reads the NDJSON documents of one archive entry
+func readSnapshotEntry(rd io.Reader, name, collection string, batchSize int, visit func(collection string, docs []json.RawMessage) error) error {
+	decoder := json.NewDecoder(rd)
+	batch := make([]json.RawMessage, 0, batchSize)
+	for {
+		var doc json.RawMessage
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.ValidationError(fmt.Sprintf("invalid snapshot entry %s: %v", name, err))
+		}
+
+		// Documents are upserted by key, so one without would be inserted
+		// again by every restore
+		var key struct {
+			Key string `json:"_key"`
+		}
+		if err := json.Unmarshal(doc, &key); err != nil || key.Key == "" {
+			return errors.ValidationError(fmt.Sprintf("invalid snapshot entry %s: document without a _key", name))
+		}
+
+		batch = append(batch, doc)
+		if len(batch) == batchSize {
+			if err := visit(collection, batch); err != nil {
+				return err
+			}
+			batch = make([]json.RawMessage, 0, batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		return visit(collection, batch)
+	}
+	return nil
+}
+
+// isSnapshotCollection reports whether a snapshot may restore a collection
+func isSnapshotCollection(collection string) bool {
+	for _, c := range snapshotCollections {
+		if c == collection {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package spatial
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	sw, err := newSnapshotWriter(&buf, &api.SnapshotManifest{
+		Format: snapshotFormat, Version: snapshotVersion, CreatedAt: 1700000000000, SessionID: "s1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to start snapshot: %v", err)
+	}
+	docs := []struct {
+		collection string
+		doc        string
+	}{
+		{database.AnchorsCollection, `{"_key":"a1","id":"a1"}`},
+		{database.AnchorsCollection, `{"_key":"a2","id":"a2"}`},
+		{database.AnchorsCollection, `{"_key":"a3","id":"a3"}`},
+		{database.TopologyEdges, `{"_key":"e1","_from":"anchors/a1","_to":"anchors/a2"}`},
+	}
+	for _, d := range docs {
+		if err := sw.Add(d.collection, json.RawMessage(d.doc)); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Failed to close snapshot: %v", err)
+	}
+
+	var batches []string
+	var read []string
+	manifest, err := readSnapshot(&buf, 2, func(collection string, batch []json.RawMessage) error {
+		batches = append(batches, collection)
+		for _, doc := range batch {
+			read = append(read, string(doc))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	if manifest.SessionID != "s1" || manifest.CreatedAt != 1700000000000 {
+		t.Errorf("Expected the manifest read back, got %+v", manifest)
+	}
+	want := []string{database.AnchorsCollection, database.AnchorsCollection, database.TopologyEdges}
+	if strings.Join(batches, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected batches %v, got %v", want, batches)
+	}
+	if len(read) != len(docs) {
+		t.Fatalf("Expected %d documents, got %d", len(docs), len(read))
+	}
+	for i, d := range docs {
+		if read[i] != d.doc {
+			t.Errorf("Expected document %s, got %s", d.doc, read[i])
+		}
+	}
+}
+
+func TestReadCompressedSnapshot(t *testing.T) {
+	for _, name := range []string{CodecGzip, CodecZstd} {
+		var buf bytes.Buffer
+		encoder, err := NewCodecWriter(name, &buf, 5)
+		if err != nil {
+			t.Fatalf("Failed to create %s writer: %v", name, err)
+		}
+		sw, err := newSnapshotWriter(encoder, &api.SnapshotManifest{Format: snapshotFormat, Version: snapshotVersion})
+		if err != nil {
+			t.Fatalf("Failed to start snapshot: %v", err)
+		}
+		sw.Add(database.MeshesCollection, json.RawMessage(`{"_key":"m1"}`))
+		sw.Close()
+		encoder.Close()
+
+		read := 0
+		_, err = readSnapshot(&buf, 10, func(collection string, docs []json.RawMessage) error {
+			read += len(docs)
+			return nil
+		})
+		if err != nil || read != 1 {
+			t.Errorf("Expected the %s snapshot read, got %d documents, %v", name, read, err)
+		}
+	}
+}
+
+func TestReadSnapshotRejectsMalformed(t *testing.T) {
+	manifest := `{"format":"stag-snapshot","version":1,"created_at":1}`
+	tests := []struct {
+		name    string
+		entries [][2]string
+	}{
+		{"no manifest", [][2]string{{"anchors/000001.ndjson", `{"_key":"a1"}`}}},
+		{"unknown format", [][2]string{{snapshotManifestName, `{"format":"other","version":1}`}}},
+		{"newer version", [][2]string{{snapshotManifestName, `{"format":"stag-snapshot","version":2}`}}},
+		{"unknown collection", [][2]string{{snapshotManifestName, manifest}, {"users/000001.ndjson", `{"_key":"u1"}`}}},
+		{"document without key", [][2]string{{snapshotManifestName, manifest}, {"anchors/000001.ndjson", `{"id":"a1"}`}}},
+		{"invalid document", [][2]string{{snapshotManifestName, manifest}, {"anchors/000001.ndjson", `{"_key":`}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gz)
+			for _, entry := range tt.entries {
+				tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: entry[0], Mode: 0o644, Size: int64(len(entry[1]))})
+				tw.Write([]byte(entry[1]))
+			}
+			tw.Close()
+			gz.Close()
+
+			_, err := readSnapshot(&buf, 10, func(string, []json.RawMessage) error { return nil })
+			if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected a validation error, got %v", err)
+			}
+		})
+	}
+
+	if _, err := readSnapshot(strings.NewReader("not a tar"), 10, nil); err == nil {
+		t.Error("Expected an error for data that is not a tar archive")
+	}
+}
+
+func TestSnapshotQuerySession(t *testing.T) {
+	query, bindVars := snapshotQuery("", database.MeshesCollection, "RETURN doc")
+	if strings.Contains(query, "FILTER") || len(bindVars) != 1 {
+		t.Errorf("Expected a full snapshot unfiltered, got %q %v", query, bindVars)
+	}
+
+	query, bindVars = snapshotQuery("s1", database.MeshesCollection, "RETURN doc")
+	if !strings.Contains(query, "referenced_by") || bindVars["session_id"] != "s1" {
+		t.Errorf("Expected the session's and referenced meshes, got %q %v", query, bindVars)
+	}
+
+	query, bindVars = snapshotQuery("s1", database.TopologyEdges, "RETURN doc")
+	if !strings.Contains(query, "session_anchors") || bindVars["@anchors"] != database.AnchorsCollection {
+		t.Errorf("Expected edges selected by the session's anchors, got %q %v", query, bindVars)
+	}
+}
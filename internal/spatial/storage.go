@@ -0,0 +1,236 @@
+package spatial
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+// storageStatsTTL is how long a storage breakdown is served from cache, since
+// gathering collection figures is expensive
+const storageStatsTTL = 30 * time.Second
+
+// storageCollections are the collections a storage breakdown covers
+var storageCollections = []string{
+	database.AnchorsCollection,
+	database.AnchorPosesCollection,
+	database.MeshesCollection,
+	database.TopologyEdges,
+}
+
+// storageStatsCache holds the last storage breakdown
+type storageStatsCache struct {
+	mu      sync.Mutex // Held while computing, so concurrent requests share one result
+	stats   *api.StorageStats
+	expires time.Time
+}
+
+// sessionDocuments is a session's document count and geometry bytes in one
+// collection
+type sessionDocuments struct {
+	SessionID string `json:"session_id"`
+	Documents int64  `json:"documents"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// StorageStats breaks stored data down by collection, from ArangoDB's
+// collection figures, and by session. The result is cached for
+// storageStatsTTL and refreshes the storage size gauges.
+func (r *Repository) StorageStats(ctx context.Context) (*api.StorageStats, error) {
+	r.storageStats.mu.Lock()
+	defer r.storageStats.mu.Unlock()
+
+	if r.storageStats.stats != nil && time.Now().Before(r.storageStats.expires) {
+		return r.storageStats.stats, nil
+	}
+
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("query", "storage_stats").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	stats, err := r.computeStorageStats(ctx)
+	if err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("query", "storage_stats", "error").Inc()
+		return nil, err
+	}
+	r.metrics.DBOperationsTotal.WithLabelValues("query", "storage_stats", "success").Inc()
+
+	r.storageStats.stats = stats
+	r.storageStats.expires = time.Now().Add(storageStatsTTL)
+	return stats, nil
+}
+
+func (r *Repository) computeStorageStats(ctx context.Context) (*api.StorageStats, error) {
+	stats := &api.StorageStats{
+		Collections: make([]api.CollectionStorage, 0, len(storageCollections)),
+		GeneratedAt: time.Now().UnixMilli(),
+	}
+	collections := make(map[string]api.CollectionStorage, len(storageCollections))
+	for _, name := range storageCollections {
+		collection, err := r.collectionStorage(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		stats.Collections = append(stats.Collections, *collection)
+		collections[name] = *collection
+	}
+
+	anchors, err := r.sessionDocuments(ctx, database.AnchorsCollection, false)
+	if err != nil {
+		return nil, err
+	}
+	poses, err := r.sessionDocuments(ctx, database.AnchorPosesCollection, false)
+	if err != nil {
+		return nil, err
+	}
+	meshes, err := r.sessionDocuments(ctx, database.MeshesCollection, true)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Sessions = apportionStorage(collections, anchors, poses, meshes)
+	stats.TotalSessions = len(stats.Sessions)
+
+	// Mesh bytes are tracked as stored geometry, see GetMetrics
+	var disk int64
+	for _, collection := range stats.Collections {
+		if collection.Name != database.MeshesCollection {
+			r.metrics.StorageSizeBytes.WithLabelValues(collection.Name).Set(float64(collection.LogicalBytes))
+		}
+		disk += collection.EstimatedDisk
+	}
+	r.metrics.StorageSizeBytes.WithLabelValues("disk").Set(float64(disk))
+
+	return stats, nil
+}
+
+// collectionStorage reads a collection's document count and sizes from its
+// ArangoDB figures
+func (r *Repository) collectionStorage(ctx context.Context, name string) (*api.CollectionStorage, error) {
+	col, err := r.db.Database().Collection(ctx, name)
+	if err != nil {
+		return nil, databaseError("failed to get collection", err)
+	}
+
+	figures, err := col.Statistics(ctx)
+	if err != nil {
+		return nil, databaseError("failed to get collection figures", err)
+	}
+
+	collection := &api.CollectionStorage{
+		Name:       name,
+		Documents:  figures.Count,
+		IndexBytes: figures.Figures.Indexes.Size,
+	}
+	if figures.Figures.DocumentsSize != nil {
+		collection.LogicalBytes = *figures.Figures.DocumentsSize
+	}
+	collection.EstimatedDisk = collection.LogicalBytes + collection.IndexBytes
+	return collection, nil
+}
+
+// sessionDocuments counts a collection's documents per session, summing the
+// stored geometry of meshes when geometry is set. Buffers are stored base64
+// encoded, so decoded sizes are derived from string lengths.
+func (r *Repository) sessionDocuments(ctx context.Context, collection string, geometry bool) ([]sessionDocuments, error) {
+	query := `
+		FOR d IN @@collection
+		FILTER d.session_id != null
+		COLLECT session_id = d.session_id
+		AGGREGATE documents = COUNT(1),
+			bytes = SUM(@geometry ? FLOOR((LENGTH(d.vertices) + LENGTH(d.faces) + LENGTH(d.normals)) * 3 / 4) : 0)
+		RETURN { session_id, documents, bytes }
+	`
+	cursor, err := r.runQuery(ctx, query, map[string]interface{}{
+		"@collection": collection,
+		"geometry":    geometry,
+	})
+	if err != nil {
+		return nil, databaseError("failed to aggregate session storage", err)
+	}
+	defer cursor.Close()
+
+	var sessions []sessionDocuments
+	for {
+		var session sessionDocuments
+		if _, err := cursor.ReadDocument(ctx, &session); driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, databaseError("failed to read session storage", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// apportionStorage builds per-session storage, splitting each collection's
+// bytes between sessions by their share of its documents, or of its geometry
+// for meshes. Sessions are sorted largest on disk first.
+func apportionStorage(collections map[string]api.CollectionStorage, anchors, poses, meshes []sessionDocuments) []api.SessionStorage {
+	byID := make(map[string]*api.SessionStorage)
+	session := func(sessionID string) *api.SessionStorage {
+		if s, ok := byID[sessionID]; ok {
+			return s
+		}
+		s := &api.SessionStorage{SessionID: sessionID}
+		byID[sessionID] = s
+		return s
+	}
+
+	// share adds a session's part of a collection's bytes
+	share := func(s *api.SessionStorage, collection string, part, whole int64) {
+		if whole <= 0 {
+			return
+		}
+		fraction := float64(part) / float64(whole)
+		s.LogicalBytes += int64(float64(collections[collection].LogicalBytes) * fraction)
+		s.EstimatedDisk += int64(float64(collections[collection].EstimatedDisk) * fraction)
+	}
+
+	for _, counts := range []struct {
+		collection string
+		documents  []sessionDocuments
+		record     func(s *api.SessionStorage, d sessionDocuments)
+	}{
+		{database.AnchorsCollection, anchors, func(s *api.SessionStorage, d sessionDocuments) { s.Anchors = d.Documents }},
+		{database.AnchorPosesCollection, poses, func(s *api.SessionStorage, d sessionDocuments) { s.PoseSamples = d.Documents }},
+		{database.MeshesCollection, meshes, func(s *api.SessionStorage, d sessionDocuments) {
+			s.Meshes, s.GeometryBytes = d.Documents, d.Bytes
+		}},
+	} {
+		var documents, bytes int64
+		for _, d := range counts.documents {
+			documents += d.Documents
+			bytes += d.Bytes
+		}
+		for _, d := range counts.documents {
+			s := session(d.SessionID)
+			counts.record(s, d)
+			if bytes > 0 {
+				share(s, counts.collection, d.Bytes, bytes)
+			} else {
+				share(s, counts.collection, d.Documents, documents)
+			}
+		}
+	}
+
+	sessions := make([]api.SessionStorage, 0, len(byID))
+	for _, s := range byID {
+		sessions = append(sessions, *s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].EstimatedDisk != sessions[j].EstimatedDisk {
+			return sessions[i].EstimatedDisk > sessions[j].EstimatedDisk
+		}
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+	return sessions
+}
@@ -0,0 +1,39 @@
+package spatial
+
+import (
+	"testing"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func TestApportionStorage(t *testing.T) {
+	collections := map[string]api.CollectionStorage{
+		database.AnchorsCollection:     {LogicalBytes: 1000, EstimatedDisk: 1200},
+		database.AnchorPosesCollection: {LogicalBytes: 400, EstimatedDisk: 500},
+		database.MeshesCollection:      {LogicalBytes: 9000, EstimatedDisk: 10000},
+	}
+	anchors := []sessionDocuments{{SessionID: "small", Documents: 3}, {SessionID: "large", Documents: 1}}
+	poses := []sessionDocuments{{SessionID: "small", Documents: 4}}
+	meshes := []sessionDocuments{{SessionID: "small", Documents: 5, Bytes: 100}, {SessionID: "large", Documents: 1, Bytes: 900}}
+
+	sessions := apportionStorage(collections, anchors, poses, meshes)
+	if len(sessions) != 2 || sessions[0].SessionID != "large" {
+		t.Fatalf("Expected the session with the most geometry first, got %+v", sessions)
+	}
+
+	// Anchors split by count, meshes by geometry
+	large, small := sessions[0], sessions[1]
+	if large.Anchors != 1 || large.Meshes != 1 || large.GeometryBytes != 900 || large.PoseSamples != 0 {
+		t.Errorf("Unexpected counts for large: %+v", large)
+	}
+	if want := int64(1200/4 + 10000*9/10); large.EstimatedDisk != want {
+		t.Errorf("Expected large to use %d bytes on disk, got %d", want, large.EstimatedDisk)
+	}
+	if want := int64(1000*3/4 + 400 + 9000/10); small.LogicalBytes != want {
+		t.Errorf("Expected small to hold %d logical bytes, got %d", want, small.LogicalBytes)
+	}
+	if small.PoseSamples != 4 {
+		t.Errorf("Expected 4 pose samples for small, got %d", small.PoseSamples)
+	}
+}
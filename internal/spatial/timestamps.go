@@ -0,0 +1,173 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// backfillKey marks a context whose events skip timestamp validation
+type backfillKey struct{}
+
+// WithBackfill marks ctx as carrying a backfill import, whose events may be
+// older than their session's latest and are not validated against the clock
+func WithBackfill(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backfillKey{}, true)
+}
+
+// isBackfill reports whether ctx was marked by WithBackfill
+func isBackfill(ctx context.Context) bool {
+	backfill, _ := ctx.Value(backfillKey{}).(bool)
+	return backfill
+}
+
+// sessionTimestamp is the latest accepted event timestamp of a session
+type sessionTimestamp struct {
+	timestamp int64 // Unix milliseconds
+	seenAt    time.Time
+}
+
+// timestampGuard rejects event timestamps from misconfigured device clocks:
+// those too far ahead of server time, and those older than the latest event
+// accepted for their session. The latest timestamps are held in memory by
+// each instance and forgotten once a session has been idle for ttl.
+type timestampGuard struct {
+	maxFutureSkew time.Duration // 0 disables the check
+	monotonic     bool
+	tolerance     time.Duration // How far behind the latest a timestamp may be
+	ttl           time.Duration // 0 keeps sessions until deleted
+
+	mu     sync.Mutex
+	latest map[string]sessionTimestamp
+}
+
+// newTimestampGuard creates a guard, or returns nil if both checks are disabled
+func newTimestampGuard(maxFutureSkew time.Duration, monotonic bool, tolerance, ttl time.Duration) *timestampGuard {
+	if maxFutureSkew <= 0 && !monotonic {
+		return nil
+	}
+	return &timestampGuard{
+		maxFutureSkew: maxFutureSkew,
+		monotonic:     monotonic,
+		tolerance:     tolerance,
+		ttl:           ttl,
+		latest:        make(map[string]sessionTimestamp),
+	}
+}
+
+// check validates events in order, returning an error for each rejected
+// event. Each event is held to the latest timestamp accepted for its session
+// and to the accepted events before it.
+func (g *timestampGuard) check(events []api.SpatialEvent, now time.Time) []error {
+	errs := make([]error, len(events))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	pending := make(map[string]int64)
+	for i := range events {
+		event := &events[i]
+		if g.maxFutureSkew > 0 {
+			if ahead := time.UnixMilli(event.Timestamp).Sub(now); ahead > g.maxFutureSkew {
+				errs[i] = errors.ValidationError(fmt.Sprintf("event %s timestamp %d is %s ahead of server time, beyond the allowed skew of %s",
+					event.EventID, event.Timestamp, ahead.Round(time.Millisecond), g.maxFutureSkew))
+				continue
+			}
+		}
+		if !g.monotonic {
+			continue
+		}
+
+		latest, ok := pending[event.SessionID]
+		if !ok {
+			entry, stored := g.latest[event.SessionID]
+			latest, ok = entry.timestamp, stored
+		}
+		if ok && event.Timestamp < latest-g.tolerance.Milliseconds() {
+			errs[i] = errors.ValidationError(fmt.Sprintf("event %s timestamp %d is older than %d, the latest accepted for session %s",
+				event.EventID, event.Timestamp, latest, event.SessionID))
+			continue
+		}
+		pending[event.SessionID] = max(latest, event.Timestamp)
+	}
+	return errs
+}
+
+// accept records the timestamps of stored events
+func (g *timestampGuard) accept(events []api.SpatialEvent, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i := range events {
+		entry := g.latest[events[i].SessionID]
+		entry.timestamp = max(entry.timestamp, events[i].Timestamp)
+		entry.seenAt = now
+		g.latest[events[i].SessionID] = entry
+	}
+}
+
+// forget drops a session's latest timestamp
+func (g *timestampGuard) forget(sessionID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.latest, sessionID)
+}
+
+// evictIdle forgets sessions with no event accepted since ttl before now
+func (g *timestampGuard) evictIdle(now time.Time) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	evicted := 0
+	for sessionID, entry := range g.latest {
+		if now.Sub(entry.seenAt) > g.ttl {
+			delete(g.latest, sessionID)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// checkEventTimestamps validates the timestamps of events about to be
+// stored, returning an error for each rejected event. Backfill requests and
+// repositories without timestamp validation accept every event.
+func (r *Repository) checkEventTimestamps(ctx context.Context, events []api.SpatialEvent) []error {
+	if r.timestamps == nil || isBackfill(ctx) {
+		return make([]error, len(events))
+	}
+	return r.timestamps.check(events, time.Now())
+}
+
+// acceptEventTimestamps records the timestamps of stored events. Backfilled
+// events do not move their session's latest timestamp.
+func (r *Repository) acceptEventTimestamps(ctx context.Context, events []api.SpatialEvent) {
+	if r.timestamps == nil || isBackfill(ctx) {
+		return
+	}
+	r.timestamps.accept(events, time.Now())
+}
+
+// runTimestampJanitor periodically forgets the latest timestamps of idle
+// sessions
+func (r *Repository) runTimestampJanitor(ttl time.Duration) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if evicted := r.timestamps.evictIdle(now); evicted > 0 {
+				r.logger.Debugf("Forgot the latest event timestamps of %d idle sessions", evicted)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
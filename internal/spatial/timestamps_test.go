@@ -0,0 +1,73 @@
+package spatial
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func TestTimestampGuard(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	guard := newTimestampGuard(time.Minute, true, time.Second, time.Hour)
+	event := func(id, session string, timestamp int64) api.SpatialEvent {
+		return api.SpatialEvent{EventID: id, SessionID: session, Timestamp: timestamp}
+	}
+	base := now.UnixMilli()
+
+	if newTimestampGuard(0, false, time.Second, time.Hour) != nil {
+		t.Error("Expected no guard with both checks disabled")
+	}
+
+	// Events are held to the accepted events before them
+	errs := guard.check([]api.SpatialEvent{
+		event("e1", "s1", base),
+		event("e2", "s1", base-500),  // Within tolerance
+		event("e3", "s1", base-2000), // Too old
+		event("e4", "s2", base-2000), // Another session
+		event("e5", "s1", base+2*time.Minute.Milliseconds()),
+	}, now)
+	for i, wantErr := range []bool{false, false, true, false, true} {
+		if (errs[i] != nil) != wantErr {
+			t.Errorf("Event %d: expected rejection %v, got %v", i+1, wantErr, errs[i])
+		}
+	}
+
+	// Only accepted timestamps move a session's latest
+	if errs := guard.check([]api.SpatialEvent{event("e6", "s1", base-60000)}, now); errs[0] != nil {
+		t.Errorf("Expected no latest timestamp before accept, got %v", errs[0])
+	}
+	guard.accept([]api.SpatialEvent{event("e1", "s1", base)}, now)
+	if errs := guard.check([]api.SpatialEvent{event("e7", "s1", base-2000)}, now); errs[0] == nil {
+		t.Error("Expected an event older than the accepted one to be rejected")
+	}
+
+	// Idle and deleted sessions are forgotten
+	guard.accept([]api.SpatialEvent{event("e4", "s2", base)}, now.Add(30*time.Minute))
+	if evicted := guard.evictIdle(now.Add(90 * time.Minute)); evicted != 1 {
+		t.Errorf("Expected 1 idle session evicted, got %d", evicted)
+	}
+	guard.forget("s2")
+	if len(guard.latest) != 0 {
+		t.Errorf("Expected no sessions left, got %v", guard.latest)
+	}
+}
+
+func TestCheckEventTimestampsBackfill(t *testing.T) {
+	repo := &Repository{timestamps: newTimestampGuard(time.Minute, false, 0, 0)}
+	future := []api.SpatialEvent{{EventID: "e1", SessionID: "s1", Timestamp: time.Now().Add(time.Hour).UnixMilli()}}
+
+	if errs := repo.checkEventTimestamps(context.Background(), future); errs[0] == nil {
+		t.Error("Expected a far-future event to be rejected")
+	}
+	if errs := repo.checkEventTimestamps(WithBackfill(context.Background()), future); errs[0] != nil {
+		t.Errorf("Expected backfill to skip validation, got %v", errs[0])
+	}
+
+	// Without a guard every event is accepted
+	repo.timestamps = nil
+	if errs := repo.checkEventTimestamps(context.Background(), future); len(errs) != 1 || errs[0] != nil {
+		t.Errorf("Expected no validation without a guard, got %v", errs)
+	}
+}
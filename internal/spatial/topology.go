@@ -0,0 +1,209 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/arangodb/go-driver"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// buildTopology links an anchor to every anchor of its session within the
+// neighbor distance, and drops edges to anchors it has moved away from.
+// Edges are stored with _from < _to so each pair has at most one edge.
+func (r *Repository) buildTopology(ctx context.Context, sessionID, anchorID string) error {
+	if r.neighborDistance <= 0 {
+		return nil
+	}
+
+	bindVars := map[string]interface{}{
+		"@anchors":     database.AnchorsCollection,
+		"@edges":       database.TopologyEdges,
+		"session_id":   sessionID,
+		"id":           anchorID,
+		"max_distance": r.neighborDistance,
+	}
+
+	// Remove edges that are now out of range
+	prune := `
+		LET src = FIRST(FOR a IN @@anchors FILTER a.session_id == @session_id AND a.id == @id RETURN a)
+		FOR e IN @@edges
+		FILTER src != null AND (e._from == src._id OR e._to == src._id)
+		LET other = DOCUMENT(e._from == src._id ? e._to : e._from)
+		FILTER other == null OR SQRT(POW(other.pose.x - src.pose.x, 2) + POW(other.pose.y - src.pose.y, 2) + POW(other.pose.z - src.pose.z, 2)) > @max_distance
+		REMOVE e IN @@edges
+	`
+
+	cursor, err := r.runQuery(ctx, prune, bindVars)
+	if err != nil {
+		return databaseError("failed to prune topology edges", err)
+	}
+	cursor.Close()
+
+	// Upsert an edge to every anchor in range
+	link := `
+		LET src = FIRST(FOR a IN @@anchors FILTER a.session_id == @session_id AND a.id == @id RETURN a)
+		FOR other IN @@anchors
+		FILTER src != null AND other.session_id == src.session_id AND other._id != src._id
+		FILTER ABS(other.pose.x - src.pose.x) <= @max_distance
+		FILTER ABS(other.pose.y - src.pose.y) <= @max_distance
+		LET distance = SQRT(POW(other.pose.x - src.pose.x, 2) + POW(other.pose.y - src.pose.y, 2) + POW(other.pose.z - src.pose.z, 2))
+		FILTER distance <= @max_distance
+		LET from = src._id < other._id ? src._id : other._id
+		LET to = src._id < other._id ? other._id : src._id
+		UPSERT { _from: from, _to: to }
+		INSERT { _from: from, _to: to, distance: distance, session_id: src.session_id }
+		UPDATE { distance: distance }
+		IN @@edges
+	`
+
+	cursor, err = r.runQuery(ctx, link, bindVars)
+	if err != nil {
+		return databaseError("failed to link topology edges", err)
+	}
+	cursor.Close()
+
+	return nil
+}
+
+// GetNeighbors returns anchors connected to an anchor of a session within
+// depth hops, nearest hops first
+func (r *Repository) GetNeighbors(ctx context.Context, sessionID, anchorID string, depth int) (*api.NeighborsResponse, error) {
+	if depth < 1 {
+		depth = 1
+	}
+	if r.maxHops > 0 && depth > r.maxHops {
+		depth = r.maxHops
+	}
+
+	query := `
+		FOR start IN @@anchors
+		FILTER start.session_id == @session_id AND start.id == @id
+		LIMIT 1
+		LET neighbors = (
+			FOR v, e, p IN 1..@depth ANY start._id GRAPH @graph
+			OPTIONS { uniqueVertices: "global", order: "bfs" }
+			RETURN {
+				anchor: v,
+				hops: LENGTH(p.edges),
+				distance: SQRT(POW(v.pose.x - start.pose.x, 2) + POW(v.pose.y - start.pose.y, 2) + POW(v.pose.z - start.pose.z, 2))
+			}
+		)
+		RETURN { anchor_id: start.id, neighbors: neighbors }
+	`
+
+	bindVars := map[string]interface{}{
+		"@anchors":   database.AnchorsCollection,
+		"graph":      database.TopologyGraph,
+		"session_id": sessionID,
+		"id":         anchorID,
+		"depth":      depth,
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to traverse topology", err)
+	}
+	defer cursor.Close()
+
+	var response api.NeighborsResponse
+	if _, err := cursor.ReadDocument(ctx, &response); driver.IsNoMoreDocuments(err) {
+		return nil, errors.NotFound(fmt.Sprintf("anchor %s not found", anchorID))
+	} else if err != nil {
+		return nil, databaseError("failed to read neighbors", err)
+	}
+
+	response.Count = len(response.Neighbors)
+	return &response, nil
+}
+
+// ShortestPath returns the path through the topology graph from one anchor of
+// a session to another with the least total edge distance. Anchors more than
+// maxPathDepth hops apart are reported as disconnected, and the weighted
+// search only runs once a bounded breadth-first traversal has reached the
+// target, so a large graph is never searched without limit.
+func (r *Repository) ShortestPath(ctx context.Context, sessionID, fromID, toID string) (*api.PathResponse, error) {
+	reachable := "src != null AND dst != null"
+	if r.maxPathDepth > 0 {
+		reachable += ` AND (src._id == dst._id OR LENGTH(
+			FOR v IN 1..@max_depth ANY src._id GRAPH @graph
+			OPTIONS { uniqueVertices: "global", order: "bfs" }
+			FILTER v._id == dst._id
+			LIMIT 1
+			RETURN 1
+		) > 0)`
+	}
+
+	query := `
+		LET src = FIRST(FOR a IN @@anchors FILTER a.session_id == @session_id AND a.id == @from RETURN a)
+		LET dst = FIRST(FOR a IN @@anchors FILTER a.session_id == @session_id AND a.id == @to RETURN a)
+		LET reachable = ` + reachable + `
+		LET path = (
+			FOR ok IN (reachable ? [true] : [])
+			FOR v, e IN ANY SHORTEST_PATH src._id TO dst._id GRAPH @graph
+			OPTIONS { weightAttribute: "distance", defaultWeight: 1 }
+			RETURN { anchor: v, distance: e == null ? 0 : e.distance }
+		)
+		RETURN {
+			from_found: src != null,
+			to_found: dst != null,
+			anchors: path[*].anchor,
+			distance: SUM(path[*].distance)
+		}
+	`
+
+	bindVars := map[string]interface{}{
+		"@anchors":   database.AnchorsCollection,
+		"graph":      database.TopologyGraph,
+		"session_id": sessionID,
+		"from":       fromID,
+		"to":         toID,
+	}
+	if r.maxPathDepth > 0 {
+		bindVars["max_depth"] = r.maxPathDepth
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, databaseError("failed to find topology path", err)
+	}
+	defer cursor.Close()
+
+	var result struct {
+		FromFound bool         `json:"from_found"`
+		ToFound   bool         `json:"to_found"`
+		Anchors   []api.Anchor `json:"anchors"`
+		Distance  float64      `json:"distance"`
+	}
+	if _, err := cursor.ReadDocument(ctx, &result); err != nil {
+		return nil, databaseError("failed to read topology path", err)
+	}
+
+	switch {
+	case !result.FromFound:
+		return nil, errors.NotFound(fmt.Sprintf("anchor %s not found", fromID))
+	case !result.ToFound:
+		return nil, errors.NotFound(fmt.Sprintf("anchor %s not found", toID))
+	}
+
+	return newPathResponse(fromID, toID, result.Anchors, result.Distance, r.maxPathDepth), nil
+}
+
+// newPathResponse reports a path found between two anchors. The weighted
+// search may prefer a path of more hops than the depth limit allows even when
+// a shorter one in hops exists, and such a path is reported as disconnected.
+func newPathResponse(fromID, toID string, anchors []api.Anchor, distance float64, maxDepth int) *api.PathResponse {
+	response := &api.PathResponse{From: fromID, To: toID, Anchors: []api.Anchor{}}
+	if len(anchors) == 0 || (maxDepth > 0 && len(anchors)-1 > maxDepth) {
+		return response
+	}
+
+	response.Connected = true
+	response.Anchors = anchors
+	response.Hops = len(anchors) - 1
+	response.Distance = distance
+	return response
+}
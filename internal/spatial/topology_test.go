@@ -0,0 +1,39 @@
+package spatial
+
+import (
+	"testing"
+
+	"github.com/tabular/stag-v2/pkg/api"
+)
+
+func TestNewPathResponse(t *testing.T) {
+	path := []api.Anchor{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+
+	response := newPathResponse("a", "c", path, 2.5, 5)
+	if !response.Connected || response.Hops != 2 || response.Distance != 2.5 || len(response.Anchors) != 3 {
+		t.Errorf("Expected a connected path of 2 hops and 2.5m, got %+v", response)
+	}
+
+	// The same anchor is its own path
+	response = newPathResponse("a", "a", path[:1], 0, 5)
+	if !response.Connected || response.Hops != 0 || len(response.Anchors) != 1 {
+		t.Errorf("Expected a path of no hops, got %+v", response)
+	}
+
+	response = newPathResponse("a", "c", nil, 0, 5)
+	if response.Connected || response.Anchors == nil || len(response.Anchors) != 0 {
+		t.Errorf("Expected an empty disconnected path, got %+v", response)
+	}
+
+	// A weighted path longer than the depth limit is reported as disconnected
+	response = newPathResponse("a", "c", path, 2.5, 1)
+	if response.Connected || len(response.Anchors) != 0 || response.Distance != 0 {
+		t.Errorf("Expected a path over the depth limit to be disconnected, got %+v", response)
+	}
+
+	// 0 leaves the depth unlimited
+	response = newPathResponse("a", "c", path, 2.5, 0)
+	if !response.Connected || response.Hops != 2 {
+		t.Errorf("Expected an unlimited depth to keep the path, got %+v", response)
+	}
+}
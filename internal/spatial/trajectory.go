@@ -0,0 +1,192 @@
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tabular/stag-v2/internal/database"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Anchors hold only their latest pose, so every pose written is also recorded
+// in the anchor_poses collection, one document per anchor and timestamp. The
+// idx_pose_anchor_timestamp index finds the samples around any time.
+
+// poseSample is one entry of an anchor's pose history
+type poseSample struct {
+	AnchorID  string   `json:"anchor_id"`
+	SessionID string   `json:"session_id"`
+	Pose      api.Pose `json:"pose"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+// recordPose adds an anchor's current pose to its history, pruning samples
+// beyond the pose history limits. Samples are keyed by session, anchor and
+// timestamp, so rewriting a sample replaces it.
+func (r *Repository) recordPose(ctx context.Context, anchor *api.Anchor) error {
+	query := `
+		UPSERT { session_id: @sample.session_id, anchor_id: @sample.anchor_id, timestamp: @sample.timestamp }
+		INSERT @sample
+		REPLACE @sample
+		IN @@collection
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.AnchorPosesCollection,
+		"sample": poseSample{
+			AnchorID:  anchor.ID,
+			SessionID: anchor.SessionID,
+			Pose:      anchor.Pose,
+			Timestamp: anchor.Timestamp,
+		},
+	}
+
+	cursor, err := r.runQuery(ctx, query, bindVars)
+	if err != nil {
+		return databaseError(fmt.Sprintf("failed to record pose of anchor %s", anchor.ID), err)
+	}
+	cursor.Close()
+	return r.prunePoses(ctx, anchor.SessionID, anchor.ID)
+}
+
+// PoseAt interpolates the pose of an anchor of a session at a time from the
+// history samples on either side of it. Times outside the history return the
+// nearest sample when clamp is set and NotFound otherwise.
+func (r *Repository) PoseAt(ctx context.Context, sessionID, anchorID string, at int64, clamp bool) (*api.PoseAtTime, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DBOperationDuration.WithLabelValues("query", "anchor_poses").
+			Observe(time.Since(startTime).Seconds())
+	}()
+
+	query := `
+		LET prev = FIRST(
+			FOR p IN @@collection
+			FILTER p.anchor_id == @id AND p.timestamp <= @at AND p.session_id == @session_id
+			SORT p.timestamp DESC
+			LIMIT 1
+			RETURN p
+		)
+		LET next = FIRST(
+			FOR p IN @@collection
+			FILTER p.anchor_id == @id AND p.timestamp >= @at AND p.session_id == @session_id
+			SORT p.timestamp ASC
+			LIMIT 1
+			RETURN p
+		)
+		RETURN { prev, next }
+	`
+	bindVars := map[string]interface{}{
+		"@collection": database.AnchorPosesCollection,
+		"session_id":  sessionID,
+		"id":          anchorID,
+		"at":          at,
+	}
+
+	var samples struct {
+		Prev *poseSample `json:"prev"`
+		Next *poseSample `json:"next"`
+	}
+	if err := r.readSingle(ctx, query, bindVars, &samples); err != nil {
+		r.metrics.DBOperationsTotal.WithLabelValues("query", "anchor_poses", "error").Inc()
+		return nil, databaseError("failed to query pose history", err)
+	}
+	r.metrics.DBOperationsTotal.WithLabelValues("query", "anchor_poses", "success").Inc()
+
+	return poseBetween(anchorID, at, samples.Prev, samples.Next, clamp)
+}
+
+// poseBetween builds the pose at a time from the samples around it, either of
+// which may be missing when the time is outside the history
+func poseBetween(anchorID string, at int64, prev, next *poseSample, clamp bool) (*api.PoseAtTime, error) {
+	if prev == nil && next == nil {
+		return nil, errors.NotFound(fmt.Sprintf("anchor %s has no pose history", anchorID))
+	}
+
+	if prev == nil || next == nil {
+		if !clamp {
+			return nil, errors.NotFound(fmt.Sprintf("time %d is outside the pose history of anchor %s", at, anchorID))
+		}
+		nearest := prev
+		if nearest == nil {
+			nearest = next
+		}
+		return &api.PoseAtTime{
+			AnchorID:   anchorID,
+			SessionID:  nearest.SessionID,
+			Timestamp:  at,
+			Pose:       nearest.Pose,
+			PrevSample: nearest.Timestamp,
+			NextSample: nearest.Timestamp,
+			Clamped:    true,
+		}, nil
+	}
+
+	t := 0.0
+	if next.Timestamp > prev.Timestamp {
+		t = float64(at-prev.Timestamp) / float64(next.Timestamp-prev.Timestamp)
+	}
+
+	return &api.PoseAtTime{
+		AnchorID:   anchorID,
+		SessionID:  next.SessionID,
+		Timestamp:  at,
+		Pose:       interpolatePose(prev.Pose, next.Pose, t),
+		PrevSample: prev.Timestamp,
+		NextSample: next.Timestamp,
+	}, nil
+}
+
+// interpolatePose blends two poses, linearly for translation and by
+// spherical linear interpolation for rotation, with t from 0 (a) to 1 (b)
+func interpolatePose(a, b api.Pose, t float64) api.Pose {
+	return api.Pose{
+		X:        a.X + (b.X-a.X)*t,
+		Y:        a.Y + (b.Y-a.Y)*t,
+		Z:        a.Z + (b.Z-a.Z)*t,
+		Rotation: slerp(a.Rotation, b.Rotation, t),
+	}
+}
+
+// slerp interpolates between unit quaternions along the shorter arc
+func slerp(a, b []float64, t float64) []float64 {
+	if len(a) != 4 || len(b) != 4 {
+		// Samples predating rotation validation may be malformed
+		if t < 0.5 {
+			return a
+		}
+		return b
+	}
+
+	dot := a[0]*b[0] + a[1]*b[1] + a[2]*b[2] + a[3]*b[3]
+
+	// q and -q are the same rotation; flip b to take the shorter path
+	sign := 1.0
+	if dot < 0 {
+		sign, dot = -1, -dot
+	}
+
+	// Nearly parallel quaternions divide by a vanishing sine, so blend linearly
+	wa, wb := 1-t, t
+	if dot < 0.9995 {
+		theta := math.Acos(dot)
+		sinTheta := math.Sin(theta)
+		wa = math.Sin((1-t)*theta) / sinTheta
+		wb = math.Sin(t*theta) / sinTheta
+	}
+
+	result := make([]float64, 4)
+	var norm float64
+	for i := range result {
+		result[i] = wa*a[i] + sign*wb*b[i]
+		norm += result[i] * result[i]
+	}
+
+	norm = math.Sqrt(norm)
+	for i := range result {
+		result[i] /= norm
+	}
+	return result
+}
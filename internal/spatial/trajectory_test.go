@@ -0,0 +1,93 @@
+package spatial
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+func TestSlerp(t *testing.T) {
+	identity := []float64{0, 0, 0, 1}
+	halfTurnZ := []float64{0, 0, 1, 0} // 180 degrees about Z
+
+	// Halfway to a 180 degree turn is a 90 degree turn
+	got := slerp(identity, halfTurnZ, 0.5)
+	want := []float64{0, 0, math.Sqrt2 / 2, math.Sqrt2 / 2}
+	assertQuaternion(t, got, want)
+
+	// The endpoints are returned unchanged
+	assertQuaternion(t, slerp(identity, halfTurnZ, 0), identity)
+	assertQuaternion(t, slerp(identity, halfTurnZ, 1), halfTurnZ)
+
+	// A negated quaternion is the same rotation, so the path stays short
+	assertQuaternion(t, slerp(identity, []float64{0, 0, 0, -1}, 0.5), identity)
+}
+
+func TestPoseBetween(t *testing.T) {
+	prev := &poseSample{SessionID: "s", Timestamp: 1000, Pose: api.Pose{X: 0, Y: 2, Rotation: []float64{0, 0, 0, 1}}}
+	next := &poseSample{SessionID: "s", Timestamp: 2000, Pose: api.Pose{X: 10, Y: 2, Rotation: []float64{0, 0, 0, 1}}}
+
+	pose, err := poseBetween("a", 1250, prev, next, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pose.Pose.X != 2.5 || pose.Pose.Y != 2 || pose.PrevSample != 1000 || pose.NextSample != 2000 || pose.Clamped {
+		t.Errorf("Unexpected interpolated pose: %+v", pose)
+	}
+
+	// An exact sample matches on both sides
+	if pose, err := poseBetween("a", 1000, prev, prev, false); err != nil || pose.Pose.X != 0 {
+		t.Errorf("Expected the sample's pose, got %+v, %v", pose, err)
+	}
+
+	if pose, err := poseBetween("a", 3000, next, nil, true); err != nil || !pose.Clamped || pose.Pose.X != 10 {
+		t.Errorf("Expected the last sample clamped, got %+v, %v", pose, err)
+	}
+
+	for name, samples := range map[string][2]*poseSample{
+		"AfterLast":   {next, nil},
+		"BeforeFirst": {nil, prev},
+		"NoHistory":   {nil, nil},
+	} {
+		_, err := poseBetween("a", 0, samples[0], samples[1], false)
+		if apiErr, ok := errors.IsAPIError(err); !ok || apiErr.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected not found, got %v", name, err)
+		}
+	}
+}
+
+func assertQuaternion(t *testing.T, got, want []float64) {
+	t.Helper()
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Errorf("Expected quaternion %v, got %v", want, got)
+			return
+		}
+	}
+}
+
+func TestPoseHistoryLimits(t *testing.T) {
+	limits := newPoseHistoryLimits(config.PoseHistoryConfig{MaxSamples: 100, FullResolution: 10 * time.Minute, Interval: 5 * time.Second})
+	if limits.maxSamples != 100 || limits.fullResolution != 600000 || limits.interval != 5000 || !limits.enabled() {
+		t.Errorf("Expected limits in milliseconds, got %+v", limits)
+	}
+
+	if !newPoseHistoryLimits(config.PoseHistoryConfig{MaxSamples: 10}).enabled() {
+		t.Error("Expected a sample cap alone to prune")
+	}
+
+	// Full resolution alone keeps everything, so pruning never queries
+	repo := &Repository{poseHistory: newPoseHistoryLimits(config.PoseHistoryConfig{FullResolution: time.Minute})}
+	if repo.poseHistory.enabled() {
+		t.Error("Expected full resolution without an interval to keep every sample")
+	}
+	if err := repo.prunePoses(context.Background(), "s", "a1"); err != nil {
+		t.Errorf("Expected nothing pruned, got %v", err)
+	}
+}
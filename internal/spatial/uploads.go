@@ -0,0 +1,365 @@
+package spatial
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// Meshes too large to send in one request are uploaded in numbered chunks,
+// which may arrive in any order and be resent, then assembled into the JSON
+// body of one ingest mesh. Chunks are held in memory by the instance that
+// started the upload, so every request of an upload must reach it.
+
+// meshUpload is an unfinished chunked upload
+type meshUpload struct {
+	sessionID string
+	size      int64  // Declared bytes of the assembled mesh
+	sha256    string // Declared hex digest, "" if none
+	chunks    map[int][]byte
+	received  int64
+	touched   time.Time // Start or latest chunk, from which the upload expires
+
+	// Set while the upload is being stored, when chunks are refused
+	completing bool
+}
+
+// meshUploads holds the unfinished uploads of an instance
+type meshUploads struct {
+	ttl      time.Duration
+	maxSize  int64
+	maxBytes int64
+
+	mu      sync.Mutex
+	uploads map[string]*meshUpload
+	bytes   int64 // Chunk bytes held across uploads
+}
+
+// newMeshUploads creates an empty upload store
+func newMeshUploads(cfg config.UploadConfig) *meshUploads {
+	return &meshUploads{
+		ttl:      cfg.TTL,
+		maxSize:  cfg.MaxSize,
+		maxBytes: cfg.MaxBytes,
+		uploads:  make(map[string]*meshUpload),
+	}
+}
+
+// start registers a new upload
+func (s *meshUploads) start(req *api.MeshUploadRequest, now time.Time) (*api.MeshUploadStatus, error) {
+	if req.Size > s.maxSize {
+		return nil, errors.PayloadTooLarge(fmt.Sprintf("mesh upload of %d bytes exceeds the limit of %d", req.Size, s.maxSize))
+	}
+
+	id := uuid.NewString()
+	upload := &meshUpload{
+		sessionID: req.SessionID,
+		size:      req.Size,
+		sha256:    strings.ToLower(req.SHA256),
+		chunks:    make(map[int][]byte),
+		touched:   now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[id] = upload
+	return s.status(id, upload), nil
+}
+
+// get returns an upload, treating one past its TTL as gone. Callers must
+// hold s.mu.
+func (s *meshUploads) get(id string, now time.Time) (*meshUpload, error) {
+	upload, ok := s.uploads[id]
+	if !ok || (!upload.completing && now.Sub(upload.touched) > s.ttl) {
+		return nil, errors.NotFound(fmt.Sprintf("mesh upload %s not found", id))
+	}
+	return upload, nil
+}
+
+// put stores chunk n of an upload. Resending a chunk with the same bytes
+// succeeds without change; different bytes conflict with the first.
+func (s *meshUploads) put(id string, n int, data []byte, now time.Time) (*api.MeshUploadStatus, error) {
+	if n < 0 {
+		return nil, errors.BadRequest("chunk number must not be negative")
+	}
+	if len(data) == 0 {
+		return nil, errors.BadRequest("chunk is empty")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.get(id, now)
+	if err != nil {
+		return nil, err
+	}
+	if upload.completing {
+		return nil, errors.Conflict(fmt.Sprintf("mesh upload %s is being completed", id))
+	}
+
+	if existing, ok := upload.chunks[n]; ok {
+		if string(existing) != string(data) {
+			return nil, errors.Conflict(fmt.Sprintf("chunk %d of mesh upload %s was already received with different content", n, id))
+		}
+		upload.touched = now
+		return s.status(id, upload), nil
+	}
+
+	if upload.received+int64(len(data)) > upload.size {
+		return nil, errors.ValidationError(fmt.Sprintf("chunk %d overruns the declared size of %d bytes", n, upload.size))
+	}
+	if s.bytes+int64(len(data)) > s.maxBytes {
+		return nil, errors.ServerBusy("mesh upload buffer is full")
+	}
+
+	upload.chunks[n] = append([]byte(nil), data...)
+	upload.received += int64(len(data))
+	upload.touched = now
+	s.bytes += int64(len(data))
+	return s.status(id, upload), nil
+}
+
+// lookup returns the status of an upload
+func (s *meshUploads) lookup(id string, now time.Time) (*api.MeshUploadStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.get(id, now)
+	if err != nil {
+		return nil, err
+	}
+	return s.status(id, upload), nil
+}
+
+// assemble joins the chunks of a complete upload in order, verifying its
+// size and digest, and holds the upload until it is released or removed.
+// A digest mismatch discards the upload, as its chunks cannot be replaced.
+func (s *meshUploads) assemble(id string, now time.Time) (string, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.get(id, now)
+	if err != nil {
+		return "", nil, err
+	}
+	if upload.completing {
+		return "", nil, errors.Conflict(fmt.Sprintf("mesh upload %s is being completed", id))
+	}
+
+	numbers := sortedChunks(upload)
+	var missing []string
+	for i, next := 0, 0; i < len(numbers); next++ {
+		if numbers[i] == next {
+			i++
+			continue
+		}
+		missing = append(missing, fmt.Sprint(next))
+	}
+	if len(missing) > 0 {
+		return "", nil, errors.Conflict(fmt.Sprintf("mesh upload %s is missing chunks %s", id, strings.Join(missing, ", ")))
+	}
+	if upload.received != upload.size {
+		return "", nil, errors.Conflict(fmt.Sprintf("mesh upload %s has received %d of %d bytes", id, upload.received, upload.size))
+	}
+
+	data := make([]byte, 0, upload.size)
+	for _, n := range numbers {
+		data = append(data, upload.chunks[n]...)
+	}
+
+	if upload.sha256 != "" {
+		sum := sha256.Sum256(data)
+		if digest := hex.EncodeToString(sum[:]); digest != upload.sha256 {
+			s.removeLocked(id)
+			return "", nil, errors.ValidationError(fmt.Sprintf("mesh upload %s has SHA-256 %s, expected %s", id, digest, upload.sha256))
+		}
+	}
+
+	upload.completing = true
+	return upload.sessionID, data, nil
+}
+
+// release returns an upload whose completion failed to receiving chunks,
+// so completing it can be retried
+func (s *meshUploads) release(id string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if upload, ok := s.uploads[id]; ok {
+		upload.completing = false
+		upload.touched = now
+	}
+}
+
+// remove deletes an upload, returning it or nil if it is gone
+func (s *meshUploads) remove(id string) *meshUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeLocked(id)
+}
+
+// removeLocked deletes an upload. Callers must hold s.mu.
+func (s *meshUploads) removeLocked(id string) *meshUpload {
+	upload, ok := s.uploads[id]
+	if !ok {
+		return nil
+	}
+	delete(s.uploads, id)
+	s.bytes -= upload.received
+	return upload
+}
+
+// evictExpired deletes uploads that received no chunk within the TTL,
+// returning how many were deleted
+func (s *meshUploads) evictExpired(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var evicted int
+	for id, upload := range s.uploads {
+		if !upload.completing && now.Sub(upload.touched) > s.ttl {
+			s.removeLocked(id)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// bufferedBytes returns the chunk bytes held across uploads
+func (s *meshUploads) bufferedBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// status reports an upload's progress. Callers must hold s.mu.
+func (s *meshUploads) status(id string, upload *meshUpload) *api.MeshUploadStatus {
+	return &api.MeshUploadStatus{
+		UploadID:      id,
+		SessionID:     upload.sessionID,
+		Size:          upload.size,
+		ReceivedBytes: upload.received,
+		Chunks:        sortedChunks(upload),
+		ExpiresAt:     upload.touched.Add(s.ttl).UnixMilli(),
+	}
+}
+
+// sortedChunks returns the numbers of an upload's chunks, ascending
+func sortedChunks(upload *meshUpload) []int {
+	numbers := make([]int, 0, len(upload.chunks))
+	for n := range upload.chunks {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// StartMeshUpload begins a chunked upload of one mesh of a session
+func (r *Repository) StartMeshUpload(req *api.MeshUploadRequest) (*api.MeshUploadStatus, error) {
+	return r.uploads.start(req, time.Now())
+}
+
+// PutMeshUploadChunk stores chunk n of an upload, numbered from 0
+func (r *Repository) PutMeshUploadChunk(uploadID string, n int, data []byte) (*api.MeshUploadStatus, error) {
+	status, err := r.uploads.put(uploadID, n, data, time.Now())
+	r.metrics.MeshUploadBytes.Set(float64(r.uploads.bufferedBytes()))
+	return status, err
+}
+
+// MeshUploadStatus returns the chunks an upload has received
+func (r *Repository) MeshUploadStatus(uploadID string) (*api.MeshUploadStatus, error) {
+	return r.uploads.lookup(uploadID, time.Now())
+}
+
+// AssembleMeshUpload returns the session and assembled body of a complete
+// upload, which must then be stored with IngestMeshUpload or discarded with
+// DiscardMeshUpload. Uploads with missing chunks are left to be resumed.
+func (r *Repository) AssembleMeshUpload(uploadID string) (string, []byte, error) {
+	sessionID, data, err := r.uploads.assemble(uploadID, time.Now())
+	if apiErr, ok := errors.IsAPIError(err); ok && apiErr.Code == "VALIDATION_ERROR" {
+		r.metrics.MeshUploads.WithLabelValues("invalid").Inc()
+		r.metrics.MeshUploadBytes.Set(float64(r.uploads.bufferedBytes()))
+	}
+	return sessionID, data, err
+}
+
+// DiscardMeshUpload deletes an assembled upload whose mesh is invalid
+func (r *Repository) DiscardMeshUpload(uploadID string) {
+	if r.uploads.remove(uploadID) != nil {
+		r.metrics.MeshUploads.WithLabelValues("invalid").Inc()
+	}
+	r.metrics.MeshUploadBytes.Set(float64(r.uploads.bufferedBytes()))
+}
+
+// IngestMeshUpload stores the mesh of an assembled upload through the ingest
+// path, returning the stored mesh and the event it was stored in. The upload
+// is deleted once stored, and otherwise kept so completing can be retried.
+func (r *Repository) IngestMeshUpload(ctx context.Context, uploadID, sessionID string, mesh *api.Mesh) (*api.MeshUploadResponse, *api.SpatialEvent, error) {
+	stored := *mesh
+	event := api.SpatialEvent{
+		SessionID: sessionID,
+		EventID:   "upload-" + uploadID,
+		Timestamp: mesh.Timestamp,
+		Meshes:    []api.Mesh{stored},
+	}
+
+	if err := r.ValidateEventSize(&event); err != nil {
+		r.uploads.release(uploadID, time.Now())
+		return nil, nil, err
+	}
+	if err := r.Ingest(ctx, &event); err != nil {
+		r.uploads.release(uploadID, time.Now())
+		return nil, nil, err
+	}
+
+	var size int64
+	if upload := r.uploads.remove(uploadID); upload != nil {
+		size = upload.size
+	}
+	r.metrics.MeshUploads.WithLabelValues("completed").Inc()
+	r.metrics.MeshUploadBytes.Set(float64(r.uploads.bufferedBytes()))
+
+	meshID := r.storedMeshID(mesh)
+	event.Meshes[0].ID = meshID
+
+	return &api.MeshUploadResponse{
+		UploadID:     uploadID,
+		MeshID:       meshID,
+		SessionID:    sessionID,
+		AnchorID:     mesh.AnchorID,
+		Size:         size,
+		Deduplicated: meshID != mesh.ID,
+	}, &event, nil
+}
+
+// runUploadJanitor periodically discards abandoned uploads
+func (r *Repository) runUploadJanitor() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.uploads.ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if evicted := r.uploads.evictExpired(now); evicted > 0 {
+				r.metrics.MeshUploads.WithLabelValues("expired").Add(float64(evicted))
+				r.metrics.MeshUploadBytes.Set(float64(r.uploads.bufferedBytes()))
+				r.logger.Debugf("Discarded %d abandoned mesh uploads", evicted)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package spatial
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tabular/stag-v2/internal/config"
+	"github.com/tabular/stag-v2/pkg/api"
+	"github.com/tabular/stag-v2/pkg/errors"
+)
+
+// uploadStatusCode returns the HTTP status of an upload error
+func uploadStatusCode(err error) int {
+	apiErr, ok := errors.IsAPIError(err)
+	if !ok {
+		return 0
+	}
+	return apiErr.StatusCode
+}
+
+func TestMeshUploadChunks(t *testing.T) {
+	uploads := newMeshUploads(config.UploadConfig{TTL: time.Hour, MaxSize: 1 << 20, MaxBytes: 1 << 20})
+	now := time.Now()
+
+	body := `{"id":"m1","anchor_id":"a1","timestamp":1}`
+	sum := sha256.Sum256([]byte(body))
+	status, err := uploads.start(&api.MeshUploadRequest{SessionID: "s1", Size: int64(len(body)), SHA256: strings.ToUpper(hex.EncodeToString(sum[:]))}, now)
+	if err != nil {
+		t.Fatalf("Failed to start upload: %v", err)
+	}
+	id := status.UploadID
+
+	// Chunks arrive out of order, and a resent chunk changes nothing
+	parts := []string{body[:10], body[10:25], body[25:]}
+	for _, n := range []int{2, 0, 2} {
+		if _, err := uploads.put(id, n, []byte(parts[n]), now); err != nil {
+			t.Fatalf("Failed to store chunk %d: %v", n, err)
+		}
+	}
+	if _, err := uploads.put(id, 0, []byte("different!"), now); uploadStatusCode(err) != http.StatusConflict {
+		t.Errorf("Expected a conflicting resend rejected with 409, got %v", err)
+	}
+
+	if _, _, err := uploads.assemble(id, now); uploadStatusCode(err) != http.StatusConflict || !strings.Contains(err.Error(), "missing chunks 1") {
+		t.Errorf("Expected chunk 1 reported missing, got %v", err)
+	}
+
+	status, err = uploads.put(id, 1, []byte(parts[1]), now)
+	if err != nil {
+		t.Fatalf("Failed to store chunk 1: %v", err)
+	}
+	if status.ReceivedBytes != int64(len(body)) || len(status.Chunks) != 3 || status.Chunks[0] != 0 || status.Chunks[2] != 2 {
+		t.Errorf("Expected all chunks received in order, got %+v", status)
+	}
+
+	sessionID, data, err := uploads.assemble(id, now)
+	if err != nil || sessionID != "s1" || string(data) != body {
+		t.Fatalf("Expected the chunks joined in order, got %q, %v", data, err)
+	}
+
+	// Chunks are refused while the upload is stored, and accepted again if that fails
+	if _, err := uploads.put(id, 3, []byte("x"), now); uploadStatusCode(err) != http.StatusConflict {
+		t.Errorf("Expected chunks refused while completing, got %v", err)
+	}
+	uploads.release(id, now)
+	if _, _, err := uploads.assemble(id, now); err != nil {
+		t.Errorf("Expected completion retried after a release, got %v", err)
+	}
+
+	if uploads.remove(id) == nil || uploads.bufferedBytes() != 0 {
+		t.Errorf("Expected the upload's bytes freed, %d remain", uploads.bufferedBytes())
+	}
+	if _, err := uploads.lookup(id, now); uploadStatusCode(err) != http.StatusNotFound {
+		t.Errorf("Expected a removed upload not found, got %v", err)
+	}
+}
+
+func TestMeshUploadLimits(t *testing.T) {
+	uploads := newMeshUploads(config.UploadConfig{TTL: time.Minute, MaxSize: 10, MaxBytes: 12})
+	now := time.Now()
+
+	if _, err := uploads.start(&api.MeshUploadRequest{SessionID: "s1", Size: 11}, now); uploadStatusCode(err) != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected an oversized upload rejected with 413, got %v", err)
+	}
+
+	first, _ := uploads.start(&api.MeshUploadRequest{SessionID: "s1", Size: 10}, now)
+	second, _ := uploads.start(&api.MeshUploadRequest{SessionID: "s1", Size: 10, SHA256: strings.Repeat("0", 64)}, now)
+
+	if _, err := uploads.put(first.UploadID, 0, []byte("0123456789x"), now); uploadStatusCode(err) != http.StatusBadRequest {
+		t.Errorf("Expected a chunk past the declared size rejected, got %v", err)
+	}
+	if _, err := uploads.put(first.UploadID, 0, []byte("0123456789"), now); err != nil {
+		t.Fatalf("Failed to store chunk: %v", err)
+	}
+	if _, err := uploads.put(second.UploadID, 0, []byte("abc"), now); uploadStatusCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("Expected chunks refused once the buffer is full, got %v", err)
+	}
+
+	// A digest mismatch discards the upload, as its chunks cannot be replaced
+	uploads.remove(first.UploadID)
+	if _, err := uploads.put(second.UploadID, 0, []byte("0123456789"), now); err != nil {
+		t.Fatalf("Failed to store chunk: %v", err)
+	}
+	if _, _, err := uploads.assemble(second.UploadID, now); uploadStatusCode(err) != http.StatusBadRequest {
+		t.Errorf("Expected a digest mismatch rejected, got %v", err)
+	}
+	if _, err := uploads.lookup(second.UploadID, now); uploadStatusCode(err) != http.StatusNotFound {
+		t.Errorf("Expected the mismatched upload discarded, got %v", err)
+	}
+
+	// Uploads without a chunk for longer than the TTL expire
+	idle, _ := uploads.start(&api.MeshUploadRequest{SessionID: "s1", Size: 5}, now)
+	active, _ := uploads.start(&api.MeshUploadRequest{SessionID: "s1", Size: 5}, now)
+	uploads.put(active.UploadID, 0, []byte("ab"), now.Add(50*time.Second))
+
+	later := now.Add(90 * time.Second)
+	if _, err := uploads.put(idle.UploadID, 0, []byte("ab"), later); uploadStatusCode(err) != http.StatusNotFound {
+		t.Errorf("Expected an expired upload not found, got %v", err)
+	}
+	if evicted := uploads.evictExpired(later); evicted != 1 {
+		t.Errorf("Expected 1 upload evicted, got %d", evicted)
+	}
+	if _, err := uploads.lookup(active.UploadID, later); err != nil {
+		t.Errorf("Expected the active upload kept, got %v", err)
+	}
+}
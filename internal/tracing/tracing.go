@@ -0,0 +1,115 @@
+// Package tracing records OpenTelemetry spans for requests, database queries
+// and WebSocket updates and exports them over OTLP/HTTP
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+// instrumentationName names the tracer of all STAG spans
+const instrumentationName = "github.com/tabular/stag-v2"
+
+// Span attributes. Only bounded values are recorded: sessions and
+// operations, never anchor or mesh IDs.
+const (
+	SessionIDKey = attribute.Key("stag.session_id")
+	OperationKey = attribute.Key("stag.operation")
+)
+
+// Setup installs the global tracer provider and W3C trace context
+// propagation. When tracing is disabled the provider records nothing, but
+// incoming trace context is still propagated. The returned function flushes
+// and stops the exporter.
+func Setup(ctx context.Context, cfg config.TracingConfig, version string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of STAG spans from the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts an internal span as a child of any span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, on span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ContextWithTraceID continues the trace named by a WebSocket message's
+// trace_id. A W3C traceparent is followed exactly. A bare trace ID of 32 hex
+// digits, or a UUID, joins its trace under a placeholder parent, as the span
+// the client sent it from is unknown. Other IDs leave ctx unchanged.
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	if strings.Count(traceID, "-") == 3 && len(traceID) == 55 {
+		carrier := propagation.MapCarrier{"traceparent": traceID}
+		return propagation.TraceContext{}.Extract(ctx, carrier)
+	}
+
+	raw, err := hex.DecodeString(strings.ReplaceAll(traceID, "-", ""))
+	if err != nil || len(raw) != len(trace.TraceID{}) {
+		return ctx
+	}
+
+	var config trace.SpanContextConfig
+	copy(config.TraceID[:], raw)
+	copy(config.SpanID[:], raw[len(raw)-len(config.SpanID):])
+	config.TraceFlags = trace.FlagsSampled
+	config.Remote = true
+
+	spanContext := trace.NewSpanContext(config)
+	if !spanContext.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, spanContext)
+}
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tabular/stag-v2/internal/config"
+)
+
+func TestContextWithTraceID(t *testing.T) {
+	tests := []struct {
+		name    string
+		traceID string
+		want    string // Expected trace ID, "" for none
+		spanID  string
+	}{
+		{"Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"Hex", "4bf92f3577b34da6a3ce929d0e0e4736", "4bf92f3577b34da6a3ce929d0e0e4736", "a3ce929d0e0e4736"},
+		{"UUID", "4bf92f35-77b3-4da6-a3ce-929d0e0e4736", "4bf92f3577b34da6a3ce929d0e0e4736", "a3ce929d0e0e4736"},
+		{"Opaque", "client-trace-1", "", ""},
+		{"Zero", "00000000000000000000000000000000", "", ""},
+		{"BadTraceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spanContext := trace.SpanContextFromContext(ContextWithTraceID(context.Background(), tt.traceID))
+			if tt.want == "" {
+				if spanContext.IsValid() {
+					t.Errorf("Expected no span context, got %v", spanContext.TraceID())
+				}
+				return
+			}
+			if !spanContext.IsValid() || !spanContext.IsRemote() {
+				t.Fatalf("Expected a remote span context, got %+v", spanContext)
+			}
+			if spanContext.TraceID().String() != tt.want || spanContext.SpanID().String() != tt.spanID {
+				t.Errorf("Expected trace %s span %s, got %s %s", tt.want, tt.spanID, spanContext.TraceID(), spanContext.SpanID())
+			}
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+
+	shutdown, err := Setup(context.Background(), config.TracingConfig{}, "test")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+
+	// Disabled tracing records nothing
+	_, span := Start(context.Background(), "disabled")
+	if span.IsRecording() {
+		t.Error("Expected no spans recorded while disabled")
+	}
+	span.End()
+
+	// The exporter only connects when spans are flushed
+	cfg := config.TracingConfig{Enabled: true, Endpoint: "localhost:4318", Insecure: true, ServiceName: "stag", SampleRatio: 1}
+	shutdown, err = Setup(context.Background(), cfg, "test")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	_, span = Start(context.Background(), "enabled", SessionIDKey.String("s1"))
+	if !span.IsRecording() {
+		t.Error("Expected spans recorded while enabled")
+	}
+	span.End()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // Do not wait on a collector that is not there
+	shutdown(ctx)
+}
@@ -14,6 +14,64 @@ type SpatialEvent struct {
 	Meshes    []Mesh   `json:"meshes"`
 }
 
+// BatchIngestResult reports the outcome of one event in a batch ingest
+type BatchIngestResult struct {
+	Index     int    `json:"index"`
+	EventID   string `json:"event_id,omitempty"`
+	Success   bool   `json:"success"`
+	Code      string `json:"code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Retryable bool   `json:"retryable,omitempty"` // The event failed transiently and may be resent
+
+	Details map[string]interface{} `json:"details,omitempty"` // Invalid fields of the event, as in ErrorResponse
+}
+
+// BatchIngestResponse contains per-event results of a batch ingest
+type BatchIngestResponse struct {
+	Results   []BatchIngestResult `json:"results"`
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+}
+
+// ImportResponse describes a mesh created from an uploaded OBJ or PLY file
+type ImportResponse struct {
+	MeshID        string `json:"mesh_id"`
+	SessionID     string `json:"session_id"`
+	AnchorID      string `json:"anchor_id"`
+	VertexCount   int    `json:"vertex_count"`
+	TriangleCount int    `json:"triangle_count"`
+	Deduplicated  bool   `json:"deduplicated"`   // Identical geometry was already stored under MeshID
+	AnchorCreated bool   `json:"anchor_created"` // The anchor did not exist and was created at the origin
+}
+
+// MeshUploadRequest starts a chunked upload of one mesh, sent as the JSON
+// body of an ingest mesh split into numbered chunks
+type MeshUploadRequest struct {
+	SessionID string `json:"session_id" binding:"required"`
+	Size      int64  `json:"size" binding:"required,gt=0"`                            // Bytes of the assembled mesh JSON
+	SHA256    string `json:"sha256,omitempty" binding:"omitempty,len=64,hexadecimal"` // Hex digest checked on completion
+}
+
+// MeshUploadStatus reports the chunks an unfinished upload has received
+type MeshUploadStatus struct {
+	UploadID      string `json:"upload_id"`
+	SessionID     string `json:"session_id"`
+	Size          int64  `json:"size"`
+	ReceivedBytes int64  `json:"received_bytes"`
+	Chunks        []int  `json:"chunks"`     // Numbers of the chunks received, ascending
+	ExpiresAt     int64  `json:"expires_at"` // Unix milliseconds after which the upload is discarded unless a chunk arrives
+}
+
+// MeshUploadResponse describes the mesh stored by a completed upload
+type MeshUploadResponse struct {
+	UploadID     string `json:"upload_id"`
+	MeshID       string `json:"mesh_id"`
+	SessionID    string `json:"session_id"`
+	AnchorID     string `json:"anchor_id"`
+	Size         int64  `json:"size"`
+	Deduplicated bool   `json:"deduplicated"` // Identical geometry was already stored under MeshID
+}
+
 // Anchor represents a spatial anchor with pose and metadata
 type Anchor struct {
 	ID        string                 `json:"id" binding:"required"`
@@ -31,31 +89,181 @@ type Pose struct {
 	Rotation []float64 `json:"rotation"` // Quaternion [x, y, z, w]
 }
 
+// PoseAtTime is an anchor's pose interpolated from its pose history
+type PoseAtTime struct {
+	AnchorID   string `json:"anchor_id"`
+	SessionID  string `json:"session_id"`
+	Timestamp  int64  `json:"timestamp"` // Requested time, Unix milliseconds
+	Pose       Pose   `json:"pose"`
+	PrevSample int64  `json:"prev_sample"` // Timestamp of the sample at or before the requested time
+	NextSample int64  `json:"next_sample"` // Timestamp of the sample at or after the requested time
+	Clamped    bool   `json:"clamped"`     // The time is outside the history and the nearest sample was used
+}
+
 // Mesh represents 3D geometry data
 type Mesh struct {
 	ID               string `json:"id" binding:"required"`
 	AnchorID         string `json:"anchor_id" binding:"required"`
+	SessionID        string `json:"session_id,omitempty"`       // Session of the anchor, set on ingest
 	Vertices         []byte `json:"vertices,omitempty"`         // Compressed vertex data
 	Faces            []byte `json:"faces,omitempty"`            // Compressed face indices
 	Normals          []byte `json:"normals,omitempty"`          // Optional compressed normals
 	Hash             string `json:"hash,omitempty"`              // Hash for deduplication
 	IsDelta          bool   `json:"is_delta"`                    // Whether this is a delta mesh
 	BaseMeshID       string `json:"base_mesh_id,omitempty"`     // Reference to base mesh if delta
-	DeltaData        []byte `json:"delta_data,omitempty"`       // Delta information
+	DeltaData        []byte `json:"delta_data,omitempty"`       // Binary delta patch against the base mesh
 	CompressionLevel int    `json:"compression_level" binding:"min=0,max=9"`
+	CompressionCodec string `json:"compression_codec,omitempty" binding:"omitempty,oneof=raw gzip zstd draco meshopt"` // Empty to detect gzip/zstd framing
 	Timestamp        int64  `json:"timestamp" binding:"required"`
+	RawSize          int64  `json:"raw_size,omitempty"`         // Decompressed geometry bytes, set on ingest
+	TriangleCount    int    `json:"triangle_count,omitempty"`   // Set on ingest for geometry the server can decode
+	LODOf            string `json:"lod_of,omitempty"`           // Mesh this is a lower level of detail of
+	VertexStride     int    `json:"vertex_stride,omitempty"`    // Bytes per vertex, starting with an XYZ float32 position; 0 means 12
+	IndexFormat      string `json:"index_format,omitempty"`     // Face index width, uint16 or uint32 (default); stored as uint32
+
+	// Where geometry offloaded to object storage is kept, in place of the
+	// buffers. Set on ingest; meshes are returned with their buffers.
+	BufferRef *MeshBufferRef `json:"buffer_ref,omitempty"`
+
+	// Anchors sharing the stored geometry through deduplication, the first
+	// being the one that stored it. Set on ingest.
+	RefCount     int             `json:"ref_count,omitempty"`
+	ReferencedBy []MeshReference `json:"referenced_by,omitempty"`
+}
+
+// MeshBufferRef locates a mesh's stored buffers in object storage. The
+// object holds the vertex, face and normal buffers back to back.
+type MeshBufferRef struct {
+	Backend      string `json:"backend"` // filesystem or s3
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	Hash         string `json:"hash"` // SHA-256 of the object, which names it
+	Size         int64  `json:"size"`
+	VerticesSize int64  `json:"vertices_size"`
+	FacesSize    int64  `json:"faces_size"`
+}
+
+// MeshReference is an anchor whose mesh is a stored mesh's geometry
+type MeshReference struct {
+	SessionID string `json:"session_id"`
+	AnchorID  string `json:"anchor_id"`
+}
+
+// MeshBatchResult is one requested mesh of a batch fetch
+type MeshBatchResult struct {
+	ID    string `json:"id"`
+	Found bool   `json:"found"`
+	Mesh  *Mesh  `json:"mesh,omitempty"`
+	Error string `json:"error,omitempty"` // Why a stored mesh could not be returned
+}
+
+// MeshBatchResponse holds a batch fetch's meshes in request order
+type MeshBatchResponse struct {
+	Meshes  []MeshBatchResult `json:"meshes"`
+	Found   int               `json:"found"`
+	Missing int               `json:"missing"`
+}
+
+// LatestPosesRequest names the anchors whose latest poses to fetch, in the
+// given session or any
+type LatestPosesRequest struct {
+	AnchorIDs []string `json:"anchor_ids"`
+	SessionID string   `json:"session_id,omitempty"`
+}
+
+// LatestPose is one requested anchor's latest pose
+type LatestPose struct {
+	AnchorID  string `json:"anchor_id"`
+	Found     bool   `json:"found"`
+	SessionID string `json:"session_id,omitempty"`
+	Pose      *Pose  `json:"pose,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"` // When the pose was last updated
+}
+
+// LatestPosesResponse holds the requested anchors' latest poses in request order
+type LatestPosesResponse struct {
+	Poses   []LatestPose `json:"poses"`
+	Found   int          `json:"found"`
+	Missing int          `json:"missing"`
+}
+
+// LODResponse describes a level of detail generated from a mesh
+type LODResponse struct {
+	MeshID              string `json:"mesh_id"`
+	LODOf               string `json:"lod_of"`
+	VertexCount         int    `json:"vertex_count"`
+	TriangleCount       int    `json:"triangle_count"`
+	SourceTriangleCount int    `json:"source_triangle_count"`
+	Created             bool   `json:"created"` // False when a level with the same triangle count already existed
+}
+
+// MeshDiffResponse describes a delta mesh generated against a stored base
+type MeshDiffResponse struct {
+	MeshID     string `json:"mesh_id"`
+	BaseMeshID string `json:"base_mesh_id"`
+	Operations int    `json:"operations"`
+	DeltaBytes int64  `json:"delta_bytes"` // Stored size of the delta
+	FullBytes  int64  `json:"full_bytes"`  // Stored size of the mesh had it been sent in full
+	SavedBytes int64  `json:"saved_bytes"` // FullBytes less DeltaBytes; negative when the delta is larger
+}
+
+// MeshChainLink is one mesh of a delta chain
+type MeshChainLink struct {
+	MeshID     string `json:"mesh_id"`
+	IsDelta    bool   `json:"is_delta"`
+	BaseMeshID string `json:"base_mesh_id,omitempty"`
+	Bytes      int64  `json:"bytes"` // Stored size of the delta patch, or of a full mesh's buffers
+}
+
+// MeshChainResponse lists the meshes a stored mesh is resolved against
+type MeshChainResponse struct {
+	MeshID            string          `json:"mesh_id"`
+	Chain             []MeshChainLink `json:"chain"`                          // From the mesh down to the full mesh at the root
+	Depth             int             `json:"depth"`                          // Deltas applied to resolve the mesh
+	TotalBytes        int64           `json:"total_bytes"`                    // Stored size of the whole chain
+	MissingBaseMeshID string          `json:"missing_base_mesh_id,omitempty"` // Base the chain ends at when it is not stored
 }
 
 // QueryParams defines parameters for spatial queries
 type QueryParams struct {
 	SessionID      string  `form:"session_id"`
 	AnchorID       string  `form:"anchor_id"`
-	Radius         float64 `form:"radius"`         // Radius in meters for spatial query
+	Radius         float64 `form:"radius"`         // Radius in meters for 3D spatial query
 	Since          int64   `form:"since"`          // Unix timestamp in milliseconds
 	Until          int64   `form:"until"`          // Unix timestamp in milliseconds
 	Limit          int     `form:"limit"`          // Max number of results
 	IncludeMeshes  bool    `form:"include_meshes"` // Whether to include mesh data
 	IncludeDeleted bool    `form:"include_deleted"` // Whether to include deleted anchors
+	History        bool    `form:"history"`         // Return every recorded pose sample instead of each anchor's latest pose
+	Cursor         string  `form:"cursor"`          // Opaque token from a previous page
+	MaxTriangles   int     `form:"max_triangles"`   // Return each mesh's most detailed level within this many triangles
+	CountOnly      bool    `form:"count_only"`      // Return only the number of matches, without anchors or meshes
+	HasMesh        string  `form:"has_mesh"`        // "true" or "false" selects anchors with or without a stored mesh, "any" or empty both
+
+	// Axis-aligned bounding box in meters, inclusive. Pointers distinguish an
+	// unset bound from zero; all six must be given together.
+	MinX *float64 `form:"min_x"`
+	MinY *float64 `form:"min_y"`
+	MinZ *float64 `form:"min_z"`
+	MaxX *float64 `form:"max_x"`
+	MaxY *float64 `form:"max_y"`
+	MaxZ *float64 `form:"max_z"`
+
+	// Polygon on the floor plane as "x1,y1;x2,y2;..." pose coordinates in
+	// meters, like longitude then latitude. Selects anchors whose (x, y) lies
+	// inside it, at any height.
+	Polygon string `form:"polygon"`
+
+	// Metadata values anchors must all hold, from repeatable
+	// meta.<key>=<value> parameters
+	Metadata []MetadataFilter `form:"-"`
+}
+
+// MetadataFilter selects anchors whose metadata holds Value at Key. A value
+// spelling a number or boolean also matches that number or boolean.
+type MetadataFilter struct {
+	Key   string
+	Value string
 }
 
 // QueryResponse contains the results of a spatial query
@@ -64,6 +272,151 @@ type QueryResponse struct {
 	Meshes  []Mesh   `json:"meshes,omitempty"`
 	Count   int      `json:"count"`
 	HasMore bool     `json:"has_more"`
+	Cursor  string   `json:"cursor,omitempty"` // Pass as ?cursor= to fetch the next page
+}
+
+// SessionListParams defines parameters for listing sessions
+type SessionListParams struct {
+	Since  int64  `form:"since"`  // Only sessions active at or after this Unix timestamp in milliseconds
+	Limit  int    `form:"limit"`  // Max number of sessions
+	Cursor string `form:"cursor"` // Opaque token from a previous page
+
+	// Sessions the caller's token grants, set by the server; nil lists all
+	Scope *SessionScope `form:"-"`
+}
+
+// SessionScope is a set of sessions: those named, and with a tenant, the
+// session named after it and every "<tenant>:" session
+type SessionScope struct {
+	Sessions []string
+	Tenant   string
+}
+
+// SessionSummary holds aggregate statistics for a session
+type SessionSummary struct {
+	SessionID      string `json:"session_id"`
+	AnchorCount    int    `json:"anchor_count"`
+	MeshCount      int    `json:"mesh_count"`
+	FirstTimestamp int64  `json:"first_timestamp"`
+	LastTimestamp  int64  `json:"last_timestamp"`
+	Live           bool   `json:"live"` // Whether any WebSocket client is connected
+}
+
+// SessionListResponse contains a page of sessions, most recently active first
+type SessionListResponse struct {
+	Sessions []SessionSummary `json:"sessions"`
+	Count    int              `json:"count"`
+	HasMore  bool             `json:"has_more"`
+	Cursor   string           `json:"cursor,omitempty"`
+}
+
+// SessionDeleteResponse counts the documents removed with a session, or that
+// would be removed on a dry run
+type SessionDeleteResponse struct {
+	SessionID    string `json:"session_id"`
+	Anchors      int    `json:"anchors"`
+	Meshes       int    `json:"meshes"`
+	SharedMeshes int    `json:"shared_meshes"` // Meshes kept for other sessions that reference them
+	Edges        int    `json:"edges"`
+	Poses        int    `json:"poses"` // Anchor pose history samples
+	DryRun       bool   `json:"dry_run"`
+}
+
+// ActivityParams defines the time range and bucket size of a session activity query
+type ActivityParams struct {
+	Bucket string `form:"bucket"` // Bucket width, e.g. 30s, 5m, 1h or 1d
+	Since  int64  `form:"since"`  // Unix timestamp in milliseconds, required
+	Until  int64  `form:"until"`  // Unix timestamp in milliseconds, defaults to now
+}
+
+// ActivityBucket counts the anchors and meshes whose timestamps fall in a bucket
+type ActivityBucket struct {
+	Timestamp   int64 `json:"timestamp"` // Bucket start, Unix milliseconds
+	AnchorCount int   `json:"anchor_count"`
+	MeshCount   int   `json:"mesh_count"`
+}
+
+// ActivityResponse is a session's activity time series, oldest bucket first.
+// Buckets without activity are omitted.
+type ActivityResponse struct {
+	SessionID string           `json:"session_id"`
+	BucketMs  int64            `json:"bucket_ms"`
+	Since     int64            `json:"since"`
+	Until     int64            `json:"until"`
+	Series    []ActivityBucket `json:"series"`
+}
+
+// ClusterParams defines the grid and optional region of an anchor clustering
+type ClusterParams struct {
+	Grid float64 `form:"grid"` // Cell size in meters, defaults to 1
+
+	// Axis-aligned bounding box in meters, inclusive, as in QueryParams. All
+	// six bounds must be given together.
+	MinX *float64 `form:"min_x"`
+	MinY *float64 `form:"min_y"`
+	MinZ *float64 `form:"min_z"`
+	MaxX *float64 `form:"max_x"`
+	MaxY *float64 `form:"max_y"`
+	MaxZ *float64 `form:"max_z"`
+}
+
+// AnchorCluster is the anchors of one occupied grid cell
+type AnchorCluster struct {
+	Cell  [3]int64 `json:"cell"` // Cell indices, each coordinate divided by the grid and floored
+	X     float64  `json:"x"`    // Centroid of the cell's anchors
+	Y     float64  `json:"y"`
+	Z     float64  `json:"z"`
+	Count int      `json:"count"`
+}
+
+// ClusterResponse lists a session's occupied grid cells, most anchors first
+type ClusterResponse struct {
+	SessionID     string          `json:"session_id"`
+	Grid          float64         `json:"grid"`
+	Clusters      []AnchorCluster `json:"clusters"`
+	Count         int             `json:"count"`
+	TotalClusters int             `json:"total_clusters"` // Occupied cells, including any beyond the returned clusters
+	Anchors       int             `json:"anchors"`        // Anchors in all occupied cells
+}
+
+// ReplayParams selects the time window and pacing of a session replay
+type ReplayParams struct {
+	From          int64   `form:"from"`           // Unix timestamp in milliseconds, inclusive
+	To            int64   `form:"to"`             // Unix timestamp in milliseconds, exclusive; 0 for no end
+	Speed         float64 `form:"speed"`          // Playback speed for replay offsets, defaults to 1
+	ResolveDeltas bool    `form:"resolve_deltas"` // Send delta meshes as the full meshes they produce
+}
+
+// ReplayEvent is one record of a session replay: an event that can be posted
+// back to /ingest as is, with the time to post it to reproduce the session
+type ReplayEvent struct {
+	SpatialEvent
+	ReplayOffsetMs int64 `json:"replay_offset_ms"` // Since the first record, scaled by speed
+}
+
+// Neighbor is an anchor reachable through the topology graph
+type Neighbor struct {
+	Anchor   Anchor  `json:"anchor"`
+	Hops     int     `json:"hops"`
+	Distance float64 `json:"distance"` // Straight-line distance in meters from the queried anchor
+}
+
+// NeighborsResponse contains the anchors connected to an anchor
+type NeighborsResponse struct {
+	AnchorID  string     `json:"anchor_id"`
+	Neighbors []Neighbor `json:"neighbors"`
+	Count     int        `json:"count"`
+}
+
+// PathResponse is the shortest path through the topology graph between two
+// anchors of a session
+type PathResponse struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Connected bool     `json:"connected"` // False when no path within the depth limit joins them
+	Anchors   []Anchor `json:"anchors"`   // From first and To last; empty when not connected
+	Hops      int      `json:"hops"`
+	Distance  float64  `json:"distance"` // Sum of the edge distances in meters
 }
 
 // WSMessage represents a WebSocket message
@@ -77,13 +430,17 @@ type WSMessage struct {
 
 // WebSocket message types
 const (
-	WSTypeAnchorUpdate = "anchor_update"
-	WSTypeMeshUpdate   = "mesh_update"
-	WSTypePing         = "ping"
-	WSTypePong         = "pong"
-	WSTypeError        = "error"
-	WSTypeSubscribe    = "subscribe"
-	WSTypeUnsubscribe  = "unsubscribe"
+	WSTypeAnchorUpdate  = "anchor_update"
+	WSTypeAnchorDiff    = "anchor_diff"
+	WSTypeMeshUpdate    = "mesh_update"
+	WSTypePing          = "ping"
+	WSTypePong          = "pong"
+	WSTypeError         = "error"
+	WSTypeSubscribe     = "subscribe"
+	WSTypeUnsubscribe   = "unsubscribe"
+	WSTypeIngestSummary = "ingest_summary"
+	WSTypeSlowConsumer  = "slow_consumer"
+	WSTypeSnapshot      = "snapshot"
 )
 
 // AnchorUpdate represents an anchor position update
@@ -93,6 +450,18 @@ type AnchorUpdate struct {
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// AnchorDiff announces the fields an anchor_update changed. Unchanged fields
+// are omitted; a newly created anchor carries all of them.
+type AnchorDiff struct {
+	ID       string                 `json:"id"`
+	Created  bool                   `json:"created,omitempty"`
+	X        *float64               `json:"x,omitempty"`
+	Y        *float64               `json:"y,omitempty"`
+	Z        *float64               `json:"z,omitempty"`
+	Rotation []float64              `json:"rotation,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"` // Changed keys only
+}
+
 // PoseData represents pose in WebSocket messages
 type PoseData struct {
 	X        float64   `json:"x"`
@@ -109,15 +478,125 @@ type MeshUpdate struct {
 	Faces            string `json:"faces"`            // Base64 encoded
 	Normals          string `json:"normals,omitempty"` // Base64 encoded
 	CompressionLevel int    `json:"compression_level"`
+	CompressionCodec string `json:"compression_codec,omitempty"` // raw, gzip, zstd, draco or meshopt
 	IsDelta          bool   `json:"is_delta"`
 	BaseMeshID       string `json:"base_mesh_id,omitempty"`
+	Checksum         string `json:"checksum,omitempty"` // Hex CRC32 (IEEE) of decoded vertices, faces and normals
+	VertexStride     int    `json:"vertex_stride,omitempty"`
+	IndexFormat      string `json:"index_format,omitempty"`
+}
+
+// MeshFrameHeader is the header of a binary mesh_update frame: the message
+// envelope and the MeshUpdate fields other than its buffers, which follow the
+// header raw
+type MeshFrameHeader struct {
+	SessionID        string `json:"session_id,omitempty"`
+	Timestamp        int64  `json:"timestamp"`
+	TraceID          string `json:"trace_id,omitempty"`
+	ID               string `json:"id"`
+	AnchorID         string `json:"anchor_id"`
+	CompressionLevel int    `json:"compression_level"`
+	CompressionCodec string `json:"compression_codec,omitempty"`
+	IsDelta          bool   `json:"is_delta"`
+	BaseMeshID       string `json:"base_mesh_id,omitempty"`
+	Checksum         string `json:"checksum,omitempty"` // Hex CRC32 (IEEE) of the vertices, faces and normals
+	VertexStride     int    `json:"vertex_stride,omitempty"`
+	IndexFormat      string `json:"index_format,omitempty"`
+}
+
+// SubscribeOptions are optional settings sent with a subscribe message
+type SubscribeOptions struct {
+	CompressionLevel *int          `json:"compression_level,omitempty"` // Storage level for the session's meshes that set none
+	Region           *RegionFilter `json:"region,omitempty"`            // Replaces the subscription's region; an empty region removes it
+}
+
+// RegionFilter limits the anchor and mesh updates a subscription receives
+// to anchors inside an axis-aligned box, given by Min and Max, or within
+// Radius meters of an anchor
+type RegionFilter struct {
+	Min      []float64 `json:"min,omitempty"` // [x, y, z] in meters, inclusive
+	Max      []float64 `json:"max,omitempty"`
+	AnchorID string    `json:"anchor_id,omitempty"` // Center of the sphere, following the anchor as it moves
+	Radius   float64   `json:"radius,omitempty"`
+}
+
+// IngestSummary announces a large HTTP ingest in place of individual updates
+type IngestSummary struct {
+	EventIDs    []string `json:"event_ids"`
+	AnchorCount int      `json:"anchor_count"`
+	MeshCount   int      `json:"mesh_count"`
+}
+
+// SlowConsumerWarning tells a client its outbound queue has overflowed. It is
+// disconnected unless the queue falls back within the buffer in time.
+type SlowConsumerWarning struct {
+	Queued            int   `json:"queued"`   // Messages waiting to be sent
+	Buffer            int   `json:"buffer"`   // Messages that may wait without a warning
+	Capacity          int   `json:"capacity"` // Messages that may wait before further ones are dropped
+	DisconnectAfterMs int64 `json:"disconnect_after_ms"`
+}
+
+// SnapshotRequest are the optional settings of a snapshot message
+type SnapshotRequest struct {
+	IncludeMeshes bool `json:"include_meshes,omitempty"`
+}
+
+// SnapshotPage is one message answering a snapshot request. Pages carry the
+// session's anchors newest first, with their meshes if requested; the last
+// has Done set, and live updates held during the snapshot follow it.
+type SnapshotPage struct {
+	Page      int      `json:"page"` // From 0
+	Anchors   []Anchor `json:"anchors"`
+	Meshes    []Mesh   `json:"meshes,omitempty"`
+	Done      bool     `json:"done,omitempty"`
+	Total     int      `json:"total,omitempty"`     // Anchors sent across all pages, on the last
+	Truncated bool     `json:"truncated,omitempty"` // The session holds more anchors than a snapshot sends, on the last
+}
+
+// DeadLetter is a WebSocket update that failed processing for a server-side
+// reason, kept for inspection and, when the failure was transient, retry
+type DeadLetter struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	SessionID   string          `json:"session_id"`
+	TraceID     string          `json:"trace_id,omitempty"`
+	ErrorClass  string          `json:"error_class"`
+	Error       string          `json:"error"`
+	Attempts    int             `json:"attempts"`     // Processing attempts, including the original
+	Retrying    bool            `json:"retrying"`     // Another attempt is scheduled
+	FailedAt    int64           `json:"failed_at"`    // First failure, Unix milliseconds
+	LastAttempt int64           `json:"last_attempt"` // Latest failure, Unix milliseconds
+	Size        int             `json:"size"`         // Bytes of the update's data
+	Data        json.RawMessage `json:"data,omitempty"`
+}
+
+// DeadLetterParams defines parameters for listing dead letters
+type DeadLetterParams struct {
+	SessionID   string `form:"session_id"`
+	Limit       int    `form:"limit"`        // Max number of entries, newest first
+	IncludeData bool   `form:"include_data"` // Include each update's data
+}
+
+// DeadLetterList contains recent dead-lettered WebSocket updates
+type DeadLetterList struct {
+	DeadLetters []DeadLetter `json:"dead_letters"`
+	Count       int          `json:"count"`
+	Dropped     int64        `json:"dropped"` // Entries evicted to stay within the buffer caps
 }
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
 	Code    string                 `json:"code"`
 	Message string                 `json:"message"`
-	Details map[string]interface{} `json:"details,omitempty"`
+	Error   string                 `json:"error,omitempty"`   // Message again in HTTP responses, under the key of their other errors
+	Details map[string]interface{} `json:"details,omitempty"` // Invalid request fields, by name, as FieldError
+}
+
+// FieldError describes why a request field is invalid
+type FieldError struct {
+	Rule    string `json:"rule"`            // Broken rule: required, min, max, oneof, type, ...
+	Param   string `json:"param,omitempty"` // The rule's parameter, such as a bound or the expected type
+	Message string `json:"message"`
 }
 
 // HealthResponse represents health check response
@@ -126,6 +605,102 @@ type HealthResponse struct {
 	Version   string    `json:"version"`
 	Timestamp time.Time `json:"timestamp"`
 	Database  string    `json:"database"`
+
+	// Schema version recorded in the database, and the version this
+	// instance migrates to; an instance behind the database runs an older
+	// build than the one that migrated it
+	SchemaVersion       int `json:"schema_version,omitempty"`
+	LatestSchemaVersion int `json:"latest_schema_version"`
+
+	// Whether each of several ArangoDB endpoints passed its last probe
+	DatabaseEndpoints map[string]bool `json:"database_endpoints,omitempty"`
+}
+
+// StorageStatsParams defines parameters for the storage breakdown
+type StorageStatsParams struct {
+	Limit int `form:"limit"` // Max number of sessions, largest first
+}
+
+// StorageStats breaks down stored data by collection and by session
+type StorageStats struct {
+	Collections   []CollectionStorage `json:"collections"`
+	Sessions      []SessionStorage    `json:"sessions"`       // Largest on disk first
+	TotalSessions int                 `json:"total_sessions"` // Sessions before the limit was applied
+	GeneratedAt   int64               `json:"generated_at"`   // Unix milliseconds; results are cached briefly
+}
+
+// CollectionStorage is a collection's size from its ArangoDB figures
+type CollectionStorage struct {
+	Name          string `json:"name"`
+	Documents     int64  `json:"documents"`
+	LogicalBytes  int64  `json:"logical_bytes"` // Document data
+	IndexBytes    int64  `json:"index_bytes"`
+	EstimatedDisk int64  `json:"estimated_disk_bytes"` // Documents and indexes
+}
+
+// SessionStorage is a session's share of storage. Collection bytes are
+// apportioned by the session's share of their documents, or for meshes of
+// their geometry.
+type SessionStorage struct {
+	SessionID     string `json:"session_id"`
+	Anchors       int64  `json:"anchors"`
+	PoseSamples   int64  `json:"pose_samples"`
+	Meshes        int64  `json:"meshes"`
+	GeometryBytes int64  `json:"geometry_bytes"` // Stored mesh buffers
+	LogicalBytes  int64  `json:"logical_bytes"`
+	EstimatedDisk int64  `json:"estimated_disk_bytes"`
+}
+
+// DedupStatsParams defines parameters for the dedup cache report
+type DedupStatsParams struct {
+	Limit int `form:"limit"` // Max number of top hashes
+}
+
+// DedupStats describes the mesh dedup cache and the stored meshes shared most
+type DedupStats struct {
+	Entries   int         `json:"entries"`
+	Hits      int64       `json:"hits"`      // Ingested hashes found in the cache since startup
+	Misses    int64       `json:"misses"`    // Ingested hashes looked up in the database instead
+	HitRatio  float64     `json:"hit_ratio"` // Hits over lookups, 0 before any
+	TopHashes []DedupHash `json:"top_hashes"`
+}
+
+// DedupHash is a stored mesh hash with the anchors sharing its geometry
+type DedupHash struct {
+	Hash     string `json:"hash"`
+	MeshID   string `json:"mesh_id"`
+	RefCount int    `json:"ref_count"`
+	Cached   bool   `json:"cached"` // Whether the dedup cache holds the hash
+}
+
+// DedupRebuildResponse reports a rebuild of the dedup cache
+type DedupRebuildResponse struct {
+	PreviousEntries int   `json:"previous_entries"`
+	Entries         int   `json:"entries"`
+	DurationMs      int64 `json:"duration_ms"`
+}
+
+// SnapshotParams represents query parameters for a snapshot export
+type SnapshotParams struct {
+	SessionID string `form:"session_id"` // Limits the snapshot to one session
+}
+
+// SnapshotManifest describes a snapshot archive, as its first entry
+type SnapshotManifest struct {
+	Format    string         `json:"format"`
+	Version   int            `json:"version"`
+	CreatedAt int64          `json:"created_at"` // Unix milliseconds
+	SessionID string         `json:"session_id,omitempty"`
+	Documents map[string]int `json:"documents"` // Per collection
+}
+
+// SnapshotRestoreResponse reports a restored snapshot
+type SnapshotRestoreResponse struct {
+	SessionID    string         `json:"session_id,omitempty"`
+	CreatedAt    int64          `json:"created_at"`
+	Documents    map[string]int `json:"documents"` // Upserted per collection
+	DedupEntries int            `json:"dedup_entries"`
+	DurationMs   int64          `json:"duration_ms"`
 }
 
 // MetricsInfo represents metrics information
@@ -0,0 +1,79 @@
+package api
+
+// MediaTypeV2 is the Accept media type that selects /api/v2 response shapes
+// on /api/v1 paths
+const MediaTypeV2 = "application/vnd.stag.v2+json"
+
+// QueryResponseV2 is the /api/v2 shape of a spatial query. Anchors name
+// their meshes by ID, and each mesh appears once however many anchors in the
+// page share it.
+type QueryResponseV2 struct {
+	Anchors []AnchorV2      `json:"anchors"`
+	Meshes  map[string]Mesh `json:"meshes,omitempty"` // Keyed by mesh ID
+	Page    PageV2          `json:"page"`
+}
+
+// AnchorV2 is an anchor with references to its meshes
+type AnchorV2 struct {
+	Anchor
+	MeshIDs []string `json:"mesh_ids,omitempty"` // Only set when meshes were requested
+}
+
+// PageV2 describes a page of results and how to fetch the next one
+type PageV2 struct {
+	Count      int    `json:"count"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"` // Pass as ?cursor= to fetch the next page
+}
+
+// NewQueryResponseV2 translates a query result into the v2 shape. A mesh is
+// linked to every anchor in the page that references it, or to its own
+// anchor if it records no references.
+func NewQueryResponseV2(response *QueryResponse) *QueryResponseV2 {
+	v2 := &QueryResponseV2{
+		Anchors: make([]AnchorV2, len(response.Anchors)),
+		Page: PageV2{
+			Count:      response.Count,
+			HasMore:    response.HasMore,
+			NextCursor: response.Cursor,
+		},
+	}
+
+	// History pages repeat anchors, so one reference may link several entries
+	byReference := make(map[MeshReference][]int, len(response.Anchors))
+	for i, anchor := range response.Anchors {
+		v2.Anchors[i] = AnchorV2{Anchor: anchor}
+		ref := MeshReference{SessionID: anchor.SessionID, AnchorID: anchor.ID}
+		byReference[ref] = append(byReference[ref], i)
+	}
+
+	if len(response.Meshes) == 0 {
+		return v2
+	}
+
+	v2.Meshes = make(map[string]Mesh, len(response.Meshes))
+	for _, mesh := range response.Meshes {
+		v2.Meshes[mesh.ID] = mesh
+
+		refs := mesh.ReferencedBy
+		if len(refs) == 0 {
+			refs = []MeshReference{{SessionID: mesh.SessionID, AnchorID: mesh.AnchorID}}
+		}
+		for _, ref := range refs {
+			for _, i := range byReference[ref] {
+				v2.Anchors[i].MeshIDs = appendUnique(v2.Anchors[i].MeshIDs, mesh.ID)
+			}
+		}
+	}
+	return v2
+}
+
+// appendUnique appends id to ids unless already present
+func appendUnique(ids []string, id string) []string {
+	for _, existing := range ids {
+		if existing == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
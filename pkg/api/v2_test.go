@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// queryResult is one internal query result served by both API versions
+func queryResult() *QueryResponse {
+	return &QueryResponse{
+		Anchors: []Anchor{
+			{ID: "a1", SessionID: "s1", Timestamp: 3},
+			{ID: "a2", SessionID: "s1", Timestamp: 2},
+			{ID: "a1", SessionID: "s1", Timestamp: 1}, // A second history sample
+		},
+		Meshes: []Mesh{
+			{
+				ID: "shared", AnchorID: "a1", SessionID: "s1", Timestamp: 1,
+				ReferencedBy: []MeshReference{{SessionID: "s1", AnchorID: "a1"}, {SessionID: "s1", AnchorID: "a2"}, {SessionID: "s2", AnchorID: "a2"}},
+				RefCount:     3,
+			},
+			{ID: "legacy", AnchorID: "a2", SessionID: "s1", Timestamp: 1},
+		},
+		Count:   3,
+		HasMore: true,
+		Cursor:  "next",
+	}
+}
+
+// decode marshals v and decodes it as generic JSON
+func decode(t *testing.T, v interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	return decoded
+}
+
+func TestQueryResponseVersions(t *testing.T) {
+	result := queryResult()
+
+	v1 := decode(t, result)
+	for _, key := range []string{"anchors", "meshes", "count", "has_more", "cursor"} {
+		if _, ok := v1[key]; !ok {
+			t.Errorf("Expected v1 key %q, got %v", key, v1)
+		}
+	}
+	if meshes, ok := v1["meshes"].([]interface{}); !ok || len(meshes) != 2 {
+		t.Errorf("Expected v1 meshes as a list of 2, got %v", v1["meshes"])
+	}
+
+	v2 := decode(t, NewQueryResponseV2(result))
+	for _, key := range []string{"count", "has_more", "cursor"} {
+		if _, ok := v2[key]; ok {
+			t.Errorf("Expected no top-level v2 key %q", key)
+		}
+	}
+	wantPage := map[string]interface{}{"count": 3.0, "has_more": true, "next_cursor": "next"}
+	if !reflect.DeepEqual(v2["page"], wantPage) {
+		t.Errorf("Expected page %v, got %v", wantPage, v2["page"])
+	}
+
+	meshes, ok := v2["meshes"].(map[string]interface{})
+	if !ok || len(meshes) != 2 || meshes["shared"] == nil || meshes["legacy"] == nil {
+		t.Fatalf("Expected v2 meshes keyed by ID, got %v", v2["meshes"])
+	}
+
+	anchors := v2["anchors"].([]interface{})
+	wantMeshIDs := [][]interface{}{{"shared"}, {"shared", "legacy"}, {"shared"}}
+	for i, raw := range anchors {
+		anchor := raw.(map[string]interface{})
+		if anchor["id"] != result.Anchors[i].ID || anchor["timestamp"] != float64(result.Anchors[i].Timestamp) {
+			t.Errorf("Expected anchor %d flattened as %+v, got %v", i, result.Anchors[i], anchor)
+		}
+		if !reflect.DeepEqual(anchor["mesh_ids"], wantMeshIDs[i]) {
+			t.Errorf("Expected anchor %d mesh_ids %v, got %v", i, wantMeshIDs[i], anchor["mesh_ids"])
+		}
+	}
+
+	// The translation leaves the internal result as v1 serves it
+	if !reflect.DeepEqual(result, queryResult()) {
+		t.Error("Expected the query result unchanged by the translation")
+	}
+}
+
+func TestQueryResponseV2WithoutMeshes(t *testing.T) {
+	result := queryResult()
+	result.Meshes = nil
+	result.HasMore = false
+	result.Cursor = ""
+
+	v2 := decode(t, NewQueryResponseV2(result))
+	if _, ok := v2["meshes"]; ok {
+		t.Errorf("Expected no meshes, got %v", v2["meshes"])
+	}
+	for _, raw := range v2["anchors"].([]interface{}) {
+		if ids, ok := raw.(map[string]interface{})["mesh_ids"]; ok {
+			t.Errorf("Expected no mesh_ids without meshes, got %v", ids)
+		}
+	}
+	wantPage := map[string]interface{}{"count": 3.0, "has_more": false}
+	if !reflect.DeepEqual(v2["page"], wantPage) {
+		t.Errorf("Expected page %v, got %v", wantPage, v2["page"])
+	}
+}
@@ -1,6 +1,7 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"net/http"
 )
@@ -10,6 +11,8 @@ type APIError struct {
 	Message    string
 	StatusCode int
 	Code       string
+	Retryable  bool  // The request may succeed if sent again unchanged
+	Err        error // Underlying cause, if any
 }
 
 // Error implements the error interface
@@ -17,6 +20,16 @@ func (e *APIError) Error() string {
 	return e.Message
 }
 
+// Unwrap returns the underlying cause
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether the failure is transient
+func (e *APIError) IsRetryable() bool {
+	return e != nil && e.Retryable
+}
+
 // Common error constructors
 
 // BadRequest creates a 400 error
@@ -91,6 +104,66 @@ func DatabaseError(message string) *APIError {
 	}
 }
 
+// DatabaseConflict creates a retryable 409 error for a write that raced another
+func DatabaseConflict(message string) *APIError {
+	return &APIError{
+		Message:    fmt.Sprintf("database conflict: %s", message),
+		StatusCode: http.StatusConflict,
+		Code:       "DATABASE_CONFLICT",
+		Retryable:  true,
+	}
+}
+
+// DatabaseUnavailable creates a retryable 503 error for an unreachable or busy database
+func DatabaseUnavailable(message string) *APIError {
+	return &APIError{
+		Message:    fmt.Sprintf("database unavailable: %s", message),
+		StatusCode: http.StatusServiceUnavailable,
+		Code:       "DATABASE_UNAVAILABLE",
+		Retryable:  true,
+	}
+}
+
+// StorageUnavailable creates a retryable 503 error for unreachable object storage
+func StorageUnavailable(message string) *APIError {
+	return &APIError{
+		Message:    fmt.Sprintf("storage unavailable: %s", message),
+		StatusCode: http.StatusServiceUnavailable,
+		Code:       "STORAGE_UNAVAILABLE",
+		Retryable:  true,
+	}
+}
+
+// ServerBusy creates a retryable 503 error for a request refused under load
+func ServerBusy(message string) *APIError {
+	return &APIError{
+		Message:    fmt.Sprintf("server busy: %s", message),
+		StatusCode: http.StatusServiceUnavailable,
+		Code:       "SERVER_BUSY",
+		Retryable:  true,
+	}
+}
+
+// QueryTimeout creates a retryable 504 error for a query that ran out of time
+func QueryTimeout(message string) *APIError {
+	return &APIError{
+		Message:    fmt.Sprintf("query timeout: %s", message),
+		StatusCode: http.StatusGatewayTimeout,
+		Code:       "QUERY_TIMEOUT",
+		Retryable:  true,
+	}
+}
+
+// ClientClosedRequest creates a 499 error for an operation abandoned because
+// the client disconnected
+func ClientClosedRequest(message string) *APIError {
+	return &APIError{
+		Message:    fmt.Sprintf("client closed request: %s", message),
+		StatusCode: 499,
+		Code:       "CLIENT_CLOSED_REQUEST",
+	}
+}
+
 // ValidationError creates a 400 error with validation prefix
 func ValidationError(message string) *APIError {
 	return &APIError{
@@ -109,6 +182,15 @@ func CompressionError(message string) *APIError {
 	}
 }
 
+// PayloadTooLarge creates a 413 error
+func PayloadTooLarge(message string) *APIError {
+	return &APIError{
+		Message:    message,
+		StatusCode: http.StatusRequestEntityTooLarge,
+		Code:       "PAYLOAD_TOO_LARGE",
+	}
+}
+
 // RateLimitError creates a 429 error
 func RateLimitError(message string) *APIError {
 	return &APIError{
@@ -118,8 +200,9 @@ func RateLimitError(message string) *APIError {
 	}
 }
 
-// IsAPIError checks if an error is an APIError
+// IsAPIError checks if an error is, or wraps, an APIError
 func IsAPIError(err error) (*APIError, bool) {
-	apiErr, ok := err.(*APIError)
+	var apiErr *APIError
+	ok := stderrors.As(err, &apiErr)
 	return apiErr, ok
 }
\ No newline at end of file
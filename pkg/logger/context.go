@@ -0,0 +1,31 @@
+package logger
+
+import "context"
+
+// TraceIDField is the structured log field holding a request's trace ID
+const TraceIDField = "trace_id"
+
+type traceIDKey struct{}
+
+// WithTraceID returns a context carrying traceID
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID returns the trace ID carried by ctx, or "" if there is none
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// FromContext returns log annotated with the trace ID carried by ctx, unless
+// log was configured without trace IDs
+func FromContext(ctx context.Context, log Logger) Logger {
+	if l, ok := log.(*LogrusLogger); ok && !l.traceIDs {
+		return log
+	}
+	if traceID := TraceID(ctx); traceID != "" {
+		return log.WithField(TraceIDField, traceID)
+	}
+	return log
+}
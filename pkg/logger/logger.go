@@ -1,9 +1,34 @@
 package logger
 
 import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+
 	"github.com/sirupsen/logrus"
 )
 
+// Log output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// DefaultTimestampFormat is RFC 3339 with milliseconds
+const DefaultTimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// CallerField is the structured log field holding the file and line that logged
+const CallerField = "caller"
+
+// Config selects how log lines are written
+type Config struct {
+	Format          string `mapstructure:"format"`           // json (default) or text
+	Caller          bool   `mapstructure:"caller"`           // Add the file and line that logged each line
+	TimestampFormat string `mapstructure:"timestamp_format"` // Go time layout, DefaultTimestampFormat if empty
+	TraceID         bool   `mapstructure:"trace_id"`         // Add trace_id to lines logged for traced requests and updates
+}
+
 // Logger interface defines logging methods
 type Logger interface {
 	Debug(args ...interface{})
@@ -21,23 +46,41 @@ type Logger interface {
 	SetLevel(level logrus.Level)
 }
 
-// LogrusLogger wraps logrus.Logger to implement our Logger interface
+// LogrusLogger wraps a logrus entry to implement our Logger interface, so
+// that fields added with WithField are kept on every line it logs
 type LogrusLogger struct {
-	*logrus.Logger
+	*logrus.Entry
+	traceIDs bool // FromContext adds trace IDs
 }
 
-// New creates a new logger instance
-func New() Logger {
+// New creates a new logger instance. Text output is colored when written
+// to a terminal.
+func New(cfg Config) Logger {
+	timestampFormat := cfg.TimestampFormat
+	if timestampFormat == "" {
+		timestampFormat = DefaultTimestampFormat
+	}
+
 	log := logrus.New()
-	log.SetFormatter(&logrus.JSONFormatter{
-		TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
-	})
-	return &LogrusLogger{Logger: log}
+	if cfg.Format == FormatText {
+		log.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: timestampFormat,
+		})
+	} else {
+		log.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: timestampFormat,
+		})
+	}
+	if cfg.Caller {
+		log.AddHook(callerHook{})
+	}
+	return &LogrusLogger{Entry: logrus.NewEntry(log), traceIDs: cfg.TraceID}
 }
 
 // WithField creates a new logger with a single field
 func (l *LogrusLogger) WithField(key string, value interface{}) Logger {
-	return &LogrusLogger{Logger: l.Logger.WithField(key, value).Logger}
+	return &LogrusLogger{Entry: l.Entry.WithField(key, value), traceIDs: l.traceIDs}
 }
 
 // WithFields creates a new logger with multiple fields
@@ -46,5 +89,51 @@ func (l *LogrusLogger) WithFields(fields map[string]interface{}) Logger {
 	for k, v := range fields {
 		logrusFields[k] = v
 	}
-	return &LogrusLogger{Logger: l.Logger.WithFields(logrusFields).Logger}
-}
\ No newline at end of file
+	return &LogrusLogger{Entry: l.Entry.WithFields(logrusFields), traceIDs: l.traceIDs}
+}
+
+// SetLevel sets the level of the underlying logger, shared by derived loggers
+func (l *LogrusLogger) SetLevel(level logrus.Level) {
+	l.Entry.Logger.SetLevel(level)
+}
+
+// wrapperPrefix starts the names of LogrusLogger's methods, which log on
+// behalf of their callers
+var wrapperPrefix = reflect.TypeOf(LogrusLogger{}).PkgPath() + ".(*LogrusLogger)."
+
+// callerHook adds the file and line that logged an entry. logrus's own
+// caller reporting would name LogrusLogger's promoted methods instead.
+type callerHook struct{}
+
+// Levels implements logrus.Hook
+func (callerHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (callerHook) Fire(entry *logrus.Entry) error {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/sirupsen/logrus.") && !strings.HasPrefix(frame.Function, wrapperPrefix) {
+			entry.Data[CallerField] = fmt.Sprintf("%s:%d", shortFile(frame.File), frame.Line)
+			return nil
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// shortFile trims a source path to its directory and file name
+func shortFile(file string) string {
+	slash := strings.LastIndexByte(file, '/')
+	if slash <= 0 {
+		return file
+	}
+	if dir := strings.LastIndexByte(file[:slash], '/'); dir >= 0 {
+		return file[dir+1:]
+	}
+	return file
+}
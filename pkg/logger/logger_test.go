@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// capture returns a logger built from cfg writing to the returned buffer
+func capture(cfg Config) (Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	log := New(cfg)
+	log.(*LogrusLogger).Logger.SetOutput(&buf)
+	return log, &buf
+}
+
+func TestNewFormats(t *testing.T) {
+	log, buf := capture(Config{})
+	log.WithField("anchor_id", "a1").Info("stored")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a JSON line by default, got %q", buf)
+	}
+	if line["msg"] != "stored" || line["anchor_id"] != "a1" || line[CallerField] != nil {
+		t.Errorf("Unexpected JSON line: %v", line)
+	}
+
+	log, buf = capture(Config{Format: FormatText, TimestampFormat: "2006"})
+	log.Info("stored")
+	if out := buf.String(); !strings.Contains(out, `msg=stored`) || json.Valid(buf.Bytes()) {
+		t.Errorf("Expected a text line, got %q", out)
+	}
+}
+
+func TestNewCaller(t *testing.T) {
+	log, buf := capture(Config{Caller: true})
+	log.WithFields(map[string]interface{}{"k": "v"}).Warnf("at %s", "caller")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Failed to decode line %q: %v", buf, err)
+	}
+	caller, _ := line[CallerField].(string)
+	if !strings.HasPrefix(caller, "logger/logger_test.go:") {
+		t.Errorf("Expected the caller in this test, got %q", caller)
+	}
+}
+
+func TestFromContextTraceID(t *testing.T) {
+	ctx := WithTraceID(context.Background(), "trace-1")
+
+	for _, traceIDs := range []bool{true, false} {
+		log, buf := capture(Config{TraceID: traceIDs})
+		FromContext(ctx, log.WithField("k", "v")).Info("traced")
+
+		var line map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("Failed to decode line %q: %v", buf, err)
+		}
+		if _, ok := line[TraceIDField]; ok != traceIDs {
+			t.Errorf("With trace IDs %t, got line %v", traceIDs, line)
+		}
+	}
+}
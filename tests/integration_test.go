@@ -2,10 +2,19 @@ package tests
 
 import (
 	"bytes"
-	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -35,6 +44,7 @@ func TestFullIntegration(t *testing.T) {
 
 	// Test 1: Ingest spatial event
 	t.Run("IngestEvent", func(t *testing.T) {
+		vertices, faces := triangleBuffers(1)
 		event := api.SpatialEvent{
 			SessionID: sessionID,
 			EventID:   "event-1",
@@ -56,8 +66,8 @@ func TestFullIntegration(t *testing.T) {
 				{
 					ID:               "mesh-1",
 					AnchorID:         anchorID,
-					Vertices:         []byte{1, 2, 3, 4, 5, 6},
-					Faces:            []byte{0, 1, 2},
+					Vertices:         vertices,
+					Faces:            faces,
 					CompressionLevel: 5,
 					Timestamp:        time.Now().UnixMilli(),
 				},
@@ -96,6 +106,55 @@ func TestFullIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("QueryLimitClamped", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&limit=1000000", testServerURL, sessionID))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected a limit over the maximum clamped, got status %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("QueryV2", func(t *testing.T) {
+		check := func(t *testing.T, req *http.Request) {
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+			}
+
+			var result api.QueryResponseV2
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if result.Page.Count != 1 || len(result.Anchors) != 1 {
+				t.Fatalf("Expected 1 anchor, got %+v", result.Page)
+			}
+			meshIDs := result.Anchors[0].MeshIDs
+			if len(meshIDs) != 1 || result.Meshes[meshIDs[0]].AnchorID != anchorID {
+				t.Errorf("Expected the anchor to reference its mesh, got %v and %d meshes", meshIDs, len(result.Meshes))
+			}
+		}
+
+		t.Run("Path", func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v2/query?session_id=%s&include_meshes=true", testServerURL, sessionID), nil)
+			check(t, req)
+		})
+
+		t.Run("Accept", func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v1/query?session_id=%s&include_meshes=true", testServerURL, sessionID), nil)
+			req.Header.Set("Accept", api.MediaTypeV2)
+			check(t, req)
+		})
+	})
+
 	// Test 3: WebSocket streaming
 	t.Run("WebSocketStreaming", func(t *testing.T) {
 		// Connect to WebSocket
@@ -106,41 +165,106 @@ func TestFullIntegration(t *testing.T) {
 		}
 		defer conn.Close()
 
-		// Send anchor update
-		update := map[string]interface{}{
-			"type":       "anchor_update",
-			"session_id": sessionID,
-			"data": map[string]interface{}{
-				"id": "anchor-ws-1",
-				"pose": map[string]interface{}{
-					"x":        5.0,
-					"y":        6.0,
-					"z":        7.0,
-					"rotation": []float64{0, 0, 0, 1},
+		// Updates are broadcast to the session's other clients
+		listener, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("WebSocket connection failed: %v", err)
+		}
+		defer listener.Close()
+
+		sendPose := func(x float64, metadata map[string]interface{}) {
+			update := map[string]interface{}{
+				"type":       "anchor_update",
+				"session_id": sessionID,
+				"data": map[string]interface{}{
+					"id": "anchor-ws-1",
+					"pose": map[string]interface{}{
+						"x":        x,
+						"y":        6.0,
+						"z":        7.0,
+						"rotation": []float64{0, 0, 0, 1},
+					},
+					"metadata": metadata,
 				},
+				"timestamp": time.Now().UnixMilli(),
+			}
+			if err := conn.WriteJSON(update); err != nil {
+				t.Fatalf("Failed to send message: %v", err)
+			}
+		}
+
+		readDiff := func() (string, api.AnchorDiff) {
+			listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var message api.WSMessage
+			if err := listener.ReadJSON(&message); err != nil {
+				t.Fatalf("Failed to read broadcast: %v", err)
+			}
+			var diff api.AnchorDiff
+			json.Unmarshal(message.Data, &diff)
+			return message.Type, diff
+		}
+
+		sendPose(5, map[string]interface{}{"label": "door"})
+		if messageType, diff := readDiff(); messageType != api.WSTypeAnchorDiff || !diff.Created || diff.X == nil || *diff.X != 5 {
+			t.Fatalf("Expected an anchor_diff creating the anchor, got %s %+v", messageType, diff)
+		}
+
+		// An unchanged pose is not broadcast, so the next diff carries only x
+		sendPose(5, map[string]interface{}{"label": "door"})
+		sendPose(8, map[string]interface{}{"label": "door"})
+		messageType, diff := readDiff()
+		if messageType != api.WSTypeAnchorDiff || diff.Created || diff.X == nil || *diff.X != 8 ||
+			diff.Y != nil || diff.Z != nil || diff.Rotation != nil || diff.Metadata != nil {
+			t.Errorf("Expected an anchor_diff of x alone, got %s %+v", messageType, diff)
+		}
+	})
+
+	t.Run("HTTPIngestBroadcast", func(t *testing.T) {
+		broadcastSession := sessionID + "-broadcast"
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s?session_id=%s", testWSURL, broadcastSession), nil)
+		if err != nil {
+			t.Fatalf("WebSocket connection failed: %v", err)
+		}
+		defer conn.Close()
+
+		// Give the hub time to register the watcher
+		time.Sleep(100 * time.Millisecond)
+
+		now := time.Now().UnixMilli()
+		event := api.SpatialEvent{
+			SessionID: broadcastSession,
+			EventID:   "event-broadcast",
+			Timestamp: now,
+			Anchors: []api.Anchor{
+				{ID: "broadcast-anchor", SessionID: broadcastSession, Pose: api.Pose{X: 1, Rotation: []float64{0, 0, 0, 1}}, Timestamp: now},
 			},
-			"timestamp": time.Now().UnixMilli(),
 		}
 
-		if err := conn.WriteJSON(update); err != nil {
-			t.Fatalf("Failed to send message: %v", err)
+		resp := postJSON(t, "/api/v1/ingest", event)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
 		}
 
-		// Read response (should be broadcast back)
 		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-		var response map[string]interface{}
-		if err := conn.ReadJSON(&response); err != nil {
-			t.Fatalf("Failed to read response: %v", err)
+		var message api.WSMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read broadcast: %v", err)
 		}
 
-		if response["type"] != "anchor_update" {
-			t.Fatalf("Expected anchor_update, got %v", response["type"])
+		var update api.AnchorUpdate
+		if err := json.Unmarshal(message.Data, &update); err != nil {
+			t.Fatalf("Failed to decode update: %v", err)
+		}
+		if message.Type != api.WSTypeAnchorUpdate || update.ID != "broadcast-anchor" {
+			t.Errorf("Expected anchor_update for broadcast-anchor, got %s %s", message.Type, update.ID)
 		}
 	})
 
 	// Test 4: Mesh deduplication
 	t.Run("MeshDeduplication", func(t *testing.T) {
 		// Ingest same mesh twice
+		vertices, faces := triangleBuffers(2)
 		event1 := api.SpatialEvent{
 			SessionID: sessionID,
 			EventID:   "event-dedup-1",
@@ -149,8 +273,8 @@ func TestFullIntegration(t *testing.T) {
 				{
 					ID:               "mesh-dup-1",
 					AnchorID:         anchorID,
-					Vertices:         []byte{1, 2, 3, 4, 5, 6},
-					Faces:            []byte{0, 1, 2},
+					Vertices:         vertices,
+					Faces:            faces,
 					CompressionLevel: 5,
 					Timestamp:        time.Now().UnixMilli(),
 				},
@@ -165,8 +289,8 @@ func TestFullIntegration(t *testing.T) {
 				{
 					ID:               "mesh-dup-2",
 					AnchorID:         anchorID,
-					Vertices:         []byte{1, 2, 3, 4, 5, 6}, // Same data
-					Faces:            []byte{0, 1, 2},
+					Vertices:         vertices, // Same data
+					Faces:            faces,
 					CompressionLevel: 5,
 					Timestamp:        time.Now().UnixMilli(),
 				},
@@ -200,10 +324,141 @@ func TestFullIntegration(t *testing.T) {
 		t.Logf("Total meshes stored: %d", metrics.TotalMeshes)
 	})
 
+	// Concurrent ingests of one mesh ID both succeed and store it once
+	t.Run("ConcurrentMeshIngest", func(t *testing.T) {
+		statuses := make([]int, 2)
+		var wg sync.WaitGroup
+		for i := range statuses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				// Different geometry, so hash deduplication does not apply
+				vertices, faces := triangleBuffers(float32(10 + i))
+				event := api.SpatialEvent{
+					SessionID: sessionID,
+					EventID:   fmt.Sprintf("event-mesh-race-%d", i),
+					Timestamp: time.Now().UnixMilli(),
+					Meshes: []api.Mesh{{
+						ID:               "mesh-race",
+						AnchorID:         anchorID,
+						Vertices:         vertices,
+						Faces:            faces,
+						CompressionLevel: 5,
+						Timestamp:        time.Now().UnixMilli(),
+					}},
+				}
+
+				body, _ := json.Marshal(event)
+				resp, err := http.Post(testServerURL+"/api/v1/ingest", "application/json", bytes.NewReader(body))
+				if err != nil {
+					t.Errorf("Ingest failed: %v", err)
+					return
+				}
+				resp.Body.Close()
+				statuses[i] = resp.StatusCode
+			}(i)
+		}
+		wg.Wait()
+
+		for i, status := range statuses {
+			if status != http.StatusOK {
+				t.Errorf("Ingest %d: expected status 200, got %d", i, status)
+			}
+		}
+		if _, status := getMesh(t, "mesh-race"); status != http.StatusOK {
+			t.Errorf("Expected the mesh to be stored, got status %d", status)
+		}
+	})
+
+	t.Run("MalformedGeometry", func(t *testing.T) {
+		vertices, faces := triangleBuffers(4)
+		faces = binary.LittleEndian.AppendUint32(faces[:8], 3) // Past the last vertex
+
+		event := api.SpatialEvent{
+			SessionID: sessionID,
+			EventID:   "event-malformed",
+			Timestamp: time.Now().UnixMilli(),
+			Meshes: []api.Mesh{{
+				ID:        "mesh-malformed",
+				AnchorID:  anchorID,
+				Vertices:  vertices,
+				Faces:     faces,
+				Timestamp: time.Now().UnixMilli(),
+			}},
+		}
+
+		resp := postJSON(t, "/api/v1/ingest", event)
+		defer resp.Body.Close()
+
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != http.StatusBadRequest || body["code"] != "VALIDATION_ERROR" {
+			t.Errorf("Expected a 400 validation error for an out of range index, got %d %v", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("CompressionLevelHeader", func(t *testing.T) {
+		session := sessionID + "-level"
+		vertices, faces := triangleBuffers(11)
+		event := api.SpatialEvent{
+			SessionID: session,
+			EventID:   "event-level",
+			Timestamp: time.Now().UnixMilli(),
+			Anchors: []api.Anchor{{
+				ID:        "level-anchor",
+				SessionID: session,
+				Pose:      api.Pose{Rotation: []float64{0, 0, 0, 1}},
+				Timestamp: time.Now().UnixMilli(),
+			}},
+			Meshes: []api.Mesh{{
+				ID:        "level-mesh",
+				AnchorID:  "level-anchor",
+				Vertices:  vertices,
+				Faces:     faces,
+				Timestamp: time.Now().UnixMilli(),
+			}},
+		}
+		body, _ := json.Marshal(event)
+
+		ingest := func(level string) *http.Response {
+			req, _ := http.NewRequest(http.MethodPost, testServerURL+"/api/v1/ingest", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Compression-Level", level)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("POST request failed: %v", err)
+			}
+			resp.Body.Close()
+			return resp
+		}
+
+		if resp := ingest("fast"); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for an invalid level, got %d", resp.StatusCode)
+		}
+		if resp := ingest("12"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("Ingest failed: %d", resp.StatusCode)
+		}
+
+		// The clamped level is stored with the mesh
+		resp, err := http.Get(testServerURL + "/api/v1/meshes/level-mesh?raw=true")
+		if err != nil {
+			t.Fatalf("Failed to get mesh: %v", err)
+		}
+		defer resp.Body.Close()
+		var mesh api.Mesh
+		if err := json.NewDecoder(resp.Body).Decode(&mesh); err != nil {
+			t.Fatalf("Failed to decode mesh: %v", err)
+		}
+		if mesh.CompressionLevel != 9 {
+			t.Errorf("Expected the mesh stored at level 9, got %d", mesh.CompressionLevel)
+		}
+	})
+
 	// Test 5: Delta mesh
 	t.Run("DeltaMesh", func(t *testing.T) {
 		// First, ingest a base mesh
 		baseMeshID := "base-mesh-1"
+		baseVertices, baseFaces := triangleBuffers(3)
 		baseEvent := api.SpatialEvent{
 			SessionID: sessionID,
 			EventID:   "event-base",
@@ -212,8 +467,8 @@ func TestFullIntegration(t *testing.T) {
 				{
 					ID:               baseMeshID,
 					AnchorID:         anchorID,
-					Vertices:         []byte{1, 2, 3, 4, 5, 6, 7, 8, 9},
-					Faces:            []byte{0, 1, 2, 3, 4, 5},
+					Vertices:         baseVertices,
+					Faces:            baseFaces,
 					CompressionLevel: 5,
 					Timestamp:        time.Now().UnixMilli(),
 				},
@@ -247,37 +502,1479 @@ func TestFullIntegration(t *testing.T) {
 		if resp.StatusCode != http.StatusOK {
 			t.Fatalf("Delta mesh ingest failed: %d", resp.StatusCode)
 		}
+
+		// The stored delta is returned as is with ?raw=true
+		rawResp, err := http.Get(fmt.Sprintf("%s/api/v1/meshes/delta-mesh-1?raw=true", testServerURL))
+		if err != nil {
+			t.Fatalf("Failed to get mesh: %v", err)
+		}
+		defer rawResp.Body.Close()
+		var raw api.Mesh
+		if err := json.NewDecoder(rawResp.Body).Decode(&raw); err != nil {
+			t.Fatalf("Failed to decode mesh: %v", err)
+		}
+		if !raw.IsDelta || raw.BaseMeshID != baseMeshID {
+			t.Errorf("Expected the unresolved delta of %s, got %+v", baseMeshID, raw)
+		}
+
+		// The chain runs from the delta down to its base
+		chainResp, err := http.Get(fmt.Sprintf("%s/api/v1/meshes/delta-mesh-1/chain", testServerURL))
+		if err != nil {
+			t.Fatalf("Failed to get mesh chain: %v", err)
+		}
+		defer chainResp.Body.Close()
+		var chain api.MeshChainResponse
+		if err := json.NewDecoder(chainResp.Body).Decode(&chain); err != nil {
+			t.Fatalf("Failed to decode mesh chain: %v", err)
+		}
+		if chain.Depth != 1 || len(chain.Chain) != 2 || chain.Chain[0].MeshID != "delta-mesh-1" || chain.Chain[1].MeshID != baseMeshID || chain.Chain[1].IsDelta {
+			t.Errorf("Expected the chain delta-mesh-1 -> %s, got %+v", baseMeshID, chain)
+		}
+
+		// Deltas based on each other fail to resolve instead of recursing forever
+		loopSession := sessionID + "-delta-loop"
+		loopEvent := api.SpatialEvent{
+			SessionID: loopSession,
+			EventID:   "event-delta-loop",
+			Timestamp: time.Now().UnixMilli(),
+			Anchors: []api.Anchor{
+				{ID: "loop-anchor", SessionID: loopSession, Pose: api.Pose{Rotation: []float64{0, 0, 0, 1}}, Timestamp: time.Now().UnixMilli()},
+			},
+			Meshes: []api.Mesh{
+				{ID: "loop-mesh-1", AnchorID: "loop-anchor", IsDelta: true, BaseMeshID: "loop-mesh-2", DeltaData: []byte{1}, Timestamp: time.Now().UnixMilli()},
+				{ID: "loop-mesh-2", AnchorID: "loop-anchor", IsDelta: true, BaseMeshID: "loop-mesh-1", DeltaData: []byte{2}, Timestamp: time.Now().UnixMilli()},
+			},
+		}
+		resp = postJSON(t, "/api/v1/ingest", loopEvent)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Delta loop ingest failed: %d", resp.StatusCode)
+		}
+		for _, path := range []string{"/api/v1/meshes/loop-mesh-1", "/api/v1/meshes/loop-mesh-1/chain"} {
+			loopResp, err := http.Get(testServerURL + path)
+			if err != nil {
+				t.Fatalf("Failed to get %s: %v", path, err)
+			}
+			loopResp.Body.Close()
+			if loopResp.StatusCode != http.StatusUnprocessableEntity {
+				t.Errorf("Expected status 422 for %s, got %d", path, loopResp.StatusCode)
+			}
+		}
+
+		// The vertex buffer comes back decompressed as octet-stream
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v1/meshes/%s", testServerURL, baseMeshID), nil)
+		req.Header.Set("Accept", "application/octet-stream")
+		vertexResp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to get mesh vertices: %v", err)
+		}
+		defer vertexResp.Body.Close()
+		vertices, _ := io.ReadAll(vertexResp.Body)
+		if vertexResp.StatusCode != http.StatusOK || !bytes.Equal(vertices, baseVertices) {
+			t.Errorf("Expected the base mesh vertices, got %d %v", vertexResp.StatusCode, vertices)
+		}
+
+		missing, err := http.Get(fmt.Sprintf("%s/api/v1/meshes/no-such-mesh", testServerURL))
+		if err != nil {
+			t.Fatalf("Failed to get mesh: %v", err)
+		}
+		missing.Body.Close()
+		if missing.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a missing mesh, got %d", missing.StatusCode)
+		}
 	})
-}
 
-// Helper functions
+	t.Run("MeshBatch", func(t *testing.T) {
+		ids := []string{"base-mesh-1", "no-such-mesh", "mesh-1"}
+		resp := postJSON(t, "/api/v1/meshes/batch", ids)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
 
-func waitForServer(t *testing.T) {
-	maxRetries := 30
-	for i := 0; i < maxRetries; i++ {
-		resp, err := http.Get(testServerURL + "/health")
-		if err == nil && resp.StatusCode == http.StatusOK {
-			resp.Body.Close()
-			return
+		var result api.MeshBatchResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
 		}
-		if resp != nil {
-			resp.Body.Close()
+		if len(result.Meshes) != len(ids) || result.Found != 2 || result.Missing != 1 {
+			t.Fatalf("Expected 2 of 3 meshes found, got %+v", result)
+		}
+		for i, mesh := range result.Meshes {
+			found := ids[i] != "no-such-mesh"
+			if mesh.ID != ids[i] || mesh.Found != found || (mesh.Mesh != nil) != found {
+				t.Errorf("Expected %s in position %d found %t, got %+v", ids[i], i, found, mesh)
+			}
 		}
-		time.Sleep(1 * time.Second)
-	}
-	t.Fatal("Server failed to start")
-}
 
-func postJSON(t *testing.T, path string, data interface{}) *http.Response {
-	body, err := json.Marshal(data)
-	if err != nil {
-		t.Fatalf("Failed to marshal data: %v", err)
-	}
+		tooMany := make([]string, 101)
+		for i := range tooMany {
+			tooMany[i] = fmt.Sprintf("mesh-%d", i)
+		}
+		capped := postJSON(t, "/api/v1/meshes/batch", tooMany)
+		capped.Body.Close()
+		if capped.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400 beyond the cap, got %d", capped.StatusCode)
+		}
+	})
 
-	resp, err := http.Post(testServerURL+path, "application/json", bytes.NewReader(body))
-	if err != nil {
-		t.Fatalf("POST request failed: %v", err)
-	}
+	t.Run("LatestPoses", func(t *testing.T) {
+		ids := []string{anchorID, "no-such-anchor", anchorID}
+		resp := postJSON(t, "/api/v1/anchors/latest", api.LatestPosesRequest{AnchorIDs: ids, SessionID: sessionID})
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
 
-	return resp
-}
\ No newline at end of file
+		var result api.LatestPosesResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(result.Poses) != len(ids) || result.Found != 2 || result.Missing != 1 {
+			t.Fatalf("Expected 2 of 3 poses found, got %+v", result)
+		}
+		for i, pose := range result.Poses {
+			found := ids[i] == anchorID
+			if pose.AnchorID != ids[i] || pose.Found != found || (pose.Pose != nil) != found {
+				t.Errorf("Expected %s in position %d found %t, got %+v", ids[i], i, found, pose)
+			}
+			if found && (pose.SessionID != sessionID || len(pose.Pose.Rotation) != 4 || pose.Timestamp == 0) {
+				t.Errorf("Expected the anchor's pose in session %s, got %+v", sessionID, pose)
+			}
+		}
+
+		// Scoped to another session, the anchor is not found
+		other := postJSON(t, "/api/v1/anchors/latest", api.LatestPosesRequest{AnchorIDs: []string{anchorID}, SessionID: "no-such-session"})
+		defer other.Body.Close()
+		var scoped api.LatestPosesResponse
+		if err := json.NewDecoder(other.Body).Decode(&scoped); err != nil || scoped.Found != 0 {
+			t.Errorf("Expected no poses in another session, got %+v, %v", scoped, err)
+		}
+
+		empty := postJSON(t, "/api/v1/anchors/latest", api.LatestPosesRequest{})
+		empty.Body.Close()
+		if empty.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400 without anchor IDs, got %d", empty.StatusCode)
+		}
+	})
+
+	t.Run("MeshDiff", func(t *testing.T) {
+		vertices, faces := triangleBuffers(3)
+		vertices[0] ^= 0xff // Move the first vertex
+		mesh := api.Mesh{
+			ID:        "diff-mesh-1",
+			AnchorID:  anchorID,
+			Vertices:  vertices,
+			Faces:     faces,
+			Timestamp: time.Now().UnixMilli(),
+		}
+
+		resp := postJSON(t, "/api/v1/meshes/base-mesh-1/diff", mesh)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+		}
+		var result api.MeshDiffResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result.MeshID != mesh.ID || result.BaseMeshID != "base-mesh-1" || result.Operations != 1 || result.SavedBytes <= 0 {
+			t.Errorf("Expected a one operation delta saving bytes, got %+v", result)
+		}
+
+		if raw, status := getMesh(t, mesh.ID); status != http.StatusOK || !raw.IsDelta || raw.BaseMeshID != "base-mesh-1" {
+			t.Errorf("Expected a stored delta of base-mesh-1, got %d %+v", status, raw)
+		}
+
+		// The resolved mesh has the diffed geometry
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v1/meshes/%s", testServerURL, mesh.ID), nil)
+		req.Header.Set("Accept", "application/octet-stream")
+		vertexResp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to get mesh vertices: %v", err)
+		}
+		defer vertexResp.Body.Close()
+		resolved, _ := io.ReadAll(vertexResp.Body)
+		if vertexResp.StatusCode != http.StatusOK || !bytes.Equal(resolved, vertices) {
+			t.Errorf("Expected the diffed vertices, got %d %v", vertexResp.StatusCode, resolved)
+		}
+
+		again := postJSON(t, "/api/v1/meshes/base-mesh-1/diff", mesh)
+		again.Body.Close()
+		if again.StatusCode != http.StatusConflict {
+			t.Errorf("Expected status 409 for a taken mesh ID, got %d", again.StatusCode)
+		}
+
+		mesh.ID = "diff-mesh-2"
+		missing := postJSON(t, "/api/v1/meshes/no-such-mesh/diff", mesh)
+		missing.Body.Close()
+		if missing.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a missing base, got %d", missing.StatusCode)
+		}
+	})
+
+	t.Run("ChunkedUpload", func(t *testing.T) {
+		vertices, faces := triangleBuffers(31)
+		body, err := json.Marshal(api.Mesh{ID: "uploaded-mesh-1", AnchorID: anchorID, Vertices: vertices, Faces: faces, Timestamp: time.Now().UnixMilli()})
+		if err != nil {
+			t.Fatalf("Failed to marshal mesh: %v", err)
+		}
+		sum := sha256.Sum256(body)
+
+		resp := postJSON(t, "/api/v1/meshes/upload/init", api.MeshUploadRequest{SessionID: sessionID, Size: int64(len(body)), SHA256: hex.EncodeToString(sum[:])})
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+		}
+		var upload api.MeshUploadStatus
+		if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		// Send the chunks last first, repeating one
+		chunkSize := len(body)/3 + 1
+		var chunks [][]byte
+		for start := 0; start < len(body); start += chunkSize {
+			chunks = append(chunks, body[start:min(start+chunkSize, len(body))])
+		}
+		for _, n := range []int{2, 1, 1, 0} {
+			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/v1/meshes/upload/%s/chunk/%d", testServerURL, upload.UploadID, n), bytes.NewReader(chunks[n]))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			chunkResp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("PUT request failed: %v", err)
+			}
+			chunkResp.Body.Close()
+			if chunkResp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected chunk %d stored, got %d", n, chunkResp.StatusCode)
+			}
+		}
+
+		complete := postJSON(t, "/api/v1/meshes/upload/"+upload.UploadID+"/complete", nil)
+		defer complete.Body.Close()
+		if complete.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", complete.StatusCode)
+		}
+		var result api.MeshUploadResponse
+		if err := json.NewDecoder(complete.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result.MeshID != "uploaded-mesh-1" || result.Size != int64(len(body)) || result.SessionID != sessionID {
+			t.Errorf("Unexpected upload result: %+v", result)
+		}
+		if mesh, status := getMesh(t, result.MeshID); status != http.StatusOK || mesh.AnchorID != anchorID {
+			t.Errorf("Expected the uploaded mesh stored, got status %d", status)
+		}
+
+		// A completed upload is gone
+		again := postJSON(t, "/api/v1/meshes/upload/"+upload.UploadID+"/complete", nil)
+		again.Body.Close()
+		if again.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a completed upload, got %d", again.StatusCode)
+		}
+	})
+
+	// Test 6: 3D radius query excludes vertically stacked anchors
+	t.Run("RadiusQuery3D", func(t *testing.T) {
+		now := time.Now().UnixMilli()
+		event := api.SpatialEvent{
+			SessionID: sessionID,
+			EventID:   "event-radius-3d",
+			Timestamp: now,
+			Anchors: []api.Anchor{
+				{ID: "radius-ref", SessionID: sessionID, Pose: api.Pose{X: 0, Y: 0, Z: 0, Rotation: []float64{0, 0, 0, 1}}, Timestamp: now},
+				{ID: "radius-near", SessionID: sessionID, Pose: api.Pose{X: 1, Y: 0, Z: 0, Rotation: []float64{0, 0, 0, 1}}, Timestamp: now},
+				{ID: "radius-above", SessionID: sessionID, Pose: api.Pose{X: 0, Y: 0, Z: 5, Rotation: []float64{0, 0, 0, 1}}, Timestamp: now},
+			},
+		}
+
+		resp := postJSON(t, "/api/v1/ingest", event)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		queryResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&anchor_id=radius-ref&radius=2", testServerURL, sessionID))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		defer queryResp.Body.Close()
+
+		var result api.QueryResponse
+		if err := json.NewDecoder(queryResp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		found := map[string]bool{}
+		for _, anchor := range result.Anchors {
+			found[anchor.ID] = true
+		}
+		if !found["radius-ref"] || !found["radius-near"] {
+			t.Errorf("Expected nearby anchors in result, got %v", found)
+		}
+		if found["radius-above"] {
+			t.Error("Expected vertically stacked anchor to be excluded")
+		}
+	})
+
+	// Test 7: Bounding box query is inclusive on its faces
+	t.Run("BoundingBoxQuery", func(t *testing.T) {
+		boxSession := sessionID + "-box"
+		now := time.Now().UnixMilli()
+		rotation := []float64{0, 0, 0, 1}
+		event := api.SpatialEvent{
+			SessionID: boxSession,
+			EventID:   "event-bbox",
+			Timestamp: now,
+			Anchors: []api.Anchor{
+				{ID: "box-inside", SessionID: boxSession, Pose: api.Pose{X: 0.5, Y: 0.5, Z: 0.5, Rotation: rotation}, Timestamp: now},
+				{ID: "box-min-corner", SessionID: boxSession, Pose: api.Pose{X: 0, Y: 0, Z: 0, Rotation: rotation}, Timestamp: now},
+				{ID: "box-max-face", SessionID: boxSession, Pose: api.Pose{X: 1, Y: 0.5, Z: 1, Rotation: rotation}, Timestamp: now},
+				{ID: "box-outside-x", SessionID: boxSession, Pose: api.Pose{X: 1.001, Y: 0.5, Z: 0.5, Rotation: rotation}, Timestamp: now},
+				{ID: "box-outside-z", SessionID: boxSession, Pose: api.Pose{X: 0.5, Y: 0.5, Z: -0.001, Rotation: rotation}, Timestamp: now},
+			},
+		}
+
+		resp := postJSON(t, "/api/v1/ingest", event)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		box := "min_x=0&min_y=0&min_z=0&max_x=1&max_y=1&max_z=1"
+		queryResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&%s", testServerURL, boxSession, box))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		defer queryResp.Body.Close()
+
+		var result api.QueryResponse
+		if err := json.NewDecoder(queryResp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		found := map[string]bool{}
+		for _, anchor := range result.Anchors {
+			found[anchor.ID] = true
+		}
+		for _, id := range []string{"box-inside", "box-min-corner", "box-max-face"} {
+			if !found[id] {
+				t.Errorf("Expected %s inside the box, got %v", id, found)
+			}
+		}
+		for _, id := range []string{"box-outside-x", "box-outside-z"} {
+			if found[id] {
+				t.Errorf("Expected %s outside the box to be excluded", id)
+			}
+		}
+
+		// A count covers the same anchors as the full query
+		countResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&%s&count_only=true", testServerURL, boxSession, box))
+		if err != nil {
+			t.Fatalf("Count query failed: %v", err)
+		}
+		defer countResp.Body.Close()
+
+		var counted api.QueryResponse
+		if err := json.NewDecoder(countResp.Body).Decode(&counted); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if counted.Count != len(result.Anchors) || len(counted.Anchors) != 0 || counted.Anchors == nil {
+			t.Errorf("Expected a count of %d without anchors, got %d with %d anchors", len(result.Anchors), counted.Count, len(counted.Anchors))
+		}
+
+		// Box and radius filters cannot be combined
+		badResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&anchor_id=box-inside&radius=1&%s", testServerURL, boxSession, box))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		badResp.Body.Close()
+		if badResp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for box plus radius, got %d", badResp.StatusCode)
+		}
+	})
+
+	// Metadata filters select anchors by key/value
+	t.Run("MetadataQuery", func(t *testing.T) {
+		metaSession := sessionID + "-meta"
+		now := time.Now().UnixMilli()
+		rotation := []float64{0, 0, 0, 1}
+		event := api.SpatialEvent{
+			SessionID: metaSession,
+			EventID:   "event-meta",
+			Timestamp: now,
+			Anchors: []api.Anchor{
+				{ID: "meta-kitchen-1", SessionID: metaSession, Pose: api.Pose{Rotation: rotation}, Timestamp: now,
+					Metadata: map[string]interface{}{"room": "kitchen", "floor": 1}},
+				{ID: "meta-kitchen-2", SessionID: metaSession, Pose: api.Pose{Rotation: rotation}, Timestamp: now,
+					Metadata: map[string]interface{}{"room": "kitchen", "floor": 2}},
+				{ID: "meta-hall", SessionID: metaSession, Pose: api.Pose{Rotation: rotation}, Timestamp: now,
+					Metadata: map[string]interface{}{"room": "hall", "floor": 1}},
+			},
+		}
+
+		resp := postJSON(t, "/api/v1/ingest", event)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		query := func(filters string) []string {
+			queryResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&%s", testServerURL, metaSession, filters))
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			defer queryResp.Body.Close()
+			if queryResp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", queryResp.StatusCode)
+			}
+
+			var result api.QueryResponse
+			if err := json.NewDecoder(queryResp.Body).Decode(&result); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			ids := make([]string, 0, len(result.Anchors))
+			for _, anchor := range result.Anchors {
+				ids = append(ids, anchor.ID)
+			}
+			sort.Strings(ids)
+			return ids
+		}
+
+		if ids := query("meta.room=kitchen"); len(ids) != 2 || ids[0] != "meta-kitchen-1" || ids[1] != "meta-kitchen-2" {
+			t.Errorf("Expected the kitchen anchors, got %v", ids)
+		}
+		// Numbers match their spelling, and filters are combined
+		if ids := query("meta.room=kitchen&meta.floor=2"); len(ids) != 1 || ids[0] != "meta-kitchen-2" {
+			t.Errorf("Expected meta-kitchen-2, got %v", ids)
+		}
+		if ids := query("meta.room=garage"); len(ids) != 0 {
+			t.Errorf("Expected no anchors, got %v", ids)
+		}
+
+		badResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&meta.=kitchen", testServerURL, metaSession))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		badResp.Body.Close()
+		if badResp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an empty metadata key, got %d", badResp.StatusCode)
+		}
+	})
+
+	// Anchors without a mesh are told apart from those with their own or a shared one
+	t.Run("HasMeshQuery", func(t *testing.T) {
+		meshSession := sessionID + "-hasmesh"
+		vertices, faces := triangleBuffers(20)
+		rotation := []float64{0, 0, 0, 1}
+		anchor := func(id string) api.Anchor {
+			return api.Anchor{ID: id, SessionID: meshSession, Pose: api.Pose{Rotation: rotation}, Timestamp: time.Now().UnixMilli()}
+		}
+
+		for i, event := range []api.SpatialEvent{
+			{Anchors: []api.Anchor{anchor("with-mesh"), anchor("without-mesh")},
+				Meshes: []api.Mesh{{ID: "hasmesh-own", AnchorID: "with-mesh", Vertices: vertices, Faces: faces}}},
+			// The same geometry is deduplicated against the first mesh
+			{Anchors: []api.Anchor{anchor("shared-mesh")},
+				Meshes: []api.Mesh{{ID: "hasmesh-shared", AnchorID: "shared-mesh", Vertices: vertices, Faces: faces}}},
+		} {
+			event.SessionID = meshSession
+			event.EventID = fmt.Sprintf("event-hasmesh-%d", i)
+			event.Timestamp = time.Now().UnixMilli()
+			resp := postJSON(t, "/api/v1/ingest", event)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+			}
+		}
+
+		query := func(hasMesh string) []string {
+			queryResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&has_mesh=%s", testServerURL, meshSession, hasMesh))
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			defer queryResp.Body.Close()
+			if queryResp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", queryResp.StatusCode)
+			}
+
+			var result api.QueryResponse
+			if err := json.NewDecoder(queryResp.Body).Decode(&result); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			ids := make([]string, 0, len(result.Anchors))
+			for _, anchor := range result.Anchors {
+				ids = append(ids, anchor.ID)
+			}
+			sort.Strings(ids)
+			return ids
+		}
+
+		if ids := query("false"); len(ids) != 1 || ids[0] != "without-mesh" {
+			t.Errorf("Expected only without-mesh, got %v", ids)
+		}
+		if ids := query("true"); len(ids) != 2 || ids[0] != "shared-mesh" || ids[1] != "with-mesh" {
+			t.Errorf("Expected shared-mesh and with-mesh, got %v", ids)
+		}
+		if ids := query("any"); len(ids) != 3 {
+			t.Errorf("Expected all 3 anchors, got %v", ids)
+		}
+
+		badResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&has_mesh=maybe", testServerURL, meshSession))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		badResp.Body.Close()
+		if badResp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown has_mesh, got %d", badResp.StatusCode)
+		}
+	})
+
+	// Polygon query selects anchors inside an L-shaped room outline
+	t.Run("SessionClusters", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/sessions/%s/clusters?grid=0.5", testServerURL, sessionID))
+		if err != nil {
+			t.Fatalf("Cluster request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		var result api.ClusterResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result.Grid != 0.5 || result.Count == 0 || result.TotalClusters != result.Count {
+			t.Errorf("Expected the session's anchors clustered, got %+v", result)
+		}
+		counted := 0
+		for _, cluster := range result.Clusters {
+			counted += cluster.Count
+		}
+		if counted != result.Anchors {
+			t.Errorf("Expected cluster counts to sum to %d anchors, got %d", result.Anchors, counted)
+		}
+
+		invalid, err := http.Get(fmt.Sprintf("%s/api/v1/sessions/%s/clusters?grid=-1", testServerURL, sessionID))
+		if err != nil {
+			t.Fatalf("Cluster request failed: %v", err)
+		}
+		invalid.Body.Close()
+		if invalid.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for a negative grid, got %d", invalid.StatusCode)
+		}
+	})
+
+	t.Run("PolygonQuery", func(t *testing.T) {
+		roomSession := sessionID + "-room"
+		now := time.Now().UnixMilli()
+		rotation := []float64{0, 0, 0, 1}
+		event := api.SpatialEvent{
+			SessionID: roomSession,
+			EventID:   "event-room",
+			Timestamp: now,
+			Anchors: []api.Anchor{
+				{ID: "room-inside", SessionID: roomSession, Pose: api.Pose{X: 1, Y: 1, Z: 2, Rotation: rotation}, Timestamp: now},
+				{ID: "room-wing", SessionID: roomSession, Pose: api.Pose{X: 1, Y: 3, Z: 0, Rotation: rotation}, Timestamp: now},
+				{ID: "room-notch", SessionID: roomSession, Pose: api.Pose{X: 3, Y: 3, Z: 0, Rotation: rotation}, Timestamp: now},
+				{ID: "room-far", SessionID: roomSession, Pose: api.Pose{X: 150, Y: 120, Z: 0, Rotation: rotation}, Timestamp: now},
+			},
+		}
+
+		resp := postJSON(t, "/api/v1/ingest", event)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		polygon := url.QueryEscape("0,0;4,0;4,2;2,2;2,4;0,4")
+		queryResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&polygon=%s", testServerURL, roomSession, polygon))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		defer queryResp.Body.Close()
+		if queryResp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", queryResp.StatusCode)
+		}
+
+		var result api.QueryResponse
+		if err := json.NewDecoder(queryResp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		found := map[string]bool{}
+		for _, anchor := range result.Anchors {
+			found[anchor.ID] = true
+		}
+		if !found["room-inside"] || !found["room-wing"] {
+			t.Errorf("Expected anchors inside the room, got %v", found)
+		}
+		if found["room-notch"] || found["room-far"] {
+			t.Errorf("Expected anchors outside the room to be excluded, got %v", found)
+		}
+
+		// Fewer than three vertices is rejected
+		badResp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&polygon=%s", testServerURL, roomSession, url.QueryEscape("0,0;1,1")))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		badResp.Body.Close()
+		if badResp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a degenerate polygon, got %d", badResp.StatusCode)
+		}
+	})
+
+	// Test 8: Nearby anchors are linked in the topology graph
+	t.Run("TopologyNeighbors", func(t *testing.T) {
+		topoSession := sessionID + "-topo"
+		now := time.Now().UnixMilli()
+		rotation := []float64{0, 0, 0, 1}
+		event := api.SpatialEvent{
+			SessionID: topoSession,
+			EventID:   "event-topo",
+			Timestamp: now,
+			Anchors: []api.Anchor{
+				{ID: "topo-a", SessionID: topoSession, Pose: api.Pose{X: 0, Y: 0, Z: 0, Rotation: rotation}, Timestamp: now},
+				{ID: "topo-b", SessionID: topoSession, Pose: api.Pose{X: 1, Y: 0, Z: 0, Rotation: rotation}, Timestamp: now},
+				{ID: "topo-c", SessionID: topoSession, Pose: api.Pose{X: 2.5, Y: 0, Z: 0, Rotation: rotation}, Timestamp: now},
+				{ID: "topo-far", SessionID: topoSession, Pose: api.Pose{X: 50, Y: 0, Z: 0, Rotation: rotation}, Timestamp: now},
+			},
+		}
+
+		// Ingesting twice must not duplicate edges
+		for i := 0; i < 2; i++ {
+			resp := postJSON(t, "/api/v1/ingest", event)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+			}
+		}
+
+		getNeighbors := func(depth int) map[string]int {
+			resp, err := http.Get(fmt.Sprintf("%s/api/v1/anchors/topo-a/neighbors?depth=%d", testServerURL, depth))
+			if err != nil {
+				t.Fatalf("Neighbors request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var result api.NeighborsResponse
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			hops := map[string]int{}
+			for _, neighbor := range result.Neighbors {
+				if _, seen := hops[neighbor.Anchor.ID]; seen {
+					t.Errorf("Neighbor %s returned twice", neighbor.Anchor.ID)
+				}
+				hops[neighbor.Anchor.ID] = neighbor.Hops
+			}
+			return hops
+		}
+
+		direct := getNeighbors(1)
+		if len(direct) != 1 || direct["topo-b"] != 1 {
+			t.Errorf("Expected only topo-b as a direct neighbor, got %v", direct)
+		}
+
+		twoHops := getNeighbors(2)
+		if twoHops["topo-c"] != 2 {
+			t.Errorf("Expected topo-c two hops away, got %v", twoHops)
+		}
+		if _, ok := twoHops["topo-far"]; ok {
+			t.Error("Expected distant anchor to be unlinked")
+		}
+	})
+
+	t.Run("TopologyPath", func(t *testing.T) {
+		topoSession := sessionID + "-topo"
+		getPath := func(from, to string) (int, api.PathResponse) {
+			resp, err := http.Get(fmt.Sprintf("%s/api/v1/anchors/%s/path/%s?session_id=%s", testServerURL, from, to, topoSession))
+			if err != nil {
+				t.Fatalf("Path request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var result api.PathResponse
+			if resp.StatusCode == http.StatusOK {
+				if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+			}
+			return resp.StatusCode, result
+		}
+
+		status, path := getPath("topo-a", "topo-c")
+		if status != http.StatusOK || !path.Connected || path.Hops != 2 || len(path.Anchors) != 3 {
+			t.Fatalf("Expected a 2 hop path, got %d %+v", status, path)
+		}
+		if path.Anchors[0].ID != "topo-a" || path.Anchors[1].ID != "topo-b" || path.Anchors[2].ID != "topo-c" {
+			t.Errorf("Expected topo-a, topo-b, topo-c, got %+v", path.Anchors)
+		}
+		if math.Abs(path.Distance-2.5) > 1e-6 {
+			t.Errorf("Expected a 2.5m path, got %v", path.Distance)
+		}
+
+		status, path = getPath("topo-a", "topo-far")
+		if status != http.StatusOK || path.Connected || len(path.Anchors) != 0 {
+			t.Errorf("Expected an empty path to an unlinked anchor, got %d %+v", status, path)
+		}
+
+		if status, _ := getPath("topo-a", "topo-missing"); status != http.StatusNotFound {
+			t.Errorf("Expected 404 for a missing target, got %d", status)
+		}
+		if status, _ := getPath("topo-missing", "topo-a"); status != http.StatusNotFound {
+			t.Errorf("Expected 404 for a missing source, got %d", status)
+		}
+	})
+
+	t.Run("UpdateAnchor", func(t *testing.T) {
+		update := api.AnchorUpdate{
+			ID:       anchorID,
+			Pose:     api.PoseData{X: 4, Y: 5, Z: 6, Rotation: []float64{0, 0, 0, 1}},
+			Metadata: map[string]interface{}{"label": "moved"},
+		}
+
+		resp := putJSON(t, "/api/v1/anchors/"+anchorID, update)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		var anchor api.Anchor
+		if err := json.NewDecoder(resp.Body).Decode(&anchor); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if anchor.Pose.X != 4 || anchor.SessionID != sessionID || anchor.Metadata["label"] != "moved" {
+			t.Errorf("Unexpected updated anchor: %+v", anchor)
+		}
+
+		mismatch := putJSON(t, "/api/v1/anchors/other-anchor", update)
+		mismatch.Body.Close()
+		if mismatch.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for mismatched ID, got %d", mismatch.StatusCode)
+		}
+
+		update.ID = "missing-anchor"
+		missing := putJSON(t, "/api/v1/anchors/missing-anchor", update)
+		missing.Body.Close()
+		if missing.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status 404 for unknown anchor, got %d", missing.StatusCode)
+		}
+	})
+
+	t.Run("ImportMesh", func(t *testing.T) {
+		obj := "v 0 0 0\nv 2 0 0\nv 2 2 0\nv 0 2 0\nf 1 2 3 4\n"
+		fields := map[string]string{"session_id": sessionID, "anchor_id": "imported-anchor"}
+
+		resp := postFile(t, "/api/v1/import", fields, "room.obj", []byte(obj))
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+		}
+
+		var result api.ImportResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result.MeshID == "" || result.VertexCount != 4 || result.TriangleCount != 2 || !result.AnchorCreated {
+			t.Errorf("Unexpected import result: %+v", result)
+		}
+
+		// The same geometry again dedups against the first import
+		again := postFile(t, "/api/v1/import", fields, "room.obj", []byte(obj))
+		defer again.Body.Close()
+		var repeat api.ImportResponse
+		if err := json.NewDecoder(again.Body).Decode(&repeat); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !repeat.Deduplicated || repeat.MeshID != result.MeshID {
+			t.Errorf("Expected duplicate import to reuse mesh %s, got %+v", result.MeshID, repeat)
+		}
+
+		malformed := postFile(t, "/api/v1/import", fields, "broken.obj", []byte("v 0 0\nf 1 2 3\n"))
+		malformed.Body.Close()
+		if malformed.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for malformed file, got %d", malformed.StatusCode)
+		}
+	})
+
+	t.Run("MeshLOD", func(t *testing.T) {
+		// A 20x20 vertex grid of 722 triangles
+		var obj strings.Builder
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 20; x++ {
+				fmt.Fprintf(&obj, "v %d %d 0\n", x, y)
+			}
+		}
+		for y := 0; y < 19; y++ {
+			for x := 0; x < 19; x++ {
+				i := y*20 + x + 1
+				fmt.Fprintf(&obj, "f %d %d %d %d\n", i, i+1, i+21, i+20)
+			}
+		}
+
+		lodSession := sessionID + "-lod"
+		fields := map[string]string{"session_id": lodSession, "anchor_id": "lod-anchor"}
+		resp := postFile(t, "/api/v1/import", fields, "grid.obj", []byte(obj.String()))
+		defer resp.Body.Close()
+		var imported api.ImportResponse
+		if err := json.NewDecoder(resp.Body).Decode(&imported); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		createLOD := func(meshID, ratio string) (*http.Response, api.LODResponse) {
+			resp, err := http.Post(fmt.Sprintf("%s/api/v1/meshes/%s/lod?ratio=%s", testServerURL, meshID, ratio), "", nil)
+			if err != nil {
+				t.Fatalf("POST request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			var result api.LODResponse
+			json.NewDecoder(resp.Body).Decode(&result)
+			return resp, result
+		}
+
+		lodResp, lod := createLOD(imported.MeshID, "0.25")
+		if lodResp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", lodResp.StatusCode)
+		}
+		if lod.LODOf != imported.MeshID || lod.SourceTriangleCount != 722 || lod.TriangleCount == 0 || lod.TriangleCount > 180 {
+			t.Errorf("Unexpected level of detail: %+v", lod)
+		}
+
+		// The same level is not stored twice
+		againResp, again := createLOD(imported.MeshID, "0.25")
+		if againResp.StatusCode != http.StatusOK || again.MeshID != lod.MeshID || again.Created {
+			t.Errorf("Expected existing level %s, got %d %+v", lod.MeshID, againResp.StatusCode, again)
+		}
+
+		if resp, _ := createLOD(imported.MeshID, "1"); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for ratio 1, got %d", resp.StatusCode)
+		}
+		if resp, _ := createLOD("missing-mesh", "0.5"); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status 404 for unknown mesh, got %d", resp.StatusCode)
+		}
+
+		queryMeshes := func(extra string) []api.Mesh {
+			resp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&include_meshes=true%s", testServerURL, lodSession, extra))
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			defer resp.Body.Close()
+			var result api.QueryResponse
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			return result.Meshes
+		}
+
+		if meshes := queryMeshes(""); len(meshes) != 1 || meshes[0].ID != imported.MeshID {
+			t.Errorf("Expected only the full mesh by default, got %d meshes", len(meshes))
+		}
+		if meshes := queryMeshes("&max_triangles=200"); len(meshes) != 1 || meshes[0].ID != lod.MeshID {
+			t.Errorf("Expected level %s within 200 triangles, got %d meshes", lod.MeshID, len(meshes))
+		}
+		if meshes := queryMeshes("&max_triangles=1000"); len(meshes) != 1 || meshes[0].ID != imported.MeshID {
+			t.Errorf("Expected the full mesh within 1000 triangles, got %d meshes", len(meshes))
+		}
+	})
+
+	t.Run("PoseInterpolation", func(t *testing.T) {
+		trajectoryID := "trajectory-anchor"
+		for i, x := range []float64{0, 10} {
+			event := api.SpatialEvent{
+				SessionID: sessionID,
+				EventID:   fmt.Sprintf("event-trajectory-%d", i),
+				Timestamp: int64(1000 * (i + 1)),
+				Anchors: []api.Anchor{{
+					ID:        trajectoryID,
+					SessionID: sessionID,
+					Pose:      api.Pose{X: x, Rotation: []float64{0, 0, 0, 1}},
+					Timestamp: int64(1000 * (i + 1)),
+				}},
+			}
+			resp := postJSON(t, "/api/v1/ingest", event)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Ingest failed: %d", resp.StatusCode)
+			}
+		}
+
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/anchors/%s/pose?at=1500", testServerURL, trajectoryID))
+		if err != nil {
+			t.Fatalf("Failed to get pose: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var pose api.PoseAtTime
+		if err := json.NewDecoder(resp.Body).Decode(&pose); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if pose.Pose.X != 5 || pose.PrevSample != 1000 || pose.NextSample != 2000 {
+			t.Errorf("Unexpected interpolated pose: %+v", pose)
+		}
+
+		historyResp, err := http.Get(fmt.Sprintf("%s/api/v1/anchors/%s?history=true", testServerURL, trajectoryID))
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		defer historyResp.Body.Close()
+
+		var history api.QueryResponse
+		if err := json.NewDecoder(historyResp.Body).Decode(&history); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(history.Anchors) != 2 || history.Anchors[0].Timestamp != 2000 || history.Anchors[1].Pose.X != 0 {
+			t.Errorf("Expected both samples newest first, got %+v", history.Anchors)
+		}
+
+		outside, err := http.Get(fmt.Sprintf("%s/api/v1/anchors/%s/pose?at=5000", testServerURL, trajectoryID))
+		if err != nil {
+			t.Fatalf("Failed to get pose: %v", err)
+		}
+		outside.Body.Close()
+		if outside.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status 404 outside the history, got %d", outside.StatusCode)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		replaySession := sessionID + "-replay"
+		vertices, faces := triangleBuffers(4)
+		for i, x := range []float64{0, 10} {
+			event := api.SpatialEvent{
+				SessionID: replaySession,
+				EventID:   fmt.Sprintf("event-replay-%d", i),
+				Timestamp: int64(1000 * (i + 1)),
+				Anchors: []api.Anchor{{
+					ID:        "replay-anchor",
+					SessionID: replaySession,
+					Pose:      api.Pose{X: x, Rotation: []float64{0, 0, 0, 1}},
+					Timestamp: int64(1000 * (i + 1)),
+					Metadata:  map[string]interface{}{"room": "lab"},
+				}},
+			}
+			if i == 1 {
+				event.Meshes = []api.Mesh{{
+					ID:        "replay-mesh",
+					AnchorID:  "replay-anchor",
+					Vertices:  vertices,
+					Faces:     faces,
+					Timestamp: 2000,
+				}}
+			}
+			resp := postJSON(t, "/api/v1/ingest", event)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Ingest failed: %d", resp.StatusCode)
+			}
+		}
+
+		replay := func(query string) []string {
+			resp, err := http.Get(fmt.Sprintf("%s/api/v1/sessions/%s/replay?%s", testServerURL, replaySession, query))
+			if err != nil {
+				t.Fatalf("Failed to replay session: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+				t.Fatalf("Expected status 200 with NDJSON, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+			}
+			body, _ := io.ReadAll(resp.Body)
+			return strings.Split(strings.TrimSpace(string(body)), "\n")
+		}
+
+		lines := replay("speed=2")
+		if len(lines) != 2 {
+			t.Fatalf("Expected 2 events, got %d", len(lines))
+		}
+		var second api.ReplayEvent
+		if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		if second.Timestamp != 2000 || second.ReplayOffsetMs != 500 || len(second.Anchors) != 1 || len(second.Meshes) != 1 {
+			t.Errorf("Unexpected second event: %+v", second)
+		}
+		if second.Anchors[0].Pose.X != 10 || second.Anchors[0].Metadata["room"] != "lab" {
+			t.Errorf("Expected the second pose with the anchor's metadata, got %+v", second.Anchors[0])
+		}
+
+		if windowed := replay("from=1500"); len(windowed) != 1 {
+			t.Errorf("Expected 1 event from 1500, got %d", len(windowed))
+		}
+
+		// Posting the events back reproduces the session
+		deleteSession(t, replaySession, false)
+		for _, line := range lines {
+			resp, err := http.Post(testServerURL+"/api/v1/ingest", "application/json", strings.NewReader(line))
+			if err != nil {
+				t.Fatalf("POST request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Re-ingest failed: %d", resp.StatusCode)
+			}
+		}
+		again := replay("speed=2")
+		var replayed api.ReplayEvent
+		if len(again) != 2 || json.Unmarshal([]byte(again[1]), &replayed) != nil ||
+			len(replayed.Anchors) != 1 || len(replayed.Meshes) != 1 || replayed.Meshes[0].ID != "replay-mesh" {
+			t.Errorf("Expected the re-ingested session to replay alike, got %v", again)
+		}
+	})
+
+	t.Run("AnchorIDAcrossSessions", func(t *testing.T) {
+		sharedID := "shared-anchor"
+		sessions := []string{sessionID + "-left", sessionID + "-right"}
+		for i, session := range sessions {
+			vertices, faces := triangleBuffers(float32(i + 5))
+			event := api.SpatialEvent{
+				SessionID: session,
+				EventID:   "event-shared-" + session,
+				Timestamp: time.Now().UnixMilli(),
+				Anchors: []api.Anchor{{
+					ID:        sharedID,
+					SessionID: session,
+					Pose:      api.Pose{X: float64(i), Rotation: []float64{0, 0, 0, 1}},
+					Timestamp: time.Now().UnixMilli(),
+				}},
+				Meshes: []api.Mesh{{
+					ID:        "shared-mesh-" + session,
+					AnchorID:  sharedID,
+					Vertices:  vertices,
+					Faces:     faces,
+					Timestamp: time.Now().UnixMilli(),
+				}},
+			}
+			resp := postJSON(t, "/api/v1/ingest", event)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Ingest failed: %d", resp.StatusCode)
+			}
+		}
+
+		// Each session keeps its own anchor and only its own mesh
+		for i, session := range sessions {
+			resp, err := http.Get(fmt.Sprintf("%s/api/v1/query?session_id=%s&include_meshes=true", testServerURL, session))
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			var result api.QueryResponse
+			err = json.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(result.Anchors) != 1 || result.Anchors[0].Pose.X != float64(i) {
+				t.Errorf("Session %s: expected its own anchor at x=%d, got %+v", session, i, result.Anchors)
+			}
+			if len(result.Meshes) != 1 || result.Meshes[0].ID != "shared-mesh-"+session {
+				t.Errorf("Session %s: expected only its own mesh, got %d meshes", session, len(result.Meshes))
+			}
+		}
+
+		// An ambiguous ID needs the session
+		ambiguous, err := http.Get(fmt.Sprintf("%s/api/v1/anchors/%s", testServerURL, sharedID))
+		if err != nil {
+			t.Fatalf("Failed to get anchor: %v", err)
+		}
+		ambiguous.Body.Close()
+		if ambiguous.StatusCode != http.StatusConflict {
+			t.Errorf("Expected status 409 without session_id, got %d", ambiguous.StatusCode)
+		}
+
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/anchors/%s?session_id=%s", testServerURL, sharedID, sessions[1]))
+		if err != nil {
+			t.Fatalf("Failed to get anchor: %v", err)
+		}
+		defer resp.Body.Close()
+		var anchor api.Anchor
+		if err := json.NewDecoder(resp.Body).Decode(&anchor); err != nil {
+			t.Fatalf("Failed to decode anchor: %v", err)
+		}
+		if anchor.SessionID != sessions[1] || anchor.Pose.X != 1 {
+			t.Errorf("Expected the anchor of %s, got %+v", sessions[1], anchor)
+		}
+
+		for _, session := range sessions {
+			deleteSession(t, session, false)
+		}
+	})
+
+	t.Run("DeadLetters", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/deadletter?session_id=%s", testServerURL, sessionID))
+		if err != nil {
+			t.Fatalf("Failed to list dead letters: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var list api.DeadLetterList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || list.DeadLetters == nil || list.Count != len(list.DeadLetters) {
+			t.Fatalf("Expected status 200 with a dead-letter list, got %d: %+v", resp.StatusCode, list)
+		}
+	})
+
+	t.Run("StorageStats", func(t *testing.T) {
+		resp, err := http.Get(testServerURL + "/api/v1/stats/storage?limit=1000")
+		if err != nil {
+			t.Fatalf("Failed to get storage stats: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var stats api.StorageStats
+		if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+			t.Fatalf("Failed to decode storage stats: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || len(stats.Collections) != 4 {
+			t.Fatalf("Expected 4 collections, got %d %+v", resp.StatusCode, stats.Collections)
+		}
+
+		found := false
+		for _, session := range stats.Sessions {
+			if session.SessionID == sessionID {
+				found = session.Anchors > 0 && session.Meshes > 0 && session.GeometryBytes > 0 && session.EstimatedDisk > 0
+			}
+		}
+		if !found {
+			t.Errorf("Expected storage for session %s", sessionID)
+		}
+	})
+
+	t.Run("DedupAdmin", func(t *testing.T) {
+		rebuildResp := postJSON(t, "/api/v1/admin/dedup/rebuild", nil)
+		defer rebuildResp.Body.Close()
+
+		var rebuilt api.DedupRebuildResponse
+		if err := json.NewDecoder(rebuildResp.Body).Decode(&rebuilt); err != nil {
+			t.Fatalf("Failed to decode rebuild: %v", err)
+		}
+		if rebuildResp.StatusCode != http.StatusOK || rebuilt.Entries == 0 {
+			t.Fatalf("Expected stored hashes loaded, got %d %+v", rebuildResp.StatusCode, rebuilt)
+		}
+
+		resp, err := http.Get(testServerURL + "/api/v1/admin/dedup?limit=5")
+		if err != nil {
+			t.Fatalf("Failed to get dedup stats: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var stats api.DedupStats
+		if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+			t.Fatalf("Failed to decode dedup stats: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || stats.Entries != rebuilt.Entries || len(stats.TopHashes) == 0 || len(stats.TopHashes) > 5 {
+			t.Fatalf("Unexpected dedup stats %d %+v", resp.StatusCode, stats)
+		}
+		for i, hash := range stats.TopHashes {
+			if !hash.Cached || hash.RefCount < 1 || (i > 0 && hash.RefCount > stats.TopHashes[i-1].RefCount) {
+				t.Errorf("Expected cached hashes by descending references, got %+v", stats.TopHashes)
+				break
+			}
+		}
+		// Earlier subtests ingested the same geometry twice
+		if stats.Hits == 0 {
+			t.Errorf("Expected dedup cache hits, got %+v", stats)
+		}
+	})
+
+	t.Run("DeleteSession", func(t *testing.T) {
+		purgeSession := sessionID + "-purge"
+		obj := "v 0 0 0\nv 3 0 0\nv 0 3 0\nf 1 2 3\n"
+		fields := map[string]string{"session_id": purgeSession, "anchor_id": "purge-anchor"}
+
+		imported := postFile(t, "/api/v1/import", fields, "purge.obj", []byte(obj))
+		imported.Body.Close()
+		if imported.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", imported.StatusCode)
+		}
+
+		dryRun := deleteSession(t, purgeSession, true)
+		if dryRun.Anchors != 1 || dryRun.Meshes != 1 || dryRun.Poses != 1 || !dryRun.DryRun {
+			t.Errorf("Unexpected dry run counts: %+v", dryRun)
+		}
+
+		deleted := deleteSession(t, purgeSession, false)
+		if deleted.Anchors != 1 || deleted.Meshes != 1 || deleted.DryRun {
+			t.Errorf("Unexpected deletion counts: %+v", deleted)
+		}
+
+		if again := deleteSession(t, purgeSession, true); again.Anchors != 0 || again.Meshes != 0 {
+			t.Errorf("Expected nothing left to delete, got %+v", again)
+		}
+
+		// The geometry is stored afresh rather than deduplicated against the deleted mesh
+		reimported := postFile(t, "/api/v1/import", fields, "purge.obj", []byte(obj))
+		defer reimported.Body.Close()
+		var result api.ImportResponse
+		if err := json.NewDecoder(reimported.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result.Deduplicated {
+			t.Errorf("Expected re-imported mesh to be stored again, got %+v", result)
+		}
+	})
+	t.Run("Snapshot", func(t *testing.T) {
+		snapshotSession := sessionID + "-snapshot"
+		obj := "v 0 0 0\nv 5 0 0\nv 0 5 0\nf 1 2 3\n"
+		fields := map[string]string{"session_id": snapshotSession, "anchor_id": "snapshot-anchor"}
+
+		imported := postFile(t, "/api/v1/import", fields, "snapshot.obj", []byte(obj))
+		imported.Body.Close()
+		if imported.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", imported.StatusCode)
+		}
+
+		resp, err := http.Get(testServerURL + "/api/v1/admin/snapshot?compression=zstd&session_id=" + snapshotSession)
+		if err != nil {
+			t.Fatalf("Failed to get snapshot: %v", err)
+		}
+		archive, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "zstd" {
+			t.Fatalf("Expected a zstd snapshot, got %d %q %v", resp.StatusCode, resp.Header.Get("Content-Encoding"), err)
+		}
+
+		deleteSession(t, snapshotSession, false)
+
+		// Restoring twice upserts the same documents
+		for i := 0; i < 2; i++ {
+			resp, err := http.Post(testServerURL+"/api/v1/admin/snapshot", "application/x-tar", bytes.NewReader(archive))
+			if err != nil {
+				t.Fatalf("Failed to restore snapshot: %v", err)
+			}
+			var restored api.SnapshotRestoreResponse
+			err = json.NewDecoder(resp.Body).Decode(&restored)
+			resp.Body.Close()
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected the snapshot restored, got %d %v", resp.StatusCode, err)
+			}
+			if restored.SessionID != snapshotSession || restored.Documents["anchors"] != 1 || restored.Documents["meshes"] != 1 {
+				t.Errorf("Unexpected restore counts: %+v", restored)
+			}
+		}
+
+		if counts := deleteSession(t, snapshotSession, true); counts.Anchors != 1 || counts.Meshes != 1 {
+			t.Errorf("Expected one copy of the session restored, got %+v", counts)
+		}
+
+		// The restored mesh is back in the dedup cache
+		reimported := postFile(t, "/api/v1/import", fields, "snapshot.obj", []byte(obj))
+		defer reimported.Body.Close()
+		var result api.ImportResponse
+		if err := json.NewDecoder(reimported.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !result.Deduplicated {
+			t.Errorf("Expected the re-imported mesh deduplicated against the restored one, got %+v", result)
+		}
+
+		missing, err := http.Get(testServerURL + "/api/v1/admin/snapshot?session_id=no-such-session")
+		if err != nil {
+			t.Fatalf("Failed to get snapshot: %v", err)
+		}
+		missing.Body.Close()
+		if missing.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown session, got %d", missing.StatusCode)
+		}
+	})
+
+	t.Run("SharedMeshDeletion", func(t *testing.T) {
+		// Deleting either session first leaves the geometry to the other
+		orders := []struct {
+			name       string
+			ownerFirst bool
+			size       int // Distinct geometry per order
+		}{
+			{"OwnerFirst", true, 5},
+			{"ReferenceFirst", false, 6},
+		}
+		for _, tt := range orders {
+			t.Run(tt.name, func(t *testing.T) {
+				owner := sessionID + "-shared-owner-" + tt.name
+				referrer := sessionID + "-shared-ref-" + tt.name
+				obj := fmt.Sprintf("v 0 0 0\nv %d 0 0\nv 0 %d 0\nf 1 2 3\n", tt.size, tt.size)
+
+				stored := importMesh(t, owner, obj)
+				shared := importMesh(t, referrer, obj)
+				if !shared.Deduplicated || shared.MeshID != stored.MeshID {
+					t.Fatalf("Expected %s to share mesh %s, got %+v", referrer, stored.MeshID, shared)
+				}
+				if mesh, status := getMesh(t, stored.MeshID); status != http.StatusOK || mesh.RefCount != 2 || len(mesh.ReferencedBy) != 2 {
+					t.Fatalf("Expected a mesh referenced twice, got status %d and %+v", status, mesh.ReferencedBy)
+				}
+
+				first, second := referrer, owner
+				if tt.ownerFirst {
+					first, second = owner, referrer
+				}
+
+				dryRun := deleteSession(t, first, true)
+				if dryRun.Meshes != 0 || dryRun.SharedMeshes != 1 {
+					t.Errorf("Expected the mesh counted as shared, got %+v", dryRun)
+				}
+				if deleted := deleteSession(t, first, false); deleted.Meshes != 0 || deleted.SharedMeshes != 1 {
+					t.Errorf("Expected the mesh released rather than removed, got %+v", deleted)
+				}
+
+				mesh, status := getMesh(t, stored.MeshID)
+				if status != http.StatusOK {
+					t.Fatalf("Expected the shared mesh kept, got status %d", status)
+				}
+				if mesh.RefCount != 1 || mesh.SessionID != second || len(mesh.ReferencedBy) != 1 || mesh.ReferencedBy[0].SessionID != second {
+					t.Errorf("Expected the mesh left to %s alone, got session %s and references %+v", second, mesh.SessionID, mesh.ReferencedBy)
+				}
+
+				if deleted := deleteSession(t, second, false); deleted.Meshes != 1 || deleted.SharedMeshes != 0 {
+					t.Errorf("Expected the last reference to remove the mesh, got %+v", deleted)
+				}
+				if _, status := getMesh(t, stored.MeshID); status != http.StatusNotFound {
+					t.Errorf("Expected the mesh removed, got status %d", status)
+				}
+			})
+		}
+	})
+}
+
+// Helper functions
+
+func waitForServer(t *testing.T) {
+	maxRetries := 30
+	for i := 0; i < maxRetries; i++ {
+		resp, err := http.Get(testServerURL + "/health")
+		if err == nil && resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			return
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		time.Sleep(1 * time.Second)
+	}
+	t.Fatal("Server failed to start")
+}
+
+// triangleBuffers packs one right triangle with legs of the given size as
+// float32 XYZ vertices and uint32 indices
+func triangleBuffers(size float32) (vertices, faces []byte) {
+	for _, v := range []float32{0, 0, 0, size, 0, 0, 0, size, 0} {
+		vertices = binary.LittleEndian.AppendUint32(vertices, math.Float32bits(v))
+	}
+	for _, index := range []uint32{0, 1, 2} {
+		faces = binary.LittleEndian.AppendUint32(faces, index)
+	}
+	return vertices, faces
+}
+
+func postJSON(t *testing.T, path string, data interface{}) *http.Response {
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("Failed to marshal data: %v", err)
+	}
+
+	resp, err := http.Post(testServerURL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST request failed: %v", err)
+	}
+
+	return resp
+}
+
+func putJSON(t *testing.T, path string, data interface{}) *http.Response {
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("Failed to marshal data: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, testServerURL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT request failed: %v", err)
+	}
+
+	return resp
+}
+
+func postFile(t *testing.T, path string, fields map[string]string, filename string, data []byte) *http.Response {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatalf("Failed to write field: %v", err)
+		}
+	}
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	resp, err := http.Post(testServerURL+path, writer.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("POST request failed: %v", err)
+	}
+
+	return resp
+}
+
+func deleteSession(t *testing.T, sessionID string, dryRun bool) api.SessionDeleteResponse {
+	url := fmt.Sprintf("%s/api/v1/sessions/%s?dry_run=%t", testServerURL, sessionID, dryRun)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var result api.SessionDeleteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return result
+}
+
+// importMesh imports OBJ geometry to a new anchor of sessionID
+func importMesh(t *testing.T, sessionID, obj string) api.ImportResponse {
+	fields := map[string]string{"session_id": sessionID, "anchor_id": sessionID + "-anchor"}
+	resp := postFile(t, "/api/v1/import", fields, "mesh.obj", []byte(obj))
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+
+	var result api.ImportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return result
+}
+
+// getMesh fetches a stored mesh, returning the response status with it
+func getMesh(t *testing.T, meshID string) (api.Mesh, int) {
+	resp, err := http.Get(fmt.Sprintf("%s/api/v1/meshes/%s?raw=true", testServerURL, meshID))
+	if err != nil {
+		t.Fatalf("GET request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var mesh api.Mesh
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&mesh); err != nil {
+			t.Fatalf("Failed to decode mesh: %v", err)
+		}
+	}
+	return mesh, resp.StatusCode
+}